@@ -2,6 +2,9 @@ package distributedtxn
 
 import (
 	"errors"
+	"fmt"
+	"slices"
+	"sort"
 	"sync"
 	"time"
 )
@@ -15,8 +18,27 @@ const (
 	VoteYes Vote = iota
 	VoteNo
 	VoteAbort
+	// VoteReadOnly is the vote of a participant whose operations only
+	// read: it has nothing to commit, releases the transaction at once and
+	// takes no part in phase 2
+	VoteReadOnly
 )
 
+func (v Vote) String() string {
+	switch v {
+	case VoteYes:
+		return "YES"
+	case VoteNo:
+		return "NO"
+	case VoteAbort:
+		return "ABORT"
+	case VoteReadOnly:
+		return "READ-ONLY"
+	default:
+		return fmt.Sprintf("Vote(%d)", int(v))
+	}
+}
+
 // Transaction states
 type TxnState int
 
@@ -27,11 +49,28 @@ const (
 	StateAborted
 )
 
+func (s TxnState) String() string {
+	switch s {
+	case StatePreparing:
+		return "PREPARING"
+	case StatePrepared:
+		return "PREPARED"
+	case StateCommitted:
+		return "COMMITTED"
+	case StateAborted:
+		return "ABORTED"
+	default:
+		return fmt.Sprintf("TxnState(%d)", int(s))
+	}
+}
+
 // Errors
 var (
-	ErrVoteNo     = errors.New("participant voted no")
-	ErrTimeout    = errors.New("operation timeout")
-	ErrTxnAborted = errors.New("transaction aborted")
+	ErrVoteNo             = errors.New("participant voted no")
+	ErrTimeout            = errors.New("operation timeout")
+	ErrTxnAborted         = errors.New("transaction aborted")
+	ErrUnknownTxn         = errors.New("unknown transaction")
+	ErrUnknownParticipant = errors.New("unknown participant")
 )
 
 // Operation represents a transaction operation
@@ -43,19 +82,67 @@ type Operation struct {
 
 // Participant interface
 type Participant interface {
+	// Prepare receives every operation of the transaction for this
+	// participant in one batch, in the order they were executed
 	Prepare(txnID TxnID, operations []Operation) (Vote, error)
 	Commit(txnID TxnID) error
 	Abort(txnID TxnID) error
 }
 
+// OnePhaseCommitter is implemented by participants that can commit a
+// transaction in a single message. When a transaction has operations for
+// only one participant, there is nobody to agree with, so the coordinator
+// hands it the whole decision instead of running 2PC. CommitOnePhase must
+// return an error only if the participant did not commit.
+type OnePhaseCommitter interface {
+	CommitOnePhase(txnID TxnID, operations []Operation) error
+}
+
+// Options configures a TransactionCoordinator
+type Options struct {
+	// PrepareTimeout bounds the prepare phase (default 5s)
+	PrepareTimeout time.Duration
+	// PrepareWorkers is how many participants are sent messages at once
+	// (default 16). 1 contacts participants one after another.
+	PrepareWorkers int
+	// Tracing records the protocol messages and state changes of each
+	// transaction; see Trace and ExportTraces
+	Tracing bool
+	// MaxTraces is how many traces are kept, the oldest being dropped
+	// first (default 1000)
+	MaxTraces int
+}
+
+func (o Options) withDefaults() Options {
+	if o.PrepareTimeout <= 0 {
+		o.PrepareTimeout = 5 * time.Second
+	}
+	if o.PrepareWorkers <= 0 {
+		o.PrepareWorkers = 16
+	}
+	if o.MaxTraces <= 0 {
+		o.MaxTraces = 1000
+	}
+	return o
+}
+
 // TransactionCoordinator manages distributed transactions
 type TransactionCoordinator struct {
 	participants []Participant
 	txnLog       *TxnLog
 	nextTxnID    TxnID
+	opts         Options
+	active       map[TxnID]*activeTxn
+	tracer       *tracer
 	mu           sync.Mutex
 }
 
+// activeTxn buffers the operations of a transaction until it commits
+type activeTxn struct {
+	operations []Operation
+	batches    map[int][]Operation // by participant
+}
+
 // TxnLog stores transaction state for recovery
 type TxnLog struct {
 	entries map[TxnID]*LogEntry
@@ -65,16 +152,25 @@ type TxnLog struct {
 type LogEntry struct {
 	TxnID        TxnID
 	State        TxnState
-	Participants []int
-	Operations   []Operation
+	Participants []int       // participants with operations, ascending
+	Operations   []Operation // all operations in execution order
 }
 
 // NewCoordinator creates a new transaction coordinator
 func NewCoordinator(participants []Participant) *TransactionCoordinator {
+	return NewCoordinatorWithOptions(participants, Options{})
+}
+
+// NewCoordinatorWithOptions creates a coordinator with custom options
+func NewCoordinatorWithOptions(participants []Participant, opts Options) *TransactionCoordinator {
+	opts = opts.withDefaults()
 	return &TransactionCoordinator{
 		participants: participants,
 		txnLog:       NewTxnLog(),
 		nextTxnID:    1,
+		opts:         opts,
+		active:       make(map[TxnID]*activeTxn),
+		tracer:       newTracer(opts),
 	}
 }
 
@@ -85,61 +181,217 @@ func NewTxnLog() *TxnLog {
 	}
 }
 
+// Write records entry, replacing any earlier entry of the transaction
+func (l *TxnLog) Write(entry LogEntry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries[entry.TxnID] = &entry
+}
+
+// Get returns a copy of the transaction's entry
+func (l *TxnLog) Get(txnID TxnID) (LogEntry, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entry, ok := l.entries[txnID]
+	if !ok {
+		return LogEntry{}, false
+	}
+	return *entry, true
+}
+
+// Forget drops a transaction every participant has acknowledged
+func (l *TxnLog) Forget(txnID TxnID) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.entries, txnID)
+}
+
+// Len returns the number of transactions in the log
+func (l *TxnLog) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return len(l.entries)
+}
+
+// Entries returns copies of all entries in transaction order
+func (l *TxnLog) Entries() []LogEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	entries := make([]LogEntry, 0, len(l.entries))
+	for _, entry := range l.entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].TxnID < entries[j].TxnID })
+	return entries
+}
+
 // Begin starts a new distributed transaction
 func (tc *TransactionCoordinator) Begin() (TxnID, error) {
-	// TODO: Assign transaction ID
-	// TODO: Log transaction start
-	return 0, nil
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	txnID := tc.nextTxnID
+	tc.nextTxnID++
+	tc.active[txnID] = &activeTxn{batches: make(map[int][]Operation)}
+	return txnID, nil
 }
 
-// Execute adds an operation to the transaction
+// Execute adds an operation to the transaction. Operations are buffered
+// per participant and shipped in a single Prepare at commit.
 func (tc *TransactionCoordinator) Execute(txnID TxnID, participantID int, op Operation) error {
-	// TODO: Buffer operation for prepare phase
+	if participantID < 0 || participantID >= len(tc.participants) {
+		return fmt.Errorf("%w: %d", ErrUnknownParticipant, participantID)
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	txn, ok := tc.active[txnID]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrUnknownTxn, txnID)
+	}
+	txn.operations = append(txn.operations, op)
+	txn.batches[participantID] = append(txn.batches[participantID], op)
 	return nil
 }
 
-// Commit commits the distributed transaction using 2PC
+// Commit commits the distributed transaction using 2PC. Prepare messages
+// go to all participants concurrently; any NO vote, error or timeout
+// aborts the transaction. Participants voting READ-ONLY drop out after
+// phase 1, and a transaction touching a single OnePhaseCommitter skips 2PC
+// altogether. Once COMMITTED is logged the outcome is final: a participant
+// failing to acknowledge is retried by Recover.
 func (tc *TransactionCoordinator) Commit(txnID TxnID) error {
-	// TODO: Implement 2PC
-	// Phase 1: Prepare
-	//   1. Log PREPARING state
-	//   2. Send PREPARE to all participants
-	//   3. Wait for votes with timeout
-	//   4. If all YES, proceed to Phase 2
-	//   5. If any NO or timeout, abort
-
-	// Phase 2: Commit
-	//   1. Log COMMITTED state (decision point)
-	//   2. Send COMMIT to all participants
-	//   3. Wait for ACKs
-	//   4. Transaction complete
+	entry, batches, err := tc.finish(txnID)
+	if err != nil {
+		return err
+	}
+	if len(entry.Participants) == 1 {
+		id := entry.Participants[0]
+		if p, ok := tc.participants[id].(OnePhaseCommitter); ok {
+			return tc.commitOnePhase(txnID, id, p, batches[id])
+		}
+	}
+
+	// Phase 1: prepare
+	tc.setState(&entry, StatePreparing, nil)
+	start := time.Now()
+	readOnly, err := tc.prepare(txnID, entry.Participants, batches)
+	tc.tracer.observePrepare(time.Now().Sub(start))
+	// Read-only participants have already released the transaction
+	entry.Participants = slices.DeleteFunc(entry.Participants, func(id int) bool { return readOnly[id] })
+	if err != nil {
+		tc.setState(&entry, StateAborted, err)
+		tc.tracer.outcome(false)
+		if tc.broadcast(txnID, MsgAbort, entry.Participants, func(p Participant) error { return p.Abort(txnID) }) == nil {
+			tc.txnLog.Forget(txnID)
+		}
+		return fmt.Errorf("%w: %w", ErrTxnAborted, err)
+	}
+	tc.tracer.outcome(true)
+	if len(entry.Participants) == 0 {
+		tc.traceState(txnID, StateCommitted, nil)
+		tc.txnLog.Forget(txnID)
+		return nil
+	}
+	tc.setState(&entry, StatePrepared, nil)
+
+	// Phase 2: commit
+	tc.setState(&entry, StateCommitted, nil)
+	return tc.complete(entry)
+}
+
+// setState logs entry in state, recording the cause of an abort in the
+// trace
+func (tc *TransactionCoordinator) setState(entry *LogEntry, state TxnState, cause error) {
+	entry.State = state
+	tc.txnLog.Write(*entry)
+	tc.traceState(entry.TxnID, state, cause)
+}
 
+// commitOnePhase lets the only participant of a transaction decide its
+// outcome. No decision is logged: the participant holds it.
+func (tc *TransactionCoordinator) commitOnePhase(txnID TxnID, id int, p OnePhaseCommitter, operations []Operation) error {
+	tc.traceSend(txnID, id, MsgCommitOnePhase)
+	err := p.CommitOnePhase(txnID, operations)
+	tc.traceReceive(txnID, id, MsgAck, nil, err)
+	if err != nil {
+		tc.traceState(txnID, StateAborted, err)
+		tc.tracer.outcome(false)
+		return fmt.Errorf("%w: participant %d: %w", ErrTxnAborted, id, err)
+	}
+	tc.traceState(txnID, StateCommitted, nil)
+	tc.tracer.outcome(true)
 	return nil
 }
 
-// Abort aborts the distributed transaction
+// Abort aborts the distributed transaction. Nothing was sent to the
+// participants before Commit, so none of them is contacted.
 func (tc *TransactionCoordinator) Abort(txnID TxnID) error {
-	// TODO: Implement abort
-	// 1. Log ABORTED state
-	// 2. Send ABORT to all participants
-	// 3. Wait for ACKs
+	entry, _, err := tc.finish(txnID)
+	if err != nil {
+		return err
+	}
+	tc.setState(&entry, StateAborted, nil)
+	tc.txnLog.Forget(txnID)
 	return nil
 }
 
-// Recover recovers in-progress transactions
+// finish removes an active transaction and returns its log entry and
+// batched operations
+func (tc *TransactionCoordinator) finish(txnID TxnID) (LogEntry, map[int][]Operation, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	txn, ok := tc.active[txnID]
+	if !ok {
+		return LogEntry{}, nil, fmt.Errorf("%w: %d", ErrUnknownTxn, txnID)
+	}
+	delete(tc.active, txnID)
+	entry := LogEntry{TxnID: txnID, Operations: txn.operations}
+	for id := range txn.batches {
+		entry.Participants = append(entry.Participants, id)
+	}
+	sort.Ints(entry.Participants)
+	return entry, txn.batches, nil
+}
+
+// Recover recovers in-progress transactions: those that never reached a
+// decision are aborted and committed ones are committed again, since a
+// participant may have missed the COMMIT
 func (tc *TransactionCoordinator) Recover() error {
-	// TODO: Implement recovery
-	// 1. Read transaction log
-	// 2. For each incomplete transaction:
-	//    - If PREPARING: abort
-	//    - If PREPARED or COMMITTED: commit
-	//    - If ABORTED: abort
+	var errs []error
+	for _, entry := range tc.txnLog.Entries() {
+		switch entry.State {
+		case StatePreparing, StateAborted:
+			tc.setState(&entry, StateAborted, nil)
+			txnID := entry.TxnID
+			err := tc.broadcast(txnID, MsgAbort, entry.Participants, func(p Participant) error { return p.Abort(txnID) })
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			tc.txnLog.Forget(txnID)
+		case StatePrepared, StateCommitted:
+			tc.setState(&entry, StateCommitted, nil)
+			if err := tc.complete(entry); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// complete sends COMMIT for a committed entry and forgets it once every
+// participant acknowledged
+func (tc *TransactionCoordinator) complete(entry LogEntry) error {
+	txnID := entry.TxnID
+	if err := tc.broadcast(txnID, MsgCommit, entry.Participants, func(p Participant) error { return p.Commit(txnID) }); err != nil {
+		return fmt.Errorf("txn %d committed, acknowledgement pending: %w", txnID, err)
+	}
+	tc.txnLog.Forget(txnID)
 	return nil
 }
 
 // WaitForGraph for deadlock detection
 type WaitForGraph struct {
-	edges map[TxnID][]TxnID  // who is waiting for whom
+	edges map[TxnID][]TxnID // who is waiting for whom
 	mu    sync.RWMutex
 }
 
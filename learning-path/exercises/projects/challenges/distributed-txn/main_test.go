@@ -1,20 +1,411 @@
 package distributedtxn
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mockParticipant records the 2PC messages it receives
+type mockParticipant struct {
+	mu          sync.Mutex
+	vote        Vote
+	delay       time.Duration // per Prepare
+	block       chan struct{} // if set, Prepare waits for it to close
+	commitFails int           // Commit calls to fail before succeeding
+
+	prepareCalls int
+	commitCalls  int
+	abortCalls   int
+	prepared     map[TxnID][]Operation
+	committed    map[TxnID]bool
+	aborted      map[TxnID]bool
+}
+
+func newMockParticipant() *mockParticipant {
+	return &mockParticipant{
+		prepared:  make(map[TxnID][]Operation),
+		committed: make(map[TxnID]bool),
+		aborted:   make(map[TxnID]bool),
+	}
+}
+
+func (m *mockParticipant) Prepare(txnID TxnID, operations []Operation) (Vote, error) {
+	if m.block != nil {
+		<-m.block
+	}
+	time.Sleep(m.delay)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prepareCalls++
+	m.prepared[txnID] = operations
+	return m.vote, nil
+}
+
+func (m *mockParticipant) Commit(txnID TxnID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commitCalls++
+	if m.commitFails > 0 {
+		m.commitFails--
+		return errors.New("connection reset")
+	}
+	m.committed[txnID] = true
+	return nil
+}
+
+func (m *mockParticipant) Abort(txnID TxnID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.abortCalls++
+	m.aborted[txnID] = true
+	return nil
+}
+
+// messages returns how many 2PC messages the participant received
+func (m *mockParticipant) messages() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prepareCalls + m.commitCalls + m.abortCalls
+}
+
+// onePhaseParticipant also accepts one-phase commits
+type onePhaseParticipant struct {
+	*mockParticipant
+	onePhaseCalls int
+	fail          error
+}
+
+func (m *onePhaseParticipant) CommitOnePhase(txnID TxnID, operations []Operation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onePhaseCalls++
+	if m.fail != nil {
+		return m.fail
+	}
+	m.prepared[txnID] = operations
+	m.committed[txnID] = true
+	return nil
+}
+
+func newCluster(n int, opts Options) (*TransactionCoordinator, []*mockParticipant) {
+	mocks := make([]*mockParticipant, n)
+	participants := make([]Participant, n)
+	for i := range mocks {
+		mocks[i] = newMockParticipant()
+		participants[i] = mocks[i]
+	}
+	return NewCoordinatorWithOptions(participants, opts), mocks
+}
 
 func TestSuccessful2PC(t *testing.T) {
-	// TODO: Test successful distributed commit
-	t.Skip("not implemented")
+	tc, mocks := newCluster(3, Options{})
+	txn, _ := tc.Begin()
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+	tc.Execute(txn, 2, Operation{Type: "put", Key: "b"})
+	if err := tc.Commit(txn); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	if !mocks[0].committed[txn] || !mocks[2].committed[txn] {
+		t.Error("participants with operations not committed")
+	}
+	if mocks[1].prepareCalls != 0 || mocks[1].committed[txn] {
+		t.Error("participant without operations was contacted")
+	}
+	if entries := tc.txnLog.Entries(); len(entries) != 0 {
+		t.Errorf("completed transaction left in log: %+v", entries)
+	}
+	if err := tc.Commit(txn); !errors.Is(err, ErrUnknownTxn) {
+		t.Errorf("second Commit = %v, want ErrUnknownTxn", err)
+	}
+	if err := tc.Execute(txn, 5, Operation{}); !errors.Is(err, ErrUnknownParticipant) {
+		t.Errorf("Execute on participant 5 = %v, want ErrUnknownParticipant", err)
+	}
+}
+
+func TestBatchedPrepare(t *testing.T) {
+	tc, mocks := newCluster(2, Options{})
+	txn, _ := tc.Begin()
+	for i := range 10 {
+		tc.Execute(txn, i%2, Operation{Type: "put", Key: fmt.Sprint(i)})
+	}
+	if err := tc.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	for p, m := range mocks {
+		if m.prepareCalls != 1 {
+			t.Errorf("participant %d got %d prepares, want 1", p, m.prepareCalls)
+		}
+		ops := m.prepared[txn]
+		if len(ops) != 5 {
+			t.Fatalf("participant %d got %d operations, want 5", p, len(ops))
+		}
+		for i, op := range ops {
+			if want := fmt.Sprint(2*i + p); op.Key != want {
+				t.Errorf("participant %d operation %d = %q, want %q", p, i, op.Key, want)
+			}
+		}
+	}
 }
 
 func TestParticipantFailure(t *testing.T) {
-	// TODO: Test participant failure causes abort
-	t.Skip("not implemented")
+	tc, mocks := newCluster(3, Options{})
+	mocks[1].vote = VoteNo
+	txn, _ := tc.Begin()
+	for p := range mocks {
+		tc.Execute(txn, p, Operation{Type: "put", Key: "k"})
+	}
+	err := tc.Commit(txn)
+	if !errors.Is(err, ErrTxnAborted) || !errors.Is(err, ErrVoteNo) {
+		t.Fatalf("Commit = %v, want ErrTxnAborted wrapping ErrVoteNo", err)
+	}
+	for p, m := range mocks {
+		if m.committed[txn] || !m.aborted[txn] {
+			t.Errorf("participant %d: committed=%v aborted=%v", p, m.committed[txn], m.aborted[txn])
+		}
+	}
 }
 
 func TestCoordinatorRecovery(t *testing.T) {
-	// TODO: Test coordinator crash and recovery
-	t.Skip("not implemented")
+	tc, mocks := newCluster(2, Options{})
+	mocks[1].commitFails = 1
+
+	txn, _ := tc.Begin()
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+	tc.Execute(txn, 1, Operation{Type: "put", Key: "b"})
+	if err := tc.Commit(txn); err == nil {
+		t.Fatal("Commit succeeded although participant 1 missed the COMMIT")
+	}
+	if entry, ok := tc.txnLog.Get(txn); !ok || entry.State != StateCommitted {
+		t.Fatalf("log entry = %+v, want COMMITTED", entry)
+	}
+
+	// A transaction that crashed mid-prepare is aborted by recovery
+	tc.txnLog.Write(LogEntry{TxnID: 99, State: StatePreparing, Participants: []int{0}})
+
+	if err := tc.Recover(); err != nil {
+		t.Fatalf("Recover: %v", err)
+	}
+	if !mocks[1].committed[txn] {
+		t.Error("Recover did not re-send COMMIT")
+	}
+	if !mocks[0].aborted[99] {
+		t.Error("Recover did not abort the undecided transaction")
+	}
+	if entries := tc.txnLog.Entries(); len(entries) != 0 {
+		t.Errorf("log after recovery: %+v", entries)
+	}
+}
+
+func TestOnePhaseCommit(t *testing.T) {
+	one := &onePhaseParticipant{mockParticipant: newMockParticipant()}
+	other := newMockParticipant()
+	tc := NewCoordinator([]Participant{one, other})
+
+	txn, _ := tc.Begin()
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "b"})
+	if err := tc.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	if one.onePhaseCalls != 1 || one.messages() != 0 || other.messages() != 0 {
+		t.Errorf("messages: one-phase %d, 2PC %d and %d; want 1, 0 and 0", one.onePhaseCalls, one.messages(), other.messages())
+	}
+	if len(one.prepared[txn]) != 2 || !one.committed[txn] {
+		t.Errorf("participant got %v, committed %v", one.prepared[txn], one.committed[txn])
+	}
+	if entries := tc.txnLog.Entries(); len(entries) != 0 {
+		t.Errorf("one-phase commit logged %+v", entries)
+	}
+
+	// The participant's refusal aborts the transaction
+	one.fail = errors.New("constraint violated")
+	txn, _ = tc.Begin()
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+	if err := tc.Commit(txn); !errors.Is(err, ErrTxnAborted) {
+		t.Errorf("Commit = %v, want ErrTxnAborted", err)
+	}
+
+	// A transaction spanning both participants still runs 2PC
+	one.fail = nil
+	txn, _ = tc.Begin()
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+	tc.Execute(txn, 1, Operation{Type: "put", Key: "b"})
+	if err := tc.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	if one.onePhaseCalls != 2 || one.messages() != 2 || other.messages() != 2 {
+		t.Errorf("messages: one-phase %d, 2PC %d and %d; want 2, 2 and 2", one.onePhaseCalls, one.messages(), other.messages())
+	}
+
+	// Without OnePhaseCommitter a single participant gets ordinary 2PC
+	tc, mocks := newCluster(1, Options{})
+	txn, _ = tc.Begin()
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+	if err := tc.Commit(txn); err != nil || mocks[0].messages() != 2 {
+		t.Errorf("Commit = %v after %d messages, want 2", err, mocks[0].messages())
+	}
+}
+
+func TestReadOnlyVote(t *testing.T) {
+	tc, mocks := newCluster(3, Options{})
+	mocks[0].vote = VoteReadOnly
+	mocks[1].vote = VoteReadOnly
+
+	txn, _ := tc.Begin()
+	for p := range mocks {
+		tc.Execute(txn, p, Operation{Type: "get", Key: "k"})
+	}
+	if err := tc.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range []int{1, 1, 2} {
+		if got := mocks[p].messages(); got != want {
+			t.Errorf("participant %d got %d messages, want %d", p, got, want)
+		}
+	}
+	if !mocks[2].committed[txn] || mocks[0].committed[txn] {
+		t.Error("phase 2 went to the wrong participants")
+	}
+
+	// All read-only: no phase 2 and nothing left in the log
+	mocks[2].vote = VoteReadOnly
+	txn, _ = tc.Begin()
+	for p := range mocks {
+		tc.Execute(txn, p, Operation{Type: "get", Key: "k"})
+	}
+	if err := tc.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	for p, want := range []int{2, 2, 3} {
+		if got := mocks[p].messages(); got != want {
+			t.Errorf("participant %d got %d messages, want %d", p, got, want)
+		}
+	}
+	if entries := tc.txnLog.Entries(); len(entries) != 0 {
+		t.Errorf("read-only transaction left in log: %+v", entries)
+	}
+
+	// On abort, read-only participants are not sent ABORT. The NO vote
+	// comes last, so both read-only votes are in.
+	mocks[2].vote = VoteNo
+	mocks[2].delay = 20 * time.Millisecond
+	txn, _ = tc.Begin()
+	for p := range mocks {
+		tc.Execute(txn, p, Operation{Type: "get", Key: "k"})
+	}
+	if err := tc.Commit(txn); !errors.Is(err, ErrVoteNo) {
+		t.Fatalf("Commit = %v, want ErrVoteNo", err)
+	}
+	if mocks[0].aborted[txn] || mocks[1].aborted[txn] || !mocks[2].aborted[txn] {
+		t.Error("ABORT went to the wrong participants")
+	}
+}
+
+func TestProtocolTrace(t *testing.T) {
+	tc, mocks := newCluster(3, Options{Tracing: true, MaxTraces: 2})
+	mocks[1].vote = VoteNo
+	txn, _ := tc.Begin()
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+	tc.Execute(txn, 1, Operation{Type: "put", Key: "b"})
+	tc.Commit(txn)
+
+	trace, ok := tc.Trace(txn)
+	if !ok {
+		t.Fatal("no trace")
+	}
+	count := make(map[string]int)
+	for _, e := range trace.Events {
+		count[e.Kind+" "+e.Message+e.State+e.Vote]++
+	}
+	want := map[string]int{
+		"state PREPARING": 1, "send PREPARE": 2, "receive VOTEYES": 1, "receive VOTENO": 1,
+		"state ABORTED": 1, "send ABORT": 2, "receive ACK": 2,
+	}
+	if !maps.Equal(count, want) {
+		t.Errorf("events = %v, want %v", count, want)
+	}
+	if first := trace.Events[0]; first.State != "PREPARING" || first.Participant != -1 {
+		t.Errorf("first event = %+v", first)
+	}
+	for _, e := range trace.Events {
+		if e.State == "ABORTED" && !strings.Contains(e.Error, "participant 1") {
+			t.Errorf("abort cause = %q", e.Error)
+		}
+	}
+
+	// Only the latest MaxTraces are kept
+	for range 2 {
+		txn, _ := tc.Begin()
+		tc.Execute(txn, 2, Operation{Type: "put", Key: "c"})
+		tc.Commit(txn)
+	}
+	if _, ok := tc.Trace(txn); ok {
+		t.Error("oldest trace kept beyond MaxTraces")
+	}
+	var buf bytes.Buffer
+	if err := tc.ExportTraces(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var exported []Trace
+	if err := json.Unmarshal(buf.Bytes(), &exported); err != nil {
+		t.Fatal(err)
+	}
+	if len(exported) != 2 || exported[0].TxnID != txn+1 || exported[1].Events[len(exported[1].Events)-1].Message != MsgAck {
+		t.Errorf("exported %+v", exported)
+	}
+
+	tc, _ = newCluster(1, Options{})
+	txn, _ = tc.Begin()
+	tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+	tc.Commit(txn)
+	if _, ok := tc.Trace(txn); ok {
+		t.Error("traced without Tracing")
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	tc, mocks := newCluster(2, Options{})
+	mocks[0].delay = 10 * time.Millisecond
+	commit := func() error {
+		txn, _ := tc.Begin()
+		tc.Execute(txn, 0, Operation{Type: "put", Key: "a"})
+		tc.Execute(txn, 1, Operation{Type: "put", Key: "b"})
+		return tc.Commit(txn)
+	}
+
+	commit()
+	mocks[1].vote = VoteNo
+	commit()
+	mocks[1].vote = VoteYes
+	mocks[1].commitFails = 1
+	commit()
+
+	m := tc.Metrics()
+	if m.Committed != 2 || m.Aborted != 1 || m.AbortRate != 1.0/3 {
+		t.Errorf("outcomes: %+v", m)
+	}
+	if m.InDoubt != 1 {
+		t.Errorf("in doubt = %d, want the unacknowledged commit", m.InDoubt)
+	}
+	lat := m.PrepareLatency
+	if lat.Count != 3 || lat.P50 < 10*time.Millisecond || lat.Max < lat.P99 || lat.P99 < lat.P50 {
+		t.Errorf("prepare latency: %+v", lat)
+	}
+
+	if err := tc.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if m := tc.Metrics(); m.InDoubt != 0 {
+		t.Errorf("in doubt after recovery = %d", m.InDoubt)
+	}
 }
 
 func TestDistributedDeadlock(t *testing.T) {
@@ -23,16 +414,90 @@ func TestDistributedDeadlock(t *testing.T) {
 }
 
 func TestNetworkPartition(t *testing.T) {
-	// TODO: Test behavior under network partition
-	t.Skip("not implemented")
+	tc, mocks := newCluster(3, Options{PrepareTimeout: 20 * time.Millisecond})
+	mocks[2].block = make(chan struct{})
+	defer close(mocks[2].block)
+
+	txn, _ := tc.Begin()
+	for p := range mocks {
+		tc.Execute(txn, p, Operation{Type: "put", Key: "k"})
+	}
+	if err := tc.Commit(txn); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Commit = %v, want ErrTimeout", err)
+	}
+	if mocks[0].committed[txn] || !mocks[0].aborted[txn] {
+		t.Error("reachable participant not aborted")
+	}
+}
+
+func TestPipelinedPrepare(t *testing.T) {
+	const delay = 10 * time.Millisecond
+	commit := func(workers int) time.Duration {
+		tc, mocks := newCluster(8, Options{PrepareWorkers: workers})
+		for _, m := range mocks {
+			m.delay = delay
+		}
+		txn, _ := tc.Begin()
+		for p := range mocks {
+			tc.Execute(txn, p, Operation{Type: "put", Key: "k"})
+		}
+		start := time.Now()
+		if err := tc.Commit(txn); err != nil {
+			t.Fatal(err)
+		}
+		return time.Since(start)
+	}
+
+	sequential := commit(1)
+	pipelined := commit(8)
+	if sequential < 8*delay {
+		t.Errorf("sequential commit took %v, want at least %v", sequential, 8*delay)
+	}
+	if pipelined >= sequential/2 {
+		t.Errorf("pipelined commit took %v, sequential %v", pipelined, sequential)
+	}
 }
 
+// BenchmarkCommit compares commit latency with participants contacted one
+// at a time against the pipelined prepare
 func BenchmarkCommit(b *testing.B) {
-	// TODO: Benchmark commit latency
-	b.Skip("not implemented")
+	for _, bc := range []struct {
+		name    string
+		workers int
+	}{
+		{"sequential", 1},
+		{"pipelined", 16},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			tc, mocks := newCluster(5, Options{PrepareWorkers: bc.workers})
+			for _, m := range mocks {
+				m.delay = 100 * time.Microsecond
+			}
+			for range b.N {
+				txn, _ := tc.Begin()
+				for p := range mocks {
+					tc.Execute(txn, p, Operation{Type: "put", Key: "k"})
+				}
+				if err := tc.Commit(txn); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
 }
 
 func BenchmarkThroughput(b *testing.B) {
-	// TODO: Benchmark transaction throughput
-	b.Skip("not implemented")
+	tc, mocks := newCluster(3, Options{})
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			txn, _ := tc.Begin()
+			for p := range mocks {
+				tc.Execute(txn, p, Operation{Type: "put", Key: "k"})
+			}
+			if err := tc.Commit(txn); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
 }
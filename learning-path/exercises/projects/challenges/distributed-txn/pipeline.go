@@ -0,0 +1,102 @@
+package distributedtxn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+)
+
+// prepare sends every participant its batch of operations and waits for
+// the votes, returning the participants that voted READ-ONLY. Messages are
+// pipelined: up to PrepareWorkers participants are contacted at once, so
+// the phase takes about as long as the slowest participant rather than the
+// sum of all of them.
+func (tc *TransactionCoordinator) prepare(txnID TxnID, participants []int, batches map[int][]Operation) (map[int]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tc.opts.PrepareTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	readOnly := make(map[int]bool)
+	results := tc.fanOut(ctx, participants, func(id int) error {
+		tc.traceSend(txnID, id, MsgPrepare)
+		vote, err := tc.participants[id].Prepare(txnID, batches[id])
+		tc.traceReceive(txnID, id, MsgVote, &vote, err)
+		switch {
+		case err != nil:
+			return fmt.Errorf("participant %d: %w", id, err)
+		case vote == VoteReadOnly:
+			mu.Lock()
+			readOnly[id] = true
+			mu.Unlock()
+		case vote != VoteYes:
+			return fmt.Errorf("%w: participant %d", ErrVoteNo, id)
+		}
+		return nil
+	})
+	for range participants {
+		select {
+		case err := <-results:
+			if err != nil {
+				return snapshotVotes(&mu, readOnly), err
+			}
+		case <-ctx.Done():
+			return snapshotVotes(&mu, readOnly), ErrTimeout
+		}
+	}
+	return readOnly, nil
+}
+
+// snapshotVotes copies the read-only votes received so far, since workers
+// may still be adding to them
+func snapshotVotes(mu *sync.Mutex, readOnly map[int]bool) map[int]bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return maps.Clone(readOnly)
+}
+
+// broadcast sends msg, by running fn, to every participant through the
+// worker pool and waits for all of them to acknowledge
+func (tc *TransactionCoordinator) broadcast(txnID TxnID, msg string, participants []int, fn func(p Participant) error) error {
+	results := tc.fanOut(context.Background(), participants, func(id int) error {
+		tc.traceSend(txnID, id, msg)
+		err := fn(tc.participants[id])
+		tc.traceReceive(txnID, id, MsgAck, nil, err)
+		if err != nil {
+			return fmt.Errorf("participant %d: %w", id, err)
+		}
+		return nil
+	})
+	var errs []error
+	for range participants {
+		errs = append(errs, <-results)
+	}
+	return errors.Join(errs...)
+}
+
+// fanOut calls fn for each participant on at most PrepareWorkers
+// goroutines. The returned channel receives one result per participant;
+// once ctx is done, participants not yet contacted get ctx's error instead.
+func (tc *TransactionCoordinator) fanOut(ctx context.Context, participants []int, fn func(id int) error) <-chan error {
+	jobs := make(chan int, len(participants))
+	for _, id := range participants {
+		jobs <- id
+	}
+	close(jobs)
+
+	// Buffered so workers never block on a caller that stopped reading
+	results := make(chan error, len(participants))
+	for range min(tc.opts.PrepareWorkers, len(participants)) {
+		go func() {
+			for id := range jobs {
+				if err := ctx.Err(); err != nil {
+					results <- err
+					continue
+				}
+				results <- fn(id)
+			}
+		}()
+	}
+	return results
+}
@@ -0,0 +1,237 @@
+package distributedtxn
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Trace event kinds
+const (
+	EventSend    = "send"    // coordinator to participant
+	EventReceive = "receive" // participant to coordinator
+	EventState   = "state"   // the transaction changed state
+)
+
+// Protocol messages, as traces name them
+const (
+	MsgPrepare        = "PREPARE"
+	MsgVote           = "VOTE"
+	MsgCommit         = "COMMIT"
+	MsgAbort          = "ABORT"
+	MsgAck            = "ACK"
+	MsgCommitOnePhase = "COMMIT-ONE-PHASE"
+)
+
+// TraceEvent is one step of the protocol as the coordinator saw it
+type TraceEvent struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	Participant int       `json:"participant"` // -1 for state events
+	Message     string    `json:"message,omitempty"`
+	Vote        string    `json:"vote,omitempty"`
+	State       string    `json:"state,omitempty"`
+	// Error is the error a participant returned instead of a vote or an
+	// acknowledgement, or the cause of an abort
+	Error string `json:"error,omitempty"`
+}
+
+// Trace is the protocol history of one transaction. Events are in the order
+// the coordinator recorded them; messages to different participants
+// interleave since they are sent concurrently. A reply arriving after the
+// prepare phase timed out is still recorded.
+type Trace struct {
+	TxnID  TxnID        `json:"txn_id"`
+	Events []TraceEvent `json:"events"`
+}
+
+// LatencyStats summarizes observed durations. Mean and Max cover every
+// observation; the percentiles cover the latest latencyWindow of them.
+type LatencyStats struct {
+	Count int64         `json:"count"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+// Metrics aggregates the outcomes of Commit
+type Metrics struct {
+	Committed int64 `json:"committed"`
+	// Aborted counts commits that ended in an abort: a NO vote, an error,
+	// a timeout or a refused one-phase commit. Abort calls are not counted.
+	Aborted   int64   `json:"aborted"`
+	AbortRate float64 `json:"abort_rate"` // Aborted / (Committed + Aborted)
+	// InDoubt counts transactions in the log: mid-protocol, or decided
+	// but not yet acknowledged by every participant, so Recover still
+	// has work to do for them
+	InDoubt int `json:"in_doubt"`
+	// PrepareLatency is the duration of the prepare phase of 2PC commits,
+	// failed ones included
+	PrepareLatency LatencyStats `json:"prepare_latency"`
+}
+
+// latencyWindow is how many recent prepare latencies percentiles use
+const latencyWindow = 1024
+
+// tracer records traces, if enabled, and metrics for a coordinator. It has
+// its own mutex, since events arrive from the worker pool.
+type tracer struct {
+	mu     sync.Mutex
+	max    int // traces kept; 0 disables tracing
+	traces map[TxnID]*Trace
+	order  []TxnID // oldest first, for eviction
+
+	committed, aborted int64
+	prepareCount       int64
+	prepareTotal       time.Duration
+	prepareMax         time.Duration
+	prepareRecent      []time.Duration // ring of the latest latencyWindow
+}
+
+func newTracer(opts Options) *tracer {
+	t := &tracer{}
+	if opts.Tracing {
+		t.max = opts.MaxTraces
+		t.traces = make(map[TxnID]*Trace)
+	}
+	return t
+}
+
+func (t *tracer) record(txnID TxnID, event TraceEvent) {
+	if t.max == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	trace, ok := t.traces[txnID]
+	if !ok {
+		if len(t.order) == t.max {
+			delete(t.traces, t.order[0])
+			t.order = t.order[1:]
+		}
+		trace = &Trace{TxnID: txnID}
+		t.traces[txnID] = trace
+		t.order = append(t.order, txnID)
+	}
+	trace.Events = append(trace.Events, event)
+}
+
+func (t *tracer) outcome(committed bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if committed {
+		t.committed++
+	} else {
+		t.aborted++
+	}
+}
+
+func (t *tracer) observePrepare(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.prepareRecent) < latencyWindow {
+		t.prepareRecent = append(t.prepareRecent, d)
+	} else {
+		t.prepareRecent[t.prepareCount%latencyWindow] = d
+	}
+	t.prepareCount++
+	t.prepareTotal += d
+	t.prepareMax = max(t.prepareMax, d)
+}
+
+// traceSend records a message sent to participant id
+func (tc *TransactionCoordinator) traceSend(txnID TxnID, id int, msg string) {
+	tc.tracer.record(txnID, TraceEvent{Time: time.Now(), Kind: EventSend, Participant: id, Message: msg})
+}
+
+// traceReceive records the reply of participant id, or the error it
+// returned instead
+func (tc *TransactionCoordinator) traceReceive(txnID TxnID, id int, msg string, vote *Vote, err error) {
+	event := TraceEvent{Time: time.Now(), Kind: EventReceive, Participant: id, Message: msg}
+	if err != nil {
+		event.Error = err.Error()
+	} else if vote != nil {
+		event.Vote = vote.String()
+	}
+	tc.tracer.record(txnID, event)
+}
+
+// traceState records a state change, with the cause of an abort
+func (tc *TransactionCoordinator) traceState(txnID TxnID, state TxnState, cause error) {
+	event := TraceEvent{Time: time.Now(), Kind: EventState, Participant: -1, State: state.String()}
+	if cause != nil {
+		event.Error = cause.Error()
+	}
+	tc.tracer.record(txnID, event)
+}
+
+// Trace returns a copy of the protocol trace of txnID. ok is false if
+// tracing is off, the transaction never reached the protocol, or its trace
+// was evicted to stay within MaxTraces.
+func (tc *TransactionCoordinator) Trace(txnID TxnID) (trace Trace, ok bool) {
+	t := tc.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tr, ok := t.traces[txnID]
+	if !ok {
+		return Trace{}, false
+	}
+	return Trace{TxnID: tr.TxnID, Events: slices.Clone(tr.Events)}, true
+}
+
+// ExportTraces writes every trace kept as a JSON array, in transaction
+// order
+func (tc *TransactionCoordinator) ExportTraces(w io.Writer) error {
+	t := tc.tracer
+	t.mu.Lock()
+	traces := make([]Trace, 0, len(t.traces))
+	for _, tr := range t.traces {
+		traces = append(traces, Trace{TxnID: tr.TxnID, Events: slices.Clone(tr.Events)})
+	}
+	t.mu.Unlock()
+	slices.SortFunc(traces, func(a, b Trace) int { return cmp.Compare(a.TxnID, b.TxnID) })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(traces); err != nil {
+		return fmt.Errorf("export traces: %w", err)
+	}
+	return nil
+}
+
+// Metrics returns the commit outcomes and prepare latencies so far
+func (tc *TransactionCoordinator) Metrics() Metrics {
+	inDoubt := tc.txnLog.Len()
+
+	t := tc.tracer
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m := Metrics{Committed: t.committed, Aborted: t.aborted, InDoubt: inDoubt}
+	if n := t.committed + t.aborted; n > 0 {
+		m.AbortRate = float64(t.aborted) / float64(n)
+	}
+	if t.prepareCount > 0 {
+		recent := slices.Clone(t.prepareRecent)
+		slices.Sort(recent)
+		m.PrepareLatency = LatencyStats{
+			Count: t.prepareCount,
+			Mean:  t.prepareTotal / time.Duration(t.prepareCount),
+			P50:   percentile(recent, 0.50),
+			P99:   percentile(recent, 0.99),
+			Max:   t.prepareMax,
+		}
+	}
+	return m
+}
+
+// percentile returns the p-th percentile of sorted, nearest rank
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(float64(len(sorted))*p)) - 1
+	return sorted[max(i, 0)]
+}
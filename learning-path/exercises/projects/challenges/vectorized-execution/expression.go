@@ -0,0 +1,231 @@
+package vectorized
+
+import (
+	"cmp"
+	"fmt"
+)
+
+// ColumnRef evaluates to a column of the input batch
+type ColumnRef struct {
+	Index int
+}
+
+func (c ColumnRef) Evaluate(batch *VectorBatch) *Vector {
+	return batch.columns[c.Index]
+}
+
+// Literal evaluates to a constant vector; Value must not be nil
+type Literal struct {
+	Value interface{}
+}
+
+func (l Literal) Evaluate(batch *VectorBatch) *Vector {
+	return NewConstantVector(l.Value, batch.size)
+}
+
+// ArithOp is an arithmetic operator
+type ArithOp int
+
+const (
+	OpAdd ArithOp = iota
+	OpSub
+	OpMul
+	OpDiv
+)
+
+// Arithmetic applies Op to two numeric operands. INT64 op FLOAT64 is
+// FLOAT64; NULL operands and integer division by zero give NULL.
+type Arithmetic struct {
+	Op          ArithOp
+	Left, Right Expression
+}
+
+func (a Arithmetic) Evaluate(batch *VectorBatch) *Vector {
+	l, r := a.Left.Evaluate(batch), a.Right.Evaluate(batch)
+	if l.typ == TypeInt64 && r.typ == TypeInt64 {
+		var kernel func(x, y operand[int64], out []int64) bool
+		if a.Op == OpAdd {
+			kernel = addInt64Kernel
+		}
+		return binary(l, r, TypeInt64, arith[int64](a.Op), kernel)
+	}
+	if !isNumeric(l.typ) || !isNumeric(r.typ) {
+		panic(fmt.Sprintf("vectorized: arithmetic on %s and %s", l.typ, r.typ))
+	}
+	return binary(toFloat64(l), toFloat64(r), TypeFloat64, arith[float64](a.Op), nil)
+}
+
+func arith[T int64 | float64](op ArithOp) func(x, y T) (T, bool) {
+	switch op {
+	case OpAdd:
+		return func(x, y T) (T, bool) { return x + y, true }
+	case OpSub:
+		return func(x, y T) (T, bool) { return x - y, true }
+	case OpMul:
+		return func(x, y T) (T, bool) { return x * y, true }
+	case OpDiv:
+		return func(x, y T) (T, bool) {
+			var zero T
+			if _, isInt := any(zero).(int64); isInt && y == 0 {
+				return zero, false
+			}
+			return x / y, true
+		}
+	default:
+		panic(fmt.Sprintf("vectorized: unknown arithmetic operator %d", op))
+	}
+}
+
+// addInt64Kernel adds whole NULL-free vectors with the loops the compiler
+// can vectorize, reading a constant operand as a scalar
+func addInt64Kernel(x, y operand[int64], out []int64) bool {
+	switch {
+	case x.constant && y.constant:
+		return false
+	case y.constant:
+		AddConstInt64(x.values, y.value, out)
+	case x.constant:
+		AddConstInt64(y.values, x.value, out)
+	default:
+		AddInt64(x.values, y.values, out)
+	}
+	return true
+}
+
+// CompareOp is a comparison operator
+type CompareOp int
+
+const (
+	OpEq CompareOp = iota
+	OpNe
+	OpLt
+	OpLe
+	OpGt
+	OpGe
+)
+
+// Comparison compares two operands of the same type, or two numbers, and
+// evaluates to BOOL; a NULL operand gives NULL
+type Comparison struct {
+	Op          CompareOp
+	Left, Right Expression
+}
+
+func (c Comparison) Evaluate(batch *VectorBatch) *Vector {
+	l, r := c.Left.Evaluate(batch), c.Right.Evaluate(batch)
+	switch {
+	case l.typ == TypeInt64 && r.typ == TypeInt64:
+		return binary(l, r, TypeBool, compare[int64](c.Op), nil)
+	case isNumeric(l.typ) && isNumeric(r.typ):
+		return binary(toFloat64(l), toFloat64(r), TypeBool, compare[float64](c.Op), nil)
+	case l.typ == TypeString && r.typ == TypeString:
+		return binary(l, r, TypeBool, compare[string](c.Op), nil)
+	case l.typ == TypeBool && r.typ == TypeBool:
+		return binary(l, r, TypeBool, func(x, y bool) (bool, bool) {
+			return compareResult(c.Op, cmp.Compare(boolInt(x), boolInt(y))), true
+		}, nil)
+	default:
+		panic(fmt.Sprintf("vectorized: comparison of %s and %s", l.typ, r.typ))
+	}
+}
+
+func compare[T cmp.Ordered](op CompareOp) func(x, y T) (bool, bool) {
+	return func(x, y T) (bool, bool) {
+		return compareResult(op, cmp.Compare(x, y)), true
+	}
+}
+
+func compareResult(op CompareOp, c int) bool {
+	switch op {
+	case OpEq:
+		return c == 0
+	case OpNe:
+		return c != 0
+	case OpLt:
+		return c < 0
+	case OpLe:
+		return c <= 0
+	case OpGt:
+		return c > 0
+	case OpGe:
+		return c >= 0
+	default:
+		panic(fmt.Sprintf("vectorized: unknown comparison operator %d", op))
+	}
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isNumeric(typ DataType) bool {
+	return typ == TypeInt64 || typ == TypeFloat64
+}
+
+// binary combines l and r row by row with f, which reports false for a
+// NULL result. Two constants fold into a constant; otherwise the result is
+// a computed vector evaluated only for the rows that are read. kernel, if
+// set, handles a whole NULL-free batch at once and reports whether it did.
+func binary[T, R any](l, r *Vector, typ DataType, f func(x, y T) (R, bool), kernel func(x, y operand[T], out []R) bool) *Vector {
+	if l.kind == ConstantVector && r.kind == ConstantVector {
+		if l.IsNull(0) || r.IsNull(0) {
+			return NewNullConstantVector(typ, l.size)
+		}
+		result, ok := f(l.data.([]T)[0], r.data.([]T)[0])
+		if !ok {
+			return NewNullConstantVector(typ, l.size)
+		}
+		return NewConstantVector(result, l.size)
+	}
+
+	return NewComputedVector(typ, l.size, func(sel []int, out *Vector) {
+		x, y := newOperand[T](l, sel), newOperand[T](r, sel)
+		values := out.data.([]R)
+		mayBeNull := x.hasNulls() || y.hasNulls()
+		if sel == nil && !mayBeNull && kernel != nil && kernel(x, y, values) {
+			out.nulls = nil
+			return
+		}
+		forEachRow(sel, out.size, func(i int) {
+			if mayBeNull && (x.isNull(i) || y.isNull(i)) {
+				out.SetNull(i)
+				return
+			}
+			result, ok := f(x.at(i), y.at(i))
+			if !ok {
+				out.SetNull(i)
+				return
+			}
+			values[i] = result
+			out.setValid(i)
+		})
+	})
+}
+
+// toFloat64 converts an INT64 vector to FLOAT64 without materializing it
+func toFloat64(v *Vector) *Vector {
+	if v.typ == TypeFloat64 {
+		return v
+	}
+	if v.kind == ConstantVector {
+		if v.IsNull(0) {
+			return NewNullConstantVector(TypeFloat64, v.size)
+		}
+		return NewConstantVector(float64(v.data.([]int64)[0]), v.size)
+	}
+	return NewComputedVector(TypeFloat64, v.size, func(sel []int, out *Vector) {
+		src := v.Flatten(sel)
+		ints, floats := src.data.([]int64), out.data.([]float64)
+		forEachRow(sel, out.size, func(i int) {
+			if src.IsNull(i) {
+				out.SetNull(i)
+				return
+			}
+			floats[i] = float64(ints[i])
+			out.setValid(i)
+		})
+	})
+}
@@ -0,0 +1,110 @@
+package vectorized
+
+import "fmt"
+
+// Gather returns a flat vector whose row j is row indexes[j] of v, with its
+// NULL flag. Joins use it to build output columns from matched row
+// positions, and aggregates to pull the rows of a group together. A
+// constant vector stays constant, and a computed vector is evaluated only
+// for the gathered rows.
+func Gather(v *Vector, indexes []int) *Vector {
+	switch v.kind {
+	case ConstantVector:
+		out := *v
+		out.size = len(indexes)
+		return &out
+	case ComputedVector:
+		v = v.Flatten(indexes)
+	}
+
+	var out *Vector
+	switch data := v.data.(type) {
+	case []int64:
+		out = NewInt64Vector(gatherValues(data, indexes))
+	case []float64:
+		out = NewFloat64Vector(gatherValues(data, indexes))
+	case []string:
+		out = NewStringVector(gatherValues(data, indexes))
+	case []bool:
+		out = NewBoolVector(gatherValues(data, indexes))
+	default:
+		panic(fmt.Sprintf("vectorized: unsupported type %s", v.typ))
+	}
+	if v.nulls != nil {
+		for j, i := range indexes {
+			if v.nulls.Get(i) {
+				out.SetNull(j)
+			}
+		}
+	}
+	return out
+}
+
+// gatherValues is the typed kernel of Gather
+func gatherValues[T any](src []T, indexes []int) []T {
+	dst := make([]T, len(indexes))
+	for j, i := range indexes {
+		dst[j] = src[i]
+	}
+	return dst
+}
+
+// Scatter writes row i of src to row positions[i] of dst, with its NULL
+// flag, for every row of src; rows with a negative position are skipped.
+// It is the inverse of Gather: shuffles use it to route rows to their
+// partition's buffer, aggregates to write results back in group order.
+// dst must be a flat vector of the same type as src.
+func Scatter(src *Vector, positions []int, dst *Vector) {
+	if dst.kind != FlatVector || dst.typ != src.typ {
+		panic(fmt.Sprintf("vectorized: scatter %s into %s %s vector", src.typ, dst.kind, dst.typ))
+	}
+	if src.kind == ConstantVector {
+		src = src.Flatten(nil)
+	} else if src.kind == ComputedVector {
+		src = src.Flatten(scattered(positions))
+	}
+
+	switch data := src.data.(type) {
+	case []int64:
+		scatterValues(data, positions, dst.data.([]int64))
+	case []float64:
+		scatterValues(data, positions, dst.data.([]float64))
+	case []string:
+		scatterValues(data, positions, dst.data.([]string))
+	case []bool:
+		scatterValues(data, positions, dst.data.([]bool))
+	}
+	if src.nulls == nil && dst.nulls == nil {
+		return
+	}
+	for i, p := range positions {
+		switch {
+		case p < 0:
+		case src.IsNull(i):
+			dst.SetNull(p)
+		default:
+			dst.setValid(p)
+		}
+	}
+}
+
+// scatterValues is the typed kernel of Scatter
+func scatterValues[T any](src []T, positions []int, dst []T) {
+	for i, p := range positions {
+		if p >= 0 {
+			dst[p] = src[i]
+		}
+	}
+}
+
+// scattered returns the rows Scatter writes, so a computed source is only
+// evaluated for them
+func scattered(positions []int) []int {
+	sel := make([]int, 0, len(positions))
+	for i, p := range positions {
+		if p >= 0 {
+			sel = append(sel, i)
+		}
+	}
+	return sel
+}
@@ -0,0 +1,271 @@
+package vectorized
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+)
+
+// Hash sets hashes[i] to the hash of row i of v under seed, for the rows
+// in sel, or every row if sel is nil. Fixed-width values are hashed with
+// the MurmurHash3 64-bit mix and strings with XXH64. Equal values hash
+// equally whatever the vector's kind; 0.0 and -0.0 hash equally, as do all
+// NaNs, and NULLs share one hash per seed.
+//
+// Join, aggregate and shuffle operators hash their first key column with
+// Hash and mix in the others with HashCombine.
+func Hash(v *Vector, sel []int, seed uint64, hashes []uint64) {
+	hashRows(v, sel, hashes, seed, false)
+}
+
+// HashCombine mixes the hash of row i of v into hashes[i], for the rows in
+// sel, or every row if sel is nil, using the current hash as the seed. The
+// result depends on the order columns are combined in.
+func HashCombine(v *Vector, sel []int, hashes []uint64) {
+	hashRows(v, sel, hashes, 0, true)
+}
+
+// hashRows runs the typed hash kernel for v. With combine set, each row's
+// seed is its current hash instead of seed.
+func hashRows(v *Vector, sel []int, hashes []uint64, seed uint64, combine bool) {
+	if v.kind == ConstantVector {
+		if !combine {
+			// One hash for every row
+			h := hashValue(v, 0, seed)
+			forEachRow(sel, v.size, func(i int) { hashes[i] = h })
+			return
+		}
+		forEachRow(sel, v.size, func(i int) { hashes[i] = hashValue(v, 0, hashes[i]) })
+		return
+	}
+	v = v.Flatten(sel)
+	if sel == nil && v.nulls == nil && hashDense(v, hashes, seed, combine) {
+		return
+	}
+
+	switch data := v.data.(type) {
+	case []int64:
+		hashFlat(data, v.nulls, sel, hashes, seed, combine, func(x int64, s uint64) uint64 { return hashUint64(uint64(x), s) })
+	case []float64:
+		hashFlat(data, v.nulls, sel, hashes, seed, combine, func(x float64, s uint64) uint64 { return hashUint64(floatBits(x), s) })
+	case []string:
+		hashFlat(data, v.nulls, sel, hashes, seed, combine, hashString)
+	case []bool:
+		hashFlat(data, v.nulls, sel, hashes, seed, combine, func(x bool, s uint64) uint64 { return hashUint64(boolBits(x), s) })
+	default:
+		panic(fmt.Sprintf("vectorized: unsupported type %s", v.typ))
+	}
+}
+
+// hashFlat is the typed kernel of hashRows for a flat vector
+func hashFlat[T any](data []T, nulls *Bitmap, sel []int, hashes []uint64, seed uint64, combine bool, hash func(x T, seed uint64) uint64) {
+	row := func(i int) {
+		s := seed
+		if combine {
+			s = hashes[i]
+		}
+		if nulls != nil && nulls.Get(i) {
+			hashes[i] = hashNull(s)
+		} else {
+			hashes[i] = hash(data[i], s)
+		}
+	}
+	if sel != nil {
+		for _, i := range sel {
+			row(i)
+		}
+		return
+	}
+	for i := range data {
+		row(i)
+	}
+}
+
+// hashDense hashes every row of a flat vector without NULLs, the common
+// case, with loops the compiler can inline the hash into; that halves the
+// cost of going through hashFlat. It reports false for a type it has no
+// loop for.
+func hashDense(v *Vector, hashes []uint64, seed uint64, combine bool) bool {
+	switch data := v.data.(type) {
+	case []int64:
+		if combine {
+			for i, x := range data {
+				hashes[i] = hashUint64(uint64(x), hashes[i])
+			}
+		} else {
+			for i, x := range data {
+				hashes[i] = hashUint64(uint64(x), seed)
+			}
+		}
+	case []float64:
+		if combine {
+			for i, x := range data {
+				hashes[i] = hashUint64(floatBits(x), hashes[i])
+			}
+		} else {
+			for i, x := range data {
+				hashes[i] = hashUint64(floatBits(x), seed)
+			}
+		}
+	case []string:
+		if combine {
+			for i, x := range data {
+				hashes[i] = hashString(x, hashes[i])
+			}
+		} else {
+			for i, x := range data {
+				hashes[i] = hashString(x, seed)
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// hashValue hashes row i of a flat or constant vector
+func hashValue(v *Vector, i int, seed uint64) uint64 {
+	if v.IsNull(i) {
+		return hashNull(seed)
+	}
+	switch data := v.data.(type) {
+	case []int64:
+		return hashUint64(uint64(data[i]), seed)
+	case []float64:
+		return hashUint64(floatBits(data[i]), seed)
+	case []string:
+		return hashString(data[i], seed)
+	case []bool:
+		return hashUint64(boolBits(data[i]), seed)
+	default:
+		panic(fmt.Sprintf("vectorized: unsupported type %s", v.typ))
+	}
+}
+
+// hashNull is the hash of NULL: a fixed value under a perturbed seed, so
+// it does not collide with the zero value of any type
+func hashNull(seed uint64) uint64 {
+	return hashUint64(0x9e3779b97f4a7c15, seed^0xc2b2ae3d27d4eb4f)
+}
+
+// floatBits returns the bits hashed for x: -0.0 becomes 0.0 and every NaN
+// the same NaN, as they compare equal
+func floatBits(x float64) uint64 {
+	switch {
+	case x == 0:
+		return 0
+	case math.IsNaN(x):
+		return 0x7ff8000000000001
+	}
+	return math.Float64bits(x)
+}
+
+func boolBits(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// MurmurHash3 x64 constants
+const (
+	murmurC1 = 0x87c37b91114253d5
+	murmurC2 = 0x4cf5ad432745937f
+)
+
+// hashUint64 hashes x as one 8-byte MurmurHash3 block
+func hashUint64(x, seed uint64) uint64 {
+	k := bits.RotateLeft64(x*murmurC1, 31) * murmurC2
+	h := seed ^ k
+	h = bits.RotateLeft64(h, 27)*5 + 0x52dce729
+	return fmix64(h ^ 8)
+}
+
+// fmix64 is the MurmurHash3 finalizer: every input bit affects every
+// output bit
+func fmix64(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// XXH64 primes
+const (
+	xxPrime1 uint64 = 0x9e3779b185ebca87
+	xxPrime2 uint64 = 0xc2b2ae3d27d4eb4f
+	xxPrime3 uint64 = 0x165667b19e3779f9
+	xxPrime4 uint64 = 0x85ebca77c2b2ae63
+	xxPrime5 uint64 = 0x27d4eb2f165667c5
+)
+
+// hashString is XXH64 of s
+func hashString(s string, seed uint64) uint64 {
+	n := len(s)
+	var h uint64
+	if n >= 32 {
+		v1 := seed + xxPrime1 + xxPrime2
+		v2 := seed + xxPrime2
+		v3 := seed
+		v4 := seed - xxPrime1
+		for ; len(s) >= 32; s = s[32:] {
+			v1 = xxRound(v1, load64(s))
+			v2 = xxRound(v2, load64(s[8:]))
+			v3 = xxRound(v3, load64(s[16:]))
+			v4 = xxRound(v4, load64(s[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) + bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		h = xxMerge(h, v1)
+		h = xxMerge(h, v2)
+		h = xxMerge(h, v3)
+		h = xxMerge(h, v4)
+	} else {
+		h = seed + xxPrime5
+	}
+	h += uint64(n)
+
+	for ; len(s) >= 8; s = s[8:] {
+		h ^= xxRound(0, load64(s))
+		h = bits.RotateLeft64(h, 27)*xxPrime1 + xxPrime4
+	}
+	if len(s) >= 4 {
+		h ^= uint64(load32(s)) * xxPrime1
+		h = bits.RotateLeft64(h, 23)*xxPrime2 + xxPrime3
+		s = s[4:]
+	}
+	for ; len(s) > 0; s = s[1:] {
+		h ^= uint64(s[0]) * xxPrime5
+		h = bits.RotateLeft64(h, 11) * xxPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxPrime2
+	h ^= h >> 29
+	h *= xxPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	return bits.RotateLeft64(acc, 31) * xxPrime1
+}
+
+func xxMerge(h, v uint64) uint64 {
+	h ^= xxRound(0, v)
+	return h*xxPrime1 + xxPrime4
+}
+
+// load64 reads 8 little-endian bytes of s without copying it
+func load64(s string) uint64 {
+	_ = s[7]
+	return uint64(s[0]) | uint64(s[1])<<8 | uint64(s[2])<<16 | uint64(s[3])<<24 |
+		uint64(s[4])<<32 | uint64(s[5])<<40 | uint64(s[6])<<48 | uint64(s[7])<<56
+}
+
+func load32(s string) uint32 {
+	_ = s[3]
+	return uint32(s[0]) | uint32(s[1])<<8 | uint32(s[2])<<16 | uint32(s[3])<<24
+}
@@ -1,11 +1,141 @@
 package vectorized
 
+import (
+	"fmt"
+	"math/bits"
+)
+
+// DefaultBatchSize is the number of rows per batch when none is given
+const DefaultBatchSize = 1024
+
+// DataType is the type of the values in a Vector
+type DataType int
+
+const (
+	TypeInt64 DataType = iota
+	TypeFloat64
+	TypeString
+	TypeBool
+)
+
+func (t DataType) String() string {
+	switch t {
+	case TypeInt64:
+		return "INT64"
+	case TypeFloat64:
+		return "FLOAT64"
+	case TypeString:
+		return "STRING"
+	case TypeBool:
+		return "BOOL"
+	default:
+		return fmt.Sprintf("DataType(%d)", int(t))
+	}
+}
+
 // Vector represents a batch of values
 type Vector struct {
-	data     interface{}  // []int64, []float64, []string, etc.
-	nulls    *Bitmap
+	kind     VectorKind
+	typ      DataType
+	data     interface{} // []int64, []float64, []string or []bool
+	nulls    *Bitmap     // nil if no value is NULL
 	size     int
 	capacity int
+
+	// ComputedVector only
+	eval func(sel []int, out *Vector)
+	buf  *Vector // flat output of eval, allocated on first use
+}
+
+// NewInt64Vector wraps values in a flat vector without copying
+func NewInt64Vector(values []int64) *Vector {
+	return &Vector{typ: TypeInt64, data: values, size: len(values), capacity: cap(values)}
+}
+
+// NewFloat64Vector wraps values in a flat vector without copying
+func NewFloat64Vector(values []float64) *Vector {
+	return &Vector{typ: TypeFloat64, data: values, size: len(values), capacity: cap(values)}
+}
+
+// NewStringVector wraps values in a flat vector without copying
+func NewStringVector(values []string) *Vector {
+	return &Vector{typ: TypeString, data: values, size: len(values), capacity: cap(values)}
+}
+
+// NewBoolVector wraps values in a flat vector without copying
+func NewBoolVector(values []bool) *Vector {
+	return &Vector{typ: TypeBool, data: values, size: len(values), capacity: cap(values)}
+}
+
+// newFlatVector allocates a flat vector of size zero values
+func newFlatVector(typ DataType, size int) *Vector {
+	switch typ {
+	case TypeInt64:
+		return NewInt64Vector(make([]int64, size))
+	case TypeFloat64:
+		return NewFloat64Vector(make([]float64, size))
+	case TypeString:
+		return NewStringVector(make([]string, size))
+	case TypeBool:
+		return NewBoolVector(make([]bool, size))
+	default:
+		panic(fmt.Sprintf("vectorized: unsupported type %s", typ))
+	}
+}
+
+// Kind returns how the vector stores its values
+func (v *Vector) Kind() VectorKind { return v.kind }
+
+// Type returns the type of the vector's values
+func (v *Vector) Type() DataType { return v.typ }
+
+// Len returns the number of rows
+func (v *Vector) Len() int { return v.size }
+
+// SetNull marks row i as NULL
+func (v *Vector) SetNull(i int) {
+	if v.nulls == nil {
+		v.nulls = NewBitmap(v.size)
+	}
+	v.nulls.Set(i)
+}
+
+// IsNull reports whether row i is NULL
+func (v *Vector) IsNull(i int) bool {
+	if v.kind == ComputedVector {
+		return v.Flatten([]int{i}).IsNull(i)
+	}
+	if v.nulls == nil {
+		return false
+	}
+	if v.kind == ConstantVector {
+		return v.nulls.Get(0)
+	}
+	return v.nulls.Get(i)
+}
+
+// Get returns the value of row i, or nil if it is NULL
+func (v *Vector) Get(i int) interface{} {
+	if v.kind == ComputedVector {
+		return v.Flatten([]int{i}).Get(i)
+	}
+	if v.IsNull(i) {
+		return nil
+	}
+	if v.kind == ConstantVector {
+		i = 0
+	}
+	switch data := v.data.(type) {
+	case []int64:
+		return data[i]
+	case []float64:
+		return data[i]
+	case []string:
+		return data[i]
+	case []bool:
+		return data[i]
+	}
+	return nil
 }
 
 // Bitmap for NULL values and selections
@@ -14,10 +144,107 @@ type Bitmap struct {
 	size int
 }
 
-// VectorBatch is a batch of rows (columnar format)
+// NewBitmap creates a bitmap of size bits, all clear
+func NewBitmap(size int) *Bitmap {
+	return &Bitmap{bits: make([]uint64, (size+63)/64), size: size}
+}
+
+// Set sets bit i
+func (b *Bitmap) Set(i int) {
+	b.bits[i/64] |= 1 << (i % 64)
+}
+
+// Clear clears bit i
+func (b *Bitmap) Clear(i int) {
+	b.bits[i/64] &^= 1 << (i % 64)
+}
+
+// Get reports whether bit i is set
+func (b *Bitmap) Get(i int) bool {
+	return b.bits[i/64]&(1<<(i%64)) != 0
+}
+
+// Count returns the number of set bits
+func (b *Bitmap) Count() int {
+	n := 0
+	for _, word := range b.bits {
+		n += bits.OnesCount64(word)
+	}
+	return n
+}
+
+// Size returns the number of bits
+func (b *Bitmap) Size() int { return b.size }
+
+// VectorBatch is a batch of rows (columnar format). A filtered batch keeps
+// its columns and lists the surviving rows in a selection vector.
 type VectorBatch struct {
-	columns []*Vector
-	size    int
+	columns   []*Vector
+	size      int
+	selection []int // selected row positions, ascending; nil selects all
+}
+
+// NewVectorBatch creates a batch from columns of equal length
+func NewVectorBatch(columns ...*Vector) *VectorBatch {
+	size := 0
+	if len(columns) > 0 {
+		size = columns[0].Len()
+	}
+	return &VectorBatch{columns: columns, size: size}
+}
+
+// Column returns column i
+func (b *VectorBatch) Column(i int) *Vector { return b.columns[i] }
+
+// NumColumns returns the number of columns
+func (b *VectorBatch) NumColumns() int { return len(b.columns) }
+
+// Size returns the number of rows in the columns, selected or not
+func (b *VectorBatch) Size() int { return b.size }
+
+// Selection returns the selected row positions, or nil if all are selected
+func (b *VectorBatch) Selection() []int { return b.selection }
+
+// Count returns the number of selected rows
+func (b *VectorBatch) Count() int {
+	if b.selection == nil {
+		return b.size
+	}
+	return len(b.selection)
+}
+
+// Rows returns the selected rows as values, for tests and result output
+func (b *VectorBatch) Rows() [][]interface{} {
+	rows := make([][]interface{}, 0, b.Count())
+	// Evaluate computed columns once for the whole selection
+	flat := make([]*Vector, len(b.columns))
+	for c, column := range b.columns {
+		flat[c] = column
+		if column.kind == ComputedVector {
+			flat[c] = column.Flatten(b.selection)
+		}
+	}
+	forEachRow(b.selection, b.size, func(i int) {
+		row := make([]interface{}, len(flat))
+		for c, column := range flat {
+			row[c] = column.Get(i)
+		}
+		rows = append(rows, row)
+	})
+	return rows
+}
+
+// forEachRow calls fn with each row in sel, or every row if sel is nil
+func forEachRow(sel []int, size int, fn func(i int)) {
+	if sel == nil {
+		for i := range size {
+			fn(i)
+		}
+		return
+	}
+	for _, i := range sel {
+		fn(i)
+	}
 }
 
 // VectorOperator processes batches
@@ -31,23 +258,192 @@ type VectorizedScan struct {
 	data      [][]interface{}
 	position  int
 	batchSize int
+	types     []DataType
+}
+
+// NewVectorizedScan scans rows of values, batchSize rows at a time. Column
+// types are taken from the non-NULL values in each column; int values are
+// read as INT64, and a column mixing integers with float64 values is read
+// as FLOAT64. It panics on a column mixing other types.
+func NewVectorizedScan(data [][]interface{}, batchSize int) *VectorizedScan {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	return &VectorizedScan{data: data, batchSize: batchSize, types: inferTypes(data)}
+}
+
+func inferTypes(data [][]interface{}) []DataType {
+	if len(data) == 0 {
+		return nil
+	}
+	types := make([]DataType, len(data[0]))
+	for c := range types {
+		seen := false
+		for _, row := range data {
+			if row[c] == nil {
+				continue
+			}
+			typ := typeOf(row[c])
+			switch {
+			case !seen || typ == types[c]:
+				types[c], seen = typ, true
+			case isNumeric(typ) && isNumeric(types[c]):
+				types[c] = TypeFloat64
+			default:
+				panic(fmt.Sprintf("vectorized: column %d mixes %s and %s values", c, types[c], typ))
+			}
+		}
+	}
+	return types
+}
+
+func typeOf(value interface{}) DataType {
+	switch value.(type) {
+	case int, int64:
+		return TypeInt64
+	case float64:
+		return TypeFloat64
+	case string:
+		return TypeString
+	case bool:
+		return TypeBool
+	default:
+		panic(fmt.Sprintf("vectorized: unsupported value %T", value))
+	}
 }
 
 func (s *VectorizedScan) Next() *VectorBatch {
-	// TODO: Return next batch of rows
-	return nil
+	if s.position >= len(s.data) {
+		return nil
+	}
+	rows := s.data[s.position:min(s.position+s.batchSize, len(s.data))]
+	s.position += len(rows)
+
+	columns := make([]*Vector, len(s.types))
+	for c, typ := range s.types {
+		v := newFlatVector(typ, len(rows))
+		for i, row := range rows {
+			if row[c] == nil {
+				v.SetNull(i)
+				continue
+			}
+			v.set(i, row[c])
+		}
+		columns[c] = v
+	}
+	return NewVectorBatch(columns...)
+}
+
+// set stores value at row i of a flat vector
+func (v *Vector) set(i int, value interface{}) {
+	switch data := v.data.(type) {
+	case []int64:
+		switch x := value.(type) {
+		case int:
+			data[i] = int64(x)
+		case int64:
+			data[i] = x
+		default:
+			panic(fmt.Sprintf("vectorized: %T value in %s vector", value, v.typ))
+		}
+	case []float64:
+		switch x := value.(type) {
+		case float64:
+			data[i] = x
+		case int:
+			data[i] = float64(x)
+		case int64:
+			data[i] = float64(x)
+		default:
+			panic(fmt.Sprintf("vectorized: %T value in %s vector", value, v.typ))
+		}
+	case []string:
+		data[i] = value.(string)
+	case []bool:
+		data[i] = value.(bool)
+	}
+}
+
+func (s *VectorizedScan) Reset() {
+	s.position = 0
 }
 
 // VectorizedFilter filters rows using vectorized predicate
 type VectorizedFilter struct {
 	child     VectorOperator
 	predicate func(*Vector) *Bitmap
+	condition Expression
+}
+
+// NewVectorizedFilter keeps the rows whose bit predicate sets when given
+// the batch's first column
+func NewVectorizedFilter(child VectorOperator, predicate func(*Vector) *Bitmap) *VectorizedFilter {
+	return &VectorizedFilter{child: child, predicate: predicate}
+}
+
+// NewConditionFilter keeps the rows for which the BOOL expression
+// condition is true; NULL counts as false
+func NewConditionFilter(child VectorOperator, condition Expression) *VectorizedFilter {
+	return &VectorizedFilter{child: child, condition: condition}
 }
 
 func (f *VectorizedFilter) Next() *VectorBatch {
-	// TODO: Apply filter to entire batch
-	// Use selection vector for efficiency
-	return nil
+	for {
+		batch := f.child.Next()
+		if batch == nil {
+			return nil
+		}
+		var selection []int
+		if f.condition != nil {
+			selection = selectTrue(f.condition.Evaluate(batch), batch)
+		} else {
+			column := batch.columns[0].Flatten(batch.selection)
+			selected := f.predicate(column)
+			selection = make([]int, 0, batch.Count())
+			forEachRow(batch.selection, batch.size, func(i int) {
+				if selected.Get(i) {
+					selection = append(selection, i)
+				}
+			})
+		}
+		if len(selection) > 0 {
+			return &VectorBatch{columns: batch.columns, size: batch.size, selection: selection}
+		}
+	}
+}
+
+// selectTrue returns the selected rows of batch where cond is true
+func selectTrue(cond *Vector, batch *VectorBatch) []int {
+	if cond.typ != TypeBool {
+		panic(fmt.Sprintf("vectorized: filter condition is %s, not BOOL", cond.typ))
+	}
+	if cond.kind == ConstantVector {
+		if cond.IsNull(0) || !cond.data.([]bool)[0] {
+			return nil
+		}
+		if batch.selection != nil {
+			return batch.selection
+		}
+		selection := make([]int, batch.size)
+		for i := range selection {
+			selection[i] = i
+		}
+		return selection
+	}
+
+	cond = cond.Flatten(batch.selection)
+	values := cond.data.([]bool)
+	selection := make([]int, 0, batch.Count())
+	forEachRow(batch.selection, batch.size, func(i int) {
+		if values[i] && !cond.IsNull(i) {
+			selection = append(selection, i)
+		}
+	})
+	return selection
+}
+
+func (f *VectorizedFilter) Reset() {
+	f.child.Reset()
 }
 
 // VectorizedProject projects columns
@@ -56,6 +452,29 @@ type VectorizedProject struct {
 	expressions []Expression
 }
 
+// NewVectorizedProject outputs one column per expression. Literals become
+// constant vectors and computed expressions are evaluated only when their
+// values are read, for the rows that are still selected.
+func NewVectorizedProject(child VectorOperator, expressions ...Expression) *VectorizedProject {
+	return &VectorizedProject{child: child, expressions: expressions}
+}
+
+func (p *VectorizedProject) Next() *VectorBatch {
+	batch := p.child.Next()
+	if batch == nil {
+		return nil
+	}
+	columns := make([]*Vector, len(p.expressions))
+	for i, expr := range p.expressions {
+		columns[i] = expr.Evaluate(batch)
+	}
+	return &VectorBatch{columns: columns, size: batch.size, selection: batch.selection}
+}
+
+func (p *VectorizedProject) Reset() {
+	p.child.Reset()
+}
+
 type Expression interface {
 	Evaluate(batch *VectorBatch) *Vector
 }
@@ -79,15 +498,20 @@ type AggState struct {
 
 // SIMD-friendly operations
 func AddInt64(a, b, result []int64) {
-	// TODO: Vectorized addition
 	// Compiler may auto-vectorize simple loops
 	for i := range a {
 		result[i] = a[i] + b[i]
 	}
 }
 
+// AddConstInt64 adds c to every value of a without materializing c
+func AddConstInt64(a []int64, c int64, result []int64) {
+	for i := range a {
+		result[i] = a[i] + c
+	}
+}
+
 func FilterGreaterThanInt64(data []int64, threshold int64, selection *Bitmap) {
-	// TODO: Vectorized comparison
 	for i, v := range data {
 		if v > threshold {
 			selection.Set(i)
@@ -95,6 +519,6 @@ func FilterGreaterThanInt64(data []int64, threshold int64, selection *Bitmap) {
 	}
 }
 
-// TODO: Implement vectorized operators
+// TODO: Implement vectorized aggregation
 // TODO: Add type-specific fast paths
 // TODO: Profile and optimize hot paths
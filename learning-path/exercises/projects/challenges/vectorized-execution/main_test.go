@@ -1,10 +1,51 @@
 package vectorized
 
-import "testing"
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// testRows returns n rows of (id, id % 10, name)
+func testRows(n int) [][]interface{} {
+	names := []string{"ann", "bob", "cy"}
+	rows := make([][]interface{}, n)
+	for i := range rows {
+		rows[i] = []interface{}{int64(i), int64(i % 10), names[i%len(names)]}
+	}
+	return rows
+}
+
+// drain returns every row produced by op
+func drain(op VectorOperator) [][]interface{} {
+	var rows [][]interface{}
+	for batch := op.Next(); batch != nil; batch = op.Next() {
+		rows = append(rows, batch.Rows()...)
+	}
+	return rows
+}
 
 func TestVectorizedFilter(t *testing.T) {
-	// TODO: Test vectorized filter
-	t.Skip("not implemented")
+	scan := NewVectorizedScan(testRows(100), 16)
+	filter := NewVectorizedFilter(scan, func(v *Vector) *Bitmap {
+		selection := NewBitmap(v.Len())
+		FilterGreaterThanInt64(v.data.([]int64), 89, selection)
+		return selection
+	})
+	rows := drain(filter)
+	if len(rows) != 10 || rows[0][0] != int64(90) || rows[9][0] != int64(99) {
+		t.Fatalf("filter id > 89 = %v", rows)
+	}
+
+	// A condition filter on top narrows the selection further
+	filter.Reset()
+	cond := NewConditionFilter(filter, Comparison{Op: OpEq, Left: ColumnRef{2}, Right: Literal{"ann"}})
+	rows = drain(cond)
+	want := [][]interface{}{{int64(90), int64(0), "ann"}, {int64(93), int64(3), "ann"}, {int64(96), int64(6), "ann"}, {int64(99), int64(9), "ann"}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("filter id > 89 and name = 'ann' = %v", rows)
+	}
 }
 
 func TestVectorizedAggregate(t *testing.T) {
@@ -12,12 +53,305 @@ func TestVectorizedAggregate(t *testing.T) {
 	t.Skip("not implemented")
 }
 
+func TestConstantVector(t *testing.T) {
+	batch := NewVectorBatch(NewInt64Vector([]int64{1, 2, 3}))
+	five := Literal{int64(5)}.Evaluate(batch)
+	if five.Kind() != ConstantVector || five.Len() != 3 || len(five.data.([]int64)) != 1 {
+		t.Fatalf("literal = %s vector of %d rows storing %d values", five.Kind(), five.Len(), len(five.data.([]int64)))
+	}
+	if five.Get(2) != int64(5) {
+		t.Errorf("Get(2) = %v", five.Get(2))
+	}
+
+	// Expressions of constants fold into constants
+	folded := Arithmetic{Op: OpMul, Left: Literal{int64(2)}, Right: Literal{2.5}}.Evaluate(batch)
+	if folded.Kind() != ConstantVector || folded.Get(0) != 5.0 {
+		t.Errorf("2 * 2.5 = %s %v", folded.Kind(), folded.Get(0))
+	}
+	null := Arithmetic{Op: OpDiv, Left: Literal{int64(1)}, Right: Literal{int64(0)}}.Evaluate(batch)
+	if null.Kind() != ConstantVector || !null.IsNull(1) {
+		t.Errorf("1 / 0 = %s %v, want constant NULL", null.Kind(), null.Get(1))
+	}
+}
+
+// countingExpr wraps a column in a computed vector that counts the rows it
+// evaluates
+type countingExpr struct {
+	column    int
+	evaluated *int
+}
+
+func (c countingExpr) Evaluate(batch *VectorBatch) *Vector {
+	src := batch.columns[c.column]
+	return NewComputedVector(src.Type(), batch.size, func(sel []int, out *Vector) {
+		flat := src.Flatten(sel)
+		forEachRow(sel, out.size, func(i int) {
+			*c.evaluated++
+			out.data.([]int64)[i] = flat.data.([]int64)[i]
+			out.setValid(i)
+		})
+	})
+}
+
+func TestComputedVectorEvaluatesSelectedRows(t *testing.T) {
+	evaluated := 0
+	// SELECT id + 5, id * 2.0 WHERE id % 10 = 3
+	scan := NewVectorizedScan(testRows(100), 50)
+	filter := NewConditionFilter(scan, Comparison{Op: OpEq, Left: ColumnRef{1}, Right: Literal{int64(3)}})
+	project := NewVectorizedProject(filter,
+		Arithmetic{Op: OpAdd, Left: countingExpr{0, &evaluated}, Right: Literal{int64(5)}},
+		Arithmetic{Op: OpMul, Left: ColumnRef{0}, Right: Literal{2.0}},
+	)
+
+	batch := project.Next()
+	if kind := batch.Column(0).Kind(); kind != ComputedVector {
+		t.Fatalf("id + 5 is a %s vector", kind)
+	}
+	if evaluated != 0 {
+		t.Fatalf("%d rows evaluated before the values were read", evaluated)
+	}
+	rows := append(batch.Rows(), project.Next().Rows()...)
+	if evaluated != 10 {
+		t.Errorf("evaluated %d rows, want only the 10 selected", evaluated)
+	}
+	if len(rows) != 10 || rows[0][0] != int64(8) || rows[0][1] != 6.0 || rows[9][0] != int64(98) {
+		t.Errorf("rows = %v", rows)
+	}
+}
+
+func TestComputedVectorNulls(t *testing.T) {
+	scan := NewVectorizedScan([][]interface{}{{int64(4)}, {nil}, {int64(0)}}, 0)
+	project := NewVectorizedProject(scan,
+		Arithmetic{Op: OpDiv, Left: Literal{int64(8)}, Right: ColumnRef{0}},
+		Comparison{Op: OpGt, Left: ColumnRef{0}, Right: Literal{1.5}},
+	)
+	rows := project.Next().Rows()
+	want := [][]interface{}{{int64(2), true}, {nil, nil}, {nil, false}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+}
+
+func TestScanMixedNumbers(t *testing.T) {
+	// A float column with later ints, and an int column with a later float,
+	// both read as FLOAT64 across batches
+	scan := NewVectorizedScan([][]interface{}{
+		{1.5, 1, "a"},
+		{2, int64(2), "b"},
+		{int64(3), 2.5, nil},
+	}, 2)
+	var rows [][]interface{}
+	for batch := scan.Next(); batch != nil; batch = scan.Next() {
+		for c, typ := range []DataType{TypeFloat64, TypeFloat64, TypeString} {
+			if got := batch.Column(c).Type(); got != typ {
+				t.Fatalf("column %d type = %s, want %s", c, got, typ)
+			}
+		}
+		rows = append(rows, batch.Rows()...)
+	}
+	want := [][]interface{}{{1.5, 1.0, "a"}, {2.0, 2.0, "b"}, {3.0, 2.5, nil}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("scan of a column mixing strings and numbers did not panic")
+		}
+	}()
+	NewVectorizedScan([][]interface{}{{1}, {"one"}}, 0)
+}
+
+func TestProjectConstantAllocations(t *testing.T) {
+	values := make([]int64, DefaultBatchSize)
+	batch := NewVectorBatch(NewInt64Vector(values))
+	literal := Literal{int64(5)}
+
+	allocs := testing.AllocsPerRun(100, func() { literal.Evaluate(batch) })
+	if allocs > 4 {
+		t.Errorf("literal allocated %.0f times per batch", allocs)
+	}
+
+	// col + 5 reads the constant as a scalar: only the result is allocated
+	sum := Arithmetic{Op: OpAdd, Left: ColumnRef{0}, Right: literal}.Evaluate(batch)
+	sum.Flatten(nil)
+	allocs = testing.AllocsPerRun(100, func() { sum.Flatten(nil) })
+	if allocs != 0 {
+		t.Errorf("re-evaluating col + 5 allocated %.0f times", allocs)
+	}
+}
+
+func TestGatherScatter(t *testing.T) {
+	v := NewInt64Vector([]int64{10, 11, 12, 13})
+	v.SetNull(2)
+	g := Gather(v, []int{3, 2, 0, 3})
+	if got := []interface{}{g.Get(0), g.Get(1), g.Get(2), g.Get(3)}; !reflect.DeepEqual(got, []interface{}{int64(13), nil, int64(10), int64(13)}) {
+		t.Errorf("gather = %v", got)
+	}
+
+	// Scatter routes rows back, skipping negative positions and clearing
+	// the NULL flag of rows it overwrites with values
+	dst := NewInt64Vector(make([]int64, 4))
+	dst.SetNull(1)
+	Scatter(g, []int{1, 0, -1, 2}, dst)
+	if got := []interface{}{dst.Get(0), dst.Get(1), dst.Get(2), dst.Get(3)}; !reflect.DeepEqual(got, []interface{}{nil, int64(13), int64(13), int64(0)}) {
+		t.Errorf("scatter = %v", got)
+	}
+
+	c := Gather(NewConstantVector("x", 3), []int{0, 2})
+	if c.Kind() != ConstantVector || c.Len() != 2 || c.Get(1) != "x" {
+		t.Errorf("gathered constant = %s of %d rows", c.Kind(), c.Len())
+	}
+
+	evaluated := 0
+	batch := NewVectorBatch(NewInt64Vector([]int64{1, 2, 3, 4, 5}))
+	computed := countingExpr{0, &evaluated}.Evaluate(batch)
+	if g := Gather(computed, []int{4, 1}); g.Get(0) != int64(5) || g.Get(1) != int64(2) || evaluated != 2 {
+		t.Errorf("gathered computed = %v %v after evaluating %d rows", g.Get(0), g.Get(1), evaluated)
+	}
+}
+
+func TestHashString(t *testing.T) {
+	// Reference values of XXH64 with seed 0
+	for s, want := range map[string]uint64{
+		"":    0xef46db3751d8e999,
+		"a":   0xd24ec4f1a98c6e5b,
+		"abc": 0x44bc2cf5ad770999,
+		"Nobody inspects the spammish repetition": 0xfbcea83c8a378bf1,
+	} {
+		if got := hashString(s, 0); got != want {
+			t.Errorf("XXH64(%q) = %#x, want %#x", s, got, want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	const seed = 42
+	hashOf := func(v *Vector) []uint64 {
+		hashes := make([]uint64, v.Len())
+		Hash(v, nil, seed, hashes)
+		return hashes
+	}
+
+	flat := hashOf(NewFloat64Vector([]float64{0, math.Copysign(0, -1), math.NaN(), -math.NaN(), 1.5}))
+	if flat[0] != flat[1] || flat[2] != flat[3] || flat[0] == flat[4] {
+		t.Errorf("float hashes = %x", flat)
+	}
+	strs := NewStringVector([]string{"ann", "bob", "ann", ""})
+	strs.SetNull(3)
+	h := hashOf(strs)
+	if h[0] != h[2] || h[0] == h[1] || h[3] == hashString("", seed) {
+		t.Errorf("string hashes = %x", h)
+	}
+	// A constant hashes like the same value in a flat vector
+	if c := hashOf(NewConstantVector("ann", 2)); c[1] != h[0] {
+		t.Errorf("constant hash %x, flat %x", c[1], h[0])
+	}
+	if n := hashOf(NewNullConstantVector(TypeString, 1)); n[0] != h[3] {
+		t.Errorf("NULL constant hash %x, flat %x", n[0], h[3])
+	}
+
+	// Only selected rows are hashed
+	sel := make([]uint64, 4)
+	Hash(strs, []int{1}, seed, sel)
+	if sel[0] != 0 || sel[1] != h[1] || sel[2] != 0 {
+		t.Errorf("selected hashes = %x", sel)
+	}
+
+	// Combined keys: (1, "a") and ("a", 1) differ, and equal rows match
+	ids := NewInt64Vector([]int64{1, 1, 2})
+	names := NewStringVector([]string{"a", "a", "a"})
+	keys := make([]uint64, 3)
+	Hash(ids, nil, seed, keys)
+	HashCombine(names, nil, keys)
+	swapped := make([]uint64, 3)
+	Hash(names, nil, seed, swapped)
+	HashCombine(ids, nil, swapped)
+	if keys[0] != keys[1] || keys[0] == keys[2] || keys[0] == swapped[0] {
+		t.Errorf("combined hashes = %x, swapped %x", keys, swapped)
+	}
+}
+
 func BenchmarkVectorizedFilter(b *testing.B) {
 	// TODO: Benchmark filter performance
 	b.Skip("not implemented")
 }
 
+func BenchmarkVectorizedProject(b *testing.B) {
+	values := make([]int64, DefaultBatchSize)
+	for i := range values {
+		values[i] = int64(i)
+	}
+	batch := NewVectorBatch(NewInt64Vector(values))
+	expr := Arithmetic{Op: OpAdd, Left: ColumnRef{0}, Right: Literal{int64(5)}}
+	b.ReportAllocs()
+	for range b.N {
+		expr.Evaluate(batch).Flatten(nil)
+	}
+}
+
 func BenchmarkVsRowOriented(b *testing.B) {
 	// TODO: Compare to row-at-a-time
 	b.Skip("not implemented")
 }
+
+// benchInt64s returns a column of DefaultBatchSize values and a shuffled
+// index vector over it
+func benchInt64s() (*Vector, []int) {
+	values := make([]int64, DefaultBatchSize)
+	indexes := make([]int, DefaultBatchSize)
+	for i := range values {
+		values[i] = int64(i)
+		indexes[i] = i * 7919 % DefaultBatchSize
+	}
+	return NewInt64Vector(values), indexes
+}
+
+func BenchmarkGather(b *testing.B) {
+	v, indexes := benchInt64s()
+	b.ReportAllocs()
+	for range b.N {
+		Gather(v, indexes)
+	}
+}
+
+func BenchmarkScatter(b *testing.B) {
+	v, positions := benchInt64s()
+	dst := NewInt64Vector(make([]int64, DefaultBatchSize))
+	b.ReportAllocs()
+	for range b.N {
+		Scatter(v, positions, dst)
+	}
+}
+
+func BenchmarkHashInt64(b *testing.B) {
+	v, _ := benchInt64s()
+	hashes := make([]uint64, DefaultBatchSize)
+	b.ReportAllocs()
+	for range b.N {
+		Hash(v, nil, 0, hashes)
+	}
+}
+
+func BenchmarkHashString(b *testing.B) {
+	values := make([]string, DefaultBatchSize)
+	for i := range values {
+		values[i] = fmt.Sprintf("customer-%08d", i)
+	}
+	v := NewStringVector(values)
+	hashes := make([]uint64, DefaultBatchSize)
+	b.ReportAllocs()
+	for range b.N {
+		Hash(v, nil, 0, hashes)
+	}
+}
+
+func BenchmarkHashCombine(b *testing.B) {
+	v, _ := benchInt64s()
+	hashes := make([]uint64, DefaultBatchSize)
+	b.ReportAllocs()
+	for range b.N {
+		Hash(v, nil, 0, hashes)
+		HashCombine(v, nil, hashes)
+	}
+}
@@ -0,0 +1,126 @@
+package vectorized
+
+import "fmt"
+
+// VectorKind tells how a Vector stores its values
+type VectorKind int
+
+const (
+	// FlatVector stores one value per row
+	FlatVector VectorKind = iota
+	// ConstantVector stores a single value shared by every row, so a
+	// literal costs the same for any batch size
+	ConstantVector
+	// ComputedVector stores no values; they are computed when read, and
+	// only for the rows that are read
+	ComputedVector
+)
+
+func (k VectorKind) String() string {
+	switch k {
+	case FlatVector:
+		return "flat"
+	case ConstantVector:
+		return "constant"
+	case ComputedVector:
+		return "computed"
+	default:
+		return fmt.Sprintf("VectorKind(%d)", int(k))
+	}
+}
+
+// NewConstantVector returns a vector of size rows that all hold value, an
+// int, int64, float64, string or bool
+func NewConstantVector(value interface{}, size int) *Vector {
+	v := newFlatVector(typeOf(value), 1)
+	v.set(0, value)
+	v.kind = ConstantVector
+	v.size = size
+	return v
+}
+
+// NewNullConstantVector returns a vector of size NULLs of type typ
+func NewNullConstantVector(typ DataType, size int) *Vector {
+	v := newFlatVector(typ, 1)
+	v.SetNull(0)
+	v.kind = ConstantVector
+	v.size = size
+	return v
+}
+
+// NewComputedVector returns a vector of size rows whose values are produced
+// by eval. eval is given the rows to compute (nil for all of them) and a
+// flat vector of size rows, and must set the value or NULL of each of
+// those rows in it; other rows are left as they are.
+func NewComputedVector(typ DataType, size int, eval func(sel []int, out *Vector)) *Vector {
+	return &Vector{kind: ComputedVector, typ: typ, size: size, eval: eval}
+}
+
+// Flatten returns a flat vector holding the values of the rows in sel, or
+// of every row if sel is nil. A flat vector returns itself. For the other
+// kinds the values of rows outside sel are unspecified, and the result of
+// a computed vector is reused by its next Flatten.
+func (v *Vector) Flatten(sel []int) *Vector {
+	switch v.kind {
+	case ConstantVector:
+		out := newFlatVector(v.typ, v.size)
+		null := v.IsNull(0)
+		forEachRow(sel, v.size, func(i int) {
+			if null {
+				out.SetNull(i)
+			} else {
+				out.set(i, v.Get(0))
+			}
+		})
+		return out
+	case ComputedVector:
+		if v.buf == nil {
+			v.buf = newFlatVector(v.typ, v.size)
+		}
+		v.eval(sel, v.buf)
+		return v.buf
+	default:
+		return v
+	}
+}
+
+// setValid clears the NULL flag of row i, which eval must do for rows it
+// sets since a computed vector's buffer is reused
+func (v *Vector) setValid(i int) {
+	if v.nulls != nil {
+		v.nulls.Clear(i)
+	}
+}
+
+// operand reads one side of a binary expression without materializing a
+// constant
+type operand[T any] struct {
+	values   []T
+	value    T
+	constant bool
+	vec      *Vector
+}
+
+// newOperand flattens v for the rows in sel unless it is constant
+func newOperand[T any](v *Vector, sel []int) operand[T] {
+	if v.kind == ConstantVector {
+		return operand[T]{value: v.data.([]T)[0], constant: true, vec: v}
+	}
+	v = v.Flatten(sel)
+	return operand[T]{values: v.data.([]T), vec: v}
+}
+
+func (o operand[T]) at(i int) T {
+	if o.constant {
+		return o.value
+	}
+	return o.values[i]
+}
+
+func (o operand[T]) isNull(i int) bool {
+	return o.vec.IsNull(i)
+}
+
+func (o operand[T]) hasNulls() bool {
+	return o.vec.nulls != nil
+}
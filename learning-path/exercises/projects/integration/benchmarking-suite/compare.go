@@ -0,0 +1,160 @@
+package benchmarking
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// CompareOptions sets how much worse a metric may get before Compare calls
+// it a regression
+type CompareOptions struct {
+	// Tolerance is the relative change allowed in throughput, allocs/op
+	// and B/op; defaults to 10%
+	Tolerance float64
+	// RuntimeTolerance is the relative change allowed in peak RSS and GC
+	// pause per op, which vary more from run to run; defaults to 50%
+	RuntimeTolerance float64
+}
+
+func (o CompareOptions) withDefaults() CompareOptions {
+	if o.Tolerance <= 0 {
+		o.Tolerance = 0.10
+	}
+	if o.RuntimeTolerance <= 0 {
+		o.RuntimeTolerance = 0.50
+	}
+	return o
+}
+
+// Delta is one metric of a benchmark in the baseline and the current run
+type Delta struct {
+	Metric    string
+	Old, New  float64
+	Change    float64 // (New-Old)/Old; +Inf if Old is 0 and New is not
+	Regressed bool
+}
+
+// Comparison is how one benchmark changed between two runs
+type Comparison struct {
+	Name   string
+	Deltas []Delta
+}
+
+// Regressed reports whether any metric of the benchmark regressed
+func (c Comparison) Regressed() bool {
+	for _, d := range c.Deltas {
+		if d.Regressed {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare matches the results of current with those of baseline by name
+// and compares the metrics both measured: throughput, and for registered
+// benchmarks allocs/op, B/op, GC pause per op and peak RSS. A drop in
+// throughput or a rise in any of the others beyond the tolerance is a
+// regression. Allocs/op and B/op are compared as whole numbers, as go test
+// reports them, so amortized growth of a slice is not a regression.
+// Benchmarks missing from either run are left out.
+func Compare(baseline, current []BenchmarkResult, opts CompareOptions) []Comparison {
+	opts = opts.withDefaults()
+	old := make(map[string]BenchmarkResult, len(baseline))
+	for _, r := range baseline {
+		old[r.Name] = r
+	}
+
+	var comparisons []Comparison
+	for _, cur := range current {
+		prev, ok := old[cur.Name]
+		if !ok {
+			continue
+		}
+		c := Comparison{Name: cur.Name}
+		// higher is whether a larger value is better
+		add := func(metric string, o, n, tolerance float64, higher bool) {
+			d := Delta{Metric: metric, Old: o, New: n, Change: change(o, n)}
+			if higher {
+				d.Regressed = d.Change < -tolerance
+			} else {
+				d.Regressed = d.Change > tolerance
+			}
+			c.Deltas = append(c.Deltas, d)
+		}
+		add("ops/sec", prev.Throughput, cur.Throughput, opts.Tolerance, true)
+		if pm, cm := prev.Memory, cur.Memory; pm.Ops > 0 && cm.Ops > 0 {
+			add("allocs/op", math.Floor(pm.AllocsPerOp), math.Floor(cm.AllocsPerOp), opts.Tolerance, false)
+			add("B/op", math.Floor(pm.BytesPerOp), math.Floor(cm.BytesPerOp), opts.Tolerance, false)
+			add("gc-pause/op", pauseNsPerOp(pm), pauseNsPerOp(cm), opts.RuntimeTolerance, false)
+			if pm.PeakRSS > 0 && cm.PeakRSS > 0 {
+				add("peak-rss", float64(pm.PeakRSS), float64(cm.PeakRSS), opts.RuntimeTolerance, false)
+			}
+		}
+		comparisons = append(comparisons, c)
+	}
+	return comparisons
+}
+
+func change(o, n float64) float64 {
+	switch {
+	case o == n:
+		return 0
+	case o == 0:
+		return math.Inf(1)
+	}
+	return (n - o) / o
+}
+
+func pauseNsPerOp(m MemoryStats) float64 {
+	return float64(m.GCPause.Nanoseconds()) / float64(m.Ops)
+}
+
+// FormatComparison renders comparisons as a table with a row per metric,
+// marking regressions
+func FormatComparison(comparisons []Comparison) string {
+	var sb strings.Builder
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Benchmark\tMetric\tOld\tNew\tChange\t")
+	for _, c := range comparisons {
+		name := c.Name
+		for _, d := range c.Deltas {
+			mark := ""
+			if d.Regressed {
+				mark = "REGRESSED"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%+.1f%%\t%s\n", name, d.Metric,
+				formatMetric(d.Metric, d.Old), formatMetric(d.Metric, d.New), 100*d.Change, mark)
+			name = ""
+		}
+	}
+	tw.Flush()
+	return sb.String()
+}
+
+func formatMetric(metric string, v float64) string {
+	switch metric {
+	case "gc-pause/op":
+		return round(time.Duration(v)).String()
+	case "peak-rss":
+		return formatBytes(int64(v))
+	}
+	return fmt.Sprintf("%.0f", v)
+}
+
+// formatBytes renders n in the largest binary unit that keeps it >= 1
+func formatBytes(n int64) string {
+	const units = "KMGT"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v := float64(n)
+	i := -1
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", v, units[i])
+}
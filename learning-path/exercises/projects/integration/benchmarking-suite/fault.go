@@ -0,0 +1,237 @@
+package benchmarking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Fault is a failure injected into a phase, At a time after the phase
+// starts. It is either a fault at a named point, active for Duration, or a
+// crash that blocks new operations until Restart returns.
+//
+// Operations reach a fault point by calling InjectFault, e.g. just before
+// an fsync or a page write:
+//
+//	{Name: "fsync-stall", Point: "wal.fsync", At: 2 * time.Second, Duration: time.Second, Stall: 50 * time.Millisecond}
+//	{Name: "disk-full", Point: "pages.write", At: 2 * time.Second, Duration: time.Second, Err: syscall.ENOSPC}
+//	{Name: "crash", At: 2 * time.Second, Restart: reopen}
+type Fault struct {
+	Name string
+	At   time.Duration
+
+	// Point is the fault point the fault strikes. While the fault is
+	// active, InjectFault at the point sleeps for Stall and returns Err.
+	Point    string
+	Duration time.Duration
+	Stall    time.Duration
+	Err      error
+
+	// Restart simulates a crash and restart, for example by dropping a
+	// database without closing it and opening it again. Operations in
+	// flight finish first; new ones wait until Restart returns.
+	Restart func(ctx context.Context) error
+}
+
+// FaultResult is the impact of one fault
+type FaultResult struct {
+	Name string
+	// Injected is false if the phase ended before the fault was due
+	Injected bool
+	Start    time.Duration // since the scenario started
+	// Active is how long the fault lasted, including a restart
+	Active time.Duration
+	// TimeToRecover is the time from the injection until the first
+	// operation that succeeded after the fault cleared, like a recovery
+	// time objective. Recovered is false if none did before the phase
+	// ended.
+	TimeToRecover time.Duration
+	Recovered     bool
+	// ThroughputBefore and ThroughputDuring are successful ops/sec in the
+	// phase before the fault and while it was active
+	ThroughputBefore float64
+	ThroughputDuring float64
+	// Errors counts the operations that failed while the fault was active
+	Errors int64
+	// Err is the error Restart returned
+	Err error
+}
+
+type faultsKey struct{}
+
+// InjectFault is a fault point. While a fault at point is active in the
+// scenario running ctx, it sleeps for the fault's Stall and returns its
+// Err; otherwise, and outside a scenario, it returns nil at once.
+func InjectFault(ctx context.Context, point string) error {
+	fs, ok := ctx.Value(faultsKey{}).(*faultSet)
+	if !ok || fs.pending.Load() == 0 {
+		return nil
+	}
+	var stall time.Duration
+	var err error
+	fs.mu.Lock()
+	for _, f := range fs.faults {
+		if f.Point == point && f.active() {
+			stall = max(stall, f.Stall)
+			if err == nil {
+				err = f.Err
+			}
+		}
+	}
+	fs.mu.Unlock()
+
+	if stall > 0 {
+		timer := time.NewTimer(stall)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return err
+}
+
+// faultSet runs the faults of one phase and tracks their impact
+type faultSet struct {
+	start time.Time
+	// gate is held for reading by each operation and for writing by a
+	// crash while it restarts
+	gate      sync.RWMutex
+	successes atomic.Int64
+	// pending counts the faults that are not yet recovered, so operations
+	// skip the bookkeeping when there are none
+	pending atomic.Int32
+
+	mu     sync.Mutex
+	faults []*faultState
+}
+
+type faultState struct {
+	Fault
+	started, cleared, recovered time.Time
+	successesAtStart            int64
+	successesAtClear            int64
+	errors                      int64
+	err                         error
+}
+
+func (f *faultState) active() bool {
+	return !f.started.IsZero() && f.cleared.IsZero()
+}
+
+func newFaultSet(faults []Fault, start time.Time) *faultSet {
+	fs := &faultSet{start: start}
+	for _, f := range faults {
+		fs.faults = append(fs.faults, &faultState{Fault: f})
+	}
+	fs.pending.Store(int32(len(faults)))
+	return fs
+}
+
+// observe accounts for an operation admitted past the gate at admitted
+// that finished at end
+func (fs *faultSet) observe(admitted, end time.Time, err error) {
+	if err == nil {
+		fs.successes.Add(1)
+	}
+	if fs.pending.Load() == 0 {
+		return
+	}
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for _, f := range fs.faults {
+		switch {
+		case f.active() && err != nil:
+			f.errors++
+		case !f.cleared.IsZero() && f.recovered.IsZero() && err == nil && !admitted.Before(f.cleared):
+			f.recovered = end
+			fs.pending.Add(-1)
+		}
+	}
+}
+
+// run injects f at its time and clears it when it is over or the phase
+// ends, recording it as an event
+func (fs *faultSet) run(ctx context.Context, r *recorder, f *faultState) {
+	timer := time.NewTimer(time.Until(fs.start.Add(f.At)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		fs.pending.Add(-1)
+		return
+	}
+
+	fs.mu.Lock()
+	f.started = time.Now()
+	f.successesAtStart = fs.successes.Load()
+	fs.mu.Unlock()
+
+	var err error
+	if f.Restart != nil {
+		fs.gate.Lock()
+		err = f.Restart(ctx)
+		fs.gate.Unlock()
+	} else {
+		wait := time.NewTimer(f.Duration)
+		select {
+		case <-wait.C:
+		case <-ctx.Done():
+			wait.Stop()
+		}
+	}
+
+	fs.mu.Lock()
+	f.cleared = time.Now()
+	f.successesAtClear = fs.successes.Load()
+	f.err = err
+	fs.mu.Unlock()
+	r.event(Event{Kind: f.Name, Start: f.started.Sub(r.start), Duration: f.cleared.Sub(f.started), Err: err})
+}
+
+// results returns the impact of each fault. Call it once the phase's
+// workers and faults have stopped.
+func (fs *faultSet) results(r *recorder) []FaultResult {
+	var results []FaultResult
+	for _, f := range fs.faults {
+		result := FaultResult{Name: f.Name, Injected: !f.started.IsZero()}
+		if !result.Injected {
+			results = append(results, result)
+			continue
+		}
+		result.Start = f.started.Sub(r.start)
+		result.Active = f.cleared.Sub(f.started)
+		result.Errors = f.errors
+		result.Err = f.err
+		if !f.recovered.IsZero() {
+			result.Recovered = true
+			result.TimeToRecover = f.recovered.Sub(f.started)
+		}
+		if before := f.started.Sub(fs.start); before > 0 {
+			result.ThroughputBefore = float64(f.successesAtStart) / before.Seconds()
+		}
+		if result.Active > 0 {
+			result.ThroughputDuring = float64(f.successesAtClear-f.successesAtStart) / result.Active.Seconds()
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (f *Fault) validate() error {
+	switch {
+	case f.Name == "":
+		return errors.New("fault needs a name")
+	case f.At < 0:
+		return fmt.Errorf("fault %q: At must not be negative", f.Name)
+	case f.Restart != nil && f.Point != "":
+		return fmt.Errorf("fault %q: a crash has no fault point", f.Name)
+	case f.Restart == nil && (f.Point == "" || f.Duration <= 0):
+		return fmt.Errorf("fault %q needs a Point and a Duration, or a Restart func", f.Name)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package benchmarking
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Each power of two is split into 2^subBucketBits linear sub-buckets, so a
+// recorded value is off by at most 1/16 of its magnitude
+const (
+	subBucketBits = 4
+	subBuckets    = 1 << subBucketBits
+	numBuckets    = (64 - subBucketBits + 1) * subBuckets
+)
+
+// Histogram records latencies in log-linear buckets. It uses a fixed 8KB
+// regardless of how many values are recorded. It is not safe for
+// concurrent use; record per goroutine and Merge.
+type Histogram struct {
+	counts [numBuckets]uint64
+	count  uint64
+	sum    time.Duration
+	min    time.Duration
+	max    time.Duration
+}
+
+func bucketOf(v uint64) int {
+	if v < subBuckets {
+		return int(v)
+	}
+	exp := bits.Len64(v) - 1
+	sub := (v >> (exp - subBucketBits)) & (subBuckets - 1)
+	return (exp-subBucketBits+1)*subBuckets + int(sub)
+}
+
+// bucketUpper returns the largest value that falls in bucket i
+func bucketUpper(i int) uint64 {
+	if i < subBuckets {
+		return uint64(i)
+	}
+	exp := i/subBuckets + subBucketBits - 1
+	width := uint64(1) << (exp - subBucketBits)
+	lower := (subBuckets + uint64(i%subBuckets)) * width
+	return lower + width - 1
+}
+
+// Record adds one latency
+func (h *Histogram) Record(d time.Duration) {
+	d = max(d, 0)
+	h.counts[bucketOf(uint64(d))]++
+	if h.count == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.count++
+	h.sum += d
+}
+
+// Merge adds every value recorded in o
+func (h *Histogram) Merge(o *Histogram) {
+	if o.count == 0 {
+		return
+	}
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+	if h.count == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	h.max = max(h.max, o.max)
+	h.count += o.count
+	h.sum += o.sum
+}
+
+// Count returns the number of recorded values
+func (h *Histogram) Count() uint64 {
+	return h.count
+}
+
+// Mean returns the average latency
+func (h *Histogram) Mean() time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.count)
+}
+
+// Max returns the largest recorded latency
+func (h *Histogram) Max() time.Duration {
+	return h.max
+}
+
+// Quantile returns the latency at quantile q (0..1), rounded up to its
+// bucket's upper bound
+func (h *Histogram) Quantile(q float64) time.Duration {
+	if h.count == 0 {
+		return 0
+	}
+	rank := max(uint64(math.Ceil(q*float64(h.count))), 1)
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return min(time.Duration(bucketUpper(i)), h.max)
+		}
+	}
+	return h.max
+}
+
+// Stats returns the standard percentiles
+func (h *Histogram) Stats() LatencyStats {
+	return LatencyStats{
+		P50:  h.Quantile(0.50),
+		P95:  h.Quantile(0.95),
+		P99:  h.Quantile(0.99),
+		P999: h.Quantile(0.999),
+		Max:  h.max,
+	}
+}
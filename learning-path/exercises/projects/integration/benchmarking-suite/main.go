@@ -1,12 +1,20 @@
 package benchmarking
 
 import (
+	"context"
+	"fmt"
+	"strings"
 	"time"
 )
 
 // BenchmarkSuite runs all benchmarks
 type BenchmarkSuite struct {
-	results []BenchmarkResult
+	// BenchTime is how long RunRegistered runs each benchmark for;
+	// defaults to 1s
+	BenchTime time.Duration
+
+	benchmarks []Benchmark
+	results    []BenchmarkResult
 }
 
 // BenchmarkResult stores benchmark results
@@ -14,7 +22,11 @@ type BenchmarkResult struct {
 	Name       string
 	Throughput float64
 	Latency    LatencyStats
-	Memory     int64
+	Memory     MemoryStats // of registered benchmarks only
+	// RecoveryTime is set for a fault: the time from its injection until
+	// operations succeeded again. Throughput is then during the fault.
+	RecoveryTime time.Duration
+	fault        bool
 }
 
 // LatencyStats stores latency percentiles
@@ -23,6 +35,7 @@ type LatencyStats struct {
 	P95  time.Duration
 	P99  time.Duration
 	P999 time.Duration
+	Max  time.Duration
 }
 
 // NewBenchmarkSuite creates a new benchmark suite
@@ -57,8 +70,58 @@ func (bs *BenchmarkSuite) RunScalabilityBenchmarks() {
 	// TODO: Test with 1, 2, 4, 8, 16 cores
 }
 
+// RunScenario runs a mixed-workload scenario and adds a result for each of
+// its phases, named "scenario/phase", and for each fault injected into them,
+// named "scenario/phase/fault"
+func (bs *BenchmarkSuite) RunScenario(ctx context.Context, s *Scenario) (*ScenarioResult, error) {
+	result, err := s.Run(ctx)
+	if result != nil {
+		for _, p := range result.Phases {
+			bs.results = append(bs.results, BenchmarkResult{
+				Name:       s.Name + "/" + p.Name,
+				Throughput: p.Throughput,
+				Latency:    p.Latency,
+			})
+			for _, f := range p.Faults {
+				if f.Injected {
+					bs.results = append(bs.results, BenchmarkResult{
+						Name:         s.Name + "/" + p.Name + "/" + f.Name,
+						Throughput:   f.ThroughputDuring,
+						RecoveryTime: f.TimeToRecover,
+						fault:        true,
+					})
+				}
+			}
+		}
+	}
+	return result, err
+}
+
+// Results returns the results of the benchmarks run so far, for example to
+// Compare with a baseline
+func (bs *BenchmarkSuite) Results() []BenchmarkResult {
+	return bs.results
+}
+
 // GenerateReport generates benchmark report
 func (bs *BenchmarkSuite) GenerateReport() string {
-	// TODO: Format results nicely
-	return ""
+	var sb strings.Builder
+	sb.WriteString("Benchmark Results:\n==================\n")
+	for _, r := range bs.results {
+		if r.fault {
+			fmt.Fprintf(&sb, "  %s: %.0f ops/sec during fault (recovery: %v)\n", r.Name, r.Throughput, round(r.RecoveryTime))
+			continue
+		}
+		if m := r.Memory; m.Ops > 0 {
+			fmt.Fprintf(&sb, "  %s: %.0f ops/sec, %.0f allocs/op, %.0f B/op, GC pause %v", r.Name, r.Throughput,
+				m.AllocsPerOp, m.BytesPerOp, round(m.GCPause))
+			if m.PeakRSS > 0 {
+				fmt.Fprintf(&sb, ", peak RSS %s", formatBytes(m.PeakRSS))
+			}
+			sb.WriteString("\n")
+			continue
+		}
+		fmt.Fprintf(&sb, "  %s: %.0f ops/sec (p99: %v)\n", r.Name, r.Throughput, round(r.Latency.P99))
+	}
+	return sb.String()
 }
@@ -1,6 +1,20 @@
 package benchmarking
 
-import "testing"
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
 
 func BenchmarkBufferPool(b *testing.B) {
 	b.Skip("not implemented")
@@ -17,3 +31,494 @@ func BenchmarkHashJoin(b *testing.B) {
 func BenchmarkEndToEnd(b *testing.B) {
 	b.Skip("not implemented")
 }
+
+// The chaos benchmarks run a workload against a small stand-in for each
+// storage component, with a fault injected partway through, and report how
+// long the workload took to recover and how much throughput it lost. The
+// stand-ins reach the same kind of fault points the real components have:
+// a WAL's fsync, a page write, and a restart that replays the log.
+
+// appendLog appends records to a file and can sync after each one
+type appendLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func openAppendLog(b *testing.B, path string) *appendLog {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return &appendLog{file: f}
+}
+
+func (l *appendLog) append(ctx context.Context, record []byte, sync bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.file.Write(record); err != nil {
+		return err
+	}
+	if !sync {
+		return nil
+	}
+	if err := InjectFault(ctx, "wal.fsync"); err != nil {
+		return err
+	}
+	return l.file.Sync()
+}
+
+// runChaos runs phase b.N times and reports the mean impact of its fault
+func runChaos(b *testing.B, phase Phase) {
+	var recovery time.Duration
+	var before, during float64
+	for range b.N {
+		result, err := (&Scenario{Name: b.Name(), Phases: []Phase{phase}, Seed: 1}).Run(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		f := result.Phases[0].Faults[0]
+		if !f.Recovered {
+			b.Fatalf("%s did not recover:\n%s", f.Name, result.Report())
+		}
+		recovery += f.TimeToRecover
+		before += f.ThroughputBefore
+		during += f.ThroughputDuring
+	}
+	n := float64(b.N)
+	b.ReportMetric(float64(recovery.Milliseconds())/n, "recovery-ms")
+	b.ReportMetric(before/n, "ops/s-before")
+	b.ReportMetric(during/n, "ops/s-during")
+}
+
+func BenchmarkChaosFsyncStall(b *testing.B) {
+	log := openAppendLog(b, filepath.Join(b.TempDir(), "wal"))
+	defer log.file.Close()
+	record := make([]byte, 128)
+	runChaos(b, Phase{
+		Name:     "commit",
+		Duration: 600 * time.Millisecond,
+		Workers:  4,
+		Mix: []WeightedOp{{Name: "commit", Weight: 1, Op: func(ctx context.Context) error {
+			return log.append(ctx, record, true)
+		}}},
+		Faults: []Fault{{Name: "fsync-stall", Point: "wal.fsync", At: 200 * time.Millisecond, Duration: 200 * time.Millisecond, Stall: 20 * time.Millisecond}},
+	})
+}
+
+func BenchmarkChaosDiskFull(b *testing.B) {
+	f, err := os.Create(filepath.Join(b.TempDir(), "pages"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	page := make([]byte, 4096)
+	var next atomic.Int64
+	runChaos(b, Phase{
+		Name:     "write",
+		Duration: 600 * time.Millisecond,
+		Workers:  4,
+		Mix: []WeightedOp{{Name: "write-page", Weight: 1, Op: func(ctx context.Context) error {
+			if err := InjectFault(ctx, "pages.write"); err != nil {
+				return err
+			}
+			_, err := f.WriteAt(page, next.Add(1)%1024*int64(len(page)))
+			return err
+		}}},
+		Faults: []Fault{{Name: "disk-full", Point: "pages.write", At: 200 * time.Millisecond, Duration: 200 * time.Millisecond, Err: syscall.ENOSPC}},
+	})
+}
+
+func BenchmarkChaosCrashRestart(b *testing.B) {
+	// A graph store that keeps nodes in memory, logs every insert and
+	// replays the log when it restarts
+	path := filepath.Join(b.TempDir(), "graph.log")
+	log := openAppendLog(b, path)
+	var mu sync.RWMutex
+	nodes := make(map[uint64]bool)
+	var next atomic.Uint64
+	defer func() { log.file.Close() }()
+
+	insert := func(ctx context.Context) error {
+		id := next.Add(1)
+		if err := log.append(ctx, binary.LittleEndian.AppendUint64(nil, id), false); err != nil {
+			return err
+		}
+		mu.Lock()
+		nodes[id] = true
+		mu.Unlock()
+		return nil
+	}
+	restart := func(ctx context.Context) error {
+		// Crash: drop the in-memory state without closing cleanly
+		log.file.Close()
+		log = openAppendLog(b, path)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		recovered := make(map[uint64]bool, len(data)/8)
+		for i := 0; i+8 <= len(data); i += 8 {
+			recovered[binary.LittleEndian.Uint64(data[i:])] = true
+		}
+		mu.Lock()
+		nodes = recovered
+		mu.Unlock()
+		return nil
+	}
+	runChaos(b, Phase{
+		Name:     "load",
+		Duration: 600 * time.Millisecond,
+		Workers:  4,
+		Mix:      []WeightedOp{{Name: "insert", Weight: 1, Op: insert}},
+		Faults:   []Fault{{Name: "crash", At: 300 * time.Millisecond, Restart: restart}},
+	})
+}
+
+func TestHistogram(t *testing.T) {
+	var h Histogram
+	for i := 1; i <= 1000; i++ {
+		h.Record(time.Duration(i) * time.Microsecond)
+	}
+	if h.Count() != 1000 {
+		t.Fatalf("Count() = %d, want 1000", h.Count())
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 500 * time.Microsecond}, {0.99, 990 * time.Microsecond}, {1, time.Millisecond}} {
+		got := h.Quantile(tc.q)
+		if got < tc.want || float64(got) > float64(tc.want)*1.07 {
+			t.Errorf("Quantile(%v) = %v, want within 7%% above %v", tc.q, got, tc.want)
+		}
+	}
+
+	var other Histogram
+	other.Record(5 * time.Millisecond)
+	h.Merge(&other)
+	if h.Count() != 1001 || h.Max() != 5*time.Millisecond {
+		t.Errorf("after Merge: Count() = %d, Max() = %v", h.Count(), h.Max())
+	}
+}
+
+func TestScenario(t *testing.T) {
+	var mu sync.Mutex
+	store := make(map[int]int)
+	var key int
+	write := func(ctx context.Context) error {
+		mu.Lock()
+		key++
+		store[key%100] = key
+		flush := key%50 == 0
+		mu.Unlock()
+		if flush {
+			MarkEvent(ctx, "flush")
+		}
+		return nil
+	}
+	read := func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		_ = store[key%100]
+		return nil
+	}
+	checkpoint := func(ctx context.Context) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	}
+
+	suite := NewBenchmarkSuite()
+	result, err := suite.RunScenario(context.Background(), &Scenario{
+		Name: "oltp",
+		Phases: []Phase{
+			{Name: "load", Ops: 200, Workers: 4, Mix: []WeightedOp{{Name: "insert", Weight: 1, Op: write}}},
+			{
+				Name:     "steady",
+				Duration: 300 * time.Millisecond,
+				Workers:  4,
+				Rate:     20000,
+				Mix: []WeightedOp{
+					{Name: "read", Weight: 80, Op: read},
+					{Name: "write", Weight: 20, Op: write},
+				},
+				Background: []BackgroundTask{{Name: "checkpoint", Interval: 50 * time.Millisecond, Run: checkpoint}},
+			},
+		},
+		TimelineInterval: 50 * time.Millisecond,
+		Seed:             1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Phases) != 2 {
+		t.Fatalf("got %d phases, want 2", len(result.Phases))
+	}
+	if load := result.Phases[0]; load.Ops != 200 || load.Errors != 0 {
+		t.Errorf("load phase: %d ops, %d errors; want 200 ops", load.Ops, load.Errors)
+	}
+	steady := result.Phases[1]
+	if steady.Duration < 300*time.Millisecond {
+		t.Errorf("steady phase ran %v, want at least 300ms", steady.Duration)
+	}
+	if steady.Ops < 500 {
+		t.Fatalf("steady phase ran only %d ops", steady.Ops)
+	}
+	reads := float64(steady.ByOp[0].Count) / float64(steady.Ops)
+	if reads < 0.7 || reads > 0.9 {
+		t.Errorf("reads are %.2f of the mix, want about 0.8", reads)
+	}
+	if steady.Throughput > 25000 {
+		t.Errorf("throughput %.0f ops/sec exceeds the 20000 rate limit", steady.Throughput)
+	}
+
+	kinds := make(map[string]int)
+	for _, e := range result.Events {
+		kinds[e.Kind]++
+		if e.Kind == "checkpoint" && e.Start < steady.Start {
+			t.Errorf("checkpoint at %v before the steady phase started at %v", e.Start, steady.Start)
+		}
+	}
+	if kinds["checkpoint"] < 3 {
+		t.Errorf("got %d checkpoint events, want at least 3", kinds["checkpoint"])
+	}
+	if kinds["flush"] == 0 {
+		t.Error("no flush events recorded with MarkEvent")
+	}
+
+	if len(result.Timeline) < 6 {
+		t.Fatalf("got %d timeline buckets, want at least 6", len(result.Timeline))
+	}
+	var timelineOps int64
+	var checkpoints int
+	for _, b := range result.Timeline {
+		timelineOps += b.Ops
+		if b.Ops > 0 && b.Phase == "" {
+			t.Errorf("bucket at %v has ops but no phase", b.Start)
+		}
+		for _, e := range b.Events {
+			if e.Kind == "checkpoint" {
+				checkpoints++
+			}
+		}
+	}
+	if timelineOps != result.Phases[0].Ops+steady.Ops {
+		t.Errorf("timeline has %d ops, phases have %d", timelineOps, result.Phases[0].Ops+steady.Ops)
+	}
+	if checkpoints < kinds["checkpoint"] {
+		t.Errorf("timeline shows %d checkpoints, want %d", checkpoints, kinds["checkpoint"])
+	}
+	var lastPhase string
+	for _, b := range result.Timeline {
+		if b.Ops > 0 {
+			lastPhase = b.Phase
+		}
+	}
+	if lastPhase != "steady" {
+		t.Errorf("last busy bucket phase = %q, want steady", lastPhase)
+	}
+
+	report := result.Report()
+	for _, want := range []string{"Scenario oltp", "load", "steady", "read", "write", "Timeline (50ms buckets)", "checkpoint"} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report is missing %q:\n%s", want, report)
+		}
+	}
+	if got := suite.GenerateReport(); !strings.Contains(got, "oltp/steady") {
+		t.Errorf("suite report is missing the steady phase:\n%s", got)
+	}
+}
+
+func TestScenarioErrors(t *testing.T) {
+	fail := errors.New("boom")
+	result, err := (&Scenario{Phases: []Phase{{
+		Name: "failing",
+		Ops:  10,
+		Mix:  []WeightedOp{{Name: "op", Weight: 1, Op: func(context.Context) error { return fail }}},
+	}}}).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := result.Phases[0]; p.Ops != 10 || p.Errors != 10 {
+		t.Errorf("got %d ops, %d errors; want 10 of each", p.Ops, p.Errors)
+	}
+
+	if _, err := (&Scenario{}).Run(context.Background()); !errors.Is(err, ErrNoPhases) {
+		t.Errorf("Run() with no phases = %v, want ErrNoPhases", err)
+	}
+	noop := []WeightedOp{{Name: "noop", Weight: 1, Op: func(context.Context) error { return nil }}}
+	for _, p := range []Phase{
+		{Name: "unbounded", Mix: noop},
+		{Name: "empty mix", Ops: 1},
+		{Name: "bad task", Ops: 1, Mix: noop, Background: []BackgroundTask{{Name: "t"}}},
+	} {
+		if _, err := (&Scenario{Phases: []Phase{p}}).Run(context.Background()); !errors.Is(err, ErrInvalidPhase) {
+			t.Errorf("phase %q: Run() = %v, want ErrInvalidPhase", p.Name, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&Scenario{Phases: []Phase{{Name: "p", Duration: time.Second, Mix: noop}}}).Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run() with a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	fail := errors.New("disk full")
+	var inFlight, overlapped atomic.Int32
+	op := func(ctx context.Context) error {
+		inFlight.Add(1)
+		defer inFlight.Add(-1)
+		time.Sleep(100 * time.Microsecond)
+		return InjectFault(ctx, "pages.write")
+	}
+	restart := func(ctx context.Context) error {
+		if inFlight.Load() != 0 {
+			overlapped.Add(1)
+		}
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	}
+
+	suite := NewBenchmarkSuite()
+	result, err := suite.RunScenario(context.Background(), &Scenario{
+		Name: "chaos",
+		Phases: []Phase{{
+			Name:     "load",
+			Duration: 300 * time.Millisecond,
+			Workers:  4,
+			Mix:      []WeightedOp{{Name: "write", Weight: 1, Op: op}},
+			Faults: []Fault{
+				{Name: "disk-full", Point: "pages.write", At: 50 * time.Millisecond, Duration: 50 * time.Millisecond, Err: fail},
+				{Name: "crash", At: 150 * time.Millisecond, Restart: restart},
+				{Name: "late", Point: "pages.write", At: time.Hour, Duration: time.Second},
+			},
+		}},
+		TimelineInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	faults := result.Phases[0].Faults
+	if len(faults) != 3 {
+		t.Fatalf("got %d fault results, want 3", len(faults))
+	}
+	full, crash, late := faults[0], faults[1], faults[2]
+	if !full.Injected || full.Errors == 0 || full.Errors != result.Phases[0].Errors {
+		t.Errorf("disk-full: injected %v, %d errors; phase had %d", full.Injected, full.Errors, result.Phases[0].Errors)
+	}
+	if !full.Recovered || full.TimeToRecover < full.Active || full.Active < 50*time.Millisecond {
+		t.Errorf("disk-full: active %v, recovered %v after %v", full.Active, full.Recovered, full.TimeToRecover)
+	}
+	if full.ThroughputBefore == 0 || full.ThroughputDuring > full.ThroughputBefore/2 {
+		t.Errorf("disk-full: %.0f ops/sec before, %.0f during; want a drop", full.ThroughputBefore, full.ThroughputDuring)
+	}
+	if !crash.Recovered || crash.Errors != 0 || crash.TimeToRecover < 30*time.Millisecond {
+		t.Errorf("crash: %d errors, recovered %v after %v", crash.Errors, crash.Recovered, crash.TimeToRecover)
+	}
+	if overlapped.Load() != 0 {
+		t.Error("operations ran while the phase restarted")
+	}
+	if late.Injected {
+		t.Error("fault due after the phase was injected")
+	}
+
+	kinds := make(map[string]int)
+	for _, e := range result.Events {
+		kinds[e.Kind]++
+	}
+	if kinds["disk-full"] != 1 || kinds["crash"] != 1 || kinds["late"] != 0 {
+		t.Errorf("fault events: %v", kinds)
+	}
+	if report := result.Report(); !strings.Contains(report, "Faults") || !strings.Contains(report, "not injected") {
+		t.Errorf("report is missing the faults:\n%s", report)
+	}
+	if got := suite.GenerateReport(); !strings.Contains(got, "chaos/load/crash") || strings.Contains(got, "chaos/load/late") {
+		t.Errorf("suite report faults:\n%s", got)
+	}
+
+	if err := InjectFault(context.Background(), "pages.write"); err != nil {
+		t.Errorf("InjectFault outside a scenario = %v", err)
+	}
+	noop := []WeightedOp{{Name: "noop", Weight: 1, Op: func(context.Context) error { return nil }}}
+	for _, f := range []Fault{
+		{Name: "no point", Duration: time.Second},
+		{Name: "no duration", Point: "p"},
+		{Name: "both", Point: "p", Restart: restart},
+		{Point: "p", Duration: time.Second},
+	} {
+		if _, err := (&Scenario{Phases: []Phase{{Name: "p", Ops: 1, Mix: noop, Faults: []Fault{f}}}}).Run(context.Background()); !errors.Is(err, ErrInvalidPhase) {
+			t.Errorf("fault %q: Run() = %v, want ErrInvalidPhase", f.Name, err)
+		}
+	}
+}
+
+var sink []byte
+
+func TestRegisteredMemory(t *testing.T) {
+	suite := NewBenchmarkSuite()
+	suite.BenchTime = 20 * time.Millisecond
+	suite.Register("alloc", func(n int) {
+		for range n {
+			sink = make([]byte, 1024)
+		}
+	})
+	suite.Register("noalloc", func(n int) {
+		for i := range n {
+			sink = sink[:i%2]
+		}
+	})
+	suite.RunRegistered()
+
+	results := suite.Results()
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	alloc, noalloc := results[0].Memory, results[1].Memory
+	if alloc.Ops == 0 || alloc.AllocsPerOp < 1 || alloc.AllocsPerOp > 1.1 || alloc.BytesPerOp < 1024 || alloc.BytesPerOp > 1100 {
+		t.Errorf("alloc: %+v", alloc)
+	}
+	if alloc.NumGC == 0 || alloc.GCPause <= 0 {
+		t.Errorf("alloc ran no GC: %+v", alloc)
+	}
+	if noalloc.Ops == 0 || noalloc.AllocsPerOp > 0.01 {
+		t.Errorf("noalloc: %+v", noalloc)
+	}
+	if runtime.GOOS == "linux" && alloc.PeakRSS == 0 {
+		t.Error("no peak RSS on Linux")
+	}
+	if report := suite.GenerateReport(); !strings.Contains(report, "alloc: ") || !strings.Contains(report, "allocs/op") {
+		t.Errorf("report:\n%s", report)
+	}
+
+	// A benchmark that allocates twice as much regresses; a 5% slower one
+	// does not
+	current := slices.Clone(results)
+	current[0].Memory.AllocsPerOp *= 2
+	current[0].Memory.BytesPerOp *= 2
+	current[1].Throughput *= 0.95
+	current = append(current, BenchmarkResult{Name: "new", Throughput: 1})
+	comparisons := Compare(results, current, CompareOptions{RuntimeTolerance: 100})
+	if len(comparisons) != 2 {
+		t.Fatalf("got %d comparisons, want 2", len(comparisons))
+	}
+	if !comparisons[0].Regressed() || comparisons[1].Regressed() {
+		t.Errorf("comparisons = %+v", comparisons)
+	}
+	for _, d := range comparisons[0].Deltas {
+		if want := d.Metric == "allocs/op" || d.Metric == "B/op"; d.Regressed != want {
+			t.Errorf("%s: regressed = %v, want %v", d.Metric, d.Regressed, want)
+		}
+	}
+	if out := FormatComparison(comparisons); !strings.Contains(out, "REGRESSED") || !strings.Contains(out, "+100.0%") {
+		t.Errorf("comparison table:\n%s", out)
+	}
+
+	// Throughput is compared for every result, memory only when measured
+	scenario := []BenchmarkResult{{Name: "oltp/steady", Throughput: 100}}
+	slower := []BenchmarkResult{{Name: "oltp/steady", Throughput: 80}}
+	if c := Compare(scenario, slower, CompareOptions{}); len(c) != 1 || len(c[0].Deltas) != 1 || !c[0].Regressed() {
+		t.Errorf("scenario comparison = %+v", c)
+	}
+}
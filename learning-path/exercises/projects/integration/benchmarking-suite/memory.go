@@ -0,0 +1,95 @@
+package benchmarking
+
+import (
+	"runtime"
+	"time"
+)
+
+// defaultBenchTime is how long RunRegistered runs each benchmark for when
+// the suite does not set BenchTime
+const defaultBenchTime = time.Second
+
+// Benchmark is a micro-benchmark registered with a suite. Run performs n
+// operations, like the loop of a testing benchmark over b.N.
+type Benchmark struct {
+	Name string
+	Run  func(n int)
+}
+
+// MemoryStats is what a benchmark allocated and what it cost the garbage
+// collector over its measured run
+type MemoryStats struct {
+	Ops         int64 // operations measured; 0 if memory was not measured
+	AllocsPerOp float64
+	BytesPerOp  float64
+	// PeakRSS is the resident set high-water mark during the run, in
+	// bytes; on Linux only. It covers the whole process, so a benchmark
+	// also pays for memory the runtime kept from earlier ones.
+	PeakRSS int64
+	NumGC   int64
+	GCPause time.Duration // total stop-the-world time of those cycles
+}
+
+// Register adds a benchmark for RunRegistered
+func (bs *BenchmarkSuite) Register(name string, run func(n int)) {
+	bs.benchmarks = append(bs.benchmarks, Benchmark{Name: name, Run: run})
+}
+
+// RunRegistered runs the registered benchmarks in order and adds a result
+// for each, with its throughput and memory. Like go test, each runs with a
+// growing n until one run lasts BenchTime, and only that run is reported.
+func (bs *BenchmarkSuite) RunRegistered() {
+	benchTime := bs.BenchTime
+	if benchTime <= 0 {
+		benchTime = defaultBenchTime
+	}
+	for _, b := range bs.benchmarks {
+		n := 1
+		for {
+			elapsed, mem := measure(n, b.Run)
+			if elapsed >= benchTime || n >= 1e9 {
+				bs.results = append(bs.results, BenchmarkResult{
+					Name:       b.Name,
+					Throughput: float64(n) / elapsed.Seconds(),
+					Memory:     mem,
+				})
+				break
+			}
+			n = nextN(n, elapsed, benchTime)
+		}
+	}
+}
+
+// nextN predicts the n that makes a run last benchTime, with 20% to spare,
+// growing at least by one and at most a hundredfold
+func nextN(n int, elapsed, benchTime time.Duration) int {
+	next := 100 * n
+	if elapsed > 0 {
+		next = min(next, int(1.2*float64(n)*float64(benchTime)/float64(elapsed)))
+	}
+	return max(next, n+1)
+}
+
+// measure runs n operations of run from a freshly collected heap and
+// returns how long they took and what they allocated. It reads the
+// runtime's MemStats rather than runtime/metrics, as go test does: reading
+// them flushes the per-P allocation caches, so the counts are exact.
+func measure(n int, run func(n int)) (time.Duration, MemoryStats) {
+	var before, after runtime.MemStats
+	runtime.GC()
+	resetPeakRSS()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	run(n)
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	return elapsed, MemoryStats{
+		Ops:         int64(n),
+		AllocsPerOp: float64(after.Mallocs-before.Mallocs) / float64(n),
+		BytesPerOp:  float64(after.TotalAlloc-before.TotalAlloc) / float64(n),
+		PeakRSS:     peakRSS(),
+		NumGC:       int64(after.NumGC - before.NumGC),
+		GCPause:     time.Duration(after.PauseTotalNs - before.PauseTotalNs),
+	}
+}
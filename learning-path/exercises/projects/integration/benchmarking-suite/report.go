@@ -0,0 +1,129 @@
+package benchmarking
+
+import (
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Report formats the result as a table of phases followed by the timeline,
+// so latency changes can be lined up with the events in the same bucket
+func (r *ScenarioResult) Report() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Scenario %s (%v)\n\n", r.Name, round(r.Duration))
+
+	tw := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Phase\tDuration\tOps\tOps/sec\tp50\tp99\tp999\tmax\tErrors")
+	for _, p := range r.Phases {
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%.0f\t%v\t%v\t%v\t%v\t%d\n", p.Name, round(p.Duration), p.Ops, p.Throughput,
+			round(p.Latency.P50), round(p.Latency.P99), round(p.Latency.P999), round(p.Latency.Max), p.Errors)
+		if len(p.ByOp) < 2 {
+			continue
+		}
+		for _, op := range p.ByOp {
+			fmt.Fprintf(tw, "  %s\t\t%d\t\t%v\t%v\t%v\t%v\t%d\n", op.Name, op.Count,
+				round(op.Latency.P50), round(op.Latency.P99), round(op.Latency.P999), round(op.Latency.Max), op.Errors)
+		}
+	}
+	tw.Flush()
+
+	if faults := r.faults(); len(faults) > 0 {
+		sb.WriteString("\nFaults\n")
+		tw = tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "Fault\tPhase\tAt\tActive\tRecovery\tOps/sec before\tduring\tErrors")
+		for _, pf := range faults {
+			f := pf.fault
+			if !f.Injected {
+				fmt.Fprintf(tw, "%s\t%s\tnot injected\t\t\t\t\t\n", f.Name, pf.phase)
+				continue
+			}
+			recovery := "none"
+			if f.Recovered {
+				recovery = round(f.TimeToRecover).String()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%v\t%v\t%s\t%.0f\t%.0f\t%d\n", f.Name, pf.phase, round(f.Start), round(f.Active),
+				recovery, f.ThroughputBefore, f.ThroughputDuring, f.Errors)
+		}
+		tw.Flush()
+	}
+
+	fmt.Fprintf(&sb, "\nTimeline (%v buckets)\n", r.Interval)
+	tw = tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Time\tPhase\tOps/sec\tp50\tp99\tmax\tEvents")
+	for _, b := range r.Timeline {
+		fmt.Fprintf(tw, "%v\t%s\t%.0f\t%v\t%v\t%v\t%s\n", b.Start, b.Phase, b.Throughput,
+			round(b.Latency.P50), round(b.Latency.P99), round(b.Latency.Max), summarizeEvents(b.Events))
+	}
+	tw.Flush()
+	return sb.String()
+}
+
+type phaseFault struct {
+	phase string
+	fault FaultResult
+}
+
+// faults returns the faults of every phase
+func (r *ScenarioResult) faults() []phaseFault {
+	var faults []phaseFault
+	for _, p := range r.Phases {
+		for _, f := range p.Faults {
+			faults = append(faults, phaseFault{phase: p.Name, fault: f})
+		}
+	}
+	return faults
+}
+
+// summarizeEvents renders events by kind, e.g. "checkpoint (12ms) gc×3 (max 150µs)"
+func summarizeEvents(events []Event) string {
+	type summary struct {
+		kind    string
+		count   int
+		longest time.Duration
+		errors  int
+	}
+	var kinds []*summary
+	byKind := make(map[string]*summary)
+	for _, e := range events {
+		s, ok := byKind[e.Kind]
+		if !ok {
+			s = &summary{kind: e.Kind}
+			byKind[e.Kind] = s
+			kinds = append(kinds, s)
+		}
+		s.count++
+		s.longest = max(s.longest, e.Duration)
+		if e.Err != nil {
+			s.errors++
+		}
+	}
+
+	parts := make([]string, len(kinds))
+	for i, s := range kinds {
+		parts[i] = s.kind
+		if s.count > 1 {
+			parts[i] += fmt.Sprintf("×%d", s.count)
+		}
+		switch {
+		case s.longest > 0 && s.count > 1:
+			parts[i] += fmt.Sprintf(" (max %v)", round(s.longest))
+		case s.longest > 0:
+			parts[i] += fmt.Sprintf(" (%v)", round(s.longest))
+		}
+		if s.errors > 0 {
+			parts[i] += fmt.Sprintf(" [%d failed]", s.errors)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// round keeps three or four significant digits of d
+func round(d time.Duration) time.Duration {
+	for unit := time.Duration(1); unit < time.Second; unit *= 10 {
+		if d < 1000*unit {
+			return d.Round(unit)
+		}
+	}
+	return d.Round(time.Millisecond)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package benchmarking
+
+func resetPeakRSS() {}
+
+// peakRSS is only measured on Linux
+func peakRSS() int64 {
+	return 0
+}
@@ -0,0 +1,482 @@
+package benchmarking
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrNoPhases     = errors.New("scenario has no phases")
+	ErrInvalidPhase = errors.New("invalid phase")
+)
+
+// Op is one operation of a workload. A returned error is counted in the
+// phase's metrics; it does not stop the phase.
+type Op func(ctx context.Context) error
+
+// WeightedOp is an operation and its share of a phase's mix. A mix of
+// {"read", 80} and {"write", 20} runs reads 80% of the time.
+type WeightedOp struct {
+	Name   string
+	Weight int
+	Op     Op
+}
+
+// BackgroundTask runs every Interval while its phase is running, for
+// example a checkpoint or a compaction. Each run is an event on the
+// timeline.
+type BackgroundTask struct {
+	Name     string
+	Interval time.Duration
+	Run      func(ctx context.Context) error
+}
+
+// Phase is one stage of a scenario, such as a bulk load or a steady state
+type Phase struct {
+	Name string
+	// The phase ends after Duration or after Ops operations in total,
+	// whichever comes first. At least one must be set.
+	Duration time.Duration
+	Ops      int64
+	// Workers run the mix concurrently; defaults to 1
+	Workers int
+	// Rate caps the phase's total operations per second; 0 is unlimited
+	Rate       float64
+	Mix        []WeightedOp
+	Background []BackgroundTask
+	Faults     []Fault
+}
+
+// Scenario is a sequence of phases run back to back, for example a bulk
+// load followed by ten minutes of 80/20 reads and writes with a checkpoint
+// every 30 seconds
+type Scenario struct {
+	Name   string
+	Phases []Phase
+	// TimelineInterval is the width of a timeline bucket; defaults to 1s
+	TimelineInterval time.Duration
+	// Seed makes the choice of operations reproducible
+	Seed uint64
+}
+
+// OpResult is the metrics of one operation of a phase's mix
+type OpResult struct {
+	Name    string
+	Count   int64
+	Errors  int64
+	Latency LatencyStats
+}
+
+// PhaseResult is the metrics of one phase
+type PhaseResult struct {
+	Name       string
+	Start      time.Duration // since the scenario started
+	Duration   time.Duration
+	Ops        int64
+	Errors     int64
+	Throughput float64 // ops/sec
+	Latency    LatencyStats
+	ByOp       []OpResult
+	Faults     []FaultResult
+}
+
+// Event is background activity that may affect latency: a BackgroundTask
+// run, a GC pause, or a mark made with MarkEvent
+type Event struct {
+	Kind     string
+	Start    time.Duration // since the scenario started
+	Duration time.Duration
+	Err      error
+}
+
+// TimelineBucket is the metrics of one TimelineInterval of the run
+type TimelineBucket struct {
+	Start      time.Duration
+	Phase      string // the phases that ran ops in the bucket, "+"-joined
+	Ops        int64
+	Errors     int64
+	Throughput float64
+	Latency    LatencyStats
+	Events     []Event // events overlapping the bucket
+}
+
+// ScenarioResult is the outcome of Scenario.Run
+type ScenarioResult struct {
+	Name     string
+	Duration time.Duration
+	Phases   []PhaseResult
+	Timeline []TimelineBucket
+	Events   []Event
+	Interval time.Duration
+}
+
+type recorderKey struct{}
+
+// MarkEvent records an instantaneous event, such as a flush, on the
+// timeline of the scenario running ctx. It does nothing outside a scenario.
+func MarkEvent(ctx context.Context, kind string) {
+	if r, ok := ctx.Value(recorderKey{}).(*recorder); ok {
+		r.event(Event{Kind: kind, Start: time.Since(r.start)})
+	}
+}
+
+// recorder collects the timeline of a run
+type recorder struct {
+	start    time.Time
+	interval time.Duration
+
+	mu      sync.Mutex
+	buckets []*timelineStats
+	events  []Event
+	numGC   int64
+}
+
+type timelineStats struct {
+	phases []string
+	hist   Histogram
+	ops    int64
+	errors int64
+}
+
+func (r *recorder) event(e Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+// flush adds a worker's metrics for one timeline bucket
+func (r *recorder) flush(idx int, phase string, hist *Histogram, ops, errs int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for len(r.buckets) <= idx {
+		r.buckets = append(r.buckets, &timelineStats{})
+	}
+	b := r.buckets[idx]
+	if !slices.Contains(b.phases, phase) {
+		b.phases = append(b.phases, phase)
+	}
+	b.hist.Merge(hist)
+	b.ops += ops
+	b.errors += errs
+}
+
+// collectGC turns the GC pauses since the last call into events. The
+// runtime keeps only the most recent pauses, so it runs every interval.
+func (r *recorder) collectGC() {
+	var stats debug.GCStats
+	debug.ReadGCStats(&stats)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := min(stats.NumGC-r.numGC, int64(len(stats.Pause)))
+	r.numGC = stats.NumGC
+	for i := n - 1; i >= 0; i-- {
+		start := stats.PauseEnd[i].Sub(r.start) - stats.Pause[i]
+		if start >= 0 {
+			r.events = append(r.events, Event{Kind: "gc", Start: start, Duration: stats.Pause[i]})
+		}
+	}
+}
+
+// Run runs the phases in order. If ctx is cancelled it stops and returns
+// the metrics so far with ctx.Err().
+func (s *Scenario) Run(ctx context.Context) (*ScenarioResult, error) {
+	if len(s.Phases) == 0 {
+		return nil, ErrNoPhases
+	}
+	for _, p := range s.Phases {
+		if err := p.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &recorder{start: time.Now(), interval: s.TimelineInterval}
+	if r.interval <= 0 {
+		r.interval = time.Second
+	}
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	r.numGC = gc.NumGC
+
+	samplerDone := make(chan struct{})
+	samplerStopped := make(chan struct{})
+	go func() {
+		defer close(samplerStopped)
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.collectGC()
+			case <-samplerDone:
+				return
+			}
+		}
+	}()
+
+	result := &ScenarioResult{Name: s.Name, Interval: r.interval}
+	ctx = context.WithValue(ctx, recorderKey{}, r)
+	var err error
+	for i, p := range s.Phases {
+		result.Phases = append(result.Phases, r.runPhase(ctx, p, s.Seed+uint64(i)))
+		if err = ctx.Err(); err != nil {
+			break
+		}
+	}
+	close(samplerDone)
+	<-samplerStopped
+	r.collectGC()
+
+	result.Duration = time.Since(r.start)
+	result.Events = r.events
+	slices.SortStableFunc(result.Events, func(a, b Event) int { return cmp.Compare(a.Start, b.Start) })
+	result.Timeline = r.timeline(result.Duration, result.Events)
+	return result, err
+}
+
+func (p *Phase) validate() error {
+	if p.Duration <= 0 && p.Ops <= 0 {
+		return fmt.Errorf("%w %q: needs a Duration or an Ops count", ErrInvalidPhase, p.Name)
+	}
+	total := 0
+	for _, op := range p.Mix {
+		if op.Weight < 0 || op.Op == nil {
+			return fmt.Errorf("%w %q: op %q needs a func and a weight >= 0", ErrInvalidPhase, p.Name, op.Name)
+		}
+		total += op.Weight
+	}
+	if total == 0 {
+		return fmt.Errorf("%w %q: mix has no weight", ErrInvalidPhase, p.Name)
+	}
+	for _, task := range p.Background {
+		if task.Interval <= 0 || task.Run == nil {
+			return fmt.Errorf("%w %q: background task %q needs a func and an interval", ErrInvalidPhase, p.Name, task.Name)
+		}
+	}
+	for _, f := range p.Faults {
+		if err := f.validate(); err != nil {
+			return fmt.Errorf("%w %q: %v", ErrInvalidPhase, p.Name, err)
+		}
+	}
+	return nil
+}
+
+// phaseStats accumulates the per-op metrics of a phase's workers
+type phaseStats struct {
+	mu  sync.Mutex
+	ops []opStats
+}
+
+type opStats struct {
+	hist   Histogram
+	count  int64
+	errors int64
+}
+
+func (r *recorder) runPhase(parent context.Context, p Phase, seed uint64) PhaseResult {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if p.Duration > 0 {
+		ctx, cancel = context.WithTimeout(parent, p.Duration)
+	} else {
+		ctx, cancel = context.WithCancel(parent)
+	}
+	defer cancel()
+	start := time.Now()
+	faults := newFaultSet(p.Faults, start)
+	ctx = context.WithValue(ctx, faultsKey{}, faults)
+
+	var bg sync.WaitGroup
+	for _, task := range p.Background {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			r.runBackground(ctx, task)
+		}()
+	}
+	for _, f := range faults.faults {
+		bg.Add(1)
+		go func() {
+			defer bg.Done()
+			faults.run(ctx, r, f)
+		}()
+	}
+
+	workers := max(p.Workers, 1)
+	stats := &phaseStats{ops: make([]opStats, len(p.Mix))}
+	var remaining atomic.Int64
+	remaining.Store(p.Ops)
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.runWorker(ctx, &p, stats, faults, &remaining, start, rand.New(rand.NewPCG(seed, uint64(w))), workers)
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	cancel()
+	bg.Wait()
+
+	result := PhaseResult{Name: p.Name, Start: start.Sub(r.start), Duration: elapsed}
+	var all Histogram
+	for i, op := range stats.ops {
+		result.ByOp = append(result.ByOp, OpResult{
+			Name:    p.Mix[i].Name,
+			Count:   op.count,
+			Errors:  op.errors,
+			Latency: op.hist.Stats(),
+		})
+		result.Ops += op.count
+		result.Errors += op.errors
+		all.Merge(&op.hist)
+	}
+	result.Latency = all.Stats()
+	if elapsed > 0 {
+		result.Throughput = float64(result.Ops) / elapsed.Seconds()
+	}
+	result.Faults = faults.results(r)
+	return result
+}
+
+func (r *recorder) runBackground(ctx context.Context, task BackgroundTask) {
+	ticker := time.NewTicker(task.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		began := time.Now()
+		err := task.Run(ctx)
+		if err != nil && ctx.Err() != nil {
+			return // interrupted by the end of the phase
+		}
+		r.event(Event{Kind: task.Name, Start: began.Sub(r.start), Duration: time.Since(began), Err: err})
+	}
+}
+
+func (r *recorder) runWorker(ctx context.Context, p *Phase, stats *phaseStats, faults *faultSet, remaining *atomic.Int64, start time.Time, rng *rand.Rand, workers int) {
+	local := make([]opStats, len(p.Mix))
+	total := 0
+	for _, op := range p.Mix {
+		total += op.Weight
+	}
+
+	// The worker's timeline bucket is merged into the recorder whenever
+	// the worker moves on to the next one
+	var cur Histogram
+	var curOps, curErrs int64
+	curIdx := -1
+	flush := func() {
+		if curOps > 0 {
+			r.flush(curIdx, p.Name, &cur, curOps, curErrs)
+		}
+		cur, curOps, curErrs = Histogram{}, 0, 0
+	}
+
+	var pace time.Duration
+	if p.Rate > 0 {
+		pace = time.Duration(float64(workers) / p.Rate * float64(time.Second))
+	}
+	for n := 0; ctx.Err() == nil; n++ {
+		if p.Ops > 0 && remaining.Add(-1) < 0 {
+			break
+		}
+		if pace > 0 {
+			if wait := time.Until(start.Add(time.Duration(n) * pace)); wait > 0 {
+				timer := time.NewTimer(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					timer.Stop()
+				}
+				if ctx.Err() != nil {
+					break
+				}
+			}
+		}
+
+		pick := rng.IntN(total)
+		i := 0
+		for pick >= p.Mix[i].Weight {
+			pick -= p.Mix[i].Weight
+			i++
+		}
+
+		// The latency includes any wait for a crashed phase to restart
+		began := time.Now()
+		faults.gate.RLock()
+		admitted := time.Now()
+		err := p.Mix[i].Op(ctx)
+		faults.gate.RUnlock()
+		end := time.Now()
+		latency := end.Sub(began)
+		if err != nil && ctx.Err() != nil {
+			break // cut short by the end of the phase
+		}
+		faults.observe(admitted, end, err)
+
+		if idx := int(began.Sub(r.start) / r.interval); idx != curIdx {
+			flush()
+			curIdx = idx
+		}
+		local[i].hist.Record(latency)
+		local[i].count++
+		cur.Record(latency)
+		curOps++
+		if err != nil {
+			local[i].errors++
+			curErrs++
+		}
+	}
+	flush()
+
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	for i := range local {
+		stats.ops[i].hist.Merge(&local[i].hist)
+		stats.ops[i].count += local[i].count
+		stats.ops[i].errors += local[i].errors
+	}
+}
+
+// timeline builds the buckets of a run of the given length
+func (r *recorder) timeline(length time.Duration, events []Event) []TimelineBucket {
+	n := int((length + r.interval - 1) / r.interval)
+	timeline := make([]TimelineBucket, n)
+	for i := range timeline {
+		b := &timeline[i]
+		b.Start = time.Duration(i) * r.interval
+		width := min(r.interval, length-b.Start)
+		if i < len(r.buckets) {
+			stats := r.buckets[i]
+			for j, phase := range stats.phases {
+				if j > 0 {
+					b.Phase += "+"
+				}
+				b.Phase += phase
+			}
+			b.Ops, b.Errors = stats.ops, stats.errors
+			b.Latency = stats.hist.Stats()
+			if width > 0 {
+				b.Throughput = float64(stats.ops) / width.Seconds()
+			}
+		}
+		for _, e := range events {
+			if e.Start < b.Start+r.interval && e.Start+e.Duration >= b.Start {
+				b.Events = append(b.Events, e)
+			}
+		}
+	}
+	return timeline
+}
@@ -0,0 +1,210 @@
+package minigraphdb
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// aggregateFuncs are the functions a RETURN or WITH item may apply
+var aggregateFuncs = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+// projection is the item list of a WITH or RETURN, and for a WITH the
+// WHERE that filters its rows, which is how the query language writes
+// HAVING:
+//
+//	MATCH (p)-[:knows]->(f) WITH p.name AS name, count(*) AS n WHERE n > 1 RETURN name, n
+type projection struct {
+	items []returnItem
+	where []columnCondition
+}
+
+// columnCondition compares a column of a WITH with a literal
+type columnCondition struct {
+	column int
+	op     string
+	value  any
+}
+
+// input is a row a projection reads: the nodes bound by the match for
+// the first projection, the row of the previous WITH for later ones
+type input struct {
+	binding map[string]*Node
+	row     []any
+}
+
+// columns returns the names of the projection's items
+func (proj projection) columns() []string {
+	names := make([]string, len(proj.items))
+	for i, item := range proj.items {
+		names[i] = item.name
+	}
+	return names
+}
+
+// apply projects inputs into rows and filters them. With an aggregate
+// among its items, the rows are grouped by the other items.
+func (proj projection) apply(inputs []input) [][]any {
+	var rows [][]any
+	if slices.ContainsFunc(proj.items, func(item returnItem) bool { return item.agg != "" }) {
+		op := newAggregateOperator(proj.items)
+		for _, in := range inputs {
+			op.add(in)
+		}
+		rows = op.results()
+	} else {
+		for _, in := range inputs {
+			row := make([]any, len(proj.items))
+			for i, item := range proj.items {
+				row[i] = item.eval(in)
+			}
+			rows = append(rows, row)
+		}
+	}
+	return slices.DeleteFunc(rows, func(row []any) bool {
+		for _, c := range proj.where {
+			if v := row[c.column]; v == nil || !compareOp(v, c.op, c.value) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// eval returns the value of a non-aggregate item, or the argument of an
+// aggregate
+func (item returnItem) eval(in input) any {
+	if item.column >= 0 {
+		return in.row[item.column]
+	}
+	node := in.binding[item.variable]
+	if item.property == "" {
+		return node.ID
+	}
+	return node.Props[item.property]
+}
+
+// aggregateOperator groups rows by the items without an aggregate, the
+// GROUP BY the query language leaves implicit, and aggregates the other
+// items per group. Groups are returned in the order they first appear.
+type aggregateOperator struct {
+	items  []returnItem
+	groups map[string]*group
+	order  []*group
+}
+
+type group struct {
+	keys   []any      // value of each grouping item, nil for aggregates
+	states []aggState // state of each aggregate item
+}
+
+func newAggregateOperator(items []returnItem) *aggregateOperator {
+	return &aggregateOperator{items: items, groups: make(map[string]*group)}
+}
+
+func (op *aggregateOperator) add(in input) {
+	keys := make([]any, len(op.items))
+	var id strings.Builder
+	for i, item := range op.items {
+		if item.agg == "" {
+			keys[i] = item.eval(in)
+			// %#v quotes strings, so distinct keys never print alike
+			fmt.Fprintf(&id, "%T %#v\x00", keys[i], keys[i])
+		}
+	}
+	g, ok := op.groups[id.String()]
+	if !ok {
+		g = &group{keys: keys, states: make([]aggState, len(op.items))}
+		op.groups[id.String()] = g
+		op.order = append(op.order, g)
+	}
+	for i, item := range op.items {
+		if item.agg == "" {
+			continue
+		}
+		if item.star {
+			g.states[i].count++
+			continue
+		}
+		g.states[i].add(item.agg, item.eval(in))
+	}
+}
+
+// results returns a row per group. Without grouping items there is one
+// group even if no row was added, so count(*) of nothing is 0.
+func (op *aggregateOperator) results() [][]any {
+	groups := op.order
+	if len(groups) == 0 && !slices.ContainsFunc(op.items, func(item returnItem) bool { return item.agg == "" }) {
+		groups = []*group{{keys: make([]any, len(op.items)), states: make([]aggState, len(op.items))}}
+	}
+	var rows [][]any
+	for _, g := range groups {
+		row := g.keys
+		for i, item := range op.items {
+			if item.agg != "" {
+				row[i] = g.states[i].result(item.agg)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// aggState is the running value of one aggregate in one group
+type aggState struct {
+	count    int64 // values counted; for sum and avg, only numbers
+	sumInt   int64
+	sumFloat float64
+	float    bool // a float was summed, so the sum is a float
+	extreme  any  // min or max so far
+}
+
+// add adds v to the aggregate fn. NULLs, and for sum and avg values that
+// are not numbers, are skipped.
+func (s *aggState) add(fn string, v any) {
+	if v == nil {
+		return
+	}
+	switch fn {
+	case "count":
+		s.count++
+	case "sum", "avg":
+		switch v := v.(type) {
+		case int64:
+			s.sumInt += v
+		case float64:
+			s.sumFloat += v
+			s.float = true
+		default:
+			return
+		}
+		s.count++
+	case "min", "max":
+		if s.extreme == nil {
+			s.extreme = v
+			return
+		}
+		if order, ok := compareValues(v, s.extreme); ok && (fn == "min" && order < 0 || fn == "max" && order > 0) {
+			s.extreme = v
+		}
+	}
+}
+
+func (s *aggState) result(fn string) any {
+	switch fn {
+	case "count":
+		return s.count
+	case "sum":
+		if s.float {
+			return float64(s.sumInt) + s.sumFloat
+		}
+		return s.sumInt
+	case "avg":
+		if s.count == 0 {
+			return nil
+		}
+		return (float64(s.sumInt) + s.sumFloat) / float64(s.count)
+	default:
+		return s.extreme
+	}
+}
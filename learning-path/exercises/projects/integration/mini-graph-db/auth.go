@@ -0,0 +1,124 @@
+package minigraphdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"sync"
+)
+
+// Role is what a user may do. Each role includes the ones before it.
+type Role int
+
+const (
+	// RoleNone is the role of a connection that has not authenticated
+	RoleNone Role = iota
+	// RoleRead may run MATCH queries and transaction control
+	RoleRead
+	// RoleWrite may also create nodes and edges
+	RoleWrite
+	// RoleAdmin may also create databases
+	RoleAdmin
+)
+
+// String returns the name of the role
+func (r Role) String() string {
+	switch r {
+	case RoleNone:
+		return "none"
+	case RoleRead:
+		return "read"
+	case RoleWrite:
+		return "write"
+	case RoleAdmin:
+		return "admin"
+	default:
+		return fmt.Sprintf("Role(%d)", int(r))
+	}
+}
+
+// user is a stored account. Only a salted hash of the password is kept.
+type user struct {
+	role Role
+	salt [16]byte
+	hash [sha256.Size]byte
+}
+
+// UserStore holds the accounts a Server authenticates against. It is safe
+// for concurrent use.
+type UserStore struct {
+	mu    sync.RWMutex
+	users map[string]*user
+}
+
+// NewUserStore creates a store with no users
+func NewUserStore() *UserStore {
+	return &UserStore{users: make(map[string]*user)}
+}
+
+// SetUser adds a user or replaces the password and role of an existing one
+func (us *UserStore) SetUser(name, password string, role Role) error {
+	if name == "" || role < RoleRead || role > RoleAdmin {
+		return fmt.Errorf("%w: user %q with role %v", ErrInvalidUser, name, role)
+	}
+	u := &user{role: role}
+	if _, err := rand.Read(u.salt[:]); err != nil {
+		return err
+	}
+	u.hash = hashPassword(u.salt, password)
+
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	us.users[name] = u
+	return nil
+}
+
+// RemoveUser deletes a user. Connections it authenticated keep their role.
+func (us *UserStore) RemoveUser(name string) {
+	us.mu.Lock()
+	defer us.mu.Unlock()
+	delete(us.users, name)
+}
+
+// Authenticate returns the role of the user if the password matches. An
+// unknown user and a wrong password fail alike, with ErrAuthFailed.
+func (us *UserStore) Authenticate(name, password string) (Role, error) {
+	us.mu.RLock()
+	u, ok := us.users[name]
+	us.mu.RUnlock()
+	if !ok {
+		return RoleNone, ErrAuthFailed
+	}
+	hash := hashPassword(u.salt, password)
+	if subtle.ConstantTimeCompare(hash[:], u.hash[:]) != 1 {
+		return RoleNone, ErrAuthFailed
+	}
+	return u.role, nil
+}
+
+// hashPassword hashes a salted password. A deployment would use a slow
+// hash such as bcrypt or argon2; SHA-256 keeps this project dependency-free.
+func hashPassword(salt [16]byte, password string) [sha256.Size]byte {
+	return sha256.Sum256(append(salt[:], password...))
+}
+
+// authorize checks that role may run stmt
+func authorize(role Role, stmt statement) error {
+	need := RoleRead
+	if writes(stmt) {
+		need = RoleWrite
+	}
+	return checkRole(role, need)
+}
+
+// checkRole checks that role includes need
+func checkRole(role, need Role) error {
+	if role == RoleNone {
+		return ErrAuthRequired
+	}
+	if role < need {
+		return fmt.Errorf("%w: needs %v role, have %v", ErrPermissionDenied, need, role)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package minigraphdb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// DefaultDatabase is the database a server connection starts in
+const DefaultDatabase = "default"
+
+// validName is the form of database names: they name directories
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]{0,63}$`)
+
+// Databases is a set of named databases served by one process. Each
+// database is a GraphDB of its own: it has its own storage directory
+// under the root, its own graph and its own transactions, so nothing one
+// database does is visible in another. It is safe for concurrent use.
+type Databases struct {
+	root string
+	mu   sync.RWMutex
+	dbs  map[string]*GraphDB
+}
+
+// NewDatabases creates an empty set whose databases are stored in
+// subdirectories of root
+func NewDatabases(root string) (*Databases, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	return &Databases{root: root, dbs: make(map[string]*GraphDB)}, nil
+}
+
+// Create creates the database name in its own directory
+func (d *Databases) Create(name string) (*GraphDB, error) {
+	if !validName.MatchString(name) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidDatabaseName, name)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.dbs[name]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseExists, name)
+	}
+	if d.root == "" {
+		return nil, fmt.Errorf("%w: a single-database server cannot create %s", ErrPermissionDenied, name)
+	}
+
+	dir := filepath.Join(d.root, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db, err := NewGraphDB(dir)
+	if err != nil {
+		return nil, err
+	}
+	d.dbs[name] = db
+	return db, nil
+}
+
+// Get returns the database name
+func (d *Databases) Get(name string) (*GraphDB, error) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	db, ok := d.dbs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrDatabaseNotFound, name)
+	}
+	return db, nil
+}
+
+// Names returns the names of the databases in order
+func (d *Databases) Names() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	names := make([]string, 0, len(d.dbs))
+	for name := range d.dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes every database
+func (d *Databases) Close() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	var errs []error
+	for _, db := range d.dbs {
+		errs = append(errs, db.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// single wraps db as the default database of a set that cannot grow
+func single(db *GraphDB) *Databases {
+	return &Databases{dbs: map[string]*GraphDB{DefaultDatabase: db}}
+}
@@ -0,0 +1,233 @@
+package minigraphdb
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// edgeKey identifies an edge: at most one edge of a label joins two nodes
+type edgeKey struct {
+	label    string
+	from, to int64
+}
+
+// versioned is a committed node or edge stamped with its commit timestamp.
+// Nodes and edges are immutable once committed, so each has one version.
+type versioned[T any] struct {
+	ts    uint64
+	value T
+}
+
+// GraphStore holds the committed graph
+type GraphStore struct {
+	mu    sync.RWMutex
+	nodes map[int64]versioned[*Node]
+	edges map[edgeKey]versioned[*Edge]
+	out   map[int64][]edgeKey // outgoing edges in commit order
+}
+
+// NewGraphStore creates an empty graph
+func NewGraphStore() *GraphStore {
+	return &GraphStore{
+		nodes: make(map[int64]versioned[*Node]),
+		edges: make(map[edgeKey]versioned[*Edge]),
+		out:   make(map[int64][]edgeKey),
+	}
+}
+
+// TransactionManager hands out snapshots and serializes commits
+type TransactionManager struct {
+	graph  *GraphStore
+	clock  atomic.Uint64 // timestamp of the newest commit
+	nextID atomic.Uint64
+	active atomic.Int64
+}
+
+// NewTransactionManager creates a transaction manager over graph
+func NewTransactionManager(graph *GraphStore) *TransactionManager {
+	return &TransactionManager{graph: graph}
+}
+
+// Transaction reads the graph as of its start and buffers its writes until
+// commit. It is not safe for concurrent use.
+type Transaction struct {
+	db      *GraphDB
+	id      uint64
+	startTS uint64
+	nodes   map[int64]*Node
+	edges   map[edgeKey]*Edge
+	order   []edgeKey // own edges in creation order
+	done    bool
+}
+
+// Begin starts a transaction that sees every commit made so far
+func (tm *TransactionManager) Begin() *Transaction {
+	tm.active.Add(1)
+	return &Transaction{
+		id:      tm.nextID.Add(1),
+		startTS: tm.clock.Load(),
+		nodes:   make(map[int64]*Node),
+		edges:   make(map[edgeKey]*Edge),
+	}
+}
+
+// Commit installs txn's writes under a new timestamp. The first transaction
+// to commit a node or edge wins; later ones fail with ErrWriteConflict.
+func (tm *TransactionManager) Commit(txn *Transaction) error {
+	if txn.done {
+		return ErrTxnClosed
+	}
+	defer tm.finish(txn)
+
+	g := tm.graph
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for id := range txn.nodes {
+		if _, ok := g.nodes[id]; ok {
+			return ErrWriteConflict
+		}
+	}
+	for key := range txn.edges {
+		if _, ok := g.edges[key]; ok {
+			return ErrWriteConflict
+		}
+	}
+
+	// Publish the timestamp last so a snapshot taken at it sees every write
+	ts := tm.clock.Load() + 1
+	for id, node := range txn.nodes {
+		g.nodes[id] = versioned[*Node]{ts, node}
+	}
+	for _, key := range txn.order {
+		g.edges[key] = versioned[*Edge]{ts, txn.edges[key]}
+		g.out[key.from] = append(g.out[key.from], key)
+	}
+	tm.clock.Store(ts)
+	return nil
+}
+
+// Rollback discards txn's writes
+func (tm *TransactionManager) Rollback(txn *Transaction) error {
+	if txn.done {
+		return ErrTxnClosed
+	}
+	tm.finish(txn)
+	return nil
+}
+
+func (tm *TransactionManager) finish(txn *Transaction) {
+	txn.done = true
+	txn.nodes, txn.edges, txn.order = nil, nil, nil
+	tm.active.Add(-1)
+}
+
+// Active returns the number of open transactions
+func (tm *TransactionManager) Active() int {
+	return int(tm.active.Load())
+}
+
+// Execute runs one query inside the transaction
+func (txn *Transaction) Execute(query string) (*ResultSet, error) {
+	if txn.done {
+		return nil, ErrTxnClosed
+	}
+	stmt, err := txn.db.queryEngine.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := stmt.(txnControl); ok {
+		return nil, ErrTxnControl
+	}
+	return txn.db.queryEngine.Execute(txn, stmt)
+}
+
+// node returns the node with id as the transaction sees it
+func (txn *Transaction) node(id int64) (*Node, bool) {
+	if node, ok := txn.nodes[id]; ok {
+		return node, true
+	}
+	g := txn.db.graph
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	v, ok := g.nodes[id]
+	if !ok || v.ts > txn.startTS {
+		return nil, false
+	}
+	return v.value, true
+}
+
+// hasEdge reports whether the transaction sees an edge with key
+func (txn *Transaction) hasEdge(key edgeKey) bool {
+	if _, ok := txn.edges[key]; ok {
+		return true
+	}
+	g := txn.db.graph
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	v, ok := g.edges[key]
+	return ok && v.ts <= txn.startTS
+}
+
+// allNodes returns the nodes the transaction sees, ordered by ID
+func (txn *Transaction) allNodes() []*Node {
+	var nodes []*Node
+	g := txn.db.graph
+	g.mu.RLock()
+	for _, v := range g.nodes {
+		if v.ts <= txn.startTS {
+			nodes = append(nodes, v.value)
+		}
+	}
+	g.mu.RUnlock()
+	for _, node := range txn.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes
+}
+
+// outEdges returns the edges leaving id that the transaction sees,
+// committed ones first
+func (txn *Transaction) outEdges(id int64) []*Edge {
+	var edges []*Edge
+	g := txn.db.graph
+	g.mu.RLock()
+	for _, key := range g.out[id] {
+		if v := g.edges[key]; v.ts <= txn.startTS {
+			edges = append(edges, v.value)
+		}
+	}
+	g.mu.RUnlock()
+	for _, key := range txn.order {
+		if key.from == id {
+			edges = append(edges, txn.edges[key])
+		}
+	}
+	return edges
+}
+
+// createNode buffers a new node
+func (txn *Transaction) createNode(node *Node) error {
+	if _, ok := txn.node(node.ID); ok {
+		return ErrNodeExists
+	}
+	txn.nodes[node.ID] = node
+	return nil
+}
+
+// createEdge buffers a new edge between two nodes the transaction sees
+func (txn *Transaction) createEdge(edge *Edge) error {
+	for _, id := range []int64{edge.From, edge.To} {
+		if _, ok := txn.node(id); !ok {
+			return ErrNodeNotFound
+		}
+	}
+	key := edgeKey{edge.Label, edge.From, edge.To}
+	if txn.hasEdge(key) {
+		return ErrEdgeExists
+	}
+	txn.edges[key] = edge
+	txn.order = append(txn.order, key)
+	return nil
+}
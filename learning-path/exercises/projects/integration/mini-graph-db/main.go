@@ -1,54 +1,142 @@
 package minigraphdb
 
-import "errors"
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	ErrNodeExists      = errors.New("node already exists")
+	ErrEdgeExists      = errors.New("edge already exists")
+	ErrNodeNotFound    = errors.New("node not found")
+	ErrWriteConflict   = errors.New("write conflict: another transaction committed first")
+	ErrTxnClosed       = errors.New("transaction already committed or rolled back")
+	ErrTxnControl      = errors.New("BEGIN, COMMIT and ROLLBACK need a session")
+	ErrDatabaseClosed  = errors.New("database is closed")
+	ErrTxnInProgress   = errors.New("transaction already in progress")
+	ErrNoTransaction   = errors.New("no transaction in progress")
+	ErrSessionClosed   = errors.New("session is closed")
+	ErrSessionIdle     = errors.New("session closed after idle timeout")
+	ErrSyntax          = errors.New("syntax error")
+	ErrUnknownVariable = errors.New("unknown variable")
+
+	ErrAuthRequired        = errors.New("authentication required")
+	ErrAuthFailed          = errors.New("invalid user or password")
+	ErrPermissionDenied    = errors.New("permission denied")
+	ErrInvalidUser         = errors.New("invalid user")
+	ErrDatabaseExists      = errors.New("database already exists")
+	ErrDatabaseNotFound    = errors.New("database not found")
+	ErrInvalidDatabaseName = errors.New("invalid database name")
+)
 
 // GraphDB is the main database interface
 type GraphDB struct {
-	storage  *StorageManager
-	graph    *GraphStore
-	txnMgr   *TransactionManager
+	storage     *StorageManager
+	graph       *GraphStore
+	txnMgr      *TransactionManager
 	queryEngine *QueryEngine
+	closed      atomic.Bool
 }
 
-// NewGraphDB creates a new graph database
+// NewGraphDB creates a new graph database. The graph is kept in memory;
+// dbPath is reserved for the storage layer.
 func NewGraphDB(dbPath string) (*GraphDB, error) {
-	// TODO: Initialize all components
-	return nil, nil
+	graph := NewGraphStore()
+	return &GraphDB{
+		storage:     &StorageManager{path: dbPath},
+		graph:       graph,
+		txnMgr:      NewTransactionManager(graph),
+		queryEngine: &QueryEngine{},
+	}, nil
 }
 
-// ExecuteQuery executes a query string
+// ExecuteQuery executes a query string in its own transaction
 func (db *GraphDB) ExecuteQuery(query string) (*ResultSet, error) {
-	// TODO: Parse -> Optimize -> Execute
-	return nil, nil
+	stmt, err := db.queryEngine.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := stmt.(txnControl); ok {
+		return nil, ErrTxnControl
+	}
+	return db.autocommit(stmt)
+}
+
+// autocommit runs stmt in a transaction of its own
+func (db *GraphDB) autocommit(stmt statement) (*ResultSet, error) {
+	txn, err := db.BeginTransaction()
+	if err != nil {
+		return nil, err
+	}
+	result, err := db.queryEngine.Execute(txn, stmt)
+	if err != nil {
+		db.Rollback(txn)
+		return nil, err
+	}
+	if err := db.Commit(txn); err != nil {
+		return nil, err
+	}
+	return result, nil
 }
 
-// BeginTransaction starts a transaction
+// BeginTransaction starts a snapshot-isolated transaction
 func (db *GraphDB) BeginTransaction() (*Transaction, error) {
-	// TODO: Create transaction
-	return nil, nil
+	if db.closed.Load() {
+		return nil, ErrDatabaseClosed
+	}
+	txn := db.txnMgr.Begin()
+	txn.db = db
+	return txn, nil
 }
 
-// Commit commits a transaction
+// Commit commits a transaction. It fails with ErrWriteConflict if a
+// transaction that committed after txn began wrote the same node or edge.
 func (db *GraphDB) Commit(txn *Transaction) error {
-	// TODO: Commit with WAL
-	return nil
+	return db.txnMgr.Commit(txn)
 }
 
-// Rollback aborts a transaction
+// Rollback aborts a transaction, discarding its writes
 func (db *GraphDB) Rollback(txn *Transaction) error {
-	// TODO: Rollback changes
-	return nil
+	return db.txnMgr.Rollback(txn)
+}
+
+// ActiveTransactions returns the number of transactions not yet committed
+// or rolled back
+func (db *GraphDB) ActiveTransactions() int {
+	return db.txnMgr.Active()
 }
 
-// Close closes the database
+// Close closes the database. New transactions are refused; open ones can
+// still finish.
 func (db *GraphDB) Close() error {
-	// TODO: Cleanup all resources
+	db.closed.Store(true)
 	return nil
 }
 
-type StorageManager struct{}
-type GraphStore struct{}
-type TransactionManager struct{}
-type QueryEngine struct{}
-type Transaction struct{}
-type ResultSet struct{}
+// StorageManager will persist the graph; for now it only records the path
+type StorageManager struct {
+	path string
+}
+
+// Node is a vertex with a label and properties. Its "id" property is the
+// node ID.
+type Node struct {
+	ID    int64
+	Label string
+	Props map[string]any
+}
+
+// Edge is a directed, labelled edge. Its "from" and "to" properties are
+// the endpoint IDs.
+type Edge struct {
+	Label string
+	From  int64
+	To    int64
+	Props map[string]any
+}
+
+// ResultSet is the result of a query. Writes return an empty result.
+type ResultSet struct {
+	Columns []string `json:"columns,omitempty"`
+	Rows    [][]any  `json:"rows,omitempty"`
+}
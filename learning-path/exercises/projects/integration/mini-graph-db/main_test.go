@@ -1,25 +1,472 @@
 package minigraphdb
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestDB(t testing.TB, queries ...string) *GraphDB {
+	t.Helper()
+	db, err := NewGraphDB(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range queries {
+		if _, err := db.ExecuteQuery(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	return db
+}
+
+var socialGraph = []string{
+	`CREATE NODE person {id: 1, name: "Alice", age: 30}`,
+	`CREATE NODE person {id: 2, name: "Bob", age: 25}`,
+	`CREATE NODE person {id: 3, name: "Carol", age: 35}`,
+	`CREATE NODE city {id: 10, name: "Paris"}`,
+	`CREATE EDGE knows {from: 1, to: 2, since: 2020}`,
+	`CREATE EDGE knows {from: 1, to: 3}`,
+	`CREATE EDGE lives_in {from: 1, to: 10}`,
+	`CREATE EDGE knows {from: 2, to: 3}`,
+}
+
+func query(t *testing.T, db *GraphDB, q string) [][]any {
+	t.Helper()
+	result, err := db.ExecuteQuery(q)
+	if err != nil {
+		t.Fatalf("%s: %v", q, err)
+	}
+	return result.Rows
+}
 
 func TestCreateNode(t *testing.T) {
-	// TODO: Test node creation
-	t.Skip("not implemented")
+	db := newTestDB(t, socialGraph[:4]...)
+	rows := query(t, db, `MATCH (p:person) RETURN p, p.name, p.age`)
+	want := [][]any{{int64(1), "Alice", int64(30)}, {int64(2), "Bob", int64(25)}, {int64(3), "Carol", int64(35)}}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+
+	if _, err := db.ExecuteQuery(`CREATE NODE person {id: 2, name: "Bob again"}`); !errors.Is(err, ErrNodeExists) {
+		t.Errorf("duplicate id: %v", err)
+	}
+	if _, err := db.ExecuteQuery(`CREATE NODE person {name: "no id"}`); !errors.Is(err, ErrSyntax) {
+		t.Errorf("missing id: %v", err)
+	}
 }
 
 func TestCreateEdge(t *testing.T) {
-	// TODO: Test edge creation
-	t.Skip("not implemented")
+	db := newTestDB(t, socialGraph...)
+	if _, err := db.ExecuteQuery(`CREATE EDGE knows {from: 1, to: 99}`); !errors.Is(err, ErrNodeNotFound) {
+		t.Errorf("edge to missing node: %v", err)
+	}
+	if _, err := db.ExecuteQuery(`CREATE EDGE knows {from: 1, to: 2}`); !errors.Is(err, ErrEdgeExists) {
+		t.Errorf("duplicate edge: %v", err)
+	}
+	rows := query(t, db, `MATCH (a)-[]->(b) RETURN a, b`)
+	if len(rows) != 4 {
+		t.Errorf("%d edges, want 4: %v", len(rows), rows)
+	}
 }
 
 func TestPatternMatch(t *testing.T) {
-	// TODO: Test pattern matching query
-	t.Skip("not implemented")
+	db := newTestDB(t, socialGraph...)
+	for _, tc := range []struct {
+		query string
+		want  [][]any
+	}{
+		{`MATCH (p:person)-[:knows]->(friend) WHERE p.name = "Alice" RETURN friend.name, friend.age`,
+			[][]any{{"Bob", int64(25)}, {"Carol", int64(35)}}},
+		{`MATCH (p)-[:knows]->(f:person) WHERE f.age >= 30 AND p.age < 30 RETURN p.name, f.name`,
+			[][]any{{"Bob", "Carol"}}},
+		{`MATCH (p:person)-[:lives_in]->(c) RETURN p.name, c.name`,
+			[][]any{{"Alice", "Paris"}}},
+		{`match (p:person) where p.age > 29.5 return p.name`,
+			[][]any{{"Alice"}, {"Carol"}}},
+		{`MATCH (p:person) WHERE p.name != 'Bob' RETURN p.missing`,
+			[][]any{{nil}, {nil}}},
+	} {
+		if rows := query(t, db, tc.query); !reflect.DeepEqual(rows, tc.want) {
+			t.Errorf("%s\n got %v\nwant %v", tc.query, rows, tc.want)
+		}
+	}
+
+	for _, q := range []string{
+		`MATCH (p:person) RETURN q.name`,
+		`MATCH (p)-[:knows]->(p) RETURN p`,
+		`MATCH (p) WHERE p.age ~ 3 RETURN p`,
+		`MATCH (p) RETURN`,
+		`DROP NODE 1`,
+	} {
+		if _, err := db.ExecuteQuery(q); !errors.Is(err, ErrSyntax) && !errors.Is(err, ErrUnknownVariable) {
+			t.Errorf("%s: err = %v", q, err)
+		}
+	}
+}
+
+func TestAggregation(t *testing.T) {
+	db := newTestDB(t, append(socialGraph,
+		`CREATE NODE person {id: 4, name: "Dan", age: 40.5}`,
+		`CREATE EDGE knows {from: 4, to: 2}`,
+		`CREATE EDGE knows {from: 4, to: 3}`,
+		`CREATE EDGE knows {from: 4, to: 10}`,
+	)...)
+	for _, tc := range []struct {
+		query   string
+		columns []string
+		want    [][]any
+	}{
+		// Grouped by the items without an aggregate
+		{`MATCH (p:person)-[:knows]->(f) RETURN p.name, count(*), avg(f.age), count(f.age)`,
+			[]string{"p.name", "count(*)", "avg(f.age)", "count(f.age)"},
+			[][]any{{"Alice", int64(2), 30.0, int64(2)}, {"Bob", int64(1), 35.0, int64(1)}, {"Dan", int64(3), 30.0, int64(2)}}},
+		// A single group without them, even over no rows
+		{`MATCH (p:person) RETURN count(*) AS people, sum(p.age), min(p.name), max(p.age)`,
+			[]string{"people", "sum(p.age)", "min(p.name)", "max(p.age)"},
+			[][]any{{int64(4), 130.5, "Alice", 40.5}}},
+		{`MATCH (p:planet) RETURN count(*), sum(p.mass), avg(p.mass)`,
+			[]string{"count(*)", "sum(p.mass)", "avg(p.mass)"},
+			[][]any{{int64(0), int64(0), nil}}},
+		{`MATCH (p:planet) RETURN p.name, count(*)`, []string{"p.name", "count(*)"}, nil},
+		// WITH ... WHERE filters groups, like HAVING
+		{`MATCH (p:person)-[:knows]->(f) WITH p.name AS name, count(*) AS friends WHERE friends > 1 RETURN name, friends`,
+			[]string{"name", "friends"},
+			[][]any{{"Alice", int64(2)}, {"Dan", int64(3)}}},
+		{`MATCH (p)-[:knows]->(f:person) WITH f.name, count(*) AS n WHERE n >= 2 AND f.name != "Bob" RETURN f.name`,
+			[]string{"f.name"},
+			[][]any{{"Carol"}}},
+		// and its rows can be aggregated again
+		{`MATCH (p:person)-[:knows]->(f) WITH p, count(*) AS n RETURN count(*), sum(n), max(n)`,
+			[]string{"count(*)", "sum(n)", "max(n)"},
+			[][]any{{int64(3), int64(6), int64(3)}}},
+	} {
+		result, err := db.ExecuteQuery(tc.query)
+		if err != nil {
+			t.Errorf("%s: %v", tc.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(result.Columns, tc.columns) || !reflect.DeepEqual(result.Rows, tc.want) {
+			t.Errorf("%s\n got %v %v\nwant %v %v", tc.query, result.Columns, result.Rows, tc.columns, tc.want)
+		}
+	}
+
+	for q, want := range map[string]error{
+		`MATCH (p) RETURN median(p.age)`:                    ErrSyntax,
+		`MATCH (p) RETURN count(*`:                          ErrSyntax,
+		`MATCH (p) RETURN sum(q.age)`:                       ErrUnknownVariable,
+		`MATCH (p) WITH p.name AS name RETURN p.age`:        ErrUnknownVariable,
+		`MATCH (p) WITH count(*) AS n WHERE m > 1 RETURN n`: ErrUnknownVariable,
+	} {
+		if _, err := db.ExecuteQuery(q); !errors.Is(err, want) {
+			t.Errorf("%s: err = %v, want %v", q, err, want)
+		}
+	}
 }
 
 func TestTransaction(t *testing.T) {
-	// TODO: Test transaction commit/rollback
-	t.Skip("not implemented")
+	db := newTestDB(t, socialGraph[:2]...)
+	txn, err := db.BeginTransaction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.Execute(`CREATE NODE person {id: 3, name: "Carol"}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := txn.Execute(`CREATE EDGE knows {from: 3, to: 1}`); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := txn.Execute(`MATCH (a)-[:knows]->(b) RETURN a.name, b.name`); len(result.Rows) != 1 {
+		t.Errorf("transaction should see its own writes, got %v", result.Rows)
+	}
+	if rows := query(t, db, `MATCH (p) RETURN p`); len(rows) != 2 {
+		t.Errorf("uncommitted node visible outside its transaction: %v", rows)
+	}
+	if err := db.Rollback(txn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Commit(txn); !errors.Is(err, ErrTxnClosed) {
+		t.Errorf("commit after rollback: %v", err)
+	}
+	if rows := query(t, db, `MATCH (p) RETURN p`); len(rows) != 2 {
+		t.Errorf("rolled back node visible: %v", rows)
+	}
+
+	// Snapshot isolation: t1 does not see t2's commit, and the second
+	// writer of node 3 loses
+	t1, _ := db.BeginTransaction()
+	t2, _ := db.BeginTransaction()
+	t2.Execute(`CREATE NODE person {id: 3, name: "Carol"}`)
+	if err := db.Commit(t2); err != nil {
+		t.Fatal(err)
+	}
+	if result, _ := t1.Execute(`MATCH (p) RETURN p`); len(result.Rows) != 2 {
+		t.Errorf("t1 sees a commit made after it began: %v", result.Rows)
+	}
+	t1.Execute(`CREATE NODE person {id: 3, name: "Other Carol"}`)
+	if err := db.Commit(t1); !errors.Is(err, ErrWriteConflict) {
+		t.Errorf("conflicting commit: %v", err)
+	}
+	if n := db.ActiveTransactions(); n != 0 {
+		t.Errorf("%d transactions still active", n)
+	}
+}
+
+func TestSessionTransaction(t *testing.T) {
+	db := newTestDB(t, socialGraph[:2]...)
+	s := db.NewSession()
+	for _, q := range []string{"BEGIN", `CREATE NODE person {id: 3, name: "Carol"}`, `CREATE EDGE knows {from: 1, to: 3}`} {
+		if _, err := s.Execute(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if _, err := s.Execute("BEGIN"); !errors.Is(err, ErrTxnInProgress) {
+		t.Errorf("nested BEGIN: %v", err)
+	}
+	// A failed statement leaves the transaction open
+	if _, err := s.Execute(`CREATE EDGE knows {from: 1, to: 42}`); !errors.Is(err, ErrNodeNotFound) || !s.InTransaction() {
+		t.Errorf("failed statement: %v, in transaction %v", err, s.InTransaction())
+	}
+	if _, err := s.Execute("COMMIT"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Execute("ROLLBACK"); !errors.Is(err, ErrNoTransaction) {
+		t.Errorf("ROLLBACK without BEGIN: %v", err)
+	}
+	if rows := query(t, db, `MATCH (a)-[:knows]->(b) RETURN b.name`); !reflect.DeepEqual(rows, [][]any{{"Carol"}}) {
+		t.Errorf("committed edge: %v", rows)
+	}
+	if _, err := db.ExecuteQuery("BEGIN"); !errors.Is(err, ErrTxnControl) {
+		t.Errorf("BEGIN outside a session: %v", err)
+	}
+
+	s.Execute("BEGIN")
+	s.Execute(`CREATE NODE person {id: 4}`)
+	s.Close()
+	if n := db.ActiveTransactions(); n != 0 {
+		t.Errorf("Close left %d transactions active", n)
+	}
+	if _, err := s.Execute(`MATCH (p) RETURN p`); !errors.Is(err, ErrSessionClosed) {
+		t.Errorf("query on closed session: %v", err)
+	}
+}
+
+func startTestServer(t *testing.T, db *GraphDB, opts ServerOptions) *Server {
+	t.Helper()
+	server, err := StartServer(db, "127.0.0.1:0", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	return server
+}
+
+func dial(t *testing.T, server *Server) *Client {
+	t.Helper()
+	c, err := Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServerTransaction(t *testing.T) {
+	db := newTestDB(t, socialGraph[:2]...)
+	server := startTestServer(t, db, ServerOptions{})
+	writer, reader := dial(t, server), dial(t, server)
+
+	for _, q := range []string{"BEGIN", `CREATE NODE person {id: 3, name: "Carol", score: 1.5}`, `CREATE EDGE knows {from: 2, to: 3}`} {
+		if _, err := writer.Query(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if !writer.InTransaction() {
+		t.Error("writer should be in a transaction")
+	}
+	result, err := writer.Query(`MATCH (a)-[:knows]->(b) RETURN a.name, b.name, b.score`)
+	if err != nil || !reflect.DeepEqual(result.Rows, [][]any{{"Bob", "Carol", 1.5}}) {
+		t.Errorf("own writes: %v, %v", result, err)
+	}
+	if result, _ := reader.Query(`MATCH (p) RETURN p`); len(result.Rows) != 2 {
+		t.Errorf("other session sees uncommitted writes: %v", result.Rows)
+	}
+	var serverErr *ServerError
+	if _, err := writer.Query(`CREATE NODE person {id: 1}`); !errors.As(err, &serverErr) || !strings.Contains(err.Error(), ErrNodeExists.Error()) {
+		t.Errorf("duplicate node over the wire: %v", err)
+	}
+
+	if _, err := writer.Query("COMMIT"); err != nil || writer.InTransaction() {
+		t.Fatalf("COMMIT: %v, in transaction %v", err, writer.InTransaction())
+	}
+	if result, _ := reader.Query(`MATCH (p) RETURN p`); len(result.Rows) != 3 {
+		t.Errorf("commit not visible to other session: %v", result.Rows)
+	}
+}
+
+func TestServerRollbackOnDisconnect(t *testing.T) {
+	db := newTestDB(t)
+	server := startTestServer(t, db, ServerOptions{})
+	c := dial(t, server)
+	c.Query("BEGIN")
+	c.Query(`CREATE NODE person {id: 1}`)
+	if n := db.ActiveTransactions(); n != 1 {
+		t.Fatalf("%d active transactions, want 1", n)
+	}
+
+	c.Close()
+	waitFor(t, "rollback on disconnect", func() bool { return db.ActiveTransactions() == 0 })
+	if rows := query(t, db, `MATCH (p) RETURN p`); len(rows) != 0 {
+		t.Errorf("disconnected session's writes committed: %v", rows)
+	}
+}
+
+func TestServerIdleTimeout(t *testing.T) {
+	db := newTestDB(t)
+	server := startTestServer(t, db, ServerOptions{IdleTimeout: 50 * time.Millisecond})
+	c := dial(t, server)
+	c.Query("BEGIN")
+	c.Query(`CREATE NODE person {id: 1}`)
+
+	waitFor(t, "idle session rollback", func() bool { return db.ActiveTransactions() == 0 })
+	if _, err := c.Query(`COMMIT`); err == nil || !strings.Contains(err.Error(), ErrSessionIdle.Error()) {
+		t.Errorf("query after idle timeout: %v", err)
+	}
+	if rows := query(t, db, `MATCH (p) RETURN p`); len(rows) != 0 {
+		t.Errorf("idle session's writes committed: %v", rows)
+	}
+
+	// Server shutdown rolls back open transactions too
+	server, err := StartServer(db, "127.0.0.1:0", ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dial(t, server).Query("BEGIN")
+	if err := server.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := db.ActiveTransactions(); n != 0 {
+		t.Errorf("%d transactions active after server Close", n)
+	}
+}
+
+func TestServerAuth(t *testing.T) {
+	db := newTestDB(t, socialGraph[:2]...)
+	users := NewUserStore()
+	for name, role := range map[string]Role{"reader": RoleRead, "writer": RoleWrite} {
+		if err := users.SetUser(name, "secret", role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := users.SetUser("nobody", "secret", RoleNone); !errors.Is(err, ErrInvalidUser) {
+		t.Errorf("user without a role: %v", err)
+	}
+	server := startTestServer(t, db, ServerOptions{Users: users})
+
+	c := dial(t, server)
+	if _, err := c.Query(`MATCH (p) RETURN p`); err == nil || !strings.Contains(err.Error(), ErrAuthRequired.Error()) {
+		t.Errorf("query before AUTH: %v", err)
+	}
+	if err := c.Auth("reader", "wrong"); err == nil || !strings.Contains(err.Error(), ErrAuthFailed.Error()) {
+		t.Errorf("wrong password: %v", err)
+	}
+	if err := c.Auth("reader", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := c.Query(`MATCH (p) RETURN p`); err != nil || len(result.Rows) != 2 {
+		t.Errorf("reader MATCH: %v, %v", result, err)
+	}
+	if _, err := c.Query(`CREATE NODE person {id: 3}`); err == nil || !strings.Contains(err.Error(), ErrPermissionDenied.Error()) {
+		t.Errorf("reader CREATE: %v", err)
+	}
+	if _, err := c.Query(`BEGIN`); err != nil {
+		t.Errorf("reader BEGIN: %v", err)
+	}
+
+	w := dial(t, server)
+	if err := w.Auth("writer", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Query(`CREATE NODE person {id: 3}`); err != nil {
+		t.Errorf("writer CREATE: %v", err)
+	}
+	if _, err := w.Query(`CREATE DATABASE other`); err == nil || !strings.Contains(err.Error(), ErrPermissionDenied.Error()) {
+		t.Errorf("writer CREATE DATABASE: %v", err)
+	}
+}
+
+func TestServerDatabases(t *testing.T) {
+	root := t.TempDir()
+	dbs, err := NewDatabases(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbs.Close()
+	if _, err := dbs.Create(DefaultDatabase); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbs.Create("../escape"); !errors.Is(err, ErrInvalidDatabaseName) {
+		t.Errorf("invalid name: %v", err)
+	}
+	server, err := ServeDatabases(dbs, "127.0.0.1:0", ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+
+	c := dial(t, server)
+	for _, q := range []string{`CREATE DATABASE a`, `CREATE DATABASE b`, `USE a`, `CREATE NODE person {id: 1, name: "Alice"}`} {
+		if _, err := c.Query(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if _, err := c.Query(`CREATE DATABASE a`); err == nil || !strings.Contains(err.Error(), ErrDatabaseExists.Error()) {
+		t.Errorf("duplicate database: %v", err)
+	}
+	if err := c.Use("b"); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := c.Query(`MATCH (p) RETURN p`); err != nil || len(result.Rows) != 0 {
+		t.Errorf("database b sees a's node: %v, %v", result, err)
+	}
+	c.Query(`BEGIN`)
+	if err := c.Use("a"); err == nil || !strings.Contains(err.Error(), ErrTxnInProgress.Error()) {
+		t.Errorf("USE inside a transaction: %v", err)
+	}
+	c.Query(`ROLLBACK`)
+	if err := c.Use("missing"); err == nil || !strings.Contains(err.Error(), ErrDatabaseNotFound.Error()) {
+		t.Errorf("USE of a missing database: %v", err)
+	}
+
+	// A new connection starts in the default database
+	if result, err := dial(t, server).Query(`MATCH (p) RETURN p`); err != nil || len(result.Rows) != 0 {
+		t.Errorf("default database: %v, %v", result, err)
+	}
+	if got := dbs.Names(); !reflect.DeepEqual(got, []string{"a", "b", DefaultDatabase}) {
+		t.Errorf("Names() = %v", got)
+	}
+	for _, name := range dbs.Names() {
+		if _, err := os.Stat(filepath.Join(root, name)); err != nil {
+			t.Errorf("database %s has no directory: %v", name, err)
+		}
+	}
 }
 
 func TestCrashRecovery(t *testing.T) {
@@ -28,11 +475,44 @@ func TestCrashRecovery(t *testing.T) {
 }
 
 func TestConcurrentQueries(t *testing.T) {
-	// TODO: Test concurrent access
-	t.Skip("not implemented")
+	db := newTestDB(t)
+	var wg sync.WaitGroup
+	for w := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := db.NewSession()
+			defer s.Close()
+			for i := range 50 {
+				id := w*100 + i
+				s.Execute("BEGIN")
+				s.Execute(fmt.Sprintf(`CREATE NODE n {id: %d}`, id))
+				if i > 0 {
+					s.Execute(fmt.Sprintf(`CREATE EDGE next {from: %d, to: %d}`, id-1, id))
+				}
+				if _, err := s.Execute("COMMIT"); err != nil {
+					t.Error(err)
+					return
+				}
+				s.Execute(`MATCH (a)-[:next]->(b) RETURN a`)
+			}
+		}()
+	}
+	wg.Wait()
+	if rows := query(t, db, `MATCH (a)-[:next]->(b) RETURN a`); len(rows) != 8*49 {
+		t.Errorf("%d edges, want %d", len(rows), 8*49)
+	}
 }
 
 func BenchmarkQuery(b *testing.B) {
-	// TODO: Benchmark query performance
-	b.Skip("not implemented")
+	db := newTestDB(b)
+	for i := range 1000 {
+		db.ExecuteQuery(fmt.Sprintf(`CREATE NODE person {id: %d, age: %d}`, i, i%80))
+		if i > 0 {
+			db.ExecuteQuery(fmt.Sprintf(`CREATE EDGE knows {from: %d, to: %d}`, i-1, i))
+		}
+	}
+	for b.Loop() {
+		db.ExecuteQuery(`MATCH (p:person)-[:knows]->(f) WHERE p.age = 30 RETURN f.age`)
+	}
 }
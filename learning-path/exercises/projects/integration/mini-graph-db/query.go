@@ -0,0 +1,707 @@
+package minigraphdb
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// QueryEngine parses and executes queries:
+//
+//	CREATE NODE person {id: 1, name: "Alice", age: 30}
+//	CREATE EDGE knows {from: 1, to: 2, since: 2020}
+//	MATCH (p:person)-[:knows]->(friend) WHERE p.name = "Alice" RETURN friend.name
+//	MATCH (p:person)-[:knows]->(f) RETURN p.name, count(*), avg(f.age)
+//	BEGIN | COMMIT | ROLLBACK
+type QueryEngine struct{}
+
+// statement is a parsed query
+type statement interface {
+	execute(txn *Transaction) (*ResultSet, error)
+}
+
+// txnControl is BEGIN, COMMIT or ROLLBACK, which only a Session executes
+type txnControl string
+
+func (txnControl) execute(*Transaction) (*ResultSet, error) { return nil, ErrTxnControl }
+
+// writes reports whether stmt creates nodes or edges
+func writes(stmt statement) bool {
+	switch stmt.(type) {
+	case createNode, createEdge:
+		return true
+	}
+	return false
+}
+
+// Parse parses a single statement
+func (qe *QueryEngine) Parse(query string) (statement, error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return stmt, nil
+}
+
+// Execute runs stmt inside txn
+func (qe *QueryEngine) Execute(txn *Transaction, stmt statement) (*ResultSet, error) {
+	if txn.done {
+		return nil, ErrTxnClosed
+	}
+	return stmt.execute(txn)
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokInt
+	tokFloat
+	tokString
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string // for strings, the unquoted value
+	pos  int
+}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case isLetter(c):
+			j := i
+			for j < len(src) && (isLetter(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, src[i:j], i})
+			i = j
+		case isDigit(c) || c == '-' && i+1 < len(src) && isDigit(src[i+1]):
+			j, kind := i+1, tokInt
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+				if src[j] == '.' {
+					kind = tokFloat
+				}
+				j++
+			}
+			tokens = append(tokens, token{kind, src[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				sb.WriteByte(src[j])
+			}
+			if j == len(src) {
+				return nil, fmt.Errorf("%w: unterminated string at offset %d", ErrSyntax, i)
+			}
+			tokens = append(tokens, token{tokString, sb.String(), i})
+			i = j + 1
+		default:
+			if i+1 < len(src) {
+				switch two := src[i : i+2]; two {
+				case "->", "<=", ">=", "!=", "<>":
+					tokens = append(tokens, token{tokSymbol, two, i})
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("()[]{}:,.=<>-*", rune(c)) {
+				return nil, fmt.Errorf("%w: unexpected character %q at offset %d", ErrSyntax, c, i)
+			}
+			tokens = append(tokens, token{tokSymbol, string(c), i})
+			i++
+		}
+	}
+	return append(tokens, token{tokEOF, "", len(src)}), nil
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) errorf(t token, format string, args ...any) error {
+	return fmt.Errorf("%w: %s at offset %d", ErrSyntax, fmt.Sprintf(format, args...), t.pos)
+}
+
+// keyword consumes the next token if it is the keyword kw, in any case
+func (p *parser) keyword(kw string) bool {
+	if t := p.peek(); t.kind == tokIdent && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// symbol consumes the next token if it is the symbol sym
+func (p *parser) symbol(sym string) bool {
+	if t := p.peek(); t.kind == tokSymbol && t.text == sym {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(sym string) error {
+	if !p.symbol(sym) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, found %q", sym, t.text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.next()
+	if t.kind != tokIdent {
+		return "", p.errorf(t, "expected a name, found %q", t.text)
+	}
+	return t.text, nil
+}
+
+func (p *parser) statement() (statement, error) {
+	switch {
+	case p.keyword("BEGIN"):
+		return txnControl("BEGIN"), nil
+	case p.keyword("COMMIT"):
+		return txnControl("COMMIT"), nil
+	case p.keyword("ROLLBACK"):
+		return txnControl("ROLLBACK"), nil
+	case p.keyword("CREATE"):
+		return p.create()
+	case p.keyword("MATCH"):
+		return p.match()
+	}
+	t := p.peek()
+	return nil, p.errorf(t, "unknown statement %q", t.text)
+}
+
+func (p *parser) create() (statement, error) {
+	isNode := p.keyword("NODE")
+	if !isNode && !p.keyword("EDGE") {
+		t := p.peek()
+		return nil, p.errorf(t, "expected NODE or EDGE, found %q", t.text)
+	}
+	label, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	at := p.peek()
+	props, err := p.properties()
+	if err != nil {
+		return nil, err
+	}
+
+	if isNode {
+		id, ok := props["id"].(int64)
+		if !ok {
+			return nil, p.errorf(at, "node needs an integer id property")
+		}
+		return createNode{&Node{ID: id, Label: label, Props: props}}, nil
+	}
+	from, ok1 := props["from"].(int64)
+	to, ok2 := props["to"].(int64)
+	if !ok1 || !ok2 {
+		return nil, p.errorf(at, "edge needs integer from and to properties")
+	}
+	return createEdge{&Edge{Label: label, From: from, To: to, Props: props}}, nil
+}
+
+// properties parses {key: value, ...}
+func (p *parser) properties() (map[string]any, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	props := make(map[string]any)
+	for !p.symbol("}") {
+		if len(props) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		key, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if props[key], err = p.literal(); err != nil {
+			return nil, err
+		}
+	}
+	return props, nil
+}
+
+func (p *parser) literal() (any, error) {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		v, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, p.errorf(t, "bad integer %q", t.text)
+		}
+		return v, nil
+	case tokFloat:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "bad number %q", t.text)
+		}
+		return v, nil
+	case tokString:
+		return t.text, nil
+	case tokIdent:
+		switch strings.ToLower(t.text) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+	}
+	return nil, p.errorf(t, "expected a value, found %q", t.text)
+}
+
+type createNode struct{ node *Node }
+
+func (s createNode) execute(txn *Transaction) (*ResultSet, error) {
+	if err := txn.createNode(s.node); err != nil {
+		return nil, fmt.Errorf("%w: %d", err, s.node.ID)
+	}
+	return &ResultSet{}, nil
+}
+
+type createEdge struct{ edge *Edge }
+
+func (s createEdge) execute(txn *Transaction) (*ResultSet, error) {
+	if err := txn.createEdge(s.edge); err != nil {
+		return nil, fmt.Errorf("%w: %s %d -> %d", err, s.edge.Label, s.edge.From, s.edge.To)
+	}
+	return &ResultSet{}, nil
+}
+
+// nodePattern is (var:label); the label is optional
+type nodePattern struct {
+	variable string
+	label    string
+}
+
+// condition compares a node property with a literal
+type condition struct {
+	variable string
+	property string
+	op       string
+	value    any
+}
+
+// returnItem is var.property, or var alone for the node ID, optionally
+// inside an aggregate: count(*), count(x), sum(x), avg(x), min(x) or
+// max(x). After a WITH, an item reads a column of it instead.
+type returnItem struct {
+	variable string
+	property string
+	column   int    // column of the previous WITH, or -1
+	agg      string // aggregate function, or ""
+	star     bool   // count(*)
+	name     string // the AS alias, or the item as written
+}
+
+// matchQuery is a single node or a single hop, then any number of WITH
+// clauses and a RETURN:
+// MATCH (a)-[:label]->(b) WHERE cond AND ... [WITH item, ... [WHERE ...]] RETURN item, ...
+type matchQuery struct {
+	from      nodePattern
+	edgeLabel *string // nil when the pattern has no edge
+	to        nodePattern
+	where     []condition
+	stages    []projection // the WITH clauses, then the RETURN
+}
+
+func (p *parser) match() (statement, error) {
+	var q matchQuery
+	var err error
+	if q.from, err = p.nodePattern(); err != nil {
+		return nil, err
+	}
+	if p.symbol("-") {
+		if err := p.expect("["); err != nil {
+			return nil, err
+		}
+		label := ""
+		if p.symbol(":") {
+			if label, err = p.ident(); err != nil {
+				return nil, err
+			}
+		}
+		q.edgeLabel = &label
+		for _, sym := range []string{"]", "->"} {
+			if err := p.expect(sym); err != nil {
+				return nil, err
+			}
+		}
+		if q.to, err = p.nodePattern(); err != nil {
+			return nil, err
+		}
+		if q.to.variable == q.from.variable {
+			return nil, p.errorf(p.peek(), "variable %s bound twice", q.to.variable)
+		}
+	}
+	bound := func(t token, v string) error {
+		if v == q.from.variable || q.edgeLabel != nil && v == q.to.variable {
+			return nil
+		}
+		return fmt.Errorf("%w %s at offset %d", ErrUnknownVariable, v, t.pos)
+	}
+
+	if p.keyword("WHERE") {
+		for {
+			t := p.peek()
+			var c condition
+			if c.variable, c.property, err = p.propertyRef(); err != nil {
+				return nil, err
+			}
+			if err := bound(t, c.variable); err != nil {
+				return nil, err
+			}
+			if c.op, err = p.comparison(); err != nil {
+				return nil, err
+			}
+			if c.value, err = p.literal(); err != nil {
+				return nil, err
+			}
+			q.where = append(q.where, c)
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+
+	// scope is the columns of the last WITH, which are all that later
+	// clauses can read; nil before the first
+	var scope []string
+	for p.keyword("WITH") {
+		stage, err := p.projection(bound, scope)
+		if err != nil {
+			return nil, err
+		}
+		scope = stage.columns()
+		if p.keyword("WHERE") {
+			if stage.where, err = p.columnConditions(scope); err != nil {
+				return nil, err
+			}
+		}
+		q.stages = append(q.stages, stage)
+	}
+
+	if !p.keyword("RETURN") {
+		t := p.peek()
+		return nil, p.errorf(t, "expected RETURN, found %q", t.text)
+	}
+	stage, err := p.projection(bound, scope)
+	if err != nil {
+		return nil, err
+	}
+	q.stages = append(q.stages, stage)
+	return q, nil
+}
+
+// comparison parses a comparison operator
+func (p *parser) comparison() (string, error) {
+	op := p.next()
+	switch op.text {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		return op.text, nil
+	}
+	return "", p.errorf(op, "expected a comparison, found %q", op.text)
+}
+
+// projection parses the items of a WITH or RETURN. bound checks the
+// variables of items over the match, and scope lists the columns of the
+// previous WITH, if any.
+func (p *parser) projection(bound func(token, string) error, scope []string) (projection, error) {
+	var proj projection
+	for {
+		item, err := p.returnItem(bound, scope)
+		if err != nil {
+			return proj, err
+		}
+		proj.items = append(proj.items, item)
+		if !p.symbol(",") {
+			return proj, nil
+		}
+	}
+}
+
+func (p *parser) returnItem(bound func(token, string) error, scope []string) (returnItem, error) {
+	item := returnItem{column: -1}
+	t := p.peek()
+	name, err := p.ident()
+	if err != nil {
+		return item, err
+	}
+	if p.symbol("(") {
+		fn := strings.ToLower(name)
+		if !aggregateFuncs[fn] {
+			return item, p.errorf(t, "unknown function %s", name)
+		}
+		item.agg = fn
+		if fn == "count" && p.symbol("*") {
+			item.star = true
+			item.name = "count(*)"
+		} else {
+			arg := p.peek()
+			if name, err = p.ident(); err != nil {
+				return item, err
+			}
+			if err := p.operand(&item, arg, name, bound, scope); err != nil {
+				return item, err
+			}
+			item.name = fn + "(" + item.name + ")"
+		}
+		if err := p.expect(")"); err != nil {
+			return item, err
+		}
+	} else if err := p.operand(&item, t, name, bound, scope); err != nil {
+		return item, err
+	}
+	if p.keyword("AS") {
+		if item.name, err = p.ident(); err != nil {
+			return item, err
+		}
+	}
+	return item, nil
+}
+
+// operand parses the rest of a value reference that starts with name:
+// var or var.property over the match, or the name of a column of the
+// previous WITH
+func (p *parser) operand(item *returnItem, t token, name string, bound func(token, string) error, scope []string) error {
+	ref, property := name, ""
+	if p.symbol(".") {
+		var err error
+		if property, err = p.ident(); err != nil {
+			return err
+		}
+		ref += "." + property
+	}
+	item.name = ref
+	if scope == nil {
+		item.variable, item.property = name, property
+		return bound(t, name)
+	}
+	if item.column = slices.Index(scope, ref); item.column < 0 {
+		return fmt.Errorf("%w %s at offset %d", ErrUnknownVariable, ref, t.pos)
+	}
+	return nil
+}
+
+// columnConditions parses the WHERE of a WITH, whose conditions compare
+// its columns
+func (p *parser) columnConditions(columns []string) ([]columnCondition, error) {
+	var conds []columnCondition
+	for {
+		t := p.peek()
+		var item returnItem
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.operand(&item, t, name, nil, columns); err != nil {
+			return nil, err
+		}
+		c := columnCondition{column: item.column}
+		if c.op, err = p.comparison(); err != nil {
+			return nil, err
+		}
+		if c.value, err = p.literal(); err != nil {
+			return nil, err
+		}
+		conds = append(conds, c)
+		if !p.keyword("AND") {
+			return conds, nil
+		}
+	}
+}
+
+func (p *parser) nodePattern() (nodePattern, error) {
+	var n nodePattern
+	var err error
+	if err = p.expect("("); err != nil {
+		return n, err
+	}
+	if n.variable, err = p.ident(); err != nil {
+		return n, err
+	}
+	if p.symbol(":") {
+		if n.label, err = p.ident(); err != nil {
+			return n, err
+		}
+	}
+	return n, p.expect(")")
+}
+
+func (p *parser) propertyRef() (string, string, error) {
+	variable, err := p.ident()
+	if err != nil {
+		return "", "", err
+	}
+	if err := p.expect("."); err != nil {
+		return "", "", err
+	}
+	property, err := p.ident()
+	return variable, property, err
+}
+
+func (q matchQuery) execute(txn *Transaction) (*ResultSet, error) {
+	var inputs []input
+	emit := func(binding map[string]*Node) {
+		for _, c := range q.where {
+			if !c.holds(binding[c.variable]) {
+				return
+			}
+		}
+		inputs = append(inputs, input{binding: binding})
+	}
+
+	for _, from := range txn.allNodes() {
+		if !q.from.matches(from) {
+			continue
+		}
+		if q.edgeLabel == nil {
+			emit(map[string]*Node{q.from.variable: from})
+			continue
+		}
+		for _, edge := range txn.outEdges(from.ID) {
+			if *q.edgeLabel != "" && edge.Label != *q.edgeLabel {
+				continue
+			}
+			if to, ok := txn.node(edge.To); ok && q.to.matches(to) {
+				emit(map[string]*Node{q.from.variable: from, q.to.variable: to})
+			}
+		}
+	}
+
+	// Each WITH feeds its rows to the next clause
+	var rows [][]any
+	for i, stage := range q.stages {
+		rows = stage.apply(inputs)
+		if i < len(q.stages)-1 {
+			inputs = make([]input, len(rows))
+			for j, row := range rows {
+				inputs[j] = input{row: row}
+			}
+		}
+	}
+	return &ResultSet{Columns: q.stages[len(q.stages)-1].columns(), Rows: rows}, nil
+}
+
+func (n nodePattern) matches(node *Node) bool {
+	return n.label == "" || node.Label == n.label
+}
+
+// holds evaluates the condition; a missing property never matches
+func (c condition) holds(node *Node) bool {
+	v, ok := node.Props[c.property]
+	if !ok {
+		return false
+	}
+	return compareOp(v, c.op, c.value)
+}
+
+// compareOp reports whether v op value holds. Values of different kinds
+// are only unequal.
+func compareOp(v any, op string, value any) bool {
+	order, ok := compareValues(v, value)
+	if !ok {
+		return op == "!=" || op == "<>"
+	}
+	switch op {
+	case "=":
+		return order == 0
+	case "!=", "<>":
+		return order != 0
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	default:
+		return order >= 0
+	}
+}
+
+// compareValues orders two property values. Integers and floats compare
+// numerically and booleans are only equal or not; ok is false for values
+// of different kinds.
+func compareValues(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case int64:
+		switch b := b.(type) {
+		case int64:
+			return cmp.Compare(a, b), true
+		case float64:
+			return cmp.Compare(float64(a), b), true
+		}
+	case float64:
+		switch b := b.(type) {
+		case int64:
+			return cmp.Compare(a, float64(b)), true
+		case float64:
+			return cmp.Compare(a, b), true
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			if a == b {
+				return 0, true
+			}
+			return 1, true
+		}
+	}
+	return 0, false
+}
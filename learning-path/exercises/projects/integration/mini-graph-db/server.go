@@ -0,0 +1,427 @@
+package minigraphdb
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Session runs a client's queries. Outside BEGIN ... COMMIT every query
+// runs in its own transaction; inside, queries share one MVCC transaction
+// and see its uncommitted writes. A Session is not safe for concurrent use.
+type Session struct {
+	db     *GraphDB
+	role   Role
+	txn    *Transaction
+	closed bool
+}
+
+// NewSession starts a session with no transaction open. It may run any
+// statement; server connections get sessions limited to their user's role.
+func (db *GraphDB) NewSession() *Session {
+	return &Session{db: db, role: RoleAdmin}
+}
+
+// Execute runs one statement, including BEGIN, COMMIT and ROLLBACK. A
+// failed statement has no effect and leaves an open transaction open; a
+// failed COMMIT rolls it back.
+func (s *Session) Execute(query string) (*ResultSet, error) {
+	if s.closed {
+		return nil, ErrSessionClosed
+	}
+	stmt, err := s.db.queryEngine.Parse(query)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorize(s.role, stmt); err != nil {
+		return nil, err
+	}
+
+	control, ok := stmt.(txnControl)
+	switch {
+	case !ok && s.txn != nil:
+		return s.db.queryEngine.Execute(s.txn, stmt)
+	case !ok:
+		return s.db.autocommit(stmt)
+	case control == "BEGIN":
+		if s.txn != nil {
+			return nil, ErrTxnInProgress
+		}
+		if s.txn, err = s.db.BeginTransaction(); err != nil {
+			return nil, err
+		}
+		return &ResultSet{}, nil
+	}
+
+	txn := s.txn
+	if txn == nil {
+		return nil, ErrNoTransaction
+	}
+	s.txn = nil
+	if control == "COMMIT" {
+		err = s.db.Commit(txn)
+	} else {
+		err = s.db.Rollback(txn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ResultSet{}, nil
+}
+
+// InTransaction reports whether BEGIN has not yet been matched by COMMIT
+// or ROLLBACK
+func (s *Session) InTransaction() bool {
+	return s.txn != nil
+}
+
+// Close rolls back the open transaction, if any
+func (s *Session) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.txn == nil {
+		return nil
+	}
+	txn := s.txn
+	s.txn = nil
+	return s.db.Rollback(txn)
+}
+
+// ServerOptions configures a Server
+type ServerOptions struct {
+	// IdleTimeout closes a session that sends no query for this long,
+	// rolling back its open transaction. Defaults to 5 minutes.
+	IdleTimeout time.Duration
+	// Users, if set, requires every connection to AUTH before anything
+	// else and limits it to its user's role. Without it every connection
+	// has the admin role.
+	Users *UserStore
+}
+
+func (o ServerOptions) withDefaults() ServerOptions {
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = 5 * time.Minute
+	}
+	return o
+}
+
+// response is one reply of the wire protocol
+type response struct {
+	Columns       []string `json:"columns,omitempty"`
+	Rows          [][]any  `json:"rows,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	InTransaction bool     `json:"in_transaction,omitempty"`
+}
+
+// Server serves the query language over TCP. A client sends one statement
+// per line and gets one JSON response per line:
+//
+//	{"columns":["friend.name"],"rows":[["Bob"]]}
+//	{"error":"...","in_transaction":true}
+//
+// Each connection is a Session, so BEGIN ... COMMIT can span many lines.
+// A transaction left open when the connection drops or idles out is
+// rolled back. Besides queries, a connection may send:
+//
+//	AUTH user password     authenticate, when ServerOptions.Users is set
+//	USE name               switch to another database
+//	CREATE DATABASE name   create a database (admin role)
+type Server struct {
+	dbs      *Databases
+	opts     ServerOptions
+	listener net.Listener
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+// StartServer serves db on addr as the only database
+func StartServer(db *GraphDB, addr string, opts ServerOptions) (*Server, error) {
+	return ServeDatabases(single(db), addr, opts)
+}
+
+// ServeDatabases serves every database of dbs on addr. Connections start
+// in DefaultDatabase, if there is one.
+func ServeDatabases(dbs *Databases, addr string, opts ServerOptions) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		dbs:      dbs,
+		opts:     opts.withDefaults(),
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Addr returns the address clients should connect to
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops accepting clients and disconnects existing ones, rolling back
+// their open transactions
+func (s *Server) Close() error {
+	close(s.done)
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.serveSession(conn)
+		}()
+	}
+}
+
+// connState is the user and database of one connection
+type connState struct {
+	role    Role
+	session *Session // nil until a database is selected
+}
+
+// serveSession runs the queries of one connection until it is closed,
+// idles out or the server shuts down
+func (s *Server) serveSession(conn net.Conn) {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-s.done:
+		case <-finished:
+		}
+		conn.Close()
+	}()
+
+	st := &connState{role: RoleAdmin}
+	if s.opts.Users != nil {
+		st.role = RoleNone
+	}
+	if db, err := s.dbs.Get(DefaultDatabase); err == nil {
+		st.session = db.NewSession()
+		st.session.role = st.role
+	}
+	defer func() {
+		if st.session != nil {
+			st.session.Close()
+		}
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(nil, 1<<20)
+	w := bufio.NewWriter(conn)
+	enc := json.NewEncoder(w)
+	reply := func(resp response) error {
+		conn.SetWriteDeadline(time.Now().Add(s.opts.IdleTimeout))
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.opts.IdleTimeout))
+		if !scanner.Scan() {
+			var netErr net.Error
+			if errors.As(scanner.Err(), &netErr) && netErr.Timeout() {
+				if st.session != nil {
+					st.session.Close()
+				}
+				reply(response{Error: ErrSessionIdle.Error()})
+			}
+			return
+		}
+		query := strings.TrimSpace(scanner.Text())
+		if query == "" {
+			continue
+		}
+
+		var resp response
+		result, err := s.execute(st, query)
+		if err != nil {
+			resp.Error = err.Error()
+		} else {
+			resp.Columns, resp.Rows = result.Columns, result.Rows
+		}
+		resp.InTransaction = st.session != nil && st.session.InTransaction()
+		if err := reply(resp); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a server command or a query for the connection
+func (s *Server) execute(st *connState, line string) (*ResultSet, error) {
+	fields := strings.Fields(line)
+	switch {
+	case strings.EqualFold(fields[0], "AUTH"):
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: AUTH user password", ErrSyntax)
+		}
+		if s.opts.Users == nil {
+			return &ResultSet{}, nil
+		}
+		role, err := s.opts.Users.Authenticate(fields[1], fields[2])
+		if err != nil {
+			return nil, err
+		}
+		st.role = role
+		if st.session != nil {
+			st.session.role = role
+		}
+		return &ResultSet{}, nil
+
+	case strings.EqualFold(fields[0], "USE"):
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%w: USE database", ErrSyntax)
+		}
+		if err := checkRole(st.role, RoleRead); err != nil {
+			return nil, err
+		}
+		if st.session != nil && st.session.InTransaction() {
+			return nil, ErrTxnInProgress
+		}
+		db, err := s.dbs.Get(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		if st.session != nil {
+			st.session.Close()
+		}
+		st.session = db.NewSession()
+		st.session.role = st.role
+		return &ResultSet{}, nil
+
+	case len(fields) >= 2 && strings.EqualFold(fields[0], "CREATE") && strings.EqualFold(fields[1], "DATABASE"):
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%w: CREATE DATABASE name", ErrSyntax)
+		}
+		if err := checkRole(st.role, RoleAdmin); err != nil {
+			return nil, err
+		}
+		if _, err := s.dbs.Create(fields[2]); err != nil {
+			return nil, err
+		}
+		return &ResultSet{}, nil
+	}
+
+	if st.session == nil {
+		if err := checkRole(st.role, RoleRead); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w: no database selected; send USE name", ErrDatabaseNotFound)
+	}
+	return st.session.Execute(line)
+}
+
+// Client is a connection to a Server. It is not safe for concurrent use.
+type Client struct {
+	conn          net.Conn
+	dec           *json.Decoder
+	inTransaction bool
+}
+
+// Dial connects to the server at addr
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	dec.UseNumber()
+	return &Client{conn: conn, dec: dec}, nil
+}
+
+// Query sends one statement and waits for its result. Errors reported by
+// the server are returned as *ServerError.
+func (c *Client) Query(query string) (*ResultSet, error) {
+	if strings.ContainsAny(query, "\r\n") {
+		return nil, fmt.Errorf("%w: a query must fit on one line", ErrSyntax)
+	}
+	if _, err := fmt.Fprintln(c.conn, query); err != nil {
+		return nil, err
+	}
+	var resp response
+	if err := c.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	c.inTransaction = resp.InTransaction
+	if resp.Error != "" {
+		return nil, &ServerError{Message: resp.Error}
+	}
+	for _, row := range resp.Rows {
+		for i, v := range row {
+			row[i] = fromJSON(v)
+		}
+	}
+	return &ResultSet{Columns: resp.Columns, Rows: resp.Rows}, nil
+}
+
+// Auth authenticates the connection as user
+func (c *Client) Auth(user, password string) error {
+	if strings.ContainsAny(user+password, " \t") {
+		return fmt.Errorf("%w: user and password must not contain spaces", ErrInvalidUser)
+	}
+	_, err := c.Query("AUTH " + user + " " + password)
+	return err
+}
+
+// Use switches the connection to the database name
+func (c *Client) Use(name string) error {
+	_, err := c.Query("USE " + name)
+	return err
+}
+
+// InTransaction reports whether the server has a transaction open for
+// this connection, as of the last response
+func (c *Client) InTransaction() bool {
+	return c.inTransaction
+}
+
+// Close disconnects; the server rolls back any open transaction
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// ServerError is an error the server reported for a query
+type ServerError struct {
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+// fromJSON restores the int64 and float64 property types lost in JSON.
+// Whole-number floats come back as int64.
+func fromJSON(v any) any {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	f, _ := n.Float64()
+	return f
+}
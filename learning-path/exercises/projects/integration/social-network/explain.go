@@ -0,0 +1,95 @@
+package socialnetwork
+
+import (
+	"fmt"
+	"strings"
+)
+
+// defaultExplainPaths is how many paths Explain returns when not told
+const defaultExplainPaths = 3
+
+// Connection is one chain of friendships between two users
+type Connection struct {
+	Via []User // users in between, in order from the first user; empty for friends
+}
+
+// Hops returns the number of friendships in the chain
+func (c Connection) Hops() int {
+	return len(c.Via) + 1
+}
+
+// String renders the connection as a UI would show it, e.g. "connected
+// through Bob and Carol"
+func (c Connection) String() string {
+	if len(c.Via) == 0 {
+		return "friends"
+	}
+	names := make([]string, len(c.Via))
+	for i, u := range c.Via {
+		names[i] = u.Name
+	}
+	return "connected through " + strings.Join(names, " and ")
+}
+
+// Explain returns up to k of the shortest friendship chains from a to b,
+// of at most three hops, to say why b was recommended to a or how any two
+// users are linked. k <= 0 means 3. Shorter chains come first, and chains
+// of the same length are ordered by the IDs of the users in between. No
+// chain visits a user twice. An empty result means a and b are further
+// apart.
+func (sn *SocialNetwork) Explain(a, b UserID, k int) ([]Connection, error) {
+	if a == b {
+		return nil, ErrSelfLink
+	}
+	for _, id := range []UserID{a, b} {
+		if sn.users[id] == nil {
+			return nil, fmt.Errorf("user %d: %w", id, ErrUnknownUser)
+		}
+	}
+	if k <= 0 {
+		k = defaultExplainPaths
+	}
+
+	var paths [][]UserID
+	add := func(via ...UserID) bool {
+		paths = append(paths, via)
+		return len(paths) < k
+	}
+	g := sn.graph
+	// One hop: a and b are friends
+	if g.connected(a, b) && !add() {
+		return sn.connections(paths), nil
+	}
+	// Two hops: mutual friends. Neighbors are sorted, so the intersection
+	// comes out in ID order.
+	for _, x := range g.neighbors(a) {
+		if x != b && g.connected(x, b) && !add(x) {
+			return sn.connections(paths), nil
+		}
+	}
+	// Three hops: a friend x of a who is a friend of a friend y of b. Going
+	// through x, then y, in ascending order keeps the ID order.
+	for _, x := range g.neighbors(a) {
+		if x == b {
+			continue
+		}
+		for _, y := range g.neighbors(x) {
+			if y != a && y != b && g.connected(y, b) && !add(x, y) {
+				return sn.connections(paths), nil
+			}
+		}
+	}
+	return sn.connections(paths), nil
+}
+
+// connections resolves the IDs of each path to users
+func (sn *SocialNetwork) connections(paths [][]UserID) []Connection {
+	conns := make([]Connection, len(paths))
+	for i, via := range paths {
+		conns[i].Via = make([]User, len(via))
+		for j, id := range via {
+			conns[i].Via[j] = *sn.users[id]
+		}
+	}
+	return conns
+}
@@ -0,0 +1,43 @@
+package socialnetwork
+
+import "slices"
+
+// Graph is the undirected friendship graph. Each user's friends are kept
+// sorted, so traversals visit them in a stable order and a friendship is
+// checked with a binary search.
+type Graph struct {
+	adj   map[UserID][]UserID
+	edges int
+}
+
+func newGraph() *Graph {
+	return &Graph{adj: make(map[UserID][]UserID)}
+}
+
+// addEdge connects a and b and reports whether they were not already
+func (g *Graph) addEdge(a, b UserID) bool {
+	if g.connected(a, b) {
+		return false
+	}
+	g.adj[a] = insertSorted(g.adj[a], b)
+	g.adj[b] = insertSorted(g.adj[b], a)
+	g.edges++
+	return true
+}
+
+// connected reports whether a and b are friends
+func (g *Graph) connected(a, b UserID) bool {
+	_, found := slices.BinarySearch(g.adj[a], b)
+	return found
+}
+
+// neighbors returns the friends of u in ascending order. The slice is
+// shared with the graph and must not be modified.
+func (g *Graph) neighbors(u UserID) []UserID {
+	return g.adj[u]
+}
+
+func insertSorted(ids []UserID, id UserID) []UserID {
+	i, _ := slices.BinarySearch(ids, id)
+	return slices.Insert(ids, i, id)
+}
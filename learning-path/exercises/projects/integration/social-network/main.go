@@ -1,5 +1,16 @@
 package socialnetwork
 
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned when building or querying the network
+var (
+	ErrUnknownUser = errors.New("unknown user")
+	ErrSelfLink    = errors.New("a user cannot be their own friend")
+)
+
 // SocialNetwork represents the social network analyzer
 type SocialNetwork struct {
 	graph      *Graph
@@ -18,7 +29,30 @@ type User struct {
 
 // NewSocialNetwork creates a new analyzer
 func NewSocialNetwork() *SocialNetwork {
-	// TODO: Initialize components
+	return &SocialNetwork{
+		graph:      newGraph(),
+		users:      make(map[UserID]*User),
+		algorithms: &AlgorithmRunner{},
+	}
+}
+
+// AddUser adds a user, or replaces the profile of an existing one
+func (sn *SocialNetwork) AddUser(u User) {
+	sn.users[u.ID] = &u
+}
+
+// AddFriendship connects two users. Friendships are mutual; adding one
+// twice has no effect.
+func (sn *SocialNetwork) AddFriendship(a, b UserID) error {
+	if a == b {
+		return ErrSelfLink
+	}
+	for _, id := range []UserID{a, b} {
+		if sn.users[id] == nil {
+			return fmt.Errorf("user %d: %w", id, ErrUnknownUser)
+		}
+	}
+	sn.graph.addEdge(a, b)
 	return nil
 }
 
@@ -52,7 +86,6 @@ func (sn *SocialNetwork) Stats() NetworkStats {
 	return NetworkStats{}
 }
 
-type Graph struct{}
 type AlgorithmRunner struct{}
 type NetworkStats struct {
 	UserCount      int
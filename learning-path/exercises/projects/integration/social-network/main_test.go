@@ -1,6 +1,10 @@
 package socialnetwork
 
-import "testing"
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
 
 func TestLoadCSV(t *testing.T) {
 	t.Skip("not implemented")
@@ -21,3 +25,64 @@ func TestCommunityDetection(t *testing.T) {
 func BenchmarkRecommendations(b *testing.B) {
 	b.Skip("not implemented")
 }
+
+func TestExplain(t *testing.T) {
+	sn := NewSocialNetwork()
+	for i, name := range []string{"Alice", "Bob", "Carol", "Dave", "Erin", "Frank", "Grace"} {
+		sn.AddUser(User{ID: UserID(i + 1), Name: name})
+	}
+	// Alice(1) knows Bob(2) and Carol(3), who both know Dave(4); Dave and
+	// Carol know Erin(5); Erin knows Frank(6). Grace(7) knows nobody.
+	for _, e := range [][2]UserID{{1, 2}, {1, 3}, {2, 4}, {3, 4}, {4, 5}, {3, 5}, {5, 6}} {
+		if err := sn.AddFriendship(e[0], e[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sn.AddFriendship(1, 2); err != nil || sn.graph.edges != 7 {
+		t.Errorf("repeated friendship: err %v, %d edges", err, sn.graph.edges)
+	}
+
+	via := func(conns []Connection) [][]UserID {
+		var paths [][]UserID
+		for _, c := range conns {
+			ids := []UserID{}
+			for _, u := range c.Via {
+				ids = append(ids, u.ID)
+			}
+			paths = append(paths, ids)
+		}
+		return paths
+	}
+	tests := []struct {
+		a, b UserID
+		k    int
+		want [][]UserID
+	}{
+		{1, 4, 0, [][]UserID{{2}, {3}, {3, 5}}},
+		{1, 4, 1, [][]UserID{{2}}},
+		{1, 5, 10, [][]UserID{{3}, {2, 4}, {3, 4}}},
+		{1, 2, 0, [][]UserID{{}, {3, 4}}},
+		{1, 6, 0, [][]UserID{{3, 5}}},
+		{1, 7, 0, nil},
+	}
+	for _, tt := range tests {
+		conns, err := sn.Explain(tt.a, tt.b, tt.k)
+		if err != nil {
+			t.Fatalf("Explain(%d, %d): %v", tt.a, tt.b, err)
+		}
+		if got := via(conns); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Explain(%d, %d, %d) = %v, want %v", tt.a, tt.b, tt.k, got, tt.want)
+		}
+	}
+
+	conns, _ := sn.Explain(1, 6, 0)
+	if len(conns) != 1 || conns[0].Hops() != 3 || conns[0].String() != "connected through Carol and Erin" {
+		t.Errorf("Explain(1, 6) = %v", conns)
+	}
+	if _, err := sn.Explain(1, 99, 0); !errors.Is(err, ErrUnknownUser) {
+		t.Errorf("unknown user: err = %v", err)
+	}
+	if _, err := sn.Explain(1, 1, 0); !errors.Is(err, ErrSelfLink) {
+		t.Errorf("same user: err = %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package bufferpool
+
+// FrameAllocation selects how frame memory is allocated
+type FrameAllocation int
+
+const (
+	// AllocPerFrame gives every frame its own heap buffer
+	AllocPerFrame FrameAllocation = iota
+	// AllocArena carves all frames out of one contiguous heap buffer, so a
+	// large pool is a single object to the garbage collector
+	AllocArena
+	// AllocMmap carves all frames out of an anonymous memory mapping that
+	// lives outside the Go heap and does not count toward GC pacing. It
+	// falls back to AllocArena where mmap is unavailable. Frame data must
+	// not be used after Close, which unmaps the region.
+	AllocMmap
+)
+
+// arena hands out frame buffers from one backing region
+type arena struct {
+	buf     []byte
+	off     int
+	release func() error
+}
+
+// newArena reserves size bytes using the given allocation strategy. It
+// returns nil for AllocPerFrame.
+func newArena(alloc FrameAllocation, size int) *arena {
+	switch alloc {
+	case AllocArena:
+		return &arena{buf: make([]byte, size)}
+	case AllocMmap:
+		if buf, release, err := mmapRegion(size); err == nil {
+			return &arena{buf: buf, release: release}
+		}
+		return &arena{buf: make([]byte, size)}
+	default:
+		return nil
+	}
+}
+
+// alloc returns the next n bytes of the arena, or a fresh heap buffer if
+// the pool has no arena. The capacity is clipped so an append on one frame
+// can never spill into its neighbour.
+func (a *arena) alloc(n int) []byte {
+	if a == nil {
+		return make([]byte, n)
+	}
+	b := a.buf[a.off : a.off+n : a.off+n]
+	a.off += n
+	return b
+}
+
+// free releases the backing region if it lives outside the heap
+func (a *arena) free() error {
+	if a == nil || a.release == nil {
+		return nil
+	}
+	release := a.release
+	a.release = nil
+	return release()
+}
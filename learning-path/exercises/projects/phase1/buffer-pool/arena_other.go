@@ -0,0 +1,9 @@
+//go:build !unix
+
+package bufferpool
+
+import "errors"
+
+func mmapRegion(size int) ([]byte, func() error, error) {
+	return nil, nil, errors.New("mmap not supported on this platform")
+}
@@ -0,0 +1,14 @@
+//go:build unix
+
+package bufferpool
+
+import "syscall"
+
+// mmapRegion maps size bytes of anonymous, zeroed memory
+func mmapRegion(size int) ([]byte, func() error, error) {
+	buf, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf, func() error { return syscall.Munmap(buf) }, nil
+}
@@ -0,0 +1,222 @@
+package bufferpool
+
+import (
+	"errors"
+	"fmt"
+)
+
+// AsyncPageIO is an optional DiskManager extension for batched
+// asynchronous I/O. ReadPagesAsync reads page pageIDs[i] into bufs[i] and
+// sends an error per page, nil for the pages read; WritePagesAsync writes
+// pages[i] as page pageIDs[i] and sends nil or an error. Both return at
+// once, and the pool leaves the buffers alone until the result arrives.
+// The misses of a Prefetch or WarmUp are read, and the dirty pages of
+// FlushAll and the background flusher written, as one batch each, so a
+// backend such as io_uring has them in flight together. A single miss is
+// read with ReadPage.
+type AsyncPageIO interface {
+	ReadPagesAsync(pageIDs []PageID, bufs [][]byte) <-chan []error
+	WritePagesAsync(pageIDs []PageID, pages [][]byte) <-chan error
+}
+
+// FetchResult is the outcome of an asynchronous page fetch. On success the
+// frame is pinned and must be released with UnpinPage.
+type FetchResult struct {
+	Frame *Frame
+	Err   error
+	hit   bool // the page was resident
+}
+
+// pendingRead is a disk read in flight for one page. Requests for the page
+// that arrive while it is in flight wait on the same read.
+type pendingRead struct {
+	frame   *Frame
+	waiters []chan FetchResult
+	scan    bool // every waiter is a scan
+}
+
+// FetchPageAsync fetches a page without holding the pool latch during disk
+// I/O. The returned channel receives exactly one result. A resident page is
+// delivered immediately; concurrent misses for the same page share a single
+// read.
+//
+// Writing back a dirty victim to make room still happens under the latch.
+func (bp *BufferPool) FetchPageAsync(pageID PageID) <-chan FetchResult {
+	return bp.fetchPageAsync(pageID, 0, false)
+}
+
+func (bp *BufferPool) fetchPageAsync(pageID PageID, class int, scan bool) <-chan FetchResult {
+	return bp.fetchPagesAsync([]pageFetch{{pageID, class}}, scan)[0]
+}
+
+// pageFetch is a page of a batched fetch, and the size class it is in
+type pageFetch struct {
+	pageID PageID
+	class  int
+}
+
+// fetchPagesAsync fetches pages as FetchPageAsync does, returning a channel
+// per page. The reads of the pages that miss are issued together.
+func (bp *BufferPool) fetchPagesAsync(fetches []pageFetch, scan bool) []<-chan FetchResult {
+	results := make([]<-chan FetchResult, len(fetches))
+	var ids []PageID
+	var reads []*pendingRead
+	bp.mu.Lock()
+	for i, f := range fetches {
+		result, pending := bp.startFetchLocked(f.pageID, f.class, scan)
+		results[i] = result
+		if pending != nil {
+			ids = append(ids, f.pageID)
+			reads = append(reads, pending)
+		}
+	}
+	bp.mu.Unlock()
+	bp.startReads(ids, reads)
+	return results
+}
+
+// startFetchLocked starts a fetch, returning its channel and, if the page
+// has to be read, the pending read to issue. Caller holds bp.mu.
+func (bp *BufferPool) startFetchLocked(pageID PageID, class int, scan bool) (chan FetchResult, *pendingRead) {
+	result := make(chan FetchResult, 1)
+	if bp.closed {
+		result <- FetchResult{Err: ErrClosed}
+		return result, nil
+	}
+	if pageID < 0 {
+		result <- FetchResult{Err: ErrInvalidPageID}
+		return result, nil
+	}
+
+	if frameID, found := bp.pageTable[pageID]; found {
+		frame := bp.frames[frameID]
+		if frame.class != class {
+			result <- FetchResult{Err: ErrPageClassMismatch}
+			return result, nil
+		}
+		frame.Pin()
+		bp.pinInReplacer(frameID)
+		if !scan {
+			frame.scan = false
+			bp.touch(frameID, pageID)
+		}
+		bp.cacheHits.Add(1)
+		result <- FetchResult{Frame: frame, hit: true}
+		return result, nil
+	}
+	bp.cacheMisses.Add(1)
+
+	if pending, ok := bp.inflight[pageID]; ok {
+		if pending.frame.class != class {
+			result <- FetchResult{Err: ErrPageClassMismatch}
+			return result, nil
+		}
+		pending.waiters = append(pending.waiters, result)
+		pending.scan = pending.scan && scan
+		bp.coalescedReads.Add(1)
+		return result, nil
+	}
+
+	frameID, err := bp.acquireFrameLocked(class)
+	if err != nil {
+		result <- FetchResult{Err: err}
+		return result, nil
+	}
+
+	// The frame is in neither the page table, a free list nor a replacer,
+	// so nothing else touches it until the read completes
+	pending := &pendingRead{
+		frame:   bp.frames[frameID],
+		waiters: []chan FetchResult{result},
+		scan:    scan,
+	}
+	bp.inflight[pageID] = pending
+	bp.reads.Add(1)
+	return result, pending
+}
+
+// startReads issues the disk reads of pending fetches: several as one
+// batch if the disk manager implements AsyncPageIO, or otherwise a
+// goroutine each
+func (bp *BufferPool) startReads(pageIDs []PageID, reads []*pendingRead) {
+	aio, ok := bp.diskManager.(AsyncPageIO)
+	if !ok || len(reads) < 2 {
+		for i, pending := range reads {
+			go bp.completeRead(pageIDs[i], pending)
+		}
+		return
+	}
+	bufs := make([][]byte, len(reads))
+	for i, pending := range reads {
+		bufs[i] = pending.frame.data
+	}
+	done := aio.ReadPagesAsync(pageIDs, bufs)
+	go func() {
+		errs := <-done
+		for i, pending := range reads {
+			bp.finishRead(pageIDs[i], pending, errs[i])
+		}
+	}()
+}
+
+// completeRead performs the disk read for a pending fetch and finishes it
+func (bp *BufferPool) completeRead(pageID PageID, pending *pendingRead) {
+	bp.finishRead(pageID, pending, bp.diskManager.ReadPage(pageID, pending.frame.data))
+}
+
+// finishRead delivers the pinned frame of a pending fetch whose read
+// completed with err, or the error, to every waiter
+func (bp *BufferPool) finishRead(pageID PageID, pending *pendingRead, err error) {
+	frame := pending.frame
+	defer bp.reads.Done()
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	delete(bp.inflight, pageID)
+	if err == nil && bp.closed {
+		// Close is waiting to release the frame memory
+		err = ErrClosed
+	}
+	if err != nil {
+		bp.releaseFrameLocked(frame)
+		for _, w := range pending.waiters {
+			w <- FetchResult{Err: err}
+		}
+		return
+	}
+
+	bp.installLocked(frame, pageID, pending.scan)
+	for i, w := range pending.waiters {
+		if i > 0 {
+			frame.Pin()
+			if !pending.scan {
+				bp.touch(frame.frameID, pageID)
+			}
+		}
+		w <- FetchResult{Frame: frame}
+	}
+}
+
+// Prefetch reads pages of the default size class into the pool ahead of
+// their use, and waits for them. The pages not resident are read as one
+// batch, through AsyncPageIO if the disk manager has it. They are left
+// unpinned, as if fetched and released; a batch larger than the frames
+// that can be freed fails for the pages past them. A page that fails to
+// load is skipped and its error joined into the one returned.
+func (bp *BufferPool) Prefetch(pageIDs []PageID) error {
+	fetches := make([]pageFetch, len(pageIDs))
+	for i, pageID := range pageIDs {
+		fetches[i] = pageFetch{pageID: pageID}
+	}
+	var errs []error
+	for i, result := range bp.fetchPagesAsync(fetches, false) {
+		if res := <-result; res.Err != nil {
+			errs = append(errs, fmt.Errorf("prefetch page %d: %w", pageIDs[i], res.Err))
+			continue
+		}
+		if err := bp.UnpinPage(pageIDs[i], false); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,217 @@
+package bufferpool
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+)
+
+// LSN is a log sequence number assigned by the write-ahead log
+type LSN uint64
+
+// SetLSN records the LSN of the latest log record describing a change to
+// the frame's page. Callers set it while holding the page pinned, before
+// unpinning it dirty. The first LSN set after the page was last written is
+// kept as its recLSN, the point recovery has to redo the page from.
+func (f *Frame) SetLSN(lsn LSN) {
+	f.lsn.Store(uint64(lsn))
+	f.recLSN.CompareAndSwap(0, uint64(lsn))
+}
+
+// LSN returns the page LSN of the frame
+func (f *Frame) LSN() LSN {
+	return LSN(f.lsn.Load())
+}
+
+// takeRecLSN clears the frame's recLSN before its page is written and
+// returns it, so an LSN set during the write starts a new one
+func (f *Frame) takeRecLSN() LSN {
+	return LSN(f.recLSN.Swap(0))
+}
+
+// restoreRecLSN puts back a recLSN taken for a write that did not happen,
+// keeping the older of it and any recLSN set since
+func (f *Frame) restoreRecLSN(lsn LSN) {
+	for lsn != 0 {
+		cur := f.recLSN.Load()
+		if cur != 0 && cur <= uint64(lsn) {
+			return
+		}
+		if f.recLSN.CompareAndSwap(cur, uint64(lsn)) {
+			return
+		}
+	}
+}
+
+// CheckpointLog is the write-ahead log a buffer pool coordinates with
+type CheckpointLog interface {
+	// FlushTo makes the log durable through lsn. The pool calls it before
+	// writing a page whose page LSN is lsn, so no page reaches disk ahead
+	// of its log records.
+	FlushTo(lsn LSN) error
+	// EndLSN returns the LSN the next record appended will get
+	EndLSN() LSN
+	// AppendCheckpoint logs a checkpoint record carrying the dirty page
+	// table - each page still dirty with its recLSN - and returns its LSN.
+	// begin is the EndLSN taken before the table: changes logged from begin
+	// on may be missing from dirty, so recovery has to redo from begin at
+	// the latest, not only from the recLSNs in dirty.
+	AppendCheckpoint(begin LSN, dirty map[PageID]LSN) (LSN, error)
+}
+
+// CheckpointResult describes a completed checkpoint
+type CheckpointResult struct {
+	// Flushed holds the page LSN of each page the checkpoint wrote
+	Flushed map[PageID]LSN
+	// DirtyPages is the dirty page table logged with the checkpoint: the
+	// pages dirtied again while it ran, with their recLSNs
+	DirtyPages map[PageID]LSN
+	// BeginLSN is the log end taken before DirtyPages, 0 without a log
+	BeginLSN LSN
+	// CheckpointLSN is the LSN of the checkpoint record, 0 without a log
+	CheckpointLSN LSN
+	// RecoveryLSN is where redo has to start after a crash: the oldest
+	// recLSN in DirtyPages, or BeginLSN if that is older
+	RecoveryLSN LSN
+}
+
+// logFlushed applies the WAL rule before a page with page LSN lsn is
+// written
+func (bp *BufferPool) logFlushed(lsn LSN) error {
+	if bp.opts.CheckpointLog == nil || lsn == 0 {
+		return nil
+	}
+	return bp.opts.CheckpointLog.FlushTo(lsn)
+}
+
+// Checkpoint writes the dirty pages oldest recLSN first, syncs the disk
+// manager if it is a PageSyncer, and appends a checkpoint record with the
+// remaining dirty page table to Options.CheckpointLog.
+//
+// The pool is not quiesced. Pages are written one at a time under the
+// shared latch, which is released in between, so a FetchPage waits for at
+// most one page write; Options.CheckpointRate further limits how fast pages
+// are written. Pages dirtied while the checkpoint runs stay dirty and are
+// logged in the dirty page table, as are pinned pages already given an LSN
+// with SetLSN.
+//
+// This is a fuzzy checkpoint: the log end is taken as BeginLSN before the
+// table, and a change logged after that may be missing from the table.
+// Recovery covers it by redoing from BeginLSN at the latest, which is
+// passed to AppendCheckpoint and folded into RecoveryLSN. A change logged
+// before BeginLSN must have been given its LSN with SetLSN by then.
+//
+// If ctx is done before every page is written, Checkpoint returns its
+// error without logging a checkpoint. Pages already written stay clean.
+func (bp *BufferPool) Checkpoint(ctx context.Context) (*CheckpointResult, error) {
+	bp.checkpointMu.Lock()
+	defer bp.checkpointMu.Unlock()
+
+	type dirtyPage struct {
+		pageID PageID
+		recLSN LSN
+	}
+	bp.mu.RLock()
+	var pages []dirtyPage
+	for _, frame := range bp.frames {
+		if frame.pageID >= 0 && frame.IsDirty() {
+			pages = append(pages, dirtyPage{frame.pageID, LSN(frame.recLSN.Load())})
+		}
+	}
+	bp.mu.RUnlock()
+	slices.SortFunc(pages, func(a, b dirtyPage) int { return cmp.Compare(a.recLSN, b.recLSN) })
+
+	var interval time.Duration
+	if bp.opts.CheckpointRate > 0 {
+		interval = time.Second / time.Duration(bp.opts.CheckpointRate)
+	}
+	start := time.Now()
+	flushed := make(map[PageID]LSN)
+	for i, page := range pages {
+		if err := pace(ctx, start.Add(time.Duration(i)*interval)); err != nil {
+			return nil, err
+		}
+		if err := bp.checkpointPage(page.pageID, flushed); err != nil {
+			return nil, err
+		}
+	}
+
+	if syncer, ok := bp.diskManager.(PageSyncer); ok {
+		if err := syncer.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &CheckpointResult{Flushed: flushed}
+	if bp.opts.CheckpointLog != nil {
+		result.BeginLSN = bp.opts.CheckpointLog.EndLSN()
+	}
+	result.DirtyPages = bp.dirtyPageTable()
+	if bp.opts.CheckpointLog != nil {
+		lsn, err := bp.opts.CheckpointLog.AppendCheckpoint(result.BeginLSN, result.DirtyPages)
+		if err != nil {
+			return nil, err
+		}
+		result.CheckpointLSN = lsn
+		result.RecoveryLSN = result.BeginLSN
+	}
+	for _, recLSN := range result.DirtyPages {
+		if recLSN != 0 && (result.RecoveryLSN == 0 || recLSN < result.RecoveryLSN) {
+			result.RecoveryLSN = recLSN
+		}
+	}
+	return result, nil
+}
+
+// checkpointPage writes one page if it is still resident and dirty
+func (bp *BufferPool) checkpointPage(pageID PageID, flushed map[PageID]LSN) error {
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+
+	if bp.closed {
+		return ErrClosed
+	}
+	frameID, found := bp.pageTable[pageID]
+	if !found || !bp.frames[frameID].IsDirty() {
+		return nil // evicted, and written, since the table was taken
+	}
+	frame := bp.frames[frameID]
+	lsn := frame.LSN()
+	if err := bp.flushFrame(frame); err != nil {
+		return err
+	}
+	flushed[pageID] = lsn
+	return nil
+}
+
+// dirtyPageTable returns the recLSN of every dirty page, and of every page
+// that has a recLSN but is still pinned and so not yet marked dirty
+func (bp *BufferPool) dirtyPageTable() map[PageID]LSN {
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+	dirty := make(map[PageID]LSN)
+	for _, frame := range bp.frames {
+		recLSN := LSN(frame.recLSN.Load())
+		if frame.pageID >= 0 && (frame.IsDirty() || recLSN != 0) {
+			dirty[frame.pageID] = recLSN
+		}
+	}
+	return dirty
+}
+
+// pace waits until next or until ctx is done
+func pace(ctx context.Context, next time.Time) error {
+	wait := time.Until(next)
+	if wait <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -10,7 +10,8 @@ import (
 
 // Constants
 const (
-	PageSize = 4096 // 4KB pages
+	DefaultPageSize      = 4096 // 4KB pages
+	DefaultFlushInterval = 5 * time.Second
 )
 
 // Type definitions
@@ -22,6 +23,9 @@ var (
 	ErrNoVictimFrame = errors.New("no victim frame available: all pages pinned")
 	ErrInvalidPageID = errors.New("invalid page ID")
 	ErrPageNotFound  = errors.New("page not found in pool")
+	ErrPageNotPinned = errors.New("page is not pinned")
+	ErrPagePinned    = errors.New("page is pinned")
+	ErrClosed        = errors.New("buffer pool is closed")
 )
 
 // DiskManager interface for reading/writing pages to disk
@@ -36,9 +40,13 @@ type DiskManager interface {
 type Frame struct {
 	frameID  FrameID
 	pageID   PageID
-	data     [PageSize]byte
+	class    int
+	data     []byte
 	pinCount atomic.Int32
 	dirty    atomic.Bool
+	lsn      atomic.Uint64
+	recLSN   atomic.Uint64 // first LSN set since the page was last written
+	scan     bool          // loaded by FetchPageForScan and not fetched since; guarded by bp.mu
 	mu       sync.RWMutex
 }
 
@@ -71,7 +79,28 @@ func (f *Frame) IsDirty() bool {
 
 // Data returns a pointer to the frame's data
 func (f *Frame) Data() []byte {
-	return f.data[:]
+	return f.data
+}
+
+// Replacer selects eviction victims among unpinned frames
+type Replacer interface {
+	RecordAccess(frameID FrameID)
+	Victim() (FrameID, bool)
+	Remove(frameID FrameID)
+	Size() int
+}
+
+// accessTracker is implemented by replacers that want to observe every
+// page access, not only the transitions to unpinned
+type accessTracker interface {
+	Touch(frameID FrameID, pageID PageID)
+}
+
+// framePinner is implemented by replacers that keep per-frame state across
+// pins. Pin takes a frame out of eviction but remembers it; Remove is only
+// used when the frame is freed.
+type framePinner interface {
+	Pin(frameID FrameID)
 }
 
 // LRUReplacer implements LRU eviction policy
@@ -93,21 +122,40 @@ func NewLRUReplacer(capacity int) *LRUReplacer {
 
 // RecordAccess records that a frame was accessed
 func (r *LRUReplacer) RecordAccess(frameID FrameID) {
-	// TODO: Implement LRU access tracking
-	// Move frame to front of LRU list
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.frames[frameID]; ok {
+		r.lruList.MoveToFront(elem)
+		return
+	}
+	r.frames[frameID] = r.lruList.PushFront(frameID)
 }
 
 // Victim returns a victim frame for eviction
 func (r *LRUReplacer) Victim() (FrameID, bool) {
-	// TODO: Implement victim selection
-	// Return least recently used unpinned frame
-	return -1, false
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	back := r.lruList.Back()
+	if back == nil {
+		return -1, false
+	}
+	frameID := back.Value.(FrameID)
+	r.lruList.Remove(back)
+	delete(r.frames, frameID)
+	return frameID, true
 }
 
 // Remove removes a frame from the replacer
 func (r *LRUReplacer) Remove(frameID FrameID) {
-	// TODO: Implement frame removal
-	// Remove frame from LRU tracking
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.frames[frameID]; ok {
+		r.lruList.Remove(elem)
+		delete(r.frames, frameID)
+	}
 }
 
 // Size returns the number of frames in the replacer
@@ -123,6 +171,8 @@ type BackgroundFlusher struct {
 	interval time.Duration
 	stopCh   chan struct{}
 	doneCh   chan struct{}
+	started  atomic.Bool
+	stopOnce sync.Once
 }
 
 // NewBackgroundFlusher creates a new background flusher
@@ -137,20 +187,39 @@ func NewBackgroundFlusher(pool *BufferPool, interval time.Duration) *BackgroundF
 
 // Start starts the background flusher goroutine
 func (f *BackgroundFlusher) Start() {
-	// TODO: Implement background flusher
-	// Periodically flush dirty pages using ticker
+	if f.started.Swap(true) {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		defer close(f.doneCh)
+
+		for {
+			select {
+			case <-ticker.C:
+				f.flushDirtyPages()
+			case <-f.stopCh:
+				return
+			}
+		}
+	}()
 }
 
 // Stop stops the background flusher
 func (f *BackgroundFlusher) Stop() {
-	// TODO: Implement graceful shutdown
-	// Close stopCh and wait for doneCh
+	f.stopOnce.Do(func() {
+		close(f.stopCh)
+		if f.started.Load() {
+			<-f.doneCh
+		}
+	})
 }
 
 // flushDirtyPages flushes all dirty pages
 func (f *BackgroundFlusher) flushDirtyPages() {
-	// TODO: Implement dirty page flushing
-	// Iterate through frames and flush dirty ones
+	// Errors are retried on the next tick; Close reports them
+	_ = f.pool.FlushAll()
 }
 
 // PoolStats contains buffer pool statistics
@@ -161,100 +230,356 @@ type PoolStats struct {
 	FreeFrames   int
 	CacheHits    int64
 	CacheMisses  int64
+	// CoalescedReads counts misses served by a read already in flight
+	CoalescedReads int64
 }
 
 // BufferPool manages a pool of page frames
 type BufferPool struct {
 	frames      []*Frame
 	pageTable   map[PageID]FrameID
-	freeList    []FrameID
-	replacer    *LRUReplacer
+	inflight    map[PageID]*pendingRead
+	classes     []*frameClass
 	diskManager DiskManager
 	mu          sync.RWMutex
 	flusher     *BackgroundFlusher
 	cacheHits   atomic.Int64
 	cacheMisses atomic.Int64
+	opts        Options
+	arena       *arena
+
+	coalescedReads atomic.Int64
+	checkpointMu   sync.Mutex // one Checkpoint at a time
+	owners         owners
+	closed         bool           // guarded by mu
+	reads          sync.WaitGroup // disk reads of pending fetches
+}
+
+// ReplacerType selects the eviction policy of a BufferPool
+type ReplacerType int
+
+const (
+	ReplacerLRU ReplacerType = iota
+	ReplacerTinyLFU
+)
+
+// Options configures a BufferPool. Zero values select the defaults.
+type Options struct {
+	// PoolSize is the number of frames in the pool (required)
+	PoolSize int
+	// FlushInterval is the background flusher period; a negative value
+	// disables the background flusher
+	FlushInterval time.Duration
+	// ReplacerType selects the eviction policy
+	ReplacerType ReplacerType
+	// PageSize is the size of each frame in bytes
+	PageSize int
+	// PageClasses partitions the pool into frame size classes. When set it
+	// replaces PoolSize and PageSize; the first class is the default used
+	// by FetchPage and NewPage.
+	PageClasses []PageClass
+	// CheckpointLog is the WAL pages are flushed against and checkpoints
+	// are logged to
+	CheckpointLog CheckpointLog
+	// CheckpointRate caps the pages per second Checkpoint writes; 0 is
+	// unlimited
+	CheckpointRate int
+	// FrameAllocation selects how frame memory is allocated
+	FrameAllocation FrameAllocation
+}
+
+// withDefaults fills in zero-valued options
+func (o Options) withDefaults() Options {
+	if o.FlushInterval == 0 {
+		o.FlushInterval = DefaultFlushInterval
+	}
+	if o.PageSize == 0 {
+		o.PageSize = DefaultPageSize
+	}
+	if len(o.PageClasses) == 0 {
+		o.PageClasses = []PageClass{{PageSize: o.PageSize, Frames: o.PoolSize}}
+	}
+	o.PageSize = o.PageClasses[0].PageSize
+	o.PoolSize = 0
+	for _, class := range o.PageClasses {
+		o.PoolSize += class.Frames
+	}
+	return o
+}
+
+// newReplacer creates the eviction policy selected by the options
+func (o Options) newReplacer(capacity int) Replacer {
+	switch o.ReplacerType {
+	case ReplacerTinyLFU:
+		return NewTinyLFUReplacer(capacity)
+	default:
+		return NewLRUReplacer(capacity)
+	}
 }
 
 // New creates a new buffer pool
-func New(diskManager DiskManager, poolSize int) *BufferPool {
+func New(diskManager DiskManager, opts Options) *BufferPool {
+	opts = opts.withDefaults()
 	bp := &BufferPool{
-		frames:      make([]*Frame, poolSize),
+		frames:      make([]*Frame, 0, opts.PoolSize),
 		pageTable:   make(map[PageID]FrameID),
-		freeList:    make([]FrameID, poolSize),
-		replacer:    NewLRUReplacer(poolSize),
+		inflight:    make(map[PageID]*pendingRead),
 		diskManager: diskManager,
+		opts:        opts,
 	}
 
-	// Initialize frames and free list
-	for i := 0; i < poolSize; i++ {
-		bp.frames[i] = &Frame{
-			frameID: FrameID(i),
-			pageID:  -1,
+	arenaSize := 0
+	for _, pc := range opts.PageClasses {
+		arenaSize += pc.PageSize * pc.Frames
+	}
+	bp.arena = newArena(opts.FrameAllocation, arenaSize)
+
+	// Initialize frames and a free list and replacer per size class
+	for classIdx, pc := range opts.PageClasses {
+		class := &frameClass{
+			pageSize: pc.PageSize,
+			freeList: make([]FrameID, 0, pc.Frames),
+			replacer: opts.newReplacer(pc.Frames),
 		}
-		bp.freeList[i] = FrameID(i)
+		for i := 0; i < pc.Frames; i++ {
+			frameID := FrameID(len(bp.frames))
+			bp.frames = append(bp.frames, &Frame{
+				frameID: frameID,
+				pageID:  -1,
+				class:   classIdx,
+				data:    bp.arena.alloc(pc.PageSize),
+			})
+			class.freeList = append(class.freeList, frameID)
+		}
+		bp.classes = append(bp.classes, class)
 	}
 
 	// Start background flusher
-	bp.flusher = NewBackgroundFlusher(bp, 5*time.Second)
-	bp.flusher.Start()
+	bp.flusher = NewBackgroundFlusher(bp, opts.FlushInterval)
+	if opts.FlushInterval > 0 {
+		bp.flusher.Start()
+	}
 
 	return bp
 }
 
-// FetchPage fetches a page from the pool or disk
+// PageSize returns the size of pages in the default class in bytes
+func (bp *BufferPool) PageSize() int {
+	return bp.opts.PageSize
+}
+
+// FetchPage fetches a page of the default size class from the pool or disk
 func (bp *BufferPool) FetchPage(pageID PageID) (*Frame, error) {
-	// TODO: Implement page fetching
-	// 1. Check if page is already in pool (cache hit)
-	// 2. If not, find a victim frame (from free list or via eviction)
-	// 3. If victim is dirty, flush it
-	// 4. Load new page from disk
-	// 5. Update page table and pin the frame
-	return nil, nil
+	return bp.fetchPage(pageID, 0)
+}
+
+func (bp *BufferPool) fetchPage(pageID PageID, class int) (*Frame, error) {
+	res := <-bp.fetchPageAsync(pageID, class, false)
+	return res.Frame, res.Err
+}
+
+// acquireFrameLocked returns an empty frame of the given class, evicting a
+// victim if the class's free list is exhausted. Dirty victims are written
+// back first. Caller holds bp.mu.
+func (bp *BufferPool) acquireFrameLocked(class int) (FrameID, error) {
+	fc := bp.classes[class]
+	if n := len(fc.freeList); n > 0 {
+		frameID := fc.freeList[n-1]
+		fc.freeList = fc.freeList[:n-1]
+		return frameID, nil
+	}
+
+	frameID, found := fc.replacer.Victim()
+	if !found {
+		return -1, ErrNoVictimFrame
+	}
+
+	victim := bp.frames[frameID]
+	if victim.IsDirty() {
+		err := bp.logFlushed(victim.LSN())
+		if err == nil {
+			err = bp.diskManager.WritePage(victim.pageID, victim.data)
+		}
+		if err != nil {
+			fc.replacer.RecordAccess(frameID)
+			return -1, err
+		}
+		victim.dirty.Store(false)
+	}
+	delete(bp.pageTable, victim.pageID)
+	victim.pageID = -1
+
+	return frameID, nil
+}
+
+// releaseFrameLocked returns an unmapped frame to its class's free list.
+// Caller holds bp.mu.
+func (bp *BufferPool) releaseFrameLocked(frame *Frame) {
+	fc := bp.classes[frame.class]
+	fc.freeList = append(fc.freeList, frame.frameID)
+}
+
+// replacerFor returns the replacer responsible for a frame
+func (bp *BufferPool) replacerFor(frameID FrameID) Replacer {
+	return bp.classes[bp.frames[frameID].class].replacer
+}
+
+// installLocked maps pageID to frame and pins it. Pages installed for a
+// scan are not reported to access trackers. Caller holds bp.mu.
+func (bp *BufferPool) installLocked(frame *Frame, pageID PageID, scan bool) {
+	frame.pageID = pageID
+	frame.dirty.Store(false)
+	frame.lsn.Store(0)
+	frame.recLSN.Store(0)
+	frame.scan = scan
+	frame.Pin()
+	bp.pageTable[pageID] = frame.frameID
+	if !scan {
+		bp.touch(frame.frameID, pageID)
+	}
+}
+
+// pinInReplacer takes a resident frame that was just pinned out of
+// eviction
+func (bp *BufferPool) pinInReplacer(frameID FrameID) {
+	r := bp.replacerFor(frameID)
+	if p, ok := r.(framePinner); ok {
+		p.Pin(frameID)
+		return
+	}
+	r.Remove(frameID)
+}
+
+// touch reports a page access to replacers that track access frequency
+func (bp *BufferPool) touch(frameID FrameID, pageID PageID) {
+	if t, ok := bp.replacerFor(frameID).(accessTracker); ok {
+		t.Touch(frameID, pageID)
+	}
 }
 
 // UnpinPage unpins a page and marks it dirty if modified
 func (bp *BufferPool) UnpinPage(pageID PageID, dirty bool) error {
-	// TODO: Implement unpinning
-	// 1. Find frame in page table
-	// 2. Decrement pin count
-	// 3. Mark dirty if needed
-	// 4. Add to replacer if pin count == 0
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	frameID, found := bp.pageTable[pageID]
+	if !found {
+		return ErrPageNotFound
+	}
+
+	frame := bp.frames[frameID]
+	if !frame.IsPinned() {
+		return ErrPageNotPinned
+	}
+	if dirty {
+		frame.MarkDirty()
+	}
+	frame.Unpin()
+	if !frame.IsPinned() {
+		bp.recordUnpinnedLocked(frame)
+	}
 	return nil
 }
 
 // FlushPage flushes a specific page to disk
 func (bp *BufferPool) FlushPage(pageID PageID) error {
-	// TODO: Implement single page flush
-	// 1. Find frame in page table
-	// 2. If dirty, write to disk
-	// 3. Clear dirty flag
-	return nil
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+
+	if bp.closed {
+		return ErrClosed
+	}
+	frameID, found := bp.pageTable[pageID]
+	if !found {
+		return ErrPageNotFound
+	}
+	return bp.flushFrame(bp.frames[frameID])
+}
+
+// flushFrame writes a dirty frame to disk and clears its dirty flag.
+// Caller holds bp.mu (shared or exclusive) so the mapping cannot change.
+func (bp *BufferPool) flushFrame(frame *Frame) error {
+	recLSN := frame.takeRecLSN()
+	if !frame.dirty.CompareAndSwap(true, false) {
+		frame.restoreRecLSN(recLSN)
+		return nil
+	}
+
+	err := bp.logFlushed(frame.LSN())
+	if err == nil {
+		frame.mu.RLock()
+		err = bp.diskManager.WritePage(frame.pageID, frame.data)
+		frame.mu.RUnlock()
+	}
+	if err != nil {
+		frame.dirty.Store(true)
+		frame.restoreRecLSN(recLSN)
+	}
+	return err
 }
 
 // FlushAll flushes all dirty pages to disk
 func (bp *BufferPool) FlushAll() error {
-	// TODO: Implement flush all
-	// Iterate through all frames and flush dirty ones
-	return nil
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+	if bp.closed {
+		return ErrClosed
+	}
+	return bp.flushBatched()
 }
 
-// NewPage allocates a new page
+// NewPage allocates a new page in the default size class
 func (bp *BufferPool) NewPage() (PageID, *Frame, error) {
-	// TODO: Implement page allocation
-	// 1. Allocate page from disk manager
-	// 2. Fetch the new page into pool
-	// 3. Return page ID and frame
-	return -1, nil, nil
+	return bp.newPage(0)
+}
+
+func (bp *BufferPool) newPage(class int) (PageID, *Frame, error) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	if bp.closed {
+		return -1, nil, ErrClosed
+	}
+	frameID, err := bp.acquireFrameLocked(class)
+	if err != nil {
+		return -1, nil, err
+	}
+
+	frame := bp.frames[frameID]
+	pageID, err := bp.diskManager.AllocatePage()
+	if err != nil {
+		bp.releaseFrameLocked(frame)
+		return -1, nil, err
+	}
+
+	clear(frame.data)
+	bp.installLocked(frame, pageID, false)
+	return pageID, frame, nil
 }
 
 // DeletePage deletes a page from pool and disk
 func (bp *BufferPool) DeletePage(pageID PageID) error {
-	// TODO: Implement page deletion
-	// 1. Remove from page table
-	// 2. Add frame to free list
-	// 3. Deallocate from disk
-	return nil
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+
+	// A page being read in is about to be pinned by its waiters
+	if _, reading := bp.inflight[pageID]; reading {
+		return ErrPagePinned
+	}
+
+	if frameID, found := bp.pageTable[pageID]; found {
+		frame := bp.frames[frameID]
+		if frame.IsPinned() {
+			return ErrPagePinned
+		}
+		bp.replacerFor(frameID).Remove(frameID)
+		delete(bp.pageTable, pageID)
+		frame.pageID = -1
+		frame.dirty.Store(false)
+		bp.releaseFrameLocked(frame)
+	}
+
+	return bp.diskManager.DeallocatePage(pageID)
 }
 
 // Stats returns buffer pool statistics
@@ -264,9 +589,13 @@ func (bp *BufferPool) Stats() PoolStats {
 
 	stats := PoolStats{
 		TotalFrames: len(bp.frames),
-		FreeFrames:  len(bp.freeList),
 		CacheHits:   bp.cacheHits.Load(),
 		CacheMisses: bp.cacheMisses.Load(),
+
+		CoalescedReads: bp.coalescedReads.Load(),
+	}
+	for _, fc := range bp.classes {
+		stats.FreeFrames += len(fc.freeList)
 	}
 
 	// Count pinned and dirty frames
@@ -282,11 +611,24 @@ func (bp *BufferPool) Stats() PoolStats {
 	return stats
 }
 
-// Close closes the buffer pool
+// Close flushes dirty pages and releases frame memory. Fetches and flushes
+// fail with ErrClosed from the moment Close starts, and disk reads already
+// in flight are waited for before the memory goes. The memory is released
+// even if the flush fails; both errors are returned. Closing twice is a
+// no-op.
 func (bp *BufferPool) Close() error {
-	// TODO: Implement cleanup
-	// 1. Stop background flusher
-	// 2. Flush all dirty pages
-	// 3. Wait for all pins to be released (or timeout)
-	return nil
+	bp.mu.Lock()
+	if bp.closed {
+		bp.mu.Unlock()
+		return nil
+	}
+	bp.closed = true
+	bp.mu.Unlock()
+
+	bp.flusher.Stop()
+	bp.reads.Wait()
+
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return errors.Join(bp.flushBatched(), bp.arena.free())
 }
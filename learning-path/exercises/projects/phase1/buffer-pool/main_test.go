@@ -1,13 +1,26 @@
 package bufferpool
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // MockDiskManager is a simple in-memory disk manager for testing
 type MockDiskManager struct {
 	pages      map[PageID][]byte
 	nextPageID PageID
+	mu         sync.Mutex
 }
 
 func NewMockDiskManager() *MockDiskManager {
@@ -18,28 +31,97 @@ func NewMockDiskManager() *MockDiskManager {
 }
 
 func (m *MockDiskManager) ReadPage(pageID PageID, data []byte) error {
-	// TODO: Implement mock read
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if page, ok := m.pages[pageID]; ok {
+		copy(data, page)
+		return nil
+	}
+	clear(data)
 	return nil
 }
 
 func (m *MockDiskManager) WritePage(pageID PageID, data []byte) error {
-	// TODO: Implement mock write
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.pages[pageID] = bytes.Clone(data)
 	return nil
 }
 
 func (m *MockDiskManager) AllocatePage() (PageID, error) {
-	// TODO: Implement mock allocation
-	return -1, nil
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pageID := m.nextPageID
+	m.nextPageID++
+	return pageID, nil
 }
 
 func (m *MockDiskManager) DeallocatePage(pageID PageID) error {
-	// TODO: Implement mock deallocation
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.pages, pageID)
+	return nil
+}
+
+// SlowDiskManager blocks reads until release is closed and counts them
+type SlowDiskManager struct {
+	*MockDiskManager
+	release chan struct{}
+	reads   atomic.Int32
+}
+
+func (m *SlowDiskManager) ReadPage(pageID PageID, data []byte) error {
+	m.reads.Add(1)
+	<-m.release
+	return m.MockDiskManager.ReadPage(pageID, data)
+}
+
+// VectoredDiskManager adds WritePages to MockDiskManager and records the
+// length of every batch it receives
+type VectoredDiskManager struct {
+	*MockDiskManager
+	batches []int
+}
+
+func (m *VectoredDiskManager) WritePages(startPageID PageID, pages [][]byte) error {
+	m.mu.Lock()
+	m.batches = append(m.batches, len(pages))
+	m.mu.Unlock()
+
+	for i, data := range pages {
+		if err := m.WritePage(startPageID+PageID(i), data); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// touchPage fetches and immediately unpins a page
+func touchPage(t testing.TB, bp *BufferPool, pageID PageID) {
+	t.Helper()
+	if _, err := bp.FetchPage(pageID); err != nil {
+		t.Fatalf("fetch page %d: %v", pageID, err)
+	}
+	if err := bp.UnpinPage(pageID, false); err != nil {
+		t.Fatalf("unpin page %d: %v", pageID, err)
+	}
+}
+
+// resident reports whether pageID is currently cached
+func resident(bp *BufferPool, pageID PageID) bool {
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+	_, ok := bp.pageTable[pageID]
+	return ok
+}
+
 func TestNew(t *testing.T) {
 	dm := NewMockDiskManager()
-	bp := New(dm, 10)
+	bp := New(dm, Options{PoolSize: 10})
 
 	if bp == nil {
 		t.Fatal("expected non-nil buffer pool")
@@ -51,6 +133,79 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewOptions(t *testing.T) {
+	dm := NewMockDiskManager()
+	bp := New(dm, Options{PoolSize: 2, PageSize: 16 << 10, FlushInterval: -1})
+	defer bp.Close()
+
+	if bp.PageSize() != 16<<10 {
+		t.Errorf("expected 16KB pages, got %d", bp.PageSize())
+	}
+	frame, err := bp.FetchPage(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frame.Data()) != 16<<10 {
+		t.Errorf("expected 16KB frame, got %d bytes", len(frame.Data()))
+	}
+	if bp.flusher.started.Load() {
+		t.Error("expected background flusher to be disabled")
+	}
+
+	def := New(dm, Options{PoolSize: 2})
+	defer def.Close()
+	if _, ok := def.classes[0].replacer.(*LRUReplacer); !ok {
+		t.Error("expected LRU replacer by default")
+	}
+}
+
+func TestPageClasses(t *testing.T) {
+	dm := NewMockDiskManager()
+	bp := New(dm, Options{PageClasses: []PageClass{
+		{PageSize: 4 << 10, Frames: 2},
+		{PageSize: 16 << 10, Frames: 1},
+	}})
+	defer bp.Close()
+
+	if stats := bp.Stats(); stats.TotalFrames != 3 || stats.FreeFrames != 3 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	big, err := bp.FetchPageSized(100, 16<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(big.Data()) != 16<<10 {
+		t.Errorf("expected 16KB frame, got %d bytes", len(big.Data()))
+	}
+	if err := bp.UnpinPage(100, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Churning through small pages must only evict small frames
+	for pageID := PageID(0); pageID < 10; pageID++ {
+		touchPage(t, bp, pageID)
+	}
+	if !resident(bp, 100) {
+		t.Error("large page was evicted by small-page traffic")
+	}
+
+	if _, err := bp.FetchPage(100); !errors.Is(err, ErrPageClassMismatch) {
+		t.Errorf("expected ErrPageClassMismatch, got %v", err)
+	}
+	if _, err := bp.FetchPageSized(1, 64<<10); !errors.Is(err, ErrNoPageClass) {
+		t.Errorf("expected ErrNoPageClass, got %v", err)
+	}
+
+	// The single large frame is pinned, so a second large page cannot load
+	if _, err := bp.FetchPageSized(100, 16<<10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bp.FetchPageSized(101, 16<<10); !errors.Is(err, ErrNoVictimFrame) {
+		t.Errorf("expected ErrNoVictimFrame, got %v", err)
+	}
+}
+
 func TestFetchPage(t *testing.T) {
 	// TODO: Implement fetch page test
 	// 1. Create buffer pool
@@ -131,10 +286,897 @@ func TestFlushAll(t *testing.T) {
 	t.Skip("not implemented")
 }
 
+func TestTinyLFUScanResistance(t *testing.T) {
+	dm := NewMockDiskManager()
+	bp := New(dm, Options{PoolSize: 4, ReplacerType: ReplacerTinyLFU})
+	defer bp.Close()
+
+	// Build up a hot working set of two pages
+	for i := 0; i < 10; i++ {
+		touchPage(t, bp, 0)
+		touchPage(t, bp, 1)
+	}
+
+	// A one-off scan over many pages must not push the hot pages out
+	for pageID := PageID(100); pageID < 140; pageID++ {
+		touchPage(t, bp, pageID)
+	}
+
+	for _, pageID := range []PageID{0, 1} {
+		if !resident(bp, pageID) {
+			t.Errorf("hot page %d was evicted by scan", pageID)
+		}
+	}
+}
+
+func TestTinyLFUDecay(t *testing.T) {
+	r := NewTinyLFUReplacer(4)
+	for i := 0; i < 20; i++ {
+		r.Touch(0, 7)
+	}
+	before := r.Temperature(0)
+	for i := 0; i < r.decayPeriod; i++ {
+		r.Touch(1, PageID(1000+i))
+	}
+	if after := r.Temperature(0); after >= before {
+		t.Errorf("expected temperature to decay, before=%d after=%d", before, after)
+	}
+}
+
+func TestTinyLFUDeletedFrameStartsCold(t *testing.T) {
+	bp := New(NewMockDiskManager(), Options{PoolSize: 4, ReplacerType: ReplacerTinyLFU})
+	defer bp.Close()
+
+	for range 20 {
+		touchPage(t, bp, 5)
+	}
+	frameID := bp.pageTable[5]
+	r := bp.replacerFor(frameID).(*TinyLFUReplacer)
+	hot := r.Temperature(frameID)
+	if err := bp.DeletePage(5); err != nil {
+		t.Fatal(err)
+	}
+
+	touchPage(t, bp, 9)
+	if bp.pageTable[9] != frameID {
+		t.Fatalf("page 9 loaded into frame %d, want the freed frame %d", bp.pageTable[9], frameID)
+	}
+	if got := r.Temperature(frameID); got != 1 {
+		t.Errorf("reused frame temperature = %d, want 1 (was %d while page 5 was hot)", got, hot)
+	}
+}
+
+func TestDumpFrames(t *testing.T) {
+	dm := NewMockDiskManager()
+	bp := New(dm, Options{PoolSize: 4, ReplacerType: ReplacerTinyLFU})
+	defer bp.Close()
+
+	for i := 0; i < 3; i++ {
+		touchPage(t, bp, 5)
+	}
+	touchPage(t, bp, 6)
+
+	infos := bp.DebugFrames()
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(infos))
+	}
+	if infos[0].PageID != 5 || infos[0].Temperature <= infos[1].Temperature {
+		t.Errorf("expected page 5 hottest, got %+v", infos)
+	}
+
+	var sb strings.Builder
+	if err := bp.DumpFrames(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(sb.String(), "\n"); lines != 3 {
+		t.Errorf("expected header plus 2 rows, got %d lines:\n%s", lines, sb.String())
+	}
+}
+
+func TestUnpinErrors(t *testing.T) {
+	bp := New(NewMockDiskManager(), Options{PoolSize: 2})
+	defer bp.Close()
+
+	if err := bp.UnpinPage(3, false); !errors.Is(err, ErrPageNotFound) {
+		t.Errorf("expected ErrPageNotFound, got %v", err)
+	}
+	touchPage(t, bp, 3)
+	if err := bp.UnpinPage(3, false); !errors.Is(err, ErrPageNotPinned) {
+		t.Errorf("expected ErrPageNotPinned, got %v", err)
+	}
+}
+
+func TestFlushAllCoalescesAdjacentPages(t *testing.T) {
+	dm := &VectoredDiskManager{MockDiskManager: NewMockDiskManager()}
+	bp := New(dm, Options{PoolSize: 8})
+	defer bp.Close()
+
+	for _, pageID := range []PageID{3, 1, 2, 7, 5} {
+		frame, err := bp.FetchPage(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame.Data()[0] = byte(pageID)
+		if err := bp.UnpinPage(pageID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := bp.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages 1-3 form one run; 5 and 7 are isolated single-page writes
+	if len(dm.batches) != 1 || dm.batches[0] != 3 {
+		t.Errorf("expected one batch of 3 pages, got %v", dm.batches)
+	}
+	for _, pageID := range []PageID{1, 2, 3, 5, 7} {
+		if got := dm.pages[pageID][0]; got != byte(pageID) {
+			t.Errorf("page %d: expected %d on disk, got %d", pageID, pageID, got)
+		}
+	}
+	if stats := bp.Stats(); stats.DirtyFrames != 0 {
+		t.Errorf("expected no dirty frames after flush, got %d", stats.DirtyFrames)
+	}
+}
+
+func TestFlushAllWithoutPageWriter(t *testing.T) {
+	dm := NewMockDiskManager()
+	bp := New(dm, Options{PoolSize: 4})
+	defer bp.Close()
+
+	for pageID := PageID(0); pageID < 3; pageID++ {
+		if _, err := bp.FetchPage(pageID); err != nil {
+			t.Fatal(err)
+		}
+		if err := bp.UnpinPage(pageID, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bp.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(dm.pages) != 3 {
+		t.Errorf("expected 3 pages written, got %d", len(dm.pages))
+	}
+}
+
+// MockLog is a CheckpointLog that records what the pool asks of it.
+// Checkpoint records are numbered from 1000; EndLSN reports end.
+// beforeAppend, if set, runs as a checkpoint record is appended.
+type MockLog struct {
+	mu           sync.Mutex
+	flushedTo    LSN
+	end          LSN
+	begins       []LSN
+	checkpoints  []map[PageID]LSN
+	beforeAppend func()
+	err          error
+}
+
+func (l *MockLog) FlushTo(lsn LSN) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushedTo = max(l.flushedTo, lsn)
+	return nil
+}
+
+func (l *MockLog) EndLSN() LSN {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.end
+}
+
+func (l *MockLog) AppendCheckpoint(begin LSN, dirty map[PageID]LSN) (LSN, error) {
+	if l.beforeAppend != nil {
+		l.beforeAppend()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return 0, l.err
+	}
+	l.begins = append(l.begins, begin)
+	l.checkpoints = append(l.checkpoints, dirty)
+	return LSN(1000 + len(l.checkpoints)), nil
+}
+
+// SyncingDiskManager records the order of writes and counts syncs
+type SyncingDiskManager struct {
+	*MockDiskManager
+	writes []PageID
+	syncs  int
+}
+
+func (m *SyncingDiskManager) WritePage(pageID PageID, data []byte) error {
+	m.mu.Lock()
+	m.writes = append(m.writes, pageID)
+	m.mu.Unlock()
+	return m.MockDiskManager.WritePage(pageID, data)
+}
+
+func (m *SyncingDiskManager) Sync() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.syncs++
+	return nil
+}
+
+// dirtyPage modifies a page under the given LSN and unpins it dirty
+func dirtyPage(t testing.TB, bp *BufferPool, pageID PageID, lsn LSN) {
+	t.Helper()
+	frame, err := bp.FetchPage(pageID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame.Data()[0] = byte(lsn)
+	frame.SetLSN(lsn)
+	if err := bp.UnpinPage(pageID, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpoint(t *testing.T) {
+	dm := &SyncingDiskManager{MockDiskManager: NewMockDiskManager()}
+	log := &MockLog{end: 25}
+	bp := New(dm, Options{PoolSize: 4, FlushInterval: -1, CheckpointLog: log})
+	defer bp.Close()
+
+	// Page 2 was dirtied first, so it is written first
+	dirtyPage(t, bp, 2, 10)
+	dirtyPage(t, bp, 1, 15)
+	dirtyPage(t, bp, 1, 20)
+	touchPage(t, bp, 3) // clean page must not be reported
+
+	result, err := bp.Checkpoint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Flushed) != 2 || result.Flushed[1] != 20 || result.Flushed[2] != 10 {
+		t.Errorf("Flushed = %v, want pages 1@20 and 2@10", result.Flushed)
+	}
+	if len(dm.writes) != 2 || dm.writes[0] != 2 || dm.pages[1][0] != 20 {
+		t.Errorf("writes %v, want page 2 then page 1", dm.writes)
+	}
+	// The log was flushed past every written page, and the pages synced,
+	// before the checkpoint was logged
+	if log.flushedTo != 20 || dm.syncs != 1 || len(log.checkpoints) != 1 {
+		t.Errorf("log flushed to %d, %d syncs, %d checkpoints", log.flushedTo, dm.syncs, len(log.checkpoints))
+	}
+	if result.CheckpointLSN != 1001 || result.BeginLSN != 25 || result.RecoveryLSN != 25 || len(result.DirtyPages) != 0 {
+		t.Errorf("result = %+v, want a clean checkpoint at 1001 redoing from 25", result)
+	}
+	if len(log.begins) != 1 || log.begins[0] != 25 {
+		t.Errorf("logged begin LSNs %v, want [25]", log.begins)
+	}
+	if stats := bp.Stats(); stats.DirtyFrames != 0 {
+		t.Errorf("expected no dirty frames, got %d", stats.DirtyFrames)
+	}
+
+	// A page dirtied after the checkpoint starts a new recLSN
+	dirtyPage(t, bp, 1, 30)
+	if got := bp.dirtyPageTable(); got[1] != 30 {
+		t.Errorf("dirty page table %v, want page 1 at recLSN 30", got)
+	}
+
+	// A failing log fails the checkpoint
+	log.err = errors.New("wal unavailable")
+	if _, err := bp.Checkpoint(context.Background()); err == nil {
+		t.Error("expected checkpoint error from the log")
+	}
+}
+
+func TestCheckpointThrottled(t *testing.T) {
+	dm := &SyncingDiskManager{MockDiskManager: NewMockDiskManager()}
+	log := &MockLog{end: 1000}
+	bp := New(dm, Options{PoolSize: 64, FlushInterval: -1, CheckpointLog: log, CheckpointRate: 400})
+	defer bp.Close()
+	for pageID := range PageID(40) {
+		dirtyPage(t, bp, pageID, LSN(100+pageID))
+	}
+
+	// 40 pages at 400 pages/sec take about 100ms. Foreground fetches keep
+	// going meanwhile, and a page written early is dirtied again.
+	done := make(chan struct{})
+	var result *CheckpointResult
+	var err error
+	began := time.Now()
+	go func() {
+		defer close(done)
+		result, err = bp.Checkpoint(context.Background())
+	}()
+	var slowest time.Duration
+	redirtied := false
+	for fetches := 0; ; fetches++ {
+		select {
+		case <-done:
+		default:
+			fetched := time.Now()
+			touchPage(t, bp, PageID(40+fetches%8))
+			slowest = max(slowest, time.Since(fetched))
+			if _, dirty := bp.dirtyPageTable()[0]; !redirtied && !dirty {
+				dirtyPage(t, bp, 0, 500)
+				redirtied = true
+			}
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		break
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if elapsed := time.Since(began); len(result.Flushed) != 40 || elapsed < 90*time.Millisecond {
+		t.Errorf("wrote %d pages in %v, want 40 throttled to about 100ms", len(result.Flushed), elapsed)
+	}
+	if slowest > 50*time.Millisecond {
+		t.Errorf("slowest fetch during checkpoint took %v", slowest)
+	}
+	if !redirtied {
+		t.Fatal("page 0 was not written before the checkpoint finished")
+	}
+	if result.DirtyPages[0] != 500 || result.RecoveryLSN != 500 {
+		t.Errorf("dirty pages %v recovery LSN %d, want page 0 at 500", result.DirtyPages, result.RecoveryLSN)
+	}
+	if len(log.checkpoints) != 1 || log.checkpoints[0][0] != 500 {
+		t.Errorf("logged dirty page tables %v", log.checkpoints)
+	}
+}
+
+func TestCheckpointBeginLSN(t *testing.T) {
+	log := &MockLog{end: 40}
+	bp := New(NewMockDiskManager(), Options{PoolSize: 4, FlushInterval: -1, CheckpointLog: log})
+	defer bp.Close()
+	dirtyPage(t, bp, 1, 30)
+
+	// A clean page is updated after the dirty page table is taken but
+	// before the checkpoint record is appended
+	log.beforeAppend = func() {
+		log.beforeAppend = nil
+		dirtyPage(t, bp, 2, 40)
+	}
+	result, err := bp.Checkpoint(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := result.DirtyPages[2]; ok {
+		t.Fatalf("dirty page table %v already has page 2", result.DirtyPages)
+	}
+	if result.BeginLSN != 40 || result.RecoveryLSN > 40 {
+		t.Errorf("begin %d recovery %d, want redo from 40 at the latest", result.BeginLSN, result.RecoveryLSN)
+	}
+
+	// A pinned page given an LSN is in the table though not yet dirty
+	frame, err := bp.FetchPage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame.SetLSN(45)
+	log.end = 50
+	if result, err = bp.Checkpoint(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if result.DirtyPages[3] != 45 || result.RecoveryLSN != 45 {
+		t.Errorf("dirty pages %v recovery %d, want pinned page 3 at 45", result.DirtyPages, result.RecoveryLSN)
+	}
+	if err := bp.UnpinPage(3, true); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckpointCancel(t *testing.T) {
+	log := &MockLog{}
+	bp := New(NewMockDiskManager(), Options{PoolSize: 8, FlushInterval: -1, CheckpointLog: log, CheckpointRate: 10})
+	defer bp.Close()
+	for pageID := range PageID(4) {
+		dirtyPage(t, bp, pageID, LSN(1+pageID))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	if _, err := bp.Checkpoint(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Checkpoint() = %v, want DeadlineExceeded", err)
+	}
+	// The pages written before the deadline stay clean, oldest first
+	if got := bp.dirtyPageTable(); len(got) != 2 || got[2] != 3 || got[3] != 4 {
+		t.Errorf("dirty page table after cancel = %v, want pages 2 and 3", got)
+	}
+	if len(log.checkpoints) != 0 {
+		t.Error("cancelled checkpoint was logged")
+	}
+}
+
+func TestFetchPageAsyncCoalesces(t *testing.T) {
+	dm := &SlowDiskManager{MockDiskManager: NewMockDiskManager(), release: make(chan struct{})}
+	dm.pages[7] = []byte{42}
+	bp := New(dm, Options{PoolSize: 4, FlushInterval: -1})
+	defer bp.Close()
+
+	first := bp.FetchPageAsync(7)
+	second := bp.FetchPageAsync(7)
+
+	// The latch is free while the read is in flight
+	if stats := bp.Stats(); stats.CacheMisses != 2 {
+		t.Errorf("CacheMisses = %d, want 2", stats.CacheMisses)
+	}
+	close(dm.release)
+
+	for _, ch := range []<-chan FetchResult{first, second} {
+		res := <-ch
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if res.Frame.Data()[0] != 42 {
+			t.Errorf("page data = %d, want 42", res.Frame.Data()[0])
+		}
+	}
+	if n := dm.reads.Load(); n != 1 {
+		t.Errorf("disk reads = %d, want 1", n)
+	}
+	if stats := bp.Stats(); stats.CoalescedReads != 1 {
+		t.Errorf("CoalescedReads = %d, want 1", stats.CoalescedReads)
+	}
+
+	// Each waiter holds its own pin
+	for range 2 {
+		if err := bp.UnpinPage(7, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bp.UnpinPage(7, false); err != ErrPageNotPinned {
+		t.Errorf("third unpin = %v, want ErrPageNotPinned", err)
+	}
+
+	res := <-bp.FetchPageAsync(7)
+	if res.Err != nil || bp.Stats().CacheHits != 1 {
+		t.Errorf("resident fetch: err=%v hits=%d", res.Err, bp.Stats().CacheHits)
+	}
+}
+
+// AsyncDiskManager adds AsyncPageIO to MockDiskManager and records the
+// length of every batch it receives. Writes fail while failWrites is set.
+type AsyncDiskManager struct {
+	*MockDiskManager
+	readBatches, writeBatches []int
+	failWrites                atomic.Bool
+}
+
+func (m *AsyncDiskManager) ReadPagesAsync(pageIDs []PageID, bufs [][]byte) <-chan []error {
+	m.mu.Lock()
+	m.readBatches = append(m.readBatches, len(pageIDs))
+	m.mu.Unlock()
+	done := make(chan []error, 1)
+	go func() {
+		errs := make([]error, len(pageIDs))
+		for i, pageID := range pageIDs {
+			errs[i] = m.ReadPage(pageID, bufs[i])
+		}
+		done <- errs
+	}()
+	return done
+}
+
+func (m *AsyncDiskManager) WritePagesAsync(pageIDs []PageID, pages [][]byte) <-chan error {
+	m.mu.Lock()
+	m.writeBatches = append(m.writeBatches, len(pageIDs))
+	m.mu.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		if m.failWrites.Load() {
+			done <- errors.New("write failed")
+			return
+		}
+		for i, pageID := range pageIDs {
+			m.WritePage(pageID, pages[i])
+		}
+		done <- nil
+	}()
+	return done
+}
+
+func TestAsyncPageIO(t *testing.T) {
+	dm := &AsyncDiskManager{MockDiskManager: NewMockDiskManager()}
+	for pageID := PageID(0); pageID < 6; pageID++ {
+		dm.WritePage(pageID, bytes.Repeat([]byte{byte(pageID)}, DefaultPageSize))
+	}
+	bp := New(dm, Options{PoolSize: 8, FlushInterval: -1})
+	defer bp.Close()
+
+	// The prefetched misses are read as one batch and left unpinned
+	if err := bp.Prefetch([]PageID{0, 1, 2, 3, 4}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dm.readBatches) != 1 || dm.readBatches[0] != 5 {
+		t.Errorf("read batches = %v, want one of 5 pages", dm.readBatches)
+	}
+	if stats := bp.Stats(); stats.PinnedFrames != 0 || stats.CacheMisses != 5 {
+		t.Errorf("after Prefetch: %+v", stats)
+	}
+	frame, err := bp.FetchPage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Data()[0] != 3 || bp.Stats().CacheHits != 1 {
+		t.Errorf("prefetched page 3 = %d, stats %+v", frame.Data()[0], bp.Stats())
+	}
+	bp.UnpinPage(3, false)
+
+	// Resident pages are not read again; a single miss uses ReadPage
+	if err := bp.Prefetch([]PageID{1, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if len(dm.readBatches) != 1 {
+		t.Errorf("read batches = %v, want no new batch", dm.readBatches)
+	}
+
+	// The dirty pages are written as one batch
+	for _, pageID := range []PageID{4, 0, 2} {
+		frame, err := bp.FetchPage(pageID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frame.Data()[0] = 100 + byte(pageID)
+		bp.UnpinPage(pageID, true)
+	}
+	if err := bp.FlushAll(); err != nil {
+		t.Fatal(err)
+	}
+	if len(dm.writeBatches) != 1 || dm.writeBatches[0] != 3 {
+		t.Errorf("write batches = %v, want one of 3 pages", dm.writeBatches)
+	}
+	for _, pageID := range []PageID{0, 2, 4} {
+		if got := dm.pages[pageID][0]; got != 100+byte(pageID) {
+			t.Errorf("page %d on disk = %d", pageID, got)
+		}
+	}
+	if stats := bp.Stats(); stats.DirtyFrames != 0 {
+		t.Errorf("dirty frames after flush = %d", stats.DirtyFrames)
+	}
+
+	// A failed batch leaves its pages dirty
+	dm.failWrites.Store(true)
+	if _, err := bp.FetchPage(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := bp.UnpinPage(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := bp.FlushAll(); err == nil {
+		t.Error("FlushAll succeeded with failing writes")
+	}
+	if stats := bp.Stats(); stats.DirtyFrames != 1 {
+		t.Errorf("dirty frames after a failed flush = %d, want 1", stats.DirtyFrames)
+	}
+	dm.failWrites.Store(false)
+}
+
+func TestFrameAllocation(t *testing.T) {
+	for name, alloc := range map[string]FrameAllocation{
+		"per-frame": AllocPerFrame,
+		"arena":     AllocArena,
+		"mmap":      AllocMmap,
+	} {
+		t.Run(name, func(t *testing.T) {
+			dm := NewMockDiskManager()
+			bp := New(dm, Options{PoolSize: 4, FlushInterval: -1, FrameAllocation: alloc})
+
+			// Cycle more pages than frames so every frame is reused
+			for pageID := PageID(0); pageID < 16; pageID++ {
+				frame, err := bp.FetchPage(pageID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cap(frame.Data()) != DefaultPageSize {
+					t.Fatalf("frame capacity = %d, want %d", cap(frame.Data()), DefaultPageSize)
+				}
+				frame.Data()[0] = byte(pageID)
+				frame.Data()[DefaultPageSize-1] = byte(pageID)
+				if err := bp.UnpinPage(pageID, true); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := bp.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			for pageID := PageID(0); pageID < 16; pageID++ {
+				page := dm.pages[pageID]
+				if page[0] != byte(pageID) || page[DefaultPageSize-1] != byte(pageID) {
+					t.Errorf("page %d corrupted: first=%d last=%d", pageID, page[0], page[DefaultPageSize-1])
+				}
+			}
+		})
+	}
+}
+
+func TestCloseReleasesArenaOnFlushError(t *testing.T) {
+	dm := &AsyncDiskManager{MockDiskManager: NewMockDiskManager()}
+	bp := New(dm, Options{PoolSize: 4, FlushInterval: -1, FrameAllocation: AllocMmap})
+	if _, err := bp.FetchPage(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := bp.UnpinPage(0, true); err != nil {
+		t.Fatal(err)
+	}
+
+	dm.failWrites.Store(true)
+	if err := bp.Close(); err == nil {
+		t.Error("Close succeeded with failing writes")
+	}
+	if bp.arena.release != nil {
+		t.Error("Close left the frame mapping in place after a failed flush")
+	}
+}
+
+func TestFetchAfterClose(t *testing.T) {
+	bp := New(NewMockDiskManager(), Options{PoolSize: 4, FlushInterval: -1, FrameAllocation: AllocMmap})
+	touchPage(t, bp, 1)
+	if err := bp.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pageID := range []PageID{1, 2} {
+		if _, err := bp.FetchPage(pageID); !errors.Is(err, ErrClosed) {
+			t.Errorf("FetchPage(%d) after Close = %v, want ErrClosed", pageID, err)
+		}
+	}
+	if _, _, err := bp.NewPage(); !errors.Is(err, ErrClosed) {
+		t.Errorf("NewPage after Close = %v, want ErrClosed", err)
+	}
+	if err := bp.FlushAll(); !errors.Is(err, ErrClosed) {
+		t.Errorf("FlushAll after Close = %v, want ErrClosed", err)
+	}
+	if err := bp.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+}
+
+func TestCloseWaitsForInflightReads(t *testing.T) {
+	dm := &SlowDiskManager{MockDiskManager: NewMockDiskManager(), release: make(chan struct{})}
+	bp := New(dm, Options{PoolSize: 4, FlushInterval: -1, FrameAllocation: AllocMmap})
+
+	result := bp.FetchPageAsync(3)
+	for dm.reads.Load() == 0 {
+		runtime.Gosched()
+	}
+	closed := make(chan error)
+	go func() { closed <- bp.Close() }()
+	for {
+		bp.mu.RLock()
+		started := bp.closed
+		bp.mu.RUnlock()
+		if started {
+			break
+		}
+		runtime.Gosched()
+	}
+
+	select {
+	case err := <-closed:
+		t.Fatalf("Close returned while a read was in flight: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(dm.release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if res := <-result; !errors.Is(res.Err, ErrClosed) {
+		t.Errorf("fetch racing Close = %v, want ErrClosed", res.Err)
+	}
+}
+
+// BenchmarkGCWithPool measures a full GC cycle while a 64MB pool is live
+func BenchmarkGCWithPool(b *testing.B) {
+	for _, tc := range []struct {
+		name  string
+		alloc FrameAllocation
+	}{
+		{"per-frame", AllocPerFrame},
+		{"arena", AllocArena},
+		{"mmap", AllocMmap},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			bp := New(NewMockDiskManager(), Options{PoolSize: 16384, FlushInterval: -1, FrameAllocation: tc.alloc})
+			defer bp.Close()
+			runtime.GC()
+
+			var stats runtime.MemStats
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+			}
+			b.StopTimer()
+
+			runtime.ReadMemStats(&stats)
+			b.ReportMetric(float64(stats.HeapAlloc)/(1<<20), "heap-MB")
+			runtime.KeepAlive(bp)
+		})
+	}
+}
+
+func TestSaveLoadState(t *testing.T) {
+	dm := NewMockDiskManager()
+	for pageID := PageID(0); pageID < 4; pageID++ {
+		dm.pages[pageID] = []byte{byte(pageID + 1)}
+	}
+	path := filepath.Join(t.TempDir(), "pool.state")
+
+	bp := New(dm, Options{PoolSize: 4, FlushInterval: -1, ReplacerType: ReplacerTinyLFU})
+	for pageID := PageID(0); pageID < 4; pageID++ {
+		// Page 3 is the hottest, page 0 the coldest
+		for range int(pageID) + 1 {
+			touchPage(t, bp, pageID)
+		}
+	}
+	if err := bp.SaveState(path); err != nil {
+		t.Fatal(err)
+	}
+	bp.Close()
+
+	// A smaller pool keeps only the hottest pages
+	warm := New(dm, Options{PoolSize: 2, FlushInterval: -1})
+	defer warm.Close()
+	if n, err := warm.WarmUp(path); err != nil || n != 2 {
+		t.Fatalf("WarmUp = %d, %v", n, err)
+	}
+	for pageID, want := range map[PageID]bool{0: false, 1: false, 2: true, 3: true} {
+		if resident(warm, pageID) != want {
+			t.Errorf("page %d resident = %v, want %v", pageID, !want, want)
+		}
+	}
+	if stats := warm.Stats(); stats.PinnedFrames != 0 || stats.DirtyFrames != 0 {
+		t.Errorf("prefetched pages left pinned=%d dirty=%d", stats.PinnedFrames, stats.DirtyFrames)
+	}
+
+	frame, err := warm.FetchPage(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Data()[0] != 4 || warm.Stats().CacheHits != 1 {
+		t.Errorf("warm fetch: data=%d hits=%d", frame.Data()[0], warm.Stats().CacheHits)
+	}
+	warm.UnpinPage(3, false)
+
+	// A TinyLFU pool gets the saved temperatures back
+	hot := New(dm, Options{PoolSize: 4, FlushInterval: -1, ReplacerType: ReplacerTinyLFU})
+	defer hot.Close()
+	if err := hot.LoadState(path); err != nil {
+		t.Fatal(err)
+	}
+	temps := make(map[PageID]uint32)
+	for _, info := range hot.DebugFrames() {
+		temps[info.PageID] = info.Temperature
+	}
+	if temps[3] < 4 || temps[2] < 3 || temps[0] >= temps[3] {
+		t.Errorf("warmed temperatures = %v", temps)
+	}
+
+	// Version 1 files, without temperatures, still load
+	v1 := filepath.Join(t.TempDir(), "v1.state")
+	var buf bytes.Buffer
+	buf.WriteString(stateMagicV1)
+	binary.Write(&buf, binary.LittleEndian, uint32(1))
+	binary.Write(&buf, binary.LittleEndian, stateEntryV1{PageID: 1})
+	os.WriteFile(v1, buf.Bytes(), 0o644)
+	old := New(dm, Options{PoolSize: 2, FlushInterval: -1})
+	defer old.Close()
+	if n, err := old.WarmUp(v1); err != nil || n != 1 || !resident(old, 1) {
+		t.Errorf("WarmUp(v1) = %d, %v", n, err)
+	}
+
+	if _, err := warm.WarmUp(filepath.Join(t.TempDir(), "missing")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("WarmUp(missing) = %v, want ErrNotExist", err)
+	}
+	bad := filepath.Join(t.TempDir(), "bad")
+	os.WriteFile(bad, []byte("garbage"), 0o644)
+	if _, err := warm.WarmUp(bad); !errors.Is(err, ErrBadStateFile) {
+		t.Errorf("WarmUp(bad) = %v, want ErrBadStateFile", err)
+	}
+}
+
+func TestFetchPageForScan(t *testing.T) {
+	for name, replacer := range map[string]ReplacerType{"lru": ReplacerLRU, "tinylfu": ReplacerTinyLFU} {
+		t.Run(name, func(t *testing.T) {
+			bp := New(NewMockDiskManager(), Options{PoolSize: 4, FlushInterval: -1, ReplacerType: replacer})
+			defer bp.Close()
+
+			touchPage(t, bp, 0)
+			touchPage(t, bp, 1)
+
+			for pageID := PageID(100); pageID < 120; pageID++ {
+				if _, err := bp.FetchPageForScan(pageID); err != nil {
+					t.Fatal(err)
+				}
+				if err := bp.UnpinPage(pageID, false); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !resident(bp, 0) || !resident(bp, 1) {
+				t.Error("scan evicted the working set")
+			}
+
+			// A regular fetch promotes a scan-loaded page
+			touchPage(t, bp, 119)
+			touchPage(t, bp, 200)
+			touchPage(t, bp, 201)
+			if !resident(bp, 119) {
+				t.Error("promoted scan page was evicted before colder pages")
+			}
+		})
+	}
+}
+
+func TestOwnerStats(t *testing.T) {
+	bp := New(NewMockDiskManager(), Options{PoolSize: 8, FlushInterval: -1})
+	defer bp.Close()
+	touchPage(t, bp, 1)
+
+	for _, pageID := range []PageID{1, 2, 2} {
+		if _, err := bp.FetchPageOwned("q1", pageID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := bp.FetchPageOwned("q2", 2); err != nil {
+		t.Fatal(err)
+	}
+	want := OwnerStats{Owner: "q1", Pinned: 2, PeakPinned: 2, Hits: 2, Misses: 1}
+	if got, ok := bp.OwnerStats("q1"); !ok || got != want {
+		t.Errorf("OwnerStats(q1) = %+v, want %+v", got, want)
+	}
+
+	if err := bp.UnpinPageOwned("q2", 1, false); !errors.Is(err, ErrPageNotPinned) {
+		t.Errorf("unpin of a page pinned by another owner: %v", err)
+	}
+	for _, pageID := range []PageID{2, 2, 1} {
+		if err := bp.UnpinPageOwned("q1", pageID, pageID == 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = OwnerStats{Owner: "q1", PeakPinned: 2, Hits: 2, Misses: 1, Dirtied: 2}
+	if got := bp.ReleaseOwner("q1"); got != want {
+		t.Errorf("ReleaseOwner(q1) = %+v, want %+v", got, want)
+	}
+	if all := bp.AllOwnerStats(); len(all) != 1 || all[0].Owner != "q2" || all[0].Pinned != 1 {
+		t.Errorf("AllOwnerStats() = %+v", all)
+	}
+	if stats := bp.Stats(); stats.PinnedFrames != 1 || stats.DirtyFrames != 1 {
+		t.Errorf("pool has %d pinned, %d dirty frames; want 1 and 1", stats.PinnedFrames, stats.DirtyFrames)
+	}
+}
+
+func TestOwnerStatsSized(t *testing.T) {
+	bp := New(NewMockDiskManager(), Options{FlushInterval: -1, PageClasses: []PageClass{
+		{PageSize: 4096, Frames: 2},
+		{PageSize: 16384, Frames: 2},
+	}})
+	defer bp.Close()
+
+	frame, err := bp.FetchPageOwnedSized("q", 7, 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frame.Data()) != 16384 {
+		t.Errorf("frame holds %d bytes, want 16384", len(frame.Data()))
+	}
+	if _, err := bp.FetchPageOwnedSized("q", 8, 1000); !errors.Is(err, ErrNoPageClass) {
+		t.Errorf("fetch with an unknown page size = %v, want ErrNoPageClass", err)
+	}
+	if got, _ := bp.OwnerStats("q"); got.Pinned != 1 || got.Misses != 1 {
+		t.Errorf("OwnerStats(q) = %+v, want 1 pin and 1 miss", got)
+	}
+	if err := bp.UnpinPageOwned("q", 7, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := bp.OwnerStats("q"); got.Pinned != 0 || got.Dirtied != 1 {
+		t.Errorf("OwnerStats(q) after unpin = %+v", got)
+	}
+}
+
 func BenchmarkFetchPage(b *testing.B) {
 	// TODO: Benchmark cached page fetch
 	dm := NewMockDiskManager()
-	bp := New(dm, 100)
+	bp := New(dm, Options{PoolSize: 100})
 	defer bp.Close()
 
 	b.Skip("not implemented")
@@ -0,0 +1,157 @@
+package bufferpool
+
+import (
+	"sort"
+	"sync"
+)
+
+// OwnerStats is the buffer pool usage attributed to one owner, such as a
+// query, through FetchPageOwned and UnpinPageOwned
+type OwnerStats struct {
+	Owner string
+	// Pinned is the number of distinct pages the owner has pinned now, and
+	// PeakPinned the most it had pinned at once
+	Pinned     int
+	PeakPinned int
+	// Hits and Misses count the owner's fetches that found the page
+	// resident and that had to read it (or wait for a read in flight)
+	Hits   int64
+	Misses int64
+	// Dirtied counts the owner's unpins that marked a page dirty
+	Dirtied int64
+}
+
+// ownerUsage is the running account of one owner
+type ownerUsage struct {
+	stats OwnerStats
+	pins  map[PageID]int
+}
+
+// owners tracks usage by owner. It has its own lock, which is never held
+// while calling into the pool, so accounting never holds up the pool latch.
+type owners struct {
+	mu    sync.Mutex
+	usage map[string]*ownerUsage
+}
+
+// get returns the account of owner, creating it. Caller holds o.mu.
+func (o *owners) get(owner string) *ownerUsage {
+	if o.usage == nil {
+		o.usage = make(map[string]*ownerUsage)
+	}
+	u, ok := o.usage[owner]
+	if !ok {
+		u = &ownerUsage{stats: OwnerStats{Owner: owner}, pins: make(map[PageID]int)}
+		o.usage[owner] = u
+	}
+	return u
+}
+
+// FetchPageOwned fetches a page like FetchPage and attributes the fetch
+// and the pin to owner. Release the pin with UnpinPageOwned under the same
+// owner.
+func (bp *BufferPool) FetchPageOwned(owner string, pageID PageID) (*Frame, error) {
+	return bp.fetchPageOwned(owner, pageID, 0)
+}
+
+// FetchPageOwnedSized is FetchPageOwned for a page held in frames of the
+// given size, like FetchPageSized
+func (bp *BufferPool) FetchPageOwnedSized(owner string, pageID PageID, pageSize int) (*Frame, error) {
+	class, err := bp.classFor(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return bp.fetchPageOwned(owner, pageID, class)
+}
+
+func (bp *BufferPool) fetchPageOwned(owner string, pageID PageID, class int) (*Frame, error) {
+	res := <-bp.fetchPageAsync(pageID, class, false)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+
+	bp.owners.mu.Lock()
+	defer bp.owners.mu.Unlock()
+	u := bp.owners.get(owner)
+	if res.hit {
+		u.stats.Hits++
+	} else {
+		u.stats.Misses++
+	}
+	u.pins[pageID]++
+	u.stats.Pinned = len(u.pins)
+	u.stats.PeakPinned = max(u.stats.PeakPinned, u.stats.Pinned)
+	return res.Frame, nil
+}
+
+// UnpinPageOwned releases a pin taken by FetchPageOwned for owner. It
+// returns ErrPageNotPinned, leaving the page pinned, if owner holds no pin
+// on it.
+func (bp *BufferPool) UnpinPageOwned(owner string, pageID PageID, dirty bool) error {
+	// The pin is taken out of the account first and the lock dropped, so
+	// the pool latch is not acquired under it; a failed unpin puts it back
+	bp.owners.mu.Lock()
+	u := bp.owners.get(owner)
+	if u.pins[pageID] == 0 {
+		bp.owners.mu.Unlock()
+		return ErrPageNotPinned
+	}
+	u.account(pageID, -1, dirty)
+	bp.owners.mu.Unlock()
+
+	if err := bp.UnpinPage(pageID, dirty); err != nil {
+		bp.owners.mu.Lock()
+		u.account(pageID, 1, dirty)
+		bp.owners.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// account adds delta pins on pageID, and counts an unpin that dirtied it
+// (or takes that back for a positive delta). Caller holds o.mu.
+func (u *ownerUsage) account(pageID PageID, delta int, dirty bool) {
+	if u.pins[pageID] += delta; u.pins[pageID] == 0 {
+		delete(u.pins, pageID)
+	}
+	u.stats.Pinned = len(u.pins)
+	if dirty {
+		u.stats.Dirtied -= int64(delta)
+	}
+}
+
+// OwnerStats returns the usage of owner so far
+func (bp *BufferPool) OwnerStats(owner string) (OwnerStats, bool) {
+	bp.owners.mu.Lock()
+	defer bp.owners.mu.Unlock()
+	u, ok := bp.owners.usage[owner]
+	if !ok {
+		return OwnerStats{Owner: owner}, false
+	}
+	return u.stats, true
+}
+
+// AllOwnerStats returns the usage of every owner, ordered by owner
+func (bp *BufferPool) AllOwnerStats() []OwnerStats {
+	bp.owners.mu.Lock()
+	defer bp.owners.mu.Unlock()
+	all := make([]OwnerStats, 0, len(bp.owners.usage))
+	for _, u := range bp.owners.usage {
+		all = append(all, u.stats)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Owner < all[j].Owner })
+	return all
+}
+
+// ReleaseOwner forgets owner, for example when its query finishes, and
+// returns its final usage. Pins it still holds stay in place.
+func (bp *BufferPool) ReleaseOwner(owner string) OwnerStats {
+	bp.owners.mu.Lock()
+	defer bp.owners.mu.Unlock()
+	u, ok := bp.owners.usage[owner]
+	if !ok {
+		return OwnerStats{Owner: owner}
+	}
+	delete(bp.owners.usage, owner)
+	return u.stats
+}
@@ -0,0 +1,58 @@
+package bufferpool
+
+import "errors"
+
+// Page class errors
+var (
+	ErrNoPageClass       = errors.New("no page class for requested page size")
+	ErrPageClassMismatch = errors.New("page is resident in a different page class")
+)
+
+// PageClass describes one frame size class of a BufferPool
+type PageClass struct {
+	PageSize int
+	Frames   int
+}
+
+// frameClass holds the frames of one size class. Each class has its own
+// free list and replacer, so large pages never evict small ones and vice
+// versa.
+type frameClass struct {
+	pageSize int
+	freeList []FrameID
+	replacer Replacer
+}
+
+// classFor returns the index of the class with exactly pageSize bytes
+func (bp *BufferPool) classFor(pageSize int) (int, error) {
+	for i, fc := range bp.classes {
+		if fc.pageSize == pageSize {
+			return i, nil
+		}
+	}
+	return -1, ErrNoPageClass
+}
+
+// FetchPageSized fetches a page held in frames of the given size. The disk
+// manager is asked to fill exactly pageSize bytes.
+func (bp *BufferPool) FetchPageSized(pageID PageID, pageSize int) (*Frame, error) {
+	class, err := bp.classFor(pageSize)
+	if err != nil {
+		return nil, err
+	}
+	return bp.fetchPage(pageID, class)
+}
+
+// NewPageSized allocates a new page in the class with the given size
+func (bp *BufferPool) NewPageSized(pageSize int) (PageID, *Frame, error) {
+	class, err := bp.classFor(pageSize)
+	if err != nil {
+		return -1, nil, err
+	}
+	return bp.newPage(class)
+}
+
+// PageClasses returns the configured size classes
+func (bp *BufferPool) PageClasses() []PageClass {
+	return append([]PageClass(nil), bp.opts.PageClasses...)
+}
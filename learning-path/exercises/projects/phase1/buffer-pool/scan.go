@@ -0,0 +1,54 @@
+package bufferpool
+
+// coldInserter is implemented by replacers that can make a frame evictable
+// without treating the access as recent
+type coldInserter interface {
+	RecordColdAccess(frameID FrameID)
+}
+
+// RecordColdAccess marks a frame as evictable at the cold end of the list,
+// making it the next victim
+func (r *LRUReplacer) RecordColdAccess(frameID FrameID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.frames[frameID]; ok {
+		r.lruList.MoveToBack(elem)
+		return
+	}
+	r.frames[frameID] = r.lruList.PushBack(frameID)
+}
+
+// RecordColdAccess marks a frame as evictable at the cold end of the list
+func (r *TinyLFUReplacer) RecordColdAccess(frameID FrameID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.frames[frameID]; ok {
+		r.lruList.MoveToBack(elem)
+		return
+	}
+	r.frames[frameID] = r.lruList.PushBack(frameID)
+}
+
+// FetchPageForScan fetches a page for a sequential scan. A page read in by
+// the scan is placed at the cold end of the replacer when unpinned, so a
+// large scan recycles its own frames instead of evicting the working set,
+// and the access does not raise the page's temperature. A page that was
+// already resident keeps its position. Any regular fetch of a
+// scan-loaded page before it is evicted promotes it to a normal page.
+func (bp *BufferPool) FetchPageForScan(pageID PageID) (*Frame, error) {
+	res := <-bp.fetchPageAsync(pageID, 0, true)
+	return res.Frame, res.Err
+}
+
+// recordUnpinnedLocked hands a frame whose last pin was released to its
+// replacer. Caller holds bp.mu.
+func (bp *BufferPool) recordUnpinnedLocked(frame *Frame) {
+	replacer := bp.replacerFor(frame.frameID)
+	if ci, ok := replacer.(coldInserter); ok && frame.scan {
+		ci.RecordColdAccess(frame.frameID)
+		return
+	}
+	replacer.RecordAccess(frame.frameID)
+}
@@ -0,0 +1,190 @@
+package bufferpool
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// State file magics. Version 2 added page temperatures; version 1 files
+// still load, with every page cold.
+const (
+	stateMagicV1 = "BPS1"
+	stateMagic   = "BPS2"
+)
+
+// ErrBadStateFile is returned by WarmUp for a file not written by SaveState
+var ErrBadStateFile = errors.New("not a buffer pool state file")
+
+// stateEntry is one resident page recorded by SaveState
+type stateEntry struct {
+	PageID      PageID
+	Class       uint32
+	Temperature uint32
+}
+
+// stateEntryV1 is an entry of a version 1 file
+type stateEntryV1 struct {
+	PageID PageID
+	Class  uint32
+}
+
+// temperatureRestorer is implemented by replacers that can take back the
+// temperature a page had before a restart
+type temperatureRestorer interface {
+	Warm(frameID FrameID, pageID PageID, temperature uint32)
+}
+
+// SaveState writes the IDs and temperatures of all resident pages to path
+// in DebugFrames order, hottest first when the replacer tracks temperature,
+// so a restarted pool can warm itself with WarmUp. Page contents are not
+// saved; they are read from disk again on load. The file is replaced
+// atomically.
+func (bp *BufferPool) SaveState(path string) error {
+	bp.mu.RLock()
+	entries := make([]stateEntry, 0, len(bp.pageTable))
+	for _, info := range bp.debugFramesLocked() {
+		entries = append(entries, stateEntry{
+			PageID:      info.PageID,
+			Class:       uint32(bp.frames[info.FrameID].class),
+			Temperature: info.Temperature,
+		})
+	}
+	bp.mu.RUnlock()
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	w := bufio.NewWriter(f)
+	w.WriteString(stateMagic)
+	binary.Write(w, binary.LittleEndian, uint32(len(entries)))
+	binary.Write(w, binary.LittleEndian, entries)
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// WarmUp prefetches the pages recorded by SaveState and returns how many
+// it loaded, so a restarted pool does not begin with a cold cache. Each
+// size class is filled up to its capacity, hottest pages first; the reads
+// are issued concurrently. Pages are left unpinned and clean, and recorded
+// with the replacer so the hottest are evicted last. A TinyLFUReplacer also
+// gets back their saved temperatures. Pages belonging to a size class this
+// pool does not have are ignored. The reads are one batch with a disk
+// manager implementing AsyncPageIO.
+//
+// A page that fails to load is skipped and its error joined into the one
+// returned; the other pages are still loaded.
+func (bp *BufferPool) WarmUp(path string) (int, error) {
+	entries, err := readState(path)
+	if err != nil {
+		return 0, err
+	}
+
+	budget := make([]int, len(bp.opts.PageClasses))
+	for i, pc := range bp.opts.PageClasses {
+		budget[i] = pc.Frames
+	}
+
+	var fetches []pageFetch
+	var prefetched []stateEntry
+	for _, e := range entries {
+		class := int(e.Class)
+		if class >= len(budget) || budget[class] == 0 {
+			continue
+		}
+		budget[class]--
+		fetches = append(fetches, pageFetch{e.PageID, class})
+		prefetched = append(prefetched, e)
+	}
+	results := bp.fetchPagesAsync(fetches, false)
+
+	// Unpin coldest first so the replacer treats the hottest as most recent
+	var errs []error
+	loaded := 0
+	for i := len(results) - 1; i >= 0; i-- {
+		p := prefetched[i]
+		res := <-results[i]
+		if res.Err != nil {
+			errs = append(errs, fmt.Errorf("prefetch page %d: %w", p.PageID, res.Err))
+			continue
+		}
+		if r, ok := bp.replacerFor(res.Frame.frameID).(temperatureRestorer); ok && p.Temperature > 0 {
+			r.Warm(res.Frame.frameID, p.PageID, p.Temperature)
+		}
+		if err := bp.UnpinPage(p.PageID, false); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		loaded++
+	}
+	return loaded, errors.Join(errs...)
+}
+
+// LoadState is WarmUp without the count of pages loaded.
+//
+// Deprecated: use WarmUp.
+func (bp *BufferPool) LoadState(path string) error {
+	_, err := bp.WarmUp(path)
+	return err
+}
+
+// readState decodes a file written by SaveState
+func readState(path string) ([]stateEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(stateMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || (string(magic) != stateMagic && string(magic) != stateMagicV1) {
+		return nil, ErrBadStateFile
+	}
+	v1 := string(magic) == stateMagicV1
+	var n uint32
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, ErrBadStateFile
+	}
+	// Reject a corrupt count before allocating for it
+	size := binary.Size(stateEntry{})
+	if v1 {
+		size = binary.Size(stateEntryV1{})
+	}
+	if info, err := f.Stat(); err != nil || int64(n)*int64(size) > info.Size() {
+		return nil, ErrBadStateFile
+	}
+
+	entries := make([]stateEntry, n)
+	if !v1 {
+		if err := binary.Read(r, binary.LittleEndian, entries); err != nil {
+			return nil, ErrBadStateFile
+		}
+		return entries, nil
+	}
+	old := make([]stateEntryV1, n)
+	if err := binary.Read(r, binary.LittleEndian, old); err != nil {
+		return nil, ErrBadStateFile
+	}
+	for i, e := range old {
+		entries[i] = stateEntry{PageID: e.PageID, Class: e.Class}
+	}
+	return entries, nil
+}
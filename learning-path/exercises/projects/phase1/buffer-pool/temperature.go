@@ -0,0 +1,294 @@
+package bufferpool
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+const (
+	// victimSample is how many frames from the cold end of the recency
+	// list are compared by temperature when choosing a victim
+	victimSample = 8
+
+	// sketchDepth is the number of hash rows in the frequency sketch
+	sketchDepth = 4
+
+	// maxCounter saturates 8-bit counters so decay stays meaningful
+	maxCounter = 255
+)
+
+// frequencySketch is a count-min sketch of page access frequencies. It
+// remembers pages that are no longer resident, which is what lets the
+// replacer tell a returning hot page from a one-off scan page.
+type frequencySketch struct {
+	rows [sketchDepth][]uint8
+	mask uint64
+}
+
+func newFrequencySketch(capacity int) *frequencySketch {
+	width := 16
+	for width < capacity*8 {
+		width <<= 1
+	}
+	s := &frequencySketch{mask: uint64(width - 1)}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *frequencySketch) index(pageID PageID, row int) uint64 {
+	// splitmix64 finalizer with a per-row seed
+	h := uint64(pageID) + uint64(row+1)*0x9e3779b97f4a7c15
+	h = (h ^ (h >> 30)) * 0xbf58476d1ce4e5b9
+	h = (h ^ (h >> 27)) * 0x94d049bb133111eb
+	h ^= h >> 31
+	return h & s.mask
+}
+
+func (s *frequencySketch) increment(pageID PageID) {
+	for i := range s.rows {
+		idx := s.index(pageID, i)
+		if s.rows[i][idx] < maxCounter {
+			s.rows[i][idx]++
+		}
+	}
+}
+
+// raise lifts the page's counters to at least c
+func (s *frequencySketch) raise(pageID PageID, c uint8) {
+	for i := range s.rows {
+		idx := s.index(pageID, i)
+		s.rows[i][idx] = max(s.rows[i][idx], c)
+	}
+}
+
+func (s *frequencySketch) estimate(pageID PageID) uint32 {
+	est := uint32(maxCounter)
+	for i := range s.rows {
+		if c := uint32(s.rows[i][s.index(pageID, i)]); c < est {
+			est = c
+		}
+	}
+	return est
+}
+
+// halve applies one step of exponential decay to every counter
+func (s *frequencySketch) halve() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+}
+
+// TinyLFUReplacer combines recency and access frequency for eviction.
+//
+// Every access bumps the frame's temperature and the page's entry in a
+// frequency sketch. After decayPeriod accesses all counts are halved, so
+// temperature reflects recent popularity rather than lifetime totals.
+// Victims are chosen among the least recently used frames, preferring the
+// coldest; newly unpinned frames that are colder than the current eviction
+// candidate are admitted at the cold end so that one-off scans evict each
+// other instead of the working set.
+type TinyLFUReplacer struct {
+	capacity    int
+	frames      map[FrameID]*list.Element
+	lruList     *list.List
+	temperature map[FrameID]uint32
+	sketch      *frequencySketch
+	accesses    int
+	decayPeriod int
+	mu          sync.Mutex
+}
+
+// NewTinyLFUReplacer creates a new temperature-aware replacer
+func NewTinyLFUReplacer(capacity int) *TinyLFUReplacer {
+	return &TinyLFUReplacer{
+		capacity:    capacity,
+		frames:      make(map[FrameID]*list.Element),
+		lruList:     list.New(),
+		temperature: make(map[FrameID]uint32),
+		sketch:      newFrequencySketch(capacity),
+		decayPeriod: max(capacity*10, 64),
+	}
+}
+
+// Touch records an access to pageID held in frameID
+func (r *TinyLFUReplacer) Touch(frameID FrameID, pageID PageID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sketch.increment(pageID)
+	// A frame that was just loaded inherits the page's remembered frequency
+	r.temperature[frameID] = max(r.temperature[frameID]+1, r.sketch.estimate(pageID))
+
+	r.accesses++
+	if r.accesses >= r.decayPeriod {
+		r.decayLocked()
+	}
+}
+
+// Warm restores the temperature a page had before a restart, for a frame
+// it was just loaded into. The page's sketch counters are raised to match,
+// so the temperature also survives the page being evicted and reloaded.
+func (r *TinyLFUReplacer) Warm(frameID FrameID, pageID PageID, temperature uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := uint8(min(temperature, maxCounter))
+	r.sketch.raise(pageID, t)
+	r.temperature[frameID] = max(r.temperature[frameID], uint32(t))
+}
+
+func (r *TinyLFUReplacer) decayLocked() {
+	r.accesses = 0
+	r.sketch.halve()
+	for frameID, t := range r.temperature {
+		r.temperature[frameID] = t >> 1
+	}
+}
+
+// RecordAccess marks a frame as evictable
+func (r *TinyLFUReplacer) RecordAccess(frameID FrameID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.frames[frameID]; ok {
+		r.lruList.Remove(elem)
+	}
+
+	// Admission: a frame colder than the next victim goes to the cold end
+	if back := r.lruList.Back(); back != nil &&
+		r.temperature[frameID] < r.temperature[back.Value.(FrameID)] {
+		r.frames[frameID] = r.lruList.PushBack(frameID)
+		return
+	}
+	r.frames[frameID] = r.lruList.PushFront(frameID)
+}
+
+// Victim returns the coldest of the least recently used frames
+func (r *TinyLFUReplacer) Victim() (FrameID, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var victim *list.Element
+	elem := r.lruList.Back()
+	for i := 0; i < victimSample && elem != nil; i++ {
+		frameID := elem.Value.(FrameID)
+		if victim == nil || r.temperature[frameID] < r.temperature[victim.Value.(FrameID)] {
+			victim = elem
+		}
+		elem = elem.Prev()
+	}
+	if victim == nil {
+		return -1, false
+	}
+
+	frameID := victim.Value.(FrameID)
+	r.lruList.Remove(victim)
+	delete(r.frames, frameID)
+	delete(r.temperature, frameID)
+	return frameID, true
+}
+
+// Pin makes a frame unevictable while it is in use. Its temperature is
+// kept, since the frame still holds the same page.
+func (r *TinyLFUReplacer) Pin(frameID FrameID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.frames[frameID]; ok {
+		r.lruList.Remove(elem)
+		delete(r.frames, frameID)
+	}
+}
+
+// Remove forgets a frame whose page was deleted, so the next page loaded
+// into it starts cold
+func (r *TinyLFUReplacer) Remove(frameID FrameID) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.frames[frameID]; ok {
+		r.lruList.Remove(elem)
+		delete(r.frames, frameID)
+	}
+	delete(r.temperature, frameID)
+}
+
+// Size returns the number of evictable frames
+func (r *TinyLFUReplacer) Size() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lruList.Len()
+}
+
+// Temperature returns the decayed access count of a frame
+func (r *TinyLFUReplacer) Temperature(frameID FrameID) uint32 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.temperature[frameID]
+}
+
+// FrameInfo describes one frame for debugging
+type FrameInfo struct {
+	FrameID     FrameID
+	PageID      PageID
+	PinCount    int32
+	Dirty       bool
+	Temperature uint32
+}
+
+// DebugFrames returns a snapshot of all occupied frames, hottest first.
+// Temperature is zero unless the pool uses a TinyLFUReplacer.
+func (bp *BufferPool) DebugFrames() []FrameInfo {
+	bp.mu.RLock()
+	defer bp.mu.RUnlock()
+	return bp.debugFramesLocked()
+}
+
+// debugFramesLocked implements DebugFrames. Caller holds bp.mu.
+func (bp *BufferPool) debugFramesLocked() []FrameInfo {
+	infos := make([]FrameInfo, 0, len(bp.pageTable))
+	for _, frame := range bp.frames {
+		if frame.pageID < 0 {
+			continue
+		}
+		info := FrameInfo{
+			FrameID:  frame.frameID,
+			PageID:   frame.pageID,
+			PinCount: frame.pinCount.Load(),
+			Dirty:    frame.IsDirty(),
+		}
+		if tr, ok := bp.replacerFor(frame.frameID).(*TinyLFUReplacer); ok {
+			info.Temperature = tr.Temperature(frame.frameID)
+		}
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Temperature != infos[j].Temperature {
+			return infos[i].Temperature > infos[j].Temperature
+		}
+		return infos[i].FrameID < infos[j].FrameID
+	})
+	return infos
+}
+
+// DumpFrames writes a human-readable table of DebugFrames to w
+func (bp *BufferPool) DumpFrames(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "%-8s %-10s %-5s %-5s %s\n", "FRAME", "PAGE", "PINS", "DIRTY", "TEMP"); err != nil {
+		return err
+	}
+	for _, info := range bp.DebugFrames() {
+		if _, err := fmt.Fprintf(w, "%-8d %-10d %-5d %-5t %d\n",
+			info.FrameID, info.PageID, info.PinCount, info.Dirty, info.Temperature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package bufferpool
+
+import "sort"
+
+// PageWriter is an optional DiskManager extension for vectored writes.
+// WritePages writes len(pages) consecutive pages starting at startPageID in
+// a single call, like pwritev over a contiguous file range. All pages in
+// one call belong to the same size class.
+type PageWriter interface {
+	WritePages(startPageID PageID, pages [][]byte) error
+}
+
+// PageSyncer is an optional DiskManager extension that makes written pages
+// durable, like fsync. Checkpoint syncs before logging the checkpoint.
+type PageSyncer interface {
+	Sync() error
+}
+
+// maxBatchPages bounds how many pages are coalesced into one write
+const maxBatchPages = 64
+
+// flushBatched writes every dirty frame back to disk: all of them as one
+// batch of asynchronous writes if the disk manager implements AsyncPageIO,
+// or else coalescing runs of adjacent page IDs into single WritePages
+// calls when it supports them. Caller holds bp.mu (shared or exclusive).
+func (bp *BufferPool) flushBatched() error {
+	dirty := make([]*Frame, 0, len(bp.frames))
+	for _, frame := range bp.frames {
+		if frame.pageID >= 0 && frame.IsDirty() {
+			dirty = append(dirty, frame)
+		}
+	}
+	if len(dirty) == 0 {
+		return nil
+	}
+
+	if aio, ok := bp.diskManager.(AsyncPageIO); ok {
+		sort.Slice(dirty, func(i, j int) bool { return dirty[i].pageID < dirty[j].pageID })
+		return bp.writeAsync(aio, dirty)
+	}
+	pw, ok := bp.diskManager.(PageWriter)
+	if !ok {
+		var firstErr error
+		for _, frame := range dirty {
+			if err := bp.flushFrame(frame); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	sort.Slice(dirty, func(i, j int) bool { return dirty[i].pageID < dirty[j].pageID })
+
+	var firstErr error
+	for start := 0; start < len(dirty); {
+		end := start + 1
+		for end < len(dirty) && end-start < maxBatchPages &&
+			dirty[end].pageID == dirty[end-1].pageID+1 &&
+			dirty[end].class == dirty[start].class {
+			end++
+		}
+		if err := bp.writeRun(pw, dirty[start:end]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		start = end
+	}
+	return firstErr
+}
+
+// writeRun writes a run of frames holding consecutive page IDs
+func (bp *BufferPool) writeRun(pw PageWriter, run []*Frame) error {
+	if len(run) == 1 {
+		return bp.flushFrame(run[0])
+	}
+	return bp.writeFrames(run, func(pages [][]byte) error {
+		return pw.WritePages(run[0].pageID, pages)
+	})
+}
+
+// writeAsync writes dirty frames as one batch of AsyncPageIO writes
+func (bp *BufferPool) writeAsync(aio AsyncPageIO, frames []*Frame) error {
+	pageIDs := make([]PageID, len(frames))
+	for i, frame := range frames {
+		pageIDs[i] = frame.pageID
+	}
+	return bp.writeFrames(frames, func(pages [][]byte) error {
+		return <-aio.WritePagesAsync(pageIDs, pages)
+	})
+}
+
+// writeFrames flushes the log through the frames' LSNs, clears their dirty
+// flags and writes their data with write, holding their latches for
+// reading until it returns. If it fails, the frames are dirty again.
+func (bp *BufferPool) writeFrames(run []*Frame, write func(pages [][]byte) error) error {
+	var maxLSN LSN
+	for _, frame := range run {
+		maxLSN = max(maxLSN, frame.LSN())
+	}
+	if err := bp.logFlushed(maxLSN); err != nil {
+		return err
+	}
+
+	pages := make([][]byte, len(run))
+	recLSNs := make([]LSN, len(run))
+	for i, frame := range run {
+		recLSNs[i] = frame.takeRecLSN()
+		frame.dirty.Store(false)
+		frame.mu.RLock()
+		pages[i] = frame.data[:]
+	}
+	err := write(pages)
+	for i, frame := range run {
+		frame.mu.RUnlock()
+		if err != nil {
+			frame.dirty.Store(true)
+			frame.restoreRecLSN(recLSNs[i])
+		}
+	}
+	return err
+}
@@ -0,0 +1,193 @@
+package pagemanager
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// defaultIODepth is the default of Options.IODepth
+const defaultIODepth = 32
+
+// PageResult is the outcome of one page of ReadPagesAsync
+type PageResult struct {
+	Page *Page
+	Err  error
+}
+
+// diskBackend returns the backend of the asynchronous calls, creating it
+// on first use, or nil with Options.Mmap
+func (pm *PageManager) diskBackend() (DiskBackend, error) {
+	if pm.opts.Mmap {
+		return nil, nil
+	}
+	pm.backendOnce.Do(func() {
+		pm.backend, pm.backendErr = newDiskBackend(pm.file, pm.opts)
+	})
+	return pm.backend, pm.backendErr
+}
+
+// ReadPagesAsync reads pages as a batch and returns at once. The channel
+// receives a result per page, in order, once all of them are read. Pages
+// in the cache are not read again; the others are submitted to the disk
+// backend together, so with io_uring they take one system call. Pages
+// read are added to the cache unless they were written meanwhile. Like
+// ReadPage, each result is a private copy.
+func (pm *PageManager) ReadPagesAsync(ids []PageID) <-chan []PageResult {
+	results := make([]PageResult, len(ids))
+	out := make(chan []PageResult, 1)
+	type miss struct {
+		i       int
+		version uint64
+	}
+	var reqs []*IORequest
+	var misses []miss
+
+	start := pm.traceStart()
+	pm.mu.RLock()
+	backend, err := pm.diskBackend()
+	if err != nil {
+		pm.mu.RUnlock()
+		for i := range results {
+			results[i].Err = err
+		}
+		out <- results
+		return out
+	}
+	for i, id := range ids {
+		if err := pm.checkAllocatedLocked(id); err != nil {
+			results[i].Err = fmt.Errorf("page %d: %w", id, err)
+			continue
+		}
+		if backend == nil {
+			// The mapping needs no I/O
+			page, err := pm.currentPageLocked(id)
+			if err != nil {
+				results[i].Err = err
+			} else {
+				results[i].Page = page.clone()
+			}
+			continue
+		}
+		if page, ok := pm.cache.Get(id); ok {
+			results[i].Page = page.clone()
+			continue
+		}
+		pm.counters.diskReads.Add(1)
+		reqs = append(reqs, &IORequest{Offset: pm.pageOffset(id), Buf: pm.pageBuffer()})
+		misses = append(misses, miss{i, pm.versions[id]})
+	}
+	if len(reqs) == 0 {
+		pm.mu.RUnlock()
+		out <- results
+		return out
+	}
+	pm.asyncIO.Add(1)
+	pm.mu.RUnlock()
+
+	done := backend.Submit(reqs)
+	go func() {
+		<-done
+		pm.asyncIO.Done()
+		pm.mu.RLock()
+		defer pm.mu.RUnlock()
+		for j, m := range misses {
+			id, req := ids[m.i], reqs[j]
+			pm.trace(OpDiskRead, id, start)
+			if req.Err != nil && req.Err != io.EOF {
+				results[m.i].Err = fmt.Errorf("page %d: %w", id, req.Err)
+				continue
+			}
+			page, err := pm.decodePage(id, req.Buf)
+			if err != nil {
+				results[m.i].Err = err
+				continue
+			}
+			// The page may have been written, or freed, since it was read
+			if pm.versions[id] == m.version && pm.freeBitmap.Test(int(id)) {
+				if _, cached := pm.cache.peek(id); !cached {
+					pm.cache.Put(page)
+				}
+			}
+			results[m.i].Page = page.clone()
+		}
+		out <- results
+	}()
+	return out
+}
+
+// WritePagesAsync writes pages to the file as a batch and returns at once.
+// The channel receives nil, or the errors joined, once every write has
+// completed. Each page becomes the latest image at once, as with
+// WritePage, so reads see it while its write is in flight; its cached copy
+// is marked clean when the write lands, unless it was written again
+// meanwhile. The writes are not synced: Flush makes them durable.
+//
+// The pages are checked and installed in order, and the first that fails
+// ends the batch with its error, before any write is submitted.
+func (pm *PageManager) WritePagesAsync(pages []*Page) <-chan error {
+	out := make(chan error, 1)
+	start := pm.traceStart()
+	pm.mu.Lock()
+	backend, err := pm.diskBackend()
+	for _, page := range pages {
+		if err != nil {
+			break
+		}
+		if err = pm.checkAllocatedLocked(page.ID); err != nil {
+			err = fmt.Errorf("page %d: %w", page.ID, err)
+		} else {
+			err = pm.putLocked(page)
+		}
+	}
+	if err != nil || backend == nil || len(pages) == 0 {
+		// With the mapping, putLocked has written the pages already
+		pm.mu.Unlock()
+		out <- err
+		return out
+	}
+
+	// Only the last image of a page listed twice is written, as writes in
+	// flight together may land in any order
+	last := make(map[PageID]int, len(pages))
+	for i, page := range pages {
+		last[page.ID] = i
+	}
+	var reqs []*IORequest
+	var written, installed []*Page
+	for i, page := range pages {
+		if last[page.ID] != i {
+			continue
+		}
+		buf := pm.pageBuffer()
+		page.marshalTo(buf)
+		reqs = append(reqs, &IORequest{Write: true, Offset: pm.pageOffset(page.ID), Buf: buf})
+		cached, _ := pm.cache.peek(page.ID)
+		written, installed = append(written, page), append(installed, cached)
+	}
+	pm.counters.diskWrites.Add(uint64(len(reqs)))
+	pm.asyncIO.Add(1)
+	pm.mu.Unlock()
+
+	done := backend.Submit(reqs)
+	go func() {
+		<-done
+		pm.asyncIO.Done()
+		var errs []error
+		clean := make([]*Page, 0, len(reqs))
+		pm.mu.Lock()
+		for i, req := range reqs {
+			pm.trace(OpDiskWrite, written[i].ID, start)
+			if req.Err != nil {
+				errs = append(errs, fmt.Errorf("page %d: %w", written[i].ID, req.Err))
+			} else if installed[i] != nil {
+				clean = append(clean, installed[i])
+			}
+		}
+		// MarkClean skips pages no longer cached as they were
+		pm.cache.MarkClean(clean)
+		pm.mu.Unlock()
+		out <- errors.Join(errs...)
+	}()
+	return out
+}
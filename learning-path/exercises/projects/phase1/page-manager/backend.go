@@ -0,0 +1,182 @@
+package pagemanager
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Errors
+var (
+	ErrIOUringUnsupported = errors.New("io_uring not supported on this platform")
+	ErrBackendClosed      = errors.New("disk backend closed")
+)
+
+// IORequest is one read or write of a DiskBackend batch: Buf is read from,
+// or written to, the file at Offset
+type IORequest struct {
+	Write  bool
+	Offset int64
+	Buf    []byte
+	// N and Err are the bytes transferred and the error, once the request
+	// has completed. Like ReadAt, a read cut short by the end of the file
+	// fails with io.EOF.
+	N   int
+	Err error
+}
+
+// DiskBackend performs the file I/O of ReadPagesAsync and WritePagesAsync,
+// a batch of requests at a time
+type DiskBackend interface {
+	// Submit starts reqs and returns a channel closed once every one has
+	// completed. It blocks only while the backend is full. The buffers
+	// must not be touched until then.
+	Submit(reqs []*IORequest) <-chan struct{}
+	// Name is the name of the implementation: "io_uring" or "pool"
+	Name() string
+	// Close waits for the requests in flight and releases the backend.
+	// The file stays open.
+	Close() error
+}
+
+// BackendKind selects the DiskBackend of a PageManager
+type BackendKind int
+
+const (
+	// BackendAuto uses io_uring where the kernel allows it, and a
+	// goroutine pool otherwise
+	BackendAuto BackendKind = iota
+	// BackendPool runs the requests on a pool of goroutines doing pread
+	// and pwrite
+	BackendPool
+	// BackendIOUring submits each batch to an io_uring; Open fails with
+	// ErrIOUringUnsupported where there is none
+	BackendIOUring
+)
+
+func (k BackendKind) String() string {
+	switch k {
+	case BackendAuto:
+		return "auto"
+	case BackendPool:
+		return "pool"
+	case BackendIOUring:
+		return "io_uring"
+	}
+	return fmt.Sprintf("BackendKind(%d)", int(k))
+}
+
+// WithBackend selects the disk backend (Options.Backend)
+func WithBackend(kind BackendKind) Option {
+	return func(o *Options) { o.Backend = kind }
+}
+
+// newDiskBackend creates the backend opts select for file
+func newDiskBackend(file *os.File, opts Options) (DiskBackend, error) {
+	switch opts.Backend {
+	case BackendPool:
+		return NewPoolBackend(file, opts.IODepth), nil
+	case BackendIOUring:
+		return NewIOUringBackend(file, opts.IODepth)
+	}
+	if b, err := NewIOUringBackend(file, opts.IODepth); err == nil {
+		return b, nil
+	}
+	return NewPoolBackend(file, opts.IODepth), nil
+}
+
+// ioBatch tracks the requests of a batch still in flight
+type ioBatch struct {
+	pending atomic.Int64
+	done    chan struct{}
+}
+
+func newIOBatch(n int) *ioBatch {
+	b := &ioBatch{done: make(chan struct{})}
+	b.pending.Store(int64(n))
+	if n == 0 {
+		close(b.done)
+	}
+	return b
+}
+
+// complete marks a request of the batch completed
+func (b *ioBatch) complete() {
+	if b.pending.Add(-1) == 0 {
+		close(b.done)
+	}
+}
+
+// poolBackend is the portable DiskBackend: a fixed pool of goroutines
+// taking requests off a queue
+type poolBackend struct {
+	file   *os.File
+	jobs   chan poolJob
+	wg     sync.WaitGroup
+	mu     sync.RWMutex // held for reading while submitting
+	closed bool
+}
+
+type poolJob struct {
+	req   *IORequest
+	batch *ioBatch
+}
+
+// NewPoolBackend returns a DiskBackend doing the I/O of file on workers
+// goroutines (default 32)
+func NewPoolBackend(file *os.File, workers int) DiskBackend {
+	if workers <= 0 {
+		workers = defaultIODepth
+	}
+	b := &poolBackend{file: file, jobs: make(chan poolJob, workers)}
+	b.wg.Add(workers)
+	for range workers {
+		go b.work()
+	}
+	return b
+}
+
+func (b *poolBackend) Name() string {
+	return "pool"
+}
+
+func (b *poolBackend) Submit(reqs []*IORequest) <-chan struct{} {
+	batch := newIOBatch(len(reqs))
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, req := range reqs {
+		if b.closed {
+			req.Err = ErrBackendClosed
+			batch.complete()
+			continue
+		}
+		b.jobs <- poolJob{req: req, batch: batch}
+	}
+	return batch.done
+}
+
+func (b *poolBackend) work() {
+	defer b.wg.Done()
+	for job := range b.jobs {
+		req := job.req
+		if req.Write {
+			req.N, req.Err = b.file.WriteAt(req.Buf, req.Offset)
+		} else {
+			req.N, req.Err = b.file.ReadAt(req.Buf, req.Offset)
+		}
+		job.batch.complete()
+	}
+}
+
+func (b *poolBackend) Close() error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.jobs)
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+	return nil
+}
@@ -0,0 +1,56 @@
+package pagemanager
+
+import "os"
+
+// Backup writes the pages as of the snapshot to a new page file at path,
+// which must not exist yet. Writers carry on meanwhile: each page is read
+// through the snapshot under a short read lock, and pages they change are
+// served from the snapshot's copy-on-write images. The copy keeps every
+// page ID, and pages free in the snapshot are free in the copy.
+func (s *Snapshot) Backup(path string) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	f.Close()
+	dst, err := Open(path, Options{CacheSize: 1})
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	defer func() {
+		if closeErr := dst.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	// The copy is private, so it stays locked throughout and its pages are
+	// written straight to its file
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+	var free []PageID
+	for id := PageID(0); id < s.nextPageID; id++ {
+		if _, err := dst.allocateLocked(); err != nil {
+			return err
+		}
+		if !s.visible(id) {
+			free = append(free, id)
+			continue
+		}
+		page, err := s.ReadPage(id)
+		if err != nil {
+			return err
+		}
+		if err := dst.writePageToDisk(page); err != nil {
+			return err
+		}
+	}
+	// Freed only now, so the allocations above assign IDs in order
+	for _, id := range free {
+		dst.freeBitmap.Clear(int(id))
+	}
+	return dst.flushLocked()
+}
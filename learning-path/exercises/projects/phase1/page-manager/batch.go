@@ -0,0 +1,82 @@
+package pagemanager
+
+import "fmt"
+
+// AllocatePages allocates n pages under one hold of the lock, preferring
+// consecutive IDs so the pages can later be read in one sequential pass.
+// It takes the first run of n free IDs, which may be a run of freed pages
+// or extend past the last allocated page. Freed pages that form no such
+// run are used only once the file has no room left for one. Either all n
+// pages are allocated or, with ErrFileFull, none are. The IDs are returned
+// in ascending order.
+func (pm *PageManager) AllocatePages(n int) ([]PageID, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	capacity := int(pm.meta.capacity())
+	ids := make([]PageID, 0, n)
+	if start := pm.freeBitmap.FindZeroRun(n, capacity); start >= 0 {
+		for id := start; id < start+n; id++ {
+			ids = append(ids, PageID(id))
+		}
+	} else {
+		// No room for a run: fill the holes, then the rest of the file
+		for id := pm.freeBitmap.NextZero(0); id < int(pm.nextPageID) && len(ids) < n; id = pm.freeBitmap.NextZero(id + 1) {
+			ids = append(ids, PageID(id))
+		}
+		for id := pm.nextPageID; len(ids) < n && int(id) < capacity; id++ {
+			ids = append(ids, id)
+		}
+		if len(ids) < n {
+			return nil, ErrFileFull
+		}
+	}
+
+	if high := ids[len(ids)-1] + 1; high > pm.nextPageID {
+		if err := pm.growLocked(high); err != nil {
+			return nil, err
+		}
+		if int(high) > pm.freeBitmap.Size() {
+			pm.freeBitmap.Resize(max(pm.freeBitmap.Size()*2, int(high)))
+		}
+		pm.nextPageID = high
+		pm.meta.headerDirty = true
+	}
+	for _, id := range ids {
+		pm.freeBitmap.Set(int(id))
+		pm.meta.markAllocation(id)
+	}
+	pm.counters.allocations.Add(uint64(len(ids)))
+	return ids, nil
+}
+
+// FreePages frees pages under one hold of the lock. The IDs are checked
+// first: if one is invalid, not allocated or listed twice, nothing is
+// freed.
+func (pm *PageManager) FreePages(ids []PageID) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	seen := make(map[PageID]bool, len(ids))
+	for _, id := range ids {
+		if err := pm.checkAllocatedLocked(id); err != nil {
+			return fmt.Errorf("page %d: %w", id, err)
+		}
+		if seen[id] {
+			return fmt.Errorf("page %d: listed twice: %w", id, ErrPageNotAllocated)
+		}
+		seen[id] = true
+	}
+	for _, id := range ids {
+		if err := pm.preserveLocked(id); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		pm.freeLocked(id)
+	}
+	return nil
+}
@@ -41,16 +41,60 @@ func (b *Bitmap) Test(n int) bool {
 
 // FindFirstZero finds the first 0 bit (free page)
 func (b *Bitmap) FindFirstZero() int {
-	// TODO: Implement efficient search
-	// Optimization: scan bytes first, then bits
-	for i := 0; i < b.size; i++ {
-		if !b.Test(i) {
-			return i
-		}
+	if n := b.NextZero(0); n < b.size {
+		return n
 	}
 	return -1 // No free pages
 }
 
+// NextZero returns the first 0 bit at or after from. Bits past Size count
+// as 0, so the result is at most max(from, Size). Full bytes are skipped
+// whole.
+func (b *Bitmap) NextZero(from int) int {
+	n := max(from, 0)
+	for n < b.size {
+		if n%8 == 0 && b.bits[n/8] == 0xFF {
+			n += 8
+			continue
+		}
+		if !b.Test(n) {
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+// NextOne returns the first 1 bit in [from, to), or -1. Empty bytes are
+// skipped whole.
+func (b *Bitmap) NextOne(from, to int) int {
+	n := max(from, 0)
+	for to = min(to, b.size); n < to; {
+		if n%8 == 0 && b.bits[n/8] == 0 {
+			n += 8
+			continue
+		}
+		if b.Test(n) {
+			return n
+		}
+		n++
+	}
+	return -1
+}
+
+// FindZeroRun returns the start of the first run of n 0 bits that ends at
+// or before limit, or -1. Bits past Size count as 0.
+func (b *Bitmap) FindZeroRun(n, limit int) int {
+	for start := b.NextZero(0); start+n <= limit; {
+		one := b.NextOne(start, start+n)
+		if one < 0 {
+			return start
+		}
+		start = b.NextZero(one + 1)
+	}
+	return -1
+}
+
 // CountOnes returns the number of 1 bits (allocated pages)
 func (b *Bitmap) CountOnes() int {
 	// TODO: Implement using bit manipulation tricks
@@ -65,8 +109,17 @@ func (b *Bitmap) CountOnes() int {
 
 // Resize grows or shrinks the bitmap
 func (b *Bitmap) Resize(newSize int) {
-	// TODO: Implement resize
-	// - Allocate new byte slice
-	// - Copy existing bits
-	// - Update size
+	bits := make([]byte, (newSize+7)/8)
+	copy(bits, b.bits)
+	// Clear bits beyond newSize in the last byte when shrinking
+	if rem := newSize % 8; rem != 0 && newSize < b.size {
+		bits[len(bits)-1] &= byte(1<<rem) - 1
+	}
+	b.bits = bits
+	b.size = newSize
+}
+
+// Size returns the number of bits in the bitmap
+func (b *Bitmap) Size() int {
+	return b.size
 }
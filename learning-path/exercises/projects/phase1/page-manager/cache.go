@@ -13,8 +13,13 @@ type LRUCache struct {
 	mu       sync.RWMutex
 	hits     uint64
 	misses   uint64
+	dirty    int // dirty pages cached
+	flush    FlushFunc
 }
 
+// FlushFunc writes a dirty page the cache is about to evict
+type FlushFunc func(*Page) error
+
 type cacheEntry struct {
 	pageID PageID
 	page   *Page
@@ -29,6 +34,15 @@ func NewLRUCache(capacity int) *LRUCache {
 	}
 }
 
+// SetFlushFunc sets the function Put writes dirty victims with. Without
+// one, dirty pages are never evicted and the cache grows past capacity
+// until they are marked clean.
+func (c *LRUCache) SetFlushFunc(flush FlushFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.flush = flush
+}
+
 // Get retrieves a page from cache
 func (c *LRUCache) Get(pageID PageID) (*Page, bool) {
 	c.mu.Lock()
@@ -50,39 +64,84 @@ func (c *LRUCache) Get(pageID PageID) (*Page, bool) {
 	return nil, false
 }
 
-// Put adds a page to cache
-func (c *LRUCache) Put(page *Page) {
+// peek returns a cached page without counting a hit or a miss, or moving
+// it in the LRU order
+func (c *LRUCache) peek(pageID PageID) (*Page, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if elem, ok := c.pages[pageID]; ok {
+		return elem.Value.(*cacheEntry).page, true
+	}
+	return nil, false
+}
+
+// Put adds a page to cache. When the cache is full it evicts the least
+// recently used clean page, or failing that writes the least recently used
+// dirty page with the flush function and evicts it. If the flush fails, the
+// page stays cached and dirty, and the error is returned; page is cached
+// either way.
+func (c *LRUCache) Put(page *Page) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// TODO: Implement cache put
-	// - Check if page already exists, update it
-	// - If at capacity, evict LRU page
-	// - Add new page to front
-	// - If evicted page is dirty, need to flush first
-
+	if page.Dirty {
+		c.dirty++
+	}
 	if elem, ok := c.pages[page.ID]; ok {
 		// Update existing
 		c.lru.MoveToFront(elem)
-		elem.Value.(*cacheEntry).page = page
-		return
+		entry := elem.Value.(*cacheEntry)
+		if entry.page.Dirty {
+			c.dirty--
+		}
+		entry.page = page
+		return nil
 	}
 
-	// Add new
-	if c.lru.Len() >= c.capacity {
-		// Evict LRU
-		oldest := c.lru.Back()
-		if oldest != nil {
-			entry := oldest.Value.(*cacheEntry)
-			delete(c.pages, entry.pageID)
-			c.lru.Remove(oldest)
-			// TODO: Flush if dirty
-		}
+	// Add new. Clean pages go first; without a flush function a dirty page
+	// stays until it is written back, so the cache can run over capacity
+	// while every page in it is dirty.
+	c.trimLocked(c.capacity - 1)
+	var err error
+	if c.flush != nil && c.lru.Len() >= c.capacity {
+		err = c.flushVictimsLocked(c.capacity - 1)
 	}
 
 	entry := &cacheEntry{pageID: page.ID, page: page}
 	elem := c.lru.PushFront(entry)
 	c.pages[page.ID] = elem
+	return err
+}
+
+// flushVictimsLocked writes and evicts the least recently used dirty pages
+// until at most size pages are cached. Only dirty pages are left when it is
+// called.
+func (c *LRUCache) flushVictimsLocked(size int) error {
+	for c.lru.Len() > size {
+		elem := c.lru.Back()
+		entry := elem.Value.(*cacheEntry)
+		if err := c.flush(entry.page); err != nil {
+			return err
+		}
+		entry.page.Dirty = false
+		c.dirty--
+		delete(c.pages, entry.pageID)
+		c.lru.Remove(elem)
+	}
+	return nil
+}
+
+// trimLocked evicts the least recently used clean pages until at most
+// size pages are cached or only dirty ones are left
+func (c *LRUCache) trimLocked(size int) {
+	for elem := c.lru.Back(); elem != nil && c.lru.Len() > size; {
+		prev := elem.Prev()
+		if entry := elem.Value.(*cacheEntry); !entry.page.Dirty {
+			delete(c.pages, entry.pageID)
+			c.lru.Remove(elem)
+		}
+		elem = prev
+	}
 }
 
 // Remove removes a page from cache
@@ -92,22 +151,30 @@ func (c *LRUCache) Remove(pageID PageID) {
 
 	// TODO: Implement removal
 	if elem, ok := c.pages[pageID]; ok {
+		if elem.Value.(*cacheEntry).page.Dirty {
+			c.dirty--
+		}
 		delete(c.pages, pageID)
 		c.lru.Remove(elem)
 	}
 }
 
-// Evict evicts all dirty pages and returns them for flushing
+// Evict empties the cache and returns its dirty pages, least recently used
+// first, for the caller to flush
 func (c *LRUCache) Evict() []*Page {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	// TODO: Implement eviction
-	// - Collect all dirty pages
-	// - Clear cache
-	// - Return dirty pages for flushing
-
-	return nil
+	var dirty []*Page
+	for elem := c.lru.Back(); elem != nil; elem = elem.Prev() {
+		if page := elem.Value.(*cacheEntry).page; page.Dirty {
+			dirty = append(dirty, page)
+		}
+	}
+	clear(c.pages)
+	c.lru.Init()
+	c.dirty = 0
+	return dirty
 }
 
 // Stats returns cache statistics
@@ -126,6 +193,7 @@ func (c *LRUCache) Stats() CacheStats {
 		Misses:  c.misses,
 		HitRate: hitRate,
 		Size:    c.lru.Len(),
+		Dirty:   c.dirty,
 	}
 }
 
@@ -135,4 +203,63 @@ type CacheStats struct {
 	Misses  uint64
 	HitRate float64
 	Size    int
+	Dirty   int
+}
+
+// DirtyPages returns the cached pages that have unflushed modifications
+func (c *LRUCache) DirtyPages() []*Page {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var dirty []*Page
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		if page := elem.Value.(*cacheEntry).page; page.Dirty {
+			dirty = append(dirty, page)
+		}
+	}
+	return dirty
+}
+
+// DirtyCount returns the number of dirty pages cached
+func (c *LRUCache) DirtyCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.dirty
+}
+
+// OldestDirty returns up to n dirty pages, least recently used first
+func (c *LRUCache) OldestDirty(n int) []*Page {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var dirty []*Page
+	for elem := c.lru.Back(); elem != nil && len(dirty) < n; elem = elem.Prev() {
+		if page := elem.Value.(*cacheEntry).page; page.Dirty {
+			dirty = append(dirty, page)
+		}
+	}
+	return dirty
+}
+
+// MarkClean marks pages written back. Pages that were replaced or removed
+// since they were handed out are left alone, and the cache is trimmed to
+// capacity again.
+func (c *LRUCache) MarkClean(pages []*Page) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, page := range pages {
+		elem, ok := c.pages[page.ID]
+		if !ok || elem.Value.(*cacheEntry).page != page || !page.Dirty {
+			continue
+		}
+		page.Dirty = false
+		c.dirty--
+	}
+	c.trimLocked(c.capacity)
+}
+
+// Capacity returns the number of pages the cache holds before evicting
+func (c *LRUCache) Capacity() int {
+	return c.capacity
 }
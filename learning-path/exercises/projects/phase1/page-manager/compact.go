@@ -0,0 +1,137 @@
+package pagemanager
+
+import "errors"
+
+// ErrSnapshotActive is returned by Compact while a snapshot is open, since
+// moving pages would change what it sees
+var ErrSnapshotActive = errors.New("snapshot active")
+
+// Compact moves the highest-numbered allocated pages into the lowest free
+// page IDs, then truncates the file after the last allocated page, so the
+// file shrinks after bulk frees. It returns the number of pages moved.
+//
+// relocate, if not nil, is called after each move so the caller can update
+// its references to the page; it must not call the PageManager. If it
+// returns an error Compact stops there, keeping the moves made so far. The
+// overflow chains and the free-space map are updated by Compact itself.
+// Compact does not run while a snapshot is open or a page is latched.
+func (pm *PageManager) Compact(relocate func(from, to PageID) error) (int, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if len(pm.snapshots) > 0 {
+		return 0, ErrSnapshotActive
+	}
+	if pm.latches.held() {
+		return 0, ErrPagesLatched
+	}
+	// Moved pages are written straight to disk, so start from a clean
+	// cache whose evictions lose nothing
+	if err := pm.flushLocked(); err != nil {
+		return 0, err
+	}
+
+	moved := make(map[PageID]PageID)
+	var relocateErr error
+	to, from := 0, int(pm.nextPageID)-1
+	for {
+		for to < from && pm.freeBitmap.Test(to) {
+			to++
+		}
+		for from > to && !pm.freeBitmap.Test(from) {
+			from--
+		}
+		if to >= from {
+			break
+		}
+		if err := pm.moveLocked(PageID(from), PageID(to)); err != nil {
+			return len(moved), err
+		}
+		moved[PageID(from)] = PageID(to)
+		if relocate != nil {
+			if relocateErr = relocate(PageID(from), PageID(to)); relocateErr != nil {
+				break
+			}
+		}
+	}
+	if err := pm.relinkLocked(moved); err != nil {
+		return len(moved), err
+	}
+
+	pm.nextPageID = PageID(pm.highestAllocatedLocked() + 1)
+	pm.meta.headerDirty = true
+	if err := pm.flushLocked(); err != nil {
+		return len(moved), err
+	}
+	if err := pm.file.Truncate(pm.pageOffset(pm.nextPageID)); err != nil {
+		return len(moved), err
+	}
+	pm.filePages = pm.nextPageID
+	return len(moved), relocateErr
+}
+
+// moveLocked copies page from to the free page to and frees from
+func (pm *PageManager) moveLocked(from, to PageID) error {
+	page, err := pm.currentPageLocked(from)
+	if err != nil {
+		return err
+	}
+	cp := page.clone()
+	cp.ID = to
+	if err := pm.writePageToDisk(cp); err != nil {
+		return err
+	}
+	pm.cache.Remove(from)
+	pm.cache.Remove(to)
+
+	pm.freeBitmap.Set(int(to))
+	pm.freeBitmap.Clear(int(from))
+	pm.meta.markAllocation(to)
+	pm.meta.markAllocation(from)
+	pm.bumpVersionLocked(to)
+	pm.bumpVersionLocked(from)
+	if free, ok := pm.freeSpace[from]; ok {
+		pm.freeSpace[to] = free
+		delete(pm.freeSpace, from)
+	}
+	return nil
+}
+
+// relinkLocked points overflow pages that linked to a moved page at its
+// new ID
+func (pm *PageManager) relinkLocked(moved map[PageID]PageID) error {
+	if len(moved) == 0 {
+		return nil
+	}
+	for id := PageID(0); id < pm.nextPageID; id++ {
+		if !pm.freeBitmap.Test(int(id)) {
+			continue
+		}
+		page, err := pm.currentPageLocked(id)
+		if err != nil {
+			return err
+		}
+		to, ok := moved[page.NextOverflow]
+		if page.Type != PageTypeOverflow || !ok {
+			continue
+		}
+		cp := page.clone()
+		cp.NextOverflow = to
+		if err := pm.writePageToDisk(cp); err != nil {
+			return err
+		}
+		pm.cache.Remove(id)
+		pm.bumpVersionLocked(id)
+	}
+	return nil
+}
+
+// highestAllocatedLocked returns the highest allocated page ID, or -1
+func (pm *PageManager) highestAllocatedLocked() int {
+	for n := int(pm.nextPageID) - 1; n >= 0; n-- {
+		if pm.freeBitmap.Test(n) {
+			return n
+		}
+	}
+	return -1
+}
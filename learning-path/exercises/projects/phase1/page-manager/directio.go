@@ -0,0 +1,56 @@
+package pagemanager
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+)
+
+// Errors
+var (
+	ErrDirectIOUnsupported = errors.New("direct I/O not supported on this platform")
+	ErrDirectIOWithMmap    = errors.New("direct I/O and mmap cannot be combined")
+)
+
+// directIOAlignment is the alignment of the buffers, offsets and lengths
+// of direct I/O. Pages are PageSize bytes at PageSize offsets, so only the
+// buffers need care.
+const directIOAlignment = 4096
+
+// WithDirectIO selects direct I/O (Options.DirectIO)
+func WithDirectIO() Option {
+	return func(o *Options) { o.DirectIO = true }
+}
+
+// openFile opens the page file, bypassing the OS page cache with
+// Options.DirectIO
+func openFile(filename string, opts Options) (*os.File, error) {
+	const flag = os.O_RDWR | os.O_CREATE
+	if !opts.DirectIO {
+		return os.OpenFile(filename, flag, 0644)
+	}
+	if opts.Mmap {
+		return nil, ErrDirectIOWithMmap
+	}
+	return openDirect(filename, flag, 0644)
+}
+
+// alignedBuffer returns a zeroed buffer of size bytes that starts at a
+// multiple of directIOAlignment
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+directIOAlignment)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) % directIOAlignment); rem != 0 {
+		off = directIOAlignment - rem
+	}
+	return buf[off : off+size : off+size]
+}
+
+// pageBuffer returns a buffer for one page of file I/O, aligned for
+// direct I/O if it is on
+func (pm *PageManager) pageBuffer() []byte {
+	if pm.opts.DirectIO {
+		return alignedBuffer(pm.pageSize)
+	}
+	return make([]byte, pm.pageSize)
+}
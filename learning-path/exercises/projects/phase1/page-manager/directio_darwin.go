@@ -0,0 +1,20 @@
+package pagemanager
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect opens a file with F_NOCACHE set, the macOS equivalent of
+// O_DIRECT
+func openDirect(name string, flag int, perm os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, f.Fd(), syscall.F_NOCACHE, 1); errno != 0 {
+		f.Close()
+		return nil, errno
+	}
+	return f, nil
+}
@@ -0,0 +1,12 @@
+package pagemanager
+
+import (
+	"os"
+	"syscall"
+)
+
+// openDirect opens a file with O_DIRECT, so reads and writes go straight
+// to the device
+func openDirect(name string, flag int, perm os.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag|syscall.O_DIRECT, perm)
+}
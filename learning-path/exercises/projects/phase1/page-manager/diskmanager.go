@@ -0,0 +1,141 @@
+package pagemanager
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	bufferpool "github.com/kuzu/learning-path/exercises/projects/phase1/buffer-pool"
+)
+
+// Errors
+var (
+	ErrFrameTooLarge = errors.New("buffer pool frame larger than PageDataSize")
+)
+
+// DiskManager adapts a PageManager to bufferpool.DiskManager, so a buffer
+// pool can cache the pages of a page file. A frame holds the data area of
+// a page, so the pool's page size must be at most PageDataSize; the manager
+// keeps the page header and checksum. Frames are written as PageTypeRaw
+// pages.
+type DiskManager struct {
+	pm *PageManager
+}
+
+var (
+	_ bufferpool.DiskManager = (*DiskManager)(nil)
+	_ bufferpool.AsyncPageIO = (*DiskManager)(nil)
+)
+
+// NewDiskManager returns a DiskManager over pm. Closing pm stays with the
+// caller.
+func NewDiskManager(pm *PageManager) *DiskManager {
+	return &DiskManager{pm: pm}
+}
+
+// ReadPage fills data with the start of the page's data area
+func (d *DiskManager) ReadPage(pageID bufferpool.PageID, data []byte) error {
+	id, err := fromPoolID(pageID, data)
+	if err != nil {
+		return err
+	}
+	page, err := d.pm.ReadPage(id)
+	if err != nil {
+		return err
+	}
+	copy(data, page.Data[:])
+	return nil
+}
+
+// WritePage stores data as the page's data area, zeroing the rest of it
+func (d *DiskManager) WritePage(pageID bufferpool.PageID, data []byte) error {
+	id, err := fromPoolID(pageID, data)
+	if err != nil {
+		return err
+	}
+	page := NewPage(id)
+	copy(page.Data[:], data)
+	return d.pm.WritePage(page)
+}
+
+// ReadPagesAsync reads a batch of pages through PageManager.ReadPagesAsync
+// and fills bufs with their data areas
+func (d *DiskManager) ReadPagesAsync(pageIDs []bufferpool.PageID, bufs [][]byte) <-chan []error {
+	errs := make([]error, len(pageIDs))
+	ids := make([]PageID, 0, len(pageIDs))
+	index := make([]int, 0, len(pageIDs)) // of each of ids in pageIDs
+	for i, pageID := range pageIDs {
+		id, err := fromPoolID(pageID, bufs[i])
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		ids = append(ids, id)
+		index = append(index, i)
+	}
+	out := make(chan []error, 1)
+	results := d.pm.ReadPagesAsync(ids)
+	go func() {
+		for j, result := range <-results {
+			i := index[j]
+			if result.Err != nil {
+				errs[i] = result.Err
+				continue
+			}
+			copy(bufs[i], result.Page.Data[:])
+		}
+		out <- errs
+	}()
+	return out
+}
+
+// WritePagesAsync writes a batch of pages through
+// PageManager.WritePagesAsync, zeroing the rest of each data area
+func (d *DiskManager) WritePagesAsync(pageIDs []bufferpool.PageID, data [][]byte) <-chan error {
+	pages := make([]*Page, len(pageIDs))
+	for i, pageID := range pageIDs {
+		id, err := fromPoolID(pageID, data[i])
+		if err != nil {
+			out := make(chan error, 1)
+			out <- err
+			return out
+		}
+		pages[i] = NewPage(id)
+		copy(pages[i].Data[:], data[i])
+	}
+	return d.pm.WritePagesAsync(pages)
+}
+
+// AllocatePage allocates a page in the page file
+func (d *DiskManager) AllocatePage() (bufferpool.PageID, error) {
+	id, err := d.pm.AllocatePage()
+	if err != nil {
+		return -1, err
+	}
+	if id > math.MaxInt64 {
+		// Unreachable with a real file, but the ID types differ in range
+		return -1, fmt.Errorf("page %d: %w", id, ErrInvalidPageID)
+	}
+	return bufferpool.PageID(id), nil
+}
+
+// DeallocatePage frees a page in the page file
+func (d *DiskManager) DeallocatePage(pageID bufferpool.PageID) error {
+	id, err := fromPoolID(pageID, nil)
+	if err != nil {
+		return err
+	}
+	return d.pm.FreePage(id)
+}
+
+// fromPoolID converts a buffer pool page ID, which is signed, and checks
+// that a frame of data fits in a page
+func fromPoolID(pageID bufferpool.PageID, data []byte) (PageID, error) {
+	if pageID < 0 {
+		return InvalidPageID, fmt.Errorf("page %d: %w", pageID, ErrInvalidPageID)
+	}
+	if len(data) > PageDataSize {
+		return InvalidPageID, fmt.Errorf("%w: %d bytes", ErrFrameTooLarge, len(data))
+	}
+	return PageID(pageID), nil
+}
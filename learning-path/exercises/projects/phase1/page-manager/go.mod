@@ -1,3 +1,7 @@
 module pagemanager
 
 go 1.24.7
+
+require github.com/kuzu/learning-path/exercises/projects/phase1/buffer-pool v0.0.0
+
+replace github.com/kuzu/learning-path/exercises/projects/phase1/buffer-pool => ../buffer-pool
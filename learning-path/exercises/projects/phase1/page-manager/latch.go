@@ -0,0 +1,209 @@
+package pagemanager
+
+import (
+	"errors"
+	"sync"
+)
+
+// Errors
+var (
+	ErrLatchReleased = errors.New("latch released")
+	ErrNotExclusive  = errors.New("page latched shared, not exclusive")
+	// ErrPagesLatched is returned by Compact while page latches are held,
+	// since moving pages would change what they guard
+	ErrPagesLatched = errors.New("pages latched")
+)
+
+// LatchMode is the mode a page latch is held in
+type LatchMode int
+
+const (
+	// LatchShared lets other shared holders in and keeps exclusive ones out
+	LatchShared LatchMode = iota
+	// LatchExclusive keeps every other holder out
+	LatchExclusive
+)
+
+// latchTable holds the latch of every page that is latched or waited for.
+// Latches are created on first use and dropped when the last holder or
+// waiter leaves, so the table stays as small as the set of latched pages.
+// It has its own lock: latches are taken and waited for without pm.mu.
+type latchTable struct {
+	mu      sync.Mutex
+	latches map[PageID]*pageLatch
+}
+
+type pageLatch struct {
+	sync.RWMutex
+	refs int // holders and waiters; guarded by latchTable.mu
+}
+
+// acquire blocks until the latch of pageID is held in mode
+func (t *latchTable) acquire(pageID PageID, mode LatchMode) {
+	t.mu.Lock()
+	if t.latches == nil {
+		t.latches = make(map[PageID]*pageLatch)
+	}
+	l, ok := t.latches[pageID]
+	if !ok {
+		l = &pageLatch{}
+		t.latches[pageID] = l
+	}
+	l.refs++
+	t.mu.Unlock()
+
+	if mode == LatchExclusive {
+		l.Lock()
+	} else {
+		l.RLock()
+	}
+}
+
+// release releases the latch of pageID held in mode
+func (t *latchTable) release(pageID PageID, mode LatchMode) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l := t.latches[pageID]
+	if mode == LatchExclusive {
+		l.Unlock()
+	} else {
+		l.RUnlock()
+	}
+	if l.refs--; l.refs == 0 {
+		delete(t.latches, pageID)
+	}
+}
+
+// held reports whether any page is latched or waited for
+func (t *latchTable) held() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.latches) > 0
+}
+
+// PageGuard is a page latched by ReadPageLatched or WritePageLatched.
+// Page is a private copy read after the latch was taken; with an exclusive
+// latch, changes to it are written by Write. Call Release when done.
+//
+// Latches order access to a page among the callers that take them: a page
+// latched exclusive is not read or written by another latched caller until
+// it is released. ReadPage and WritePage take no latches, so code that
+// latches a page should not also access it through them. Latches are held
+// without pm.mu, so holders of latches on different pages never wait for
+// each other; pm.mu is taken only for the read or write itself.
+type PageGuard struct {
+	pm       *PageManager
+	Page     *Page
+	id       PageID
+	mode     LatchMode
+	released bool
+}
+
+// ReadPageLatched latches a page shared and reads it
+func (pm *PageManager) ReadPageLatched(pageID PageID) (*PageGuard, error) {
+	return pm.latchPage(pageID, LatchShared)
+}
+
+// WritePageLatched latches a page exclusive and reads it, so the caller
+// can change the page and Write it back
+func (pm *PageManager) WritePageLatched(pageID PageID) (*PageGuard, error) {
+	return pm.latchPage(pageID, LatchExclusive)
+}
+
+// latchPage latches pageID in mode, then reads it. The latch is taken
+// before pm.mu, never while holding it.
+func (pm *PageManager) latchPage(pageID PageID, mode LatchMode) (*PageGuard, error) {
+	pm.latches.acquire(pageID, mode)
+	page, err := pm.ReadPage(pageID)
+	if err != nil {
+		pm.latches.release(pageID, mode)
+		return nil, err
+	}
+	return &PageGuard{pm: pm, Page: page, id: pageID, mode: mode}, nil
+}
+
+// Mode returns the mode the latch is held in
+func (g *PageGuard) Mode() LatchMode {
+	return g.mode
+}
+
+// Write writes g.Page, keeping the latch. It returns ErrNotExclusive if
+// the latch is shared.
+func (g *PageGuard) Write() error {
+	if g.released {
+		return ErrLatchReleased
+	}
+	if g.mode != LatchExclusive {
+		return ErrNotExclusive
+	}
+	return g.pm.WritePage(g.Page)
+}
+
+// Release releases the latch. Releasing it again does nothing.
+func (g *PageGuard) Release() {
+	if g.released {
+		return
+	}
+	g.released = true
+	g.pm.latches.release(g.id, g.mode)
+}
+
+// Crab latches the pages of a root-to-leaf traversal, such as a B-tree
+// descent, with latch crabbing: a child is latched before its ancestors
+// are released, so no writer can slip in between them.
+//
+//	crab := pm.Crab(LatchExclusive)
+//	defer crab.Release()
+//	node, err := crab.Descend(root, hasRoom)
+//	for err == nil && !isLeaf(node.Page) {
+//		node, err = crab.Descend(childFor(node.Page, key), hasRoom)
+//	}
+//
+// A shared crab releases the parent as soon as the child is latched. An
+// exclusive crab keeps every ancestor latched until it reaches a child
+// that safe reports will absorb the change without splitting or merging
+// into its parent; then it releases the ancestors.
+type Crab struct {
+	pm   *PageManager
+	mode LatchMode
+	held []*PageGuard // root first
+}
+
+// Crab starts a traversal that latches pages in mode
+func (pm *PageManager) Crab(mode LatchMode) *Crab {
+	return &Crab{pm: pm, mode: mode}
+}
+
+// Descend latches and reads the next page of the traversal, then releases
+// the ancestors that no longer need latching. For an exclusive crab that
+// is when safe reports the page safe; a nil safe keeps every ancestor. If
+// the page cannot be read the ancestors stay latched.
+func (c *Crab) Descend(pageID PageID, safe func(*Page) bool) (*PageGuard, error) {
+	g, err := c.pm.latchPage(pageID, c.mode)
+	if err != nil {
+		return nil, err
+	}
+	if c.mode == LatchShared || (safe != nil && safe(g.Page)) {
+		c.releaseHeld()
+	}
+	c.held = append(c.held, g)
+	return g, nil
+}
+
+// Held returns the pages latched now, root first. The last is the page
+// Descend returned last.
+func (c *Crab) Held() []*PageGuard {
+	return c.held
+}
+
+// Release releases every latch the traversal holds
+func (c *Crab) Release() {
+	c.releaseHeld()
+}
+
+func (c *Crab) releaseHeld() {
+	for _, g := range c.held {
+		g.Release()
+	}
+	c.held = c.held[:0]
+}
@@ -1,8 +1,18 @@
 package pagemanager
 
 import (
+	"errors"
+	"io"
 	"os"
 	"sync"
+	"time"
+)
+
+// Errors
+var (
+	ErrInvalidPageID    = errors.New("invalid page ID")
+	ErrPageNotAllocated = errors.New("page not allocated")
+	ErrBadFreeOffset    = errors.New("free-space offset beyond page data")
 )
 
 // PageManager manages pages on disk with caching
@@ -13,119 +23,442 @@ type PageManager struct {
 	freeBitmap *Bitmap
 	mu         sync.RWMutex
 	nextPageID PageID
+	snapshots  map[SnapshotID]*Snapshot
+	nextSnapID SnapshotID
+	// freeSpace maps each PageTypeData page to its free bytes
+	freeSpace map[PageID]int
+	// meta tracks the file header and the on-disk free bitmap
+	meta fileMeta
+	opts Options
+	// filePages is the number of pages the file has room for after the
+	// meta pages
+	filePages PageID
+	// quarantine holds the pages that failed their checksum
+	quarantine quarantine
+	// mapping is the memory-mapped file, with Options.Mmap
+	mapping []byte
+	// latches holds the page latches of ReadPageLatched and
+	// WritePageLatched
+	latches latchTable
+	// writeSeq counts page writes and frees; versions holds the writeSeq
+	// of each page's last one, or nothing if it has none since Open
+	writeSeq uint64
+	versions map[PageID]uint64
+	// writer is the background writer, if the options ask for one
+	writer     *bgWriter
+	writeStats WriteStats
+	counters   opCounters
+	// backend does the I/O of ReadPagesAsync and WritePagesAsync, and is
+	// created by the first of them unless Open needed it; asyncIO counts
+	// the batches in flight on it
+	backend     DiskBackend
+	backendErr  error
+	backendOnce sync.Once
+	asyncIO     sync.WaitGroup
+}
+
+// Options configures a PageManager. Zero values select the defaults.
+type Options struct {
+	// CacheSize is the number of pages the LRU cache holds (default 100)
+	CacheSize int
+	// GrowthPages is how many pages the file is extended by when an
+	// allocation needs room, so a bulk load does not grow it a page at a
+	// time (default 16)
+	GrowthPages int
+	// Mmap reads and writes pages through a shared memory mapping of the
+	// file instead of pread and pwrite. WritePage copies the page into the
+	// mapping at once, ReadPageView returns pages without copying them,
+	// and Flush makes the writes durable with msync.
+	Mmap bool
+	// DirectIO opens the file with O_DIRECT (F_NOCACHE on macOS), so pages
+	// are cached once, in the LRU cache, rather than also in the OS page
+	// cache. Every read the cache misses then goes to the device. It
+	// cannot be combined with Mmap.
+	DirectIO bool
+	// DirtyHighWatermark, if set, starts a background writer that writes
+	// dirty pages once they fill this fraction of the cache, oldest first,
+	// until they fill no more than DirtyLowWatermark (default half of
+	// DirtyHighWatermark). Without it, dirty pages are written by Flush,
+	// or when a write finds the cache full of them.
+	DirtyHighWatermark float64
+	DirtyLowWatermark  float64
+	// WriterInterval, with a background writer, also has it write every
+	// dirty page this often (default only at the high watermark)
+	WriterInterval time.Duration
+	// Trace, if set, is called with the latency of every operation
+	Trace TraceFunc
+	// Backend selects the DiskBackend of ReadPagesAsync and
+	// WritePagesAsync (default BackendAuto), and IODepth how many requests
+	// it has in flight at once (default 32). With Mmap they read and write
+	// through the mapping instead.
+	Backend BackendKind
+	IODepth int
+}
+
+// withDefaults fills in zero-valued options
+func (o Options) withDefaults() Options {
+	if o.CacheSize <= 0 {
+		o.CacheSize = 100
+	}
+	if o.GrowthPages <= 0 {
+		o.GrowthPages = 16
+	}
+	if o.IODepth <= 0 {
+		o.IODepth = defaultIODepth
+	}
+	if o.DirtyHighWatermark > 0 {
+		o.DirtyHighWatermark = min(o.DirtyHighWatermark, 1)
+		if o.DirtyLowWatermark <= 0 || o.DirtyLowWatermark >= o.DirtyHighWatermark {
+			o.DirtyLowWatermark = o.DirtyHighWatermark / 2
+		}
+	}
+	return o
 }
 
-// New creates a new page manager
-func New(filename string, cacheSize int) (*PageManager, error) {
-	// TODO: Implement initialization
-	// - Open or create file
-	// - Load or initialize bitmap
-	// - Create cache
-	// - Read file header
+// New opens the page file with a cache of cacheSize pages, and the default
+// options unless changed by opts, e.g. New(filename, 100, WithMmap())
+func New(filename string, cacheSize int, opts ...Option) (*PageManager, error) {
+	o := Options{CacheSize: cacheSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return Open(filename, o)
+}
 
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+// Open opens the page file, creating it if it does not exist. The pages
+// allocated in an existing file, and the ones freed, are loaded from its
+// free bitmap.
+func Open(filename string, opts Options) (*PageManager, error) {
+	opts = opts.withDefaults()
+	file, err := openFile(filename, opts)
 	if err != nil {
 		return nil, err
 	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
 
 	pm := &PageManager{
 		file:       file,
 		pageSize:   PageSize,
-		cache:      NewLRUCache(cacheSize),
+		cache:      NewLRUCache(opts.CacheSize),
 		freeBitmap: NewBitmap(1000), // Initial size
 		nextPageID: 0,
+		snapshots:  make(map[SnapshotID]*Snapshot),
+		freeSpace:  make(map[PageID]int),
+		opts:       opts,
+		versions:   make(map[PageID]uint64),
+	}
+	if info.Size() == 0 {
+		// Write the meta pages at once, so the file is never extended
+		// without a header
+		pm.initMetaLocked()
+		err = pm.flushMetaLocked()
+	} else {
+		err = pm.loadMetaLocked()
+	}
+	if err == nil && opts.Mmap {
+		err = pm.mapLocked()
+	}
+	if err == nil && opts.Backend == BackendIOUring && !opts.Mmap {
+		// Fail now rather than at the first asynchronous call
+		_, err = pm.diskBackend()
 	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	pm.filePages = PageID(max(info.Size()/int64(pm.pageSize)-int64(pm.meta.metaPages()), 0))
+	pm.startWriter()
 
 	return pm, nil
 }
 
 // AllocatePage allocates a new page and returns its ID
-func (pm *PageManager) AllocatePage() (PageID, error) {
+func (pm *PageManager) AllocatePage() (pageID PageID, err error) {
+	defer func(start time.Time) { pm.trace(OpAllocate, pageID, start) }(pm.traceStart())
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
+	return pm.allocateLocked()
+}
 
-	// TODO: Implement page allocation
-	// - Find free page in bitmap
-	// - If none, grow file
-	// - Mark page as allocated
-	// - Return page ID
+// allocateLocked allocates a page and marks its bitmap page for the next
+// flush. Caller holds pm.mu exclusively.
+func (pm *PageManager) allocateLocked() (PageID, error) {
+	// Reuse a freed page below the high-water mark if there is one
+	if n := pm.freeBitmap.FindFirstZero(); n >= 0 && PageID(n) < pm.nextPageID {
+		pm.freeBitmap.Set(n)
+		pm.meta.markAllocation(PageID(n))
+		pm.counters.allocations.Add(1)
+		return PageID(n), nil
+	}
 
 	pageID := pm.nextPageID
+	if pageID >= pm.meta.capacity() {
+		return InvalidPageID, ErrFileFull
+	}
+	if err := pm.growLocked(pageID + 1); err != nil {
+		return InvalidPageID, err
+	}
+	if int(pageID) >= pm.freeBitmap.Size() {
+		pm.freeBitmap.Resize(pm.freeBitmap.Size() * 2)
+	}
+	pm.freeBitmap.Set(int(pageID))
+	pm.meta.markAllocation(pageID)
+	pm.meta.headerDirty = true
 	pm.nextPageID++
+	pm.counters.allocations.Add(1)
 
 	return pageID, nil
 }
 
+// growLocked extends the file by GrowthPages, or more if needed, so that
+// it has room for pages pages. Caller holds pm.mu exclusively.
+func (pm *PageManager) growLocked(pages PageID) error {
+	if pages <= pm.filePages {
+		return nil
+	}
+	size := min(max(pages, pm.filePages+PageID(pm.opts.GrowthPages)), pm.meta.capacity())
+	if err := pm.file.Truncate(pm.pageOffset(size)); err != nil {
+		return err
+	}
+	pm.filePages = size
+	return nil
+}
+
 // FreePage marks a page as free
 func (pm *PageManager) FreePage(pageID PageID) error {
+	defer pm.trace(OpFree, pageID, pm.traceStart())
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
-	// TODO: Implement page freeing
-	// - Validate page ID
-	// - Evict from cache if present
-	// - Mark as free in bitmap
+	if err := pm.checkAllocatedLocked(pageID); err != nil {
+		return err
+	}
+	if err := pm.preserveLocked(pageID); err != nil {
+		return err
+	}
+	pm.freeLocked(pageID)
+	return nil
+}
+
+// freeLocked frees an allocated page whose image snapshots have preserved.
+// Caller holds pm.mu exclusively.
+func (pm *PageManager) freeLocked(pageID PageID) {
+	pm.cache.Remove(pageID)
+	pm.freeBitmap.Clear(int(pageID))
+	pm.meta.markAllocation(pageID)
+	delete(pm.freeSpace, pageID)
+	pm.bumpVersionLocked(pageID)
+	pm.quarantine.remove(pageID)
+	pm.counters.frees.Add(1)
+}
 
+// checkAllocatedLocked validates pageID. Caller holds pm.mu.
+func (pm *PageManager) checkAllocatedLocked(pageID PageID) error {
+	if pageID >= pm.nextPageID {
+		return ErrInvalidPageID
+	}
+	if !pm.freeBitmap.Test(int(pageID)) {
+		return ErrPageNotAllocated
+	}
 	return nil
 }
 
-// ReadPage reads a page from disk (may come from cache)
+// ReadPage reads a page from disk (may come from cache). The returned page
+// is a private copy; modifications take effect through WritePage.
 func (pm *PageManager) ReadPage(pageID PageID) (*Page, error) {
-	// TODO: Implement page reading
-	// - Check cache first
-	// - If not in cache, read from disk
-	// - Add to cache
-	// - Update LRU
+	defer pm.trace(OpRead, pageID, pm.traceStart())
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
 
-	return nil, nil
+	if err := pm.checkAllocatedLocked(pageID); err != nil {
+		return nil, err
+	}
+	page, err := pm.currentPageLocked(pageID)
+	if err != nil {
+		return nil, err
+	}
+	return page.clone(), nil
+}
+
+// currentPageLocked returns the latest image of a page, loading it into the
+// cache on a miss. Caller holds pm.mu and must not modify the result.
+func (pm *PageManager) currentPageLocked(pageID PageID) (*Page, error) {
+	if page, ok := pm.cache.Get(pageID); ok {
+		return page, nil
+	}
+
+	page, err := pm.readPageFromDisk(pageID)
+	if err != nil {
+		return nil, err
+	}
+	if err := pm.cache.Put(page); err != nil {
+		return nil, err
+	}
+	return page, nil
 }
 
 // WritePage writes a page to disk (may be cached)
 func (pm *PageManager) WritePage(page *Page) error {
-	// TODO: Implement page writing
-	// - Mark page as dirty
-	// - Add to cache
-	// - Optionally flush immediately
+	defer pm.trace(OpWrite, page.ID, pm.traceStart())
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-	return nil
+	if err := pm.checkAllocatedLocked(page.ID); err != nil {
+		return err
+	}
+	return pm.putLocked(page)
+}
+
+// putLocked installs a copy of page as its latest image and updates the
+// free-space map. Caller holds pm.mu exclusively and has checked that the
+// page is allocated.
+func (pm *PageManager) putLocked(page *Page) error {
+	if page.FreeSpace() < 0 {
+		return ErrBadFreeOffset
+	}
+	if err := pm.preserveLocked(page.ID); err != nil {
+		return err
+	}
+
+	cp := page.clone()
+	cp.Dirty = true
+	if pm.mapping != nil {
+		// The write goes to the mapping now and to disk with the next msync
+		if err := pm.writePageToDisk(cp); err != nil {
+			return err
+		}
+		cp.Dirty = false
+	}
+	if err := pm.cache.Put(cp); err != nil {
+		return err
+	}
+	pm.bumpVersionLocked(page.ID)
+	pm.quarantine.remove(page.ID)
+	if page.Type == PageTypeData {
+		pm.freeSpace[page.ID] = page.FreeSpace()
+	} else {
+		delete(pm.freeSpace, page.ID)
+	}
+	return pm.afterPutLocked()
 }
 
-// Flush writes all dirty pages to disk
+// Flush writes the free bitmap and all dirty pages to disk
 func (pm *PageManager) Flush() error {
-	// TODO: Implement flushing
-	// - Iterate through cache
-	// - Write all dirty pages
-	// - Clear dirty flags
+	defer pm.trace(OpFlush, InvalidPageID, pm.traceStart())
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.flushLocked()
+}
 
-	return nil
+// flushLocked writes the free bitmap and the dirty pages and syncs. Caller
+// holds pm.mu exclusively.
+func (pm *PageManager) flushLocked() error {
+	if err := pm.flushMetaLocked(); err != nil {
+		return err
+	}
+	dirty := pm.cache.DirtyPages()
+	if err := pm.writePagesLocked(dirty); err != nil {
+		return err
+	}
+	pm.cache.MarkClean(dirty)
+	if pm.mapping != nil {
+		return msync(pm.mapping[:pm.pageOffset(pm.filePages)])
+	}
+	return pm.file.Sync()
+}
+
+// CacheStats returns the cache statistics
+func (pm *PageManager) CacheStats() CacheStats {
+	return pm.cache.Stats()
 }
 
-// Close flushes and closes the page manager
+// Close flushes and closes the page manager. The backend, mapping and file
+// are released even if the flush fails; every error is returned.
 func (pm *PageManager) Close() error {
-	if err := pm.Flush(); err != nil {
-		return err
+	if pm.writer != nil {
+		pm.writer.close()
+	}
+	errs := []error{pm.Flush()}
+	pm.asyncIO.Wait()
+	if pm.backend != nil {
+		errs = append(errs, pm.backend.Close())
 	}
-	return pm.file.Close()
+	if pm.mapping != nil {
+		errs = append(errs, unmapFile(pm.mapping))
+		pm.mapping = nil
+	}
+	errs = append(errs, pm.file.Close())
+	return errors.Join(errs...)
 }
 
-// readPageFromDisk reads a page from disk at the given offset
+// readPageFromDisk reads a page from disk at the given offset and checks
+// its checksum. Pages past the end of the file, or in room the file grew
+// by, have never been written and read as zeroes. A page that fails its
+// checksum is quarantined.
 func (pm *PageManager) readPageFromDisk(pageID PageID) (*Page, error) {
-	// TODO: Implement disk read
-	// - Calculate offset: pageID * pageSize
-	// - Seek to position
-	// - Read PageSize bytes
-	// - Unmarshal into Page
+	defer pm.trace(OpDiskRead, pageID, pm.traceStart())
+	var buf []byte
+	if pm.mapping != nil {
+		if pageID >= pm.filePages {
+			return NewPage(pageID), nil
+		}
+		pm.counters.diskReads.Add(1)
+		buf = pm.pageBytes(pageID)
+	} else {
+		// A short read leaves the rest of buf zero, which fails the
+		// checksum unless nothing was read at all
+		pm.counters.diskReads.Add(1)
+		buf = pm.pageBuffer()
+		if _, err := pm.file.ReadAt(buf, pm.pageOffset(pageID)); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	return pm.decodePage(pageID, buf)
+}
 
-	return nil, nil
+// decodePage decodes the bytes of a page read from the file, which read as
+// a fresh page if they are zeroes, and quarantines a page that fails its
+// checksum
+func (pm *PageManager) decodePage(pageID PageID, buf []byte) (*Page, error) {
+	if isZero(buf) {
+		return NewPage(pageID), nil
+	}
+
+	page := NewPage(pageID)
+	if err := page.Unmarshal(buf); err != nil {
+		return nil, err
+	}
+	if !page.Validate() {
+		pm.quarantine.add(pageID)
+		return nil, &ErrChecksumMismatch{PageID: pageID}
+	}
+	page.ID = pageID
+	return page, nil
 }
 
-// writePageToDisk writes a page to disk
+// writePageToDisk writes a page to disk, or to the mapping
 func (pm *PageManager) writePageToDisk(page *Page) error {
-	// TODO: Implement disk write
-	// - Calculate offset
-	// - Marshal page
-	// - Seek and write
-	// - Sync to disk
+	defer pm.trace(OpDiskWrite, page.ID, pm.traceStart())
+	if pm.mapping != nil {
+		if page.ID >= pm.filePages {
+			return ErrInvalidPageID
+		}
+		page.marshalTo(pm.pageBytes(page.ID))
+		pm.counters.diskWrites.Add(1)
+		return nil
+	}
+	pm.counters.diskWrites.Add(1)
+	buf := pm.pageBuffer()
+	page.marshalTo(buf)
+	_, err := pm.file.WriteAt(buf, pm.pageOffset(page.ID))
+	return err
+}
 
-	return nil
+// pageOffset returns the file offset of a page, past the meta pages
+func (pm *PageManager) pageOffset(pageID PageID) int64 {
+	return (int64(pm.meta.metaPages()) + int64(pageID)) * int64(pm.pageSize)
 }
@@ -1,9 +1,19 @@
 package pagemanager
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+	"unsafe"
+
+	bufferpool "github.com/kuzu/learning-path/exercises/projects/phase1/buffer-pool"
 )
 
 func TestPageManagerBasic(t *testing.T) {
@@ -47,11 +57,356 @@ func TestPageManagerCache(t *testing.T) {
 }
 
 func TestPageManagerPersistence(t *testing.T) {
-	// TODO: Implement persistence test
-	// - Write pages
-	// - Close manager
-	// - Reopen
-	// - Verify data persisted
+	path := filepath.Join(t.TempDir(), "test.db")
+	pm, err := New(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 4 {
+		id, _ := pm.AllocatePage()
+		writeByte(t, pm, id, byte('a'+i))
+	}
+	if err := pm.FreePage(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pm, err = New(path, 10)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	for id, want := range map[PageID]byte{0: 'a', 2: 'c', 3: 'd'} {
+		if page, err := pm.ReadPage(id); err != nil || page.Data[0] != want {
+			t.Errorf("ReadPage(%d) after reopen = %v", id, err)
+		}
+	}
+	if _, err := pm.ReadPage(1); !errors.Is(err, ErrPageNotAllocated) {
+		t.Errorf("freed page after reopen: %v", err)
+	}
+	// The page freed before the restart is reused, then the file grows
+	for _, want := range []PageID{1, 4} {
+		if id, err := pm.AllocatePage(); err != nil || id != want {
+			t.Errorf("AllocatePage() = %d, %v; want %d", id, err, want)
+		}
+	}
+
+	// A free that was never flushed is lost with the process, and the
+	// page comes back allocated
+	pm.Flush()
+	pm.FreePage(2)
+	pm.file.Close()
+	pm, err = New(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	if page, err := pm.ReadPage(2); err != nil || page.Data[0] != 'c' {
+		t.Errorf("page 2 after an unflushed free: %v", err)
+	}
+
+	// Files without the header are rejected
+	other := filepath.Join(t.TempDir(), "other.db")
+	os.WriteFile(other, bytes.Repeat([]byte{'x'}, PageSize), 0644)
+	if _, err := New(other, 10); !errors.Is(err, ErrNotPageFile) {
+		t.Errorf("New(foreign file) = %v", err)
+	}
+}
+
+// newTestManager creates a page manager backed by a temp file
+func newTestManager(t *testing.T, cacheSize int) *PageManager {
+	t.Helper()
+	pm, err := New(filepath.Join(t.TempDir(), "test.db"), cacheSize)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { pm.Close() })
+	return pm
+}
+
+// writeByte writes page pageID, which must already be allocated, with b as
+// its first data byte
+func writeByte(t *testing.T, pm *PageManager, pageID PageID, b byte) {
+	t.Helper()
+	page := NewPage(pageID)
+	page.Data[0] = b
+	if err := pm.WritePage(page); err != nil {
+		t.Fatalf("WritePage(%d) error = %v", pageID, err)
+	}
+}
+
+func TestSnapshotCopyOnWrite(t *testing.T) {
+	pm := newTestManager(t, 10)
+
+	p0, _ := pm.AllocatePage()
+	p1, _ := pm.AllocatePage()
+	writeByte(t, pm, p0, 'a')
+	writeByte(t, pm, p1, 'b')
+
+	snap := pm.BeginSnapshot()
+	defer snap.Release()
+
+	writeByte(t, pm, p0, 'A')
+	// Allocated before p1 is freed, so it cannot reuse p1's ID
+	p2, _ := pm.AllocatePage()
+	if err := pm.FreePage(p1); err != nil {
+		t.Fatal(err)
+	}
+
+	for pageID, want := range map[PageID]byte{p0: 'a', p1: 'b'} {
+		page, err := snap.ReadPage(pageID)
+		if err != nil {
+			t.Fatalf("snapshot ReadPage(%d) error = %v", pageID, err)
+		}
+		if page.Data[0] != want {
+			t.Errorf("snapshot page %d = %q, want %q", pageID, page.Data[0], want)
+		}
+	}
+	if current, _ := pm.ReadPage(p0); current.Data[0] != 'A' {
+		t.Errorf("live page %d = %q, want 'A'", p0, current.Data[0])
+	}
+	if _, err := snap.ReadPage(p2); !errors.Is(err, ErrInvalidPageID) {
+		t.Errorf("expected page allocated after snapshot to be invisible, got %v", err)
+	}
+
+	// A second write to the same page must not replace the preserved image
+	writeByte(t, pm, p0, 'Z')
+	if page, _ := snap.ReadPage(p0); page.Data[0] != 'a' {
+		t.Errorf("snapshot page %d = %q after second write, want 'a'", p0, page.Data[0])
+	}
+	if n := snap.PreservedPages(); n != 2 {
+		t.Errorf("expected 2 preserved pages, got %d", n)
+	}
+
+	snap.Release()
+	if _, err := snap.ReadPage(p0); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("expected ErrSnapshotReleased, got %v", err)
+	}
+}
+
+func TestSnapshotSurvivesFlush(t *testing.T) {
+	pm := newTestManager(t, 10)
+
+	p0, _ := pm.AllocatePage()
+	writeByte(t, pm, p0, 'x')
+	if err := pm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	snap := pm.BeginSnapshot()
+	defer snap.Release()
+	writeByte(t, pm, p0, 'y')
+	if err := pm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if page, _ := snap.ReadPage(p0); page.Data[0] != 'x' {
+		t.Errorf("snapshot page = %q, want 'x'", page.Data[0])
+	}
+}
+
+func TestPageHeaderRoundTrip(t *testing.T) {
+	page := NewPage(7)
+	page.Type = PageTypeOverflow
+	page.FreeOffset = 100
+	page.NextOverflow = 8
+	page.Data[0] = 'x'
+
+	var got Page
+	if err := got.Unmarshal(page.Marshal()); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 7 || got.Type != PageTypeOverflow || got.FreeOffset != 100 || got.NextOverflow != 8 || got.Data[0] != 'x' {
+		t.Errorf("round trip = %+v", got)
+	}
+	if !got.Validate() {
+		t.Error("checksum does not validate")
+	}
+	got.Type = PageTypeData
+	if got.Validate() {
+		t.Error("checksum does not cover the page type")
+	}
+
+	// A zeroed page is an empty raw page that ends any chain
+	var zero Page
+	zero.Unmarshal(make([]byte, PageSize))
+	if zero.Type != PageTypeRaw || zero.NextOverflow != InvalidPageID || zero.FreeSpace() != PageDataSize {
+		t.Errorf("zeroed page = type %d next %d free %d", zero.Type, zero.NextOverflow, zero.FreeSpace())
+	}
+}
+
+func TestChainedPages(t *testing.T) {
+	pm := newTestManager(t, 100)
+	payload := make([]byte, 2*PageDataSize+123)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+
+	first, err := pm.WriteChained(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages, err := pm.ChainPages(first)
+	if err != nil || len(pages) != 3 {
+		t.Fatalf("ChainPages = %d pages, %v", len(pages), err)
+	}
+	if last := pages[2]; last.NextOverflow != InvalidPageID || last.FreeOffset != 123 {
+		t.Errorf("last page next %d offset %d", last.NextOverflow, last.FreeOffset)
+	}
+
+	// The chain reads back the same from disk
+	if err := pm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for _, page := range pages {
+		pm.cache.Remove(page.ID)
+	}
+	got, err := pm.ReadChained(first)
+	if err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("ReadChained = %d bytes, %v; want %d bytes", len(got), err, len(payload))
+	}
+
+	// A page that is not part of a chain breaks it
+	raw, _ := pm.AllocatePage()
+	pages[1].NextOverflow = raw
+	if err := pm.WritePage(pages[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.ReadChained(first); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("chain into a raw page: %v", err)
+	}
+	pages[1].NextOverflow = first
+	pm.WritePage(pages[1])
+	if _, err := pm.ReadChained(first); !errors.Is(err, ErrBrokenChain) {
+		t.Errorf("looping chain: %v", err)
+	}
+
+	pages[1].NextOverflow = pages[2].ID
+	pm.WritePage(pages[1])
+	if err := pm.FreeChain(first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.ReadPage(pages[2].ID); !errors.Is(err, ErrPageNotAllocated) {
+		t.Errorf("chain page still allocated: %v", err)
+	}
+}
+
+func TestFreeSpaceMap(t *testing.T) {
+	pm := newTestManager(t, 10)
+	for _, used := range []uint16{4000, 1000, 2000} {
+		id, _ := pm.AllocatePage()
+		page := NewPage(id)
+		page.Type = PageTypeData
+		page.FreeOffset = used
+		if err := pm.WritePage(page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for need, want := range map[int]PageID{10: 0, 2000: 1, 3000: 1} {
+		if id, ok := pm.FindFreeSpace(need); !ok || id != want {
+			t.Errorf("FindFreeSpace(%d) = %d, %v; want %d", need, id, ok, want)
+		}
+	}
+	if _, ok := pm.FindFreeSpace(PageDataSize); ok {
+		t.Error("found a page with no data in it")
+	}
+	pm.FreePage(1)
+	if id, _ := pm.FindFreeSpace(2000); id != 2 {
+		t.Errorf("freed page still in the map: got %d", id)
+	}
+
+	page := NewPage(0)
+	page.FreeOffset = PageDataSize + 1
+	if err := pm.WritePage(page); !errors.Is(err, ErrBadFreeOffset) {
+		t.Errorf("WritePage with offset past the data: %v", err)
+	}
+}
+
+func TestSlottedPage(t *testing.T) {
+	pm := newTestManager(t, 10)
+	id, _ := pm.AllocatePage()
+	sp := NewSlottedPage(id)
+
+	records := map[SlotID][]byte{}
+	for _, r := range []string{"alice", "", "a somewhat longer row of variable length"} {
+		slot, err := sp.InsertRecord([]byte(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[slot] = []byte(r)
+	}
+	if err := sp.DeleteRecord(0); err != nil {
+		t.Fatal(err)
+	}
+	delete(records, 0)
+	if _, err := sp.GetRecord(0); !errors.Is(err, ErrRecordDeleted) {
+		t.Errorf("GetRecord(deleted) = %v", err)
+	}
+	if _, err := sp.GetRecord(9); !errors.Is(err, ErrInvalidSlot) {
+		t.Errorf("GetRecord(9) = %v", err)
+	}
+
+	// The slot directory survives a round trip through the manager
+	if err := pm.WritePage(sp.Page()); err != nil {
+		t.Fatal(err)
+	}
+	pm.Flush()
+	pm.cache.Remove(id)
+	page, err := pm.ReadPage(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp, err = AsSlotted(page); err != nil {
+		t.Fatal(err)
+	}
+	for slot, want := range records {
+		if got, err := sp.GetRecord(slot); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("GetRecord(%d) = %q, %v; want %q", slot, got, err, want)
+		}
+	}
+	if free, ok := pm.FindFreeSpace(1000); !ok || free != id {
+		t.Errorf("free-space map does not list the slotted page")
+	}
+
+	// Filling the page reuses the deleted slot and compacts the holes
+	row := bytes.Repeat([]byte{'r'}, 100)
+	for {
+		slot, err := sp.InsertRecord(row)
+		if errors.Is(err, ErrPageFull) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[slot] = row
+		if len(records) == 3 && slot != 0 {
+			t.Errorf("first insert after delete got slot %d, want 0", slot)
+		}
+	}
+	if sp.FreeSpace() >= len(row) {
+		t.Errorf("page full with %d bytes free", sp.FreeSpace())
+	}
+	sp.DeleteRecord(2)
+	delete(records, 2)
+	if sp.page.FreeSpace() >= len(row) {
+		t.Fatalf("test needs fragmented space, have %d contiguous", sp.page.FreeSpace())
+	}
+	slot, err := sp.InsertRecord(row[:40])
+	if err != nil || slot != 2 {
+		t.Fatalf("insert into fragmented page = %d, %v", slot, err)
+	}
+	records[slot] = row[:40]
+	for slot, want := range records {
+		if got, err := sp.GetRecord(slot); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("after compaction GetRecord(%d) = %q, %v", slot, got, err)
+		}
+	}
+
+	if _, err := AsSlotted(NewPage(1)); !errors.Is(err, ErrNotSlotted) {
+		t.Errorf("AsSlotted(raw page) = %v", err)
+	}
 }
 
 func BenchmarkAllocatePage(b *testing.B) {
@@ -69,10 +424,1068 @@ func BenchmarkReadPageCached(b *testing.B) {
 	// TODO: Implement benchmark for cached reads
 }
 
+// BenchmarkReadPageUncached reads 1024 pages round-robin through a
+// one-page cache, comparing pread with the memory-mapped backend and
+// direct I/O. Where it can be measured, cached-KB is how much of the file
+// the OS page cache holds afterwards.
 func BenchmarkReadPageUncached(b *testing.B) {
-	// TODO: Implement benchmark for uncached reads
+	const pages = 1024
+	for _, bc := range []struct {
+		name string
+		opts []Option
+		view bool
+	}{
+		{"pread", nil, false},
+		{"mmap", []Option{WithMmap()}, false},
+		{"mmap-view", []Option{WithMmap()}, true},
+		{"direct", []Option{WithDirectIO()}, false},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			path := filepath.Join(b.TempDir(), "bench.db")
+			pm, err := New(path, 1, bc.opts...)
+			if errors.Is(err, ErrMmapUnsupported) || errors.Is(err, ErrDirectIOUnsupported) || errors.Is(err, syscall.EINVAL) {
+				b.Skip(err)
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer pm.Close()
+			for range pages {
+				id, _ := pm.AllocatePage()
+				page := NewPage(id)
+				page.Data[0] = byte(id)
+				pm.WritePage(page)
+			}
+			pm.Flush()
+
+			b.SetBytes(PageDataSize)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id := PageID(i % pages)
+				if bc.view {
+					_, err = pm.ReadPageView(id)
+				} else {
+					_, err = pm.ReadPage(id)
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.StopTimer()
+			if cached, ok := pageCacheBytes(path); ok {
+				b.ReportMetric(float64(cached)/1024, "cached-KB")
+			}
+		})
+	}
 }
 
 func BenchmarkWritePage(b *testing.B) {
 	// TODO: Implement benchmark for writes
 }
+
+func TestFileGrowthAndCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	pm, err := Open(path, Options{GrowthPages: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileSize := func() int64 {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+	metaSize := int64(1+bitmapPages) * PageSize
+
+	// The file grows 8 pages at a time
+	for i := range 20 {
+		id, _ := pm.AllocatePage()
+		writeByte(t, pm, id, byte(i))
+	}
+	if got := fileSize(); got != metaSize+24*PageSize {
+		t.Errorf("file is %d pages after 20 allocations, want 24", (got-metaSize)/PageSize)
+	}
+
+	// Pages 20-22 are an overflow chain, page 23 a data page
+	payload := bytes.Repeat([]byte("overflow"), PageDataSize/3)
+	first, err := pm.WriteChained(payload)
+	if err != nil || first != 20 {
+		t.Fatalf("WriteChained = %d, %v", first, err)
+	}
+	id, _ := pm.AllocatePage()
+	data := NewSlottedPage(id)
+	data.InsertRecord([]byte("row"))
+	if err := pm.WritePage(data.Page()); err != nil {
+		t.Fatal(err)
+	}
+
+	for id := PageID(0); id < 20; id++ {
+		if id%5 != 0 {
+			pm.FreePage(id)
+		}
+	}
+	snap := pm.BeginSnapshot()
+	if _, err := pm.Compact(nil); !errors.Is(err, ErrSnapshotActive) {
+		t.Errorf("Compact with a snapshot open = %v", err)
+	}
+	snap.Release()
+
+	moves := make(map[PageID]PageID)
+	n, err := pm.Compact(func(from, to PageID) error {
+		moves[from] = to
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 || len(moves) != 6 || moves[23] != 1 || moves[first] != 4 || moves[15] != 6 {
+		t.Errorf("Compact moved %d pages: %v", n, moves)
+	}
+	if got := fileSize(); got != metaSize+8*PageSize {
+		t.Errorf("file is %d pages after Compact, want 8", (got-metaSize)/PageSize)
+	}
+
+	check := func(pm *PageManager) {
+		t.Helper()
+		for _, id := range []PageID{0, 5, 10, 15} {
+			at, ok := moves[id]
+			if !ok {
+				at = id
+			}
+			if page, err := pm.ReadPage(at); err != nil || page.Data[0] != byte(id) {
+				t.Errorf("page %d, now %d, after Compact: %v", id, at, err)
+			}
+		}
+		if got, err := pm.ReadChained(moves[first]); err != nil || !bytes.Equal(got, payload) {
+			t.Errorf("moved chain: %d bytes, %v", len(got), err)
+		}
+		if _, err := pm.ReadPage(8); !errors.Is(err, ErrInvalidPageID) {
+			t.Errorf("page past the compacted file: %v", err)
+		}
+	}
+	check(pm)
+	if id, ok := pm.FindFreeSpace(100); !ok || id != moves[23] {
+		t.Errorf("free-space map after Compact: %d, %v", id, ok)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pm, err = Open(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	check(pm)
+
+	// A failing callback stops the compaction but keeps the moves made
+	pm.FreePage(0)
+	pm.FreePage(1)
+	stop := errors.New("stop")
+	if n, err := pm.Compact(func(from, to PageID) error { return stop }); n != 1 || !errors.Is(err, stop) {
+		t.Errorf("Compact with a failing callback = %d, %v", n, err)
+	}
+}
+
+func TestChecksumQuarantine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	pm, err := New(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	for i := range 3 {
+		id, _ := pm.AllocatePage()
+		writeByte(t, pm, id, byte('a'+i))
+	}
+	unwritten, _ := pm.AllocatePage()
+	if err := pm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Flip a data byte of page 1 on disk
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte{'X'}, pm.pageOffset(1)+PageHeaderSize+10); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	for id := range PageID(4) {
+		pm.cache.Remove(id)
+	}
+
+	var mismatch *ErrChecksumMismatch
+	if _, err := pm.ReadPage(1); !errors.As(err, &mismatch) || mismatch.PageID != 1 {
+		t.Fatalf("ReadPage of a corrupt page = %v", err)
+	}
+	for _, id := range []PageID{0, 2, unwritten} {
+		if _, err := pm.ReadPage(id); err != nil {
+			t.Errorf("ReadPage(%d) = %v", id, err)
+		}
+	}
+	if got := pm.CorruptPages(); len(got) != 1 || got[0] != 1 {
+		t.Errorf("CorruptPages() = %v, want [1]", got)
+	}
+
+	// Overwriting the page takes it out of quarantine
+	writeByte(t, pm, 1, 'B')
+	if got := pm.CorruptPages(); len(got) != 0 {
+		t.Errorf("CorruptPages() after rewrite = %v", got)
+	}
+	if page, err := pm.ReadPage(1); err != nil || page.Data[0] != 'B' {
+		t.Errorf("rewritten page: %v", err)
+	}
+}
+
+func TestMmapBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	pm, err := New(path, 10, WithMmap())
+	if errors.Is(err, ErrMmapUnsupported) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		id, _ := pm.AllocatePage()
+		writeByte(t, pm, id, byte('a'+i))
+	}
+	view, err := pm.ReadPageView(3)
+	if err != nil || view[0] != 'd' || len(view) != PageDataSize {
+		t.Fatalf("ReadPageView(3) = %d bytes, %v", len(view), err)
+	}
+	writeByte(t, pm, 3, 'D')
+	if view[0] != 'D' {
+		t.Error("view does not reflect a later WritePage")
+	}
+	if page, err := pm.ReadPage(3); err != nil || page.Data[0] != 'D' {
+		t.Errorf("ReadPage(3) = %v", err)
+	}
+	for id := PageID(0); id < 15; id++ {
+		pm.FreePage(id)
+	}
+	if _, err := pm.Compact(nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file is the same as one written with pread and pwrite; Compact
+	// moved pages 19..15 to 0..4
+	pm, err = New(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := PageID(0); id < 5; id++ {
+		if page, err := pm.ReadPage(id); err != nil || page.Data[0] != byte('t'-id) {
+			t.Errorf("page %d after reopen: %v", id, err)
+		}
+	}
+	if _, err := pm.ReadPageView(0); !errors.Is(err, ErrNotMapped) {
+		t.Errorf("ReadPageView without mmap = %v", err)
+	}
+	pm.Close()
+
+	// A corrupt page fails through the view too
+	f, _ := os.OpenFile(path, os.O_RDWR, 0)
+	f.WriteAt([]byte{'X'}, pm.pageOffset(2)+PageHeaderSize+1)
+	f.Close()
+	pm, err = New(path, 10, WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	var mismatch *ErrChecksumMismatch
+	if _, err := pm.ReadPageView(2); !errors.As(err, &mismatch) || mismatch.PageID != 2 {
+		t.Errorf("ReadPageView of a corrupt page = %v", err)
+	}
+	if _, err := pm.ReadPage(2); !errors.As(err, &mismatch) {
+		t.Errorf("ReadPage of a corrupt page = %v", err)
+	}
+}
+
+func TestDirectIO(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	pm, err := New(path, 16, WithDirectIO())
+	if errors.Is(err, ErrDirectIOUnsupported) || errors.Is(err, syscall.EINVAL) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf := pm.pageBuffer(); len(buf) != PageSize || uintptr(unsafe.Pointer(&buf[0]))%directIOAlignment != 0 {
+		t.Fatalf("pageBuffer() = %d bytes at %p", len(buf), &buf[0])
+	}
+	for i := range 10 {
+		id, _ := pm.AllocatePage()
+		writeByte(t, pm, id, byte('a'+i))
+	}
+	for id := PageID(0); id < 10; id++ {
+		if page, err := pm.ReadPage(id); err != nil || page.Data[0] != byte('a'+id) {
+			t.Errorf("ReadPage(%d) = %v", id, err)
+		}
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Written through O_DIRECT, read back without it
+	pm, err = New(path, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	for id := PageID(0); id < 10; id++ {
+		if page, err := pm.ReadPage(id); err != nil || page.Data[0] != byte('a'+id) {
+			t.Errorf("page %d after reopen: %v", id, err)
+		}
+	}
+
+	if _, err := New(filepath.Join(t.TempDir(), "both.db"), 2, WithDirectIO(), WithMmap()); !errors.Is(err, ErrDirectIOWithMmap) {
+		t.Errorf("New with direct I/O and mmap = %v", err)
+	}
+}
+
+func TestPageLatches(t *testing.T) {
+	pm := newTestManager(t, 10)
+	for range 3 {
+		pm.AllocatePage()
+	}
+
+	// Latched read-modify-writes of one page do not lose updates
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				g, err := pm.WritePageLatched(0)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				binary.LittleEndian.PutUint32(g.Page.Data[:], binary.LittleEndian.Uint32(g.Page.Data[:])+1)
+				if err := g.Write(); err != nil {
+					t.Error(err)
+				}
+				g.Release()
+			}
+		}()
+	}
+	wg.Wait()
+	if page, _ := pm.ReadPage(0); binary.LittleEndian.Uint32(page.Data[:]) != 400 {
+		t.Errorf("counter = %d, want 400", binary.LittleEndian.Uint32(page.Data[:]))
+	}
+
+	// An exclusive latch keeps latched callers of that page out, but not
+	// of other pages
+	g, _ := pm.WritePageLatched(1)
+	other, err := pm.ReadPageLatched(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Release()
+	acquired := make(chan *PageGuard)
+	go func() {
+		g, _ := pm.ReadPageLatched(1)
+		acquired <- g
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("shared latch granted while the page is latched exclusive")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if _, err := pm.Compact(nil); !errors.Is(err, ErrPagesLatched) {
+		t.Errorf("Compact while latched = %v", err)
+	}
+	g.Page.Data[0] = 'x'
+	g.Write()
+	g.Release()
+	reader := <-acquired
+	if reader.Page.Data[0] != 'x' {
+		t.Error("reader did not see the write made under the exclusive latch")
+	}
+	if err := reader.Write(); !errors.Is(err, ErrNotExclusive) {
+		t.Errorf("Write with a shared latch = %v", err)
+	}
+	// Shared latches do not exclude each other
+	second, _ := pm.ReadPageLatched(1)
+	second.Release()
+	reader.Release()
+	reader.Release()
+	if err := g.Write(); !errors.Is(err, ErrLatchReleased) {
+		t.Errorf("Write after Release = %v", err)
+	}
+	if pm.latches.held() {
+		t.Error("latches left in the table after release")
+	}
+	if _, err := pm.WritePageLatched(99); !errors.Is(err, ErrInvalidPageID) || pm.latches.held() {
+		t.Errorf("latching an invalid page = %v", err)
+	}
+}
+
+func TestLatchCrabbing(t *testing.T) {
+	pm := newTestManager(t, 10)
+	// A three-level path 0 -> 1 -> 2; pages with Data[0] == 1 are safe
+	for id := range 3 {
+		pm.AllocatePage()
+		writeByte(t, pm, PageID(id), byte(id%2))
+	}
+	safe := func(p *Page) bool { return p.Data[0] == 1 }
+	held := func(c *Crab) []PageID {
+		var ids []PageID
+		for _, g := range c.Held() {
+			ids = append(ids, g.Page.ID)
+		}
+		return ids
+	}
+
+	crab := pm.Crab(LatchExclusive)
+	crab.Descend(0, safe)
+	crab.Descend(1, safe) // safe: releases page 0
+	if ids := held(crab); !slices.Equal(ids, []PageID{1}) {
+		t.Errorf("after a safe child, held %v", ids)
+	}
+	crab.Descend(2, safe) // unsafe: keeps page 1
+	if ids := held(crab); !slices.Equal(ids, []PageID{1, 2}) {
+		t.Errorf("after an unsafe child, held %v", ids)
+	}
+	// Page 0 is free for others, page 1 is not
+	if g, err := pm.WritePageLatched(0); err == nil {
+		g.Release()
+	}
+	crab.Release()
+
+	crab = pm.Crab(LatchShared)
+	for id := range 3 {
+		crab.Descend(PageID(id), nil)
+	}
+	if ids := held(crab); !slices.Equal(ids, []PageID{2}) {
+		t.Errorf("shared crab held %v", ids)
+	}
+	if _, err := crab.Descend(99, nil); err == nil || len(crab.Held()) != 1 {
+		t.Errorf("Descend to an invalid page = %v, held %d", err, len(crab.Held()))
+	}
+	crab.Release()
+	if pm.latches.held() {
+		t.Error("latches left in the table after Release")
+	}
+}
+
+func TestSnapshotBackup(t *testing.T) {
+	pm := newTestManager(t, 10)
+	for i := range 5 {
+		id, _ := pm.AllocatePage()
+		writeByte(t, pm, id, byte('a'+i))
+	}
+	pm.FreePage(3)
+
+	id := pm.SnapshotBegin()
+	snap, ok := pm.Snapshot(id)
+	if !ok || snap.ID() != id {
+		t.Fatalf("Snapshot(%d) = %v", id, ok)
+	}
+	if v, _ := pm.PageVersion(0); v > snap.Version() {
+		t.Errorf("page 0 version %d is past the snapshot's %d", v, snap.Version())
+	}
+
+	// Writers carry on while the backup runs
+	writeByte(t, pm, 0, 'Z')
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, id := range []PageID{0, 1, 4} {
+				page := NewPage(id)
+				page.Data[0] = 'Z'
+				pm.WritePage(page)
+			}
+		}
+	}()
+	path := filepath.Join(t.TempDir(), "backup.db")
+	err := snap.Backup(path)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v, _ := pm.PageVersion(0); v <= snap.Version() {
+		t.Errorf("page 0 version %d not past the snapshot's %d after writes", v, snap.Version())
+	}
+	if page, err := pm.ReadPageAt(id, 4); err != nil || page.Data[0] != 'e' {
+		t.Errorf("ReadPageAt(4) = %v", err)
+	}
+	pm.SnapshotEnd(id)
+	pm.SnapshotEnd(id)
+	if _, err := pm.ReadPageAt(id, 4); !errors.Is(err, ErrSnapshotReleased) {
+		t.Errorf("ReadPageAt after SnapshotEnd = %v", err)
+	}
+
+	backup, err := New(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backup.Close()
+	for id, want := range map[PageID]byte{0: 'a', 1: 'b', 2: 'c', 4: 'e'} {
+		if page, err := backup.ReadPage(id); err != nil || page.Data[0] != want {
+			t.Errorf("backup page %d: %v", id, err)
+		}
+	}
+	if _, err := backup.ReadPage(3); !errors.Is(err, ErrPageNotAllocated) {
+		t.Errorf("backup of a free page = %v", err)
+	}
+
+	snap = pm.BeginSnapshot()
+	defer snap.Release()
+	if err := snap.Backup(path); !errors.Is(err, os.ErrExist) {
+		t.Errorf("Backup over an existing file = %v", err)
+	}
+}
+
+func TestLRUCacheFlush(t *testing.T) {
+	dirtyPage := func(id PageID) *Page {
+		page := NewPage(id)
+		page.Dirty = true
+		return page
+	}
+
+	// Without a FlushFunc dirty pages are kept past capacity
+	c := NewLRUCache(2)
+	for id := range PageID(3) {
+		if err := c.Put(dirtyPage(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := c.Stats(); stats.Size != 3 || stats.Dirty != 3 {
+		t.Errorf("without FlushFunc: %+v", stats)
+	}
+
+	var flushed []PageID
+	failFlush := false
+	c = NewLRUCache(2)
+	c.SetFlushFunc(func(page *Page) error {
+		if failFlush {
+			return errors.New("disk full")
+		}
+		flushed = append(flushed, page.ID)
+		return nil
+	})
+	c.Put(dirtyPage(0))
+	c.Put(NewPage(1))
+	c.Put(dirtyPage(2)) // evicts clean page 1 without a flush
+	c.Put(dirtyPage(3)) // flushes page 0
+	if !slices.Equal(flushed, []PageID{0}) {
+		t.Errorf("flushed %v, want [0]", flushed)
+	}
+	if _, ok := c.Get(0); ok {
+		t.Error("flushed page 0 still cached")
+	}
+	if stats := c.Stats(); stats.Size != 2 || stats.Dirty != 2 {
+		t.Errorf("after flush: %+v", stats)
+	}
+
+	// A failed flush keeps the victim dirty and caches the new page anyway
+	failFlush = true
+	if err := c.Put(dirtyPage(4)); err == nil {
+		t.Error("Put with a failing flush succeeded")
+	}
+	if stats := c.Stats(); stats.Size != 3 || stats.Dirty != 3 {
+		t.Errorf("after failed flush: %+v", stats)
+	}
+
+	c.Put(NewPage(5))
+	dirty := c.Evict()
+	var ids []PageID
+	for _, page := range dirty {
+		ids = append(ids, page.ID)
+	}
+	if !slices.Equal(ids, []PageID{2, 3, 4}) {
+		t.Errorf("Evict() = %v, want [2 3 4]", ids)
+	}
+	if stats := c.Stats(); stats.Size != 0 || stats.Dirty != 0 {
+		t.Errorf("after Evict: %+v", stats)
+	}
+}
+
+func TestStatsAndTrace(t *testing.T) {
+	type event struct {
+		op     Op
+		pageID PageID
+	}
+	var events []event
+	pm, err := New(filepath.Join(t.TempDir(), "test.db"), 2, WithTrace(func(op Op, pageID PageID, d time.Duration) {
+		if d < 0 {
+			t.Errorf("%v of page %d took %v", op, pageID, d)
+		}
+		events = append(events, event{op, pageID})
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+
+	for range 3 {
+		id, _ := pm.AllocatePage()
+		writeByte(t, pm, id, 1)
+	}
+	if _, err := pm.AllocatePages(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.FreePage(4); err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// Page 0 was pushed out of the two-page cache and is read back
+	if _, err := pm.ReadPage(0); err != nil {
+		t.Fatal(err)
+	}
+
+	stats := pm.Stats()
+	if stats.Allocations != 5 || stats.Frees != 1 || stats.DiskReads != 1 || stats.DiskWrites != 3 {
+		t.Errorf("Stats() = %+v", stats)
+	}
+	if stats.Cache.Misses != 1 || stats.Writes.Pages != 3 {
+		t.Errorf("Stats().Cache = %+v, Writes = %+v", stats.Cache, stats.Writes)
+	}
+
+	count := make(map[Op]int)
+	for _, e := range events {
+		count[e.op]++
+	}
+	want := map[Op]int{OpAllocate: 3, OpWrite: 3, OpFree: 1, OpFlush: 1, OpRead: 1, OpDiskRead: 1}
+	for op, n := range want {
+		if count[op] != n {
+			t.Errorf("%v traced %d times, want %d", op, count[op], n)
+		}
+	}
+	if count[OpDiskWrite] == 0 {
+		t.Error("no disk writes traced")
+	}
+	if last := events[len(events)-1]; last != (event{OpRead, 0}) {
+		t.Errorf("last event %v, want the read of page 0 after its disk read", last)
+	}
+	if OpDiskWrite.String() != "disk-write" {
+		t.Errorf("OpDiskWrite.String() = %q", OpDiskWrite)
+	}
+}
+
+func TestDirtyWriteBack(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	pm, err := New(path, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		id, _ := pm.AllocatePage()
+		writeByte(t, pm, id, byte('a'+i))
+	}
+	// Dirty pages are written back instead of evicted
+	if stats := pm.CacheStats(); stats.Size > 4 || stats.Dirty > 4 {
+		t.Errorf("cache stats = %+v, want at most 4 pages", stats)
+	}
+	for id := PageID(0); id < 10; id++ {
+		if page, err := pm.ReadPage(id); err != nil || page.Data[0] != byte('a'+id) {
+			t.Errorf("ReadPage(%d) = %v", id, err)
+		}
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pm, err = New(path, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	for id := PageID(0); id < 10; id++ {
+		if page, err := pm.ReadPage(id); err != nil || page.Data[0] != byte('a'+id) {
+			t.Errorf("page %d after reopen: %v", id, err)
+		}
+	}
+
+	// Flush writes adjacent dirty pages with one write
+	before := pm.WriteStats()
+	for _, id := range []PageID{7, 2, 3, 4, 9} {
+		writeByte(t, pm, id, 'z')
+	}
+	if err := pm.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	stats := pm.WriteStats()
+	if pages, writes := stats.Pages-before.Pages, stats.Writes-before.Writes; pages != 5 || writes != 3 {
+		t.Errorf("Flush wrote %d pages in %d writes, want 5 in 3", pages, writes)
+	}
+	if dirty := pm.CacheStats().Dirty; dirty != 0 {
+		t.Errorf("%d dirty pages after Flush", dirty)
+	}
+}
+
+func TestBackgroundWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	pm, err := New(path, 10, WithBackgroundWriter(0.2, 0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 20 {
+		pm.AllocatePage()
+	}
+	waitDirty := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for pm.CacheStats().Dirty > want {
+			if time.Now().After(deadline) {
+				t.Fatalf("%d dirty pages, want at most %d", pm.CacheStats().Dirty, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// Below the high watermark nothing is written
+	for id := PageID(0); id < 4; id++ {
+		writeByte(t, pm, id, 'a')
+	}
+	time.Sleep(20 * time.Millisecond)
+	if stats := pm.WriteStats(); stats.Background != 0 {
+		t.Errorf("background writer wrote %d pages below the high watermark", stats.Background)
+	}
+
+	// At 5 of 10 the writer wakes and writes down to 2
+	writeByte(t, pm, 4, 'a')
+	waitDirty(2)
+	if stats := pm.WriteStats(); stats.Background < 3 || stats.Writes >= stats.Pages {
+		t.Errorf("write stats = %+v, want coalesced background writes", stats)
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// With an interval every dirty page is written
+	pm, err = Open(path, Options{CacheSize: 10, DirtyHighWatermark: 0.9, WriterInterval: 5 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pm.Close()
+	writeByte(t, pm, 12, 'b')
+	waitDirty(0)
+	if page, err := pm.ReadPage(3); err != nil || page.Data[0] != 'a' {
+		t.Errorf("ReadPage(3) after reopen = %v", err)
+	}
+}
+
+func TestFileHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.db")
+	pm, err := New(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		pm.AllocatePage()
+	}
+	if err := pm.Close(); err != nil {
+		t.Fatal(err)
+	}
+	h, err := ReadFileHeader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (FileHeader{Version: fileVersion, PageSize: PageSize, BitmapPages: bitmapPages, NextPageID: 3}); h != want {
+		t.Errorf("ReadFileHeader() = %+v, want %+v", h, want)
+	}
+
+	// A file written with 8 KiB pages fails fast, and is left untouched
+	other := filepath.Join(t.TempDir(), "8k.db")
+	raw := make([]byte, 3*8192)
+	copy(raw[PageHeaderSize:], fileMagic)
+	raw[PageHeaderSize+4] = fileVersion
+	binary.LittleEndian.PutUint32(raw[PageHeaderSize+5:], 8192)
+	raw[24] = byte(PageTypeMeta)
+	os.WriteFile(other, raw, 0644)
+
+	_, err = New(other, 10)
+	if !errors.Is(err, ErrPageSizeMismatch) || !errors.Is(err, ErrNotPageFile) {
+		t.Errorf("New(8 KiB file) = %v", err)
+	}
+	if h, err := ReadFileHeader(other); !errors.Is(err, ErrPageSizeMismatch) || h.PageSize != 8192 {
+		t.Errorf("ReadFileHeader(8 KiB file) = %+v, %v", h, err)
+	}
+	if got, _ := os.ReadFile(other); !bytes.Equal(got, raw) {
+		t.Error("failed open changed the file")
+	}
+
+	// So does another format version
+	binary.LittleEndian.PutUint32(raw[PageHeaderSize+5:], PageSize)
+	raw[PageHeaderSize+4] = fileVersion + 1
+	os.WriteFile(other, raw, 0644)
+	if _, err := New(other, 10); !errors.Is(err, ErrNotPageFile) || errors.Is(err, ErrPageSizeMismatch) {
+		t.Errorf("New(version %d file) = %v", fileVersion+1, err)
+	}
+}
+
+func TestBatchAllocateFree(t *testing.T) {
+	pm := newTestManager(t, 10)
+
+	ids, err := pm.AllocatePages(10)
+	if err != nil || !slices.Equal(ids, []PageID{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Fatalf("AllocatePages(10) = %v, %v", ids, err)
+	}
+	if err := pm.FreePages([]PageID{2, 3, 5, 9}); err != nil {
+		t.Fatal(err)
+	}
+	// The first run of two free IDs, then a run that starts in the hole at
+	// 9 and extends the file; the single hole at 5 is passed over
+	for _, want := range [][]PageID{{2, 3}, {9, 10, 11}} {
+		if ids, err := pm.AllocatePages(len(want)); err != nil || !slices.Equal(ids, want) {
+			t.Errorf("AllocatePages(%d) = %v, %v; want %v", len(want), ids, err, want)
+		}
+	}
+	if id, _ := pm.AllocatePage(); id != 5 {
+		t.Errorf("AllocatePage() = %d, want the hole at 5", id)
+	}
+
+	// FreePages is all or nothing
+	for _, bad := range [][]PageID{{0, 1, 1}, {0, 100}, {0, 5, 5}} {
+		if err := pm.FreePages(bad); err == nil {
+			t.Errorf("FreePages(%v) succeeded", bad)
+		}
+		if _, err := pm.ReadPage(0); err != nil {
+			t.Errorf("page 0 after FreePages(%v): %v", bad, err)
+		}
+	}
+
+	// With no room for a run, the holes are filled first; an allocation
+	// the file cannot hold takes nothing
+	capacity := int(pm.meta.capacity())
+	if _, err := pm.AllocatePages(capacity - 12 - 2); err != nil {
+		t.Fatal(err)
+	}
+	pm.FreePages([]PageID{4, 7})
+	want := []PageID{4, 7, PageID(capacity - 2), PageID(capacity - 1)}
+	if ids, err := pm.AllocatePages(4); err != nil || !slices.Equal(ids, want) {
+		t.Errorf("AllocatePages(4) on a nearly full file = %v, %v; want %v", ids, err, want)
+	}
+	pm.FreePages([]PageID{1})
+	if _, err := pm.AllocatePages(2); !errors.Is(err, ErrFileFull) {
+		t.Errorf("AllocatePages past capacity = %v", err)
+	}
+	if id, err := pm.AllocatePage(); err != nil || id != 1 {
+		t.Errorf("AllocatePage() after a failed batch = %d, %v", id, err)
+	}
+}
+
+// BenchmarkAllocate allocates 100k pages one at a time and in one batch
+func BenchmarkAllocate(b *testing.B) {
+	const pages = 100_000
+	for _, batch := range []bool{false, true} {
+		name := "single"
+		if batch {
+			name = "batch"
+		}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				pm, err := New(filepath.Join(b.TempDir(), "bench.db"), 10)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if batch {
+					_, err = pm.AllocatePages(pages)
+				} else {
+					for range pages {
+						if _, err = pm.AllocatePage(); err != nil {
+							break
+						}
+					}
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StopTimer()
+				pm.Close()
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func TestDiskManager(t *testing.T) {
+	pm := newTestManager(t, 10)
+	bp := bufferpool.New(NewDiskManager(pm), bufferpool.Options{
+		PoolSize:      2,
+		PageSize:      PageDataSize,
+		FlushInterval: -1,
+	})
+
+	// More pages than frames, so some are evicted and read back
+	var ids []bufferpool.PageID
+	for i := range 5 {
+		id, frame, err := bp.NewPage()
+		if err != nil {
+			t.Fatalf("NewPage() error = %v", err)
+		}
+		frame.Data()[0] = byte(i + 1)
+		frame.Data()[PageDataSize-1] = byte(i + 1)
+		if err := bp.UnpinPage(id, true); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	for i, id := range ids {
+		frame, err := bp.FetchPage(id)
+		if err != nil {
+			t.Fatalf("FetchPage(%d) error = %v", id, err)
+		}
+		if d := frame.Data(); d[0] != byte(i+1) || d[PageDataSize-1] != byte(i+1) {
+			t.Errorf("page %d: data %d..%d, want %d", id, d[0], d[PageDataSize-1], i+1)
+		}
+		bp.UnpinPage(id, false)
+	}
+	if err := bp.DeletePage(ids[4]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pm.ReadPage(PageID(ids[4])); !errors.Is(err, ErrPageNotAllocated) {
+		t.Errorf("ReadPage of a deleted page = %v", err)
+	}
+	if err := bp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	page, err := pm.ReadPage(PageID(ids[2]))
+	if err != nil || page.Data[0] != 3 || page.Type != PageTypeRaw {
+		t.Fatalf("page written through the pool: %v, %v", page, err)
+	}
+
+	dm := NewDiskManager(pm)
+	if err := dm.ReadPage(-1, make([]byte, 16)); !errors.Is(err, ErrInvalidPageID) {
+		t.Errorf("ReadPage(-1) = %v", err)
+	}
+	if err := dm.WritePage(ids[0], make([]byte, PageSize)); !errors.Is(err, ErrFrameTooLarge) {
+		t.Errorf("WritePage of a PageSize frame = %v", err)
+	}
+}
+
+func TestAsyncIO(t *testing.T) {
+	for _, kind := range []BackendKind{BackendPool, BackendIOUring} {
+		filename := filepath.Join(t.TempDir(), "async.db")
+		pm, err := Open(filename, Options{CacheSize: 4, Backend: kind, IODepth: 4})
+		if errors.Is(err, ErrIOUringUnsupported) {
+			t.Log("io_uring unsupported, skipped")
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		ids, err := pm.AllocatePages(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// More pages than the ring has entries, in one batch
+		pages := make([]*Page, len(ids))
+		for i, id := range ids {
+			pages[i] = NewPage(id)
+			pages[i].Data[0] = byte(i + 1)
+		}
+		if err := <-pm.WritePagesAsync(pages); err != nil {
+			t.Fatalf("%v: WritePagesAsync() error = %v", kind, err)
+		}
+		if n := pm.cache.DirtyCount(); n != 0 {
+			t.Errorf("%v: %d dirty pages after the writes landed", kind, n)
+		}
+		if name := pm.backend.Name(); kind == BackendIOUring && name != "io_uring" || kind == BackendPool && name != "pool" {
+			t.Errorf("%v: backend %q", kind, name)
+		}
+
+		results := <-pm.ReadPagesAsync(append(slices.Clone(ids), 99))
+		for i, id := range ids {
+			if r := results[i]; r.Err != nil || r.Page.ID != id || r.Page.Data[0] != byte(i+1) {
+				t.Errorf("%v: page %d: %v, %v", kind, id, r.Page, r.Err)
+			}
+		}
+		if err := results[len(ids)].Err; !errors.Is(err, ErrPageNotAllocated) && !errors.Is(err, ErrInvalidPageID) {
+			t.Errorf("%v: read of an unallocated page = %v", kind, err)
+		}
+		if err := <-pm.WritePagesAsync([]*Page{NewPage(99)}); err == nil {
+			t.Errorf("%v: write of an unallocated page succeeded", kind)
+		}
+
+		// Cached pages are not read again
+		if _, err := pm.ReadPage(ids[0]); err != nil {
+			t.Fatal(err)
+		}
+		before := pm.Stats()
+		if r := (<-pm.ReadPagesAsync(ids[:1]))[0]; r.Err != nil {
+			t.Fatal(r.Err)
+		}
+		after := pm.Stats()
+		if after.DiskReads != before.DiskReads || after.Cache.Hits != before.Cache.Hits+1 {
+			t.Errorf("%v: cached read: stats %+v then %+v", kind, before, after)
+		}
+
+		if err := pm.Close(); err != nil {
+			t.Fatal(err)
+		}
+		pm, err = Open(filename, Options{Backend: kind})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, id := range ids {
+			page, err := pm.ReadPage(id)
+			if err != nil || page.Data[0] != byte(i+1) {
+				t.Errorf("%v: page %d after reopen: %v, %v", kind, id, page, err)
+			}
+		}
+
+		// Under a buffer pool, through DiskManager
+		bp := bufferpool.New(NewDiskManager(pm), bufferpool.Options{
+			PoolSize:      len(ids),
+			PageSize:      PageDataSize,
+			FlushInterval: -1,
+		})
+		poolIDs := make([]bufferpool.PageID, len(ids))
+		for i, id := range ids {
+			poolIDs[i] = bufferpool.PageID(id)
+		}
+		if err := bp.Prefetch(poolIDs); err != nil {
+			t.Fatalf("%v: Prefetch() error = %v", kind, err)
+		}
+		for i, id := range poolIDs {
+			frame, err := bp.FetchPage(id)
+			if err != nil || frame.Data()[0] != byte(i+1) {
+				t.Fatalf("%v: prefetched page %d: %v", kind, id, err)
+			}
+			frame.Data()[0] = byte(100 + i)
+			bp.UnpinPage(id, true)
+		}
+		if err := bp.FlushAll(); err != nil {
+			t.Fatalf("%v: FlushAll() error = %v", kind, err)
+		}
+		if err := bp.Close(); err != nil {
+			t.Fatal(err)
+		}
+		for i, id := range ids {
+			page, err := pm.ReadPage(id)
+			if err != nil || page.Data[0] != byte(100+i) {
+				t.Errorf("%v: page %d flushed by the pool: %v, %v", kind, id, page, err)
+			}
+		}
+		if err := pm.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package pagemanager
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Errors
+var (
+	ErrNotPageFile = errors.New("not a page manager file")
+	ErrCorruptMeta = errors.New("corrupt file header or free bitmap")
+	ErrFileFull    = errors.New("free bitmap is full")
+	// ErrPageSizeMismatch is returned for a file written with a page size
+	// other than PageSize; it also matches ErrNotPageFile
+	ErrPageSizeMismatch = fmt.Errorf("%w: page size mismatch", ErrNotPageFile)
+)
+
+const (
+	fileMagic   = "PGMF"
+	fileVersion = 1
+
+	// bitmapPages is the number of free bitmap pages a new file reserves.
+	// Each tracks bitsPerBitmapPage pages, so a file can hold about 1 GiB.
+	bitmapPages = 8
+
+	bitsPerBitmapPage = PageDataSize * 8
+)
+
+// The first pages of the file are meta pages, which page IDs do not
+// address: the header at file page 0, then the free bitmap pages. Page ID
+// n is stored at file page metaPages+n.
+//
+// Header data: Magic "PGMF"(4) + Version(1) + PageSize(4) +
+// BitmapPages(2) + NextPageID(8). Bit n of the bitmap, spread over the
+// bitmap pages, is set while page n is allocated.
+type fileMeta struct {
+	bitmapPages int
+	// headerDirty and bitmapDirty mark meta pages changed since the last
+	// flush; bitmapDirty is indexed by bitmap page
+	headerDirty bool
+	bitmapDirty map[int]bool
+}
+
+// metaPages returns the number of file pages before page ID 0
+func (m *fileMeta) metaPages() int {
+	return 1 + m.bitmapPages
+}
+
+// capacity returns the number of pages the bitmap can track
+func (m *fileMeta) capacity() PageID {
+	return PageID(m.bitmapPages * bitsPerBitmapPage)
+}
+
+// markAllocation records that the bit for pageID changed
+func (m *fileMeta) markAllocation(pageID PageID) {
+	m.bitmapDirty[int(pageID)/bitsPerBitmapPage] = true
+}
+
+// initMetaLocked sets up the meta pages of an empty file. They are written
+// by the next flush.
+func (pm *PageManager) initMetaLocked() {
+	pm.meta = fileMeta{
+		bitmapPages: bitmapPages,
+		headerDirty: true,
+		bitmapDirty: make(map[int]bool),
+	}
+	for i := range bitmapPages {
+		pm.meta.bitmapDirty[i] = true
+	}
+}
+
+// FileHeader is the header of a page file
+type FileHeader struct {
+	Version     int
+	PageSize    int
+	BitmapPages int
+	NextPageID  PageID
+}
+
+// ReadFileHeader reads the header of the page file at path without opening
+// it, so a caller can check the page size and format version first. The
+// header's checksum is verified only if the page size matches, since it
+// covers a whole page.
+func ReadFileHeader(path string) (FileHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return FileHeader{}, err
+	}
+	defer f.Close()
+	buf := make([]byte, PageSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return FileHeader{}, fmt.Errorf("%w: %v", ErrNotPageFile, err)
+	}
+	h, err := parseFileHeader(buf)
+	if err != nil {
+		return h, err
+	}
+	page := NewPage(0)
+	if err := page.Unmarshal(buf); err != nil {
+		return h, err
+	}
+	if page.Type != PageTypeMeta || !page.Validate() {
+		return h, fmt.Errorf("%w: meta page 0", ErrCorruptMeta)
+	}
+	return h, nil
+}
+
+// parseFileHeader decodes the header fields of a raw header page. The
+// magic, version and page size are checked before the checksum, so a
+// file with other pages fails as such rather than as corrupt.
+func parseFileHeader(raw []byte) (FileHeader, error) {
+	data := raw[PageHeaderSize:]
+	if string(data[0:4]) != fileMagic {
+		return FileHeader{}, ErrNotPageFile
+	}
+	h := FileHeader{
+		Version:     int(data[4]),
+		PageSize:    int(binary.LittleEndian.Uint32(data[5:9])),
+		BitmapPages: int(binary.LittleEndian.Uint16(data[9:11])),
+		NextPageID:  PageID(binary.LittleEndian.Uint64(data[11:19])),
+	}
+	if h.Version != fileVersion {
+		return h, fmt.Errorf("%w: unsupported version %d", ErrNotPageFile, h.Version)
+	}
+	if h.PageSize != PageSize {
+		return h, fmt.Errorf("%w: file has %d-byte pages, this build uses %d", ErrPageSizeMismatch, h.PageSize, PageSize)
+	}
+	return h, nil
+}
+
+// loadMetaLocked reads the header and free bitmap of an existing file
+func (pm *PageManager) loadMetaLocked() error {
+	raw := pm.pageBuffer()
+	if _, err := pm.file.ReadAt(raw, 0); err != nil {
+		return fmt.Errorf("%w: %v", ErrNotPageFile, err)
+	}
+	h, err := parseFileHeader(raw)
+	if err != nil {
+		return err
+	}
+	if _, err := pm.readMetaPage(0); err != nil {
+		return err
+	}
+	pm.meta = fileMeta{
+		bitmapPages: h.BitmapPages,
+		bitmapDirty: make(map[int]bool),
+	}
+	pm.nextPageID = h.NextPageID
+	if pm.nextPageID > pm.meta.capacity() {
+		return fmt.Errorf("%w: %d pages, bitmap holds %d", ErrCorruptMeta, pm.nextPageID, pm.meta.capacity())
+	}
+
+	pm.freeBitmap = NewBitmap(max(int(pm.nextPageID), 1000))
+	for i := 0; i*bitsPerBitmapPage < int(pm.nextPageID); i++ {
+		page, err := pm.readMetaPage(1 + i)
+		if err != nil {
+			return err
+		}
+		copy(pm.freeBitmap.bits[i*PageDataSize:], page.Data[:])
+	}
+	// Bits past the high-water mark are never set
+	for n := int(pm.nextPageID); n < pm.freeBitmap.Size(); n++ {
+		pm.freeBitmap.Clear(n)
+	}
+	return nil
+}
+
+// readMetaPage reads and validates the meta page at the given file page
+func (pm *PageManager) readMetaPage(filePage int) (*Page, error) {
+	buf := pm.pageBuffer()
+	if _, err := pm.file.ReadAt(buf, int64(filePage)*int64(pm.pageSize)); err != nil {
+		return nil, fmt.Errorf("%w: meta page %d: %v", ErrCorruptMeta, filePage, err)
+	}
+	page := NewPage(PageID(filePage))
+	if err := page.Unmarshal(buf); err != nil {
+		return nil, err
+	}
+	if page.Type != PageTypeMeta || !page.Validate() {
+		if filePage == 0 && page.Type != PageTypeMeta {
+			return nil, ErrNotPageFile
+		}
+		return nil, fmt.Errorf("%w: meta page %d", ErrCorruptMeta, filePage)
+	}
+	return page, nil
+}
+
+// flushMetaLocked writes the meta pages changed since the last flush and
+// syncs them. Flush calls it before writing data pages, so a page that
+// reaches disk is never marked free there. Caller holds pm.mu exclusively.
+func (pm *PageManager) flushMetaLocked() error {
+	if !pm.meta.headerDirty && len(pm.meta.bitmapDirty) == 0 {
+		return nil
+	}
+	for i := range pm.meta.bitmapDirty {
+		page := NewPage(PageID(1 + i))
+		page.Type = PageTypeMeta
+		if start := i * PageDataSize; start < len(pm.freeBitmap.bits) {
+			copy(page.Data[:], pm.freeBitmap.bits[start:])
+		}
+		if err := pm.writeFilePage(1+i, page); err != nil {
+			return err
+		}
+	}
+
+	header := NewPage(0)
+	header.Type = PageTypeMeta
+	copy(header.Data[0:4], fileMagic)
+	header.Data[4] = fileVersion
+	binary.LittleEndian.PutUint32(header.Data[5:9], PageSize)
+	binary.LittleEndian.PutUint16(header.Data[9:11], uint16(pm.meta.bitmapPages))
+	binary.LittleEndian.PutUint64(header.Data[11:19], uint64(pm.nextPageID))
+	if err := pm.writeFilePage(0, header); err != nil {
+		return err
+	}
+	if err := pm.file.Sync(); err != nil {
+		return err
+	}
+
+	pm.meta.headerDirty = false
+	clear(pm.meta.bitmapDirty)
+	return nil
+}
+
+// writeFilePage writes page at the given file page
+func (pm *PageManager) writeFilePage(filePage int, page *Page) error {
+	buf := pm.pageBuffer()
+	page.marshalTo(buf)
+	_, err := pm.file.WriteAt(buf, int64(filePage)*int64(pm.pageSize))
+	return err
+}
@@ -0,0 +1,80 @@
+package pagemanager
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Errors
+var (
+	ErrMmapUnsupported = errors.New("mmap not supported on this platform")
+	ErrNotMapped       = errors.New("page manager is not memory-mapped")
+)
+
+// Option changes the Options New opens a page manager with
+type Option func(*Options)
+
+// WithMmap selects the memory-mapped backend (Options.Mmap)
+func WithMmap() Option {
+	return func(o *Options) { o.Mmap = true }
+}
+
+// mapLocked maps the meta pages and every page the bitmap can track, so
+// the mapping never moves as the file grows. Only the part the file
+// covers is touched.
+func (pm *PageManager) mapLocked() error {
+	size := pm.pageOffset(pm.meta.capacity())
+	mapping, err := mapFile(pm.file, int(size))
+	if err != nil {
+		return err
+	}
+	pm.mapping = mapping
+	return nil
+}
+
+// pageBytes returns the bytes of a page in the mapping
+func (pm *PageManager) pageBytes(pageID PageID) []byte {
+	off := pm.pageOffset(pageID)
+	return pm.mapping[off : off+int64(pm.pageSize) : off+int64(pm.pageSize)]
+}
+
+// ReadPageView returns the data of a page as a view of the mapped file,
+// without copying it. The checksum is verified as by ReadPage. The view
+// is read-only and reflects later WritePage calls; it must not be used
+// after the page is freed or moved by Compact, or after Close.
+func (pm *PageManager) ReadPageView(pageID PageID) ([]byte, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	if pm.mapping == nil {
+		return nil, ErrNotMapped
+	}
+	if err := pm.checkAllocatedLocked(pageID); err != nil {
+		return nil, err
+	}
+	if pageID >= pm.filePages {
+		return nil, ErrInvalidPageID
+	}
+	buf := pm.pageBytes(pageID)
+	data := buf[PageHeaderSize:]
+	if isZero(buf) {
+		return data, nil
+	}
+	stored := binary.LittleEndian.Uint64(buf[8:16])
+	if stored != checksumOf(buf[:PageHeaderSize], data) {
+		pm.quarantine.add(pageID)
+		return nil, &ErrChecksumMismatch{PageID: pageID}
+	}
+	return data, nil
+}
+
+// isZero reports whether every byte of b is zero, as in a page that was
+// never written
+func isZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,17 @@
+//go:build !(linux || darwin)
+
+package pagemanager
+
+import "os"
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, ErrMmapUnsupported
+}
+
+func unmapFile(b []byte) error {
+	return nil
+}
+
+func msync(b []byte) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+//go:build linux || darwin
+
+package pagemanager
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// mapFile maps size bytes of f, shared, for reading and writing. The
+// mapping may extend past the end of the file; only the part the file
+// covers may be touched.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+func unmapFile(b []byte) error {
+	return syscall.Munmap(b)
+}
+
+// msync writes the modified pages of b back to the file and waits for it
+func msync(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
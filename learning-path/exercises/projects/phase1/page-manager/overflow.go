@@ -0,0 +1,135 @@
+package pagemanager
+
+import "errors"
+
+// ErrBrokenChain is returned when an overflow chain does not lead through
+// allocated overflow pages to its end
+var ErrBrokenChain = errors.New("broken overflow chain")
+
+// AllocateChainedPages allocates n pages linked into an overflow chain and
+// returns their IDs in chain order. Each page is typed PageTypeOverflow,
+// empty, and points to the next through NextOverflow; the last points to
+// InvalidPageID.
+func (pm *PageManager) AllocateChainedPages(n int) ([]PageID, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	ids := make([]PageID, n)
+	for i := range ids {
+		id, err := pm.allocateLocked()
+		if err != nil {
+			for _, id := range ids[:i] {
+				pm.freeBitmap.Clear(int(id))
+				pm.meta.markAllocation(id)
+			}
+			return nil, err
+		}
+		ids[i] = id
+	}
+	for i, id := range ids {
+		page := NewPage(id)
+		page.Type = PageTypeOverflow
+		if i+1 < n {
+			page.NextOverflow = ids[i+1]
+		}
+		if err := pm.putLocked(page); err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
+
+// WriteChained stores payload in a new overflow chain and returns the ID
+// of its first page
+func (pm *PageManager) WriteChained(payload []byte) (PageID, error) {
+	n := max(1, (len(payload)+PageDataSize-1)/PageDataSize)
+	ids, err := pm.AllocateChainedPages(n)
+	if err != nil {
+		return InvalidPageID, err
+	}
+	for i, id := range ids {
+		page, err := pm.ReadPage(id)
+		if err != nil {
+			return InvalidPageID, err
+		}
+		chunk := payload[min(i*PageDataSize, len(payload)):min((i+1)*PageDataSize, len(payload))]
+		page.FreeOffset = uint16(copy(page.Data[:], chunk))
+		if err := pm.WritePage(page); err != nil {
+			return InvalidPageID, err
+		}
+	}
+	return ids[0], nil
+}
+
+// ReadChained returns the payload of the overflow chain starting at first:
+// the used bytes of every page, in chain order
+func (pm *PageManager) ReadChained(first PageID) ([]byte, error) {
+	pages, err := pm.ChainPages(first)
+	if err != nil {
+		return nil, err
+	}
+	var payload []byte
+	for _, page := range pages {
+		payload = append(payload, page.Data[:page.FreeOffset]...)
+	}
+	return payload, nil
+}
+
+// ChainPages returns the pages of the overflow chain starting at first
+func (pm *PageManager) ChainPages(first PageID) ([]*Page, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	var pages []*Page
+	for id := first; id != InvalidPageID; {
+		// A chain longer than the file must loop
+		if PageID(len(pages)) >= pm.nextPageID {
+			return nil, ErrBrokenChain
+		}
+		if err := pm.checkAllocatedLocked(id); err != nil {
+			return nil, errors.Join(ErrBrokenChain, err)
+		}
+		page, err := pm.currentPageLocked(id)
+		if err != nil {
+			return nil, err
+		}
+		if page.Type != PageTypeOverflow || page.FreeSpace() < 0 {
+			return nil, ErrBrokenChain
+		}
+		pages = append(pages, page.clone())
+		id = page.NextOverflow
+	}
+	return pages, nil
+}
+
+// FreeChain frees every page of the overflow chain starting at first
+func (pm *PageManager) FreeChain(first PageID) error {
+	pages, err := pm.ChainPages(first)
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		if err := pm.FreePage(page.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindFreeSpace returns the lowest-numbered PageTypeData page with at least
+// n free bytes, according to the free-space map
+func (pm *PageManager) FindFreeSpace(n int) (PageID, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	found := InvalidPageID
+	for id, free := range pm.freeSpace {
+		if free >= n && id < found {
+			found = id
+		}
+	}
+	return found, found != InvalidPageID
+}
@@ -2,6 +2,7 @@ package pagemanager
 
 import (
 	"encoding/binary"
+	"errors"
 	"hash/crc64"
 )
 
@@ -11,31 +12,92 @@ const (
 	PageDataSize   = PageSize - PageHeaderSize
 )
 
+// ErrShortPage is returned when unmarshaling fewer than PageSize bytes
+var ErrShortPage = errors.New("short page buffer")
+
 // PageID represents a unique page identifier
 type PageID uint64
 
+// InvalidPageID marks the end of an overflow chain
+const InvalidPageID = ^PageID(0)
+
+// PageType says how the data of a page is laid out
+type PageType uint8
+
+const (
+	// PageTypeRaw pages are opaque blobs; the manager does not interpret
+	// their data or free-space offset
+	PageTypeRaw PageType = iota
+	// PageTypeData pages hold variable-length records through a
+	// SlottedPage and are tracked by the free-space map
+	PageTypeData
+	// PageTypeOverflow pages hold a slice of a payload that spans several
+	// pages, linked through NextOverflow
+	PageTypeOverflow
+	// PageTypeMeta pages hold the file header and the free bitmap. They
+	// come before page 0 in the file and have no page ID.
+	PageTypeMeta
+)
+
 // Page represents a single page in the database
 type Page struct {
-	ID       PageID
-	Data     [PageDataSize]byte
-	Dirty    bool
-	Pinned   bool
-	checksum uint64
+	ID   PageID
+	Type PageType
+	// FreeOffset is where the free space in Data begins
+	FreeOffset uint16
+	// SlotCount is the number of entries in the slot directory at the end
+	// of Data, for PageTypeData pages
+	SlotCount uint16
+	// NextOverflow is the next page of an overflow chain, or InvalidPageID
+	NextOverflow PageID
+	Data         [PageDataSize]byte
+	Dirty        bool
+	Pinned       bool
+	checksum     uint64
 }
 
 // NewPage creates a new page with the given ID
 func NewPage(id PageID) *Page {
 	return &Page{
-		ID:     id,
-		Dirty:  false,
-		Pinned: false,
+		ID:           id,
+		NextOverflow: InvalidPageID,
+		Dirty:        false,
+		Pinned:       false,
 	}
 }
 
-// ComputeChecksum computes the CRC64 checksum of the page data
+// FreeSpace returns the number of free bytes between FreeOffset and the
+// slot directory
+func (p *Page) FreeSpace() int {
+	return PageDataSize - int(p.FreeOffset) - slotSize*int(p.SlotCount)
+}
+
+// ComputeChecksum computes the CRC64 checksum of the page header fields
+// and data
 func (p *Page) ComputeChecksum() uint64 {
+	return computeChecksum(p.Type, p.FreeOffset, p.NextOverflow, p.SlotCount, p.Data[:])
+}
+
+// checksumOf computes the checksum of a marshaled page from its header and
+// data, without unmarshaling it
+func checksumOf(header, data []byte) uint64 {
+	return computeChecksum(
+		PageType(header[24]),
+		binary.LittleEndian.Uint16(header[16:18]),
+		PageID(binary.LittleEndian.Uint64(header[40:48]))-1,
+		binary.LittleEndian.Uint16(header[48:50]),
+		data,
+	)
+}
+
+func computeChecksum(typ PageType, freeOffset uint16, next PageID, slots uint16, data []byte) uint64 {
 	table := crc64.MakeTable(crc64.ISO)
-	return crc64.Checksum(p.Data[:], table)
+	var header [13]byte
+	header[0] = byte(typ)
+	binary.LittleEndian.PutUint16(header[1:3], freeOffset)
+	binary.LittleEndian.PutUint64(header[3:11], uint64(next))
+	binary.LittleEndian.PutUint16(header[11:13], slots)
+	return crc64.Update(crc64.Checksum(header[:], table), table, data)
 }
 
 // Validate checks if the page checksum is valid
@@ -46,22 +108,43 @@ func (p *Page) Validate() bool {
 // Marshal serializes the page to bytes
 func (p *Page) Marshal() []byte {
 	buf := make([]byte, PageSize)
+	p.marshalTo(buf)
+	return buf
+}
 
-	// Header
+// marshalTo serializes the page into buf, which holds PageSize bytes
+func (p *Page) marshalTo(buf []byte) {
+	// Header. The next overflow page is stored plus one, so a zeroed
+	// page has no successor.
 	binary.LittleEndian.PutUint64(buf[0:8], uint64(p.ID))
 	binary.LittleEndian.PutUint64(buf[8:16], p.ComputeChecksum())
+	binary.LittleEndian.PutUint16(buf[16:18], p.FreeOffset)
+	buf[24] = byte(p.Type)
+	binary.LittleEndian.PutUint64(buf[40:48], uint64(p.NextOverflow+1))
+	binary.LittleEndian.PutUint16(buf[48:50], p.SlotCount)
 
 	// Data
 	copy(buf[PageHeaderSize:], p.Data[:])
-
-	return buf
 }
 
 // Unmarshal deserializes bytes into a page
 func (p *Page) Unmarshal(data []byte) error {
-	// TODO: Implement deserialization
-	// - Read header fields
-	// - Copy data
-	// - Validate checksum
+	if len(data) < PageSize {
+		return ErrShortPage
+	}
+
+	p.ID = PageID(binary.LittleEndian.Uint64(data[0:8]))
+	p.checksum = binary.LittleEndian.Uint64(data[8:16])
+	p.FreeOffset = binary.LittleEndian.Uint16(data[16:18])
+	p.Type = PageType(data[24])
+	p.NextOverflow = PageID(binary.LittleEndian.Uint64(data[40:48])) - 1
+	p.SlotCount = binary.LittleEndian.Uint16(data[48:50])
+	copy(p.Data[:], data[PageHeaderSize:PageSize])
 	return nil
 }
+
+// clone returns a copy of the page that shares no memory with p
+func (p *Page) clone() *Page {
+	cp := *p
+	return &cp
+}
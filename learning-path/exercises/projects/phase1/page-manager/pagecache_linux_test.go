@@ -0,0 +1,40 @@
+package pagemanager
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// pageCacheBytes returns how much of the file at path is in the OS page
+// cache, using mincore on a mapping of it
+func pageCacheBytes(path string) (int64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.Size() == 0 {
+		return 0, false
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(info.Size()), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return 0, false
+	}
+	defer syscall.Munmap(data)
+
+	pageSize := os.Getpagesize()
+	vec := make([]byte, (len(data)+pageSize-1)/pageSize)
+	_, _, errno := syscall.Syscall(syscall.SYS_MINCORE, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), uintptr(unsafe.Pointer(&vec[0])))
+	if errno != 0 {
+		return 0, false
+	}
+	var resident int64
+	for _, v := range vec {
+		if v&1 != 0 {
+			resident += int64(pageSize)
+		}
+	}
+	return resident, true
+}
@@ -0,0 +1,7 @@
+//go:build !linux
+
+package pagemanager
+
+func pageCacheBytes(path string) (int64, bool) {
+	return 0, false
+}
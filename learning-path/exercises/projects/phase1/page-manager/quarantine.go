@@ -0,0 +1,53 @@
+package pagemanager
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// ErrChecksumMismatch is returned when a page read from disk fails its
+// checksum, for example after a torn write or bit rot
+type ErrChecksumMismatch struct {
+	PageID PageID
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("page %d: checksum mismatch", e.PageID)
+}
+
+// quarantine is the set of pages that failed their checksum. It has its
+// own lock because pages are read under pm.mu held shared.
+type quarantine struct {
+	mu    sync.Mutex
+	pages map[PageID]struct{}
+}
+
+func (q *quarantine) add(pageID PageID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.pages == nil {
+		q.pages = make(map[PageID]struct{})
+	}
+	q.pages[pageID] = struct{}{}
+}
+
+func (q *quarantine) remove(pageID PageID) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.pages, pageID)
+}
+
+// CorruptPages returns, in order, the pages that failed their checksum on
+// read. A page leaves the set when it is overwritten with WritePage or
+// freed.
+func (pm *PageManager) CorruptPages() []PageID {
+	pm.quarantine.mu.Lock()
+	defer pm.quarantine.mu.Unlock()
+	pages := make([]PageID, 0, len(pm.quarantine.pages))
+	for id := range pm.quarantine.pages {
+		pages = append(pages, id)
+	}
+	slices.Sort(pages)
+	return pages
+}
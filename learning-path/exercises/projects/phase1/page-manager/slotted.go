@@ -0,0 +1,199 @@
+package pagemanager
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Errors
+var (
+	ErrNotSlotted    = errors.New("page is not a slotted data page")
+	ErrPageFull      = errors.New("not enough free space in page")
+	ErrInvalidSlot   = errors.New("invalid slot")
+	ErrRecordDeleted = errors.New("record deleted")
+)
+
+// SlotID identifies a record within a slotted page. It stays the same
+// when the page is compacted.
+type SlotID uint16
+
+const (
+	// slotSize is Offset(2) + Length(2)
+	slotSize = 4
+
+	// deletedSlot is the offset of a slot whose record was deleted
+	deletedSlot = 0xffff
+)
+
+// SlottedPage stores variable-length records in a PageTypeData page.
+// Records are packed from the start of Data up to FreeOffset; the slot
+// directory grows backwards from the end of Data, one entry per slot, and
+// the header's SlotCount says how long it is. Deleted records leave holes
+// until Compact (or an insert that needs the room) moves the live records
+// together.
+//
+// A SlottedPage edits its Page in place; write the page back with
+// PageManager.WritePage to keep the changes.
+type SlottedPage struct {
+	page *Page
+}
+
+// NewSlottedPage formats a new, empty slotted page with the given ID
+func NewSlottedPage(id PageID) *SlottedPage {
+	page := NewPage(id)
+	page.Type = PageTypeData
+	return &SlottedPage{page: page}
+}
+
+// AsSlotted returns a slotted view of page, which must be a PageTypeData
+// page, such as one read back with PageManager.ReadPage
+func AsSlotted(page *Page) (*SlottedPage, error) {
+	if page.Type != PageTypeData || page.FreeSpace() < 0 {
+		return nil, ErrNotSlotted
+	}
+	return &SlottedPage{page: page}, nil
+}
+
+// Page returns the underlying page
+func (sp *SlottedPage) Page() *Page {
+	return sp.page
+}
+
+// NumSlots returns the number of slots, including those of deleted records
+func (sp *SlottedPage) NumSlots() int {
+	return int(sp.page.SlotCount)
+}
+
+// FreeSpace returns the largest record InsertRecord can store, counting
+// the space Compact would reclaim
+func (sp *SlottedPage) FreeSpace() int {
+	free := sp.page.FreeSpace() + sp.holes()
+	if sp.freeSlot() < 0 {
+		free -= slotSize
+	}
+	return max(free, 0)
+}
+
+// InsertRecord stores data and returns its slot. The slot of a deleted
+// record is reused before the directory grows. If the free space is
+// fragmented the page is compacted first.
+func (sp *SlottedPage) InsertRecord(data []byte) (SlotID, error) {
+	if len(data) > sp.FreeSpace() {
+		return 0, ErrPageFull
+	}
+	slot := sp.freeSlot()
+	need := len(data)
+	if slot < 0 {
+		need += slotSize
+	}
+	if sp.page.FreeSpace() < need {
+		sp.Compact()
+	}
+	if slot < 0 {
+		slot = int(sp.page.SlotCount)
+		sp.page.SlotCount++
+	}
+
+	offset := sp.page.FreeOffset
+	copy(sp.page.Data[offset:], data)
+	sp.page.FreeOffset += uint16(len(data))
+	sp.setSlot(SlotID(slot), offset, uint16(len(data)))
+	sp.page.Dirty = true
+	return SlotID(slot), nil
+}
+
+// GetRecord returns the record in slot. The result aliases the page.
+func (sp *SlottedPage) GetRecord(slot SlotID) ([]byte, error) {
+	offset, length, err := sp.slot(slot)
+	if err != nil {
+		return nil, err
+	}
+	return sp.page.Data[offset : offset+length : offset+length], nil
+}
+
+// DeleteRecord deletes the record in slot. Its space is reclaimed by the
+// next compaction and its slot by the next insert.
+func (sp *SlottedPage) DeleteRecord(slot SlotID) error {
+	if _, _, err := sp.slot(slot); err != nil {
+		return err
+	}
+	sp.setSlot(slot, deletedSlot, 0)
+	sp.page.Dirty = true
+	return nil
+}
+
+// Compact moves the live records to the start of Data, in slot order, so
+// that the free space is contiguous. Slot IDs do not change.
+func (sp *SlottedPage) Compact() {
+	var compacted [PageDataSize]byte
+	// Keep the directory, including the slots of deleted records
+	dir := PageDataSize - slotSize*int(sp.page.SlotCount)
+	copy(compacted[dir:], sp.page.Data[dir:])
+
+	end := 0
+	for i := range sp.page.SlotCount {
+		offset, length, err := sp.slot(SlotID(i))
+		if err != nil {
+			continue
+		}
+		copy(compacted[end:], sp.page.Data[offset:offset+length])
+		setSlotIn(&compacted, SlotID(i), uint16(end), uint16(length))
+		end += length
+	}
+	sp.page.Data = compacted
+	sp.page.FreeOffset = uint16(end)
+	sp.page.Dirty = true
+}
+
+// slot returns the offset and length of the live record in slot
+func (sp *SlottedPage) slot(slot SlotID) (offset, length int, err error) {
+	if slot >= SlotID(sp.page.SlotCount) {
+		return 0, 0, ErrInvalidSlot
+	}
+	pos := slotPos(slot)
+	o := binary.LittleEndian.Uint16(sp.page.Data[pos:])
+	l := binary.LittleEndian.Uint16(sp.page.Data[pos+2:])
+	if o == deletedSlot {
+		return 0, 0, ErrRecordDeleted
+	}
+	if int(o)+int(l) > int(sp.page.FreeOffset) {
+		return 0, 0, ErrInvalidSlot
+	}
+	return int(o), int(l), nil
+}
+
+func (sp *SlottedPage) setSlot(slot SlotID, offset, length uint16) {
+	setSlotIn(&sp.page.Data, slot, offset, length)
+}
+
+func setSlotIn(data *[PageDataSize]byte, slot SlotID, offset, length uint16) {
+	pos := slotPos(slot)
+	binary.LittleEndian.PutUint16(data[pos:], offset)
+	binary.LittleEndian.PutUint16(data[pos+2:], length)
+}
+
+// slotPos returns the position of a slot's entry in Data
+func slotPos(slot SlotID) int {
+	return PageDataSize - slotSize*(int(slot)+1)
+}
+
+// freeSlot returns the first slot of a deleted record, or -1
+func (sp *SlottedPage) freeSlot() int {
+	for i := range int(sp.page.SlotCount) {
+		if _, _, err := sp.slot(SlotID(i)); errors.Is(err, ErrRecordDeleted) {
+			return i
+		}
+	}
+	return -1
+}
+
+// holes returns the bytes below FreeOffset not used by a live record
+func (sp *SlottedPage) holes() int {
+	used := 0
+	for i := range int(sp.page.SlotCount) {
+		if _, length, err := sp.slot(SlotID(i)); err == nil {
+			used += length
+		}
+	}
+	return int(sp.page.FreeOffset) - used
+}
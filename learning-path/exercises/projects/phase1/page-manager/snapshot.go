@@ -0,0 +1,180 @@
+package pagemanager
+
+import "errors"
+
+// ErrSnapshotReleased is returned when reading through a released snapshot
+var ErrSnapshotReleased = errors.New("snapshot released")
+
+// SnapshotID names an active snapshot, for callers that keep snapshots by
+// ID rather than holding the *Snapshot, e.g. across an RPC boundary
+type SnapshotID uint64
+
+// Snapshot is a consistent, read-only view of the pages as they were when
+// BeginSnapshot was called. Writers are never blocked: the first write or
+// free of a page after the snapshot began copies the old image into every
+// active snapshot that has not captured it yet (copy-on-write).
+type Snapshot struct {
+	pm         *PageManager
+	id         SnapshotID
+	version    uint64
+	nextPageID PageID
+	allocated  *Bitmap
+	images     map[PageID]*Page
+	released   bool
+}
+
+// BeginSnapshot starts a snapshot of the current page contents. Call
+// Release when done so preserved page images can be dropped.
+func (pm *PageManager) BeginSnapshot() *Snapshot {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	allocated := NewBitmap(pm.freeBitmap.Size())
+	copy(allocated.bits, pm.freeBitmap.bits)
+
+	pm.nextSnapID++
+	snap := &Snapshot{
+		pm:         pm,
+		id:         pm.nextSnapID,
+		version:    pm.writeSeq,
+		nextPageID: pm.nextPageID,
+		allocated:  allocated,
+		images:     make(map[PageID]*Page),
+	}
+	pm.snapshots[snap.id] = snap
+	return snap
+}
+
+// preserveLocked captures the current image of pageID into every active
+// snapshot that can see the page and has not captured it yet. Caller holds
+// pm.mu exclusively.
+func (pm *PageManager) preserveLocked(pageID PageID) error {
+	var current *Page
+	for _, snap := range pm.snapshots {
+		if !snap.visible(pageID) {
+			continue
+		}
+		if _, ok := snap.images[pageID]; ok {
+			continue
+		}
+		if current == nil {
+			page, err := pm.currentPageLocked(pageID)
+			if err != nil {
+				return err
+			}
+			current = page.clone()
+			current.Dirty = false
+		}
+		snap.images[pageID] = current
+	}
+	return nil
+}
+
+// visible reports whether pageID was allocated when the snapshot began
+func (s *Snapshot) visible(pageID PageID) bool {
+	return pageID < s.nextPageID && s.allocated.Test(int(pageID))
+}
+
+// ReadPage returns the page as it was when the snapshot began
+func (s *Snapshot) ReadPage(pageID PageID) (*Page, error) {
+	s.pm.mu.RLock()
+	defer s.pm.mu.RUnlock()
+
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	if pageID >= s.nextPageID {
+		return nil, ErrInvalidPageID
+	}
+	if !s.allocated.Test(int(pageID)) {
+		return nil, ErrPageNotAllocated
+	}
+
+	if image, ok := s.images[pageID]; ok {
+		return image.clone(), nil
+	}
+	page, err := s.pm.currentPageLocked(pageID)
+	if err != nil {
+		return nil, err
+	}
+	return page.clone(), nil
+}
+
+// PreservedPages returns how many page images the snapshot holds
+func (s *Snapshot) PreservedPages() int {
+	s.pm.mu.RLock()
+	defer s.pm.mu.RUnlock()
+	return len(s.images)
+}
+
+// Release ends the snapshot and frees its preserved page images
+func (s *Snapshot) Release() {
+	s.pm.mu.Lock()
+	defer s.pm.mu.Unlock()
+
+	s.released = true
+	s.images = nil
+	delete(s.pm.snapshots, s.id)
+}
+
+// ID returns the snapshot's ID
+func (s *Snapshot) ID() SnapshotID {
+	return s.id
+}
+
+// Version returns the page manager's write sequence when the snapshot
+// began: a page whose PageVersion is higher changed after it
+func (s *Snapshot) Version() uint64 {
+	return s.version
+}
+
+// SnapshotBegin starts a snapshot like BeginSnapshot and returns its ID,
+// for use with ReadPageAt and SnapshotEnd
+func (pm *PageManager) SnapshotBegin() SnapshotID {
+	return pm.BeginSnapshot().id
+}
+
+// Snapshot returns the active snapshot with the given ID
+func (pm *PageManager) Snapshot(id SnapshotID) (*Snapshot, bool) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	snap, ok := pm.snapshots[id]
+	return snap, ok
+}
+
+// ReadPageAt returns the page as it was when snapshot id began. It
+// returns ErrSnapshotReleased if the snapshot has ended or never existed.
+func (pm *PageManager) ReadPageAt(id SnapshotID, pageID PageID) (*Page, error) {
+	snap, ok := pm.Snapshot(id)
+	if !ok {
+		return nil, ErrSnapshotReleased
+	}
+	return snap.ReadPage(pageID)
+}
+
+// SnapshotEnd releases snapshot id. Ending it again does nothing.
+func (pm *PageManager) SnapshotEnd(id SnapshotID) {
+	if snap, ok := pm.Snapshot(id); ok {
+		snap.Release()
+	}
+}
+
+// PageVersion returns the write sequence of the last write or free of
+// pageID, so callers can tell whether it changed since a snapshot began.
+// Versions are kept in memory: a page not written since Open has version
+// 0.
+func (pm *PageManager) PageVersion(pageID PageID) (uint64, error) {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	if pageID >= pm.nextPageID {
+		return 0, ErrInvalidPageID
+	}
+	return pm.versions[pageID], nil
+}
+
+// bumpVersionLocked records a write or free of pageID. Caller holds pm.mu
+// exclusively.
+func (pm *PageManager) bumpVersionLocked(pageID PageID) {
+	pm.writeSeq++
+	pm.versions[pageID] = pm.writeSeq
+}
@@ -0,0 +1,101 @@
+package pagemanager
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Op is an operation reported to a TraceFunc
+type Op uint8
+
+const (
+	// OpRead is a ReadPage call, served from the cache or the file
+	OpRead Op = iota
+	// OpWrite is a WritePage call
+	OpWrite
+	// OpAllocate is an AllocatePage call
+	OpAllocate
+	// OpFree is a FreePage call
+	OpFree
+	// OpFlush is a Flush call; its page ID is InvalidPageID
+	OpFlush
+	// OpDiskRead is a page read from the file on a cache miss
+	OpDiskRead
+	// OpDiskWrite is a write of a page, or of a run of adjacent pages
+	// starting at the page ID, to the file
+	OpDiskWrite
+)
+
+var opNames = [...]string{"read", "write", "allocate", "free", "flush", "disk-read", "disk-write"}
+
+func (op Op) String() string {
+	if int(op) < len(opNames) {
+		return opNames[op]
+	}
+	return "unknown"
+}
+
+// TraceFunc receives each traced operation with its page and latency. The
+// disk operations are reported while the manager's lock is held, so it must
+// not call back into the manager, and it should return quickly.
+type TraceFunc func(op Op, pageID PageID, d time.Duration)
+
+// WithTrace has the manager call trace for every operation (Options.Trace)
+func WithTrace(trace TraceFunc) Option {
+	return func(o *Options) { o.Trace = trace }
+}
+
+// Stats collects the counters of a PageManager
+type Stats struct {
+	// DiskReads is the number of pages read from the file
+	DiskReads uint64
+	// DiskWrites is the number of pages written to the file, or to the
+	// mapping with Options.Mmap, for any reason: write-back, compaction
+	// or the writes of the mmap backend
+	DiskWrites uint64
+	// Allocations and Frees count the pages allocated and freed, one at a
+	// time or in batches
+	Allocations uint64
+	Frees       uint64
+	// Cache holds the hits, misses and hit rate of the LRU cache
+	Cache CacheStats
+	// Writes counts the write-back of dirty pages
+	Writes WriteStats
+}
+
+// opCounters are the counters of Stats that the manager keeps itself.
+// Disk reads happen under the read lock, so they are atomic.
+type opCounters struct {
+	diskReads   atomic.Uint64
+	diskWrites  atomic.Uint64
+	allocations atomic.Uint64
+	frees       atomic.Uint64
+}
+
+// Stats returns the I/O, allocation and cache counters
+func (pm *PageManager) Stats() Stats {
+	return Stats{
+		DiskReads:   pm.counters.diskReads.Load(),
+		DiskWrites:  pm.counters.diskWrites.Load(),
+		Allocations: pm.counters.allocations.Load(),
+		Frees:       pm.counters.frees.Load(),
+		Cache:       pm.CacheStats(),
+		Writes:      pm.WriteStats(),
+	}
+}
+
+// traceStart returns the start time of a traced operation, or the zero
+// time without a TraceFunc so untraced calls do not read the clock
+func (pm *PageManager) traceStart() time.Time {
+	if pm.opts.Trace == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// trace reports an operation that began at start to the TraceFunc
+func (pm *PageManager) trace(op Op, pageID PageID, start time.Time) {
+	if pm.opts.Trace != nil {
+		pm.opts.Trace(op, pageID, time.Since(start))
+	}
+}
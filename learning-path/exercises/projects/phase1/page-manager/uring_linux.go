@@ -0,0 +1,376 @@
+//go:build amd64 || arm64
+
+package pagemanager
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// The io_uring ABI, from linux/io_uring.h
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	ioringOpNop   = 0
+	ioringOpRead  = 22
+	ioringOpWrite = 23
+
+	ioringEnterGetEvents = 1 << 0
+
+	ioringOffSQRing = 0
+	ioringOffCQRing = 0x8000000
+	ioringOffSQEs   = 0x10000000
+)
+
+type ioUringParams struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        ioSQRingOffsets
+	cqOff        ioCQRingOffsets
+}
+
+type ioSQRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type ioCQRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// ioUringSQE is a submission queue entry, 64 bytes
+type ioUringSQE struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// ioUringCQE is a completion queue entry, 16 bytes
+type ioUringCQE struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// closeUserData marks the no-op Close submits to stop the reaper
+const closeUserData = ^uint64(0)
+
+// uringBackend submits batches to an io_uring, with one system call per
+// batch, and a reaper goroutine waits for their completions
+type uringBackend struct {
+	fd     int   // the ring's
+	fileFD int32 // the page file's
+	// The rings, mapped from the kernel. The head and tail pointers are
+	// shared with it and accessed atomically.
+	sqRing, cqRing, sqeMem []byte
+	sqTail, sqMask         *uint32
+	sqArray                []uint32
+	sqes                   []ioUringSQE
+	cqHead, cqTail, cqMask *uint32
+	cqes                   []ioUringCQE
+
+	// slots holds a token per request in flight, so no more are submitted
+	// than the rings have room for
+	slots chan struct{}
+	// mu guards the submission queue, inflight and closed
+	mu       sync.Mutex
+	inflight map[uint64]uringOp
+	nextID   uint64
+	closed   bool
+	err      error // why the reaper stopped, if it failed
+	reaped   chan struct{}
+	closing  sync.Once
+}
+
+// uringOp is a request in flight. Keeping req here also keeps its buffer
+// alive while the kernel writes to it.
+type uringOp struct {
+	req   *IORequest
+	batch *ioBatch
+}
+
+// NewIOUringBackend returns a DiskBackend doing the I/O of file through an
+// io_uring of entries entries (default 32). It fails with
+// ErrIOUringUnsupported if the kernel has no io_uring, or does not let
+// this process use it.
+func NewIOUringBackend(file *os.File, entries int) (DiskBackend, error) {
+	if entries <= 0 {
+		entries = defaultIODepth
+	}
+	var params ioUringParams
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, uintptr(entries), uintptr(unsafe.Pointer(&params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("%w: %w", ErrIOUringUnsupported, errno)
+	}
+	b := &uringBackend{
+		fd:       int(fd),
+		fileFD:   int32(file.Fd()),
+		inflight: make(map[uint64]uringOp),
+		reaped:   make(chan struct{}),
+	}
+	if err := b.mapRings(&params); err != nil {
+		b.unmap()
+		syscall.Close(b.fd)
+		return nil, err
+	}
+	// The completion queue is at least as big, so it cannot overflow
+	b.slots = make(chan struct{}, params.sqEntries)
+	go b.reap()
+	return b, nil
+}
+
+// mapRings maps the submission and completion queues
+func (b *uringBackend) mapRings(p *ioUringParams) error {
+	mmap := func(offset int64, size int) ([]byte, error) {
+		return syscall.Mmap(b.fd, offset, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+	}
+	var err error
+	if b.sqRing, err = mmap(ioringOffSQRing, int(p.sqOff.array+p.sqEntries*4)); err != nil {
+		return err
+	}
+	if b.cqRing, err = mmap(ioringOffCQRing, int(p.cqOff.cqes+p.cqEntries*uint32(unsafe.Sizeof(ioUringCQE{})))); err != nil {
+		return err
+	}
+	if b.sqeMem, err = mmap(ioringOffSQEs, int(p.sqEntries)*int(unsafe.Sizeof(ioUringSQE{}))); err != nil {
+		return err
+	}
+	b.sqTail = (*uint32)(unsafe.Pointer(&b.sqRing[p.sqOff.tail]))
+	b.sqMask = (*uint32)(unsafe.Pointer(&b.sqRing[p.sqOff.ringMask]))
+	b.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&b.sqRing[p.sqOff.array])), p.sqEntries)
+	b.sqes = unsafe.Slice((*ioUringSQE)(unsafe.Pointer(&b.sqeMem[0])), p.sqEntries)
+	b.cqHead = (*uint32)(unsafe.Pointer(&b.cqRing[p.cqOff.head]))
+	b.cqTail = (*uint32)(unsafe.Pointer(&b.cqRing[p.cqOff.tail]))
+	b.cqMask = (*uint32)(unsafe.Pointer(&b.cqRing[p.cqOff.ringMask]))
+	b.cqes = unsafe.Slice((*ioUringCQE)(unsafe.Pointer(&b.cqRing[p.cqOff.cqes])), p.cqEntries)
+	return nil
+}
+
+func (b *uringBackend) unmap() {
+	for _, m := range [][]byte{b.sqRing, b.cqRing, b.sqeMem} {
+		if m != nil {
+			syscall.Munmap(m)
+		}
+	}
+}
+
+func (b *uringBackend) Name() string {
+	return "io_uring"
+}
+
+func (b *uringBackend) Submit(reqs []*IORequest) <-chan struct{} {
+	batch := newIOBatch(len(reqs))
+	for len(reqs) > 0 {
+		// Wait for one slot, then take as many more as are free
+		b.slots <- struct{}{}
+		n := 1
+	take:
+		for n < len(reqs) {
+			select {
+			case b.slots <- struct{}{}:
+				n++
+			default:
+				break take
+			}
+		}
+		b.submit(reqs[:n], batch)
+		reqs = reqs[n:]
+	}
+	return batch.done
+}
+
+// submit queues reqs, for which slots are taken, and enters them with one
+// system call
+func (b *uringBackend) submit(reqs []*IORequest, batch *ioBatch) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		b.fail(reqs, batch, b.closeErr())
+		return
+	}
+	tail := atomic.LoadUint32(b.sqTail)
+	for i, req := range reqs {
+		op := uint8(ioringOpRead)
+		if req.Write {
+			op = ioringOpWrite
+		}
+		b.nextID++
+		index := (tail + uint32(i)) & *b.sqMask
+		b.sqes[index] = ioUringSQE{
+			opcode:   op,
+			fd:       b.fileFD,
+			off:      uint64(req.Offset),
+			addr:     uint64(uintptr(unsafe.Pointer(unsafe.SliceData(req.Buf)))),
+			len:      uint32(len(req.Buf)),
+			userData: b.nextID,
+		}
+		b.sqArray[index] = index
+		b.inflight[b.nextID] = uringOp{req: req, batch: batch}
+	}
+	sent, err := b.enter(tail, uint32(len(reqs)))
+	if err != nil {
+		for id := b.nextID - uint64(len(reqs)-sent) + 1; id <= b.nextID; id++ {
+			delete(b.inflight, id)
+		}
+		b.fail(reqs[sent:], batch, err)
+	}
+}
+
+// enter publishes the n entries queued from tail and submits them,
+// returning how many the kernel took. It only takes entries during the
+// call, so the ones it did not take when it fails are withdrawn again.
+// Caller holds b.mu.
+func (b *uringBackend) enter(tail, n uint32) (int, error) {
+	atomic.StoreUint32(b.sqTail, tail+n)
+	var sent uint32
+	for sent < n {
+		done, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(b.fd), uintptr(n-sent), 0, 0, 0, 0)
+		switch {
+		case errno == syscall.EINTR || errno == syscall.EAGAIN || errno == syscall.EBUSY:
+			continue
+		case errno != 0:
+			atomic.StoreUint32(b.sqTail, tail+sent)
+			return int(sent), errno
+		}
+		sent += uint32(done)
+	}
+	return int(sent), nil
+}
+
+// fail completes reqs with err and gives their slots back
+func (b *uringBackend) fail(reqs []*IORequest, batch *ioBatch, err error) {
+	for _, req := range reqs {
+		req.Err = err
+		<-b.slots
+		batch.complete()
+	}
+}
+
+// closeErr is the error of a request submitted after Close, or after the
+// reaper failed. Caller holds b.mu.
+func (b *uringBackend) closeErr() error {
+	if b.err != nil {
+		return b.err
+	}
+	return ErrBackendClosed
+}
+
+// reap completes the requests whose completions the kernel posts, until
+// Close's no-op arrives and nothing is left in flight
+func (b *uringBackend) reap() {
+	defer close(b.reaped)
+	stopping := false
+	for {
+		_, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(b.fd), 0, 1, ioringEnterGetEvents, 0, 0)
+		if errno != 0 && errno != syscall.EINTR {
+			b.abort(errno)
+			return
+		}
+		head, tail := atomic.LoadUint32(b.cqHead), atomic.LoadUint32(b.cqTail)
+		for ; head != tail; head++ {
+			cqe := b.cqes[head&*b.cqMask]
+			<-b.slots
+			if cqe.userData == closeUserData {
+				stopping = true
+				continue
+			}
+			b.mu.Lock()
+			op, ok := b.inflight[cqe.userData]
+			delete(b.inflight, cqe.userData)
+			b.mu.Unlock()
+			if !ok {
+				continue
+			}
+			op.req.N, op.req.Err = completion(op.req, cqe.res)
+			op.batch.complete()
+		}
+		atomic.StoreUint32(b.cqHead, head)
+
+		b.mu.Lock()
+		idle := len(b.inflight) == 0
+		b.mu.Unlock()
+		if stopping && idle {
+			return
+		}
+	}
+}
+
+// completion returns the bytes transferred and the error of a request
+// whose completion carries res
+func completion(req *IORequest, res int32) (int, error) {
+	switch {
+	case res < 0:
+		return 0, syscall.Errno(-res)
+	case int(res) == len(req.Buf):
+		return int(res), nil
+	case req.Write:
+		return int(res), io.ErrShortWrite
+	}
+	return int(res), io.EOF
+}
+
+// abort fails every request in flight after waiting for completions failed
+func (b *uringBackend) abort(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.err = fmt.Errorf("io_uring: %w", err)
+	for id, op := range b.inflight {
+		delete(b.inflight, id)
+		op.req.Err = b.err
+		<-b.slots
+		op.batch.complete()
+	}
+}
+
+// Close submits a no-op behind the requests in flight, and releases the
+// ring once the reaper has seen it. If the no-op cannot be submitted, the
+// reaper may still be using the ring, so it is left mapped.
+func (b *uringBackend) Close() error {
+	var err error
+	b.closing.Do(func() {
+		b.slots <- struct{}{}
+		b.mu.Lock()
+		aborted := b.closed
+		b.closed = true
+		if !aborted {
+			tail := atomic.LoadUint32(b.sqTail)
+			index := tail & *b.sqMask
+			b.sqes[index] = ioUringSQE{opcode: ioringOpNop, userData: closeUserData}
+			b.sqArray[index] = index
+			_, err = b.enter(tail, 1)
+		}
+		b.mu.Unlock()
+		if err != nil {
+			err = fmt.Errorf("io_uring: %w", err)
+			return
+		}
+		<-b.reaped
+		b.unmap()
+		err = syscall.Close(b.fd)
+	})
+	return err
+}
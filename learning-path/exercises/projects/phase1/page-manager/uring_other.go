@@ -0,0 +1,9 @@
+//go:build !linux || !(amd64 || arm64)
+
+package pagemanager
+
+import "os"
+
+func NewIOUringBackend(file *os.File, entries int) (DiskBackend, error) {
+	return nil, ErrIOUringUnsupported
+}
@@ -0,0 +1,213 @@
+package pagemanager
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCoalescePages caps how many adjacent pages go in one write
+	maxCoalescePages = 32
+	// writerBatch is how many pages the background writer writes per hold
+	// of pm.mu, so readers get in between batches
+	writerBatch = 64
+)
+
+// WithBackgroundWriter starts a background writer that writes dirty pages
+// once they fill high of the cache, down to low
+// (Options.DirtyHighWatermark and Options.DirtyLowWatermark)
+func WithBackgroundWriter(low, high float64) Option {
+	return func(o *Options) {
+		o.DirtyLowWatermark = low
+		o.DirtyHighWatermark = high
+	}
+}
+
+// WriteStats counts the pages written back from the cache
+type WriteStats struct {
+	// Pages is the number of pages written by Flush, write-back and the
+	// background writer
+	Pages uint64
+	// Writes is the number of write calls they took; fewer than Pages
+	// when adjacent pages were coalesced
+	Writes uint64
+	// Background is the number of Pages the background writer wrote
+	Background uint64
+}
+
+// WriteStats returns the write-back statistics
+func (pm *PageManager) WriteStats() WriteStats {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.writeStats
+}
+
+// writePagesLocked writes pages sorted by page ID, so the disk sees them
+// in file order, and coalesces runs of adjacent pages into one write. It
+// waits for the asynchronous I/O in flight first. Caller holds pm.mu
+// exclusively.
+func (pm *PageManager) writePagesLocked(pages []*Page) error {
+	// A write of WritePagesAsync still in flight could otherwise land on
+	// top of a newer image of its page written now
+	pm.asyncIO.Wait()
+	pages = slices.Clone(pages)
+	slices.SortFunc(pages, func(a, b *Page) int { return int(a.ID) - int(b.ID) })
+
+	for len(pages) > 0 {
+		run := 1
+		for run < len(pages) && run < maxCoalescePages && pages[run].ID == pages[run-1].ID+1 {
+			run++
+		}
+		if err := pm.writeRunLocked(pages[:run]); err != nil {
+			return err
+		}
+		pm.writeStats.Pages += uint64(run)
+		pm.writeStats.Writes++
+		pages = pages[run:]
+	}
+	return nil
+}
+
+// writeRunLocked writes pages with consecutive IDs
+func (pm *PageManager) writeRunLocked(pages []*Page) error {
+	if pm.mapping != nil || len(pages) == 1 {
+		for _, page := range pages {
+			if err := pm.writePageToDisk(page); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	defer pm.trace(OpDiskWrite, pages[0].ID, pm.traceStart())
+	pm.counters.diskWrites.Add(uint64(len(pages)))
+	var buf []byte
+	if pm.opts.DirectIO {
+		buf = alignedBuffer(len(pages) * pm.pageSize)
+	} else {
+		buf = make([]byte, len(pages)*pm.pageSize)
+	}
+	for i, page := range pages {
+		page.marshalTo(buf[i*pm.pageSize : (i+1)*pm.pageSize])
+	}
+	_, err := pm.file.WriteAt(buf, pm.pageOffset(pages[0].ID))
+	return err
+}
+
+// writeBackLocked writes the n least recently used dirty pages and marks
+// them clean. Caller holds pm.mu exclusively.
+func (pm *PageManager) writeBackLocked(n int) (int, error) {
+	pages := pm.cache.OldestDirty(n)
+	if err := pm.writePagesLocked(pages); err != nil {
+		return 0, err
+	}
+	pm.cache.MarkClean(pages)
+	return len(pages), nil
+}
+
+// afterPutLocked keeps the dirty pages in check after a write: past the
+// high watermark it wakes the background writer, and with the cache full
+// of dirty pages it writes the oldest back at once. The cache has no
+// FlushFunc, so dirty pages leave it only through these coalesced writes.
+// Caller holds pm.mu exclusively.
+func (pm *PageManager) afterPutLocked() error {
+	dirty := pm.cache.DirtyCount()
+	if pm.writer != nil && dirty >= pm.writer.high {
+		pm.writer.notify()
+	}
+	if excess := dirty - pm.cache.Capacity(); excess > 0 {
+		_, err := pm.writeBackLocked(excess)
+		return err
+	}
+	return nil
+}
+
+// bgWriter is the background writer of Options.DirtyHighWatermark. It
+// writes dirty pages without syncing them, like the OS's own write-back;
+// Flush still makes them durable.
+type bgWriter struct {
+	high, low int // watermarks in pages
+	interval  time.Duration
+	wake      chan struct{}
+	stop      chan struct{}
+	done      chan struct{}
+	stopOnce  sync.Once
+}
+
+// startWriter starts the background writer if the options ask for one
+func (pm *PageManager) startWriter() {
+	opts := pm.opts
+	if opts.DirtyHighWatermark <= 0 || pm.mapping != nil {
+		// Mapped pages are never dirty in the cache
+		return
+	}
+	capacity := pm.cache.Capacity()
+	w := &bgWriter{
+		high:     max(int(opts.DirtyHighWatermark*float64(capacity)), 1),
+		low:      int(opts.DirtyLowWatermark * float64(capacity)),
+		interval: opts.WriterInterval,
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	pm.writer = w
+	go pm.runWriter(w)
+}
+
+func (w *bgWriter) notify() {
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// close stops the writer and waits for it to exit
+func (w *bgWriter) close() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (pm *PageManager) runWriter(w *bgWriter) {
+	defer close(w.done)
+	var tick <-chan time.Time
+	if w.interval > 0 {
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.wake:
+			pm.writeDown(w, w.low)
+		case <-tick:
+			pm.writeDown(w, 0)
+		}
+	}
+}
+
+// writeDown writes the oldest dirty pages until at most target are left,
+// a batch at a time. A failed write leaves its pages dirty for Flush to
+// retry and report.
+func (pm *PageManager) writeDown(w *bgWriter, target int) {
+	for {
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		pm.mu.Lock()
+		n := min(pm.cache.DirtyCount()-target, writerBatch)
+		if n <= 0 {
+			pm.mu.Unlock()
+			return
+		}
+		written, err := pm.writeBackLocked(n)
+		pm.writeStats.Background += uint64(written)
+		pm.mu.Unlock()
+		if err != nil {
+			return
+		}
+	}
+}
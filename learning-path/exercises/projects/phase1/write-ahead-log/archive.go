@@ -0,0 +1,223 @@
+package wal
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Archiver copies complete segments somewhere durable, such as object
+// storage or a directory on another disk, for point-in-time recovery.
+//
+// Archive runs on a background goroutine, one segment at a time in LSN
+// order, once the segment has been rotated out; its file is not written
+// again. A failed segment is retried, and Truncate keeps it until it is
+// archived. After a crash a segment may be archived again, so Archive has
+// to be idempotent.
+type Archiver interface {
+	Archive(segment SegmentInfo) error
+}
+
+const (
+	// archivedExt marks an archived segment: "<segment>.wal.archived"
+	archivedExt = ".archived"
+
+	archiveRetryInterval = time.Second
+)
+
+// archiver is the background state of WALOptions.Archiver
+type archiver struct {
+	kick chan struct{} // buffered; a pending pass
+	stop chan struct{}
+	done chan struct{}
+
+	passMu sync.Mutex // held during a pass; excludes cutTail
+
+	mu      sync.Mutex
+	through LSN
+	err     error
+}
+
+func (w *WAL) startArchiver() {
+	w.archiver = &archiver{
+		kick: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.archiveLoop()
+	w.kickArchiver()
+}
+
+// kickArchiver schedules an archive pass, for example after a rotation
+func (w *WAL) kickArchiver() {
+	if w.archiver == nil {
+		return
+	}
+	select {
+	case w.archiver.kick <- struct{}{}:
+	default:
+	}
+}
+
+func (w *WAL) stopArchiver() {
+	if w.archiver != nil {
+		close(w.archiver.stop)
+		<-w.archiver.done
+	}
+}
+
+func (w *WAL) archiveLoop() {
+	a := w.archiver
+	defer close(a.done)
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-a.kick:
+		case <-retry:
+		case <-a.stop:
+			return
+		}
+		retry = nil
+		a.passMu.Lock()
+		err := w.archivePending()
+		a.passMu.Unlock()
+		a.mu.Lock()
+		a.err = err
+		a.mu.Unlock()
+		if err != nil {
+			retry = time.After(archiveRetryInterval)
+		}
+	}
+}
+
+// archivePending archives every complete segment not yet archived. Caller
+// holds the archiver's passMu.
+func (w *WAL) archivePending() error {
+	segments, err := ListSegments(w.opts.Dir)
+	if err != nil {
+		return err
+	}
+	// The newest segment is still being written
+	for i := 0; i+1 < len(segments); i++ {
+		segment := segments[i]
+		if !w.isArchived(segment) {
+			if err := w.opts.Archiver.Archive(segment); err != nil {
+				return err
+			}
+			marker, err := os.Create(segment.Path + archivedExt)
+			if err != nil {
+				return err
+			}
+			marker.Close()
+		}
+		w.archiver.mu.Lock()
+		w.archiver.through = segments[i+1].StartLSN
+		w.archiver.mu.Unlock()
+	}
+	return nil
+}
+
+// ArchivedLSN returns the LSN below which every record is in an archived
+// segment, and the error of the last archive attempt, nil if it succeeded.
+// Without an Archiver it returns 0, nil.
+func (w *WAL) ArchivedLSN() (LSN, error) {
+	if w.archiver == nil {
+		return 0, nil
+	}
+	w.archiver.mu.Lock()
+	defer w.archiver.mu.Unlock()
+	return w.archiver.through, w.archiver.err
+}
+
+// isArchived reports whether a segment may be removed by Truncate
+func (w *WAL) isArchived(segment SegmentInfo) bool {
+	if w.archiver == nil {
+		return true
+	}
+	_, err := os.Stat(segment.Path + archivedExt)
+	return err == nil
+}
+
+// removeSegment deletes a segment file and its archive marker
+func removeSegment(segment SegmentInfo) error {
+	if err := os.Remove(segment.Path); err != nil {
+		return err
+	}
+	if err := os.Remove(segment.Path + archivedExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// DirArchiver archives segments by copying them into Dir
+type DirArchiver struct {
+	Dir string
+}
+
+// Archive copies the segment into the archive directory. The copy is
+// written under a temporary name and renamed, so the archive never holds a
+// partial segment.
+func (a DirArchiver) Archive(segment SegmentInfo) error {
+	if err := os.MkdirAll(a.Dir, 0755); err != nil {
+		return err
+	}
+	dst := filepath.Join(a.Dir, filepath.Base(segment.Path))
+	if err := copyFile(segment.Path, dst+".tmp"); err != nil {
+		return err
+	}
+	if err := os.Rename(dst+".tmp", dst); err != nil {
+		return err
+	}
+	return syncDir(a.Dir)
+}
+
+// Restore copies the archived segments into dir, the Dir of a log to
+// recover, skipping segments dir already has. Open the log with New and
+// call RecoverTo or RecoverToTime to replay it up to the target.
+func (a DirArchiver) Restore(dir string) error {
+	segments, err := ListSegments(a.Dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		dst := filepath.Join(dir, filepath.Base(segment.Path))
+		if _, err := os.Stat(dst); err == nil {
+			continue
+		}
+		if err := copyFile(segment.Path, dst); err != nil {
+			return err
+		}
+	}
+	return syncDir(dir)
+}
+
+// copyFile copies src to dst and syncs dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package wal
+
+import "encoding/binary"
+
+// ActiveTxn is an active transaction table entry of a checkpoint
+type ActiveTxn struct {
+	TxnID TxnID
+	// FirstLSN is the transaction's first record; undoing it needs the log
+	// from there on
+	FirstLSN LSN
+}
+
+// DirtyPage is a dirty page table entry of a checkpoint
+type DirtyPage struct {
+	PageID PageID
+	// RecLSN is the first update that may not be on disk yet; redo of the
+	// page starts there
+	RecLSN LSN
+}
+
+// CheckpointData is the payload of a RecordCheckpoint written with tables:
+// the transactions without a COMMIT or ABORT and the pages not yet flushed,
+// as of the checkpoint
+type CheckpointData struct {
+	ActiveTxns []ActiveTxn
+	DirtyPages []DirtyPage
+}
+
+// checkpointEntrySize is the size of an encoded ActiveTxn or DirtyPage
+const checkpointEntrySize = 16
+
+// Encode serializes the tables for use as LogRecord.Data
+// Format: TxnCount(4) + (TxnID(8) + FirstLSN(8))* + PageCount(4) + (PageID(8) + RecLSN(8))*
+func (d *CheckpointData) Encode() []byte {
+	buf := make([]byte, 8+checkpointEntrySize*(len(d.ActiveTxns)+len(d.DirtyPages)))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(len(d.ActiveTxns)))
+	off := 4
+	for _, txn := range d.ActiveTxns {
+		binary.LittleEndian.PutUint64(buf[off:], uint64(txn.TxnID))
+		binary.LittleEndian.PutUint64(buf[off+8:], uint64(txn.FirstLSN))
+		off += checkpointEntrySize
+	}
+	binary.LittleEndian.PutUint32(buf[off:], uint32(len(d.DirtyPages)))
+	off += 4
+	for _, page := range d.DirtyPages {
+		binary.LittleEndian.PutUint64(buf[off:], uint64(page.PageID))
+		binary.LittleEndian.PutUint64(buf[off+8:], uint64(page.RecLSN))
+		off += checkpointEntrySize
+	}
+	return buf
+}
+
+// DecodeCheckpointData parses the Data of a checkpoint record written with
+// tables
+func DecodeCheckpointData(data []byte) (*CheckpointData, error) {
+	d := &CheckpointData{}
+	if len(data) < 4 {
+		return nil, ErrInvalidRecord
+	}
+	n := int(binary.LittleEndian.Uint32(data[0:4]))
+	data = data[4:]
+	if n > len(data)/checkpointEntrySize {
+		return nil, ErrInvalidRecord
+	}
+	for range n {
+		d.ActiveTxns = append(d.ActiveTxns, ActiveTxn{
+			TxnID:    TxnID(binary.LittleEndian.Uint64(data[0:8])),
+			FirstLSN: LSN(binary.LittleEndian.Uint64(data[8:16])),
+		})
+		data = data[checkpointEntrySize:]
+	}
+
+	if len(data) < 4 {
+		return nil, ErrInvalidRecord
+	}
+	n = int(binary.LittleEndian.Uint32(data[0:4]))
+	data = data[4:]
+	if n*checkpointEntrySize != len(data) {
+		return nil, ErrInvalidRecord
+	}
+	for range n {
+		d.DirtyPages = append(d.DirtyPages, DirtyPage{
+			PageID: PageID(binary.LittleEndian.Uint64(data[0:8])),
+			RecLSN: LSN(binary.LittleEndian.Uint64(data[8:16])),
+		})
+		data = data[checkpointEntrySize:]
+	}
+	return d, nil
+}
+
+// CheckpointDataHandler is implemented by recovery handlers that want the
+// tables of the checkpoints redo passes. OnCheckpointData is called after
+// OnCheckpoint for each checkpoint written with tables.
+type CheckpointDataHandler interface {
+	OnCheckpointData(lsn LSN, data *CheckpointData) error
+}
@@ -0,0 +1,84 @@
+// Command waltool inspects write-ahead log files.
+//
+// Usage:
+//
+//	waltool dump [-json] <file|dir>
+//	waltool verify <file|dir>
+//	waltool repair [-dry-run] [-report file] <file|dir>
+//	waltool segments <dir>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	wal "github.com/kuzu/learning-path/exercises/projects/phase1/write-ahead-log"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "dump":
+		fs := flag.NewFlagSet("dump", flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "emit one JSON object per record")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() != 1 {
+			usage()
+		}
+		format := wal.DumpText
+		if *asJSON {
+			format = wal.DumpJSON
+		}
+		if err := wal.DumpLog(fs.Arg(0), os.Stdout, format); err != nil {
+			fatal(err)
+		}
+	case "verify":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		if err := wal.VerifyLog(os.Args[2]); err != nil {
+			fatal(err)
+		}
+		fmt.Println("ok")
+	case "repair":
+		fs := flag.NewFlagSet("repair", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "report the first bad record without truncating")
+		reportPath := fs.String("report", "", "write the report as JSON to this file")
+		fs.Parse(os.Args[2:])
+		if fs.NArg() != 1 {
+			usage()
+		}
+		report, err := wal.Verify(fs.Arg(0), wal.VerifyOptions{Repair: !*dryRun, ReportPath: *reportPath})
+		if err != nil {
+			fatal(err)
+		}
+		fmt.Println(report.String())
+	case "segments":
+		if len(os.Args) != 3 {
+			usage()
+		}
+		segments, err := wal.ListSegments(os.Args[2])
+		if err != nil {
+			fatal(err)
+		}
+		for _, seg := range segments {
+			fmt.Printf("%s\tstart=%d\tsize=%d\n", seg.Path, seg.StartLSN, seg.Size)
+		}
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: waltool dump [-json] <file|dir> | waltool verify <file|dir> | waltool repair [-dry-run] [-report file] <file|dir> | waltool segments <dir>")
+	os.Exit(2)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "waltool:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,372 @@
+package wal
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Compression selects the codec used for record payloads
+type Compression byte
+
+const (
+	CompressionNone Compression = iota
+	CompressionFlate
+	// CompressionSnappy and CompressionZstd are reserved for codecs from
+	// outside the standard library; register one with RegisterCodec
+	// before opening a log that uses them
+	CompressionSnappy
+	CompressionZstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case CompressionNone:
+		return "none"
+	case CompressionFlate:
+		return "flate"
+	case CompressionSnappy:
+		return "snappy"
+	case CompressionZstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("Compression(%d)", byte(c))
+	}
+}
+
+// CompressionMode chooses what one compressed frame holds
+type CompressionMode int
+
+const (
+	// CompressRecords compresses each record's payload on its own
+	CompressRecords CompressionMode = iota
+	// CompressBatches compresses the records of a flush together, which
+	// also shrinks headers and similar neighbouring payloads
+	CompressBatches
+)
+
+// Codec compresses and decompresses frame payloads
+type Codec interface {
+	Compress(src []byte) ([]byte, error)
+	// Decompress must fail rather than return more than maxSize bytes
+	Decompress(src []byte, maxSize int) ([]byte, error)
+}
+
+// ErrUnknownCodec is returned for a compression with no registered codec
+var ErrUnknownCodec = errors.New("wal: unknown compression codec")
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[Compression]Codec{CompressionFlate: flateCodec{}}
+)
+
+// RegisterCodec makes codec available for logs written or read with
+// compression c. It is typically called from an init function.
+func RegisterCodec(c Compression, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c] = codec
+}
+
+func lookupCodec(c Compression) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	codec, ok := codecs[c]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCodec, c)
+	}
+	return codec, nil
+}
+
+type flateCodec struct{}
+
+func (flateCodec) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (flateCodec) Decompress(src []byte, maxSize int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > maxSize {
+		return nil, ErrInvalidRecord
+	}
+	return out, nil
+}
+
+const (
+	// frameMagic starts a compressed frame. Plain records keep their own
+	// magic, so logs written without compression read the same as before.
+	frameMagic uint32 = 0x464c4157 // "WALF"
+
+	// frameHeaderSize is the record header plus Format(1) + RawLength(4).
+	// Format holds the Compression in its low bits and frameBatch.
+	frameHeaderSize = recordHeaderSize + 5
+
+	// frameBatch marks a frame whose payload is a run of plain records
+	frameBatch = 0x80
+
+	// minCompressSize is the smallest payload worth compressing
+	minCompressSize = 64
+
+	// maxBatchSize bounds the uncompressed records in one batch frame
+	maxBatchSize = 4 << 20
+)
+
+// frame is the encoding of one or more consecutive records
+type frame struct {
+	first LSN
+	last  LSN
+	data  []byte
+}
+
+// encodeFrames encodes records with the log's compression and encryption
+// settings. Without encryption, records that do not shrink are written as
+// plain records.
+func (w *WAL) encodeFrames(records []*LogRecord) ([]frame, error) {
+	var frames []frame
+	if w.codec == nil && w.opts.EncryptionKeyProvider == nil {
+		for _, record := range records {
+			frames = append(frames, frame{record.LSN, record.LSN, record.Encode()})
+		}
+		return frames, nil
+	}
+
+	if w.opts.CompressionMode == CompressBatches {
+		limit := maxBatchSize
+		if w.opts.Dir != "" {
+			limit = int(min(int64(limit), w.opts.SegmentSize))
+		}
+		for len(records) > 0 {
+			n, size := 0, 0
+			for n < len(records) && (n == 0 || size+records[n].encodedSize() <= limit) {
+				size += records[n].encodedSize()
+				n++
+			}
+			batch, err := w.encodeBatch(records[:n])
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, batch...)
+			records = records[n:]
+		}
+		return frames, nil
+	}
+
+	for _, record := range records {
+		if !record.ID.IsZero() {
+			// A single-record frame has no room for fields, so a record
+			// with an ID goes in a batch of one
+			batch, err := w.encodeBatch([]*LogRecord{record})
+			if err != nil {
+				return nil, err
+			}
+			frames = append(frames, batch...)
+			continue
+		}
+		data, err := w.encodePayload(record, record.Data, 0)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame{record.LSN, record.LSN, data})
+	}
+	return frames, nil
+}
+
+// encodeBatch packs records into one frame, or into plain records if the
+// frame would be larger and need not be encrypted
+func (w *WAL) encodeBatch(records []*LogRecord) ([]frame, error) {
+	var raw []byte
+	for _, record := range records {
+		raw = append(raw, record.Encode()...)
+	}
+	first, last := records[0], records[len(records)-1]
+	data, err := w.encodePayload(&LogRecord{LSN: first.LSN}, raw, frameBatch)
+	if err != nil {
+		return nil, err
+	}
+	if data != nil {
+		return []frame{{first.LSN, last.LSN, data}}, nil
+	}
+
+	frames := make([]frame, len(records))
+	for i, record := range records {
+		frames[i] = frame{record.LSN, record.LSN, record.Encode()}
+	}
+	return frames, nil
+}
+
+// encodePayload returns a frame with header fields from record and payload
+// raw, compressed and encrypted as the log is configured. Without
+// encryption, if compressing raw does not make it smaller it returns
+// record's plain encoding, or nil for a batch.
+func (w *WAL) encodePayload(record *LogRecord, raw []byte, flags byte) ([]byte, error) {
+	payload, format := raw, flags
+	if w.codec != nil && len(raw) >= minCompressSize {
+		compressed, err := w.codec.Compress(raw)
+		if err != nil {
+			return nil, err
+		}
+		if frameHeaderSize+len(compressed) < record.encodedSize()-len(record.Data)+len(raw) {
+			payload, format = compressed, format|byte(w.opts.Compression)
+		}
+	}
+	// Compress before encrypting: ciphertext does not compress
+	if w.opts.EncryptionKeyProvider != nil && len(raw) > 0 {
+		return w.encodeSealed(record, payload, len(raw), format)
+	}
+	if format != flags {
+		return encodeFrame(record, payload, len(raw), format), nil
+	}
+	if flags&frameBatch != 0 {
+		return nil, nil
+	}
+	return record.Encode(), nil
+}
+
+// encodeFrame serializes a compressed frame
+// Format: Magic(4) + LSN(8) + Type(1) + TxnID(8) + Length(4) + Checksum(4) + Format(1) + RawLength(4) + Payload
+func encodeFrame(record *LogRecord, payload []byte, rawLen int, format byte) []byte {
+	buf := make([]byte, frameHeaderSize+len(payload))
+	putFrameHeader(buf, frameMagic, record, len(payload), format, rawLen)
+	copy(buf[frameHeaderSize:], payload)
+
+	record.Checksum = computeChecksum(buf)
+	binary.LittleEndian.PutUint32(buf[25:29], record.Checksum)
+	return buf
+}
+
+// putFrameHeader writes the fields shared by compressed and encrypted
+// frames, leaving the checksum zero
+func putFrameHeader(buf []byte, magic uint32, record *LogRecord, payloadLen int, format byte, rawLen int) {
+	binary.LittleEndian.PutUint32(buf[0:4], magic)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(record.LSN))
+	buf[12] = byte(record.Type)
+	binary.LittleEndian.PutUint64(buf[13:21], uint64(record.TxnID))
+	binary.LittleEndian.PutUint32(buf[21:25], uint32(payloadLen))
+	buf[29] = format
+	binary.LittleEndian.PutUint32(buf[30:34], uint32(rawLen))
+}
+
+// frameHeaderLen returns the header size of the record or frame starting
+// with magic, or 0 for an unknown magic
+func frameHeaderLen(magic uint32) int {
+	switch magic {
+	case recordMagic, recordMagicV1:
+		return recordHeaderSize
+	case frameMagic:
+		return frameHeaderSize
+	case sealedMagic:
+		return sealedHeaderSize
+	}
+	return 0
+}
+
+// decodeFrame decodes a plain record or a compressed or encrypted frame
+// into the records it holds. keys may be nil for an unencrypted log.
+func decodeFrame(data []byte, keys KeyProvider) ([]*LogRecord, error) {
+	if len(data) < recordHeaderSize {
+		return nil, ErrTruncatedRecord
+	}
+	magic := binary.LittleEndian.Uint32(data[0:4])
+	if magic != frameMagic && magic != sealedMagic {
+		record, _, err := decodePlain(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*LogRecord{record}, nil
+	}
+
+	headerSize := frameHeaderLen(magic)
+	if len(data) < headerSize {
+		return nil, ErrTruncatedRecord
+	}
+	dataLen := int(binary.LittleEndian.Uint32(data[21:25]))
+	rawLen := int(binary.LittleEndian.Uint32(data[30:34]))
+	if dataLen > maxRecordSize || rawLen > maxBatchSize+maxRecordSize {
+		return nil, ErrInvalidRecord
+	}
+	if len(data) < headerSize+dataLen {
+		return nil, ErrTruncatedRecord
+	}
+	buf := make([]byte, headerSize+dataLen)
+	copy(buf, data)
+	checksum := binary.LittleEndian.Uint32(buf[25:29])
+	binary.LittleEndian.PutUint32(buf[25:29], 0)
+	if computeChecksum(buf) != checksum {
+		return nil, ErrChecksumMismatch
+	}
+
+	payload := buf[headerSize:]
+	if magic == sealedMagic {
+		var err error
+		if payload, err = openSealed(keys, buf[:headerSize], payload); err != nil {
+			return nil, err
+		}
+	}
+
+	format := buf[29]
+	raw := payload
+	if compression := Compression(format &^ frameBatch); compression != CompressionNone {
+		codec, err := lookupCodec(compression)
+		if err != nil {
+			return nil, err
+		}
+		if raw, err = codec.Decompress(payload, rawLen); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+		}
+	}
+	if len(raw) != rawLen {
+		return nil, ErrInvalidRecord
+	}
+
+	if format&frameBatch != 0 {
+		return decodeBatch(raw)
+	}
+	record := &LogRecord{
+		LSN:      LSN(binary.LittleEndian.Uint64(buf[4:12])),
+		Type:     RecordType(buf[12]),
+		TxnID:    TxnID(binary.LittleEndian.Uint64(buf[13:21])),
+		Data:     raw,
+		Checksum: checksum,
+	}
+	if record.Type > RecordCLR {
+		return nil, ErrUnknownRecordType
+	}
+	return []*LogRecord{record}, nil
+}
+
+// decodeBatch splits the payload of a batch frame into its records
+func decodeBatch(raw []byte) ([]*LogRecord, error) {
+	var records []*LogRecord
+	for len(raw) > 0 {
+		record, n, err := decodePlain(raw)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+		raw = raw[n:]
+	}
+	if len(records) == 0 {
+		return nil, ErrInvalidRecord
+	}
+	return records, nil
+}
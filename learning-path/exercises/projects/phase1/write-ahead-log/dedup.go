@@ -0,0 +1,194 @@
+package wal
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrBadDedupState is returned by DedupWindow.UnmarshalBinary for data
+// that MarshalBinary did not produce
+var ErrBadDedupState = errors.New("wal: bad dedup window state")
+
+// RecordID is a globally unique record identifier, a random (version 4)
+// UUID. Unlike the LSN it does not change when a record is appended again,
+// for example by a producer retrying after a failover, so consumers can use
+// it to tell a replayed record from a new one.
+type RecordID [16]byte
+
+// NewRecordID returns a random RecordID
+func NewRecordID() (RecordID, error) {
+	var id RecordID
+	if _, err := rand.Read(id[:]); err != nil {
+		return RecordID{}, err
+	}
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return id, nil
+}
+
+// IsZero reports whether id is unset
+func (id RecordID) IsZero() bool {
+	return id == RecordID{}
+}
+
+// String formats id as a UUID
+func (id RecordID) String() string {
+	var buf [36]byte
+	hex.Encode(buf[0:8], id[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], id[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], id[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], id[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], id[10:])
+	return string(buf[:])
+}
+
+// assignID gives record a new ID if the log assigns them and it has none
+func (w *WAL) assignID(record *LogRecord) error {
+	if !w.opts.RecordIDs || !record.ID.IsZero() {
+		return nil
+	}
+	id, err := NewRecordID()
+	if err != nil {
+		return fmt.Errorf("wal: record ID: %w", err)
+	}
+	record.ID = id
+	return nil
+}
+
+// DedupWindow remembers the IDs of the last records a consumer applied, so
+// records delivered again after a reconnect or a replay are skipped. With
+// the window saved atomically with the consumer's state, each record is
+// applied exactly once as long as duplicates arrive within Size records of
+// the original.
+//
+//	window := wal.NewDedupWindow(100_000)
+//	for record, err := range w.Follow(ctx, from) {
+//		if err != nil { ... }
+//		if _, err := window.Apply(record, replica.Apply); err != nil { ... }
+//	}
+//
+// A DedupWindow is safe for concurrent use.
+type DedupWindow struct {
+	mu   sync.Mutex
+	size int
+	ring []RecordID // the IDs in the window, oldest at next once full
+	next int
+	seen map[RecordID]struct{}
+}
+
+// NewDedupWindow returns an empty window of the last size IDs
+func NewDedupWindow(size int) *DedupWindow {
+	return &DedupWindow{size: max(size, 1), seen: make(map[RecordID]struct{})}
+}
+
+// Size returns the number of IDs the window holds when full
+func (d *DedupWindow) Size() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.size
+}
+
+// Len returns the number of IDs in the window
+func (d *DedupWindow) Len() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.ring)
+}
+
+// Seen reports whether id is in the window
+func (d *DedupWindow) Seen(id RecordID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.seen[id]
+	return ok
+}
+
+// Add puts id in the window, dropping the oldest ID if it is full. It
+// returns false, changing nothing, if id is already there or zero.
+func (d *DedupWindow) Add(id RecordID) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.addLocked(id)
+}
+
+func (d *DedupWindow) addLocked(id RecordID) bool {
+	if _, ok := d.seen[id]; ok || id.IsZero() {
+		return false
+	}
+	if len(d.ring) < d.size {
+		d.ring = append(d.ring, id)
+	} else {
+		delete(d.seen, d.ring[d.next])
+		d.ring[d.next] = id
+		d.next = (d.next + 1) % len(d.ring)
+	}
+	d.seen[id] = struct{}{}
+	return true
+}
+
+// Apply calls apply with record unless its ID is in the window, and adds
+// the ID once apply succeeds. It reports whether apply was called. Records
+// without an ID cannot be deduplicated and are always applied. Concurrent
+// Applies are serialized, so two copies of a record are never applied at
+// once.
+func (d *DedupWindow) Apply(record *LogRecord, apply func(*LogRecord) error) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.seen[record.ID]; ok {
+		return false, nil
+	}
+	if err := apply(record); err != nil {
+		return true, err
+	}
+	d.addLocked(record.ID)
+	return true, nil
+}
+
+// MarshalBinary encodes the window, so a consumer can save it with the
+// state it applied records to
+// Format: Size(4) + Count(4) + IDs(16 each), oldest first
+func (d *DedupWindow) MarshalBinary() ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	buf := make([]byte, 8, 8+len(d.ring)*len(RecordID{}))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(d.size))
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(d.ring)))
+	for i := range d.ring {
+		id := d.ring[(d.next+i)%len(d.ring)]
+		buf = append(buf, id[:]...)
+	}
+	return buf, nil
+}
+
+// UnmarshalBinary replaces the window with one encoded by MarshalBinary
+func (d *DedupWindow) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return ErrBadDedupState
+	}
+	size := int(binary.LittleEndian.Uint32(data[0:4]))
+	count := int(binary.LittleEndian.Uint32(data[4:8]))
+	if size < 1 || count > size || len(data) != 8+count*len(RecordID{}) {
+		return ErrBadDedupState
+	}
+	restored := NewDedupWindow(size)
+	for i := range count {
+		var id RecordID
+		copy(id[:], data[8+i*len(id):])
+		if !restored.addLocked(id) {
+			return ErrBadDedupState
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.size, d.ring, d.next, d.seen = restored.size, restored.ring, restored.next, restored.seen
+	return nil
+}
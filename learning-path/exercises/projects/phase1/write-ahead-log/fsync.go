@@ -0,0 +1,179 @@
+package wal
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// SyncStats counts the work done for FlushUpTo
+type SyncStats struct {
+	Requests uint64 // FlushUpTo calls that had to wait for a sync
+	Syncs    uint64 // fdatasyncs issued for them
+}
+
+// syncer is the goroutine behind FlushUpTo. A sync covers every record
+// written before it starts, so callers that arrive while one is running
+// share the next.
+type syncer struct {
+	wake chan struct{} // buffered; waiters are pending
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	waiters []syncWaiter
+	stopped bool
+
+	requests atomic.Uint64
+	syncs    atomic.Uint64
+}
+
+type syncWaiter struct {
+	lsn  LSN
+	done chan error // buffered
+}
+
+func (w *WAL) startSyncer() {
+	w.syncer = &syncer{
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.syncLoop()
+}
+
+func (w *WAL) stopSyncer() {
+	close(w.syncer.stop)
+	<-w.syncer.done
+}
+
+// FlushUpTo makes every record up to lsn durable. Unlike Flush it does not
+// hold the writer lock while syncing: buffered records are written under it,
+// then the caller waits for a dedicated goroutine that coalesces concurrent
+// requests into one fdatasync, so appends and writes continue meanwhile.
+func (w *WAL) FlushUpTo(lsn LSN) error {
+	if lsn <= w.GetFlushLSN() {
+		return nil
+	}
+	w.mu.RLock()
+	w.writeMu.Lock()
+	err := w.writeBuffered()
+	lsn = min(lsn, w.writtenLSN)
+	w.writeMu.Unlock()
+	w.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if lsn <= w.GetFlushLSN() {
+		return nil
+	}
+	return w.syncer.await(lsn)
+}
+
+// SyncStats reports how well FlushUpTo requests were coalesced
+func (w *WAL) SyncStats() SyncStats {
+	return SyncStats{
+		Requests: w.syncer.requests.Load(),
+		Syncs:    w.syncer.syncs.Load(),
+	}
+}
+
+func (s *syncer) await(lsn LSN) error {
+	done := make(chan error, 1)
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrLogClosed
+	}
+	s.waiters = append(s.waiters, syncWaiter{lsn: lsn, done: done})
+	s.mu.Unlock()
+	s.requests.Add(1)
+	s.kick()
+	return <-done
+}
+
+func (s *syncer) kick() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take removes the pending waiters
+func (s *syncer) take(stop bool) []syncWaiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stopped = s.stopped || stop
+	waiters := s.waiters
+	s.waiters = nil
+	return waiters
+}
+
+func (w *WAL) syncLoop() {
+	s := w.syncer
+	defer close(s.done)
+	for {
+		select {
+		case <-s.wake:
+			if rest := w.syncPass(s.take(false)); len(rest) > 0 {
+				s.mu.Lock()
+				s.waiters = append(rest, s.waiters...)
+				s.mu.Unlock()
+				s.kick()
+			}
+		case <-s.stop:
+			for waiters := s.take(true); len(waiters) > 0; {
+				waiters = w.syncPass(waiters)
+			}
+			return
+		}
+	}
+}
+
+// syncPass syncs the active file once, resolves the waiters it covers and
+// returns the rest: those whose records went to a segment rotated in while
+// it ran.
+func (w *WAL) syncPass(waiters []syncWaiter) []syncWaiter {
+	if len(waiters) == 0 {
+		return nil
+	}
+	w.mu.RLock()
+	w.writeMu.Lock()
+	file, written := w.file, w.writtenLSN
+	w.writeMu.Unlock()
+	w.mu.RUnlock()
+
+	var err error
+	replaced := false
+	if written > w.GetFlushLSN() {
+		err = datasync(file)
+		w.syncer.syncs.Add(1)
+		w.mu.RLock()
+		w.writeMu.Lock()
+		if w.file != file {
+			// Rotation, truncation and Close sync the file before
+			// replacing it, so its records are durable regardless
+			err, replaced = nil, true
+		} else if err == nil && written > w.GetFlushLSN() {
+			w.setFlushed(written)
+		}
+		w.writeMu.Unlock()
+		w.mu.RUnlock()
+	}
+
+	flushed := w.GetFlushLSN()
+	var rest []syncWaiter
+	for _, waiter := range waiters {
+		switch {
+		case err != nil:
+			waiter.done <- err
+		case waiter.lsn <= flushed:
+			waiter.done <- nil
+		case replaced:
+			rest = append(rest, waiter)
+		default:
+			// RecoverTo removed the record
+			waiter.done <- ErrLSNTruncated
+		}
+	}
+	return rest
+}
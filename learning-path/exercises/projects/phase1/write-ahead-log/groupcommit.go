@@ -0,0 +1,253 @@
+package wal
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// GroupCommitOptions bounds how long a commit may wait to share an fsync
+type GroupCommitOptions struct {
+	// MaxLatency is how long after the first waiting commit the batch is
+	// flushed (default 2ms)
+	MaxLatency time.Duration
+	// MaxBytes flushes the batch early once this many bytes are buffered
+	// (default 32KB)
+	MaxBytes int
+}
+
+func (o GroupCommitOptions) withDefaults() GroupCommitOptions {
+	if o.MaxLatency <= 0 {
+		o.MaxLatency = 2 * time.Millisecond
+	}
+	if o.MaxBytes <= 0 {
+		o.MaxBytes = 32 << 10
+	}
+	return o
+}
+
+// CommitFuture is resolved once every record up to its LSN is durable
+type CommitFuture struct {
+	lsn  LSN
+	done chan struct{}
+	err  error
+}
+
+func newCommitFuture(lsn LSN) *CommitFuture {
+	return &CommitFuture{lsn: lsn, done: make(chan struct{})}
+}
+
+func (c *CommitFuture) resolve(err error) {
+	c.err = err
+	close(c.done)
+}
+
+// LSN returns the LSN the future waits for
+func (c *CommitFuture) LSN() LSN {
+	return c.lsn
+}
+
+// Done returns a channel closed when the future is resolved
+func (c *CommitFuture) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the flush error, or nil if the LSN is durable. It is only
+// meaningful after Done is closed.
+func (c *CommitFuture) Err() error {
+	select {
+	case <-c.done:
+		return c.err
+	default:
+		return nil
+	}
+}
+
+// Wait blocks until the future is resolved and returns its error
+func (c *CommitFuture) Wait() error {
+	<-c.done
+	return c.err
+}
+
+// GroupCommitStats counts the work done by a GroupCommitFlusher
+type GroupCommitStats struct {
+	Commits uint64 // futures resolved
+	Flushes uint64 // fsync batches that resolved at least one future
+}
+
+// GroupCommitFlusher performs group commits: concurrent committers wait on
+// futures and share one fsync, issued once the oldest has waited MaxLatency
+// or MaxBytes of log are buffered, whichever comes first
+type GroupCommitFlusher struct {
+	wal      *WAL
+	interval time.Duration
+	opts     GroupCommitOptions
+	stopCh   chan struct{}
+	doneCh   chan struct{}
+	commitCh chan *CommitFuture
+	stopMu   sync.RWMutex // held for writing by Stop, for reading by senders
+	stopped  bool
+
+	commits atomic.Uint64
+	flushes atomic.Uint64
+}
+
+// NewGroupCommitFlusher creates a new group commit flusher. If interval is
+// positive the log is also flushed that often without any waiting commit.
+func NewGroupCommitFlusher(wal *WAL, interval time.Duration) *GroupCommitFlusher {
+	return &GroupCommitFlusher{
+		wal:      wal,
+		interval: interval,
+		opts:     wal.opts.GroupCommit.withDefaults(),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+		commitCh: make(chan *CommitFuture, 100),
+	}
+}
+
+// Start starts the background flusher
+func (f *GroupCommitFlusher) Start() {
+	go f.run()
+}
+
+func (f *GroupCommitFlusher) run() {
+	defer close(f.doneCh)
+
+	var tick <-chan time.Time
+	if f.interval > 0 {
+		ticker := time.NewTicker(f.interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	// deadline fires MaxLatency after the oldest pending future arrived
+	deadline := time.NewTimer(f.opts.MaxLatency)
+	deadline.Stop()
+	defer deadline.Stop()
+
+	var pending []*CommitFuture
+	flush := func() {
+		err := f.wal.FlushUpTo(f.wal.GetCurrentLSN())
+		flushed := f.wal.GetFlushLSN()
+		resolved := 0
+		keep := pending[:0]
+		for _, c := range pending {
+			if err == nil && c.lsn > flushed {
+				keep = append(keep, c)
+				continue
+			}
+			c.resolve(err)
+			resolved++
+		}
+		clear(pending[len(keep):])
+		pending = keep
+		if resolved > 0 {
+			f.commits.Add(uint64(resolved))
+			f.flushes.Add(1)
+		}
+		if len(pending) == 0 {
+			deadline.Stop()
+		}
+	}
+
+	for {
+		select {
+		case c := <-f.commitCh:
+			if len(pending) == 0 {
+				deadline.Reset(f.opts.MaxLatency)
+			}
+			pending = append(pending, c)
+			if f.wal.buffer.Size() >= f.opts.MaxBytes {
+				flush()
+			}
+		case <-deadline.C:
+			flush()
+		case <-tick:
+			flush()
+		case <-f.stopCh:
+			// No sender is left once stopCh is closed
+			for len(f.commitCh) > 0 {
+				pending = append(pending, <-f.commitCh)
+			}
+			flush()
+			// Anything still pending was never appended
+			for _, c := range pending {
+				c.resolve(ErrLogClosed)
+			}
+			return
+		}
+	}
+}
+
+// Await returns a future resolved once every record up to lsn is durable
+func (f *GroupCommitFlusher) Await(lsn LSN) *CommitFuture {
+	c := newCommitFuture(lsn)
+	if lsn <= f.wal.GetFlushLSN() {
+		f.commits.Add(1)
+		c.resolve(nil)
+		return c
+	}
+	f.stopMu.RLock()
+	defer f.stopMu.RUnlock()
+	if f.stopped {
+		c.resolve(ErrLogClosed)
+		return c
+	}
+	f.commitCh <- c
+	return c
+}
+
+// Commit waits until everything appended so far is durable
+func (f *GroupCommitFlusher) Commit() error {
+	return f.Await(f.wal.GetCurrentLSN()).Wait()
+}
+
+// Stats returns the commits and fsync batches handled so far
+func (f *GroupCommitFlusher) Stats() GroupCommitStats {
+	return GroupCommitStats{
+		Commits: f.commits.Load(),
+		Flushes: f.flushes.Load(),
+	}
+}
+
+// Stop stops the flusher after a final flush
+func (f *GroupCommitFlusher) Stop() {
+	f.stopMu.Lock()
+	f.stopped = true
+	close(f.stopCh)
+	f.stopMu.Unlock()
+	<-f.doneCh
+}
+
+// CommitAsync appends a COMMIT record for txnID, stamped with the commit
+// time, and returns a future that is resolved when it is durable. Without a
+// group commit flusher the log is flushed before CommitAsync returns.
+func (w *WAL) CommitAsync(txnID TxnID) (*CommitFuture, error) {
+	lsn, err := w.Append(&LogRecord{Type: RecordCommit, TxnID: txnID, Data: encodeCommitTime(time.Now())})
+	if err != nil {
+		return nil, err
+	}
+	if w.flusher != nil {
+		return w.flusher.Await(lsn), nil
+	}
+	c := newCommitFuture(lsn)
+	c.resolve(w.Flush())
+	return c, nil
+}
+
+// Commit appends a COMMIT record for txnID and waits until it is durable
+func (w *WAL) Commit(txnID TxnID) error {
+	c, err := w.CommitAsync(txnID)
+	if err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// GroupCommitStats reports group commit batching, or zero stats if the log
+// has no flusher
+func (w *WAL) GroupCommitStats() GroupCommitStats {
+	if w.flusher == nil {
+		return GroupCommitStats{}
+	}
+	return w.flusher.Stats()
+}
@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"hash/crc32"
 	"io"
 	"os"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -21,19 +23,85 @@ type RecordType byte
 const (
 	RecordBegin RecordType = iota
 	RecordCommit
+	// RecordAbort ends a transaction whose updates have been rolled back
+	// and compensated by CLRs
 	RecordAbort
+	// RecordUpdate carries an encoded Update with undo and redo images
 	RecordUpdate
 	RecordCheckpoint
+	// RecordCLR is a redo-only compensation record written when an update
+	// is undone; its Update names the next update to undo
+	RecordCLR
+)
+
+// String returns the name of the record type
+func (t RecordType) String() string {
+	switch t {
+	case RecordBegin:
+		return "BEGIN"
+	case RecordCommit:
+		return "COMMIT"
+	case RecordAbort:
+		return "ABORT"
+	case RecordUpdate:
+		return "UPDATE"
+	case RecordCheckpoint:
+		return "CHECKPOINT"
+	case RecordCLR:
+		return "CLR"
+	default:
+		return fmt.Sprintf("RecordType(%d)", byte(t))
+	}
+}
+
+const (
+	// recordMagic starts every record so readers can tell a record boundary
+	// from the garbage of a torn write
+	recordMagic uint32 = 0x524c4157 // "WALR"
+
+	// recordMagicV1 starts a versioned record, whose body opens with a
+	// format version and a block of optional fields before the data.
+	// Records written before versioning keep recordMagic and stay readable.
+	recordMagicV1 uint32 = 0x564c4157 // "WALV"
+
+	// recordVersion is the body layout written by Encode. It changes only
+	// when old readers could not skip what is new; additions that they can
+	// ignore go in optional fields.
+	recordVersion = 1
+
+	// versionHeaderSize is Version(1) + FieldsLength(2)
+	versionHeaderSize = 3
+
+	// fieldRequired marks a field tag that readers must understand to
+	// decode the record; other unknown fields are skipped
+	fieldRequired = 0x80
+
+	// fieldRecordID holds the record's RecordID. It is optional, so
+	// readers that predate it still read the record.
+	fieldRecordID = 0x02
+
+	// recordHeaderSize is Magic(4) + LSN(8) + Type(1) + TxnID(8) + Length(4) + Checksum(4)
+	recordHeaderSize = 29
+
+	// maxRecordSize guards against allocating for a corrupted length field
+	maxRecordSize = 64 << 20
+
+	defaultBufferSize  = 64 << 10
+	defaultBufferSlots = 4096
 )
 
 // Errors
 var (
 	ErrInvalidRecord     = errors.New("invalid log record")
+	ErrBadMagic          = errors.New("bad record magic")
 	ErrTruncatedRecord   = errors.New("truncated log record")
 	ErrChecksumMismatch  = errors.New("checksum mismatch")
 	ErrUnknownRecordType = errors.New("unknown record type")
 	ErrSimulatedCrash    = errors.New("simulated crash")
 	ErrLogClosed         = errors.New("log is closed")
+	// ErrUnsupportedFormat reports a record written in a later format
+	// version, or with a required field this version does not know
+	ErrUnsupportedFormat = errors.New("unsupported record format")
 )
 
 // LogRecord represents a WAL record
@@ -43,101 +111,415 @@ type LogRecord struct {
 	TxnID    TxnID
 	Data     []byte
 	Checksum uint32
+	// ID, if not zero, identifies the record across logs and retries; see
+	// WALOptions.RecordIDs and DedupWindow
+	ID RecordID
 }
 
 // Encode serializes a log record to bytes
+// Format: Magic(4) + LSN(8) + Type(1) + TxnID(8) + Length(4) + Checksum(4) + Body(Length)
+// Body: Version(1) + FieldsLength(2) + Fields(FieldsLength) + Data(variable)
 func (r *LogRecord) Encode() []byte {
-	// TODO: Implement log record encoding
-	// Format: LSN(8) + Type(1) + TxnID(8) + Length(4) + Checksum(4) + Data(variable)
-	return nil
+	return encodeVersioned(r, recordVersion, r.fields())
+}
+
+// fields returns the optional fields Encode writes for r
+func (r *LogRecord) fields() []recordField {
+	if r.ID.IsZero() {
+		return nil
+	}
+	return []recordField{{tag: fieldRecordID, value: r.ID[:]}}
+}
+
+// recordField is an optional field of a versioned record, encoded as
+// Tag(1) + Length(2) + Value.
+type recordField struct {
+	tag   byte
+	value []byte
+}
+
+// encodeVersioned encodes r with the given format version and fields, so
+// tests can produce the records of later writers
+func encodeVersioned(r *LogRecord, version byte, fields []recordField) []byte {
+	fieldsLen := 0
+	for _, f := range fields {
+		fieldsLen += 3 + len(f.value)
+	}
+	bodyLen := versionHeaderSize + fieldsLen + len(r.Data)
+	buf := make([]byte, recordHeaderSize+bodyLen)
+
+	binary.LittleEndian.PutUint32(buf[0:4], recordMagicV1)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(r.LSN))
+	buf[12] = byte(r.Type)
+	binary.LittleEndian.PutUint64(buf[13:21], uint64(r.TxnID))
+	binary.LittleEndian.PutUint32(buf[21:25], uint32(bodyLen))
+
+	body := buf[recordHeaderSize:]
+	body[0] = version
+	binary.LittleEndian.PutUint16(body[1:3], uint16(fieldsLen))
+	pos := versionHeaderSize
+	for _, f := range fields {
+		body[pos] = f.tag
+		binary.LittleEndian.PutUint16(body[pos+1:pos+3], uint16(len(f.value)))
+		pos += 3 + copy(body[pos+3:], f.value)
+	}
+	copy(body[pos:], r.Data)
+
+	// Checksum covers the whole record with the checksum field zeroed
+	r.Checksum = computeChecksum(buf)
+	binary.LittleEndian.PutUint32(buf[25:29], r.Checksum)
+
+	return buf
 }
 
-// DecodeLogRecord deserializes a log record from bytes
+// encodedSize returns the size of r's encoding
+func (r *LogRecord) encodedSize() int {
+	size := recordHeaderSize + versionHeaderSize + len(r.Data)
+	if !r.ID.IsZero() {
+		size += 3 + len(r.ID)
+	}
+	return size
+}
+
+// DecodeLogRecord deserializes a log record from bytes. data may also be a
+// compressed frame holding a single record.
 func DecodeLogRecord(data []byte) (*LogRecord, error) {
-	// TODO: Implement log record decoding
-	// Verify checksum and validate structure
-	return nil, nil
+	records, err := decodeFrame(data, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != 1 {
+		return nil, ErrInvalidRecord
+	}
+	return records[0], nil
+}
+
+// decodePlain decodes an uncompressed record, versioned or written before
+// versioning, and returns it with the size of its encoding
+func decodePlain(data []byte) (*LogRecord, int, error) {
+	if len(data) < recordHeaderSize {
+		return nil, 0, ErrTruncatedRecord
+	}
+
+	magic := binary.LittleEndian.Uint32(data[0:4])
+	if magic != recordMagic && magic != recordMagicV1 {
+		return nil, 0, ErrBadMagic
+	}
+
+	record := &LogRecord{
+		LSN:   LSN(binary.LittleEndian.Uint64(data[4:12])),
+		Type:  RecordType(data[12]),
+		TxnID: TxnID(binary.LittleEndian.Uint64(data[13:21])),
+	}
+	if record.Type > RecordCLR {
+		return nil, 0, ErrUnknownRecordType
+	}
+
+	dataLen := int(binary.LittleEndian.Uint32(data[21:25]))
+	checksum := binary.LittleEndian.Uint32(data[25:29])
+	if dataLen > maxRecordSize {
+		return nil, 0, ErrInvalidRecord
+	}
+	if len(data) < recordHeaderSize+dataLen {
+		return nil, 0, ErrTruncatedRecord
+	}
+
+	buf := make([]byte, recordHeaderSize+dataLen)
+	copy(buf, data)
+	binary.LittleEndian.PutUint32(buf[25:29], 0)
+	if computeChecksum(buf) != checksum {
+		return nil, 0, ErrChecksumMismatch
+	}
+
+	record.Data = buf[recordHeaderSize:]
+	record.Checksum = checksum
+	if magic == recordMagicV1 {
+		if err := decodeBody(record); err != nil {
+			return nil, 0, err
+		}
+	}
+	return record, len(buf), nil
+}
+
+// decodeBody replaces the Data of record, a versioned record body, with
+// the data after the fields. It reads the fields it knows and skips the
+// optional ones it does not.
+func decodeBody(record *LogRecord) error {
+	body := record.Data
+	if len(body) < versionHeaderSize {
+		return ErrInvalidRecord
+	}
+	if version := body[0]; version != recordVersion {
+		return fmt.Errorf("%w: version %d", ErrUnsupportedFormat, version)
+	}
+	fieldsLen := int(binary.LittleEndian.Uint16(body[1:3]))
+	if versionHeaderSize+fieldsLen > len(body) {
+		return ErrInvalidRecord
+	}
+	fields := body[versionHeaderSize : versionHeaderSize+fieldsLen]
+	for len(fields) > 0 {
+		if len(fields) < 3 {
+			return ErrInvalidRecord
+		}
+		tag, n := fields[0], int(binary.LittleEndian.Uint16(fields[1:3]))
+		if 3+n > len(fields) {
+			return ErrInvalidRecord
+		}
+		value := fields[3 : 3+n]
+		switch {
+		case tag == fieldRecordID:
+			if n != len(record.ID) {
+				return ErrInvalidRecord
+			}
+			copy(record.ID[:], value)
+		case tag&fieldRequired != 0:
+			// No required fields are defined yet
+			return fmt.Errorf("%w: required field %#x", ErrUnsupportedFormat, tag)
+		}
+		fields = fields[3+n:]
+	}
+	record.Data = body[versionHeaderSize+fieldsLen:]
+	return nil
+}
+
+// defaultReadBufferSize is the buffer a log file is read through
+const defaultReadBufferSize = 64 << 10
+
+// frameReader reads the frames of a log file through a fixed-size buffer.
+// Each frame is read into the same scratch slice and decoded from there,
+// so reading a log of any size holds the buffer, the largest frame and
+// the records of the current one.
+type frameReader struct {
+	r     *bufio.Reader
+	keys  KeyProvider
+	frame []byte
+}
+
+func newFrameReader(r io.Reader, size int, keys KeyProvider) *frameReader {
+	return &frameReader{r: bufio.NewReaderSize(r, size), keys: keys}
+}
+
+// reset discards what is buffered and reads from r next, for a seek
+func (fr *frameReader) reset(r io.Reader) {
+	fr.r.Reset(r)
+}
+
+// next reads the next record, or compressed or encrypted frame of records,
+// and returns its size. It returns io.EOF at a clean end of log and
+// ErrTruncatedRecord if the log ends inside a frame. The records do not
+// share memory with the reader, so they outlive the next call.
+func (fr *frameReader) next() ([]*LogRecord, int, error) {
+	r := fr.r
+	var header [recordHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, 0, ErrTruncatedRecord
+		}
+		return nil, 0, err
+	}
+
+	headerSize := frameHeaderLen(binary.LittleEndian.Uint32(header[0:4]))
+	if headerSize == 0 {
+		return nil, 0, ErrBadMagic
+	}
+	dataLen := int(binary.LittleEndian.Uint32(header[21:25]))
+	if dataLen > maxRecordSize {
+		return nil, 0, ErrInvalidRecord
+	}
+
+	// decodeFrame copies what it keeps, so the scratch slice is reused
+	if cap(fr.frame) < headerSize+dataLen {
+		fr.frame = make([]byte, headerSize+dataLen)
+	}
+	full := fr.frame[:headerSize+dataLen]
+	copy(full, header[:])
+	if _, err := io.ReadFull(r, full[recordHeaderSize:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, ErrTruncatedRecord
+		}
+		return nil, 0, err
+	}
+
+	records, err := decodeFrame(full, fr.keys)
+	if err != nil {
+		return nil, 0, err
+	}
+	return records, len(full), nil
+}
+
+// errStopScan ends a scan early without reporting an error
+var errStopScan = errors.New("stop scan")
+
+// scanLog calls fn for every record in the log at path, in log order. path
+// is either a single log file or a directory of segments; offsets in a
+// segmented log count from the start of the oldest remaining segment. It
+// stops at the first undecodable record and returns its error.
+func scanLog(path string, keys KeyProvider, fn func(record *LogRecord, offset int64) error) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return scanFile(path, 0, keys, fn)
+	}
+
+	segments, err := ListSegments(path)
+	if err != nil {
+		return err
+	}
+	var base int64
+	for _, segment := range segments {
+		if err := scanFile(segment.Path, base, keys, fn); err != nil {
+			return err
+		}
+		base += segment.Size
+	}
+	return nil
+}
+
+// scanFile scans one log file whose first byte is at offset base
+func scanFile(path string, base int64, keys KeyProvider, fn func(record *LogRecord, offset int64) error) error {
+	return scanFileBuffered(path, base, keys, defaultReadBufferSize, fn)
+}
+
+// scanFileBuffered is scanFile reading through a buffer of size bytes
+func scanFileBuffered(path string, base int64, keys KeyProvider, size int, fn func(record *LogRecord, offset int64) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := newFrameReader(file, size, keys)
+	offset := base
+	for {
+		records, n, err := reader.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return &RecordError{Offset: offset, Err: err}
+		}
+		// Records of a compressed batch share the batch's offset
+		for _, record := range records {
+			if err := fn(record, offset); err != nil {
+				return err
+			}
+		}
+		offset += int64(n)
+	}
+}
+
+// RecordError reports a record that could not be read from the log
+type RecordError struct {
+	Offset int64
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("wal: record at offset %d: %v", e.Offset, e.Err)
+}
+
+func (e *RecordError) Unwrap() error {
+	return e.Err
 }
 
 // RecoveryHandler is called during recovery for each record
 type RecoveryHandler interface {
 	OnBegin(txnID TxnID, lsn LSN) error
 	OnCommit(txnID TxnID, lsn LSN) error
+	// OnAbort is also called for each loser once recovery has undone it
 	OnAbort(txnID TxnID, lsn LSN) error
+	// OnUpdate applies the After image of the encoded Update in data and
+	// sets the page LSN to lsn. It is called for updates and CLRs during
+	// redo and for the CLRs written by undo.
 	OnUpdate(txnID TxnID, lsn LSN, data []byte) error
 	OnCheckpoint(lsn LSN) error
 }
 
-// LogBuffer buffers log records before flushing
+// LogBuffer buffers log records before flushing. It is a ring of record
+// slots: Reserve assigns a record the next LSN, and with it a slot, in one
+// CAS, so concurrent appenders never wait for each other. Only Drain, which
+// hands the records to the writer of the log file, is serialized.
 type LogBuffer struct {
-	records []*LogRecord
-	mu      sync.Mutex
+	slots    []atomic.Pointer[LogRecord]
+	reserved atomic.Uint64 // last LSN handed out
+	drained  atomic.Uint64 // last LSN removed by Drain
+	size     atomic.Int64  // encoded bytes of the undrained records
 }
 
-// NewLogBuffer creates a new log buffer
-func NewLogBuffer() *LogBuffer {
-	return &LogBuffer{
-		records: make([]*LogRecord, 0, 100),
+// NewLogBuffer creates a log buffer holding up to slots records
+func NewLogBuffer(slots int) *LogBuffer {
+	if slots <= 0 {
+		slots = defaultBufferSlots
 	}
+	return &LogBuffer{slots: make([]atomic.Pointer[LogRecord], slots)}
 }
 
-// Add adds a record to the buffer
-func (lb *LogBuffer) Add(record *LogRecord) {
-	// TODO: Implement buffering
-	lb.mu.Lock()
-	defer lb.mu.Unlock()
-	lb.records = append(lb.records, record)
+// Reserve assigns record the next LSN and adds it to the buffer. It
+// returns false, assigning nothing, if the buffer is full.
+func (lb *LogBuffer) Reserve(record *LogRecord) (LSN, bool) {
+	for {
+		// drained is read first: it only grows, so a stale value can make
+		// the buffer look fuller than it is but never overfill it
+		drained := lb.drained.Load()
+		last := lb.reserved.Load()
+		if last-drained >= uint64(len(lb.slots)) {
+			return 0, false
+		}
+		if !lb.reserved.CompareAndSwap(last, last+1) {
+			continue
+		}
+		lsn := LSN(last + 1)
+		record.LSN = lsn
+		lb.size.Add(int64(record.encodedSize()))
+		lb.slots[uint64(lsn)%uint64(len(lb.slots))].Store(record)
+		return lsn, true
+	}
 }
 
-// Drain removes and returns all buffered records
+// Drain removes and returns the records reserved so far in LSN order,
+// waiting for those whose Reserve has not yet stored them. Calls to Drain
+// must not overlap.
 func (lb *LogBuffer) Drain() []*LogRecord {
-	// TODO: Implement draining
-	lb.mu.Lock()
-	defer lb.mu.Unlock()
-	records := lb.records
-	lb.records = make([]*LogRecord, 0, 100)
-	return records
-}
-
-// GroupCommitFlusher performs group commits
-type GroupCommitFlusher struct {
-	wal      *WAL
-	interval time.Duration
-	stopCh   chan struct{}
-	doneCh   chan struct{}
-	commitCh chan chan error
-}
-
-// NewGroupCommitFlusher creates a new group commit flusher
-func NewGroupCommitFlusher(wal *WAL, interval time.Duration) *GroupCommitFlusher {
-	return &GroupCommitFlusher{
-		wal:      wal,
-		interval: interval,
-		stopCh:   make(chan struct{}),
-		doneCh:   make(chan struct{}),
-		commitCh: make(chan chan error, 100),
+	from, to := lb.drained.Load(), lb.reserved.Load()
+	if from == to {
+		return nil
+	}
+	records := make([]*LogRecord, 0, to-from)
+	var size int64
+	for lsn := from + 1; lsn <= to; lsn++ {
+		slot := &lb.slots[lsn%uint64(len(lb.slots))]
+		record := slot.Load()
+		for record == nil {
+			// Storing a reserved record never blocks, so this is short
+			runtime.Gosched()
+			record = slot.Load()
+		}
+		slot.Store(nil)
+		records = append(records, record)
+		size += int64(record.encodedSize())
 	}
+	lb.size.Add(-size)
+	lb.drained.Store(to)
+	return records
 }
 
-// Start starts the background flusher
-func (f *GroupCommitFlusher) Start() {
-	// TODO: Implement background flusher
-	// Use ticker to periodically flush buffered records
-	// Handle commit requests from commitCh
+// Size returns the encoded size of the buffered records in bytes
+func (lb *LogBuffer) Size() int {
+	return int(lb.size.Load())
 }
 
-// Commit requests a flush and waits for completion
-func (f *GroupCommitFlusher) Commit() error {
-	// TODO: Implement commit request
-	// Send request on commitCh and wait for response
-	return nil
+// Last returns the last LSN reserved
+func (lb *LogBuffer) Last() LSN {
+	return LSN(lb.reserved.Load())
 }
 
-// Stop stops the flusher
-func (f *GroupCommitFlusher) Stop() {
-	// TODO: Implement graceful shutdown
-	close(f.stopCh)
-	<-f.doneCh
+// reset numbers the next record lsn+1. The buffer must be empty, with no
+// Reserve in progress.
+func (lb *LogBuffer) reset(lsn LSN) {
+	lb.reserved.Store(uint64(lsn))
+	lb.drained.Store(uint64(lsn))
 }
 
 // WALOptions configures the WAL
@@ -146,98 +528,445 @@ type WALOptions struct {
 	BufferSize    int
 	FlushInterval time.Duration
 	SyncOnCommit  bool
+
+	// BufferSlots bounds the number of records buffered between writes
+	// (default 4096). An append that finds the buffer full writes it out
+	// first.
+	BufferSlots int
+
+	// Dir, when set instead of FilePath, stores the log as a sequence of
+	// segment files named by their starting LSN. A segment is rotated once
+	// it reaches SegmentSize bytes (default 16MB); records never span
+	// segments.
+	Dir         string
+	SegmentSize int64
+
+	// Compression compresses record payloads, each on its own or a whole
+	// flush at a time depending on CompressionMode. Payloads that do not
+	// shrink are stored as before, and logs written without compression
+	// stay readable.
+	Compression     Compression
+	CompressionMode CompressionMode
+
+	// EncryptionKeyProvider, if set, encrypts record payloads with
+	// AES-GCM under its current key. CompressBatches also encrypts a
+	// flush as one frame.
+	EncryptionKeyProvider KeyProvider
+
+	// Archiver, if set, receives every segment once it is complete. It
+	// requires Dir.
+	Archiver Archiver
+
+	// Preallocate reserves the disk space of the next segment in the
+	// background, so a rotation renames a ready file into place and writes
+	// to it do not allocate blocks. It requires Dir.
+	Preallocate bool
+
+	// GroupCommit bounds how long CommitAsync waits for other commits to
+	// share its fsync. The flusher runs when this or FlushInterval is set.
+	GroupCommit GroupCommitOptions
+
+	// RecordIDs gives every appended record without an ID a random
+	// RecordID, so consumers can drop records they have already applied
+	// with a DedupWindow. A record that already has an ID, such as one a
+	// producer is retrying, keeps it.
+	RecordIDs bool
 }
 
 // WAL is the write-ahead log
 type WAL struct {
 	file       *os.File
-	currentLSN atomic.Uint64
 	flushLSN   atomic.Uint64
-	buffer     *LogBuffer
+	buffer     *LogBuffer // assigns LSNs
 	flusher    *GroupCommitFlusher
-	mu         sync.RWMutex
 	opts       WALOptions
 	closed     atomic.Bool
+	segSize    int64     // bytes in the active segment
+	writtenLSN LSN       // last record written to the file, synced or not
+	tornBytes  int64     // torn tail discarded when the log was opened
+	codec      Codec     // nil without compression
+	archiver   *archiver // nil without an Archiver
+	syncer     *syncer
+
+	// Appends hold mu for reading and writers of the buffer also hold
+	// writeMu, so the file state above (file, segSize, writtenLSN, the
+	// spare segment) changes under writeMu or under mu held exclusively
+	// by recovery, truncation and Close.
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+
+	spareReady chan error // preparing the next segment; nil if none is
+	spareWG    sync.WaitGroup
+
+	flushMu sync.Mutex
+	flushed chan struct{} // closed at the next flush, for Follow
 }
 
 // New creates a new WAL
 func New(opts WALOptions) (*WAL, error) {
-	// TODO: Implement WAL creation
-	// Open file, initialize structures, start background flusher
-	return nil, nil
+	if (opts.FilePath == "") == (opts.Dir == "") {
+		return nil, errors.New("wal: exactly one of FilePath and Dir is required")
+	}
+	if opts.Archiver != nil && opts.Dir == "" {
+		return nil, errors.New("wal: Archiver requires a segmented log (Dir)")
+	}
+	if opts.Preallocate && opts.Dir == "" {
+		return nil, errors.New("wal: Preallocate requires a segmented log (Dir)")
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = defaultBufferSize
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = defaultSegmentSize
+	}
+
+	w := &WAL{
+		buffer: NewLogBuffer(opts.BufferSlots),
+		opts:   opts,
+	}
+	if opts.Compression != CompressionNone {
+		codec, err := lookupCodec(opts.Compression)
+		if err != nil {
+			return nil, err
+		}
+		w.codec = codec
+	}
+	if keys := opts.EncryptionKeyProvider; keys != nil {
+		_, key, err := keys.CurrentKey()
+		if err == nil {
+			_, err = newAEAD(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("wal: encryption key: %w", err)
+		}
+	}
+
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+			return nil, err
+		}
+	} else {
+		file, err := os.OpenFile(opts.FilePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w.file = file
+	}
+
+	// Drop a torn write left by a crash before appending after it, then
+	// continue numbering after the last record
+	var lastLSN LSN
+	active, err := w.activePath()
+	if err == nil && active != "" {
+		w.tornBytes, err = repairTail(active, opts.EncryptionKeyProvider)
+	}
+	if err == nil {
+		err = scanLog(w.logPath(), opts.EncryptionKeyProvider, func(record *LogRecord, _ int64) error {
+			lastLSN = max(lastLSN, record.LSN)
+			return nil
+		})
+	}
+	if err != nil {
+		if w.file != nil {
+			w.file.Close()
+		}
+		return nil, err
+	}
+	if opts.Dir != "" {
+		if err := w.openSegments(lastLSN); err != nil {
+			return nil, err
+		}
+		if opts.Preallocate && w.spareReady == nil {
+			w.prepareSpare()
+		}
+	}
+	if opts.Archiver != nil {
+		w.startArchiver()
+	}
+	w.buffer.reset(lastLSN)
+	w.flushLSN.Store(uint64(lastLSN))
+	w.writtenLSN = lastLSN
+	w.startSyncer()
+
+	if opts.FlushInterval > 0 || opts.GroupCommit != (GroupCommitOptions{}) {
+		w.flusher = NewGroupCommitFlusher(w, opts.FlushInterval)
+		w.flusher.Start()
+	}
+
+	return w, nil
 }
 
-// Append appends a log record and returns its LSN
+// Append appends a log record and returns its LSN. Concurrent appends do
+// not wait for each other: each takes its LSN and buffer slot with one CAS.
+// Only writing the buffer to the file, once it is full or flushed, is
+// serialized.
 func (w *WAL) Append(record *LogRecord) (LSN, error) {
-	// TODO: Implement append
-	// 1. Assign LSN
-	// 2. Add to buffer
-	// 3. Optionally sync immediately
-	return 0, nil
+	if err := w.assignID(record); err != nil {
+		return 0, err
+	}
+	for {
+		w.mu.RLock()
+		if w.closed.Load() {
+			w.mu.RUnlock()
+			return 0, ErrLogClosed
+		}
+		lsn, ok := w.buffer.Reserve(record)
+		w.mu.RUnlock()
+		if ok {
+			if w.needsFlush(record) {
+				return lsn, w.Flush()
+			}
+			return lsn, nil
+		}
+
+		// The buffer is full: write it out and try again
+		w.mu.RLock()
+		w.writeMu.Lock()
+		err := w.writeBuffered()
+		w.writeMu.Unlock()
+		w.mu.RUnlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// appendLocked is Append for callers holding w.mu exclusively
+func (w *WAL) appendLocked(record *LogRecord) (LSN, error) {
+	if err := w.assignID(record); err != nil {
+		return 0, err
+	}
+	lsn, ok := w.buffer.Reserve(record)
+	if !ok {
+		if err := w.writeBuffered(); err != nil {
+			return 0, err
+		}
+		lsn, _ = w.buffer.Reserve(record)
+	}
+	if w.needsFlush(record) {
+		if err := w.flushInternal(); err != nil {
+			return lsn, err
+		}
+	}
+	return lsn, nil
+}
+
+// needsFlush reports whether appending record should flush the log
+func (w *WAL) needsFlush(record *LogRecord) bool {
+	return (w.opts.SyncOnCommit && record.Type == RecordCommit) || w.buffer.Size() >= w.opts.BufferSize
 }
 
 // Flush flushes all buffered records to disk
 func (w *WAL) Flush() error {
-	// TODO: Implement flush
-	// 1. Drain buffer
-	// 2. Write records to file
-	// 3. fsync if needed
-	return nil
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	return w.flushInternal()
 }
 
-// flushInternal is the internal flush implementation
+// flushInternal writes buffered records and syncs them. Caller holds
+// w.writeMu or w.mu exclusively.
 func (w *WAL) flushInternal() error {
-	// TODO: Implement internal flush logic
-	return nil
+	if err := w.writeBuffered(); err != nil {
+		return err
+	}
+	return w.syncLocked()
 }
 
-// Recover recovers from the log file
-func (w *WAL) Recover(handler RecoveryHandler) error {
-	// TODO: Implement recovery
-	// 1. Read log file from beginning
-	// 2. Decode records
-	// 3. Call handler for each record
-	// 4. Update currentLSN to max LSN + 1
+// writeBuffered writes the buffered records to the log without syncing the
+// active file; a segment is synced before it is rotated out. Caller holds
+// w.writeMu or w.mu exclusively.
+func (w *WAL) writeBuffered() error {
+	records := w.buffer.Drain()
+	if len(records) == 0 {
+		return nil
+	}
+
+	frames, err := w.encodeFrames(records)
+	if err != nil {
+		return err
+	}
+
+	var out []byte
+	for i, f := range frames {
+		if w.needsRotation(len(out), len(f.data)) {
+			if len(out) > 0 {
+				if err := w.writeSegment(out, frames[i-1].last); err != nil {
+					return err
+				}
+				out = out[:0]
+			}
+			if err := w.syncLocked(); err != nil {
+				return err
+			}
+			if err := w.rotate(f.first); err != nil {
+				return err
+			}
+		}
+		out = append(out, f.data...)
+	}
+	return w.writeSegment(out, records[len(records)-1].LSN)
+}
+
+// writeSegment appends encoded records ending at lastLSN to the active
+// file. Caller holds w.writeMu or w.mu exclusively.
+func (w *WAL) writeSegment(out []byte, lastLSN LSN) error {
+	n, err := w.file.Write(out)
+	w.segSize += int64(n)
+	if err != nil {
+		return err
+	}
+	w.writtenLSN = lastLSN
 	return nil
 }
 
-// handleRecord processes a record during recovery
-func (w *WAL) handleRecord(handler RecoveryHandler, record *LogRecord) error {
-	// TODO: Implement record handling
-	// Call appropriate handler method based on record type
+// syncLocked makes every written record durable. Caller holds w.writeMu
+// or w.mu exclusively.
+func (w *WAL) syncLocked() error {
+	if w.writtenLSN <= LSN(w.flushLSN.Load()) {
+		return nil
+	}
+	if err := datasync(w.file); err != nil {
+		return err
+	}
+	w.setFlushed(w.writtenLSN)
 	return nil
 }
 
-// Checkpoint creates a checkpoint record
-func (w *WAL) Checkpoint() (LSN, error) {
-	// TODO: Implement checkpoint
-	// 1. Create checkpoint record
-	// 2. Append to log
-	// 3. Flush to disk
-	return 0, nil
+// setFlushed advances the flush LSN and wakes followers. Caller holds
+// w.writeMu or w.mu exclusively.
+func (w *WAL) setFlushed(lsn LSN) {
+	w.flushLSN.Store(uint64(lsn))
+	w.notifyFlushed()
+}
+
+// logPath is the file or segment directory holding the log
+func (w *WAL) logPath() string {
+	if w.opts.Dir != "" {
+		return w.opts.Dir
+	}
+	return w.opts.FilePath
 }
 
-// Truncate truncates the log up to the given LSN
+// Checkpoint appends a checkpoint record and flushes it. data, if not nil,
+// holds the active transaction and dirty page tables as of the checkpoint,
+// and recovery starts from them instead of from the start of the log. The
+// tables must not miss updates logged before the checkpoint record, so
+// capture them while no updates are being appended.
+func (w *WAL) Checkpoint(data *CheckpointData) (LSN, error) {
+	record := &LogRecord{Type: RecordCheckpoint}
+	if data != nil {
+		record.Data = data.Encode()
+	}
+	lsn, err := w.Append(record)
+	if err != nil {
+		return 0, err
+	}
+	return lsn, w.Flush()
+}
+
+// Truncate removes records with LSNs below lsn. A segmented log drops whole
+// segments only, so records of the segment containing lsn are kept.
 func (w *WAL) Truncate(lsn LSN) error {
-	// TODO: Implement truncation
-	// 1. Create new log file
-	// 2. Copy records after LSN
-	// 3. Replace old file
-	return nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flushInternal(); err != nil {
+		return err
+	}
+	if w.opts.Dir != "" {
+		return w.truncateSegments(lsn)
+	}
+
+	if err := w.rewriteFile(w.opts.FilePath, func(record *LogRecord) bool { return record.LSN >= lsn }); err != nil {
+		return err
+	}
+	w.file.Close()
+	var err error
+	w.file, err = os.OpenFile(w.opts.FilePath, os.O_RDWR|os.O_APPEND, 0644)
+	return err
+}
+
+// rewriteFile replaces the log file at path with one holding only the
+// records keep accepts. Caller holds w.mu and reopens the file if it is the
+// active one.
+func (w *WAL) rewriteFile(path string, keep func(record *LogRecord) bool) error {
+	tmpPath := path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	// Kept records are re-encoded in chunks so batch compression still
+	// applies to them
+	writer := bufio.NewWriter(tmp)
+	var kept []*LogRecord
+	keptSize := 0
+	writeKept := func() error {
+		frames, err := w.encodeFrames(kept)
+		for _, f := range frames {
+			if err == nil {
+				_, err = writer.Write(f.data)
+			}
+		}
+		kept, keptSize = kept[:0], 0
+		return err
+	}
+	err = scanFile(path, 0, w.opts.EncryptionKeyProvider, func(record *LogRecord, _ int64) error {
+		if !keep(record) {
+			return nil
+		}
+		kept = append(kept, record)
+		keptSize += record.encodedSize()
+		if keptSize >= w.opts.BufferSize {
+			return writeKept()
+		}
+		return nil
+	})
+	if err == nil {
+		err = writeKept()
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
 }
 
 // Close closes the WAL
 func (w *WAL) Close() error {
-	// TODO: Implement cleanup
-	// 1. Stop background flusher
-	// 2. Flush remaining records
-	// 3. Close file
-	return nil
+	if w.closed.Swap(true) {
+		return nil
+	}
+	if w.flusher != nil {
+		w.flusher.Stop()
+	}
+	w.stopArchiver()
+	w.stopSyncer()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.flushInternal()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.spareWG.Wait()
+	w.notifyFlushed()
+	return err
 }
 
 // GetCurrentLSN returns the current LSN
 func (w *WAL) GetCurrentLSN() LSN {
-	return LSN(w.currentLSN.Load())
+	return w.buffer.Last()
 }
 
 // GetFlushLSN returns the last flushed LSN
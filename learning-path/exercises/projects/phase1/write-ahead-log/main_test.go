@@ -1,8 +1,23 @@
 package wal
 
 import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // TestRecoveryHandler is a simple recovery handler for testing
@@ -80,11 +95,130 @@ func TestRecovery(t *testing.T) {
 }
 
 func TestCheckpoint(t *testing.T) {
-	// TODO: Implement test for checkpointing
-	// 1. Write records
-	// 2. Create checkpoint
-	// 3. Verify checkpoint in log
-	t.Skip("not implemented")
+	path := filepath.Join(t.TempDir(), "checkpoint.wal")
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+	first := appendUpdate(t, w, 1, 7, "", "a")
+	plain, err := w.Checkpoint(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := &CheckpointData{
+		ActiveTxns: []ActiveTxn{{TxnID: 1, FirstLSN: 1}},
+		DirtyPages: []DirtyPage{{PageID: 7, RecLSN: first}},
+	}
+	lsn, err := w.Checkpoint(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Checkpoint flushes, so both are durable
+	if w.GetFlushLSN() != lsn {
+		t.Fatalf("flush LSN = %d, want %d", w.GetFlushLSN(), lsn)
+	}
+
+	records := logRecords(t, path)
+	if records[plain-1].Type != RecordCheckpoint || len(records[plain-1].Data) != 0 {
+		t.Errorf("record %d = %s with %d bytes, want empty CHECKPOINT", plain, records[plain-1].Type, len(records[plain-1].Data))
+	}
+	got, err := DecodeCheckpointData(records[lsn-1].Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got.ActiveTxns, data.ActiveTxns) || !slices.Equal(got.DirtyPages, data.DirtyPages) {
+		t.Errorf("decoded %+v, want %+v", got, data)
+	}
+
+	empty, err := DecodeCheckpointData((&CheckpointData{}).Encode())
+	if err != nil || len(empty.ActiveTxns) != 0 || len(empty.DirtyPages) != 0 {
+		t.Errorf("empty tables round trip = %+v, %v", empty, err)
+	}
+	encoded := data.Encode()
+	for _, bad := range [][]byte{nil, encoded[:3], encoded[:len(encoded)-1], append(encoded, 0)} {
+		if _, err := DecodeCheckpointData(bad); !errors.Is(err, ErrInvalidRecord) {
+			t.Errorf("DecodeCheckpointData(%d bytes) error = %v, want ErrInvalidRecord", len(bad), err)
+		}
+	}
+}
+
+// checkpointStore records the checkpoint tables recovery reports
+type checkpointStore struct {
+	*pageStore
+	tables map[LSN]*CheckpointData
+}
+
+func (s *checkpointStore) OnCheckpointData(lsn LSN, data *CheckpointData) error {
+	s.tables[lsn] = data
+	return nil
+}
+
+func TestCheckpointRecovery(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	opts := WALOptions{Dir: dir, SegmentSize: 256}
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Txn 1 commits and its page is flushed before the checkpoint; txn 2
+	// dirties page 2 before it and page 3 after it, then never commits
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+	for i := range 10 {
+		appendUpdate(t, w, 1, 1, fmt.Sprint(i), fmt.Sprint(i+1))
+	}
+	w.Append(&LogRecord{Type: RecordCommit, TxnID: 1})
+	begin2, _ := w.Append(&LogRecord{Type: RecordBegin, TxnID: 2})
+	rec2 := appendUpdate(t, w, 2, 2, "", "t2")
+	ckpt, err := w.Checkpoint(&CheckpointData{
+		ActiveTxns: []ActiveTxn{{TxnID: 2, FirstLSN: begin2}},
+		DirtyPages: []DirtyPage{{PageID: 2, RecLSN: rec2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendUpdate(t, w, 2, 3, "", "t2")
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 3})
+	appendUpdate(t, w, 3, 1, "10", "t3")
+	w.Append(&LogRecord{Type: RecordCommit, TxnID: 3})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ := ListSegments(dir); len(segments) < 3 {
+		t.Fatalf("got %d segments, want the log spread over several", len(segments))
+	}
+
+	w, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	store := &checkpointStore{pageStore: newPageStore(), tables: make(map[LSN]*CheckpointData)}
+	result, err := w.Recover(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.CheckpointLSN != ckpt || result.RedoLSN != rec2 {
+		t.Errorf("checkpoint %d redo from %d, want %d and %d", result.CheckpointLSN, result.RedoLSN, ckpt, rec2)
+	}
+	if data := store.tables[ckpt]; data == nil || len(data.ActiveTxns) != 1 || data.DirtyPages[0].RecLSN != rec2 {
+		t.Errorf("OnCheckpointData got %+v", store.tables)
+	}
+	// Redo skipped txn 1 entirely: three updates from page 2's recLSN on
+	if result.Redone != 3 || slices.Contains(store.begins, 1) || slices.Contains(store.commits, 1) {
+		t.Errorf("redone %d, begins %v commits %v; want 3 updates after the checkpoint's recLSN", result.Redone, store.begins, store.commits)
+	}
+	// Txn 2 is undone on both sides of the checkpoint
+	if !slices.Equal(result.Losers, []TxnID{2}) || result.Undone != 2 {
+		t.Errorf("losers %v undone %d, want txn 2 with 2 updates", result.Losers, result.Undone)
+	}
+	if store.pages[1] != "t3" || store.pages[2] != "" || store.pages[3] != "" {
+		t.Errorf("pages = %q", store.pages)
+	}
 }
 
 func TestTruncate(t *testing.T) {
@@ -97,12 +231,101 @@ func TestTruncate(t *testing.T) {
 }
 
 func TestCrashDuringWrite(t *testing.T) {
-	// TODO: Implement crash simulation test
-	// 1. Write partial record
-	// 2. Simulate crash (close without sync)
-	// 3. Recover
-	// 4. Verify partial record not recovered
-	t.Skip("not implemented")
+	for _, segmented := range []bool{false, true} {
+		name := map[bool]string{false: "file", true: "segments"}[segmented]
+		t.Run(name, func(t *testing.T) {
+			opts := WALOptions{FilePath: filepath.Join(t.TempDir(), "crash.wal")}
+			if segmented {
+				opts = WALOptions{Dir: filepath.Join(t.TempDir(), "wal")}
+			}
+			w, err := New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+			w.Append(&LogRecord{Type: RecordCommit, TxnID: 1})
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The crash left the first half of a record at the tail
+			active := opts.FilePath
+			if segmented {
+				segments, _ := ListSegments(opts.Dir)
+				active = segments[len(segments)-1].Path
+			}
+			partial := (&LogRecord{LSN: 3, Type: RecordBegin, TxnID: 2, Data: []byte("lost")}).Encode()
+			partial = partial[:len(partial)/2]
+			f, err := os.OpenFile(active, os.O_WRONLY|os.O_APPEND, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f.Write(partial)
+			f.Close()
+
+			w, err = New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := w.Recover(NewTestRecoveryHandler())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.TornBytes != int64(len(partial)) {
+				t.Errorf("TornBytes = %d, want %d", result.TornBytes, len(partial))
+			}
+
+			// Records appended after the repair are readable
+			lsn, _ := w.Append(&LogRecord{Type: RecordBegin, TxnID: 3})
+			w.Append(&LogRecord{Type: RecordCommit, TxnID: 3})
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if lsn != 3 {
+				t.Errorf("LSN after repair = %d, want 3", lsn)
+			}
+			if err := VerifyLog(w.logPath()); err != nil {
+				t.Errorf("log not clean after repair: %v", err)
+			}
+		})
+	}
+}
+
+func TestCorruptionIsNotTorn(t *testing.T) {
+	path := writeTestLog(t,
+		&LogRecord{Type: RecordBegin, TxnID: 1},
+		&LogRecord{Type: RecordCommit, TxnID: 1},
+	)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Corrupt the first record; the intact second one proves it is not a
+	// torn tail, so the log must not be truncated
+	data[5] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(WALOptions{FilePath: path}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("New error = %v, want checksum mismatch", err)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Errorf("corrupt log truncated to %d bytes", info.Size())
+	}
+
+	// Garbage without a record magic is torn only at the tail
+	if err := os.WriteFile(path, append(data[recordHeaderSize+versionHeaderSize:], 0xde, 0xad), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if w.tornBytes != 2 {
+		t.Errorf("tornBytes = %d, want 2", w.tornBytes)
+	}
 }
 
 func TestCrashAfterCommit(t *testing.T) {
@@ -124,47 +347,2086 @@ func TestChecksumValidation(t *testing.T) {
 }
 
 func TestGroupCommit(t *testing.T) {
-	// TODO: Implement group commit test
-	// 1. Configure with flush interval
-	// 2. Append multiple records
-	// 3. Verify batched flush
-	t.Skip("not implemented")
+	path := filepath.Join(t.TempDir(), "test.wal")
+	w, err := New(WALOptions{
+		FilePath:    path,
+		GroupCommit: GroupCommitOptions{MaxLatency: 5 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const committers = 50
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range committers {
+		wg.Add(1)
+		go func(txn TxnID) {
+			defer wg.Done()
+			<-start
+			if _, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: txn, Data: []byte("x")}); err != nil {
+				t.Error(err)
+				return
+			}
+			future, err := w.CommitAsync(txn)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if err := future.Wait(); err != nil {
+				t.Error(err)
+				return
+			}
+			if w.GetFlushLSN() < future.LSN() {
+				t.Errorf("txn %d: resolved at flush LSN %d before its LSN %d", txn, w.GetFlushLSN(), future.LSN())
+			}
+		}(TxnID(i + 1))
+	}
+	close(start)
+	wg.Wait()
+
+	stats := w.GroupCommitStats()
+	if stats.Commits != committers || stats.Flushes == 0 || stats.Flushes >= committers {
+		t.Errorf("stats = %+v, want %d commits sharing fewer flushes", stats, committers)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(1); !errors.Is(err, ErrLogClosed) {
+		t.Errorf("Commit after Close = %v, want ErrLogClosed", err)
+	}
+
+	commits := 0
+	scanLog(path, nil, func(record *LogRecord, _ int64) error {
+		if record.Type == RecordCommit {
+			commits++
+		}
+		return nil
+	})
+	if commits != committers {
+		t.Errorf("log has %d commits, want %d", commits, committers)
+	}
+}
+
+func TestGroupCommitThresholds(t *testing.T) {
+	waitFor := func(t *testing.T, future *CommitFuture) {
+		t.Helper()
+		select {
+		case <-future.Done():
+			if err := future.Err(); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("commit at LSN %d never resolved", future.LSN())
+		}
+	}
+
+	t.Run("bytes", func(t *testing.T) {
+		w, err := New(WALOptions{
+			FilePath:    filepath.Join(t.TempDir(), "test.wal"),
+			GroupCommit: GroupCommitOptions{MaxLatency: time.Hour, MaxBytes: 256},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		small, err := w.CommitAsync(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		select {
+		case <-small.Done():
+			t.Fatal("commit flushed below MaxBytes and before MaxLatency")
+		default:
+		}
+
+		// Filling the batch flushes both commits
+		w.Append(&LogRecord{Type: RecordUpdate, TxnID: 2, Data: make([]byte, 256)})
+		large, err := w.CommitAsync(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, large)
+		waitFor(t, small)
+		if stats := w.GroupCommitStats(); stats.Commits != 2 || stats.Flushes != 1 {
+			t.Errorf("stats = %+v, want 2 commits in 1 flush", stats)
+		}
+	})
+
+	t.Run("latency", func(t *testing.T) {
+		const latency = 20 * time.Millisecond
+		w, err := New(WALOptions{
+			FilePath:    filepath.Join(t.TempDir(), "test.wal"),
+			GroupCommit: GroupCommitOptions{MaxLatency: latency, MaxBytes: 1 << 20},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+
+		start := time.Now()
+		future, err := w.CommitAsync(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, future)
+		if elapsed := time.Since(start); elapsed < latency {
+			t.Errorf("resolved after %v, before MaxLatency %v", elapsed, latency)
+		}
+
+		// An LSN that is already durable resolves immediately
+		already := w.flusher.Await(future.LSN())
+		select {
+		case <-already.Done():
+		default:
+			t.Error("future for a durable LSN is not resolved")
+		}
+	})
+}
+
+func TestFlushUpTo(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	w, err := New(WALOptions{Dir: dir, SegmentSize: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, perWriter = 8, 50
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(txnID TxnID) {
+			defer wg.Done()
+			for j := 0; j < perWriter; j++ {
+				lsn, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: txnID, Data: make([]byte, 40)})
+				if err == nil {
+					err = w.FlushUpTo(lsn)
+				}
+				if err == nil && w.GetFlushLSN() < lsn {
+					err = fmt.Errorf("FlushUpTo(%d) returned with flush LSN %d", lsn, w.GetFlushLSN())
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(TxnID(i + 1))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	stats := w.SyncStats()
+	if stats.Syncs == 0 || stats.Syncs > stats.Requests {
+		t.Errorf("stats = %+v, want 1..Requests syncs", stats)
+	}
+	// Records are written before the sync is requested and rotation still
+	// happens under the lock, so every segment is complete
+	if err := w.FlushUpTo(w.GetCurrentLSN() + 10); err != nil {
+		t.Errorf("FlushUpTo past the end: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.FlushUpTo(1); err != nil {
+		t.Errorf("FlushUpTo a durable LSN after Close: %v", err)
+	}
+
+	var n int
+	err = scanLog(dir, nil, func(*LogRecord, int64) error {
+		n++
+		return nil
+	})
+	if err != nil || n != writers*perWriter {
+		t.Errorf("%d records in log (err %v), want %d", n, err, writers*perWriter)
+	}
 }
 
 func TestConcurrentAppend(t *testing.T) {
-	// TODO: Implement concurrent append test
-	// 1. Launch multiple goroutines
-	// 2. Each appends records
-	// 3. Verify all records in log
-	// 4. Verify LSN ordering
-	// Run with: go test -race
-	t.Skip("not implemented")
+	path := filepath.Join(t.TempDir(), "test.wal")
+	// A small buffer fills often, so appenders also write it out
+	w, err := New(WALOptions{FilePath: path, BufferSlots: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, perWriter = 16, 200
+	lsns := make([][]LSN, writers)
+	var wg sync.WaitGroup
+	for i := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range perWriter {
+				data := []byte(fmt.Sprintf("%d/%d", i, j))
+				lsn, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: TxnID(i + 1), Data: data})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				lsns[i] = append(lsns[i], lsn)
+				if j%50 == 0 {
+					if err := w.Flush(); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// LSNs are unique and increase within each writer
+	owner := make(map[LSN]string)
+	for i, got := range lsns {
+		for j, lsn := range got {
+			if j > 0 && lsn <= got[j-1] {
+				t.Fatalf("writer %d: LSN %d after %d", i, lsn, got[j-1])
+			}
+			if _, dup := owner[lsn]; dup {
+				t.Fatalf("LSN %d assigned twice", lsn)
+			}
+			owner[lsn] = fmt.Sprintf("%d/%d", i, j)
+		}
+	}
+
+	// The log holds every record in LSN order, each under the LSN its
+	// Append returned
+	var prev LSN
+	err = scanLog(path, nil, func(record *LogRecord, _ int64) error {
+		if record.LSN != prev+1 {
+			return fmt.Errorf("LSN %d after %d", record.LSN, prev)
+		}
+		prev = record.LSN
+		if want := owner[record.LSN]; string(record.Data) != want {
+			return fmt.Errorf("LSN %d holds %q, want %q", record.LSN, record.Data, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if prev != writers*perWriter {
+		t.Errorf("log ends at LSN %d, want %d", prev, writers*perWriter)
+	}
+}
+
+// encodeUnversioned encodes r as logs did before records carried a format
+// version: the data follows the header directly
+func encodeUnversioned(r *LogRecord) []byte {
+	buf := make([]byte, recordHeaderSize+len(r.Data))
+	binary.LittleEndian.PutUint32(buf[0:4], recordMagic)
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(r.LSN))
+	buf[12] = byte(r.Type)
+	binary.LittleEndian.PutUint64(buf[13:21], uint64(r.TxnID))
+	binary.LittleEndian.PutUint32(buf[21:25], uint32(len(r.Data)))
+	copy(buf[recordHeaderSize:], r.Data)
+	binary.LittleEndian.PutUint32(buf[25:29], computeChecksum(buf))
+	return buf
 }
 
 func TestEncodeDecodeRecord(t *testing.T) {
-	// TODO: Implement encode/decode test
-	// 1. Create record
-	// 2. Encode to bytes
-	// 3. Decode from bytes
-	// 4. Verify equality
-	t.Skip("not implemented")
+	record := &LogRecord{LSN: 7, Type: RecordUpdate, TxnID: 3, Data: []byte("payload")}
+	same := func(got *LogRecord) bool {
+		return got.LSN == record.LSN && got.Type == record.Type && got.TxnID == record.TxnID && bytes.Equal(got.Data, record.Data)
+	}
+
+	encoded := record.Encode()
+	if len(encoded) != record.encodedSize() || encoded[recordHeaderSize] != recordVersion {
+		t.Fatalf("encoded %d bytes, version %d", len(encoded), encoded[recordHeaderSize])
+	}
+	got, err := DecodeLogRecord(encoded)
+	if err != nil || !same(got) || got.Checksum != record.Checksum {
+		t.Fatalf("DecodeLogRecord = %+v, %v", got, err)
+	}
+
+	// Records written before versioning still decode
+	if got, err := DecodeLogRecord(encodeUnversioned(record)); err != nil || !same(got) {
+		t.Errorf("unversioned record: %+v, %v", got, err)
+	}
+
+	// Unknown optional fields are skipped
+	fields := []recordField{{tag: 0x01, value: []byte("flags")}, {tag: 0x7f}}
+	if got, err := DecodeLogRecord(encodeVersioned(record, recordVersion, fields)); err != nil || !same(got) {
+		t.Errorf("optional fields: %+v, %v", got, err)
+	}
+
+	// The record ID travels in an optional field
+	withID := *record
+	withID.ID = RecordID{1, 2, 3}
+	encoded = withID.Encode()
+	if len(encoded) != withID.encodedSize() {
+		t.Errorf("encoded %d bytes, encodedSize %d", len(encoded), withID.encodedSize())
+	}
+	if got, err := DecodeLogRecord(encoded); err != nil || !same(got) || got.ID != withID.ID {
+		t.Errorf("record ID: %+v, %v", got, err)
+	}
+
+	// A required field or a later version is refused, not misread
+	for name, data := range map[string][]byte{
+		"required field": encodeVersioned(record, recordVersion, []recordField{{tag: fieldRequired | 0x01}}),
+		"later version":  encodeVersioned(record, recordVersion+1, nil),
+	} {
+		if _, err := DecodeLogRecord(data); !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("%s: err = %v, want ErrUnsupportedFormat", name, err)
+		}
+	}
+
+	// A log mixing both formats reads in full; a newer record at its tail
+	// keeps it from opening rather than being cut off as torn
+	path := filepath.Join(t.TempDir(), "test.wal")
+	var data []byte
+	data = append(data, encodeUnversioned(&LogRecord{LSN: 1, Type: RecordBegin, TxnID: 1})...)
+	data = append(data, (&LogRecord{LSN: 2, Type: RecordCommit, TxnID: 1}).Encode()...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := Verify(path, VerifyOptions{}); err != nil || !report.OK() || report.Records != 2 {
+		t.Errorf("mixed log: %+v, %v", report, err)
+	}
+	data = append(data, encodeVersioned(&LogRecord{LSN: 3, Type: RecordBegin, TxnID: 2}, recordVersion+1, nil)...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(WALOptions{FilePath: path}); !errors.Is(err, ErrUnsupportedFormat) {
+		t.Errorf("New error = %v, want ErrUnsupportedFormat", err)
+	}
+	report, err := Verify(path, VerifyOptions{Repair: true})
+	if err != nil || !errors.Is(report.Err, ErrUnsupportedFormat) || report.Repaired {
+		t.Errorf("Verify = %+v, %v", report, err)
+	}
+	if info, _ := os.Stat(path); info.Size() != int64(len(data)) {
+		t.Errorf("log truncated to %d bytes", info.Size())
+	}
+}
+
+func FuzzDecodeLogRecord(f *testing.F) {
+	record := &LogRecord{LSN: 9, Type: RecordUpdate, TxnID: 4, Data: (&Update{PageID: 1, Before: []byte("a"), After: []byte("b")}).Encode()}
+	f.Add(record.Encode())
+	f.Add(encodeUnversioned(record))
+	f.Add(encodeVersioned(record, recordVersion, []recordField{{tag: 0x01, value: []byte{1}}}))
+	f.Add(encodeFrame(record, record.Data, len(record.Data), 0))
+	f.Add(encodeVersioned(record, recordVersion+1, nil))
+	f.Add((&LogRecord{LSN: 1, Type: RecordCommit, TxnID: 1}).Encode())
+	f.Add((&LogRecord{LSN: 2, Type: RecordCommit, TxnID: 1, ID: RecordID{0xff}}).Encode())
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		got, err := DecodeLogRecord(data)
+		if err != nil {
+			return
+		}
+		again, err := DecodeLogRecord(got.Encode())
+		if err != nil {
+			t.Fatalf("re-encoded record does not decode: %v", err)
+		}
+		if again.LSN != got.LSN || again.Type != got.Type || again.TxnID != got.TxnID || !bytes.Equal(again.Data, got.Data) || again.ID != got.ID {
+			t.Fatalf("round trip changed %+v to %+v", got, again)
+		}
+	})
+}
+
+// writeTestLog creates a log at a temp path containing the given records
+func writeTestLog(t *testing.T, records ...*LogRecord) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.wal")
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if _, err := w.Append(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestDumpLog(t *testing.T) {
+	path := writeTestLog(t,
+		&LogRecord{Type: RecordBegin, TxnID: 1},
+		&LogRecord{Type: RecordUpdate, TxnID: 1, Data: []byte("x=1")},
+		&LogRecord{Type: RecordCommit, TxnID: 1},
+	)
+
+	var text strings.Builder
+	if err := DumpLog(path, &text, DumpText); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(text.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "type=UPDATE") {
+		t.Errorf("unexpected text dump:\n%s", text.String())
+	}
+
+	var js strings.Builder
+	if err := DumpLog(path, &js, DumpJSON); err != nil {
+		t.Fatal(err)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(js.String()))
+	var got []dumpRecord
+	for scanner.Scan() {
+		var rec dumpRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			t.Fatalf("invalid JSON line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, rec)
+	}
+	if len(got) != 3 || got[1].LSN != 2 || string(got[1].Data) != "x=1" || got[2].Type != "COMMIT" {
+		t.Errorf("unexpected JSON dump: %+v", got)
+	}
+}
+
+func TestVerifyLog(t *testing.T) {
+	path := writeTestLog(t,
+		&LogRecord{Type: RecordBegin, TxnID: 1},
+		&LogRecord{Type: RecordUpdate, TxnID: 1, Data: []byte("a")},
+		&LogRecord{Type: RecordCommit, TxnID: 1},
+	)
+	if err := VerifyLog(path); err != nil {
+		t.Fatalf("expected clean log, got %v", err)
+	}
+}
+
+func TestVerifyLogUnpairedTxns(t *testing.T) {
+	path := writeTestLog(t,
+		&LogRecord{Type: RecordCommit, TxnID: 7},
+		&LogRecord{Type: RecordBegin, TxnID: 8},
+	)
+
+	err := VerifyLog(path)
+	var verr *VerifyError
+	if !errors.As(err, &verr) || len(verr.Issues) != 2 {
+		t.Fatalf("expected 2 issues, got %v", err)
+	}
+	if !errors.Is(err, ErrTxnNotBegun) || !errors.Is(err, ErrTxnNotEnded) {
+		t.Errorf("expected not-begun and not-ended issues, got %v", err)
+	}
+}
+
+func TestVerifyLogCorruption(t *testing.T) {
+	path := writeTestLog(t,
+		&LogRecord{Type: RecordBegin, TxnID: 1},
+		&LogRecord{Type: RecordUpdate, TxnID: 1, Data: []byte("payload")},
+		&LogRecord{Type: RecordCommit, TxnID: 1},
+	)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Flip a payload byte of the second record
+	const first = recordHeaderSize + versionHeaderSize
+	data[first+recordHeaderSize+versionHeaderSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = VerifyLog(path)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+	var verr *VerifyError
+	errors.As(err, &verr)
+	if verr.Issues[0].Offset != first {
+		t.Errorf("expected issue at offset %d, got %d", first, verr.Issues[0].Offset)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	const size = recordHeaderSize + versionHeaderSize + 50
+	records := func() []*LogRecord {
+		var rs []*LogRecord
+		for range 10 {
+			rs = append(rs, &LogRecord{Type: RecordUpdate, TxnID: 1, Data: make([]byte, 50)})
+		}
+		return rs
+	}
+	corrupt := func(t *testing.T, path string, offset int64) {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data[offset] ^= 0xff
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		report, err := Verify(writeTestLog(t, records()...), VerifyOptions{})
+		if err != nil || !report.OK() || report.Records != 10 || report.FirstLSN != 1 || report.LastLSN != 10 {
+			t.Errorf("Verify = %+v, %v", report, err)
+		}
+	})
+
+	t.Run("repair file", func(t *testing.T) {
+		path := writeTestLog(t, records()...)
+		corrupt(t, path, 4*size+recordHeaderSize)
+		reportPath := filepath.Join(t.TempDir(), "report.json")
+		report, err := Verify(path, VerifyOptions{Repair: true, ReportPath: reportPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(report.Err, ErrChecksumMismatch) || report.LastLSN != 4 || report.BadOffset != 4*size {
+			t.Fatalf("report %+v", report)
+		}
+		if report.TornTail || report.LostBytes != 6*size || !report.Repaired {
+			t.Errorf("report %+v", report)
+		}
+
+		var saved Report
+		data, err := os.ReadFile(reportPath)
+		if err == nil {
+			err = json.Unmarshal(data, &saved)
+		}
+		if err != nil || saved.Error == "" || !saved.Repaired || saved.LastLSN != 4 {
+			t.Errorf("saved report %s (%v)", data, err)
+		}
+
+		if report, _ := Verify(path, VerifyOptions{}); !report.OK() || report.LastLSN != 4 {
+			t.Errorf("after repair: %+v", report)
+		}
+		w, err := New(WALOptions{FilePath: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		if lsn, _ := w.Append(&LogRecord{Type: RecordBegin, TxnID: 2}); lsn != 5 {
+			t.Errorf("append after repair got LSN %d, want 5", lsn)
+		}
+	})
+
+	t.Run("repair segments", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "wal")
+		w, err := New(WALOptions{Dir: dir, SegmentSize: 200})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range records() {
+			w.Append(r)
+		}
+		w.Close()
+
+		// Segments hold LSNs 1-2, 3-4, ...; break the first record of the
+		// third one
+		segments, _ := ListSegments(dir)
+		corrupt(t, segments[2].Path, 0)
+		report, err := Verify(dir, VerifyOptions{Repair: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !errors.Is(report.Err, ErrBadMagic) || report.LastLSN != 4 || report.BadFile != segments[2].Path {
+			t.Fatalf("report %+v", report)
+		}
+		if len(report.RemovedSegments) != 3 {
+			t.Errorf("removed %v, want the last 3 segments", report.RemovedSegments)
+		}
+
+		w, err = New(WALOptions{Dir: dir, SegmentSize: 200})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lsn, _ := w.Append(&LogRecord{Type: RecordBegin, TxnID: 2})
+		w.Close()
+		if report, _ := Verify(dir, VerifyOptions{}); lsn != 5 || !report.OK() || report.LastLSN != 5 {
+			t.Errorf("after repair: appended LSN %d, %+v", lsn, report)
+		}
+	})
+
+	t.Run("torn tail", func(t *testing.T) {
+		path := writeTestLog(t, records()...)
+		os.Truncate(path, 10*size-7)
+		report, err := Verify(path, VerifyOptions{})
+		if err != nil || !errors.Is(report.Err, ErrTruncatedRecord) || !report.TornTail || report.LastLSN != 9 {
+			t.Errorf("report %+v, %v", report, err)
+		}
+		if report.Repaired {
+			t.Error("repaired without Repair")
+		}
+	})
+
+	t.Run("LSN order", func(t *testing.T) {
+		path := writeTestLog(t, records()[:3]...)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write((&LogRecord{LSN: 2, Type: RecordCommit, TxnID: 1}).Encode())
+		f.Close()
+		report, err := Verify(path, VerifyOptions{})
+		if err != nil || !errors.Is(report.Err, ErrLSNNotMonotonic) || report.LastLSN != 3 || report.BadOffset != 3*size {
+			t.Errorf("report %+v, %v", report, err)
+		}
+	})
+}
+
+func TestSegmentRotation(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	// Each record is 82 bytes, so two fit in a 200-byte segment
+	opts := WALOptions{Dir: dir, SegmentSize: 200}
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, 50)
+	for i := 0; i < 10; i++ {
+		if _, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: payload}); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 0 {
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := w.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 5 {
+		t.Fatalf("expected 5 segments, got %+v", segments)
+	}
+	for i, seg := range segments {
+		if want := LSN(2*i + 1); seg.StartLSN != want || filepath.Base(seg.Path) != segmentName(want) {
+			t.Errorf("segment %d: start %d path %s, want start %d", i, seg.StartLSN, seg.Path, want)
+		}
+		if seg.Size != 164 {
+			t.Errorf("segment %d: size %d, want 164", i, seg.Size)
+		}
+	}
+	if err := VerifyLog(dir); !errors.Is(err, ErrTxnNotBegun) {
+		t.Errorf("VerifyLog(dir) should scan all segments, got %v", err)
+	}
+
+	// Drop segments entirely below LSN 6; the segment holding 5 and 6 stays
+	if err := w.Truncate(6); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := w.GetCurrentLSN(); got != 10 {
+		t.Errorf("reopened LSN = %d, want 10", got)
+	}
+	var remaining int
+	err = scanLog(dir, nil, func(*LogRecord, int64) error {
+		remaining++
+		return nil
+	})
+	if err != nil || remaining != 6 {
+		t.Errorf("%d records after truncate (err %v), want 6", remaining, err)
+	}
+
+	lsn, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	segments, err = w.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 4 || segments[0].StartLSN != 5 || segments[3].StartLSN != lsn {
+		t.Errorf("unexpected segments after truncate and append: %+v", segments)
+	}
+}
+
+func TestPreallocate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	opts := WALOptions{Dir: dir, SegmentSize: 200, Preallocate: true}
+	if _, err := New(WALOptions{FilePath: filepath.Join(dir, "single.wal"), Preallocate: true}); err == nil {
+		t.Fatal("Preallocate without Dir should be rejected")
+	}
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, 50)
+	for i := 0; i < 10; i++ {
+		if _, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: payload}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		// Let the spare be allocated so some rotations rename it in
+		time.Sleep(time.Millisecond)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The spare never shows up as a segment, and preallocated segments
+	// report only what was written to them
+	segments, err := ListSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 5 {
+		t.Fatalf("expected 5 segments, got %+v", segments)
+	}
+	for i, seg := range segments {
+		if seg.Size != 164 {
+			t.Errorf("segment %d: size %d, want 164", i, seg.Size)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, spareName)); err != nil {
+		t.Errorf("no spare segment prepared: %v", err)
+	}
+
+	w, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if got := w.GetCurrentLSN(); got != 10 {
+		t.Errorf("reopened LSN = %d, want 10", got)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	payload := []byte(strings.Repeat("kuzu page image ", 32))
+	for _, tc := range []struct {
+		name string
+		opts func(dir string) WALOptions
+	}{
+		{"records", func(dir string) WALOptions {
+			return WALOptions{FilePath: filepath.Join(dir, "test.wal"), Compression: CompressionFlate}
+		}},
+		{"batches", func(dir string) WALOptions {
+			return WALOptions{FilePath: filepath.Join(dir, "test.wal"), Compression: CompressionFlate, CompressionMode: CompressBatches}
+		}},
+		{"segments", func(dir string) WALOptions {
+			return WALOptions{Dir: dir, SegmentSize: 1024, Compression: CompressionFlate, CompressionMode: CompressBatches}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := tc.opts(t.TempDir())
+			w, err := New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range 20 {
+				// Small records are stored plain next to compressed ones
+				w.Append(&LogRecord{Type: RecordBegin, TxnID: TxnID(i)})
+				w.Append(&LogRecord{Type: RecordUpdate, TxnID: TxnID(i), Data: payload})
+				if i%7 == 0 {
+					w.Flush()
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			var size int64
+			err = scanLog(cmp.Or(opts.Dir, opts.FilePath), nil, func(record *LogRecord, offset int64) error {
+				if record.Type == RecordUpdate && !bytes.Equal(record.Data, payload) {
+					t.Errorf("record %d: payload changed", record.LSN)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if opts.Dir == "" {
+				info, err := os.Stat(opts.FilePath)
+				if err != nil {
+					t.Fatal(err)
+				}
+				size = info.Size()
+			} else {
+				segments, err := ListSegments(opts.Dir)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(segments) < 2 {
+					t.Errorf("expected rotation, got %+v", segments)
+				}
+				for _, seg := range segments {
+					size += seg.Size
+				}
+			}
+			if plain := int64(20 * (2*recordHeaderSize + len(payload))); size >= plain/4 {
+				t.Errorf("log is %d bytes, uncompressed %d", size, plain)
+			}
+
+			w, err = New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			if got := w.GetCurrentLSN(); got != 40 {
+				t.Fatalf("reopened LSN = %d, want 40", got)
+			}
+			// Stream starts inside a batch
+			lsns, err := collectLSNs(w.Stream(5))
+			if err != nil || !slices.Equal(lsns, lsnRange(5, 40)) {
+				t.Errorf("Stream(5) = %v, %v; want 5..40", lsns, err)
+			}
+			if opts.Dir == "" {
+				if err := w.Truncate(12); err != nil {
+					t.Fatal(err)
+				}
+				lsns, err = collectLSNs(w.Stream(0))
+				if err != nil || !slices.Equal(lsns, lsnRange(12, 40)) {
+					t.Errorf("Stream(0) after Truncate(12) = %v, %v; want 12..40", lsns, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressionReadsPlainLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		appendUpdate(t, w, 1, 1, strings.Repeat("a", 100), strings.Repeat("b", 100))
+	}
+	w.Close()
+
+	w, err = New(WALOptions{FilePath: path, Compression: CompressionFlate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendUpdate(t, w, 1, 1, strings.Repeat("b", 100), strings.Repeat("c", 100))
+	w.Close()
+
+	records := logRecords(t, path)
+	if len(records) != 4 {
+		t.Fatalf("read %d records, want 4", len(records))
+	}
+	last, err := DecodeUpdate(records[3].Data)
+	if err != nil || string(last.After) != strings.Repeat("c", 100) {
+		t.Errorf("last update = %+v, %v", last, err)
+	}
+	if err := VerifyLog(path); !errors.Is(err, ErrTxnNotBegun) {
+		t.Errorf("VerifyLog = %v", err)
+	}
+}
+
+// countingCodec is flate that counts the frames it compresses
+type countingCodec struct {
+	flateCodec
+	frames *atomic.Int64
+}
+
+func (c countingCodec) Compress(src []byte) ([]byte, error) {
+	c.frames.Add(1)
+	return c.flateCodec.Compress(src)
+}
+
+func TestCompressionCodecs(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New(WALOptions{FilePath: filepath.Join(dir, "zstd.wal"), Compression: CompressionZstd}); !errors.Is(err, ErrUnknownCodec) {
+		t.Fatalf("New with unregistered zstd = %v, want ErrUnknownCodec", err)
+	}
+
+	const custom = Compression(42)
+	var frames atomic.Int64
+	RegisterCodec(custom, countingCodec{frames: &frames})
+	path := filepath.Join(dir, "custom.wal")
+	w, err := New(WALOptions{FilePath: path, Compression: custom, CompressionMode: CompressBatches})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 10 {
+		appendUpdate(t, w, 1, 1, strings.Repeat("x", 200), strings.Repeat("y", 200))
+	}
+	w.Close()
+	if frames.Load() != 1 {
+		t.Errorf("compressed %d frames, want one batch", frames.Load())
+	}
+	if records := logRecords(t, path); len(records) != 10 {
+		t.Errorf("read %d records, want 10", len(records))
+	}
+
+	// Flip a byte inside the compressed payload
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[frameHeaderSize+5] ^= 0xff
+	data = append(data, (&LogRecord{LSN: 11, Type: RecordCommit, TxnID: 1}).Encode()...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := scanLog(path, nil, func(*LogRecord, int64) error { return nil }); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("scan of corrupt frame = %v, want ErrChecksumMismatch", err)
+	}
+	if _, err := New(WALOptions{FilePath: path, Compression: custom}); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("corrupt frame followed by a record must not be repaired as torn, got %v", err)
+	}
+}
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestEncryption(t *testing.T) {
+	secret := strings.Repeat("secret page ", 20)
+	path := filepath.Join(t.TempDir(), "test.wal")
+	ring, err := NewKeyRing(1, testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := WALOptions{FilePath: path, EncryptionKeyProvider: ring}
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+	appendUpdate(t, w, 1, 7, "", secret)
+	w.Close()
+
+	// Rotate: new records use key 2, old ones keep key 1
+	if err := ring.Rotate(2, testKey(2)); err != nil {
+		t.Fatal(err)
+	}
+	w, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendUpdate(t, w, 1, 8, "", secret)
+	w.Append(&LogRecord{Type: RecordCommit, TxnID: 1})
+	w.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret page")) {
+		t.Fatal("plaintext payload found in the log file")
+	}
+	var records []*LogRecord
+	if err := scanLog(path, ring, func(record *LogRecord, _ int64) error {
+		records = append(records, record)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 4 {
+		t.Fatalf("read %d records, want 4", len(records))
+	}
+	for _, record := range records[1:3] {
+		u, err := DecodeUpdate(record.Data)
+		if err != nil || string(u.After) != secret {
+			t.Errorf("record %d: %+v, %v", record.LSN, u, err)
+		}
+	}
+
+	w, err = New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := NewTestRecoveryHandler()
+	if _, err := w.Recover(handler); err != nil || len(handler.commits) != 1 {
+		t.Errorf("recover: %v, commits %v", err, handler.commits)
+	}
+	w.Close()
+
+	onlyNew, _ := NewKeyRing(2, testKey(2))
+	if _, err := New(WALOptions{FilePath: path, EncryptionKeyProvider: onlyNew}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("open without the retired key: %v, want ErrUnknownKey", err)
+	}
+	wrong, _ := NewKeyRing(1, testKey(9))
+	wrong.Rotate(2, testKey(2))
+	if _, err := New(WALOptions{FilePath: path, EncryptionKeyProvider: wrong}); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("open with a wrong key: %v, want ErrDecryptionFailed", err)
+	}
+	if err := VerifyLog(path); !errors.Is(err, ErrNoKeyProvider) {
+		t.Errorf("VerifyLog without keys: %v, want ErrNoKeyProvider", err)
+	}
+	if _, err := NewKeyRing(3, []byte("short")); err == nil {
+		t.Error("NewKeyRing accepted a 5-byte key")
+	}
+}
+
+func TestEncryptionAuthenticatesHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	ring, _ := NewKeyRing(1, testKey(1))
+	w, err := New(WALOptions{FilePath: path, EncryptionKeyProvider: ring})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append(&LogRecord{Type: RecordUpdate, TxnID: 5, Data: []byte("payload")})
+	w.Close()
+
+	// Move the record to another transaction and fix up the checksum: the
+	// checksum passes but the header no longer matches the ciphertext
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint64(data[13:21], 6)
+	binary.LittleEndian.PutUint32(data[25:29], 0)
+	binary.LittleEndian.PutUint32(data[25:29], computeChecksum(data))
+	if _, err := decodeFrame(data, ring); !errors.Is(err, ErrDecryptionFailed) {
+		t.Errorf("decode of altered header = %v, want ErrDecryptionFailed", err)
+	}
+}
+
+func TestEncryptionWithCompression(t *testing.T) {
+	payload := []byte(strings.Repeat("kuzu page image ", 32))
+	ring, _ := NewKeyRing(1, testKey(1))
+	for _, mode := range []CompressionMode{CompressRecords, CompressBatches} {
+		dir := t.TempDir()
+		opts := WALOptions{Dir: dir, SegmentSize: 4096, Compression: CompressionFlate, CompressionMode: mode, EncryptionKeyProvider: ring}
+		w, err := New(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range 30 {
+			w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: payload})
+			if i%10 == 9 {
+				w.Flush()
+			}
+		}
+		lsns, err := collectLSNs(w.Stream(0))
+		if err != nil || !slices.Equal(lsns, lsnRange(1, 30)) {
+			t.Errorf("mode %d: Stream = %v, %v", mode, lsns, err)
+		}
+		w.Close()
+
+		var size int64
+		segments, _ := ListSegments(dir)
+		for _, seg := range segments {
+			size += seg.Size
+		}
+		// Compression still applies, since it runs before encryption
+		if plain := int64(30 * (recordHeaderSize + len(payload))); size >= plain/2 {
+			t.Errorf("mode %d: log is %d bytes, uncompressed %d", mode, size, plain)
+		}
+	}
+}
+
+// collectLSNs drains seq and returns the LSNs it yielded and its error
+func collectLSNs(seq iter.Seq2[*LogRecord, error]) ([]LSN, error) {
+	var lsns []LSN
+	for record, err := range seq {
+		if err != nil {
+			return lsns, err
+		}
+		lsns = append(lsns, record.LSN)
+	}
+	return lsns, nil
+}
+
+func lsnRange(from, to LSN) []LSN {
+	var lsns []LSN
+	for lsn := from; lsn <= to; lsn++ {
+		lsns = append(lsns, lsn)
+	}
+	return lsns
+}
+
+// recordingArchiver archives into a directory and can be made to fail
+type recordingArchiver struct {
+	DirArchiver
+	fail     atomic.Bool
+	archived sync.Map // segment start LSN -> number of Archive calls
+}
+
+func (a *recordingArchiver) Archive(segment SegmentInfo) error {
+	if a.fail.Load() {
+		return errors.New("archive unavailable")
+	}
+	n, _ := a.archived.LoadOrStore(segment.StartLSN, new(atomic.Int32))
+	n.(*atomic.Int32).Add(1)
+	return a.DirArchiver.Archive(segment)
+}
+
+// waitArchived waits until every record below lsn is archived
+func waitArchived(t *testing.T, w *WAL, lsn LSN) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		archived, err := w.ArchivedLSN()
+		if archived >= lsn {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("archived through %d (last error %v), want %d", archived, err, lsn)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestArchiver(t *testing.T) {
+	if _, err := New(WALOptions{FilePath: filepath.Join(t.TempDir(), "x.wal"), Archiver: DirArchiver{}}); err == nil {
+		t.Error("New accepted an Archiver for a single-file log")
+	}
+
+	dir := filepath.Join(t.TempDir(), "wal")
+	archive := &recordingArchiver{DirArchiver: DirArchiver{Dir: filepath.Join(t.TempDir(), "archive")}}
+	opts := WALOptions{Dir: dir, SegmentSize: 200, Archiver: archive}
+	w, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two 79-byte records per segment
+	appendRecords := func(n int) {
+		for range n {
+			w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: make([]byte, 50)})
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	appendRecords(6)
+	segments, _ := w.Segments()
+	if len(segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(segments))
+	}
+	// The active segment is not archived
+	waitArchived(t, w, segments[2].StartLSN)
+	for _, segment := range segments[:2] {
+		archived, err := os.ReadFile(filepath.Join(archive.Dir, filepath.Base(segment.Path)))
+		original, _ := os.ReadFile(segment.Path)
+		if err != nil || !bytes.Equal(archived, original) {
+			t.Errorf("archived copy of segment %d differs: %v", segment.StartLSN, err)
+		}
+	}
+	if _, ok := archive.archived.Load(segments[2].StartLSN); ok {
+		t.Error("active segment was archived")
+	}
+
+	// While archiving fails, Truncate keeps the unarchived segments
+	archive.fail.Store(true)
+	appendRecords(4)
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := w.ArchivedLSN(); err == nil; _, err = w.ArchivedLSN() {
+		if time.Now().After(deadline) {
+			t.Fatal("archive failure not reported")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := w.Truncate(w.GetCurrentLSN()); err != nil {
+		t.Fatal(err)
+	}
+	segments, _ = w.Segments()
+	if len(segments) != 3 || segments[0].StartLSN != 5 {
+		t.Fatalf("after truncate: %+v, want the unarchived segments from LSN 5 kept", segments)
+	}
+
+	// The failed segments are retried
+	archive.fail.Store(false)
+	waitArchived(t, w, segments[2].StartLSN)
+	if err := w.Truncate(w.GetCurrentLSN()); err != nil {
+		t.Fatal(err)
+	}
+	if segments, _ = w.Segments(); len(segments) != 1 {
+		t.Errorf("after truncate: %+v, want only the active segment", segments)
+	}
+	markers, _ := filepath.Glob(filepath.Join(dir, "*"+archivedExt))
+	if len(markers) != 0 {
+		t.Errorf("markers of removed segments left behind: %v", markers)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive.archived.Range(func(start, n any) bool {
+		if calls := n.(*atomic.Int32).Load(); calls != 1 {
+			t.Errorf("segment %d archived %d times", start, calls)
+		}
+		return true
+	})
+}
+
+// writePITRLog commits txn 1, then txn 2 after a pause, and returns the
+// LSN of txn 2's last update and the time between the commits
+func writePITRLog(t *testing.T, w *WAL) (LSN, time.Time) {
+	t.Helper()
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+	appendUpdate(t, w, 1, 1, "", "t1")
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 2})
+	appendUpdate(t, w, 2, 2, "", "t2")
+	if err := w.Commit(1); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	between := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	last := appendUpdate(t, w, 2, 1, "t1", "t2")
+	if err := w.Commit(2); err != nil {
+		t.Fatal(err)
+	}
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 3})
+	appendUpdate(t, w, 3, 3, "", "t3")
+	if err := w.Commit(3); err != nil {
+		t.Fatal(err)
+	}
+	return last, between
+}
+
+func TestRecoverTo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pitr.wal")
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, _ := writePITRLog(t, w)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	store := newPageStore()
+	result, err := w.RecoverTo(store, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Txn 2 had not committed by the target, and txn 3 is gone
+	if result.StopLSN != target || !slices.Equal(result.Losers, []TxnID{2}) {
+		t.Errorf("stopped at %d with losers %v, want %d and [2]", result.StopLSN, result.Losers, target)
+	}
+	if store.pages[1] != "t1" || store.pages[2] != "" || store.pages[3] != "" {
+		t.Errorf("pages = %q", store.pages)
+	}
+
+	// The history continues from the target
+	records := logRecords(t, path)
+	var tail []string
+	for _, record := range records[target:] {
+		tail = append(tail, record.Type.String())
+	}
+	if records[target].LSN != target+1 || strings.Join(tail, " ") != "CLR CLR ABORT" {
+		t.Errorf("records after target %d: %v from LSN %d", target, tail, records[target].LSN)
+	}
+	if err := VerifyLog(path); err != nil {
+		t.Error(err)
+	}
+	// A target before the oldest record left cannot be reconstructed
+	if err := w.Truncate(5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.RecoverTo(store, 2); !errors.Is(err, ErrLSNTruncated) {
+		t.Errorf("RecoverTo(2) after Truncate(5) = %v, want ErrLSNTruncated", err)
+	}
+}
+
+func TestRecoverToTimeFromArchive(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "wal")
+	archive := DirArchiver{Dir: filepath.Join(t.TempDir(), "archive")}
+	w, err := New(WALOptions{Dir: dir, SegmentSize: 128, Archiver: archive})
+	if err != nil {
+		t.Fatal(err)
+	}
+	last, between := writePITRLog(t, w)
+	// Rotate once more so every record is in a complete segment
+	w.Append(&LogRecord{Type: RecordCheckpoint, Data: make([]byte, 128)})
+	w.Flush()
+	waitArchived(t, w, w.GetCurrentLSN())
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Restore the archive somewhere else and recover to between the commits
+	restored := filepath.Join(t.TempDir(), "restored")
+	if err := archive.Restore(restored); err != nil {
+		t.Fatal(err)
+	}
+	w, err = New(WALOptions{Dir: restored})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	store := newPageStore()
+	result, err := w.RecoverToTime(store, between)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.StopLSN != last || !slices.Equal(result.Losers, []TxnID{2}) {
+		t.Errorf("stopped at %d with losers %v, want %d and [2]", result.StopLSN, result.Losers, last)
+	}
+	if store.pages[1] != "t1" || store.pages[2] != "" || len(store.commits) != 1 {
+		t.Errorf("pages %q commits %v, want only txn 1", store.pages, store.commits)
+	}
+
+	var commitTimes []time.Time
+	for record := range w.Stream(0) {
+		if at, ok := CommitTime(record); ok {
+			commitTimes = append(commitTimes, at)
+		}
+	}
+	if len(commitTimes) != 1 || !commitTimes[0].Before(between) {
+		t.Errorf("commit times after recovery %v, want one before %v", commitTimes, between)
+	}
+}
+
+func TestStream(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts func(dir string) WALOptions
+	}{
+		{"file", func(dir string) WALOptions { return WALOptions{FilePath: filepath.Join(dir, "test.wal")} }},
+		{"segments", func(dir string) WALOptions { return WALOptions{Dir: dir, SegmentSize: 200} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := New(tc.opts(t.TempDir()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			for i := range 10 {
+				w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: make([]byte, 50)})
+				if i == 7 {
+					w.Flush()
+				}
+			}
+
+			// Only flushed records are streamed
+			lsns, err := collectLSNs(w.Stream(3))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(lsns, lsnRange(3, 8)) {
+				t.Errorf("Stream(3) = %v, want 3..8", lsns)
+			}
+
+			w.Flush()
+			var early []LSN
+			for record, err := range w.Stream(0) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				early = append(early, record.LSN)
+				if len(early) == 4 {
+					break
+				}
+			}
+			if !slices.Equal(early, lsnRange(1, 4)) {
+				t.Errorf("first four records = %v", early)
+			}
+		})
+	}
+}
+
+func TestRecords(t *testing.T) {
+	dir := t.TempDir()
+	w, err := New(WALOptions{Dir: dir, SegmentSize: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 10 {
+		w.Append(&LogRecord{Type: RecordUpdate, TxnID: TxnID(i), Data: make([]byte, 50)})
+		if i == 3 {
+			w.Flush()
+		}
+	}
+
+	// Buffered records are included, unlike with Stream
+	lsns, err := collectLSNs(w.Records(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(lsns, lsnRange(2, 10)) {
+		t.Errorf("Records(2) = %v, want 2..10", lsns)
+	}
+	// Only rotation synced the active segment
+	if flushed := w.GetFlushLSN(); flushed >= 10 {
+		t.Errorf("Records synced the log: flush LSN %d", flushed)
+	}
+
+	for record, err := range w.Records(0) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if record.TxnID != TxnID(record.LSN-1) {
+			t.Errorf("LSN %d has txn %d", record.LSN, record.TxnID)
+		}
+		if record.LSN == 5 {
+			break
+		}
+	}
+
+	w.Close()
+	lsns, err = collectLSNs(w.Records(9))
+	if err != nil || !slices.Equal(lsns, lsnRange(9, 10)) {
+		t.Errorf("Records(9) after Close = %v, %v", lsns, err)
+	}
+}
+
+func TestStreamTruncated(t *testing.T) {
+	w, err := New(WALOptions{FilePath: filepath.Join(t.TempDir(), "test.wal")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for range 6 {
+		w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+	}
+	if err := w.Truncate(4); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := collectLSNs(w.Stream(2)); !errors.Is(err, ErrLSNTruncated) {
+		t.Errorf("Stream(2) after Truncate(4) = %v, want ErrLSNTruncated", err)
+	}
+	lsns, err := collectLSNs(w.Stream(0))
+	if err != nil || !slices.Equal(lsns, lsnRange(4, 6)) {
+		t.Errorf("Stream(0) = %v, %v; want 4..6", lsns, err)
+	}
+}
+
+func TestFollow(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts func(dir string) WALOptions
+	}{
+		{"file", func(dir string) WALOptions { return WALOptions{FilePath: filepath.Join(dir, "test.wal")} }},
+		{"segments", func(dir string) WALOptions { return WALOptions{Dir: dir, SegmentSize: 200} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := New(tc.opts(t.TempDir()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+			w.Flush()
+
+			received := make(chan LSN, 100)
+			done := make(chan error, 1)
+			go func() {
+				defer close(received)
+				for record, err := range w.Follow(context.Background(), 1) {
+					if err != nil {
+						done <- err
+						return
+					}
+					received <- record.LSN
+				}
+				done <- nil
+			}()
+			expect := func(lsns ...LSN) {
+				t.Helper()
+				for _, want := range lsns {
+					select {
+					case got := <-received:
+						if got != want {
+							t.Fatalf("followed LSN %d, want %d", got, want)
+						}
+					case <-time.After(5 * time.Second):
+						t.Fatalf("timed out waiting for LSN %d", want)
+					}
+				}
+			}
+
+			expect(1)
+			for range 3 {
+				w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: make([]byte, 50)})
+			}
+			select {
+			case lsn := <-received:
+				t.Fatalf("followed unflushed LSN %d", lsn)
+			case <-time.After(20 * time.Millisecond):
+			}
+			w.Flush()
+			expect(2, 3, 4)
+
+			// Truncate replaces the file or drops segments under the reader
+			if err := w.Truncate(4); err != nil {
+				t.Fatal(err)
+			}
+			w.Append(&LogRecord{Type: RecordCommit, TxnID: 1})
+			w.Flush()
+			expect(5)
+
+			w.Close()
+			if err := <-done; !errors.Is(err, ErrLogClosed) {
+				t.Fatalf("Follow ended with %v, want ErrLogClosed", err)
+			}
+		})
+	}
+
+	t.Run("cancel", func(t *testing.T) {
+		w, err := New(WALOptions{FilePath: filepath.Join(t.TempDir(), "test.wal")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if _, err := collectLSNs(w.Follow(ctx, 0)); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Follow = %v, want context.DeadlineExceeded", err)
+		}
+	})
+}
+
+// pageStore is a recovery handler applying updates to in-memory pages
+type pageStore struct {
+	*TestRecoveryHandler
+	pages    map[PageID]string
+	pageLSNs map[PageID]LSN
+	applied  []LSN
+}
+
+func newPageStore() *pageStore {
+	return &pageStore{
+		TestRecoveryHandler: NewTestRecoveryHandler(),
+		pages:               make(map[PageID]string),
+		pageLSNs:            make(map[PageID]LSN),
+	}
+}
+
+func (s *pageStore) OnUpdate(txnID TxnID, lsn LSN, data []byte) error {
+	u, err := DecodeUpdate(data)
+	if err != nil {
+		return err
+	}
+	s.pages[u.PageID] = string(u.After)
+	s.pageLSNs[u.PageID] = lsn
+	s.applied = append(s.applied, lsn)
+	return nil
+}
+
+func (s *pageStore) PageLSN(pageID PageID) (LSN, error) {
+	return s.pageLSNs[pageID], nil
+}
+
+func appendUpdate(t *testing.T, w *WAL, txnID TxnID, page PageID, before, after string) LSN {
+	t.Helper()
+	u := &Update{PageID: page, Before: []byte(before), After: []byte(after)}
+	lsn, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: txnID, Data: u.Encode()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return lsn
+}
+
+func logRecords(t *testing.T, path string) []*LogRecord {
+	t.Helper()
+	var records []*LogRecord
+	err := scanLog(path, nil, func(record *LogRecord, _ int64) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return records
+}
+
+func TestUpdateEncoding(t *testing.T) {
+	u := &Update{PageID: 42, Before: []byte("old"), After: []byte("new!"), UndoNext: 7}
+	got, err := DecodeUpdate(u.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PageID != 42 || string(got.Before) != "old" || string(got.After) != "new!" || got.UndoNext != 7 {
+		t.Errorf("round trip = %+v", got)
+	}
+	// Without Offset and PageLSN the original 20-byte header is kept
+	if n := len(u.Encode()); n != 20+3+4 {
+		t.Errorf("plain update encodes to %d bytes, want 27", n)
+	}
+
+	u.Offset, u.PageLSN = 128, 5
+	got, err = DecodeUpdate(u.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Offset != 128 || got.PageLSN != 5 || string(got.Before) != "old" || string(got.After) != "new!" {
+		t.Errorf("round trip with offset and page LSN = %+v", got)
+	}
+
+	extOnly := []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x80, 1, 2, 3}
+	for _, data := range [][]byte{nil, make([]byte, 19), {0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0}, extOnly} {
+		if _, err := DecodeUpdate(data); !errors.Is(err, ErrInvalidRecord) {
+			t.Errorf("DecodeUpdate(%v) error = %v, want ErrInvalidRecord", data, err)
+		}
+	}
+
+	record := &LogRecord{Type: RecordUpdate, Data: u.Encode()}
+	if got, err := record.Update(); err != nil || got.PageLSN != 5 {
+		t.Errorf("LogRecord.Update() = %+v, %v", got, err)
+	}
+	if _, err := (&LogRecord{Type: RecordCommit}).Update(); !errors.Is(err, ErrInvalidRecord) {
+		t.Errorf("Update() of a COMMIT: err = %v, want ErrInvalidRecord", err)
+	}
+}
+
+// writeARIESLog leaves txn 1 committed and txn 2 a loser with two updates
+func writeARIESLog(t *testing.T) (string, LSN) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "aries.wal")
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+	appendUpdate(t, w, 1, 1, "", "t1")
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 2})
+	appendUpdate(t, w, 2, 2, "", "t2")
+	w.Append(&LogRecord{Type: RecordCommit, TxnID: 1})
+	last := appendUpdate(t, w, 2, 1, "t1", "t2")
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path, last
+}
+
+func TestARIESRecovery(t *testing.T) {
+	path, _ := writeARIESLog(t)
+
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newPageStore()
+	if _, err := w.Recover(store); err != nil {
+		t.Fatal(err)
+	}
+	if store.pages[1] != "t1" || store.pages[2] != "" {
+		t.Errorf("pages after recovery = %q, want txn 2 undone", store.pages)
+	}
+	if len(store.commits) != 1 || len(store.aborts) != 1 || store.aborts[0] != 2 {
+		t.Errorf("commits %v aborts %v, want [1] and [2]", store.commits, store.aborts)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undo logged CLRs newest first, then ended the loser
+	records := logRecords(t, path)
+	var tail []string
+	for _, record := range records[6:] {
+		tail = append(tail, record.Type.String())
+	}
+	if strings.Join(tail, " ") != "CLR CLR ABORT" {
+		t.Fatalf("records after recovery: %v", tail)
+	}
+	clr, _ := DecodeUpdate(records[6].Data)
+	if clr.PageID != 1 || string(clr.After) != "t1" || clr.UndoNext != 4 {
+		t.Errorf("first CLR = %+v, want page 1 restored to t1 with UndoNext 4", clr)
+	}
+	if err := VerifyLog(path); err != nil {
+		t.Errorf("log inconsistent after recovery: %v", err)
+	}
+
+	// A second recovery repeats history, CLRs included, and undoes nothing
+	w, err = New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	store = newPageStore()
+	if _, err := w.Recover(store); err != nil {
+		t.Fatal(err)
+	}
+	if store.pages[1] != "t1" || store.pages[2] != "" {
+		t.Errorf("pages after second recovery = %q", store.pages)
+	}
+	if n := len(logRecords(t, path)); n != len(records) {
+		t.Errorf("second recovery wrote %d records", n-len(records))
+	}
+}
+
+func TestARIESInterruptedUndo(t *testing.T) {
+	path, last := writeARIESLog(t)
+
+	// A previous recovery undid the newest update, then crashed
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clr := &Update{PageID: 1, After: []byte("t1"), UndoNext: 4}
+	w.Append(&LogRecord{Type: RecordCLR, TxnID: 2, Data: clr.Encode()})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err = New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	store := newPageStore()
+	if _, err := w.Recover(store); err != nil {
+		t.Fatal(err)
+	}
+
+	var clrs []LSN
+	for _, record := range logRecords(t, path) {
+		if record.Type == RecordCLR {
+			clrs = append(clrs, record.LSN)
+		}
+	}
+	if len(clrs) != 2 {
+		t.Errorf("CLRs %v: update %d must not be undone twice", clrs, last)
+	}
+	if store.pages[1] != "t1" || store.pages[2] != "" {
+		t.Errorf("pages = %q", store.pages)
+	}
+}
+
+func TestARIESRedoSkipsAppliedPages(t *testing.T) {
+	path, last := writeARIESLog(t)
+
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	// Page 1 was flushed after the last update; only page 2 needs redo
+	store := newPageStore()
+	store.pages[1] = "t2"
+	store.pageLSNs[1] = last
+	if _, err := w.Recover(store); err != nil {
+		t.Fatal(err)
+	}
+	// Redo applied only page 2's update; undo applied two CLRs
+	if len(store.applied) != 3 || store.applied[0] != 4 {
+		t.Errorf("applied LSNs %v, want [4 <clr> <clr>]", store.applied)
+	}
+	if store.pages[1] != "t1" || store.pages[2] != "" {
+		t.Errorf("pages = %q", store.pages)
+	}
+}
+
+func TestRedoPageLSNs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pagelsn.wal")
+	w, err := New(WALOptions{FilePath: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+	first, _ := w.AppendUpdate(1, &Update{PageID: 1, After: []byte("a")})
+	second, _ := w.AppendUpdate(1, &Update{PageID: 1, Offset: 8, PageLSN: first, Before: []byte("a"), After: []byte("b")})
+	w.Commit(1)
+	w.Close()
+
+	t.Run("replay is idempotent", func(t *testing.T) {
+		w, err := New(WALOptions{FilePath: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		store := newPageStore()
+		for range 2 {
+			if _, err := w.Recover(store); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !slices.Equal(store.applied, []LSN{first, second}) {
+			t.Errorf("applied %v over two recoveries, want [%d %d]", store.applied, first, second)
+		}
+	})
+
+	t.Run("lost page write", func(t *testing.T) {
+		w, err := New(WALOptions{FilePath: path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Close()
+		// Flush order is broken: the page on disk predates the first update
+		// but a checkpoint claims it only needs redo from the second
+		w.Checkpoint(&CheckpointData{DirtyPages: []DirtyPage{{PageID: 1, RecLSN: second}}})
+		if _, err := w.Recover(newPageStore()); !errors.Is(err, ErrPageLSNGap) {
+			t.Errorf("Recover = %v, want ErrPageLSNGap", err)
+		}
+	})
+}
+
+// failingStore fails the update at failAt, as a crash during redo would
+// interrupt it
+type failingStore struct {
+	*pageStore
+	failAt LSN
+}
+
+func (s *failingStore) OnUpdate(txnID TxnID, lsn LSN, data []byte) error {
+	if lsn == s.failAt {
+		return errors.New("crash")
+	}
+	return s.pageStore.OnUpdate(txnID, lsn, data)
+}
+
+func TestRecoverWithOptions(t *testing.T) {
+	for _, opts := range []WALOptions{
+		{SegmentSize: 1 << 10},
+		{SegmentSize: 1 << 10, Compression: CompressionFlate, CompressionMode: CompressBatches},
+	} {
+		name := "plain"
+		if opts.Compression != CompressionNone {
+			name = "batches"
+		}
+		t.Run(name, func(t *testing.T) {
+			opts.Dir = t.TempDir()
+			w, err := New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// A loser whose updates span every segment, among committed
+			// transactions. Images are random letters, so the log spreads
+			// over several segments compressed too.
+			seed := uint32(1)
+			image := func() string {
+				b := make([]byte, 200)
+				for i := range b {
+					seed = seed*1664525 + 1013904223
+					b[i] = 'a' + byte(seed>>24)%26
+				}
+				return string(b)
+			}
+			want := make(map[PageID]string)
+			w.Append(&LogRecord{Type: RecordBegin, TxnID: 1})
+			for i := range 40 {
+				txn := TxnID(i + 2)
+				w.Append(&LogRecord{Type: RecordBegin, TxnID: txn})
+				page := PageID(i % 8)
+				after := image()
+				appendUpdate(t, w, txn, page, want[page], after)
+				want[page] = after
+				w.Append(&LogRecord{Type: RecordCommit, TxnID: txn})
+				appendUpdate(t, w, 1, PageID(100+i), "", image())
+				if i%10 == 0 {
+					w.Flush()
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if segments, _ := ListSegments(opts.Dir); len(segments) < 3 {
+				t.Fatalf("%d segments, want the log spread over several", len(segments))
+			}
+
+			// Redo fails partway, leaving the last marker reported
+			var progress []RecoveryProgress
+			recoveryOpts := RecoveryOptions{
+				ReadBufferSize:   512,
+				ProgressInterval: 1 << 10,
+				Progress:         func(p RecoveryProgress) { progress = append(progress, p) },
+			}
+			w, err = New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			store := newPageStore()
+			if _, err := w.RecoverWithOptions(&failingStore{pageStore: store, failAt: 99}, recoveryOpts); err == nil {
+				t.Fatal("recovery did not stop at the failing update")
+			}
+			w.Close()
+			var marker RecoveryMarker
+			for i, p := range progress {
+				if i > 0 && p.Phase == progress[i-1].Phase && p.BytesProcessed < progress[i-1].BytesProcessed {
+					t.Errorf("progress went back: %+v after %+v", p, progress[i-1])
+				}
+				if p.Phase == PhaseAnalysis && p.Marker.LSN != 0 {
+					t.Errorf("marker moved during analysis: %+v", p)
+				}
+				marker = p.Marker
+			}
+			if last := progress[len(progress)-1]; last.Phase != PhaseRedo || marker.LSN == 0 || marker.LSN >= 99 {
+				t.Fatalf("last progress %+v, want a redo marker before lsn 99", last)
+			}
+			if p := progress[0]; p.Phase != PhaseAnalysis || p.BytesTotal == 0 {
+				t.Errorf("first progress %+v", p)
+			}
+			data, _ := marker.MarshalBinary()
+
+			// Recovery resumes after the marker, where the store got to
+			var resumed RecoveryMarker
+			if err := resumed.UnmarshalBinary(data); err != nil || resumed != marker {
+				t.Fatalf("marker round trip = %+v, %v", resumed, err)
+			}
+			calls := func() int {
+				return len(store.begins) + len(store.commits) + len(store.aborts) + len(store.checkpoints) + len(store.applied)
+			}
+			applied, before := len(store.applied), calls()
+			progress = nil
+			recoveryOpts.Resume = &resumed
+			w, err = New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := w.RecoverWithOptions(store, recoveryOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			w.Close()
+			if result.ResumedLSN != marker.LSN || result.Undone != 40 || !slices.Equal(result.Losers, []TxnID{1}) {
+				t.Errorf("result = %+v", result)
+			}
+			if redone := store.applied[applied : len(store.applied)-40]; len(redone) == 0 || redone[0] <= marker.LSN {
+				t.Errorf("resumed redo applied %v, want only records after %d", redone, marker.LSN)
+			}
+			for page, after := range want {
+				if store.pages[page] != after {
+					t.Errorf("page %d = %.8q, want %.8q", page, store.pages[page], after)
+				}
+			}
+			for i := range 40 {
+				if got := store.pages[PageID(100+i)]; got != "" {
+					t.Errorf("page %d of the loser = %.8q, want it undone", 100+i, got)
+				}
+			}
+			if last := progress[len(progress)-1]; last.Phase != PhaseUndo || last.RecordsApplied != calls()-before || last.Marker.LSN < marker.LSN {
+				t.Errorf("last progress = %+v, want undo after %d handler calls", last, calls()-before)
+			}
+
+			// A marker past the end of the log is not this log's
+			w, err = New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			if _, err := w.RecoverWithOptions(newPageStore(), RecoveryOptions{Resume: &RecoveryMarker{LSN: 1 << 40}}); !errors.Is(err, ErrBadRecoveryMarker) {
+				t.Errorf("Recover past the end = %v, want ErrBadRecoveryMarker", err)
+			}
+			data[5] ^= 1
+			if err := resumed.UnmarshalBinary(data); !errors.Is(err, ErrBadRecoveryMarker) {
+				t.Errorf("UnmarshalBinary of a damaged marker = %v", err)
+			}
+		})
+	}
 }
 
 func BenchmarkAppend(b *testing.B) {
-	// TODO: Benchmark append performance
-	// Test with sync disabled
-	b.Skip("not implemented")
+	w, err := New(WALOptions{FilePath: filepath.Join(b.TempDir(), "bench.wal")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	payload := make([]byte, 100)
+	b.ResetTimer()
+	for range b.N {
+		if _, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: payload}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAppendParallel appends from 16 goroutines, which reserve their
+// LSNs without taking a lock in turn
+func BenchmarkAppendParallel(b *testing.B) {
+	w, err := New(WALOptions{FilePath: filepath.Join(b.TempDir(), "bench.wal")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	payload := make([]byte, 100)
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: payload}); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
 }
 
+// BenchmarkAppendSync syncs every record, holding the log during each
+// fsync; compare BenchmarkFlushUpTo
 func BenchmarkAppendSync(b *testing.B) {
-	// TODO: Benchmark append with fsync
-	// Compare to no-sync version
-	b.Skip("not implemented")
+	w, err := New(WALOptions{FilePath: filepath.Join(b.TempDir(), "bench.wal")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	payload := make([]byte, 100)
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: payload}); err != nil {
+				b.Error(err)
+				return
+			}
+			if err := w.Flush(); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func BenchmarkFlushUpTo(b *testing.B) {
+	for _, prealloc := range []bool{false, true} {
+		b.Run(fmt.Sprintf("preallocate=%v", prealloc), func(b *testing.B) {
+			w, err := New(WALOptions{Dir: filepath.Join(b.TempDir(), "wal"), SegmentSize: 1 << 20, Preallocate: prealloc})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer w.Close()
+
+			payload := make([]byte, 100)
+			b.SetParallelism(16)
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					lsn, err := w.Append(&LogRecord{Type: RecordUpdate, TxnID: 1, Data: payload})
+					if err == nil {
+						err = w.FlushUpTo(lsn)
+					}
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			if stats := w.SyncStats(); stats.Syncs > 0 {
+				b.ReportMetric(float64(stats.Requests)/float64(stats.Syncs), "requests/fsync")
+			}
+		})
+	}
 }
 
 func BenchmarkGroupCommit(b *testing.B) {
-	// TODO: Benchmark group commit throughput
-	b.Skip("not implemented")
+	w, err := New(WALOptions{
+		FilePath:    filepath.Join(b.TempDir(), "bench.wal"),
+		GroupCommit: GroupCommitOptions{MaxLatency: time.Millisecond},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer w.Close()
+
+	var txn atomic.Uint64
+	b.SetParallelism(16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := w.Commit(TxnID(txn.Add(1))); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+	stats := w.GroupCommitStats()
+	if stats.Flushes > 0 {
+		b.ReportMetric(float64(stats.Commits)/float64(stats.Flushes), "commits/fsync")
+	}
 }
 
 func BenchmarkRecovery(b *testing.B) {
@@ -177,3 +2439,165 @@ func BenchmarkRecovery(b *testing.B) {
 func cleanup(t *testing.T, path string) {
 	os.RemoveAll(path)
 }
+
+func TestRecordIDs(t *testing.T) {
+	ring, err := NewKeyRing(1, testKey(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	retried := RecordID{0xaa, 0xbb}
+	for name, opts := range map[string]WALOptions{
+		"plain":      {},
+		"compressed": {Compression: CompressionFlate},
+		"batches":    {Compression: CompressionFlate, CompressionMode: CompressBatches},
+		"encrypted":  {EncryptionKeyProvider: ring},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts.FilePath = filepath.Join(t.TempDir(), "test.wal")
+			opts.RecordIDs = true
+			w, err := New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page := strings.Repeat("compressible ", 20)
+			for i := range 5 {
+				record := &LogRecord{Type: RecordUpdate, TxnID: 1, Data: []byte(page)}
+				if i == 2 {
+					record.ID = retried
+				}
+				w.Append(record)
+				if record.ID.IsZero() {
+					t.Errorf("record %d has no ID", i)
+				}
+			}
+			w.Close()
+
+			w, err = New(opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer w.Close()
+			ids := make(map[RecordID]bool)
+			for record, err := range w.Records(0) {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if record.ID.IsZero() || ids[record.ID] || string(record.Data) != page {
+					t.Errorf("LSN %d: ID %v, %d bytes", record.LSN, record.ID, len(record.Data))
+				}
+				ids[record.ID] = true
+			}
+			if len(ids) != 5 || !ids[retried] {
+				t.Errorf("read %d IDs, retried ID kept: %v", len(ids), ids[retried])
+			}
+		})
+	}
+
+	// Without the option records carry no ID, as before
+	w, err := New(WALOptions{FilePath: filepath.Join(t.TempDir(), "test.wal")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	record := &LogRecord{Type: RecordBegin, TxnID: 1}
+	w.Append(record)
+	if !record.ID.IsZero() {
+		t.Errorf("ID assigned without RecordIDs: %v", record.ID)
+	}
+
+	id, err := NewRecordID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := id.String(); len(s) != 36 || s[14] != '4' || !strings.Contains("89ab", s[19:20]) {
+		t.Errorf("NewRecordID() = %s, want a version 4 UUID", s)
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	ids := make([]RecordID, 5)
+	for i := range ids {
+		ids[i] = RecordID{byte(i + 1)}
+	}
+	window := NewDedupWindow(3)
+	var applied []RecordID
+	apply := func(record *LogRecord) error {
+		if record.TxnID == 99 {
+			return errors.New("apply failed")
+		}
+		applied = append(applied, record.ID)
+		return nil
+	}
+
+	// A replay of the first records after a reconnect applies nothing twice
+	for _, id := range append(ids[:3:3], ids[1], ids[3], ids[2]) {
+		if _, err := window.Apply(&LogRecord{ID: id}, apply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if want := []RecordID{ids[0], ids[1], ids[2], ids[3]}; !slices.Equal(applied, want) {
+		t.Errorf("applied %v, want %v", applied, want)
+	}
+	// ids[0] fell out of the window
+	if window.Seen(ids[0]) || !window.Seen(ids[1]) || window.Len() != 3 {
+		t.Errorf("window: seen ids[0] %v, ids[1] %v, len %d", window.Seen(ids[0]), window.Seen(ids[1]), window.Len())
+	}
+
+	// A failed apply is not recorded, so the retry applies it
+	if ok, err := window.Apply(&LogRecord{ID: ids[4], TxnID: 99}, apply); !ok || err == nil || window.Seen(ids[4]) {
+		t.Errorf("failed Apply = %v, %v", ok, err)
+	}
+	if ok, _ := window.Apply(&LogRecord{ID: ids[4]}, apply); !ok {
+		t.Error("retry after a failed Apply was skipped")
+	}
+	// Records without an ID are always applied
+	for range 2 {
+		if ok, _ := window.Apply(&LogRecord{}, apply); !ok {
+			t.Error("record without an ID was skipped")
+		}
+	}
+
+	// The window survives a consumer restart
+	data, err := window.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := NewDedupWindow(1)
+	if err := restored.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Size() != 3 || restored.Len() != 3 || !restored.Seen(ids[4]) || restored.Seen(ids[1]) {
+		t.Errorf("restored window: size %d, len %d", restored.Size(), restored.Len())
+	}
+	restored.Add(RecordID{9})
+	if restored.Seen(ids[2]) || !restored.Seen(ids[3]) {
+		t.Error("restored window evicts out of order")
+	}
+	if err := restored.UnmarshalBinary(data[:len(data)-1]); !errors.Is(err, ErrBadDedupState) {
+		t.Errorf("UnmarshalBinary of truncated data = %v", err)
+	}
+
+	// Exactly once end to end: a consumer that reconnects and reads the
+	// log again applies each record once
+	w, err := New(WALOptions{FilePath: filepath.Join(t.TempDir(), "test.wal"), RecordIDs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := range 10 {
+		w.Append(&LogRecord{Type: RecordUpdate, TxnID: TxnID(i)})
+	}
+	w.Flush()
+	window, applied = NewDedupWindow(100), nil
+	for _, from := range []LSN{1, 4, 1} {
+		for record, err := range w.Stream(from) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			window.Apply(record, apply)
+		}
+	}
+	if len(applied) != 10 {
+		t.Errorf("applied %d records, want 10", len(applied))
+	}
+}
@@ -0,0 +1,153 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// commitTimeSize is the size of the timestamp Commit and CommitAsync write
+// as the Data of a COMMIT record: Unix nanoseconds(8)
+const commitTimeSize = 8
+
+func encodeCommitTime(t time.Time) []byte {
+	return binary.LittleEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+// CommitTime returns when a COMMIT record was written. It reports false for
+// other records and for commits appended without a timestamp.
+func CommitTime(record *LogRecord) (time.Time, bool) {
+	if record.Type != RecordCommit || len(record.Data) != commitTimeSize {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(record.Data))), true
+}
+
+// RecoverTo recovers the state as of targetLSN, for point-in-time
+// recovery. The records after targetLSN are removed from the log, starting
+// a new history there: transactions without a COMMIT by targetLSN are
+// rolled back like any loser, and new records are numbered from
+// targetLSN+1. A target at or past the end of the log recovers all of it;
+// RecoveryResult.StopLSN reports the last record kept.
+//
+// To recover from an archive, restore its segments into an empty Dir (see
+// DirArchiver.Restore) and open the log there. Archived copies of the
+// removed records are left alone.
+func (w *WAL) RecoverTo(handler RecoveryHandler, targetLSN LSN) (RecoveryResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.recoverToLocked(handler, targetLSN)
+}
+
+// RecoverToTime is RecoverTo for the last record before the first
+// transaction that committed after t. Commit times are those of COMMIT
+// records written by Commit and CommitAsync; see CommitTime.
+func (w *WAL) RecoverToTime(handler RecoveryHandler, t time.Time) (RecoveryResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if err := w.flushInternal(); err != nil {
+		return RecoveryResult{TornBytes: w.tornBytes}, err
+	}
+	target := w.GetCurrentLSN()
+	err := w.scanRecoverable(0, func(record *LogRecord) error {
+		if at, ok := CommitTime(record); ok && at.After(t) {
+			target = record.LSN - 1
+			return errStopScan
+		}
+		return nil
+	})
+	if err != nil && err != errStopScan {
+		return RecoveryResult{TornBytes: w.tornBytes}, err
+	}
+	return w.recoverToLocked(handler, target)
+}
+
+func (w *WAL) recoverToLocked(handler RecoveryHandler, target LSN) (RecoveryResult, error) {
+	stop, err := w.cutTail(target)
+	if err != nil {
+		return RecoveryResult{TornBytes: w.tornBytes}, err
+	}
+	result, err := w.recoverLocked(handler, RecoveryOptions{})
+	result.StopLSN = stop
+	return result, err
+}
+
+// cutTail removes the records after target from the log and returns the
+// last LSN kept. Caller holds w.mu.
+func (w *WAL) cutTail(target LSN) (LSN, error) {
+	if err := w.flushInternal(); err != nil {
+		return 0, err
+	}
+	last := w.GetCurrentLSN()
+	if target >= last {
+		return last, nil
+	}
+	keep := func(record *LogRecord) bool { return record.LSN <= target }
+
+	if w.opts.Dir == "" {
+		first := last + 1
+		err := scanFile(w.opts.FilePath, 0, w.opts.EncryptionKeyProvider, func(record *LogRecord, _ int64) error {
+			first = record.LSN
+			return errStopScan
+		})
+		if err != nil && err != errStopScan {
+			return 0, err
+		}
+		if target+1 < first {
+			return 0, ErrLSNTruncated
+		}
+		if err := w.rewriteFile(w.opts.FilePath, keep); err != nil {
+			return 0, err
+		}
+		w.file.Close()
+		w.file, err = os.OpenFile(w.opts.FilePath, os.O_RDWR|os.O_APPEND, 0644)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		// The archiver must not copy a segment while it is rewritten
+		if w.archiver != nil {
+			w.archiver.passMu.Lock()
+			defer w.archiver.passMu.Unlock()
+		}
+		segments, err := ListSegments(w.opts.Dir)
+		if err != nil {
+			return 0, err
+		}
+		if len(segments) == 0 || target+1 < segments[0].StartLSN {
+			return 0, ErrLSNTruncated
+		}
+		for i := len(segments) - 1; i >= 0; i-- {
+			if segments[i].StartLSN <= target {
+				// The segment holding target is active again, so its
+				// archived copy is out of date
+				if err := w.rewriteFile(segments[i].Path, keep); err != nil {
+					return 0, err
+				}
+				if err := os.Remove(segments[i].Path + archivedExt); err != nil && !errors.Is(err, fs.ErrNotExist) {
+					return 0, err
+				}
+				break
+			}
+			if err := removeSegment(segments[i]); err != nil {
+				return 0, err
+			}
+		}
+		if err := syncDir(w.opts.Dir); err != nil {
+			return 0, err
+		}
+		w.file.Close()
+		w.file = nil
+		if err := w.openSegments(target); err != nil {
+			return 0, err
+		}
+	}
+
+	w.buffer.reset(target)
+	w.flushLSN.Store(uint64(target))
+	w.writtenLSN = target
+	return target, nil
+}
@@ -0,0 +1,59 @@
+package wal
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// spareName is the preallocated file the next segment is renamed from. It
+// is not named like a segment, so ListSegments ignores it.
+const spareName = "next.wal.spare"
+
+// prepareSpare preallocates the file for the next segment in the
+// background. Caller holds w.writeMu or w.mu exclusively, or is New.
+func (w *WAL) prepareSpare() {
+	ready := make(chan error, 1)
+	w.spareReady = ready
+	w.spareWG.Add(1)
+	go func() {
+		defer w.spareWG.Done()
+		ready <- createSpare(filepath.Join(w.opts.Dir, spareName), w.opts.SegmentSize)
+	}()
+}
+
+func createSpare(path string, size int64) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = preallocate(file, size)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// takeSpare renames the spare to path, the new segment, if it has been
+// prepared and path does not exist yet. Caller holds w.writeMu or w.mu
+// exclusively.
+func (w *WAL) takeSpare(path string) bool {
+	if w.spareReady == nil {
+		return false
+	}
+	select {
+	case err := <-w.spareReady:
+		w.spareReady = nil
+		if err != nil {
+			return false
+		}
+	default:
+		return false // still being allocated
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return false
+	}
+	return os.Rename(filepath.Join(w.opts.Dir, spareName), path) == nil
+}
@@ -0,0 +1,224 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrBadRecoveryMarker is returned for a RecoveryMarker that
+// UnmarshalBinary cannot decode, or that does not fit the log it is used
+// on
+var ErrBadRecoveryMarker = errors.New("wal: bad recovery marker")
+
+// RecoveryPhase is a pass of recovery
+type RecoveryPhase int
+
+const (
+	PhaseAnalysis RecoveryPhase = iota
+	PhaseRedo
+	PhaseUndo
+)
+
+func (p RecoveryPhase) String() string {
+	switch p {
+	case PhaseAnalysis:
+		return "analysis"
+	case PhaseRedo:
+		return "redo"
+	case PhaseUndo:
+		return "undo"
+	}
+	return fmt.Sprintf("RecoveryPhase(%d)", int(p))
+}
+
+// RecoveryOptions configures RecoverWithOptions
+type RecoveryOptions struct {
+	// ReadBufferSize is the buffer the log is read through (default
+	// 64KB). With the largest frame in the log, it bounds the memory
+	// reading takes, whatever the size of the log.
+	ReadBufferSize int
+
+	// Progress, if set, is called every ProgressInterval bytes a pass
+	// reads (default 4MB) and at the end of each pass
+	Progress         func(RecoveryProgress)
+	ProgressInterval int64
+
+	// Resume, if set, is a marker saved from the Progress of an earlier,
+	// interrupted recovery of this log: redo starts after its LSN. The
+	// handler must have made what it was given up to then durable.
+	// Analysis still reads the whole log, and undo is unchanged.
+	Resume *RecoveryMarker
+}
+
+func (o RecoveryOptions) withDefaults() RecoveryOptions {
+	if o.ReadBufferSize <= 0 {
+		o.ReadBufferSize = defaultReadBufferSize
+	}
+	if o.ProgressInterval <= 0 {
+		o.ProgressInterval = 4 << 20
+	}
+	return o
+}
+
+// RecoveryProgress reports how far recovery has got
+type RecoveryProgress struct {
+	Phase RecoveryPhase
+	// BytesProcessed of the BytesTotal the pass reads: the log from its
+	// start for analysis, and from the redo LSN's segment for redo. Undo
+	// reads back only the updates it rolls back, and reports 0 of 0.
+	BytesProcessed int64
+	BytesTotal     int64
+	// RecordsApplied counts the calls to the handler so far, over every
+	// pass
+	RecordsApplied int
+	// LSN is the last record read
+	LSN LSN
+	// Marker is where a recovery interrupted now could resume. Save it
+	// once the handler has made its state durable; it does not move
+	// during analysis, and stays at the end of redo during undo.
+	Marker RecoveryMarker
+}
+
+// RecoveryMarker records how far redo got: every record through LSN has
+// been passed to the handler or needed no redo
+type RecoveryMarker struct {
+	LSN LSN
+}
+
+// recoveryMarkerMagic starts an encoded RecoveryMarker
+const recoveryMarkerMagic uint32 = 0x4d524c57 // "WLRM"
+
+// MarshalBinary encodes the marker, to be saved with the state recovery
+// applied records to
+// Format: Magic(4) + LSN(8) + Checksum(4)
+func (m RecoveryMarker) MarshalBinary() ([]byte, error) {
+	buf := binary.LittleEndian.AppendUint32(nil, recoveryMarkerMagic)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(m.LSN))
+	return binary.LittleEndian.AppendUint32(buf, computeChecksum(buf)), nil
+}
+
+// UnmarshalBinary decodes a marker encoded by MarshalBinary
+func (m *RecoveryMarker) UnmarshalBinary(data []byte) error {
+	if len(data) != 16 || binary.LittleEndian.Uint32(data[0:4]) != recoveryMarkerMagic ||
+		binary.LittleEndian.Uint32(data[12:16]) != computeChecksum(data[:12]) {
+		return ErrBadRecoveryMarker
+	}
+	m.LSN = LSN(binary.LittleEndian.Uint64(data[4:12]))
+	return nil
+}
+
+// recovery is the state of one run of recovery across its passes: the
+// handler calls made and the redo marker, and the progress of the pass
+// being run
+type recovery struct {
+	opts     RecoveryOptions
+	phase    RecoveryPhase
+	calls    int
+	marker   RecoveryMarker
+	lsn      LSN
+	total    int64
+	done     int64
+	reported int64 // done when progress was last reported
+}
+
+func newRecovery(opts RecoveryOptions) *recovery {
+	rc := &recovery{opts: opts.withDefaults()}
+	if opts.Resume != nil {
+		rc.marker = *opts.Resume
+	}
+	return rc
+}
+
+// startPass starts reading files for the current phase
+func (rc *recovery) startPass(files []SegmentInfo) {
+	rc.total, rc.done, rc.reported = 0, 0, 0
+	for _, file := range files {
+		rc.total += file.Size
+	}
+}
+
+// read notes a record read at offset bytes into the pass, and reports
+// progress every ProgressInterval bytes
+func (rc *recovery) read(offset int64, lsn LSN) {
+	rc.done, rc.lsn = offset, lsn
+	if rc.done-rc.reported >= rc.opts.ProgressInterval {
+		rc.report()
+	}
+}
+
+// endPass reports the end of the current pass
+func (rc *recovery) endPass() {
+	rc.done = rc.total
+	rc.report()
+}
+
+func (rc *recovery) report() {
+	rc.reported = rc.done
+	if rc.opts.Progress == nil {
+		return
+	}
+	rc.opts.Progress(RecoveryProgress{
+		Phase:          rc.phase,
+		BytesProcessed: rc.done,
+		BytesTotal:     rc.total,
+		RecordsApplied: rc.calls,
+		LSN:            rc.lsn,
+		Marker:         rc.marker,
+	})
+}
+
+// applied counts a handler call that returned err
+func (rc *recovery) applied(err error) error {
+	if err == nil {
+		rc.calls++
+	}
+	return err
+}
+
+// refReader reads back the records analysis located, keeping the file of
+// the last one open: undo reads them newest first, mostly from the same
+// segment
+type refReader struct {
+	keys   KeyProvider
+	size   int
+	path   string
+	file   *os.File
+	reader *frameReader
+}
+
+func (r *refReader) read(ref undoRef) (*LogRecord, error) {
+	if r.file == nil || r.path != ref.path {
+		r.close()
+		file, err := os.Open(ref.path)
+		if err != nil {
+			return nil, err
+		}
+		r.path, r.file = ref.path, file
+		r.reader = newFrameReader(file, r.size, r.keys)
+	}
+	if _, err := r.file.Seek(ref.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r.reader.reset(r.file)
+	records, _, err := r.reader.next()
+	if err != nil {
+		return nil, &RecordError{Offset: ref.offset, Err: err}
+	}
+	// Records of a compressed batch share the batch's offset
+	for _, record := range records {
+		if record.LSN == ref.lsn {
+			return record, nil
+		}
+	}
+	return nil, &RecordError{Offset: ref.offset, Err: fmt.Errorf("%w: lsn %d is not there", ErrInvalidRecord, ref.lsn)}
+}
+
+func (r *refReader) close() {
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
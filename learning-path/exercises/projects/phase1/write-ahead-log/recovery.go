@@ -0,0 +1,504 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+)
+
+// PageID identifies the page an Update modifies
+type PageID uint64
+
+// Update is the payload of RecordUpdate and RecordCLR records
+type Update struct {
+	PageID PageID
+	// Offset is where in the page the images apply
+	Offset uint32
+	// PageLSN is the page's LSN before this update, the LSN of the
+	// previous update to the page; 0 if unknown. Redo uses it to detect a
+	// page that missed an earlier update.
+	PageLSN  LSN
+	Before   []byte // undo image; empty in a CLR, which is redo-only
+	After    []byte // redo image
+	UndoNext LSN    // CLR only: next update of the transaction to undo, 0 if none
+}
+
+const (
+	// updateHeaderSize is PageID(8) + UndoNext(8) + BeforeLength(4)
+	updateHeaderSize = 20
+	// updateExtSize is Offset(4) + PageLSN(8), present when the high bit of
+	// BeforeLength is set
+	updateExtSize = 12
+	updateExtFlag = 1 << 31
+)
+
+// Encode serializes the update for use as LogRecord.Data
+// Format: PageID(8) + UndoNext(8) + BeforeLength(4) [+ Offset(4) + PageLSN(8)] + Before + After
+// Offset and PageLSN are written, and the high bit of BeforeLength set, only
+// if either is non-zero, so updates without them keep the original format.
+func (u *Update) Encode() []byte {
+	header := updateHeaderSize
+	beforeLen := uint32(len(u.Before))
+	if u.Offset != 0 || u.PageLSN != 0 {
+		header += updateExtSize
+		beforeLen |= updateExtFlag
+	}
+	buf := make([]byte, header+len(u.Before)+len(u.After))
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(u.PageID))
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(u.UndoNext))
+	binary.LittleEndian.PutUint32(buf[16:20], beforeLen)
+	if header > updateHeaderSize {
+		binary.LittleEndian.PutUint32(buf[20:24], u.Offset)
+		binary.LittleEndian.PutUint64(buf[24:32], uint64(u.PageLSN))
+	}
+	n := copy(buf[header:], u.Before)
+	copy(buf[header+n:], u.After)
+	return buf
+}
+
+// DecodeUpdate parses the Data of an update or CLR record. The returned
+// images alias data.
+func DecodeUpdate(data []byte) (*Update, error) {
+	if len(data) < updateHeaderSize {
+		return nil, ErrInvalidRecord
+	}
+	u := &Update{
+		PageID:   PageID(binary.LittleEndian.Uint64(data[0:8])),
+		UndoNext: LSN(binary.LittleEndian.Uint64(data[8:16])),
+	}
+	beforeLen := binary.LittleEndian.Uint32(data[16:20])
+	body := data[updateHeaderSize:]
+	if beforeLen&updateExtFlag != 0 {
+		if len(body) < updateExtSize {
+			return nil, ErrInvalidRecord
+		}
+		beforeLen &^= updateExtFlag
+		u.Offset = binary.LittleEndian.Uint32(body[0:4])
+		u.PageLSN = LSN(binary.LittleEndian.Uint64(body[4:12]))
+		body = body[updateExtSize:]
+	}
+	if int(beforeLen) > len(body) {
+		return nil, ErrInvalidRecord
+	}
+	u.Before = body[:beforeLen:beforeLen]
+	u.After = body[beforeLen:]
+	return u, nil
+}
+
+// Update decodes the payload of an update or CLR record
+func (r *LogRecord) Update() (*Update, error) {
+	if r.Type != RecordUpdate && r.Type != RecordCLR {
+		return nil, fmt.Errorf("wal: %s record lsn %d is not an update: %w", r.Type, r.LSN, ErrInvalidRecord)
+	}
+	return DecodeUpdate(r.Data)
+}
+
+// AppendUpdate appends an update record for txnID and returns its LSN
+func (w *WAL) AppendUpdate(txnID TxnID, u *Update) (LSN, error) {
+	return w.Append(&LogRecord{Type: RecordUpdate, TxnID: txnID, Data: u.Encode()})
+}
+
+// PageLSNReader is implemented by recovery handlers that track the LSN of
+// the last update applied to each page. Redo then skips records the page
+// already reflects, making replay idempotent, and fails with ErrPageLSNGap
+// if a page is older than an update's PageLSN.
+type PageLSNReader interface {
+	PageLSN(pageID PageID) (LSN, error)
+}
+
+// RecoveryResult summarizes what Recover did
+type RecoveryResult struct {
+	// TornBytes is the size of the torn write discarded from the end of
+	// the log when it was opened
+	TornBytes int64
+	Redone    int     // updates and CLRs passed to the handler by redo
+	Undone    int     // updates rolled back, one CLR each
+	Losers    []TxnID // transactions rolled back, in ascending order
+	// CheckpointLSN is the last checkpoint written with tables, 0 if none
+	CheckpointLSN LSN
+	// RedoLSN is where redo started reading: the checkpoint or its oldest
+	// recLSN, whichever is earlier. It is 0 without a checkpoint, when redo
+	// reads the whole log.
+	RedoLSN LSN
+	// StopLSN is the last record kept by RecoverTo or RecoverToTime
+	StopLSN LSN
+	// ResumedLSN is the LSN of the marker recovery resumed from, 0 if it
+	// did not; redo started after it
+	ResumedLSN LSN
+}
+
+// ErrPageLSNGap is returned by redo when a page has not seen the update an
+// update record was logged on top of, for example after a lost page write
+var ErrPageLSNGap = errors.New("wal: page is missing an earlier update")
+
+// analysis is the state rebuilt by the analysis pass
+type analysis struct {
+	// att is the active transaction table: transactions without a COMMIT
+	// or ABORT, with where their updates and CLRs are, oldest first
+	att map[TxnID][]undoRef
+	// dpt is the dirty page table: the first LSN that may have dirtied each
+	// page (its recLSN)
+	dpt     map[PageID]LSN
+	redoLSN LSN
+	maxLSN  LSN
+	// checkpointLSN is the last checkpoint with tables; redo reads from it
+	// or redoLSN, whichever is earlier
+	checkpointLSN LSN
+}
+
+// undoRef locates an update or CLR of an active transaction. Analysis
+// keeps these rather than the records, so a long transaction costs a few
+// words per update however large its images; undo reads back the ones it
+// rolls back.
+type undoRef struct {
+	lsn      LSN
+	clr      bool
+	undoNext LSN // of a CLR
+	path     string
+	offset   int64 // of the record's frame in path
+}
+
+// Recover restores the state described by the log in three ARIES passes.
+// Analysis rebuilds the active transaction and dirty page tables, taking
+// them from the last checkpoint written with tables when there is one. Redo
+// repeats history from the earliest recLSN, passing every update and CLR
+// to the handler, including those of transactions that will be undone;
+// with a checkpoint, records before its recLSNs are not read at all.
+// Undo rolls back the losers - transactions with no COMMIT or ABORT -
+// newest update first, logging a CLR for each undone update and an ABORT
+// per loser, so a crash during recovery never undoes an update twice.
+//
+// Every pass streams the log through a fixed-size buffer; see
+// RecoverWithOptions to size it, follow progress and resume.
+func (w *WAL) Recover(handler RecoveryHandler) (RecoveryResult, error) {
+	return w.RecoverWithOptions(handler, RecoveryOptions{})
+}
+
+// RecoverWithOptions is Recover with a read buffer, progress callbacks and
+// a marker to resume redo from
+func (w *WAL) RecoverWithOptions(handler RecoveryHandler, opts RecoveryOptions) (RecoveryResult, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.recoverLocked(handler, opts)
+}
+
+// recoverLocked runs the three passes. Caller holds w.mu.
+func (w *WAL) recoverLocked(handler RecoveryHandler, opts RecoveryOptions) (RecoveryResult, error) {
+	result := RecoveryResult{TornBytes: w.tornBytes}
+	rc := newRecovery(opts)
+	a, err := w.analyze(rc)
+	if err != nil {
+		return result, err
+	}
+	if a.checkpointLSN > 0 {
+		result.CheckpointLSN = a.checkpointLSN
+		result.RedoLSN = min(a.redoLSN, a.checkpointLSN)
+	}
+	if a.maxLSN > w.GetCurrentLSN() {
+		w.buffer.reset(a.maxLSN)
+	}
+	if resume := opts.Resume; resume != nil {
+		if resume.LSN > a.maxLSN {
+			return result, fmt.Errorf("%w: lsn %d is past the end of the log at %d", ErrBadRecoveryMarker, resume.LSN, a.maxLSN)
+		}
+		result.ResumedLSN = resume.LSN
+	}
+
+	if err := w.redo(handler, a, rc, &result); err != nil {
+		return result, err
+	}
+	err = w.undo(handler, a, rc, &result)
+	return result, err
+}
+
+// scanRecoverable scans the records with LSN >= from, treating a partial
+// record at the tail as the end of the log. A segmented log skips the
+// segments that end before from.
+func (w *WAL) scanRecoverable(from LSN, fn func(record *LogRecord) error) error {
+	return w.scanPass(from, newRecovery(RecoveryOptions{}), func(record *LogRecord, _ undoRef) error {
+		return fn(record)
+	})
+}
+
+// scanPass is scanRecoverable for a pass of rc, reading through its buffer
+// and reporting its progress. fn also gets where the record is.
+func (w *WAL) scanPass(from LSN, rc *recovery, fn func(record *LogRecord, at undoRef) error) error {
+	files, err := w.recoverableFiles(from)
+	if err != nil {
+		return err
+	}
+	rc.startPass(files)
+	keys := w.opts.EncryptionKeyProvider
+	var base int64
+	for _, file := range files {
+		err = scanFileBuffered(file.Path, 0, keys, rc.opts.ReadBufferSize, func(record *LogRecord, offset int64) error {
+			rc.read(base+offset, record.LSN)
+			if record.LSN < from {
+				return nil
+			}
+			return fn(record, undoRef{lsn: record.LSN, path: file.Path, offset: offset})
+		})
+		if err != nil {
+			break
+		}
+		base += file.Size
+	}
+	// A partial record at the tail is an interrupted write; stop there
+	if err != nil && !errors.Is(err, ErrTruncatedRecord) {
+		return err
+	}
+	rc.endPass()
+	return nil
+}
+
+// recoverableFiles returns the files holding the records with LSN >= from:
+// the log file, or the segments from the one holding from onward
+func (w *WAL) recoverableFiles(from LSN) ([]SegmentInfo, error) {
+	if w.opts.Dir == "" {
+		info, err := os.Stat(w.opts.FilePath)
+		if err != nil {
+			return nil, err
+		}
+		return []SegmentInfo{{Path: w.opts.FilePath, Size: info.Size()}}, nil
+	}
+	segments, err := ListSegments(w.opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for len(segments) > 1 && segments[1].StartLSN <= from {
+		segments = segments[1:]
+	}
+	return segments, nil
+}
+
+func (w *WAL) analyze(rc *recovery) (*analysis, error) {
+	a := &analysis{
+		att: make(map[TxnID][]undoRef),
+		dpt: make(map[PageID]LSN),
+	}
+	rc.phase = PhaseAnalysis
+	err := w.scanPass(0, rc, func(record *LogRecord, at undoRef) error {
+		a.maxLSN = max(a.maxLSN, record.LSN)
+		switch record.Type {
+		case RecordBegin:
+			if _, ok := a.att[record.TxnID]; !ok {
+				a.att[record.TxnID] = nil
+			}
+		case RecordUpdate, RecordCLR:
+			u, err := DecodeUpdate(record.Data)
+			if err != nil {
+				return fmt.Errorf("wal: %s record lsn %d: %w", record.Type, record.LSN, err)
+			}
+			if _, ok := a.dpt[u.PageID]; !ok {
+				a.dpt[u.PageID] = record.LSN
+			}
+			if record.Type == RecordCLR {
+				at.clr, at.undoNext = true, u.UndoNext
+			}
+			a.att[record.TxnID] = append(a.att[record.TxnID], at)
+		case RecordCommit, RecordAbort:
+			delete(a.att, record.TxnID)
+		case RecordCheckpoint:
+			if len(record.Data) == 0 {
+				return nil
+			}
+			data, err := DecodeCheckpointData(record.Data)
+			if err != nil {
+				return fmt.Errorf("wal: %s record lsn %d: %w", record.Type, record.LSN, err)
+			}
+			// The checkpoint's tables replace the ones built so far. The
+			// updates already collected for its active transactions are
+			// kept for undo.
+			att := make(map[TxnID][]undoRef, len(data.ActiveTxns))
+			for _, txn := range data.ActiveTxns {
+				att[txn.TxnID] = a.att[txn.TxnID]
+			}
+			a.att = att
+			a.dpt = make(map[PageID]LSN, len(data.DirtyPages))
+			for _, page := range data.DirtyPages {
+				a.dpt[page.PageID] = page.RecLSN
+			}
+			a.checkpointLSN = record.LSN
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.redoLSN = math.MaxUint64
+	for _, recLSN := range a.dpt {
+		a.redoLSN = min(a.redoLSN, recLSN)
+	}
+	return a, nil
+}
+
+// redo replays the log in order from result.RedoLSN, or from after the
+// marker recovery resumes from. Transaction status records are always
+// reported; updates and CLRs only from the page's recLSN onward and, for a
+// PageLSNReader, only if the page has not seen them yet.
+func (w *WAL) redo(handler RecoveryHandler, a *analysis, rc *recovery, result *RecoveryResult) error {
+	pages, _ := handler.(PageLSNReader)
+	tables, _ := handler.(CheckpointDataHandler)
+	from := result.RedoLSN
+	if result.ResumedLSN > 0 {
+		from = max(from, result.ResumedLSN+1)
+	}
+	rc.phase = PhaseRedo
+	return w.scanPass(from, rc, func(record *LogRecord, _ undoRef) error {
+		if err := w.redoRecord(handler, pages, tables, a, rc, record, result); err != nil {
+			return err
+		}
+		// Every record through this one is applied or needs no applying
+		rc.marker.LSN = record.LSN
+		return nil
+	})
+}
+
+// redoRecord passes one record to the handler if redo has to
+func (w *WAL) redoRecord(handler RecoveryHandler, pages PageLSNReader, tables CheckpointDataHandler, a *analysis, rc *recovery, record *LogRecord, result *RecoveryResult) error {
+	switch record.Type {
+	case RecordBegin:
+		return rc.applied(handler.OnBegin(record.TxnID, record.LSN))
+	case RecordCommit:
+		return rc.applied(handler.OnCommit(record.TxnID, record.LSN))
+	case RecordAbort:
+		return rc.applied(handler.OnAbort(record.TxnID, record.LSN))
+	case RecordCheckpoint:
+		if err := rc.applied(handler.OnCheckpoint(record.LSN)); err != nil {
+			return err
+		}
+		if tables == nil || len(record.Data) == 0 {
+			return nil
+		}
+		data, err := DecodeCheckpointData(record.Data)
+		if err != nil {
+			return err
+		}
+		return tables.OnCheckpointData(record.LSN, data)
+	case RecordUpdate, RecordCLR:
+		if record.LSN < a.redoLSN {
+			return nil
+		}
+		u, err := DecodeUpdate(record.Data)
+		if err != nil {
+			return err
+		}
+		if recLSN, ok := a.dpt[u.PageID]; !ok || record.LSN < recLSN {
+			return nil
+		}
+		if pages != nil {
+			pageLSN, err := pages.PageLSN(u.PageID)
+			if err != nil {
+				return err
+			}
+			if pageLSN >= record.LSN {
+				return nil
+			}
+			if pageLSN < u.PageLSN {
+				return fmt.Errorf("wal: %s record lsn %d: page %d is at lsn %d: %w",
+					record.Type, record.LSN, u.PageID, pageLSN, ErrPageLSNGap)
+			}
+		}
+		result.Redone++
+		return rc.applied(handler.OnUpdate(record.TxnID, record.LSN, record.Data))
+	default:
+		return ErrUnknownRecordType
+	}
+}
+
+// undoStep is one update of a loser that still has to be undone
+type undoStep struct {
+	txnID TxnID
+	ref   undoRef
+	next  LSN // the transaction's next update to undo after this one
+}
+
+// undo rolls back every loser, processing updates across all losers in
+// descending LSN order. Each update is read back from the log as its turn
+// comes. Caller holds w.mu.
+func (w *WAL) undo(handler RecoveryHandler, a *analysis, rc *recovery, result *RecoveryResult) error {
+	losers := make([]TxnID, 0, len(a.att))
+	for txnID := range a.att {
+		losers = append(losers, txnID)
+	}
+	sort.Slice(losers, func(i, j int) bool { return losers[i] < losers[j] })
+
+	var steps []undoStep
+	for _, txnID := range losers {
+		refs := a.att[txnID]
+		// Walk backwards; a CLR means everything after its UndoNext has
+		// already been undone by an earlier, interrupted recovery
+		limit := LSN(math.MaxUint64)
+		first := len(steps)
+		for i := len(refs) - 1; i >= 0; i-- {
+			ref := refs[i]
+			if ref.lsn > limit {
+				continue
+			}
+			if ref.clr {
+				limit = ref.undoNext
+				continue
+			}
+			steps = append(steps, undoStep{txnID: txnID, ref: ref})
+		}
+		for i := first; i+1 < len(steps); i++ {
+			steps[i].next = steps[i+1].ref.lsn
+		}
+	}
+	sort.Slice(steps, func(i, j int) bool { return steps[i].ref.lsn > steps[j].ref.lsn })
+
+	rc.phase = PhaseUndo
+	rc.startPass(nil)
+	reader := &refReader{keys: w.opts.EncryptionKeyProvider, size: rc.opts.ReadBufferSize}
+	defer reader.close()
+	pages, _ := handler.(PageLSNReader)
+	for _, step := range steps {
+		record, err := reader.read(step.ref)
+		if err != nil {
+			return err
+		}
+		update, err := DecodeUpdate(record.Data)
+		if err != nil {
+			return err
+		}
+		u := &Update{
+			PageID:   update.PageID,
+			Offset:   update.Offset,
+			After:    update.Before,
+			UndoNext: step.next,
+		}
+		if pages != nil {
+			pageLSN, err := pages.PageLSN(u.PageID)
+			if err != nil {
+				return err
+			}
+			u.PageLSN = pageLSN
+		}
+		clr := &LogRecord{Type: RecordCLR, TxnID: step.txnID, Data: u.Encode()}
+		lsn, err := w.appendLocked(clr)
+		if err != nil {
+			return err
+		}
+		if err := rc.applied(handler.OnUpdate(step.txnID, lsn, clr.Data)); err != nil {
+			return err
+		}
+		result.Undone++
+	}
+
+	for _, txnID := range losers {
+		lsn, err := w.appendLocked(&LogRecord{Type: RecordAbort, TxnID: txnID})
+		if err != nil {
+			return err
+		}
+		if err := rc.applied(handler.OnAbort(txnID, lsn)); err != nil {
+			return err
+		}
+	}
+	result.Losers = losers
+	rc.endPass()
+	return w.flushInternal()
+}
@@ -0,0 +1,189 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSegmentSize = 16 << 20
+
+	// segmentExt is the suffix of segment files; the name before it is the
+	// segment's starting LSN as 16 hex digits, so names sort in LSN order
+	segmentExt = ".wal"
+)
+
+// SegmentInfo describes one segment file of a segmented log
+type SegmentInfo struct {
+	Path     string
+	StartLSN LSN // LSN of the first record written to the segment
+	Size     int64
+}
+
+func segmentName(start LSN) string {
+	return fmt.Sprintf("%016x%s", uint64(start), segmentExt)
+}
+
+// parseSegmentName returns the starting LSN encoded in a segment file name
+func parseSegmentName(name string) (LSN, bool) {
+	hex, ok := strings.CutSuffix(name, segmentExt)
+	if !ok || len(hex) != 16 {
+		return 0, false
+	}
+	start, err := strconv.ParseUint(hex, 16, 64)
+	if err != nil {
+		return 0, false
+	}
+	return LSN(start), true
+}
+
+// ListSegments returns the segment files in dir ordered by starting LSN.
+// Files that are not named like segments are ignored.
+func ListSegments(dir string) ([]SegmentInfo, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []SegmentInfo
+	for _, entry := range entries {
+		start, ok := parseSegmentName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, SegmentInfo{
+			Path:     filepath.Join(dir, entry.Name()),
+			StartLSN: start,
+			Size:     info.Size(),
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].StartLSN < segments[j].StartLSN })
+	return segments, nil
+}
+
+// Segments lists the files backing the log in LSN order. A log opened with
+// FilePath is reported as a single segment.
+func (w *WAL) Segments() ([]SegmentInfo, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+
+	if w.opts.Dir != "" {
+		return ListSegments(w.opts.Dir)
+	}
+
+	info, err := w.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	start := w.GetCurrentLSN() + 1
+	err = scanLog(w.opts.FilePath, w.opts.EncryptionKeyProvider, func(record *LogRecord, _ int64) error {
+		start = record.LSN
+		return errStopScan
+	})
+	if err != nil && err != errStopScan {
+		return nil, err
+	}
+	return []SegmentInfo{{Path: w.opts.FilePath, StartLSN: start, Size: info.Size()}}, nil
+}
+
+// openSegments opens the newest segment in dir for appending, creating the
+// first segment if the directory holds none. lastLSN is the highest LSN
+// already in the log.
+func (w *WAL) openSegments(lastLSN LSN) error {
+	segments, err := ListSegments(w.opts.Dir)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return w.rotate(lastLSN + 1)
+	}
+
+	active := segments[len(segments)-1]
+	file, err := os.OpenFile(active.Path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	w.segSize = active.Size
+	return nil
+}
+
+// rotate closes the active segment and starts a new one whose first record
+// will have LSN start. Caller holds w.writeMu or w.mu exclusively and has
+// synced the active segment.
+func (w *WAL) rotate(start LSN) error {
+	path := filepath.Join(w.opts.Dir, segmentName(start))
+	flags := os.O_RDWR | os.O_CREATE | os.O_EXCL | os.O_APPEND
+	if w.opts.Preallocate && w.takeSpare(path) {
+		flags = os.O_RDWR | os.O_APPEND
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	// Make the new directory entry durable before records land in it
+	if err := syncDir(w.opts.Dir); err != nil {
+		file.Close()
+		os.Remove(path)
+		return err
+	}
+
+	if w.file != nil {
+		w.file.Close()
+		w.kickArchiver()
+	}
+	w.file = file
+	w.segSize = 0
+	if w.opts.Preallocate && w.spareReady == nil {
+		w.prepareSpare()
+	}
+	return nil
+}
+
+// needsRotation reports whether n more bytes would overflow the active
+// segment. An empty segment always accepts a record, however large.
+func (w *WAL) needsRotation(pending, n int) bool {
+	if w.opts.Dir == "" {
+		return false
+	}
+	used := w.segSize + int64(pending)
+	return used > 0 && used+int64(n) > w.opts.SegmentSize
+}
+
+// truncateSegments removes every segment whose records all precede lsn.
+// The active segment and segments not yet archived are never removed.
+// Caller holds w.mu.
+func (w *WAL) truncateSegments(lsn LSN) error {
+	segments, err := ListSegments(w.opts.Dir)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(segments); i++ {
+		if segments[i+1].StartLSN > lsn || !w.isArchived(segments[i]) {
+			break
+		}
+		if err := removeSegment(segments[i]); err != nil {
+			return err
+		}
+	}
+	return syncDir(w.opts.Dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
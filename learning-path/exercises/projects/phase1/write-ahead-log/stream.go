@@ -0,0 +1,256 @@
+package wal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"os"
+	"sort"
+)
+
+// ErrLSNTruncated is returned when a stream needs records that Truncate
+// has already removed; the reader has to resync from a snapshot
+var ErrLSNTruncated = errors.New("wal: requested LSN has been truncated")
+
+// Stream returns the durable records with LSN >= fromLSN, in LSN order,
+// up to the flush LSN at the time iteration starts. Records are read from
+// disk, so streaming does not block appends. A failure is yielded as the
+// final (nil, err) pair.
+//
+// A fromLSN of 0 or 1 starts at the oldest record still in the log.
+func (w *WAL) Stream(fromLSN LSN) iter.Seq2[*LogRecord, error] {
+	return func(yield func(*LogRecord, error) bool) {
+		c := &logCursor{w: w, next: max(fromLSN, 1)}
+		defer c.close()
+		if ok, err := c.read(w.GetFlushLSN(), yield); ok && err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Records returns every record appended so far with LSN >= fromLSN, in
+// LSN order. Unlike Stream it does not stop at the flush LSN: buffered
+// records are written to the file first, without waiting for a sync, so
+// the iteration also covers records that are not durable yet. It suits
+// tools that inspect a live log, such as dumpers and verifiers; replicas
+// should use Stream or Follow. A failure is yielded as the final
+// (nil, err) pair.
+//
+//	for record, err := range w.Records(0) {
+//		if err != nil { ... }
+//		fmt.Println(record.LSN, record.Type)
+//	}
+func (w *WAL) Records(fromLSN LSN) iter.Seq2[*LogRecord, error] {
+	return func(yield func(*LogRecord, error) bool) {
+		w.mu.RLock()
+		w.writeMu.Lock()
+		err := w.writeBuffered()
+		written := w.writtenLSN
+		w.writeMu.Unlock()
+		w.mu.RUnlock()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		c := &logCursor{w: w, next: max(fromLSN, 1)}
+		defer c.close()
+		if ok, err := c.read(written, yield); ok && err != nil {
+			yield(nil, err)
+		}
+	}
+}
+
+// Follow is like Stream but does not stop at the end of the log: it
+// blocks until more records are flushed and yields them as they become
+// durable. It ends with ctx.Err() when ctx is done and with ErrLogClosed
+// once the log is closed and every flushed record has been yielded.
+//
+// A replica tails the primary with:
+//
+//	for record, err := range w.Follow(ctx, replica.NextLSN()) {
+//		if err != nil { ... }
+//		replica.Apply(record)
+//	}
+func (w *WAL) Follow(ctx context.Context, fromLSN LSN) iter.Seq2[*LogRecord, error] {
+	return func(yield func(*LogRecord, error) bool) {
+		c := &logCursor{w: w, next: max(fromLSN, 1)}
+		defer c.close()
+		for {
+			// Take the signal first so a flush between reading the flush
+			// LSN and waiting is not missed
+			flushed := w.flushSignal()
+			closed := w.closed.Load()
+			ok, err := c.read(w.GetFlushLSN(), yield)
+			if !ok {
+				return
+			}
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			if closed {
+				yield(nil, ErrLogClosed)
+				return
+			}
+
+			select {
+			case <-flushed:
+			case <-ctx.Done():
+				yield(nil, ctx.Err())
+				return
+			}
+		}
+	}
+}
+
+// flushSignal returns a channel closed at the next flush or Close
+func (w *WAL) flushSignal() <-chan struct{} {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	if w.flushed == nil {
+		w.flushed = make(chan struct{})
+	}
+	return w.flushed
+}
+
+// notifyFlushed wakes every Follow waiting for new records
+func (w *WAL) notifyFlushed() {
+	w.flushMu.Lock()
+	defer w.flushMu.Unlock()
+	if w.flushed != nil {
+		close(w.flushed)
+		w.flushed = nil
+	}
+}
+
+// logCursor reads a log forward with its own file handle, following
+// segment rotation and the file replacement done by Truncate
+type logCursor struct {
+	w      *WAL
+	next   LSN // lowest LSN not yet yielded
+	file   *os.File
+	reader *frameReader
+	start  LSN // segmented log: StartLSN of the open segment
+
+	pending []*LogRecord // rest of the last compressed batch read
+}
+
+// read yields the records from c.next through limit. It reports false if
+// the consumer stopped the iteration.
+func (c *logCursor) read(limit LSN, yield func(*LogRecord, error) bool) (bool, error) {
+	for c.next <= limit {
+		if len(c.pending) == 0 {
+			if c.file == nil {
+				if err := c.open(); err != nil {
+					return true, err
+				}
+			}
+			records, _, err := c.reader.next()
+			if err == io.EOF {
+				moved, err := c.advance()
+				if err != nil {
+					return true, err
+				}
+				if !moved {
+					return true, fmt.Errorf("wal: log ends before LSN %d", limit)
+				}
+				continue
+			}
+			if err != nil {
+				return true, err
+			}
+			c.pending = records
+		}
+
+		record := c.pending[0]
+		c.pending = c.pending[1:]
+		if record.LSN < c.next {
+			continue
+		}
+		// Records are numbered without gaps, so a jump past next means
+		// the records in between were truncated
+		if record.LSN > c.next && c.next > 1 {
+			return true, fmt.Errorf("%w: want %d, log resumes at %d", ErrLSNTruncated, c.next, record.LSN)
+		}
+		c.next = record.LSN + 1
+		if !yield(record, nil) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// open opens the file that holds c.next
+func (c *logCursor) open() error {
+	path := c.w.opts.FilePath
+	if c.w.opts.Dir != "" {
+		segments, err := ListSegments(c.w.opts.Dir)
+		if err != nil {
+			return err
+		}
+		if len(segments) == 0 {
+			return fmt.Errorf("wal: no segments in %s", c.w.opts.Dir)
+		}
+		// The last segment starting at or before next, else the oldest
+		i := max(sort.Search(len(segments), func(i int) bool {
+			return segments[i].StartLSN > c.next
+		})-1, 0)
+		path, c.start = segments[i].Path, segments[i].StartLSN
+	}
+	return c.openFile(path)
+}
+
+func (c *logCursor) openFile(path string) error {
+	c.close()
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	c.file = file
+	c.reader = newFrameReader(file, defaultReadBufferSize, c.w.opts.EncryptionKeyProvider)
+	return nil
+}
+
+// advance moves past the end of the open file: to the next segment, or to
+// the file that replaced the log after a Truncate. It reports false if the
+// open file is still the end of the log.
+func (c *logCursor) advance() (bool, error) {
+	if c.w.opts.Dir != "" {
+		segments, err := ListSegments(c.w.opts.Dir)
+		if err != nil {
+			return false, err
+		}
+		for _, segment := range segments {
+			if segment.StartLSN > c.start {
+				c.start = segment.StartLSN
+				return true, c.openFile(segment.Path)
+			}
+		}
+		return false, nil
+	}
+
+	opened, err := c.file.Stat()
+	if err != nil {
+		return false, err
+	}
+	current, err := os.Stat(c.w.opts.FilePath)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(opened, current) {
+		return false, nil
+	}
+	return true, c.openFile(c.w.opts.FilePath)
+}
+
+func (c *logCursor) close() {
+	c.pending = nil
+	if c.file != nil {
+		c.file.Close()
+		c.file = nil
+		c.reader = nil
+	}
+}
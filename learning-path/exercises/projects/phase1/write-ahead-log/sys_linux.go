@@ -0,0 +1,53 @@
+//go:build linux
+
+package wal
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocKeepSize is FALLOC_FL_KEEP_SIZE: allocate blocks without changing
+// the file size, so readers still see the end of the log
+const fallocKeepSize = 0x1
+
+// datasync flushes the file's data, and only the metadata needed to read
+// it back, to stable storage
+func datasync(f *os.File) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var syncErr error
+	err = conn.Control(func(fd uintptr) {
+		syncErr = syscall.Fdatasync(int(fd))
+	})
+	if err != nil {
+		return err
+	}
+	if syncErr != nil {
+		return &os.PathError{Op: "fdatasync", Path: f.Name(), Err: syncErr}
+	}
+	return nil
+}
+
+// preallocate reserves size bytes of disk for the file. File systems that
+// cannot preallocate are not an error.
+func preallocate(f *os.File, size int64) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var allocErr error
+	err = conn.Control(func(fd uintptr) {
+		allocErr = syscall.Fallocate(int(fd), fallocKeepSize, 0, size)
+	})
+	if err != nil {
+		return err
+	}
+	if allocErr != nil && !errors.Is(allocErr, syscall.EOPNOTSUPP) {
+		return &os.PathError{Op: "fallocate", Path: f.Name(), Err: allocErr}
+	}
+	return nil
+}
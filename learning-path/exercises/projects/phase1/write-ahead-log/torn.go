@@ -0,0 +1,77 @@
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// activePath returns the file new records are appended to, or "" for a
+// segment directory that has no segments yet
+func (w *WAL) activePath() (string, error) {
+	if w.opts.Dir == "" {
+		return w.opts.FilePath, nil
+	}
+	segments, err := ListSegments(w.opts.Dir)
+	if err != nil || len(segments) == 0 {
+		return "", err
+	}
+	return segments[len(segments)-1].Path, nil
+}
+
+// repairTail truncates a torn write from the end of the log file at path
+// and returns the number of bytes discarded. The first unreadable record
+// is torn only if no intact record follows it; otherwise the log is
+// corrupt and the *RecordError is returned with the file left untouched.
+func repairTail(path string, keys KeyProvider) (int64, error) {
+	err := scanFile(path, 0, keys, func(*LogRecord, int64) error { return nil })
+	var recErr *RecordError
+	if err == nil || !errors.As(err, &recErr) {
+		return 0, err
+	}
+	// A record from a newer writer is intact, whatever follows it
+	if errors.Is(recErr, ErrUnsupportedFormat) {
+		return 0, recErr
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	tail, err := io.ReadAll(io.NewSectionReader(file, recErr.Offset, maxRecordSize+sealedHeaderSize))
+	if err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if size := info.Size() - recErr.Offset; size > int64(len(tail)) || hasIntactRecord(tail[1:], keys) {
+		return 0, recErr
+	}
+
+	if err := file.Truncate(recErr.Offset); err != nil {
+		return 0, err
+	}
+	if err := file.Sync(); err != nil {
+		return 0, err
+	}
+	return int64(len(tail)), nil
+}
+
+// hasIntactRecord reports whether a complete, valid record or frame starts
+// at any offset of data
+func hasIntactRecord(data []byte, keys KeyProvider) bool {
+	for i := 0; i+recordHeaderSize <= len(data); i++ {
+		if frameHeaderLen(binary.LittleEndian.Uint32(data[i:])) == 0 {
+			continue
+		}
+		if _, err := decodeFrame(data[i:], keys); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package wal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// VerifyOptions configures Verify
+type VerifyOptions struct {
+	// Repair truncates the log at the first bad record: the file holding
+	// it is cut there and every later segment is removed, so the log
+	// opens with the intact records only. Records of an unsupported
+	// format are reported but not removed.
+	Repair bool
+	// ReportPath, if set, receives the report as JSON
+	ReportPath string
+	// Keys decrypts the payloads of an encrypted log
+	Keys KeyProvider
+}
+
+// Report describes a log checked by Verify. The log is intact up to
+// LastLSN; if a bad record was found, Err says why and BadFile and
+// BadOffset say where.
+type Report struct {
+	Path     string `json:"path"`
+	Records  int    `json:"records"`
+	FirstLSN LSN    `json:"first_lsn"`
+	LastLSN  LSN    `json:"last_lsn"`
+
+	BadFile   string `json:"bad_file,omitempty"`
+	BadOffset int64  `json:"bad_offset"`
+	Err       error  `json:"-"`
+	Error     string `json:"error,omitempty"`
+	// LostBytes counts the bytes from the bad record to the end of the log
+	LostBytes int64 `json:"lost_bytes"`
+	// TornTail reports that no intact record follows the bad one in its
+	// file, the mark of a write torn by a crash rather than of corruption
+	TornTail bool `json:"torn_tail"`
+
+	Repaired        bool     `json:"repaired"`
+	RemovedSegments []string `json:"removed_segments,omitempty"`
+}
+
+// OK reports whether the whole log was intact
+func (r *Report) OK() bool {
+	return r.Err == nil
+}
+
+func (r *Report) String() string {
+	s := fmt.Sprintf("%s: %d intact records, LSN %d..%d", r.Path, r.Records, r.FirstLSN, r.LastLSN)
+	if r.OK() {
+		return s
+	}
+	s += fmt.Sprintf("; bad record in %s at offset %d: %v; %d bytes after it", r.BadFile, r.BadOffset, r.Err, r.LostBytes)
+	if r.TornTail {
+		s += " (torn tail)"
+	}
+	if r.Repaired {
+		s += "; truncated"
+	}
+	return s
+}
+
+// Verify walks the log file or segment directory at path, validating the
+// checksum of every record and that LSNs increase, and stops at the first
+// bad record. The log must not be open. Problems found in the log are
+// described by the report; the error is only set if the log could not be
+// read, repaired or reported on.
+func Verify(path string, opts VerifyOptions) (Report, error) {
+	report := Report{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return report, err
+	}
+	files := []SegmentInfo{{Path: path, Size: info.Size()}}
+	if info.IsDir() {
+		if files, err = ListSegments(path); err != nil {
+			return report, err
+		}
+	}
+
+	bad := -1
+	for i, file := range files {
+		offset, err := verifyFile(file.Path, opts.Keys, &report)
+		var recErr *RecordError
+		if errors.As(err, &recErr) {
+			report.BadFile, report.BadOffset, report.Err = file.Path, recErr.Offset, recErr.Err
+		} else if err != nil {
+			return report, err
+		} else if offset >= 0 {
+			report.BadFile, report.BadOffset = file.Path, offset
+		} else {
+			continue
+		}
+		bad = i
+		break
+	}
+
+	if bad >= 0 {
+		report.Error = report.Err.Error()
+		report.LostBytes = files[bad].Size - report.BadOffset
+		for _, file := range files[bad+1:] {
+			report.LostBytes += file.Size
+		}
+		if bad == len(files)-1 {
+			report.TornTail, err = isTornTail(report.BadFile, report.BadOffset, opts.Keys)
+			if err != nil {
+				return report, err
+			}
+		}
+		// A record from a newer writer is not damage, so it is never cut
+		if opts.Repair && !errors.Is(report.Err, ErrUnsupportedFormat) {
+			if err := repairLog(&report, files[bad:], info.IsDir()); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	if opts.ReportPath != "" {
+		data, err := json.MarshalIndent(&report, "", "  ")
+		if err == nil {
+			err = os.WriteFile(opts.ReportPath, append(data, '\n'), 0644)
+		}
+		if err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// verifyFile adds the intact records of one log file to report. It
+// returns the offset of a record whose LSN does not increase, having set
+// report.Err, or -1; an unreadable record is returned as a *RecordError.
+func verifyFile(path string, keys KeyProvider, report *Report) (int64, error) {
+	// A bad LSN inside a compressed batch discards the whole batch, so the
+	// records before it are only counted once their batch is complete
+	var batch []*LogRecord
+	batchOffset := int64(-1)
+	badOffset := int64(-1)
+	commit := func() {
+		for _, record := range batch {
+			if report.Records == 0 {
+				report.FirstLSN = record.LSN
+			}
+			report.Records++
+			report.LastLSN = record.LSN
+		}
+		batch = batch[:0]
+	}
+
+	err := scanFile(path, 0, keys, func(record *LogRecord, offset int64) error {
+		if offset != batchOffset {
+			commit()
+			batchOffset = offset
+		}
+		last := report.LastLSN
+		if n := len(batch); n > 0 {
+			last = batch[n-1].LSN
+		}
+		if report.Records+len(batch) > 0 && record.LSN <= last {
+			report.Err = fmt.Errorf("%w: LSN %d after %d", ErrLSNNotMonotonic, record.LSN, last)
+			badOffset = offset
+			return errStopScan
+		}
+		batch = append(batch, record)
+		return nil
+	})
+	if err == errStopScan {
+		batch = batch[:0]
+		return badOffset, nil
+	}
+	commit()
+	return -1, err
+}
+
+// isTornTail reports whether no intact record follows the bad record at
+// offset in the file at path
+func isTornTail(path string, offset int64, keys KeyProvider) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	rest, err := io.ReadAll(io.NewSectionReader(file, offset, 1<<62))
+	if err != nil || len(rest) == 0 {
+		return false, err
+	}
+	return !hasIntactRecord(rest[1:], keys), nil
+}
+
+// repairLog cuts the log at the bad record, the first in files. A segment
+// that would be left empty is removed instead, so the log reopens with a
+// segment named after its next record.
+func repairLog(report *Report, files []SegmentInfo, segmented bool) error {
+	remove := files[1:]
+	if segmented && report.BadOffset == 0 {
+		remove = files
+	} else {
+		file, err := os.OpenFile(files[0].Path, os.O_RDWR, 0)
+		if err != nil {
+			return err
+		}
+		err = file.Truncate(report.BadOffset)
+		if err == nil {
+			err = file.Sync()
+		}
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, file := range remove {
+		if err := os.Remove(file.Path); err != nil {
+			return err
+		}
+		report.RemovedSegments = append(report.RemovedSegments, file.Path)
+	}
+	report.Repaired = true
+	return nil
+}
@@ -0,0 +1,275 @@
+package columnarstore
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+)
+
+// segmentRows is the number of rows summarized by one zone map entry
+const segmentRows = 1024
+
+// ErrUnsortableColumn is returned by SortBy for a column type that cannot
+// be reordered
+var ErrUnsortableColumn = errors.New("column does not support reordering")
+
+// permuter is implemented by columns that can be reordered in place.
+// After permute(perm), row i holds what was previously row perm[i].
+type permuter interface {
+	permute(perm []int)
+}
+
+// clustering records the composite key the store was last sorted by and
+// whether rows appended since still follow that order
+type clustering struct {
+	keys   []string
+	sorted bool
+}
+
+// appended updates the clustering state after a row was appended
+func (c *clustering) appended(ps *PropertyStore) {
+	if !c.sorted || ps.rowCount < 2 {
+		return
+	}
+	if ps.compareRows(ps.rowCount-2, ps.rowCount-1, c.keys) > 0 {
+		c.sorted = false
+	}
+}
+
+// updated clears sorted if an update changed a clustering key column
+func (c *clustering) updated(values map[string]any) {
+	for _, name := range c.keys {
+		if _, ok := values[name]; ok {
+			c.sorted = false
+		}
+	}
+}
+
+// SortBy reorders every column by the composite key cols, comparing the
+// first column, then the second for ties, and so on. NULLs sort first and
+// the sort is stable. The key is kept as the store's clustering key.
+// Pending deletes and updates are compacted first.
+func (ps *PropertyStore) SortBy(cols ...string) error {
+	if len(cols) == 0 {
+		return ErrColumnNotFound
+	}
+	for _, name := range cols {
+		if _, ok := ps.columns[name]; !ok {
+			return ErrColumnNotFound
+		}
+	}
+	for _, name := range ps.names {
+		if _, ok := ps.columns[name].(permuter); !ok {
+			return ErrUnsortableColumn
+		}
+	}
+
+	if _, err := ps.Compact(); err != nil {
+		return err
+	}
+
+	perm := make([]int, ps.rowCount)
+	for i := range perm {
+		perm[i] = i
+	}
+	slices.SortStableFunc(perm, func(a, b int) int {
+		return ps.compareRows(a, b, cols)
+	})
+
+	for _, name := range ps.names {
+		ps.columns[name].(permuter).permute(perm)
+	}
+	ps.cluster = clustering{keys: slices.Clone(cols), sorted: true}
+	return nil
+}
+
+// ClusteringKey returns the columns of the last SortBy and whether the rows
+// are still in that order. Appending a row out of order clears sorted.
+func (ps *PropertyStore) ClusteringKey() (cols []string, sorted bool) {
+	return slices.Clone(ps.cluster.keys), ps.cluster.sorted
+}
+
+// compareRows compares rows a and b by the given columns
+func (ps *PropertyStore) compareRows(a, b int, cols []string) int {
+	for _, name := range cols {
+		va, _ := ps.value(name, a)
+		vb, _ := ps.value(name, b)
+		if c := compareValues(va, vb); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues orders two values of the same column; nil (NULL) sorts first
+func compareValues(a, b any) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	switch va := a.(type) {
+	case int64:
+		return cmp.Compare(va, b.(int64))
+	case float64:
+		return cmp.Compare(va, b.(float64))
+	case string:
+		return cmp.Compare(va, b.(string))
+	}
+	return 0
+}
+
+func (c *IntColumn) permute(perm []int) {
+	old := &IntColumn{values: c.values, nulls: c.nulls, bitWidth: c.bitWidth, minValue: c.minValue, rowCount: c.rowCount}
+	c.values = make([]byte, len(old.values))
+	c.nulls = NewBitmap(0)
+	for i, src := range perm {
+		if old.nulls.Test(src) {
+			c.nulls.Set(i)
+			continue
+		}
+		c.packValue(old.unpackValue(src), i)
+	}
+}
+
+func (c *StringColumn) permute(perm []int) {
+	indices := make([]uint32, len(c.indices))
+	nulls := NewBitmap(0)
+	for i, src := range perm {
+		indices[i] = c.indices[src]
+		if c.nulls.Test(src) {
+			nulls.Set(i)
+		}
+	}
+	c.indices, c.nulls = indices, nulls
+}
+
+func (c *FloatColumn) permute(perm []int) {
+	values := make([]float64, len(c.values))
+	nulls := NewBitmap(0)
+	for i, src := range perm {
+		values[i] = c.values[src]
+		if c.nulls.Test(src) {
+			nulls.Set(i)
+		}
+	}
+	c.values, c.nulls = values, nulls
+}
+
+// Zone summarizes one segment of a column: rows [Start, End)
+type Zone struct {
+	Start, End int
+	Min, Max   any // nil if every value in the segment is NULL
+	Nulls      int
+}
+
+// ZoneMaps returns the min/max summary of each segment of col. Segments
+// whose range excludes a predicate can be skipped without reading them;
+// after SortBy(col) the ranges no longer overlap, so at most the segments
+// holding matching values are read. Deleted rows are left out and updated
+// values counted.
+func (ps *PropertyStore) ZoneMaps(col string) ([]Zone, error) {
+	if _, ok := ps.columns[col]; !ok {
+		return nil, ErrColumnNotFound
+	}
+
+	var zones []Zone
+	for start := 0; start < ps.rowCount; start += segmentRows {
+		zone := Zone{Start: start, End: min(start+segmentRows, ps.rowCount)}
+		for i := zone.Start; i < zone.End; i++ {
+			v, isNull := ps.value(col, i)
+			switch {
+			case ps.mut.isDeleted(i):
+			case isNull:
+				zone.Nulls++
+			case zone.Min == nil:
+				zone.Min, zone.Max = v, v
+			case compareValues(v, zone.Min) < 0:
+				zone.Min = v
+			case compareValues(v, zone.Max) > 0:
+				zone.Max = v
+			}
+		}
+		zones = append(zones, zone)
+	}
+	return zones, nil
+}
+
+// FilterRange returns the rows whose col value lies in [lo, hi], skipping
+// segments the zone maps rule out
+func (ps *PropertyStore) FilterRange(col string, lo, hi any) ([]int, error) {
+	zones, err := ps.ZoneMaps(col)
+	if err != nil {
+		return nil, err
+	}
+	if lo, err = normalizeBound(ps.columns[col], lo); err != nil {
+		return nil, err
+	}
+	if hi, err = normalizeBound(ps.columns[col], hi); err != nil {
+		return nil, err
+	}
+
+	var rows []int
+	for _, zone := range zones {
+		if zone.Min == nil || compareValues(zone.Max, lo) < 0 || compareValues(zone.Min, hi) > 0 {
+			continue
+		}
+		for i := zone.Start; i < zone.End; i++ {
+			v, isNull := ps.value(col, i)
+			if !isNull && !ps.mut.isDeleted(i) && compareValues(v, lo) >= 0 && compareValues(v, hi) <= 0 {
+				rows = append(rows, i)
+			}
+		}
+	}
+	return rows, nil
+}
+
+// normalizeBound converts a range bound to the type the column stores, so
+// it compares against the column's values
+func normalizeBound(c Column, bound any) (any, error) {
+	switch c.(type) {
+	case *IntColumn:
+		if v, ok := toInt64(bound); ok {
+			return v, nil
+		}
+	case *FloatColumn:
+		switch v := bound.(type) {
+		case float32:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case *StringColumn:
+		if v, ok := bound.(string); ok {
+			return v, nil
+		}
+	}
+	return nil, ErrTypeMismatch
+}
+
+// Run is a run of equal consecutive values
+type Run struct {
+	Value  any
+	Length int
+}
+
+// Runs returns the run-length encoding of col. Sorting by a column turns
+// every distinct value into a single run.
+func (ps *PropertyStore) Runs(col string) ([]Run, error) {
+	if _, ok := ps.columns[col]; !ok {
+		return nil, ErrColumnNotFound
+	}
+
+	var runs []Run
+	for _, v := range ps.Scan(col) {
+		if n := len(runs); n > 0 && compareValues(runs[n-1].Value, v) == 0 {
+			runs[n-1].Length++
+			continue
+		}
+		runs = append(runs, Run{Value: v, Length: 1})
+	}
+	return runs, nil
+}
@@ -12,6 +12,8 @@ var (
 	ErrColumnNotFound = errors.New("column not found")
 	ErrTypeMismatch   = errors.New("type mismatch")
 	ErrInvalidRow     = errors.New("invalid row index")
+	ErrColumnExists   = errors.New("column already exists")
+	ErrValueRange     = errors.New("value out of range for column bit width")
 )
 
 // Column interface for different column types
@@ -37,23 +39,44 @@ func NewBitmap(size int) *Bitmap {
 	}
 }
 
+// Set sets the bit at pos, growing the bitmap if needed
 func (b *Bitmap) Set(pos int) {
-	// TODO: Implement set bit
+	b.grow(pos + 1)
+	b.bits[pos/8] |= 1 << (pos % 8)
 }
 
+// Clear clears the bit at pos
 func (b *Bitmap) Clear(pos int) {
-	// TODO: Implement clear bit
+	if pos < b.size {
+		b.bits[pos/8] &^= 1 << (pos % 8)
+	}
 }
 
+// Test reports whether the bit at pos is set. Bits past the end are unset.
 func (b *Bitmap) Test(pos int) bool {
-	// TODO: Implement test bit
-	return false
+	if pos < 0 || pos >= b.size {
+		return false
+	}
+	return b.bits[pos/8]&(1<<(pos%8)) != 0
 }
 
+// CountOnes returns the number of set bits
 func (b *Bitmap) CountOnes() int {
-	// TODO: Implement popcount
-	// Use bits.OnesCount8
-	return 0
+	count := 0
+	for _, word := range b.bits {
+		count += bits.OnesCount8(word)
+	}
+	return count
+}
+
+func (b *Bitmap) grow(size int) {
+	if size <= b.size {
+		return
+	}
+	for len(b.bits) < (size+7)/8 {
+		b.bits = append(b.bits, 0)
+	}
+	b.size = size
 }
 
 // IntColumn stores integers with bit packing
@@ -76,23 +99,94 @@ func NewIntColumn(bitWidth int, minValue int64) *IntColumn {
 }
 
 func (c *IntColumn) Append(value any) error {
-	// TODO: Implement integer append with bit packing
+	if err := c.check(value); err != nil {
+		return err
+	}
+	c.grow(c.rowCount + 1)
+	if value == nil {
+		c.nulls.Set(c.rowCount)
+	} else {
+		v, _ := toInt64(value)
+		c.packValue(v, c.rowCount)
+	}
+	c.rowCount++
 	return nil
 }
 
+// check reports whether value can be appended to the column
+func (c *IntColumn) check(value any) error {
+	if value == nil {
+		return nil
+	}
+	v, ok := toInt64(value)
+	if !ok {
+		return ErrTypeMismatch
+	}
+	if v < c.minValue || (c.bitWidth < 64 && uint64(v-c.minValue) >= 1<<c.bitWidth) {
+		return ErrValueRange
+	}
+	return nil
+}
+
+func toInt64(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// grow extends the packed value buffer to hold rows values
+func (c *IntColumn) grow(rows int) {
+	for len(c.values) < (rows*c.bitWidth+7)/8 {
+		c.values = append(c.values, 0)
+	}
+}
+
+// packValue stores value - minValue in bitWidth bits at index
+func (c *IntColumn) packValue(value int64, index int) {
+	normalized := uint64(value - c.minValue)
+	bitOffset := index * c.bitWidth
+	for i := 0; i < c.bitWidth; i++ {
+		pos := bitOffset + i
+		if normalized&(1<<i) != 0 {
+			c.values[pos/8] |= 1 << (pos % 8)
+		} else {
+			c.values[pos/8] &^= 1 << (pos % 8)
+		}
+	}
+}
+
+// unpackValue reads the value stored at index
+func (c *IntColumn) unpackValue(index int) int64 {
+	bitOffset := index * c.bitWidth
+	var value uint64
+	for i := 0; i < c.bitWidth; i++ {
+		pos := bitOffset + i
+		if c.values[pos/8]&(1<<(pos%8)) != 0 {
+			value |= 1 << i
+		}
+	}
+	return int64(value) + c.minValue
+}
+
 func (c *IntColumn) Get(index int) (any, bool) {
-	// TODO: Implement get with unpacking
-	return nil, false
+	if index < 0 || index >= c.rowCount || c.nulls.Test(index) {
+		return nil, true
+	}
+	return c.unpackValue(index), false
 }
 
 func (c *IntColumn) Scan() iter.Seq2[int, any] {
-	// TODO: Implement scan iterator
-	return nil
+	return scanColumn(c)
 }
 
 func (c *IntColumn) MemoryUsage() int64 {
-	// TODO: Calculate memory usage
-	return 0
+	return int64(len(c.values) + len(c.nulls.bits))
 }
 
 func (c *IntColumn) RowCount() int {
@@ -119,24 +213,59 @@ func NewStringColumn() *StringColumn {
 }
 
 func (c *StringColumn) Append(value any) error {
-	// TODO: Implement string append with dictionary encoding
-	// Use unique.Make for string interning
+	if err := c.check(value); err != nil {
+		return err
+	}
+	if value == nil {
+		c.nulls.Set(c.rowCount)
+		c.indices = append(c.indices, 0)
+		c.rowCount++
+		return nil
+	}
+
+	c.indices = append(c.indices, c.intern(value.(string)))
+	c.rowCount++
 	return nil
 }
 
+// check reports whether value can be appended to the column
+func (c *StringColumn) check(value any) error {
+	if _, ok := value.(string); value != nil && !ok {
+		return ErrTypeMismatch
+	}
+	return nil
+}
+
+// intern returns the dictionary index of str, adding it if needed
+func (c *StringColumn) intern(str string) uint32 {
+	handle := unique.Make(str)
+	if idx, found := c.dictMap[handle]; found {
+		return idx
+	}
+	idx := uint32(len(c.dict))
+	c.dict = append(c.dict, handle)
+	c.dictMap[handle] = idx
+	return idx
+}
+
 func (c *StringColumn) Get(index int) (any, bool) {
-	// TODO: Implement get from dictionary
-	return nil, false
+	if index < 0 || index >= c.rowCount || c.nulls.Test(index) {
+		return nil, true
+	}
+	return c.dict[c.indices[index]].Value(), false
 }
 
 func (c *StringColumn) Scan() iter.Seq2[int, any] {
-	// TODO: Implement scan iterator
-	return nil
+	return scanColumn(c)
 }
 
 func (c *StringColumn) MemoryUsage() int64 {
-	// TODO: Calculate memory usage
-	return 0
+	// Each dictionary entry is a handle plus the interned string itself
+	usage := int64(len(c.indices)*4 + len(c.nulls.bits))
+	for _, h := range c.dict {
+		usage += 8 + int64(len(h.Value()))
+	}
+	return usage
 }
 
 func (c *StringColumn) RowCount() int {
@@ -144,7 +273,6 @@ func (c *StringColumn) RowCount() int {
 }
 
 func (c *StringColumn) DistinctCount() int {
-	// TODO: Return dictionary size
 	return len(c.dict)
 }
 
@@ -164,18 +292,40 @@ func NewFloatColumn() *FloatColumn {
 }
 
 func (c *FloatColumn) Append(value any) error {
-	// TODO: Implement float append
+	if err := c.check(value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case nil:
+		c.nulls.Set(c.rowCount)
+		c.values = append(c.values, 0)
+	case float32:
+		c.values = append(c.values, float64(v))
+	case float64:
+		c.values = append(c.values, v)
+	}
+	c.rowCount++
 	return nil
 }
 
+// check reports whether value can be appended to the column
+func (c *FloatColumn) check(value any) error {
+	switch value.(type) {
+	case nil, float32, float64:
+		return nil
+	}
+	return ErrTypeMismatch
+}
+
 func (c *FloatColumn) Get(index int) (any, bool) {
-	// TODO: Implement get
-	return nil, false
+	if index < 0 || index >= c.rowCount || c.nulls.Test(index) {
+		return nil, true
+	}
+	return c.values[index], false
 }
 
 func (c *FloatColumn) Scan() iter.Seq2[int, any] {
-	// TODO: Implement scan iterator
-	return nil
+	return scanColumn(c)
 }
 
 func (c *FloatColumn) MemoryUsage() int64 {
@@ -186,10 +336,31 @@ func (c *FloatColumn) RowCount() int {
 	return c.rowCount
 }
 
+// scanColumn iterates a column by index, yielding nil for NULLs
+func scanColumn(c Column) iter.Seq2[int, any] {
+	return func(yield func(int, any) bool) {
+		for i := range c.RowCount() {
+			v, _ := c.Get(i)
+			if !yield(i, v) {
+				return
+			}
+		}
+	}
+}
+
+// valueChecker is implemented by columns that can validate a value before
+// it is appended, so a row is never partially appended
+type valueChecker interface {
+	check(value any) error
+}
+
 // PropertyStore stores columns for entities
 type PropertyStore struct {
 	columns  map[string]Column
+	names    []string
 	rowCount int
+	cluster  clustering
+	mut      mutations
 }
 
 // NewPropertyStore creates a new property store
@@ -199,45 +370,113 @@ func NewPropertyStore() *PropertyStore {
 	}
 }
 
-// AddColumn adds a column to the store
+// AddColumn adds a column to the store. Existing rows are NULL in a column
+// added after them.
 func (ps *PropertyStore) AddColumn(name string, col Column) error {
-	// TODO: Implement column addition
+	if _, exists := ps.columns[name]; exists {
+		return ErrColumnExists
+	}
+	for col.RowCount() < ps.rowCount {
+		if err := col.Append(nil); err != nil {
+			return err
+		}
+	}
+	ps.columns[name] = col
+	ps.names = append(ps.names, name)
 	return nil
 }
 
 // AppendRow appends a row with values for each column
 func (ps *PropertyStore) AppendRow(values map[string]any) error {
-	// TODO: Implement row append
-	// Append to each column (use nil for missing values)
+	for name, v := range values {
+		col, ok := ps.columns[name]
+		if !ok {
+			return ErrColumnNotFound
+		}
+		if vc, ok := col.(valueChecker); ok {
+			if err := vc.check(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, name := range ps.names {
+		if err := ps.columns[name].Append(values[name]); err != nil {
+			return err
+		}
+	}
+	ps.rowCount++
+	ps.cluster.appended(ps)
 	return nil
 }
 
-// Get retrieves a value at a specific row and column
+// Get retrieves a value at a specific row and column. The bool reports
+// whether the value is NULL.
 func (ps *PropertyStore) Get(row int, col string) (any, bool, error) {
-	// TODO: Implement get
-	return nil, false, nil
+	if _, ok := ps.columns[col]; !ok {
+		return nil, false, ErrColumnNotFound
+	}
+	if row < 0 || row >= ps.rowCount {
+		return nil, false, ErrInvalidRow
+	}
+	if ps.mut.isDeleted(row) {
+		return nil, false, ErrRowDeleted
+	}
+	v, isNull := ps.value(col, row)
+	return v, isNull, nil
 }
 
-// Scan returns an iterator over a column's values
+// Scan returns an iterator over a column's values, skipping deleted rows.
+// An unknown column yields nothing.
 func (ps *PropertyStore) Scan(col string) iter.Seq2[int, any] {
-	// TODO: Implement scan
-	return nil
+	c, ok := ps.columns[col]
+	if !ok {
+		return func(func(int, any) bool) {}
+	}
+	if !ps.mut.dirty() {
+		return c.Scan()
+	}
+	return func(yield func(int, any) bool) {
+		for row := range ps.rowCount {
+			if ps.mut.isDeleted(row) {
+				continue
+			}
+			if v, _ := ps.value(col, row); !yield(row, v) {
+				return
+			}
+		}
+	}
 }
 
-// Filter returns row indices matching the predicate
+// Filter returns the indices of live rows matching the predicate
 func (ps *PropertyStore) Filter(pred func(map[string]any) bool) []int {
-	// TODO: Implement filter
-	// For each row, build map of values and test predicate
-	return nil
+	var matches []int
+	row := make(map[string]any, len(ps.names))
+	for i := range ps.rowCount {
+		if ps.mut.isDeleted(i) {
+			continue
+		}
+		for _, name := range ps.names {
+			row[name], _ = ps.value(name, i)
+		}
+		if pred(row) {
+			matches = append(matches, i)
+		}
+	}
+	return matches
 }
 
 // MemoryUsage returns total memory usage in bytes
 func (ps *PropertyStore) MemoryUsage() int64 {
-	// TODO: Sum memory usage of all columns
-	return 0
+	var total int64
+	for _, col := range ps.columns {
+		total += col.MemoryUsage()
+	}
+	return total + ps.mut.memoryUsage()
 }
 
-// RowCount returns the number of rows
+// RowCount returns the number of rows, including deleted rows until
+// Compact removes them
 func (ps *PropertyStore) RowCount() int {
 	return ps.rowCount
 }
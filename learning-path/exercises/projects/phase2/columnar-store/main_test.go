@@ -1,6 +1,10 @@
 package columnarstore
 
-import "testing"
+import (
+	"errors"
+	"slices"
+	"testing"
+)
 
 func TestBitmap(t *testing.T) {
 	// TODO: Test bitmap operations
@@ -34,6 +38,382 @@ func TestMemoryUsage(t *testing.T) {
 	t.Skip("not implemented")
 }
 
+func newSortTestStore(t *testing.T) *PropertyStore {
+	t.Helper()
+	ps := NewPropertyStore()
+	for name, col := range map[string]Column{
+		"name":  NewStringColumn(),
+		"age":   NewIntColumn(8, 0),
+		"score": NewFloatColumn(),
+	} {
+		if err := ps.AddColumn(name, col); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ps
+}
+
+func TestSortBy(t *testing.T) {
+	ps := newSortTestStore(t)
+	rows := []map[string]any{
+		{"name": "carol", "age": 30, "score": 1.0},
+		{"name": "alice", "age": 40, "score": 2.0},
+		{"name": "bob", "age": 25, "score": 3.0},
+		{"name": "alice", "age": 20, "score": 4.0},
+		{"age": 50, "score": 5.0},
+	}
+	for _, row := range rows {
+		if err := ps.AppendRow(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := ps.SortBy("name", "age"); err != nil {
+		t.Fatal(err)
+	}
+
+	// NULL names first, then by name, ties broken by age; score moves along
+	wantScores := []float64{5, 4, 2, 3, 1}
+	for i, want := range wantScores {
+		v, isNull, err := ps.Get(i, "score")
+		if err != nil || isNull || v.(float64) != want {
+			t.Errorf("row %d score = %v (null %v, err %v), want %v", i, v, isNull, err, want)
+		}
+	}
+	if v, _, _ := ps.Get(1, "age"); v.(int64) != 20 {
+		t.Errorf("row 1 age = %v, want 20", v)
+	}
+
+	cols, sorted := ps.ClusteringKey()
+	if !slices.Equal(cols, []string{"name", "age"}) || !sorted {
+		t.Errorf("ClusteringKey() = %v, %v", cols, sorted)
+	}
+
+	// Appending in key order keeps the clustering; out of order clears it
+	if err := ps.AppendRow(map[string]any{"name": "dave", "age": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, sorted := ps.ClusteringKey(); !sorted {
+		t.Error("in-order append cleared clustering")
+	}
+	if err := ps.AppendRow(map[string]any{"name": "aaron"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, sorted := ps.ClusteringKey(); sorted {
+		t.Error("out-of-order append kept clustering")
+	}
+
+	if err := ps.SortBy("missing"); err != ErrColumnNotFound {
+		t.Errorf("SortBy(missing) = %v, want ErrColumnNotFound", err)
+	}
+}
+
+func TestSortImprovesZoneMapsAndRuns(t *testing.T) {
+	ps := newSortTestStore(t)
+	const rows = 8 * segmentRows
+	for i := range rows {
+		ps.AppendRow(map[string]any{
+			"name": []string{"a", "b", "c", "d"}[i%4],
+			"age":  (i * 37) % 200,
+		})
+	}
+
+	scanned := func() int {
+		zones, err := ps.ZoneMaps("age")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for _, z := range zones {
+			if z.Min.(int64) <= 10 && z.Max.(int64) >= 10 {
+				n++
+			}
+		}
+		return n
+	}
+
+	before, _ := ps.FilterRange("age", int64(10), int64(10))
+	if n := scanned(); n != rows/segmentRows {
+		t.Errorf("unsorted: %d segments may match, want all %d", n, rows/segmentRows)
+	}
+	if runs, _ := ps.Runs("name"); len(runs) != rows {
+		t.Errorf("unsorted: %d runs, want %d", len(runs), rows)
+	}
+
+	if err := ps.SortBy("age"); err != nil {
+		t.Fatal(err)
+	}
+	if n := scanned(); n != 1 {
+		t.Errorf("sorted: %d segments may match, want 1", n)
+	}
+	after, _ := ps.FilterRange("age", int64(10), int64(10))
+	if len(after) != len(before) || len(after) == 0 {
+		t.Errorf("FilterRange matched %d rows after sort, %d before", len(after), len(before))
+	}
+
+	if err := ps.SortBy("name"); err != nil {
+		t.Fatal(err)
+	}
+	if runs, _ := ps.Runs("name"); len(runs) != 4 {
+		t.Errorf("sorted: %d runs, want 4", len(runs))
+	}
+}
+
+func TestFilterRangeBoundTypes(t *testing.T) {
+	ps := newSortTestStore(t)
+	for i, name := range []string{"a", "b", "c", "d"} {
+		if err := ps.AppendRow(map[string]any{"name": name, "age": i * 10, "score": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, err := ps.FilterRange("age", 1, 20); err != nil || !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("FilterRange(int bounds) = %v, %v; want [1 2]", got, err)
+	}
+	if got, err := ps.FilterRange("age", int32(0), int32(0)); err != nil || !slices.Equal(got, []int{0}) {
+		t.Errorf("FilterRange(int32 bounds) = %v, %v; want [0]", got, err)
+	}
+	if got, err := ps.FilterRange("score", float32(1), float32(2)); err != nil || !slices.Equal(got, []int{1, 2}) {
+		t.Errorf("FilterRange(float32 bounds) = %v, %v; want [1 2]", got, err)
+	}
+	for _, bounds := range [][2]any{{"a", "z"}, {1.5, 2}, {nil, 10}} {
+		if _, err := ps.FilterRange("age", bounds[0], bounds[1]); !errors.Is(err, ErrTypeMismatch) {
+			t.Errorf("FilterRange(%v, %v) error = %v, want ErrTypeMismatch", bounds[0], bounds[1], err)
+		}
+	}
+}
+
+func TestSaveOpenKeepsClusteringKey(t *testing.T) {
+	ps := newSortTestStore(t)
+	for i, name := range []string{"d", "b", "c", "a"} {
+		if err := ps.AppendRow(map[string]any{"name": name, "age": i % 2, "score": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.AppendRow(map[string]any{"name": nil, "age": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ps.SortBy("age", "name"); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := ps.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cols, sorted := reopened.ClusteringKey(); !slices.Equal(cols, []string{"age", "name"}) || !sorted {
+		t.Errorf("ClusteringKey after reopen = %v, %v; want [age name], true", cols, sorted)
+	}
+	for _, col := range []string{"name", "age", "score"} {
+		for row := range ps.RowCount() {
+			want, wantNull, _ := ps.Get(row, col)
+			got, gotNull, err := reopened.Get(row, col)
+			if err != nil || got != want || gotNull != wantNull {
+				t.Errorf("row %d %s = %v (null %v, %v), want %v", row, col, got, gotNull, err, want)
+			}
+		}
+	}
+
+	// The reopened store keeps tracking sortedness, and saves it too
+	if err := reopened.AppendRow(map[string]any{"name": "z", "age": 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reopened.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	if again, err := Open(dir); err != nil {
+		t.Fatal(err)
+	} else if cols, sorted := again.ClusteringKey(); len(cols) != 2 || sorted {
+		t.Errorf("ClusteringKey after an out-of-order append = %v, %v; want unsorted", cols, sorted)
+	}
+}
+
+func TestUpdateDeleteCompact(t *testing.T) {
+	ps := newSortTestStore(t)
+	for i, name := range []string{"a", "b", "c", "d", "e"} {
+		if err := ps.AppendRow(map[string]any{"name": name, "age": i * 10, "score": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ps.SortBy("age"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ps.UpdateRow(1, map[string]any{"age": 99, "name": nil}); err != nil {
+		t.Fatal(err)
+	}
+	if v, _, _ := ps.Get(1, "age"); v != int64(99) {
+		t.Errorf("updated age = %v, want 99", v)
+	}
+	if _, isNull, _ := ps.Get(1, "name"); !isNull {
+		t.Error("name updated to NULL is not NULL")
+	}
+	if _, sorted := ps.ClusteringKey(); sorted {
+		t.Error("updating the clustering key kept the rows marked sorted")
+	}
+	if err := ps.UpdateRow(2, map[string]any{"age": 1000}); err != ErrValueRange {
+		t.Errorf("out-of-range update: err = %v, want ErrValueRange", err)
+	}
+	if v, _, _ := ps.Get(2, "age"); v != int64(20) {
+		t.Errorf("failed update changed age to %v", v)
+	}
+
+	matches := ps.Filter(func(row map[string]any) bool { return row["age"].(int64) >= 30 })
+	if !slices.Equal(matches, []int{1, 3, 4}) {
+		t.Errorf("Filter sees updates: got %v, want [1 3 4]", matches)
+	}
+	if n, err := ps.DeleteRows([]int{0, 3, 3}); err != nil || n != 2 {
+		t.Fatalf("DeleteRows = %d, %v; want 2", n, err)
+	}
+	if _, err := ps.DeleteRows([]int{5}); err != ErrInvalidRow {
+		t.Errorf("DeleteRows past the end: err = %v", err)
+	}
+	if _, _, err := ps.Get(3, "age"); err != ErrRowDeleted {
+		t.Errorf("Get of a deleted row: err = %v, want ErrRowDeleted", err)
+	}
+	if err := ps.UpdateRow(0, map[string]any{"age": 1}); err != ErrRowDeleted {
+		t.Errorf("UpdateRow of a deleted row: err = %v, want ErrRowDeleted", err)
+	}
+
+	var rows []int
+	var ages []int64
+	for row, v := range ps.Scan("age") {
+		rows = append(rows, row)
+		ages = append(ages, v.(int64))
+	}
+	if !slices.Equal(rows, []int{1, 2, 4}) || !slices.Equal(ages, []int64{99, 20, 40}) {
+		t.Errorf("Scan = rows %v ages %v, want [1 2 4] [99 20 40]", rows, ages)
+	}
+	if got, _ := ps.FilterRange("age", int64(0), int64(30)); !slices.Equal(got, []int{2}) {
+		t.Errorf("FilterRange = %v, want [2]", got)
+	}
+
+	removed, err := ps.Compact()
+	if err != nil || removed != 2 {
+		t.Fatalf("Compact = %d, %v; want 2", removed, err)
+	}
+	if ps.RowCount() != 3 || ps.DeletedCount() != 0 {
+		t.Errorf("after Compact: %d rows, %d deleted", ps.RowCount(), ps.DeletedCount())
+	}
+	wantNames := []any{nil, "c", "e"}
+	wantAges := []int64{99, 20, 40}
+	for i := range 3 {
+		name, _, _ := ps.Get(i, "name")
+		age, _, _ := ps.Get(i, "age")
+		if name != wantNames[i] || age != wantAges[i] {
+			t.Errorf("row %d = %v/%v, want %v/%v", i, name, age, wantNames[i], wantAges[i])
+		}
+	}
+	if c := ps.columns["name"].(*StringColumn); c.DistinctCount() != 2 {
+		t.Errorf("compacted dictionary has %d entries, want 2", c.DistinctCount())
+	}
+}
+
+// newAggregateTestStore fills a store with rows rows over many segments:
+// age is row%50 or NULL every 7th row, and name cycles through 3 values
+func newAggregateTestStore(tb testing.TB, rows int) *PropertyStore {
+	tb.Helper()
+	ps := NewPropertyStore()
+	ps.AddColumn("name", NewStringColumn())
+	ps.AddColumn("age", NewIntColumn(8, 0))
+	ps.AddColumn("score", NewFloatColumn())
+	names := []string{"a", "b", "c"}
+	for i := range rows {
+		row := map[string]any{"name": names[i%3], "score": float64(i) / 4}
+		if i%7 != 0 {
+			row["age"] = i % 50
+		}
+		if err := ps.AppendRow(row); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return ps
+}
+
+func TestParallelAggregate(t *testing.T) {
+	ps := newAggregateTestStore(t, 10*segmentRows+17)
+	ps.DeleteRows([]int{1, 2, 5000})
+	ps.UpdateRow(3, map[string]any{"age": 200, "name": nil})
+
+	for _, col := range []string{"age", "score", "name"} {
+		want, err := ps.Aggregate(col)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, workers := range []int{0, 1, 3, 64} {
+			if got, _ := ps.AggregateParallel(col, workers); got != want {
+				t.Errorf("AggregateParallel(%s, %d) = %+v, want %+v", col, workers, got, want)
+			}
+		}
+	}
+	age, _ := ps.Aggregate("age")
+	if age.Max != int64(200) || age.Min != int64(0) || age.Count+age.Nulls != ps.RowCount()-3 {
+		t.Errorf("Aggregate(age) = %+v", age)
+	}
+
+	want, _ := ps.GroupAggregate("name", "age")
+	got, err := ps.GroupAggregateParallel("name", "age", 4)
+	if err != nil || len(got) != 4 || got[nil].Max != int64(200) {
+		t.Fatalf("GroupAggregateParallel = %v, %v", got, err)
+	}
+	for key, agg := range want {
+		if got[key] != agg {
+			t.Errorf("group %v = %+v, want %+v", key, got[key], agg)
+		}
+	}
+
+	// Every live row is visited once
+	seen := make([]int, ps.RowCount())
+	counts := make([]int, 8)
+	ps.ScanParallel("age", 8, func(worker, row int, v any) {
+		seen[row]++
+		counts[worker]++
+	})
+	for row, n := range seen {
+		if want := 1; ps.mut.isDeleted(row) {
+			want = 0
+			if n != want {
+				t.Errorf("deleted row %d visited %d times", row, n)
+			}
+		} else if n != want {
+			t.Errorf("row %d visited %d times", row, n)
+		}
+	}
+	if _, err := ps.AggregateParallel("zip", 2); err != ErrColumnNotFound {
+		t.Errorf("unknown column: err = %v", err)
+	}
+}
+
+// BenchmarkAggregate compares the serial aggregate with the parallel one
+// over a million rows
+func BenchmarkAggregate(b *testing.B) {
+	ps := newAggregateTestStore(b, 1<<20)
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ps.Aggregate("age")
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ps.AggregateParallel("age", 0)
+		}
+	})
+	b.Run("group-serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ps.GroupAggregate("name", "score")
+		}
+	})
+	b.Run("group-parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ps.GroupAggregateParallel("name", "score", 0)
+		}
+	})
+}
+
 func BenchmarkStringAppend(b *testing.B) {
 	// TODO: Benchmark string append with interning
 	b.Skip("not implemented")
@@ -0,0 +1,201 @@
+package columnarstore
+
+import (
+	"errors"
+	"iter"
+)
+
+// Errors
+var (
+	ErrRowDeleted = errors.New("row has been deleted")
+	// ErrUncompactableColumn is returned by Compact for a column type that
+	// cannot be rewritten
+	ErrUncompactableColumn = errors.New("column does not support compaction")
+)
+
+// compactor is implemented by columns that can be rebuilt in place from a
+// new sequence of values, which Compact uses to drop dead rows
+type compactor interface {
+	compact(values iter.Seq[any]) error
+}
+
+// mutations tracks changes to rows since the last Compact. Columns stay
+// append-only: a deleted row is masked out by the deletion bitmap and an
+// updated value lives in the column's delta until Compact folds it in.
+type mutations struct {
+	deleted *Bitmap
+	ndel    int
+	deltas  map[string]map[int]any // column -> row -> value, nil for NULL
+}
+
+func (m *mutations) isDeleted(row int) bool {
+	return m.deleted != nil && m.deleted.Test(row)
+}
+
+func (m *mutations) dirty() bool {
+	return m.ndel > 0 || len(m.deltas) > 0
+}
+
+// value returns the current value of row in col, looking in the column's
+// delta before the column itself
+func (ps *PropertyStore) value(col string, row int) (any, bool) {
+	if delta, ok := ps.mut.deltas[col][row]; ok {
+		return delta, delta == nil
+	}
+	return ps.columns[col].Get(row)
+}
+
+// DeleteRows deletes the given rows, for example the result of Filter,
+// and returns how many were live. Row indices stay valid until Compact.
+func (ps *PropertyStore) DeleteRows(rows []int) (int, error) {
+	for _, row := range rows {
+		if row < 0 || row >= ps.rowCount {
+			return 0, ErrInvalidRow
+		}
+	}
+	if ps.mut.deleted == nil {
+		ps.mut.deleted = NewBitmap(0)
+	}
+	deleted := 0
+	for _, row := range rows {
+		if ps.mut.deleted.Test(row) {
+			continue
+		}
+		ps.mut.deleted.Set(row)
+		for _, delta := range ps.mut.deltas {
+			delete(delta, row)
+		}
+		deleted++
+	}
+	ps.mut.ndel += deleted
+	return deleted, nil
+}
+
+// UpdateRow sets the given columns of row. A nil value sets NULL. The row
+// is updated entirely or, on error, not at all.
+func (ps *PropertyStore) UpdateRow(row int, values map[string]any) error {
+	if row < 0 || row >= ps.rowCount {
+		return ErrInvalidRow
+	}
+	if ps.mut.isDeleted(row) {
+		return ErrRowDeleted
+	}
+	for name, v := range values {
+		col, ok := ps.columns[name]
+		if !ok {
+			return ErrColumnNotFound
+		}
+		if vc, ok := col.(valueChecker); ok {
+			if err := vc.check(v); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ps.mut.deltas == nil {
+		ps.mut.deltas = make(map[string]map[int]any)
+	}
+	for name, v := range values {
+		delta := ps.mut.deltas[name]
+		if delta == nil {
+			delta = make(map[int]any)
+			ps.mut.deltas[name] = delta
+		}
+		// Store values as the column returns them
+		if iv, ok := toInt64(v); ok {
+			v = iv
+		} else if fv, ok := v.(float32); ok {
+			v = float64(fv)
+		}
+		delta[row] = v
+	}
+	ps.cluster.updated(values)
+	return nil
+}
+
+// DeletedCount returns the number of deleted rows not yet compacted away
+func (ps *PropertyStore) DeletedCount() int {
+	return ps.mut.ndel
+}
+
+// Compact rewrites every column with the updates applied and the deleted
+// rows removed, and returns the number of rows removed. Live rows keep
+// their order but are renumbered.
+func (ps *PropertyStore) Compact() (int, error) {
+	if !ps.mut.dirty() {
+		return 0, nil
+	}
+	for _, name := range ps.names {
+		if _, ok := ps.columns[name].(compactor); !ok {
+			return 0, ErrUncompactableColumn
+		}
+	}
+
+	for _, name := range ps.names {
+		values := func(yield func(any) bool) {
+			for row := range ps.rowCount {
+				if ps.mut.isDeleted(row) {
+					continue
+				}
+				if v, _ := ps.value(name, row); !yield(v) {
+					return
+				}
+			}
+		}
+		if err := ps.columns[name].(compactor).compact(values); err != nil {
+			return 0, err
+		}
+	}
+
+	removed := ps.mut.ndel
+	ps.rowCount -= removed
+	ps.mut = mutations{}
+	return removed, nil
+}
+
+func (c *IntColumn) compact(values iter.Seq[any]) error {
+	fresh := NewIntColumn(c.bitWidth, c.minValue)
+	for v := range values {
+		if err := fresh.Append(v); err != nil {
+			return err
+		}
+	}
+	*c = *fresh
+	return nil
+}
+
+// compact also drops dictionary entries no remaining row uses
+func (c *StringColumn) compact(values iter.Seq[any]) error {
+	fresh := NewStringColumn()
+	for v := range values {
+		if err := fresh.Append(v); err != nil {
+			return err
+		}
+	}
+	*c = *fresh
+	return nil
+}
+
+func (c *FloatColumn) compact(values iter.Seq[any]) error {
+	fresh := NewFloatColumn()
+	for v := range values {
+		if err := fresh.Append(v); err != nil {
+			return err
+		}
+	}
+	*c = *fresh
+	return nil
+}
+
+// memoryUsage estimates the memory held by the deletion bitmap and
+// delta columns: a key and an interface value per delta entry
+func (m *mutations) memoryUsage() int64 {
+	var usage int64
+	if m.deleted != nil {
+		usage += int64(len(m.deleted.bits))
+	}
+	for _, delta := range m.deltas {
+		usage += int64(len(delta)) * 24
+	}
+	return usage
+}
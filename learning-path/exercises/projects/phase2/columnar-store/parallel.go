@@ -0,0 +1,197 @@
+package columnarstore
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Aggregate holds the aggregates of a column over its live rows
+type Aggregate struct {
+	Count int     // non-NULL values
+	Nulls int     // NULL values
+	Sum   float64 // sum of the values of an int or float column
+	Min   any     // nil if there are no non-NULL values
+	Max   any
+}
+
+// Avg returns the mean of the values of an int or float column, or 0 if
+// there are none
+func (a Aggregate) Avg() float64 {
+	if a.Count == 0 {
+		return 0
+	}
+	return a.Sum / float64(a.Count)
+}
+
+// add folds one value into the aggregate
+func (a *Aggregate) add(v any, isNull bool) {
+	if isNull {
+		a.Nulls++
+		return
+	}
+	a.Count++
+	switch n := v.(type) {
+	case int64:
+		a.Sum += float64(n)
+	case float64:
+		a.Sum += n
+	}
+	if a.Min == nil || compareValues(v, a.Min) < 0 {
+		a.Min = v
+	}
+	if a.Max == nil || compareValues(v, a.Max) > 0 {
+		a.Max = v
+	}
+}
+
+// merge folds a partial aggregate of other rows into the aggregate
+func (a *Aggregate) merge(b Aggregate) {
+	a.Count += b.Count
+	a.Nulls += b.Nulls
+	a.Sum += b.Sum
+	if b.Min != nil && (a.Min == nil || compareValues(b.Min, a.Min) < 0) {
+		a.Min = b.Min
+	}
+	if b.Max != nil && (a.Max == nil || compareValues(b.Max, a.Max) > 0) {
+		a.Max = b.Max
+	}
+}
+
+// forEachSegment calls fn for each segment of segmentRows rows, spread over
+// workers goroutines (GOMAXPROCS if workers <= 0). Goroutines take the next
+// segment as they finish one, so a slow segment does not hold up the rest.
+// worker is the index of the calling goroutine.
+func (ps *PropertyStore) forEachSegment(workers int, fn func(worker, start, end int)) int {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	segments := (ps.rowCount + segmentRows - 1) / segmentRows
+	workers = max(min(workers, segments), 1)
+
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				seg := int(next.Add(1) - 1)
+				if seg >= segments {
+					return
+				}
+				start := seg * segmentRows
+				fn(w, start, min(start+segmentRows, ps.rowCount))
+			}
+		}()
+	}
+	wg.Wait()
+	return workers
+}
+
+// ScanParallel calls fn for every live row of col, with the segments
+// spread over workers goroutines (GOMAXPROCS if workers <= 0). Rows of a
+// segment are visited in order by one goroutine, but fn is called
+// concurrently; worker, below the number of goroutines, lets fn keep
+// per-goroutine state without locking. Like every other method it must not
+// run concurrently with changes to the store.
+func (ps *PropertyStore) ScanParallel(col string, workers int, fn func(worker, row int, v any)) error {
+	if _, ok := ps.columns[col]; !ok {
+		return ErrColumnNotFound
+	}
+	ps.forEachSegment(workers, func(worker, start, end int) {
+		for row := start; row < end; row++ {
+			if ps.mut.isDeleted(row) {
+				continue
+			}
+			v, _ := ps.value(col, row)
+			fn(worker, row, v)
+		}
+	})
+	return nil
+}
+
+// Aggregate computes the aggregates of col in one pass on one goroutine
+func (ps *PropertyStore) Aggregate(col string) (Aggregate, error) {
+	if _, ok := ps.columns[col]; !ok {
+		return Aggregate{}, ErrColumnNotFound
+	}
+	var agg Aggregate
+	ps.aggregateRows(&agg, col, 0, ps.rowCount)
+	return agg, nil
+}
+
+// AggregateParallel computes the same aggregates as Aggregate with the
+// segments spread over workers goroutines. Each goroutine aggregates its
+// segments into a partial result of its own, and the partials are merged
+// once all are done, so the goroutines share nothing while they run.
+func (ps *PropertyStore) AggregateParallel(col string, workers int) (Aggregate, error) {
+	if _, ok := ps.columns[col]; !ok {
+		return Aggregate{}, ErrColumnNotFound
+	}
+	partials := make([]Aggregate, max(workers, runtime.GOMAXPROCS(0)))
+	n := ps.forEachSegment(workers, func(worker, start, end int) {
+		ps.aggregateRows(&partials[worker], col, start, end)
+	})
+	var agg Aggregate
+	for _, p := range partials[:n] {
+		agg.merge(p)
+	}
+	return agg, nil
+}
+
+func (ps *PropertyStore) aggregateRows(agg *Aggregate, col string, start, end int) {
+	for row := start; row < end; row++ {
+		if !ps.mut.isDeleted(row) {
+			agg.add(ps.value(col, row))
+		}
+	}
+}
+
+// GroupAggregate computes the aggregates of col for each distinct value of
+// groupCol, in one pass on one goroutine. Rows whose group value is NULL
+// are grouped under nil.
+func (ps *PropertyStore) GroupAggregate(groupCol, col string) (map[any]Aggregate, error) {
+	return ps.GroupAggregateParallel(groupCol, col, 1)
+}
+
+// GroupAggregateParallel computes the same groups as GroupAggregate with
+// the segments spread over workers goroutines, each filling a hash table
+// of partial aggregates of its own that are merged at the end
+func (ps *PropertyStore) GroupAggregateParallel(groupCol, col string, workers int) (map[any]Aggregate, error) {
+	for _, name := range []string{groupCol, col} {
+		if _, ok := ps.columns[name]; !ok {
+			return nil, ErrColumnNotFound
+		}
+	}
+	partials := make([]map[any]*Aggregate, max(workers, runtime.GOMAXPROCS(0)))
+	n := ps.forEachSegment(workers, func(worker, start, end int) {
+		groups := partials[worker]
+		if groups == nil {
+			groups = make(map[any]*Aggregate)
+			partials[worker] = groups
+		}
+		for row := start; row < end; row++ {
+			if ps.mut.isDeleted(row) {
+				continue
+			}
+			key, _ := ps.value(groupCol, row)
+			agg := groups[key]
+			if agg == nil {
+				agg = &Aggregate{}
+				groups[key] = agg
+			}
+			agg.add(ps.value(col, row))
+		}
+	})
+
+	result := make(map[any]Aggregate)
+	for _, groups := range partials[:n] {
+		for key, p := range groups {
+			agg := result[key]
+			agg.merge(*p)
+			result[key] = agg
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,163 @@
+package columnarstore
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrUnsavableColumn is returned by Save for a column type it cannot write
+var ErrUnsavableColumn = errors.New("column does not support saving")
+
+// metaFile is the name of the file describing a saved store
+const metaFile = "store.json"
+
+// storeMeta describes a saved store: its schema, row count and clustering
+// key. It is written after the column files, so a store is only visible
+// once all of its columns are.
+type storeMeta struct {
+	Rows       int          `json:"rows"`
+	Columns    []columnMeta `json:"columns"`
+	Clustering struct {
+		Keys   []string `json:"keys,omitempty"`
+		Sorted bool     `json:"sorted"`
+	} `json:"clustering"`
+}
+
+type columnMeta struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"` // "int", "string" or "float"
+	File     string `json:"file"`
+	BitWidth int    `json:"bit_width,omitempty"`
+	MinValue int64  `json:"min_value,omitempty"`
+}
+
+// columnData is the encoded contents of one column file. Only the fields
+// of the column's type are set.
+type columnData struct {
+	Nulls   []byte
+	Packed  []byte    // int
+	Floats  []float64 // float
+	Dict    []string  // string
+	Indices []uint32  // string
+}
+
+// Save writes the store to dir: a file per column and a store.json with
+// the schema and the clustering key, so ClusteringKey is the same after
+// Open. Pending deletes and updates are compacted first.
+func (ps *PropertyStore) Save(dir string) error {
+	if _, err := ps.Compact(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	meta := storeMeta{Rows: ps.rowCount}
+	meta.Clustering.Keys = ps.cluster.keys
+	meta.Clustering.Sorted = ps.cluster.sorted
+	for i, name := range ps.names {
+		cm := columnMeta{Name: name, File: fmt.Sprintf("col%03d.bin", i)}
+		var data columnData
+		switch c := ps.columns[name].(type) {
+		case *IntColumn:
+			cm.Type, cm.BitWidth, cm.MinValue = "int", c.bitWidth, c.minValue
+			data = columnData{Nulls: c.nulls.bits, Packed: c.values}
+		case *FloatColumn:
+			cm.Type = "float"
+			data = columnData{Nulls: c.nulls.bits, Floats: c.values}
+		case *StringColumn:
+			cm.Type = "string"
+			data = columnData{Nulls: c.nulls.bits, Indices: c.indices}
+			for _, h := range c.dict {
+				data.Dict = append(data.Dict, h.Value())
+			}
+		default:
+			return fmt.Errorf("%w: %s", ErrUnsavableColumn, name)
+		}
+		if err := writeFile(filepath.Join(dir, cm.File), func(f *os.File) error {
+			return gob.NewEncoder(f).Encode(&data)
+		}); err != nil {
+			return err
+		}
+		meta.Columns = append(meta.Columns, cm)
+	}
+
+	return writeFile(filepath.Join(dir, metaFile), func(f *os.File) error {
+		return json.NewEncoder(f).Encode(&meta)
+	})
+}
+
+// Open loads a store written by Save, including its clustering key
+func Open(dir string) (*PropertyStore, error) {
+	raw, err := os.ReadFile(filepath.Join(dir, metaFile))
+	if err != nil {
+		return nil, err
+	}
+	var meta storeMeta
+	if err := json.Unmarshal(raw, &meta); err != nil {
+		return nil, fmt.Errorf("%s: %w", metaFile, err)
+	}
+
+	ps := NewPropertyStore()
+	for _, cm := range meta.Columns {
+		f, err := os.Open(filepath.Join(dir, cm.File))
+		if err != nil {
+			return nil, err
+		}
+		var data columnData
+		err = gob.NewDecoder(f).Decode(&data)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", cm.Name, err)
+		}
+
+		nulls := &Bitmap{bits: data.Nulls, size: len(data.Nulls) * 8}
+		var col Column
+		switch cm.Type {
+		case "int":
+			col = &IntColumn{values: data.Packed, nulls: nulls, bitWidth: cm.BitWidth, minValue: cm.MinValue, rowCount: meta.Rows}
+		case "float":
+			col = &FloatColumn{values: data.Floats, nulls: nulls, rowCount: meta.Rows}
+		case "string":
+			c := NewStringColumn()
+			for _, s := range data.Dict {
+				c.intern(s)
+			}
+			c.indices, c.nulls, c.rowCount = data.Indices, nulls, meta.Rows
+			col = c
+		default:
+			return nil, fmt.Errorf("column %s: unknown type %q", cm.Name, cm.Type)
+		}
+		ps.columns[cm.Name] = col
+		ps.names = append(ps.names, cm.Name)
+	}
+	ps.rowCount = meta.Rows
+	ps.cluster = clustering{keys: meta.Clustering.Keys, sorted: meta.Clustering.Sorted}
+	return ps, nil
+}
+
+// writeFile writes path through a temporary file renamed into place
+func writeFile(path string, write func(f *os.File) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
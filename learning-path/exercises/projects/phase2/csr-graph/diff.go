@@ -0,0 +1,130 @@
+package csrgraph
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Edge is a directed edge of a given relationship type
+type Edge struct {
+	Src, Dst NodeID
+	Type     RelType
+}
+
+// Diff compares two graphs as edge sets. added holds the edges of b that
+// are not in a, and removed the edges of a that are not in b, both ordered
+// by source and destination. Types are matched by name, since each builder
+// numbers them on its own; added edges carry b's RelType and removed edges
+// carry a's. Duplicate edges count once.
+func Diff(a, b *CSRGraph) (added, removed []Edge) {
+	for _, name := range unionRelTypeNames(a, b) {
+		ta, pa := a.partitionByName(name)
+		tb, pb := b.partitionByName(name)
+		if pa == nil && pb == nil {
+			continue
+		}
+		for node := range NodeID(max(a.nodeCount, b.nodeCount)) {
+			x := sortedAdjacency(pa.adjacency(node), a.sorted)
+			y := sortedAdjacency(pb.adjacency(node), b.sorted)
+			mergeSorted(x, y, func(dst NodeID, inX, inY bool) {
+				switch {
+				case !inX:
+					added = append(added, Edge{Src: node, Dst: dst, Type: tb})
+				case !inY:
+					removed = append(removed, Edge{Src: node, Dst: dst, Type: ta})
+				}
+			})
+		}
+	}
+	slices.SortFunc(added, compareEdges)
+	slices.SortFunc(removed, compareEdges)
+	return added, removed
+}
+
+// Merge returns a sorted graph holding every edge of a and b once. Types
+// are matched by name and keep a's numbering; types only b has are
+// numbered after them. Nodes without edges are kept.
+func Merge(a, b *CSRGraph) *CSRGraph {
+	builder := NewBuilder()
+	if n := max(a.nodeCount, b.nodeCount); n > 0 {
+		builder.AddNode(NodeID(n - 1))
+	}
+	for _, name := range unionRelTypeNames(a, b) {
+		_, pa := a.partitionByName(name)
+		_, pb := b.partitionByName(name)
+		if pa == nil && pb == nil {
+			continue
+		}
+		relType := builder.RelType(name)
+		for node := range NodeID(max(a.nodeCount, b.nodeCount)) {
+			x := sortedAdjacency(pa.adjacency(node), a.sorted)
+			y := sortedAdjacency(pb.adjacency(node), b.sorted)
+			mergeSorted(x, y, func(dst NodeID, _, _ bool) {
+				builder.AddTypedEdge(node, dst, relType)
+			})
+		}
+	}
+	return builder.BuildSorted()
+}
+
+// unionRelTypeNames returns the type names of a followed by those only b has
+func unionRelTypeNames(a, b *CSRGraph) []string {
+	names := slices.Clone(a.typeNames)
+	if len(names) == 0 {
+		names = append(names, "")
+	}
+	for _, name := range b.typeNames {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// partitionByName returns the type named name and its partition, which is
+// nil when g has no such type
+func (g *CSRGraph) partitionByName(name string) (RelType, *partition) {
+	relType, ok := g.LookupRelType(name)
+	if !ok {
+		return 0, nil
+	}
+	return relType, g.partition(relType)
+}
+
+// sortedAdjacency returns adj in ascending order, sorting a copy unless it
+// already is
+func sortedAdjacency(adj []NodeID, sorted bool) []NodeID {
+	if sorted || len(adj) < 2 {
+		return adj
+	}
+	adj = slices.Clone(adj)
+	slices.Sort(adj)
+	return adj
+}
+
+// mergeSorted walks two ascending slices in step and calls fn once per
+// distinct value, reporting which of the slices hold it
+func mergeSorted(x, y []NodeID, fn func(v NodeID, inX, inY bool)) {
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		var v NodeID
+		switch {
+		case j == len(y) || (i < len(x) && x[i] < y[j]):
+			v = x[i]
+		default:
+			v = y[j]
+		}
+		inX, inY := i < len(x) && x[i] == v, j < len(y) && y[j] == v
+		for i < len(x) && x[i] == v {
+			i++
+		}
+		for j < len(y) && y[j] == v {
+			j++
+		}
+		fn(v, inX, inY)
+	}
+}
+
+func compareEdges(e, f Edge) int {
+	return cmp.Or(cmp.Compare(e.Src, f.Src), cmp.Compare(e.Dst, f.Dst), cmp.Compare(e.Type, f.Type))
+}
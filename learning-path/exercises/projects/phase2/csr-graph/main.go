@@ -1,6 +1,9 @@
 package csrgraph
 
-import "iter"
+import (
+	"iter"
+	"slices"
+)
 
 // NodeID represents a node identifier
 type NodeID uint32
@@ -9,43 +12,86 @@ type NodeID uint32
 type CSRGraph struct {
 	nodeCount uint32
 	edgeCount uint32
-	offsets   []uint32  // nodeCount + 1 elements
-	edges     []NodeID  // edgeCount elements
+	offsets   []uint32 // nodeCount + 1 elements
+	edges     []NodeID // edgeCount elements
+	sorted    bool     // each node's neighbors are in ascending order
+
+	partitions []*partition // indexed by RelType; nil for types without edges
+	typeNames  []string     // indexed by RelType
 }
 
 // GraphBuilder helps construct a CSR graph
 type GraphBuilder struct {
-	adjList map[NodeID][]NodeID
+	adjList   map[NodeID][]NodeID
+	edgeTypes map[NodeID][]RelType // type of each edge in adjList
+	typeNames []string
 }
 
 // NewBuilder creates a new graph builder
 func NewBuilder() *GraphBuilder {
 	return &GraphBuilder{
-		adjList: make(map[NodeID][]NodeID),
+		adjList:   make(map[NodeID][]NodeID),
+		edgeTypes: make(map[NodeID][]RelType),
+		typeNames: []string{""},
 	}
 }
 
 // AddNode adds a node to the graph
 func (b *GraphBuilder) AddNode(node NodeID) {
-	// TODO: Implement node addition
-	// Initialize empty adjacency list if not exists
+	if _, ok := b.adjList[node]; !ok {
+		b.adjList[node] = nil
+	}
 }
 
-// AddEdge adds a directed edge from src to dst
+// AddEdge adds a directed edge from src to dst with DefaultRelType
 func (b *GraphBuilder) AddEdge(src, dst NodeID) {
-	// TODO: Implement edge addition
-	// Add dst to src's adjacency list
+	b.AddTypedEdge(src, dst, DefaultRelType)
 }
 
-// Build constructs the CSR graph from the adjacency list
+// Build constructs the CSR graph from the adjacency list. Neighbors keep
+// the order in which their edges were added.
 func (b *GraphBuilder) Build() *CSRGraph {
-	// TODO: Implement CSR construction
-	// 1. Find max node ID
-	// 2. Allocate offsets array (size = maxNode + 2)
-	// 3. Fill offsets by counting edges
-	// 4. Allocate edges array
-	// 5. Copy edges from adjacency lists
-	return nil
+	return b.build(false)
+}
+
+// BuildSorted constructs the CSR graph with each node's neighbors sorted
+// ascending, which lets HasEdge binary search and CountTriangles intersect
+// adjacency lists by merging.
+func (b *GraphBuilder) BuildSorted() *CSRGraph {
+	return b.build(true)
+}
+
+func (b *GraphBuilder) build(sorted bool) *CSRGraph {
+	g := &CSRGraph{offsets: []uint32{0}, sorted: sorted, typeNames: slices.Clone(b.typeNames)}
+	if len(b.adjList) == 0 {
+		return g
+	}
+
+	var maxNode NodeID
+	for node := range b.adjList {
+		maxNode = max(maxNode, node)
+	}
+	g.nodeCount = uint32(maxNode) + 1
+
+	g.offsets = make([]uint32, g.nodeCount+1)
+	for node, adj := range b.adjList {
+		g.offsets[node+1] = uint32(len(adj))
+	}
+	for i := 1; i < len(g.offsets); i++ {
+		g.offsets[i] += g.offsets[i-1]
+	}
+	g.edgeCount = g.offsets[g.nodeCount]
+
+	g.edges = make([]NodeID, g.edgeCount)
+	for node, adj := range b.adjList {
+		dst := g.edges[g.offsets[node]:g.offsets[node+1]]
+		copy(dst, adj)
+		if sorted {
+			slices.Sort(dst)
+		}
+	}
+	b.buildPartitions(g, sorted)
+	return g
 }
 
 // NodeCount returns the number of nodes in the graph
@@ -58,23 +104,36 @@ func (g *CSRGraph) EdgeCount() uint32 {
 	return g.edgeCount
 }
 
+// Sorted reports whether each node's neighbors are stored in ascending order
+func (g *CSRGraph) Sorted() bool {
+	return g.sorted
+}
+
 // Degree returns the out-degree of a node
 func (g *CSRGraph) Degree(node NodeID) uint32 {
-	// TODO: Implement degree computation
-	// degree = offsets[node+1] - offsets[node]
-	return 0
+	if uint32(node) >= g.nodeCount {
+		return 0
+	}
+	return g.offsets[node+1] - g.offsets[node]
+}
+
+// adjacency returns the neighbor slice of a node
+func (g *CSRGraph) adjacency(node NodeID) []NodeID {
+	if uint32(node) >= g.nodeCount {
+		return nil
+	}
+	return g.edges[g.offsets[node]:g.offsets[node+1]]
 }
 
 // Neighbors returns an iterator over the neighbors of a node
 // Uses Go 1.23 iter.Seq for efficient iteration
 func (g *CSRGraph) Neighbors(node NodeID) iter.Seq[NodeID] {
 	return func(yield func(NodeID) bool) {
-		// TODO: Implement neighbor iteration
-		// 1. Check node bounds
-		// 2. Get start and end offsets
-		// 3. Iterate edges[start:end]
-		// 4. Call yield for each neighbor
-		// 5. Return early if yield returns false
+		for _, neighbor := range g.adjacency(node) {
+			if !yield(neighbor) {
+				return
+			}
+		}
 	}
 }
 
@@ -82,25 +141,116 @@ func (g *CSRGraph) Neighbors(node NodeID) iter.Seq[NodeID] {
 // Returns (src, dst) pairs using Go 1.23 iter.Seq2
 func (g *CSRGraph) Edges() iter.Seq2[NodeID, NodeID] {
 	return func(yield func(NodeID, NodeID) bool) {
-		// TODO: Implement edge iteration
-		// For each node, iterate its neighbors
+		for src := range g.nodeCount {
+			for _, dst := range g.adjacency(NodeID(src)) {
+				if !yield(NodeID(src), dst) {
+					return
+				}
+			}
+		}
 	}
 }
 
+// HasEdge reports whether the edge src->dst exists. It binary searches
+// sorted adjacency and scans it otherwise.
+func (g *CSRGraph) HasEdge(src, dst NodeID) bool {
+	adj := g.adjacency(src)
+	if g.sorted {
+		_, found := slices.BinarySearch(adj, dst)
+		return found
+	}
+	return slices.Contains(adj, dst)
+}
+
 // Has2Hop checks if there is a path from src to dst within 2 hops
 func (g *CSRGraph) Has2Hop(src, dst NodeID) bool {
-	// TODO: Implement 2-hop connectivity check
-	// 1. Check direct edge (1-hop)
-	// 2. Check paths through intermediates (2-hop)
+	if g.HasEdge(src, dst) {
+		return true
+	}
+	for _, mid := range g.adjacency(src) {
+		if g.HasEdge(mid, dst) {
+			return true
+		}
+	}
 	return false
 }
 
 // TwoHopNeighbors returns an iterator over all nodes reachable in 2 hops
 func (g *CSRGraph) TwoHopNeighbors(node NodeID) iter.Seq[NodeID] {
 	return func(yield func(NodeID) bool) {
-		// TODO: Implement 2-hop neighbor iteration
-		// Use nested iteration over neighbors
+		seen := map[NodeID]bool{node: true}
+		for _, mid := range g.adjacency(node) {
+			for _, dst := range g.adjacency(mid) {
+				if seen[dst] {
+					continue
+				}
+				seen[dst] = true
+				if !yield(dst) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// CountTriangles counts node triples u->v, v->w, u->w. Parallel edges are
+// counted once, so a triangle with a duplicated edge still counts as one.
+// With sorted adjacency each edge costs one merge of two neighbor lists;
+// otherwise every candidate w is checked with HasEdge.
+func (g *CSRGraph) CountTriangles() int {
+	count := 0
+	for u := range g.nodeCount {
+		adjU := g.adjacency(NodeID(u))
+		for i, v := range adjU {
+			if !g.firstOccurrence(adjU, i) {
+				continue
+			}
+			if g.sorted {
+				count += intersectSorted(adjU, g.adjacency(v))
+				continue
+			}
+			adjV := g.adjacency(v)
+			for j, w := range adjV {
+				if g.firstOccurrence(adjV, j) && g.HasEdge(NodeID(u), w) {
+					count++
+				}
+			}
+		}
+	}
+	return count
+}
+
+// firstOccurrence reports whether adj[i] is the first copy of its value in
+// adj, so parallel edges are visited once
+func (g *CSRGraph) firstOccurrence(adj []NodeID, i int) bool {
+	if g.sorted {
+		return i == 0 || adj[i-1] != adj[i]
+	}
+	return !slices.Contains(adj[:i], adj[i])
+}
+
+// intersectSorted counts the distinct common elements of two ascending
+// slices
+func intersectSorted(a, b []NodeID) int {
+	count, i, j := 0, 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			v := a[i]
+			count++
+			for i < len(a) && a[i] == v {
+				i++
+			}
+			for j < len(b) && b[j] == v {
+				j++
+			}
+		}
 	}
+	return count
 }
 
 // Iterator composition helpers
@@ -108,24 +258,41 @@ func (g *CSRGraph) TwoHopNeighbors(node NodeID) iter.Seq[NodeID] {
 // Filter returns an iterator that only yields elements matching the predicate
 func Filter[T any](seq iter.Seq[T], pred func(T) bool) iter.Seq[T] {
 	return func(yield func(T) bool) {
-		// TODO: Implement filter
-		// Iterate seq, only yield elements where pred is true
+		for v := range seq {
+			if pred(v) && !yield(v) {
+				return
+			}
+		}
 	}
 }
 
 // Map transforms elements using the given function
 func Map[T, U any](seq iter.Seq[T], fn func(T) U) iter.Seq[U] {
 	return func(yield func(U) bool) {
-		// TODO: Implement map
-		// Iterate seq, yield transformed elements
+		for v := range seq {
+			if !yield(fn(v)) {
+				return
+			}
+		}
 	}
 }
 
 // Take returns an iterator that yields at most n elements
 func Take[T any](seq iter.Seq[T], n int) iter.Seq[T] {
 	return func(yield func(T) bool) {
-		// TODO: Implement take
-		// Count elements and stop after n
+		if n <= 0 {
+			return
+		}
+		i := 0
+		for v := range seq {
+			if !yield(v) {
+				return
+			}
+			i++
+			if i == n {
+				return
+			}
+		}
 	}
 }
 
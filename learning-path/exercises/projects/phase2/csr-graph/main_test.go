@@ -1,6 +1,8 @@
 package csrgraph
 
 import (
+	"math/rand/v2"
+	"slices"
 	"testing"
 )
 
@@ -52,6 +54,192 @@ func TestHas2Hop(t *testing.T) {
 	t.Skip("not implemented")
 }
 
+func buildTestGraph(sorted bool) *CSRGraph {
+	b := NewBuilder()
+	// Edges are added out of order so sorting has work to do
+	for _, e := range [][2]NodeID{{0, 3}, {0, 1}, {0, 2}, {1, 2}, {2, 3}, {3, 0}, {1, 3}} {
+		b.AddEdge(e[0], e[1])
+	}
+	if sorted {
+		return b.BuildSorted()
+	}
+	return b.Build()
+}
+
+func TestHasEdge(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		g := buildTestGraph(sorted)
+		if g.Sorted() != sorted {
+			t.Errorf("Sorted() = %v, want %v", g.Sorted(), sorted)
+		}
+		if sorted {
+			if got := Collect(g.Neighbors(0)); !slices.Equal(got, []NodeID{1, 2, 3}) {
+				t.Errorf("sorted neighbors of 0 = %v", got)
+			}
+		}
+
+		tests := []struct {
+			src, dst NodeID
+			want     bool
+		}{
+			{0, 1, true},
+			{0, 3, true},
+			{1, 0, false},
+			{3, 0, true},
+			{3, 1, false},
+			{9, 0, false}, // unknown node
+		}
+		for _, tt := range tests {
+			if got := g.HasEdge(tt.src, tt.dst); got != tt.want {
+				t.Errorf("sorted=%v HasEdge(%d, %d) = %v, want %v", sorted, tt.src, tt.dst, got, tt.want)
+			}
+		}
+		if !g.Has2Hop(1, 0) || g.Has2Hop(2, 1) {
+			t.Errorf("sorted=%v Has2Hop mismatch", sorted)
+		}
+	}
+}
+
+func TestCountTriangles(t *testing.T) {
+	// Triangles: 0->1->2 with 0->2, 0->1->3 with 0->3, 0->2->3 with 0->3,
+	// 1->2->3 with 1->3
+	for _, sorted := range []bool{false, true} {
+		if got := buildTestGraph(sorted).CountTriangles(); got != 4 {
+			t.Errorf("sorted=%v CountTriangles() = %d, want 4", sorted, got)
+		}
+	}
+}
+
+func TestCountTrianglesParallelEdges(t *testing.T) {
+	// One triangle 0->1->2 with 0->2, where 0->1, 1->2 and 0->2 are each
+	// added twice; both modes must count it once
+	b := NewBuilder()
+	for range 2 {
+		b.AddEdge(0, 1)
+		b.AddEdge(1, 2)
+		b.AddEdge(0, 2)
+	}
+	b.AddEdge(2, 0)
+	for _, g := range []*CSRGraph{b.Build(), b.BuildSorted()} {
+		if got := g.CountTriangles(); got != 1 {
+			t.Errorf("sorted=%v CountTriangles() = %d, want 1", g.Sorted(), got)
+		}
+	}
+}
+
+func TestTypedEdges(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		b := NewBuilder()
+		knows, likes := b.RelType("KNOWS"), b.RelType("LIKES")
+		if b.RelType("KNOWS") != knows || knows == likes || knows == DefaultRelType {
+			t.Fatalf("RelType assigned KNOWS=%d LIKES=%d", knows, likes)
+		}
+		b.AddTypedEdge(0, 3, knows)
+		b.AddTypedEdge(0, 1, likes)
+		b.AddTypedEdge(0, 2, knows)
+		b.AddEdge(0, 4)
+		b.AddTypedEdge(2, 0, likes)
+		var g *CSRGraph
+		if sorted {
+			g = b.BuildSorted()
+		} else {
+			g = b.Build()
+		}
+
+		wantKnows := []NodeID{3, 2}
+		if sorted {
+			wantKnows = []NodeID{2, 3}
+		}
+		if got := Collect(g.NeighborsByType(0, knows)); !slices.Equal(got, wantKnows) {
+			t.Errorf("sorted=%v KNOWS neighbors of 0 = %v, want %v", sorted, got, wantKnows)
+		}
+		if got := Collect(g.NeighborsByType(0, DefaultRelType)); !slices.Equal(got, []NodeID{4}) {
+			t.Errorf("sorted=%v untyped neighbors of 0 = %v", sorted, got)
+		}
+		if g.Degree(0) != 4 || g.DegreeByType(0, likes) != 1 || g.DegreeByType(1, likes) != 0 {
+			t.Errorf("sorted=%v degrees: all %d, LIKES %d", sorted, g.Degree(0), g.DegreeByType(0, likes))
+		}
+		if !g.HasEdgeByType(2, 0, likes) || g.HasEdgeByType(2, 0, knows) || g.HasEdgeByType(9, 0, likes) {
+			t.Errorf("sorted=%v HasEdgeByType mismatch", sorted)
+		}
+		if g.EdgeCountByType(knows) != 2 || g.EdgeCountByType(99) != 0 {
+			t.Errorf("sorted=%v EdgeCountByType(KNOWS) = %d", sorted, g.EdgeCountByType(knows))
+		}
+		if got := g.RelTypes(); !slices.Equal(got, []RelType{DefaultRelType, knows, likes}) {
+			t.Errorf("sorted=%v RelTypes() = %v", sorted, got)
+		}
+		if rt, ok := g.LookupRelType("LIKES"); !ok || rt != likes || g.RelTypeName(likes) != "LIKES" {
+			t.Errorf("sorted=%v LookupRelType(LIKES) = %d, %v", sorted, rt, ok)
+		}
+		if _, ok := g.LookupRelType("FOLLOWS"); ok {
+			t.Errorf("sorted=%v unknown type found", sorted)
+		}
+	}
+
+	// With a single type the partition shares the graph's arrays
+	g := buildTestGraph(true)
+	if got := Collect(g.NeighborsByType(0, DefaultRelType)); !slices.Equal(got, []NodeID{1, 2, 3}) {
+		t.Errorf("untyped graph: NeighborsByType = %v", got)
+	}
+	if &g.partitions[DefaultRelType].edges[0] != &g.edges[0] {
+		t.Error("single-type partition copied the edge array")
+	}
+}
+
+func TestDiffMerge(t *testing.T) {
+	old := NewBuilder()
+	knows := old.RelType("KNOWS")
+	old.AddTypedEdge(0, 2, knows)
+	old.AddTypedEdge(0, 1, knows)
+	old.AddTypedEdge(0, 1, knows) // duplicate
+	old.AddEdge(1, 2)
+	old.AddEdge(2, 0)
+	a := old.Build()
+
+	// The new snapshot names its types in another order
+	next := NewBuilder()
+	likes, knows2 := next.RelType("LIKES"), next.RelType("KNOWS")
+	next.AddTypedEdge(0, 1, knows2)
+	next.AddTypedEdge(0, 2, likes)
+	next.AddEdge(2, 0)
+	next.AddEdge(3, 1)
+	next.AddNode(5)
+	b := next.BuildSorted()
+
+	added, removed := Diff(a, b)
+	wantAdded := []Edge{{0, 2, likes}, {3, 1, DefaultRelType}}
+	wantRemoved := []Edge{{0, 2, knows}, {1, 2, DefaultRelType}}
+	if !slices.Equal(added, wantAdded) || !slices.Equal(removed, wantRemoved) {
+		t.Errorf("Diff() = %v, %v, want %v, %v", added, removed, wantAdded, wantRemoved)
+	}
+	if added, removed := Diff(a, a); added != nil || removed != nil {
+		t.Errorf("Diff(a, a) = %v, %v", added, removed)
+	}
+
+	m := Merge(a, b)
+	if m.NodeCount() != 6 || m.EdgeCount() != 6 || !m.Sorted() {
+		t.Fatalf("Merge: %d nodes, %d edges", m.NodeCount(), m.EdgeCount())
+	}
+	mKnows, _ := m.LookupRelType("KNOWS")
+	mLikes, _ := m.LookupRelType("LIKES")
+	if mKnows != knows {
+		t.Errorf("Merge renumbered KNOWS to %d", mKnows)
+	}
+	if got := Collect(m.NeighborsByType(0, mKnows)); !slices.Equal(got, []NodeID{1, 2}) {
+		t.Errorf("merged KNOWS neighbors of 0 = %v", got)
+	}
+	if !m.HasEdgeByType(0, 2, mLikes) || !m.HasEdgeByType(1, 2, DefaultRelType) || !m.HasEdge(3, 1) {
+		t.Error("merged graph lost an edge")
+	}
+	// The merge holds every edge of both graphs
+	if added, _ := Diff(m, b); len(added) != 0 {
+		t.Errorf("Diff(merged, b) added %v", added)
+	}
+	if _, removed := Diff(a, m); len(removed) != 0 {
+		t.Errorf("Diff(a, merged) removed %v", removed)
+	}
+}
+
 func TestEmptyGraph(t *testing.T) {
 	// TODO: Test empty graph handling
 	t.Skip("not implemented")
@@ -94,6 +282,31 @@ func Benchmark2HopQuery(b *testing.B) {
 	b.Skip("not implemented")
 }
 
+func BenchmarkHasEdge(b *testing.B) {
+	const nodes, degree = 10000, 64
+	rng := rand.New(rand.NewPCG(1, 2))
+	builder := NewBuilder()
+	for src := range NodeID(nodes) {
+		for range degree {
+			builder.AddEdge(src, NodeID(rng.IntN(nodes)))
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		g    *CSRGraph
+	}{
+		{"scan", builder.Build()},
+		{"binary-search", builder.BuildSorted()},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tc.g.HasEdge(NodeID(i%nodes), NodeID((i*7919)%nodes))
+			}
+		})
+	}
+}
+
 func BenchmarkVsAdjList(b *testing.B) {
 	// TODO: Compare CSR vs adjacency list
 	// Measure iteration speed and memory
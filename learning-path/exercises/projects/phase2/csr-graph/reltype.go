@@ -0,0 +1,160 @@
+package csrgraph
+
+import (
+	"iter"
+	"slices"
+)
+
+// RelType identifies a relationship type. Edges added with AddEdge have
+// DefaultRelType.
+type RelType uint16
+
+// DefaultRelType is the type of untyped edges; its name is ""
+const DefaultRelType RelType = 0
+
+// partition is the CSR of the edges of one relationship type. Its offsets
+// cover every node of the graph.
+type partition struct {
+	offsets []uint32
+	edges   []NodeID
+}
+
+func (p *partition) adjacency(node NodeID) []NodeID {
+	if p == nil || int(node)+1 >= len(p.offsets) {
+		return nil
+	}
+	return p.edges[p.offsets[node]:p.offsets[node+1]]
+}
+
+// RelType returns the type named name, assigning the next free type the
+// first time a name is seen
+func (b *GraphBuilder) RelType(name string) RelType {
+	if i := slices.Index(b.typeNames, name); i >= 0 {
+		return RelType(i)
+	}
+	b.typeNames = append(b.typeNames, name)
+	return RelType(len(b.typeNames) - 1)
+}
+
+// AddTypedEdge adds a directed edge of type relType from src to dst. It is
+// also one of src's Neighbors, which span every type.
+func (b *GraphBuilder) AddTypedEdge(src, dst NodeID, relType RelType) {
+	b.AddNode(dst)
+	b.adjList[src] = append(b.adjList[src], dst)
+	b.edgeTypes[src] = append(b.edgeTypes[src], relType)
+}
+
+// buildPartitions builds the per-type CSRs of g. When every edge has the
+// same type, its partition shares g's arrays instead of copying them.
+func (b *GraphBuilder) buildPartitions(g *CSRGraph, sorted bool) {
+	counts := make(map[RelType]int)
+	for _, types := range b.edgeTypes {
+		for _, t := range types {
+			counts[t]++
+		}
+	}
+	var maxType RelType
+	for t := range counts {
+		maxType = max(maxType, t)
+	}
+	if len(counts) == 0 {
+		return
+	}
+	g.partitions = make([]*partition, maxType+1)
+	if len(counts) == 1 {
+		g.partitions[maxType] = &partition{offsets: g.offsets, edges: g.edges}
+		return
+	}
+
+	for t, n := range counts {
+		g.partitions[t] = &partition{
+			offsets: make([]uint32, g.nodeCount+1),
+			edges:   make([]NodeID, 0, n),
+		}
+	}
+	for node := range NodeID(g.nodeCount) {
+		types := b.edgeTypes[node]
+		for i, dst := range b.adjList[node] {
+			p := g.partitions[types[i]]
+			p.edges = append(p.edges, dst)
+		}
+		for _, p := range g.partitions {
+			if p == nil {
+				continue
+			}
+			p.offsets[node+1] = uint32(len(p.edges))
+			if sorted {
+				slices.Sort(p.edges[p.offsets[node]:])
+			}
+		}
+	}
+}
+
+// NeighborsByType returns an iterator over the neighbors of node along
+// edges of type relType
+func (g *CSRGraph) NeighborsByType(node NodeID, relType RelType) iter.Seq[NodeID] {
+	return func(yield func(NodeID) bool) {
+		for _, neighbor := range g.partition(relType).adjacency(node) {
+			if !yield(neighbor) {
+				return
+			}
+		}
+	}
+}
+
+// DegreeByType returns the number of edges of type relType leaving node
+func (g *CSRGraph) DegreeByType(node NodeID, relType RelType) uint32 {
+	return uint32(len(g.partition(relType).adjacency(node)))
+}
+
+// HasEdgeByType reports whether an edge src->dst of type relType exists
+func (g *CSRGraph) HasEdgeByType(src, dst NodeID, relType RelType) bool {
+	adj := g.partition(relType).adjacency(src)
+	if g.sorted {
+		_, found := slices.BinarySearch(adj, dst)
+		return found
+	}
+	return slices.Contains(adj, dst)
+}
+
+// EdgeCountByType returns the number of edges of type relType
+func (g *CSRGraph) EdgeCountByType(relType RelType) uint32 {
+	p := g.partition(relType)
+	if p == nil {
+		return 0
+	}
+	return uint32(len(p.edges))
+}
+
+// RelTypes returns the types that have at least one edge, in ascending order
+func (g *CSRGraph) RelTypes() []RelType {
+	var types []RelType
+	for t, p := range g.partitions {
+		if p != nil && len(p.edges) > 0 {
+			types = append(types, RelType(t))
+		}
+	}
+	return types
+}
+
+// LookupRelType returns the type the builder assigned to name
+func (g *CSRGraph) LookupRelType(name string) (RelType, bool) {
+	i := slices.Index(g.typeNames, name)
+	return RelType(max(i, 0)), i >= 0
+}
+
+// RelTypeName returns the name of a type assigned by GraphBuilder.RelType,
+// or "" for an unnamed type
+func (g *CSRGraph) RelTypeName(relType RelType) string {
+	if int(relType) < len(g.typeNames) {
+		return g.typeNames[relType]
+	}
+	return ""
+}
+
+func (g *CSRGraph) partition(relType RelType) *partition {
+	if int(relType) >= len(g.partitions) {
+		return nil
+	}
+	return g.partitions[relType]
+}
@@ -0,0 +1,152 @@
+package parallelalgo
+
+import "context"
+
+// reduceBlock is the number of nodes a deterministic sum adds up in one
+// sequential block
+const reduceBlock = 1024
+
+// Options configures the algorithms
+type Options struct {
+	// Workers is the number of goroutines; at least one is used
+	Workers int
+	// Deterministic makes scores bitwise identical across runs and worker
+	// counts. Every floating-point sum is reduced in an order fixed by
+	// node IDs rather than by how nodes are split between workers, at the
+	// cost of a transposed copy of the graph. Only PageRank uses it.
+	Deterministic bool
+	// Progress, if set, is called as the algorithm advances
+	Progress ProgressFunc
+}
+
+// PageRankWith is PageRank configured by opts. Cancellation is checked and
+// progress reported after each iteration, with every node in the frontier.
+func PageRankWith(ctx context.Context, g CSRGraph, iterations int, dampingFactor float64, opts Options) ([]float64, error) {
+	n := int(g.NodeCount())
+	if n == 0 {
+		return nil, ctx.Err()
+	}
+	teleport := make([]float64, n)
+	for i := range teleport {
+		teleport[i] = 1 / float64(n)
+	}
+	return propagateRank(ctx, g, teleport, 1-dampingFactor, iterations, opts)
+}
+
+// PersonalizedPageRankWith is PersonalizedPageRank configured by opts, and
+// checks ctx and reports progress as PageRankWith does
+func PersonalizedPageRankWith(ctx context.Context, g CSRGraph, sources []NodeID, alpha float64, iterations int, opts Options) ([]float64, error) {
+	teleport := sourceTeleport(int(g.NodeCount()), sources)
+	if teleport == nil {
+		return nil, ctx.Err()
+	}
+	return propagateRank(ctx, g, teleport, alpha, iterations, opts)
+}
+
+// pullRank is propagateRank with a fixed reduction order. Each node pulls
+// the shares of its in-neighbours in ascending node order, and the rank of
+// dangling nodes is summed in blocks of reduceBlock nodes whose totals are
+// added in order, so no sum depends on the node ranges of the workers.
+func pullRank(ctx context.Context, g CSRGraph, teleport []float64, alpha float64, iterations int, opts Options) ([]float64, error) {
+	workers := opts.Workers
+	n := len(teleport)
+	offsets, sources := transpose(g)
+	degree := make([]int, n)
+	for u := range degree {
+		degree[u] = len(g.Neighbors(NodeID(u)))
+	}
+	ranges := chunks(n, workers)
+	pool := &WorkerPool{workers: workers}
+
+	rank := append([]float64(nil), teleport...)
+	next := make([]float64, n)
+	share := make([]float64, n)
+	for i := range iterations {
+		pool.Execute(rangeTasks(ranges, func(lo, hi int) {
+			for u := lo; u < hi; u++ {
+				share[u] = 0
+				if degree[u] > 0 {
+					share[u] = (1 - alpha) * rank[u] / float64(degree[u])
+				}
+			}
+		}))
+
+		lost := blockSum(pool, n, func(u int) float64 {
+			if degree[u] == 0 {
+				return rank[u]
+			}
+			return 0
+		})
+		restart := alpha + (1-alpha)*lost
+
+		pool.Execute(rangeTasks(ranges, func(lo, hi int) {
+			for v := lo; v < hi; v++ {
+				sum := restart * teleport[v]
+				for _, u := range sources[offsets[v]:offsets[v+1]] {
+					sum += share[u]
+				}
+				next[v] = sum
+			}
+		}))
+		rank, next = next, rank
+		if err := iterationDone(ctx, opts.Progress, i, iterations, n); err != nil {
+			return nil, err
+		}
+	}
+	return rank, nil
+}
+
+// transpose returns the in-edges of g in CSR form: the sources of the edges
+// into v are sources[offsets[v]:offsets[v+1]], in ascending order
+func transpose(g CSRGraph) (offsets []int, sources []NodeID) {
+	n := int(g.NodeCount())
+	offsets = make([]int, n+1)
+	for u := range n {
+		for _, v := range g.Neighbors(NodeID(u)) {
+			offsets[v+1]++
+		}
+	}
+	for v := range n {
+		offsets[v+1] += offsets[v]
+	}
+	sources = make([]NodeID, offsets[n])
+	fill := append([]int(nil), offsets[:n]...)
+	for u := range n {
+		for _, v := range g.Neighbors(NodeID(u)) {
+			sources[fill[v]] = NodeID(u)
+			fill[v]++
+		}
+	}
+	return offsets, sources
+}
+
+// blockSum adds f(i) for i in [0, n) in a fixed order: blocks of
+// reduceBlock values are summed in parallel, then the block totals are
+// summed in order
+func blockSum(pool *WorkerPool, n int, f func(i int) float64) float64 {
+	totals := make([]float64, (n+reduceBlock-1)/reduceBlock)
+	tasks := make([]func(), len(totals))
+	for b := range totals {
+		tasks[b] = func() {
+			for i := b * reduceBlock; i < min((b+1)*reduceBlock, n); i++ {
+				totals[b] += f(i)
+			}
+		}
+	}
+	pool.Execute(tasks)
+
+	var sum float64
+	for _, t := range totals {
+		sum += t
+	}
+	return sum
+}
+
+// rangeTasks makes one task per range, calling fn with its bounds
+func rangeTasks(ranges [][2]int, fn func(lo, hi int)) []func() {
+	tasks := make([]func(), len(ranges))
+	for w, r := range ranges {
+		tasks[w] = func() { fn(r[0], r[1]) }
+	}
+	return tasks
+}
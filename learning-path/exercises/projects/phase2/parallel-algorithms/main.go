@@ -1,6 +1,7 @@
 package parallelalgo
 
 import (
+	"context"
 	"sync"
 	"sync/atomic"
 )
@@ -14,29 +15,98 @@ type CSRGraph interface {
 }
 
 // ParallelBFS performs breadth-first search using multiple workers
-func ParallelBFS(g CSRGraph, source NodeID, workers int) []int {
+func ParallelBFS(ctx context.Context, g CSRGraph, source NodeID, workers int) ([]int, error) {
 	// TODO: Implement parallel BFS
-	// Use level-synchronous approach
-	return nil
+	// Use level-synchronous approach; check ctx and report each level as
+	// an iteration whose frontier is the level's nodes
+	return nil, ctx.Err()
 }
 
-// PageRank computes PageRank scores in parallel
-func PageRank(g CSRGraph, iterations int, dampingFactor float64, workers int) []float64 {
-	// TODO: Implement parallel PageRank
-	return nil
+// PageRank computes PageRank scores in parallel. Each step follows an
+// out-edge with probability dampingFactor and jumps to a uniformly random
+// node otherwise.
+func PageRank(ctx context.Context, g CSRGraph, iterations int, dampingFactor float64, workers int) ([]float64, error) {
+	return PageRankWith(ctx, g, iterations, dampingFactor, Options{Workers: workers})
 }
 
 // CountTriangles counts triangles in the graph using parallel workers
-func CountTriangles(g CSRGraph, workers int) int64 {
+func CountTriangles(ctx context.Context, g CSRGraph, workers int) (int64, error) {
 	// TODO: Implement parallel triangle counting
-	return 0
+	return 0, ctx.Err()
 }
 
-// ConnectedComponents finds connected components in parallel
-func ConnectedComponents(g CSRGraph, workers int) []int {
-	// TODO: Implement parallel connected components
-	// Use union-find with path compression
-	return nil
+// ConnectedComponents finds the weakly connected components in parallel
+// and labels each node with the smallest node ID in its component. The
+// labels do not depend on scheduling or the number of workers.
+//
+// Workers union the endpoints of their nodes' edges in a shared lock-free
+// union-find. A root is only ever linked under a smaller root, and path
+// halving only moves a node's parent closer to the root, so every parent
+// ID is at most its child's and each root is the minimum of its tree.
+func ConnectedComponents(ctx context.Context, g CSRGraph, workers int) ([]int, error) {
+	return ConnectedComponentsWith(ctx, g, Options{Workers: workers})
+}
+
+// ConnectedComponentsWith is ConnectedComponents configured by opts.
+// Progress counts the nodes whose edges have been unioned.
+func ConnectedComponentsWith(ctx context.Context, g CSRGraph, opts Options) ([]int, error) {
+	workers := opts.Workers
+	n := int(g.NodeCount())
+	parent := make([]atomic.Uint32, n)
+	for i := range parent {
+		parent[i].Store(uint32(i))
+	}
+	find := func(x uint32) uint32 {
+		for {
+			p := parent[x].Load()
+			if p == x {
+				return x
+			}
+			gp := parent[p].Load()
+			parent[x].CompareAndSwap(p, gp)
+			x = gp
+		}
+	}
+	union := func(a, b uint32) {
+		for {
+			ra, rb := find(a), find(b)
+			if ra == rb {
+				return
+			}
+			lo, hi := min(ra, rb), max(ra, rb)
+			if parent[hi].CompareAndSwap(hi, lo) {
+				return
+			}
+		}
+	}
+
+	ranges := chunks(n, workers)
+	pool := &WorkerPool{workers: workers}
+	progress := newProgressTracker(ctx, opts.Progress, n)
+	pool.Execute(rangeTasks(ranges, func(lo, hi int) {
+		for block := lo; block < hi; block += checkBlock {
+			end := min(block+checkBlock, hi)
+			for u := block; u < end; u++ {
+				for _, v := range g.Neighbors(NodeID(u)) {
+					union(uint32(u), uint32(v))
+				}
+			}
+			if !progress.add(end - block) {
+				return
+			}
+		}
+	}))
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	labels := make([]int, n)
+	pool.Execute(rangeTasks(ranges, func(lo, hi int) {
+		for u := lo; u < hi; u++ {
+			labels[u] = int(find(uint32(u)))
+		}
+	}))
+	return labels, nil
 }
 
 // WorkerPool manages a pool of workers
@@ -45,7 +115,31 @@ type WorkerPool struct {
 	wg      sync.WaitGroup
 }
 
-// Execute runs tasks on the worker pool
+// Execute runs tasks on the worker pool and waits for all of them
 func (p *WorkerPool) Execute(tasks []func()) {
-	// TODO: Implement worker pool execution
+	queue := make(chan func())
+	for i := 0; i < max(p.workers, 1); i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for task := range queue {
+				task()
+			}
+		}()
+	}
+	for _, task := range tasks {
+		queue <- task
+	}
+	close(queue)
+	p.wg.Wait()
+}
+
+// chunks splits [0, n) into at most parts contiguous ranges
+func chunks(n, parts int) [][2]int {
+	parts = max(min(parts, n), 1)
+	ranges := make([][2]int, 0, parts)
+	for i := range parts {
+		ranges = append(ranges, [2]int{i * n / parts, (i + 1) * n / parts})
+	}
+	return ranges
 }
@@ -1,6 +1,61 @@
 package parallelalgo
 
-import "testing"
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+// adjGraph is a CSRGraph backed by adjacency lists
+type adjGraph [][]NodeID
+
+func (g adjGraph) NodeCount() uint32              { return uint32(len(g)) }
+func (g adjGraph) Neighbors(node NodeID) []NodeID { return g[node] }
+
+// testGraph has a dense cluster {0,1,2}, a chain 3->4->5 and a dangling
+// node 5
+var testGraph = adjGraph{
+	{1, 2},
+	{0, 2},
+	{0, 1, 3},
+	{4},
+	{5},
+	{},
+}
+
+// randomGraph has n nodes with up to degree random out-edges each; some
+// nodes have none
+func randomGraph(n, degree int, seed uint64) adjGraph {
+	rng := rand.New(rand.NewPCG(seed, 0))
+	g := make(adjGraph, n)
+	for u := range g {
+		for range rng.IntN(degree + 1) {
+			g[u] = append(g[u], NodeID(rng.IntN(n)))
+		}
+	}
+	return g
+}
+
+// must returns the result of an algorithm that cannot fail
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func sum(xs []float64) float64 {
+	var total float64
+	for _, x := range xs {
+		total += x
+	}
+	return total
+}
 
 func TestParallelBFS(t *testing.T) {
 	// TODO: Test parallel BFS correctness
@@ -13,14 +68,186 @@ func TestPageRank(t *testing.T) {
 	t.Skip("not implemented")
 }
 
+func TestPersonalizedPageRank(t *testing.T) {
+	serial := must(PersonalizedPageRank(t.Context(), testGraph, []NodeID{3}, 0.15, 50, 1))
+	parallel := must(PersonalizedPageRank(t.Context(), testGraph, []NodeID{3}, 0.15, 50, 4))
+
+	if math.Abs(sum(serial)-1) > 1e-9 {
+		t.Errorf("scores sum to %v, want 1", sum(serial))
+	}
+	for i := range serial {
+		if math.Abs(serial[i]-parallel[i]) > 1e-12 {
+			t.Errorf("node %d: serial %v != parallel %v", i, serial[i], parallel[i])
+		}
+	}
+	// Nodes downstream of the source outrank the cluster it cannot reach
+	if serial[3] <= serial[0] || serial[4] <= serial[0] {
+		t.Errorf("source neighbourhood not favoured: %v", serial)
+	}
+	for _, v := range serial[:3] {
+		if v != 0 {
+			t.Errorf("unreachable cluster has rank: %v", serial)
+			break
+		}
+	}
+
+	if got := must(PersonalizedPageRank(t.Context(), testGraph, []NodeID{99}, 0.15, 10, 2)); got != nil {
+		t.Errorf("invalid sources: got %v, want nil", got)
+	}
+
+	global := must(PageRank(t.Context(), testGraph, 50, 0.85, 2))
+	if math.Abs(sum(global)-1) > 1e-9 {
+		t.Errorf("PageRank scores sum to %v, want 1", sum(global))
+	}
+}
+
+func TestDeterministicPageRank(t *testing.T) {
+	g := randomGraph(5000, 8, 1)
+	want := must(PageRankWith(t.Context(), g, 20, 0.85, Options{Workers: 1, Deterministic: true}))
+	for _, workers := range []int{1, 3, 8} {
+		for run := range 2 {
+			got := must(PageRankWith(t.Context(), g, 20, 0.85, Options{Workers: workers, Deterministic: true}))
+			if !slices.Equal(got, want) {
+				t.Fatalf("workers=%d run %d: scores differ from workers=1", workers, run)
+			}
+		}
+	}
+
+	// The pull order only changes rounding
+	pushed := must(PageRank(t.Context(), g, 20, 0.85, 8))
+	for i := range want {
+		if math.Abs(pushed[i]-want[i]) > 1e-12 {
+			t.Fatalf("node %d: deterministic %v, default %v", i, want[i], pushed[i])
+		}
+	}
+	if math.Abs(sum(want)-1) > 1e-9 {
+		t.Errorf("scores sum to %v, want 1", sum(want))
+	}
+
+	sources := []NodeID{3, 17, 4242}
+	serial := must(PersonalizedPageRankWith(t.Context(), g, sources, 0.15, 20, Options{Workers: 1, Deterministic: true}))
+	parallel := must(PersonalizedPageRankWith(t.Context(), g, sources, 0.15, 20, Options{Workers: 7, Deterministic: true}))
+	if !slices.Equal(serial, parallel) {
+		t.Error("personalized scores depend on workers")
+	}
+}
+
+func TestRandomWalks(t *testing.T) {
+	cfg := WalkConfig{WalksPerNode: 20, WalkLength: 10, RestartProb: 0.2, Seed: 7, Workers: 1}
+	serial := must(RandomWalks(t.Context(), testGraph, nil, cfg))
+	cfg.Workers = 8
+	parallel := must(RandomWalks(t.Context(), testGraph, nil, cfg))
+
+	if !slices.Equal(serial, parallel) {
+		t.Errorf("visit counts depend on workers: %v vs %v", serial, parallel)
+	}
+	var total int64
+	for _, c := range serial {
+		total += c
+	}
+	if want := int64(len(testGraph) * 20 * 11); total != want {
+		t.Errorf("total visits = %d, want %d", total, want)
+	}
+
+	// Walks from node 3 never reach the cluster
+	fromChain := must(RandomWalks(t.Context(), testGraph, []NodeID{3}, cfg))
+	if fromChain[0]+fromChain[1]+fromChain[2] != 0 || fromChain[3] == 0 {
+		t.Errorf("walks from 3 visited %v", fromChain)
+	}
+
+	// Always restarting means every visit is the start node
+	cfg.RestartProb = 1
+	if stay := must(RandomWalks(t.Context(), testGraph, []NodeID{0}, cfg)); stay[0] != 20*11 {
+		t.Errorf("restart-only walks visited %v", stay)
+	}
+}
+
 func TestCountTriangles(t *testing.T) {
 	// TODO: Test triangle counting
 	t.Skip("not implemented")
 }
 
 func TestConnectedComponents(t *testing.T) {
-	// TODO: Test connected components
-	t.Skip("not implemented")
+	// {0,1,2} via a cycle, {3,4} via 4->3 only, and 5 alone
+	g := adjGraph{{1}, {2}, {0}, {}, {3}, {}}
+	want := []int{0, 0, 0, 3, 3, 5}
+	for _, workers := range []int{1, 2, 8} {
+		if got := must(ConnectedComponents(t.Context(), g, workers)); !slices.Equal(got, want) {
+			t.Errorf("workers=%d: labels %v, want %v", workers, got, want)
+		}
+	}
+
+	// Labels are the smallest node of each component for any worker count
+	big := randomGraph(20000, 1, 2)
+	serial := must(ConnectedComponents(t.Context(), big, 1))
+	for range 3 {
+		if got := must(ConnectedComponents(t.Context(), big, 8)); !slices.Equal(got, serial) {
+			t.Fatal("labels depend on workers")
+		}
+	}
+	for u, label := range serial {
+		if label > u {
+			t.Fatalf("node %d labelled %d", u, label)
+		}
+		for _, v := range big[u] {
+			if serial[v] != label {
+				t.Fatalf("edge %d->%d crosses components %d and %d", u, v, label, serial[v])
+			}
+		}
+	}
+}
+
+func TestProgressAndCancel(t *testing.T) {
+	g := randomGraph(20000, 4, 3)
+
+	var reports []Progress
+	opts := Options{Workers: 4, Progress: func(p Progress) { reports = append(reports, p) }}
+	if _, err := PageRankWith(t.Context(), g, 5, 0.85, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 5 || reports[4] != (Progress{Fraction: 1, Iteration: 5, Frontier: len(g)}) {
+		t.Errorf("PageRank progress %v", reports)
+	}
+
+	// Cancelling from the callback stops PageRank after that iteration
+	for _, deterministic := range []bool{false, true} {
+		ctx, cancel := context.WithCancel(t.Context())
+		iterations := 0
+		opts := Options{Workers: 4, Deterministic: deterministic, Progress: func(p Progress) {
+			iterations = p.Iteration
+			if p.Iteration == 3 {
+				cancel()
+			}
+		}}
+		if _, err := PageRankWith(ctx, g, 50, 0.85, opts); !errors.Is(err, context.Canceled) || iterations != 3 {
+			t.Errorf("deterministic=%v: cancelled PageRank = %v after %d iterations", deterministic, err, iterations)
+		}
+		cancel()
+	}
+
+	// Single-pass algorithms report rising fractions that end at 1
+	reports = nil
+	if _, err := ConnectedComponentsWith(t.Context(), g, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) < 10 || reports[len(reports)-1].Fraction != 1 ||
+		!slices.IsSortedFunc(reports, func(a, b Progress) int { return cmp.Compare(a.Fraction, b.Fraction) }) {
+		t.Errorf("ConnectedComponents progress %v", reports)
+	}
+	reports = nil
+	cfg := WalkConfig{WalksPerNode: 1, WalkLength: 5, Workers: 4, Progress: opts.Progress}
+	if _, err := RandomWalks(t.Context(), g, nil, cfg); err != nil || reports[len(reports)-1].Fraction != 1 {
+		t.Errorf("RandomWalks progress %v, %v", reports, err)
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	if _, err := ConnectedComponents(ctx, g, 4); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled ConnectedComponents = %v", err)
+	}
+	if _, err := RandomWalks(ctx, g, nil, cfg); !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled RandomWalks = %v", err)
+	}
 }
 
 func BenchmarkParallelBFS(b *testing.B) {
@@ -29,6 +256,15 @@ func BenchmarkParallelBFS(b *testing.B) {
 }
 
 func BenchmarkPageRank(b *testing.B) {
-	// TODO: Benchmark PageRank scalability
-	b.Skip("not implemented")
+	g := randomGraph(100000, 10, 1)
+	for _, deterministic := range []bool{false, true} {
+		for _, workers := range []int{1, 4} {
+			b.Run(fmt.Sprintf("deterministic=%v/workers=%d", deterministic, workers), func(b *testing.B) {
+				opts := Options{Workers: workers, Deterministic: deterministic}
+				for b.Loop() {
+					PageRankWith(b.Context(), g, 10, 0.85, opts)
+				}
+			})
+		}
+	}
 }
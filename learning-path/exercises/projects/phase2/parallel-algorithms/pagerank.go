@@ -0,0 +1,119 @@
+package parallelalgo
+
+import "context"
+
+// PersonalizedPageRank computes PageRank relative to a set of source nodes:
+// at each step the walk restarts at a uniformly chosen source with
+// probability alpha and follows a random out-edge otherwise. Scores sum to 1
+// and rank nodes by proximity to the sources. With no valid sources it
+// returns nil.
+func PersonalizedPageRank(ctx context.Context, g CSRGraph, sources []NodeID, alpha float64, iterations int, workers int) ([]float64, error) {
+	return PersonalizedPageRankWith(ctx, g, sources, alpha, iterations, Options{Workers: workers})
+}
+
+// sourceTeleport spreads the restart probability evenly over the valid
+// sources, or returns nil if there are none
+func sourceTeleport(n int, sources []NodeID) []float64 {
+	teleport := make([]float64, n)
+	valid := 0
+	for _, s := range sources {
+		if int(s) < n {
+			valid++
+		}
+	}
+	if valid == 0 {
+		return nil
+	}
+	for _, s := range sources {
+		if int(s) < n {
+			teleport[s] += 1 / float64(valid)
+		}
+	}
+	return teleport
+}
+
+// propagateRank runs power iteration for the walk that restarts according
+// to the teleport distribution with probability alpha. Rank held by nodes
+// without out-edges is redistributed by teleport as well, so the total
+// stays 1.
+//
+// Work is split into contiguous node ranges. Each worker pushes its
+// contributions into a private vector and the vectors are summed per node,
+// so no atomics are needed. The sums therefore depend on the ranges; with
+// opts.Deterministic, pullRank computes the scores instead.
+func propagateRank(ctx context.Context, g CSRGraph, teleport []float64, alpha float64, iterations int, opts Options) ([]float64, error) {
+	workers := opts.Workers
+	if opts.Deterministic {
+		return pullRank(ctx, g, teleport, alpha, iterations, opts)
+	}
+	n := len(teleport)
+	ranges := chunks(n, workers)
+	pool := &WorkerPool{workers: workers}
+
+	rank := append([]float64(nil), teleport...)
+	partial := make([][]float64, len(ranges))
+	dangling := make([]float64, len(ranges))
+	for i := range partial {
+		partial[i] = make([]float64, n)
+	}
+
+	for i := range iterations {
+		scatter := make([]func(), len(ranges))
+		for w, r := range ranges {
+			scatter[w] = func() {
+				next := partial[w]
+				clear(next)
+				dangling[w] = 0
+				for u := r[0]; u < r[1]; u++ {
+					out := g.Neighbors(NodeID(u))
+					if len(out) == 0 {
+						dangling[w] += rank[u]
+						continue
+					}
+					share := (1 - alpha) * rank[u] / float64(len(out))
+					for _, v := range out {
+						next[v] += share
+					}
+				}
+			}
+		}
+		pool.Execute(scatter)
+
+		var lost float64
+		for _, d := range dangling {
+			lost += d
+		}
+		restart := alpha + (1-alpha)*lost
+
+		gather := make([]func(), len(ranges))
+		for w, r := range ranges {
+			gather[w] = func() {
+				for v := r[0]; v < r[1]; v++ {
+					sum := restart * teleport[v]
+					for _, next := range partial {
+						sum += next[v]
+					}
+					rank[v] = sum
+				}
+			}
+		}
+		pool.Execute(gather)
+		if err := iterationDone(ctx, opts.Progress, i, iterations, n); err != nil {
+			return nil, err
+		}
+	}
+	return rank, nil
+}
+
+// iterationDone reports iteration i of an iterative algorithm as finished,
+// with frontier active nodes, and returns ctx's error once it is cancelled
+func iterationDone(ctx context.Context, progress ProgressFunc, i, iterations, frontier int) error {
+	if progress != nil {
+		progress(Progress{
+			Fraction:  float64(i+1) / float64(iterations),
+			Iteration: i + 1,
+			Frontier:  frontier,
+		})
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,57 @@
+package parallelalgo
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// checkBlock is how many nodes a worker handles between checks for
+// cancellation
+const checkBlock = 1024
+
+// Progress describes how far an algorithm has got
+type Progress struct {
+	// Fraction is the share of the work done, from 0 to 1
+	Fraction float64
+	// Iteration is the number of iterations finished, for iterative
+	// algorithms such as PageRank
+	Iteration int
+	// Frontier is the number of nodes active in the current iteration, or
+	// 0 for algorithms without iterations
+	Frontier int
+}
+
+// ProgressFunc receives progress reports. It may be called from worker
+// goroutines, but never concurrently, and should return quickly.
+type ProgressFunc func(Progress)
+
+// progressTracker counts the units of work done by the workers of a
+// single-pass algorithm and reports each further percent to a ProgressFunc
+type progressTracker struct {
+	ctx      context.Context
+	report   ProgressFunc
+	total    int64
+	done     atomic.Int64
+	mu       sync.Mutex
+	reported int64 // units done at the last report; guarded by mu
+}
+
+func newProgressTracker(ctx context.Context, report ProgressFunc, total int) *progressTracker {
+	return &progressTracker{ctx: ctx, report: report, total: int64(total)}
+}
+
+// add records n more units done and reports false once ctx is cancelled,
+// so the worker stops
+func (t *progressTracker) add(n int) bool {
+	done := t.done.Add(int64(n))
+	if t.report != nil {
+		t.mu.Lock()
+		if done-t.reported >= max(t.total/100, 1) || (done == t.total && t.reported < done) {
+			t.reported = done
+			t.report(Progress{Fraction: float64(done) / float64(t.total)})
+		}
+		t.mu.Unlock()
+	}
+	return t.ctx.Err() == nil
+}
@@ -0,0 +1,88 @@
+package parallelalgo
+
+import (
+	"context"
+	"math/rand/v2"
+)
+
+// WalkConfig configures RandomWalks
+type WalkConfig struct {
+	// WalksPerNode is the number of walks started from each start node
+	WalksPerNode int
+	// WalkLength is the number of steps in each walk
+	WalkLength int
+	// RestartProb is the chance, per step, of jumping back to the start node
+	RestartProb float64
+	// Seed makes the walks reproducible
+	Seed uint64
+	// Workers is the number of goroutines running walks
+	Workers int
+	// Progress, if set, is called as start nodes finish their walks
+	Progress ProgressFunc
+}
+
+// RandomWalks runs random walks with restart from each start node (every
+// node if starts is empty) and returns how often each node was visited,
+// start nodes included. A walk at a node without out-edges restarts.
+//
+// Each start node draws from its own generator seeded by Seed and the node
+// ID, so the counts do not depend on the number of workers. Workers check
+// ctx between start nodes.
+func RandomWalks(ctx context.Context, g CSRGraph, starts []NodeID, cfg WalkConfig) ([]int64, error) {
+	n := int(g.NodeCount())
+	if len(starts) == 0 {
+		starts = make([]NodeID, n)
+		for i := range starts {
+			starts[i] = NodeID(i)
+		}
+	}
+
+	ranges := chunks(len(starts), cfg.Workers)
+	counts := make([][]int64, len(ranges))
+	tasks := make([]func(), len(ranges))
+	progress := newProgressTracker(ctx, cfg.Progress, len(starts))
+	for w, r := range ranges {
+		tasks[w] = func() {
+			visits := make([]int64, n)
+			counts[w] = visits
+			for _, start := range starts[r[0]:r[1]] {
+				if int(start) < n {
+					rng := rand.New(rand.NewPCG(cfg.Seed, uint64(start)))
+					for range cfg.WalksPerNode {
+						walk(g, start, cfg, rng, visits)
+					}
+				}
+				if !progress.add(1) {
+					return
+				}
+			}
+		}
+	}
+	(&WorkerPool{workers: cfg.Workers}).Execute(tasks)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	total := make([]int64, n)
+	for _, visits := range counts {
+		for v, c := range visits {
+			total[v] += c
+		}
+	}
+	return total, nil
+}
+
+// walk performs one walk from start, adding its visits to visits
+func walk(g CSRGraph, start NodeID, cfg WalkConfig, rng *rand.Rand, visits []int64) {
+	current := start
+	visits[current]++
+	for range cfg.WalkLength {
+		out := g.Neighbors(current)
+		if len(out) == 0 || rng.Float64() < cfg.RestartProb {
+			current = start
+		} else {
+			current = out[rng.IntN(len(out))]
+		}
+		visits[current]++
+	}
+}
@@ -0,0 +1,198 @@
+package exprparser
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Precedence levels of the grammar, loosest first
+const (
+	precOr = iota + 1
+	precAnd
+	precNot
+	precCompare
+	precAdditive
+	precMultiplicative
+	precUnary
+	precPrimary
+)
+
+// precedence returns the binding level of e's outermost operator
+func precedence(e Expr) int {
+	switch e := e.(type) {
+	case *BinaryExpr:
+		switch e.Op {
+		case "OR":
+			return precOr
+		case "AND":
+			return precAnd
+		case "+", "-":
+			return precAdditive
+		case "*", "/", "%":
+			return precMultiplicative
+		}
+		return precCompare
+	case *UnaryExpr:
+		if e.Op == "NOT" {
+			return precNot
+		}
+		return precUnary
+	case *Literal:
+		// A negative number prints with its sign
+		if (e.Value.Kind == KindInt && e.Value.Int < 0) || (e.Value.Kind == KindFloat && e.Value.Float < 0) {
+			return precUnary
+		}
+	}
+	return precPrimary
+}
+
+// Format returns e in the parser's syntax with only the parentheses its
+// structure needs, unlike String, which parenthesizes every operation.
+// Parsing the Format of a parsed expression gives back the same tree.
+func Format(e Expr) string {
+	var b strings.Builder
+	format(&b, e)
+	return b.String()
+}
+
+func format(b *strings.Builder, e Expr) {
+	switch e := e.(type) {
+	case *BinaryExpr:
+		// Chains are left-associative and comparisons do not chain, so a
+		// right operand, or either side of a comparison, needs parentheses
+		// at the operator's own level
+		p := precedence(e)
+		leftMin := p
+		if p == precCompare {
+			leftMin = p + 1
+		}
+		formatOperand(b, e.Left, leftMin)
+		b.WriteString(" " + e.Op + " ")
+		formatOperand(b, e.Right, p+1)
+	case *UnaryExpr:
+		if e.Op == "NOT" {
+			b.WriteString("NOT ")
+			formatOperand(b, e.Operand, precNot)
+			return
+		}
+		b.WriteString(e.Op)
+		// Parenthesize a nested sign so it does not read as "--"
+		formatOperand(b, e.Operand, precPrimary)
+	case *CallExpr:
+		b.WriteString(e.Name + "(")
+		for i, arg := range e.Args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			format(b, arg)
+		}
+		b.WriteString(")")
+	case *Literal:
+		b.WriteString(formatLiteral(e))
+	default:
+		b.WriteString(e.String())
+	}
+}
+
+// formatOperand formats e, in parentheses if it binds looser than least
+func formatOperand(b *strings.Builder, e Expr, least int) {
+	if precedence(e) >= least {
+		format(b, e)
+		return
+	}
+	b.WriteString("(")
+	format(b, e)
+	b.WriteString(")")
+}
+
+// formatLiteral keeps a FLOAT without source text a FLOAT when parsed back
+func formatLiteral(e *Literal) string {
+	if e.Text != "" || e.Value.Kind != KindFloat {
+		return e.String()
+	}
+	s := strconv.FormatFloat(e.Value.Float, 'f', -1, 64)
+	if !strings.Contains(s, ".") {
+		s += ".0"
+	}
+	return s
+}
+
+// Normalize returns a canonical form of e, so that expressions that differ
+// only in the order of commutative operands, in parentheses or in how a
+// number was spelled have the same Format. It does not modify e.
+//
+//   - The operands of +, *, = and != are put in order by their Format.
+//   - <, >, <= and >= put their operands in the same order and turn the
+//     operator around, so b > a becomes a < b.
+//   - Chains of AND or OR are flattened and their operands sorted.
+//   - Literals drop their source text, so 1.50 and 1.5 agree.
+//
+// Longer + and * chains are not reassociated, because that would change
+// the rounding of FLOAT sums.
+func Normalize(e Expr) Expr {
+	switch e := e.(type) {
+	case *Literal:
+		return &Literal{Value: e.Value}
+	case *UnaryExpr:
+		return &UnaryExpr{Op: e.Op, Operand: Normalize(e.Operand)}
+	case *CallExpr:
+		args := make([]Expr, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = Normalize(arg)
+		}
+		return &CallExpr{Name: e.Name, Args: args}
+	case *BinaryExpr:
+		if e.Op == "AND" || e.Op == "OR" {
+			return normalizeChain(e)
+		}
+		left, right := Normalize(e.Left), Normalize(e.Right)
+		op := e.Op
+		if Format(left) > Format(right) {
+			switch op {
+			case "+", "*", "=", "!=":
+				left, right = right, left
+			case "<", ">", "<=", ">=":
+				left, right = right, left
+				op = flippedComparison[op]
+			}
+		}
+		return &BinaryExpr{Left: left, Op: op, Right: right}
+	}
+	return e
+}
+
+// flippedComparison maps a comparison to the one with swapped operands
+var flippedComparison = map[string]string{"<": ">", ">": "<", "<=": ">=", ">=": "<="}
+
+// normalizeChain flattens a chain of AND or OR, which three-valued logic
+// keeps associative and commutative, and rebuilds it from sorted operands
+func normalizeChain(e *BinaryExpr) Expr {
+	var operands []Expr
+	var collect func(Expr)
+	collect = func(x Expr) {
+		if b, ok := x.(*BinaryExpr); ok && b.Op == e.Op {
+			collect(b.Left)
+			collect(b.Right)
+			return
+		}
+		operands = append(operands, Normalize(x))
+	}
+	collect(e)
+
+	slices.SortStableFunc(operands, func(a, b Expr) int {
+		return strings.Compare(Format(a), Format(b))
+	})
+	chain := operands[0]
+	for _, operand := range operands[1:] {
+		chain = &BinaryExpr{Left: chain, Op: e.Op, Right: operand}
+	}
+	return chain
+}
+
+// Canonical returns the Format of e's normal form. Predicates with the
+// same canonical string are the same up to the rewrites of Normalize, so
+// it can key a plan cache or match the patterns of a rule engine.
+func Canonical(e Expr) string {
+	return Format(Normalize(e))
+}
@@ -0,0 +1,263 @@
+package exprparser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// NullPolicy describes how a function treats NULL arguments
+type NullPolicy int
+
+const (
+	// PropagateNull functions return NULL if any argument is NULL; Call
+	// never sees a NULL argument
+	PropagateNull NullPolicy = iota
+	// HandleNull functions receive NULL arguments and decide themselves
+	HandleNull
+)
+
+// Function is a scalar function callable from expressions
+type Function struct {
+	Name    string
+	MinArgs int
+	MaxArgs int // -1 for variadic
+	Nulls   NullPolicy
+	Call    func(args []Value) (Value, error)
+}
+
+func (f *Function) arity() string {
+	switch {
+	case f.MaxArgs < 0:
+		return fmt.Sprintf("at least %d argument(s)", f.MinArgs)
+	case f.MinArgs == f.MaxArgs:
+		return fmt.Sprintf("%d argument(s)", f.MinArgs)
+	default:
+		return fmt.Sprintf("%d to %d arguments", f.MinArgs, f.MaxArgs)
+	}
+}
+
+var (
+	functionsMu sync.RWMutex
+	functions   = make(map[string]*Function)
+)
+
+// RegisterFunction makes fn callable by name (case-insensitive), replacing
+// any function of the same name
+func RegisterFunction(fn Function) {
+	functionsMu.Lock()
+	defer functionsMu.Unlock()
+	fn.Name = strings.ToLower(fn.Name)
+	functions[fn.Name] = &fn
+}
+
+// LookupFunction returns the function registered under name
+func LookupFunction(name string) (*Function, bool) {
+	functionsMu.RLock()
+	defer functionsMu.RUnlock()
+	fn, ok := functions[strings.ToLower(name)]
+	return fn, ok
+}
+
+func init() {
+	for _, fn := range []Function{
+		// upper(s), lower(s), trim(s): NULL if s is NULL
+		{Name: "upper", MinArgs: 1, MaxArgs: 1, Call: stringFunc(strings.ToUpper)},
+		{Name: "lower", MinArgs: 1, MaxArgs: 1, Call: stringFunc(strings.ToLower)},
+		{Name: "trim", MinArgs: 1, MaxArgs: 1, Call: stringFunc(strings.TrimSpace)},
+		// substr(s, start[, length]): NULL if any argument is NULL
+		{Name: "substr", MinArgs: 2, MaxArgs: 3, Call: substr},
+		// concat(args...): NULL arguments are skipped; NULL only if all are
+		{Name: "concat", MinArgs: 1, MaxArgs: -1, Nulls: HandleNull, Call: concat},
+		// round(x[, digits]), floor(x), ceil(x): NULL if any argument is NULL
+		{Name: "round", MinArgs: 1, MaxArgs: 2, Call: round},
+		{Name: "floor", MinArgs: 1, MaxArgs: 1, Call: roundingFunc(math.Floor)},
+		{Name: "ceil", MinArgs: 1, MaxArgs: 1, Call: roundingFunc(math.Ceil)},
+		// pow(x, y): NULL if either argument is NULL
+		{Name: "pow", MinArgs: 2, MaxArgs: 2, Call: pow},
+		// coalesce(args...): first non-NULL argument, NULL if all are NULL
+		{Name: "coalesce", MinArgs: 1, MaxArgs: -1, Nulls: HandleNull, Call: coalesce},
+		// nullif(a, b): NULL if a equals b, otherwise a; a NULL b never matches
+		{Name: "nullif", MinArgs: 2, MaxArgs: 2, Nulls: HandleNull, Call: nullif},
+	} {
+		RegisterFunction(fn)
+	}
+}
+
+// stringFunc adapts a string transformation to a one-argument function
+func stringFunc(f func(string) string) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		if args[0].Kind != KindString {
+			return Value{}, fmt.Errorf("%w: expected STRING, got %s", ErrTypeMismatch, args[0].Kind)
+		}
+		return StringValue(f(args[0].Str)), nil
+	}
+}
+
+// substr returns length characters of s starting at the 1-based position
+// start. As in SQL, a start before 1 still counts toward length, and the
+// result is clipped to the string.
+func substr(args []Value) (Value, error) {
+	if args[0].Kind != KindString || args[1].Kind != KindInt || (len(args) == 3 && args[2].Kind != KindInt) {
+		return Value{}, fmt.Errorf("%w: substr(STRING, INT[, INT])", ErrTypeMismatch)
+	}
+
+	runes := []rune(args[0].Str)
+	start := args[1].Int
+	end := int64(len(runes)) + 1
+	if len(args) == 3 {
+		n := args[2].Int
+		if n < 0 {
+			return Value{}, fmt.Errorf("negative substring length %d", n)
+		}
+		if start < 1 {
+			// Positions before 1 use up length; count in uint64 so that
+			// 1-start cannot overflow
+			skip := uint64(1) - uint64(start)
+			if uint64(n) <= skip {
+				return StringValue(""), nil
+			}
+			n -= int64(skip)
+			start = 1
+		}
+		if n < end-start {
+			end = start + n
+		}
+	}
+	start = max(start, 1)
+	if start >= end {
+		return StringValue(""), nil
+	}
+	return StringValue(string(runes[start-1 : end-1])), nil
+}
+
+func concat(args []Value) (Value, error) {
+	var sb strings.Builder
+	allNull := true
+	for _, arg := range args {
+		if arg.IsNull() {
+			continue
+		}
+		allNull = false
+		sb.WriteString(arg.String())
+	}
+	if allNull {
+		return Null(), nil
+	}
+	return StringValue(sb.String()), nil
+}
+
+// round rounds half away from zero to the given number of decimal digits.
+// Integers are returned unchanged for non-negative digits.
+func round(args []Value) (Value, error) {
+	x := args[0]
+	if !x.isNumeric() || (len(args) == 2 && args[1].Kind != KindInt) {
+		return Value{}, fmt.Errorf("%w: round(NUMBER[, INT])", ErrTypeMismatch)
+	}
+	var digits int64
+	if len(args) == 2 {
+		digits = args[1].Int
+	}
+
+	if x.Kind == KindInt {
+		if digits >= 0 {
+			return x, nil
+		}
+		r, ok := roundInt(x.Int, -digits)
+		if !ok {
+			return Value{}, fmt.Errorf("%w: round(%d, %d)", ErrIntegerOverflow, x.Int, digits)
+		}
+		return IntValue(r), nil
+	}
+	if digits < 0 {
+		scale := math.Pow(10, float64(-digits))
+		if math.IsInf(scale, 1) {
+			return FloatValue(math.Copysign(0, x.Float)), nil
+		}
+		return FloatValue(math.Round(x.Float/scale) * scale), nil
+	}
+	// Past the precision of a float64 there is nothing left to round
+	scale := math.Pow(10, float64(digits))
+	if math.IsInf(x.Float*scale, 0) {
+		return x, nil
+	}
+	return FloatValue(math.Round(x.Float*scale) / scale), nil
+}
+
+// roundInt rounds x half away from zero to a multiple of 10^k in integer
+// arithmetic, reporting false if the result does not fit in an int64
+func roundInt(x, k int64) (int64, bool) {
+	if k > 19 {
+		// 10^20 is more than twice any int64, so everything rounds to 0
+		return 0, true
+	}
+	p := uint64(1)
+	for range k {
+		p *= 10
+	}
+	abs := uint64(x)
+	if x < 0 {
+		abs = -abs
+	}
+	q, r := abs/p, abs%p
+	if r >= p-r {
+		q++
+	}
+	limit := uint64(math.MaxInt64)
+	if x < 0 {
+		limit++
+	}
+	if q > limit/p {
+		return 0, false
+	}
+	if x < 0 {
+		return int64(-(q * p)), true
+	}
+	return int64(q * p), true
+}
+
+// roundingFunc adapts floor or ceil; integers are returned unchanged
+func roundingFunc(f func(float64) float64) func([]Value) (Value, error) {
+	return func(args []Value) (Value, error) {
+		switch args[0].Kind {
+		case KindInt:
+			return args[0], nil
+		case KindFloat:
+			return FloatValue(f(args[0].Float)), nil
+		}
+		return Value{}, fmt.Errorf("%w: expected number, got %s", ErrTypeMismatch, args[0].Kind)
+	}
+}
+
+// pow always returns a FLOAT
+func pow(args []Value) (Value, error) {
+	if !args[0].isNumeric() || !args[1].isNumeric() {
+		return Value{}, fmt.Errorf("%w: pow(NUMBER, NUMBER)", ErrTypeMismatch)
+	}
+	return FloatValue(math.Pow(args[0].asFloat(), args[1].asFloat())), nil
+}
+
+func coalesce(args []Value) (Value, error) {
+	for _, arg := range args {
+		if !arg.IsNull() {
+			return arg, nil
+		}
+	}
+	return Null(), nil
+}
+
+func nullif(args []Value) (Value, error) {
+	a, b := args[0], args[1]
+	if a.IsNull() || b.IsNull() {
+		return a, nil
+	}
+	c, err := compareValues(a, b)
+	if err != nil {
+		return Value{}, err
+	}
+	if c == 0 {
+		return Null(), nil
+	}
+	return a, nil
+}
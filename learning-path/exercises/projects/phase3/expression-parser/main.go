@@ -1,6 +1,12 @@
 package exprparser
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
 
 // TokenType represents different token types
 type TokenType int
@@ -15,12 +21,65 @@ const (
 	TokenLParen
 	TokenRParen
 	TokenEOF
+	TokenString
+	TokenComma
+	TokenPercent
+	TokenEq
+	TokenNeq
+	TokenLt
+	TokenGt
+	TokenLe
+	TokenGe
+	TokenAnd
+	TokenOr
+	TokenNot
+	TokenTrue
+	TokenFalse
+	TokenNull
+	TokenIllegal
 )
 
+// keywords maps upper-cased identifiers to their keyword tokens
+var keywords = map[string]TokenType{
+	"AND":   TokenAnd,
+	"OR":    TokenOr,
+	"NOT":   TokenNot,
+	"TRUE":  TokenTrue,
+	"FALSE": TokenFalse,
+	"NULL":  TokenNull,
+}
+
+// singleCharTokens maps operator characters that never start a longer token
+var singleCharTokens = map[byte]TokenType{
+	'+': TokenPlus, '-': TokenMinus, '*': TokenStar, '/': TokenSlash, '%': TokenPercent,
+	'(': TokenLParen, ')': TokenRParen, ',': TokenComma, '=': TokenEq,
+}
+
 // Token represents a lexical token
 type Token struct {
 	Type  TokenType
 	Value string
+	Pos   int
+}
+
+// Evaluation errors
+var (
+	ErrUnknownVariable = errors.New("unknown variable")
+	ErrUnknownFunction = errors.New("unknown function")
+	ErrTypeMismatch    = errors.New("type mismatch")
+	ErrDivisionByZero  = errors.New("division by zero")
+	ErrIntegerOverflow = errors.New("integer overflow")
+	ErrArgCount        = errors.New("wrong number of arguments")
+)
+
+// ParseError reports a syntax error and where it occurred
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("parse error at position %d: %s", e.Pos, e.Msg)
 }
 
 // Expr is the base interface for all expressions
@@ -32,9 +91,163 @@ type Expr interface {
 // Context provides variable bindings for evaluation
 type Context map[string]Value
 
-// Value represents a runtime value
+// Kind identifies the type held by a Value
+type Kind int
+
+const (
+	KindNull Kind = iota
+	KindInt
+	KindFloat
+	KindString
+	KindBool
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNull:
+		return "NULL"
+	case KindInt:
+		return "INT"
+	case KindFloat:
+		return "FLOAT"
+	case KindString:
+		return "STRING"
+	case KindBool:
+		return "BOOL"
+	default:
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+}
+
+// Value represents a runtime value. The zero Value is NULL.
 type Value struct {
-	// TODO: Support int, float, string, bool
+	Kind  Kind
+	Int   int64
+	Float float64
+	Str   string
+	Bool  bool
+}
+
+// Null returns the NULL value
+func Null() Value { return Value{} }
+
+// IntValue returns an integer value
+func IntValue(i int64) Value { return Value{Kind: KindInt, Int: i} }
+
+// FloatValue returns a floating-point value
+func FloatValue(f float64) Value { return Value{Kind: KindFloat, Float: f} }
+
+// StringValue returns a string value
+func StringValue(s string) Value { return Value{Kind: KindString, Str: s} }
+
+// BoolValue returns a boolean value
+func BoolValue(b bool) Value { return Value{Kind: KindBool, Bool: b} }
+
+// IsNull reports whether v is NULL
+func (v Value) IsNull() bool { return v.Kind == KindNull }
+
+// isNumeric reports whether v is an INT or FLOAT
+func (v Value) isNumeric() bool { return v.Kind == KindInt || v.Kind == KindFloat }
+
+// asFloat converts a numeric value to float64
+func (v Value) asFloat() float64 {
+	if v.Kind == KindInt {
+		return float64(v.Int)
+	}
+	return v.Float
+}
+
+func (v Value) String() string {
+	switch v.Kind {
+	case KindInt:
+		return strconv.FormatInt(v.Int, 10)
+	case KindFloat:
+		return strconv.FormatFloat(v.Float, 'g', -1, 64)
+	case KindString:
+		return v.Str
+	case KindBool:
+		if v.Bool {
+			return "TRUE"
+		}
+		return "FALSE"
+	default:
+		return "NULL"
+	}
+}
+
+// Literal is a constant value
+type Literal struct {
+	Value Value
+	Text  string // source text, used by String
+}
+
+func (e *Literal) Eval(ctx Context) (Value, error) {
+	return e.Value, nil
+}
+
+func (e *Literal) String() string {
+	if e.Text != "" {
+		return e.Text
+	}
+	if e.Value.Kind == KindString {
+		return "'" + strings.ReplaceAll(e.Value.Str, "'", "''") + "'"
+	}
+	return e.Value.String()
+}
+
+// Ident is a variable reference resolved from the Context
+type Ident struct {
+	Name string
+}
+
+func (e *Ident) Eval(ctx Context) (Value, error) {
+	v, ok := ctx[e.Name]
+	if !ok {
+		return Value{}, fmt.Errorf("%w: %s", ErrUnknownVariable, e.Name)
+	}
+	return v, nil
+}
+
+func (e *Ident) String() string {
+	return e.Name
+}
+
+// UnaryExpr represents negation and logical NOT
+type UnaryExpr struct {
+	Op      string
+	Operand Expr
+}
+
+func (e *UnaryExpr) Eval(ctx Context) (Value, error) {
+	v, err := e.Operand.Eval(ctx)
+	if err != nil || v.IsNull() {
+		return Null(), err
+	}
+
+	switch e.Op {
+	case "-":
+		switch v.Kind {
+		case KindInt:
+			if v.Int == math.MinInt64 {
+				return Value{}, fmt.Errorf("%w: -(%d)", ErrIntegerOverflow, v.Int)
+			}
+			return IntValue(-v.Int), nil
+		case KindFloat:
+			return FloatValue(-v.Float), nil
+		}
+	case "NOT":
+		if v.Kind == KindBool {
+			return BoolValue(!v.Bool), nil
+		}
+	}
+	return Value{}, fmt.Errorf("%w: %s %s", ErrTypeMismatch, e.Op, v.Kind)
+}
+
+func (e *UnaryExpr) String() string {
+	if e.Op == "NOT" {
+		return fmt.Sprintf("(NOT %s)", e.Operand)
+	}
+	return fmt.Sprintf("(%s%s)", e.Op, e.Operand)
 }
 
 // BinaryExpr represents binary operations
@@ -44,15 +257,219 @@ type BinaryExpr struct {
 	Right Expr
 }
 
+// Eval evaluates the operation with SQL NULL semantics: arithmetic and
+// comparison with a NULL operand yield NULL, while AND and OR use
+// three-valued logic (FALSE AND NULL is FALSE, TRUE OR NULL is TRUE).
 func (e *BinaryExpr) Eval(ctx Context) (Value, error) {
-	// TODO: Implement evaluation
-	return Value{}, nil
+	left, err := e.Left.Eval(ctx)
+	if err != nil {
+		return Value{}, err
+	}
+	right, err := e.Right.Eval(ctx)
+	if err != nil {
+		return Value{}, err
+	}
+
+	switch e.Op {
+	case "AND", "OR":
+		return evalLogical(e.Op, left, right)
+	}
+	if left.IsNull() || right.IsNull() {
+		return Null(), nil
+	}
+
+	switch e.Op {
+	case "+", "-", "*", "/", "%":
+		return evalArithmetic(e.Op, left, right)
+	case "=", "!=", "<", ">", "<=", ">=":
+		c, err := compareValues(left, right)
+		if err != nil {
+			return Value{}, err
+		}
+		return BoolValue(compareResult(e.Op, c)), nil
+	}
+	return Value{}, fmt.Errorf("unknown operator %q", e.Op)
 }
 
 func (e *BinaryExpr) String() string {
 	return fmt.Sprintf("(%s %s %s)", e.Left, e.Op, e.Right)
 }
 
+func evalLogical(op string, left, right Value) (Value, error) {
+	for _, v := range []Value{left, right} {
+		if !v.IsNull() && v.Kind != KindBool {
+			return Value{}, fmt.Errorf("%w: %s %s", ErrTypeMismatch, op, v.Kind)
+		}
+	}
+
+	// The dominant value decides regardless of NULLs
+	dominant := op == "OR"
+	if (!left.IsNull() && left.Bool == dominant) || (!right.IsNull() && right.Bool == dominant) {
+		return BoolValue(dominant), nil
+	}
+	if left.IsNull() || right.IsNull() {
+		return Null(), nil
+	}
+	return BoolValue(!dominant), nil
+}
+
+func evalArithmetic(op string, left, right Value) (Value, error) {
+	if !left.isNumeric() || !right.isNumeric() {
+		return Value{}, fmt.Errorf("%w: %s %s %s", ErrTypeMismatch, left.Kind, op, right.Kind)
+	}
+
+	if left.Kind == KindInt && right.Kind == KindInt {
+		a, b := left.Int, right.Int
+		var r int64
+		switch op {
+		case "+":
+			r = a + b
+			if (b > 0 && r < a) || (b < 0 && r > a) {
+				return Value{}, fmt.Errorf("%w: %d + %d", ErrIntegerOverflow, a, b)
+			}
+		case "-":
+			r = a - b
+			if (b > 0 && r > a) || (b < 0 && r < a) {
+				return Value{}, fmt.Errorf("%w: %d - %d", ErrIntegerOverflow, a, b)
+			}
+		case "*":
+			r = a * b
+			if a != 0 && (r/a != b || (a == -1 && b == math.MinInt64)) {
+				return Value{}, fmt.Errorf("%w: %d * %d", ErrIntegerOverflow, a, b)
+			}
+		case "/", "%":
+			if b == 0 {
+				return Value{}, ErrDivisionByZero
+			}
+			if op == "%" {
+				// MinInt64 % -1 is 0 in Go, which is also the exact result
+				return IntValue(a % b), nil
+			}
+			if a == math.MinInt64 && b == -1 {
+				return Value{}, fmt.Errorf("%w: %d / %d", ErrIntegerOverflow, a, b)
+			}
+			r = a / b
+		}
+		return IntValue(r), nil
+	}
+
+	a, b := left.asFloat(), right.asFloat()
+	switch op {
+	case "+":
+		return FloatValue(a + b), nil
+	case "-":
+		return FloatValue(a - b), nil
+	case "*":
+		return FloatValue(a * b), nil
+	case "/":
+		if b == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		return FloatValue(a / b), nil
+	default:
+		if b == 0 {
+			return Value{}, ErrDivisionByZero
+		}
+		return FloatValue(math.Mod(a, b)), nil
+	}
+}
+
+// compareValues orders two non-NULL values of compatible kinds
+func compareValues(left, right Value) (int, error) {
+	switch {
+	case left.isNumeric() && right.isNumeric():
+		if left.Kind == KindInt && right.Kind == KindInt {
+			return cmpOrdered(left.Int, right.Int), nil
+		}
+		return cmpOrdered(left.asFloat(), right.asFloat()), nil
+	case left.Kind == KindString && right.Kind == KindString:
+		return strings.Compare(left.Str, right.Str), nil
+	case left.Kind == KindBool && right.Kind == KindBool:
+		if left.Bool == right.Bool {
+			return 0, nil
+		}
+		if right.Bool {
+			return -1, nil
+		}
+		return 1, nil
+	}
+	return 0, fmt.Errorf("%w: cannot compare %s with %s", ErrTypeMismatch, left.Kind, right.Kind)
+}
+
+func cmpOrdered[T int64 | float64](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareResult(op string, c int) bool {
+	switch op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case ">":
+		return c > 0
+	case "<=":
+		return c <= 0
+	default:
+		return c >= 0
+	}
+}
+
+// CallExpr is a function call
+type CallExpr struct {
+	Name string
+	Args []Expr
+}
+
+// Eval evaluates the arguments and calls the registered function. For
+// functions with the PropagateNull policy a NULL argument makes the result
+// NULL without calling the function.
+func (e *CallExpr) Eval(ctx Context) (Value, error) {
+	fn, ok := LookupFunction(e.Name)
+	if !ok {
+		return Value{}, fmt.Errorf("%w: %s", ErrUnknownFunction, e.Name)
+	}
+	if len(e.Args) < fn.MinArgs || (fn.MaxArgs >= 0 && len(e.Args) > fn.MaxArgs) {
+		return Value{}, fmt.Errorf("%w: %s takes %s, got %d", ErrArgCount, fn.Name, fn.arity(), len(e.Args))
+	}
+
+	args := make([]Value, len(e.Args))
+	hasNull := false
+	for i, arg := range e.Args {
+		v, err := arg.Eval(ctx)
+		if err != nil {
+			return Value{}, err
+		}
+		args[i] = v
+		hasNull = hasNull || v.IsNull()
+	}
+	if hasNull && fn.Nulls == PropagateNull {
+		return Null(), nil
+	}
+
+	v, err := fn.Call(args)
+	if err != nil {
+		return Value{}, fmt.Errorf("%s: %w", fn.Name, err)
+	}
+	return v, nil
+}
+
+func (e *CallExpr) String() string {
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = arg.String()
+	}
+	return e.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
 // Lexer tokenizes input
 type Lexer struct {
 	input string
@@ -64,10 +481,90 @@ func NewLexer(input string) *Lexer {
 }
 
 func (l *Lexer) NextToken() Token {
-	// TODO: Implement lexer
-	return Token{Type: TokenEOF}
+	for l.pos < len(l.input) && isSpace(l.input[l.pos]) {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos >= len(l.input) {
+		return Token{Type: TokenEOF, Pos: start}
+	}
+
+	ch := l.input[l.pos]
+	switch {
+	case isDigit(ch) || (ch == '.' && l.pos+1 < len(l.input) && isDigit(l.input[l.pos+1])):
+		for l.pos < len(l.input) && (isDigit(l.input[l.pos]) || l.input[l.pos] == '.') {
+			l.pos++
+		}
+		return Token{Type: TokenNumber, Value: l.input[start:l.pos], Pos: start}
+	case isLetter(ch):
+		for l.pos < len(l.input) && (isLetter(l.input[l.pos]) || isDigit(l.input[l.pos])) {
+			l.pos++
+		}
+		word := l.input[start:l.pos]
+		if kw, ok := keywords[strings.ToUpper(word)]; ok {
+			return Token{Type: kw, Value: strings.ToUpper(word), Pos: start}
+		}
+		return Token{Type: TokenIdent, Value: word, Pos: start}
+	case ch == '\'':
+		return l.lexString()
+	}
+
+	l.pos++
+	if tt, ok := singleCharTokens[ch]; ok {
+		return Token{Type: tt, Value: string(ch), Pos: start}
+	}
+
+	next := byte(0)
+	if l.pos < len(l.input) {
+		next = l.input[l.pos]
+	}
+	switch {
+	case ch == '!' && next == '=':
+		l.pos++
+		return Token{Type: TokenNeq, Value: "!=", Pos: start}
+	case ch == '<' && next == '>':
+		l.pos++
+		return Token{Type: TokenNeq, Value: "!=", Pos: start}
+	case ch == '<' && next == '=':
+		l.pos++
+		return Token{Type: TokenLe, Value: "<=", Pos: start}
+	case ch == '>' && next == '=':
+		l.pos++
+		return Token{Type: TokenGe, Value: ">=", Pos: start}
+	case ch == '<':
+		return Token{Type: TokenLt, Value: "<", Pos: start}
+	case ch == '>':
+		return Token{Type: TokenGt, Value: ">", Pos: start}
+	}
+	return Token{Type: TokenIllegal, Value: string(ch), Pos: start}
 }
 
+// lexString reads a single-quoted string; a doubled quote escapes a quote
+func (l *Lexer) lexString() Token {
+	start := l.pos
+	l.pos++ // opening quote
+	var sb strings.Builder
+	for l.pos < len(l.input) {
+		ch := l.input[l.pos]
+		l.pos++
+		if ch != '\'' {
+			sb.WriteByte(ch)
+			continue
+		}
+		if l.pos < len(l.input) && l.input[l.pos] == '\'' {
+			sb.WriteByte('\'')
+			l.pos++
+			continue
+		}
+		return Token{Type: TokenString, Value: sb.String(), Pos: start}
+	}
+	return Token{Type: TokenIllegal, Value: "unterminated string", Pos: start}
+}
+
+func isSpace(ch byte) bool  { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
+func isDigit(ch byte) bool  { return ch >= '0' && ch <= '9' }
+func isLetter(ch byte) bool { return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
+
 // Parser parses expressions
 type Parser struct {
 	lexer   *Lexer
@@ -75,12 +572,194 @@ type Parser struct {
 }
 
 func NewParser(input string) *Parser {
-	return &Parser{
+	p := &Parser{
 		lexer: NewLexer(input),
 	}
+	p.advance()
+	return p
+}
+
+func (p *Parser) advance() {
+	p.current = p.lexer.NextToken()
 }
 
+func (p *Parser) errorf(format string, args ...any) error {
+	return &ParseError{Pos: p.current.Pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// ParseExpression parses a complete expression. Precedence from lowest to
+// highest: OR, AND, NOT, comparisons, + -, * / %, unary minus.
 func (p *Parser) ParseExpression() (Expr, error) {
-	// TODO: Implement recursive descent parser
-	return nil, nil
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.current.Type != TokenEOF {
+		return nil, p.errorf("unexpected %q", p.current.Value)
+	}
+	return expr, nil
+}
+
+// Parse parses input as a single expression
+func Parse(input string) (Expr, error) {
+	return NewParser(input).ParseExpression()
+}
+
+func (p *Parser) parseOr() (Expr, error) {
+	return p.parseBinary(p.parseAnd, TokenOr)
+}
+
+func (p *Parser) parseAnd() (Expr, error) {
+	return p.parseBinary(p.parseNot, TokenAnd)
+}
+
+func (p *Parser) parseNot() (Expr, error) {
+	if p.current.Type == TokenNot {
+		p.advance()
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "NOT", Operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *Parser) parseComparison() (Expr, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	switch p.current.Type {
+	case TokenEq, TokenNeq, TokenLt, TokenGt, TokenLe, TokenGe:
+		op := p.current.Value
+		p.advance()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{Left: left, Op: op, Right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *Parser) parseAdditive() (Expr, error) {
+	return p.parseBinary(p.parseMultiplicative, TokenPlus, TokenMinus)
+}
+
+func (p *Parser) parseMultiplicative() (Expr, error) {
+	return p.parseBinary(p.parseUnary, TokenStar, TokenSlash, TokenPercent)
+}
+
+// parseBinary parses a left-associative chain of operators
+func (p *Parser) parseBinary(operand func() (Expr, error), ops ...TokenType) (Expr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.matches(ops...) {
+		op := p.current.Value
+		p.advance()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &BinaryExpr{Left: left, Op: op, Right: right}
+	}
+	return left, nil
+}
+
+func (p *Parser) matches(types ...TokenType) bool {
+	for _, t := range types {
+		if p.current.Type == t {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Parser) parseUnary() (Expr, error) {
+	if p.current.Type == TokenMinus {
+		p.advance()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Op: "-", Operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *Parser) parsePrimary() (Expr, error) {
+	tok := p.current
+	switch tok.Type {
+	case TokenNumber:
+		p.advance()
+		if i, err := strconv.ParseInt(tok.Value, 10, 64); err == nil {
+			return &Literal{Value: IntValue(i), Text: tok.Value}, nil
+		}
+		f, err := strconv.ParseFloat(tok.Value, 64)
+		if err != nil {
+			return nil, &ParseError{Pos: tok.Pos, Msg: fmt.Sprintf("invalid number %q", tok.Value)}
+		}
+		return &Literal{Value: FloatValue(f), Text: tok.Value}, nil
+	case TokenString:
+		p.advance()
+		return &Literal{Value: StringValue(tok.Value)}, nil
+	case TokenTrue, TokenFalse:
+		p.advance()
+		return &Literal{Value: BoolValue(tok.Type == TokenTrue)}, nil
+	case TokenNull:
+		p.advance()
+		return &Literal{Value: Null()}, nil
+	case TokenIdent:
+		p.advance()
+		if p.current.Type == TokenLParen {
+			return p.parseCall(tok.Value)
+		}
+		return &Ident{Name: tok.Value}, nil
+	case TokenLParen:
+		p.advance()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.current.Type != TokenRParen {
+			return nil, p.errorf("expected ')'")
+		}
+		p.advance()
+		return expr, nil
+	case TokenEOF:
+		return nil, p.errorf("unexpected end of input")
+	case TokenIllegal:
+		return nil, p.errorf("illegal token %q", tok.Value)
+	}
+	return nil, p.errorf("unexpected %q", tok.Value)
+}
+
+// parseCall parses the argument list of a function call; the current token
+// is the opening parenthesis
+func (p *Parser) parseCall(name string) (Expr, error) {
+	p.advance()
+	call := &CallExpr{Name: strings.ToLower(name)}
+	if p.current.Type == TokenRParen {
+		p.advance()
+		return call, nil
+	}
+	for {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		call.Args = append(call.Args, arg)
+		switch p.current.Type {
+		case TokenComma:
+			p.advance()
+		case TokenRParen:
+			p.advance()
+			return call, nil
+		default:
+			return nil, p.errorf("expected ',' or ')' in call to %s", name)
+		}
+	}
 }
@@ -1,6 +1,10 @@
 package exprparser
 
-import "testing"
+import (
+	"errors"
+	"math"
+	"testing"
+)
 
 func TestParse(t *testing.T) {
 	tests := []struct {
@@ -10,22 +14,280 @@ func TestParse(t *testing.T) {
 		{"1 + 2", "(1 + 2)"},
 		{"1 + 2 * 3", "(1 + (2 * 3))"},
 		{"(1 + 2) * 3", "((1 + 2) * 3)"},
+		{"a > 5 AND b < 10 OR c = 20", "(((a > 5) AND (b < 10)) OR (c = 20))"},
+		{"NOT -x = 1", "(NOT ((-x) = 1))"},
+		{"upper(name, 'it''s')", "upper(name, 'it''s')"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.input, func(t *testing.T) {
-			// TODO: Parse and verify AST
-			t.Skip("not implemented")
+			expr, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := expr.String(); got != tt.want {
+				t.Errorf("Parse(%q) = %s, want %s", tt.input, got, tt.want)
+			}
 		})
 	}
 }
 
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"((1 + 2))", "1 + 2"},
+		{"1 + (2 * 3)", "1 + 2 * 3"},
+		{"(1 + 2) * 3", "(1 + 2) * 3"},
+		{"a - (b - c)", "a - (b - c)"},
+		{"(a - b) - c", "a - b - c"},
+		{"(a > 5 AND b < 10) OR c = 20", "a > 5 AND b < 10 OR c = 20"},
+		{"a AND (b OR c)", "a AND (b OR c)"},
+		{"NOT (a = 1)", "NOT a = 1"},
+		{"NOT (a AND b)", "NOT (a AND b)"},
+		{"(a = b) = c", "(a = b) = c"},
+		{"- (-x)", "-(-x)"},
+		{"-(a + b) * 2", "-(a + b) * 2"},
+		{"upper((name))", "upper(name)"},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := Format(expr)
+		if got != tt.want {
+			t.Errorf("Format(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+		// Parsing the output gives the same tree
+		if back, err := Parse(got); err != nil || back.String() != expr.String() {
+			t.Errorf("Parse(%q) = %v, %v; want %s", got, back, err, expr)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	same := [][]string{
+		{"a + b", "b + a", "(b) + a"},
+		{"x > 5", "5 < x"},
+		{"a = 1 AND b = 2 AND c", "c AND (2 = b AND a = 1)", "b = 2 AND (c AND 1 = a)"},
+		{"x = 1.50", "x = 1.5"},
+		{"f(b + a, c)", "f(a + b, c)"},
+	}
+	for _, group := range same {
+		want, _ := Parse(group[0])
+		for _, input := range group[1:] {
+			expr, err := Parse(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if Canonical(expr) != Canonical(want) {
+				t.Errorf("Canonical(%q) = %q, Canonical(%q) = %q", input, Canonical(expr), group[0], Canonical(want))
+			}
+		}
+	}
+
+	different := [][2]string{
+		{"a - b", "b - a"},
+		{"x > 5", "x < 5"},
+		{"a AND b OR c", "a AND (b OR c)"},
+		{"f(a, b)", "f(b, a)"},
+		{"x = 1", "x = 1.0"},
+	}
+	for _, pair := range different {
+		a, _ := Parse(pair[0])
+		b, _ := Parse(pair[1])
+		if Canonical(a) == Canonical(b) {
+			t.Errorf("%q and %q share the canonical form %q", pair[0], pair[1], Canonical(a))
+		}
+	}
+
+	// Normalization keeps the value and leaves its input alone
+	expr, _ := Parse("NOT (3 > a) OR b * 2 >= 1.0")
+	before := expr.String()
+	ctx := Context{"a": IntValue(4), "b": FloatValue(0.25)}
+	want, _ := expr.Eval(ctx)
+	if got, _ := Normalize(expr).Eval(ctx); got != want {
+		t.Errorf("normalized form evaluates to %v, want %v", got, want)
+	}
+	if expr.String() != before {
+		t.Errorf("Normalize modified its input: %s", expr)
+	}
+}
+
 func TestEval(t *testing.T) {
-	// TODO: Test expression evaluation
-	t.Skip("not implemented")
+	ctx := Context{"a": IntValue(7), "b": FloatValue(2.5), "n": Null(), "s": StringValue("x"),
+		"min": IntValue(math.MinInt64), "max": IntValue(math.MaxInt64)}
+	tests := []struct {
+		input string
+		want  Value
+	}{
+		{"1 + 2 * 3", IntValue(7)},
+		{"7 / 2", IntValue(3)},
+		{"a % 4", IntValue(3)},
+		{"min % -1", IntValue(0)},
+		{"max - 1 + 1", IntValue(math.MaxInt64)},
+		{"min / 2 * 2", IntValue(math.MinInt64)},
+		{"a * b", FloatValue(17.5)},
+		{"a > 5 AND s = 'x'", BoolValue(true)},
+		{"n + 1", Null()},
+		{"n = n", Null()},
+		{"FALSE AND n", BoolValue(false)},
+		{"TRUE OR n", BoolValue(true)},
+		{"TRUE AND n", Null()},
+		{"NOT n", Null()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := expr.Eval(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval(%q) = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
 }
 
 func TestErrors(t *testing.T) {
-	// TODO: Test error messages
-	t.Skip("not implemented")
+	for _, input := range []string{"1 + + ", "(1 + 2", "1 2", "'open", "f(1,", "3 # 4"} {
+		var perr *ParseError
+		if _, err := Parse(input); !errors.As(err, &perr) {
+			t.Errorf("Parse(%q) error = %v, want *ParseError", input, err)
+		}
+	}
+
+	evalTests := []struct {
+		input string
+		want  error
+	}{
+		{"missing + 1", ErrUnknownVariable},
+		{"nosuch(1)", ErrUnknownFunction},
+		{"1 / 0", ErrDivisionByZero},
+		{"'a' + 1", ErrTypeMismatch},
+		{"upper()", ErrArgCount},
+		{"upper(1)", ErrTypeMismatch},
+		{"max + 1", ErrIntegerOverflow},
+		{"min - 1", ErrIntegerOverflow},
+		{"max * 2", ErrIntegerOverflow},
+		{"min * -1", ErrIntegerOverflow},
+		{"min / -1", ErrIntegerOverflow},
+		{"-min", ErrIntegerOverflow},
+		{"round(max, -19)", ErrIntegerOverflow},
+		{"round(min, -19)", ErrIntegerOverflow},
+	}
+	ctx := Context{"min": IntValue(math.MinInt64), "max": IntValue(math.MaxInt64)}
+	for _, tt := range evalTests {
+		expr, err := Parse(tt.input)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.input, err)
+		}
+		if _, err := expr.Eval(ctx); !errors.Is(err, tt.want) {
+			t.Errorf("Eval(%q) error = %v, want %v", tt.input, err, tt.want)
+		}
+	}
+}
+
+func TestFunctions(t *testing.T) {
+	ctx := Context{"n": Null(), "min": IntValue(math.MinInt64), "max": IntValue(math.MaxInt64), "huge": FloatValue(1e300)}
+	tests := []struct {
+		input string
+		want  Value
+	}{
+		{"upper('abc')", StringValue("ABC")},
+		{"UPPER(n)", Null()},
+		{"lower('AbC')", StringValue("abc")},
+		{"lower(n)", Null()},
+		{"trim('  hi ')", StringValue("hi")},
+		{"trim(n)", Null()},
+
+		{"substr('héllo', 2, 3)", StringValue("éll")},
+		{"substr('hello', 3)", StringValue("llo")},
+		{"substr('hello', 0, 2)", StringValue("h")},
+		{"substr('hello', 9)", StringValue("")},
+		{"substr('abc', 2, 9223372036854775807)", StringValue("bc")},
+		{"substr('abc', 0, 9223372036854775807)", StringValue("abc")},
+		{"substr('abc', min, 9223372036854775807)", StringValue("")},
+		{"substr('abc', min, max)", StringValue("")},
+		{"substr(n, 1)", Null()},
+		{"substr('hello', n)", Null()},
+
+		{"concat('a', 1, 'b')", StringValue("a1b")},
+		{"concat('a', n, 'b')", StringValue("ab")},
+		{"concat(n, n)", Null()},
+
+		{"round(2.5)", FloatValue(3)},
+		{"round(-2.5)", FloatValue(-3)},
+		{"round(3.14159, 2)", FloatValue(3.14)},
+		{"round(1250, -2)", IntValue(1300)},
+		{"round(7)", IntValue(7)},
+		{"round(1.5, 400)", FloatValue(1.5)},
+		{"round(huge, 20)", FloatValue(1e300)},
+		{"round(123.4, -400)", FloatValue(0)},
+		{"round(max, -400)", IntValue(0)},
+		{"round(max, -18)", IntValue(9000000000000000000)},
+		{"round(min, -18)", IntValue(-9000000000000000000)},
+		{"round(-1250, -2)", IntValue(-1300)},
+		{"round(n)", Null()},
+		{"round(1.5, n)", Null()},
+		{"floor(-1.5)", FloatValue(-2)},
+		{"floor(4)", IntValue(4)},
+		{"floor(n)", Null()},
+		{"ceil(1.2)", FloatValue(2)},
+		{"ceil(n)", Null()},
+
+		{"pow(2, 10)", FloatValue(1024)},
+		{"pow(n, 2)", Null()},
+		{"pow(2, n)", Null()},
+
+		{"coalesce(n, n, 3, 4)", IntValue(3)},
+		{"coalesce(n)", Null()},
+
+		{"nullif(1, 1)", Null()},
+		{"nullif(1, 2)", IntValue(1)},
+		{"nullif(1, n)", IntValue(1)},
+		{"nullif(n, 1)", Null()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			expr, err := Parse(tt.input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := expr.Eval(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s = %#v, want %#v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterFunction(t *testing.T) {
+	RegisterFunction(Function{
+		Name:    "Double",
+		MinArgs: 1,
+		MaxArgs: 1,
+		Call: func(args []Value) (Value, error) {
+			return IntValue(args[0].Int * 2), nil
+		},
+	})
+
+	expr, err := Parse("double(21)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := expr.Eval(nil); err != nil || got != IntValue(42) {
+		t.Errorf("double(21) = %v, %v", got, err)
+	}
 }
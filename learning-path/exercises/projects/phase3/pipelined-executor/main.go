@@ -17,9 +17,9 @@ type Operator interface {
 
 // OperatorStats tracks operator execution statistics
 type OperatorStats struct {
-	RowsProduced int64
+	RowsProduced  int64
 	ExecutionTime time.Duration
-	MemoryUsed int64
+	MemoryUsed    int64
 }
 
 // ScanOperator scans a table
@@ -27,11 +27,26 @@ type ScanOperator struct {
 	tableName string
 	rows      []Row
 	stats     OperatorStats
+	tracer    Tracer
+}
+
+// NewScan creates a scan over an in-memory table
+func NewScan(tableName string, rows []Row) *ScanOperator {
+	return &ScanOperator{tableName: tableName, rows: rows}
 }
 
 func (o *ScanOperator) Execute() iter.Seq[Row] {
 	return func(yield func(Row) bool) {
-		// TODO: Implement scan with profiling
+		start := time.Now()
+		defer func() { o.stats.ExecutionTime += time.Since(start) }()
+		defer traceExecute(o.tracer, "Scan("+o.tableName+")", &o.stats)()
+
+		for _, row := range o.rows {
+			o.stats.RowsProduced++
+			if !yield(row) {
+				return
+			}
+		}
 	}
 }
 
@@ -45,39 +60,155 @@ func (o *ScanOperator) Profile() OperatorStats {
 
 // FilterOperator filters rows
 type FilterOperator struct {
-	child Operator
-	pred  func(Row) bool
-	stats OperatorStats
+	child  Operator
+	pred   func(Row) bool
+	stats  OperatorStats
+	tracer Tracer
+}
+
+// NewFilter creates a filter passing rows for which pred returns true
+func NewFilter(child Operator, pred func(Row) bool) *FilterOperator {
+	return &FilterOperator{child: child, pred: pred}
 }
 
 func (o *FilterOperator) Execute() iter.Seq[Row] {
 	return func(yield func(Row) bool) {
-		// TODO: Implement filter
+		start := time.Now()
+		defer func() { o.stats.ExecutionTime += time.Since(start) }()
+		defer traceExecute(o.tracer, "Filter", &o.stats)()
+
+		for row := range o.child.Execute() {
+			if !o.pred(row) {
+				continue
+			}
+			o.stats.RowsProduced++
+			if !yield(row) {
+				return
+			}
+		}
 	}
 }
 
+func (o *FilterOperator) Explain() string {
+	return "Filter(" + o.child.Explain() + ")"
+}
+
+func (o *FilterOperator) Profile() OperatorStats {
+	return o.stats
+}
+
 // ProjectOperator projects columns
 type ProjectOperator struct {
 	child   Operator
 	columns []string
 	stats   OperatorStats
+	tracer  Tracer
+}
+
+// NewProject creates a projection onto columns
+func NewProject(child Operator, columns []string) *ProjectOperator {
+	return &ProjectOperator{child: child, columns: columns}
 }
 
 func (o *ProjectOperator) Execute() iter.Seq[Row] {
-	// TODO: Implement projection
-	return nil
+	return func(yield func(Row) bool) {
+		start := time.Now()
+		defer func() { o.stats.ExecutionTime += time.Since(start) }()
+		defer traceExecute(o.tracer, "Project", &o.stats)()
+
+		for row := range o.child.Execute() {
+			out := make(Row, len(o.columns))
+			for _, col := range o.columns {
+				if v, ok := row[col]; ok {
+					out[col] = v
+				}
+			}
+			o.stats.RowsProduced++
+			if !yield(out) {
+				return
+			}
+		}
+	}
+}
+
+func (o *ProjectOperator) Explain() string {
+	return "Project(" + o.child.Explain() + ")"
+}
+
+func (o *ProjectOperator) Profile() OperatorStats {
+	return o.stats
 }
 
 // HashJoinOperator performs hash join
 type HashJoinOperator struct {
-	left    Operator
-	right   Operator
-	leftKey string
+	left     Operator
+	right    Operator
+	leftKey  string
 	rightKey string
-	stats   OperatorStats
+	stats    OperatorStats
+	tracer   Tracer
+}
+
+// NewHashJoin creates an equi-join of left.leftKey = right.rightKey. The
+// left input is the build side.
+func NewHashJoin(left, right Operator, leftKey, rightKey string) *HashJoinOperator {
+	return &HashJoinOperator{left: left, right: right, leftKey: leftKey, rightKey: rightKey}
 }
 
 func (o *HashJoinOperator) Execute() iter.Seq[Row] {
-	// TODO: Implement hash join as pipeline operator
-	return nil
+	return func(yield func(Row) bool) {
+		start := time.Now()
+		defer func() { o.stats.ExecutionTime += time.Since(start) }()
+		defer traceExecute(o.tracer, "HashJoin", &o.stats)()
+
+		// Build phase: the left input is a pipeline breaker
+		build := startSpan(o.tracer, "HashJoin.build")
+		table := make(map[interface{}][]Row)
+		built := 0
+		for row := range o.left.Execute() {
+			if key, ok := row[o.leftKey]; ok && key != nil {
+				table[key] = append(table[key], row)
+				built++
+			}
+		}
+		build.SetAttribute("rows", built)
+		build.SetAttribute("keys", len(table))
+		build.End()
+
+		// Probe phase streams the right input
+		probe := startSpan(o.tracer, "HashJoin.probe")
+		probed := 0
+		defer func() {
+			probe.SetAttribute("rows", probed)
+			probe.End()
+		}()
+		for row := range o.right.Execute() {
+			probed++
+			key, ok := row[o.rightKey]
+			if !ok || key == nil {
+				continue
+			}
+			for _, match := range table[key] {
+				out := make(Row, len(match)+len(row))
+				for k, v := range match {
+					out[k] = v
+				}
+				for k, v := range row {
+					out[k] = v
+				}
+				o.stats.RowsProduced++
+				if !yield(out) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (o *HashJoinOperator) Explain() string {
+	return "HashJoin(" + o.left.Explain() + ", " + o.right.Explain() + ")"
+}
+
+func (o *HashJoinOperator) Profile() OperatorStats {
+	return o.stats
 }
@@ -1,6 +1,15 @@
 package executor
 
-import "testing"
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
 
 func TestScan(t *testing.T) {
 	// TODO: Test scan operator
@@ -27,6 +36,240 @@ func TestProfiling(t *testing.T) {
 	t.Skip("not implemented")
 }
 
+func TestMaterialize(t *testing.T) {
+	rows := make([]Row, 100)
+	for i := range rows {
+		rows[i] = Row{"id": i, "name": fmt.Sprintf("user%d", i)}
+	}
+	scan := NewScan("users", rows)
+	dir := t.TempDir()
+	m := NewMaterialize(scan, MaterializeOptions{MemoryLimit: 2048, SpillDir: dir})
+
+	first, second := m.Consumer(), m.Consumer()
+	collect := func(op Operator) []int {
+		var ids []int
+		for row := range op.Execute() {
+			ids = append(ids, row["id"].(int))
+		}
+		return ids
+	}
+
+	// Both consumers and a rewind see every row in order
+	for _, ids := range [][]int{collect(first), collect(second), collect(first)} {
+		if len(ids) != len(rows) {
+			t.Fatalf("consumer saw %d rows, want %d", len(ids), len(rows))
+		}
+		for i, id := range ids {
+			if id != i {
+				t.Fatalf("row %d has id %d", i, id)
+			}
+		}
+	}
+	if got := scan.Profile().RowsProduced; got != int64(len(rows)) {
+		t.Errorf("child produced %d rows, want %d (executed once)", got, len(rows))
+	}
+	if m.Spilled() == 0 || m.Profile().MemoryUsed > 2048 {
+		t.Errorf("expected spilling: spilled=%d memory=%d", m.Spilled(), m.Profile().MemoryUsed)
+	}
+	if err := m.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Early termination of one consumer does not affect the other
+	for range first.Execute() {
+		break
+	}
+
+	first.Close()
+	first.Close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("spill file removed while a consumer remains: %v", entries)
+	}
+	second.Close()
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("spill file not removed after last consumer: %v", entries)
+	}
+
+	if n := len(collect(m)); n != 0 {
+		t.Errorf("released Materialize yielded %d rows", n)
+	}
+	if !errors.Is(m.Err(), ErrMaterializeReleased) {
+		t.Errorf("Err() = %v, want ErrMaterializeReleased", m.Err())
+	}
+}
+
+func TestSetOperators(t *testing.T) {
+	users := func(ids ...int) *ScanOperator {
+		rows := make([]Row, len(ids))
+		for i, id := range ids {
+			rows[i] = Row{"id": id, "name": fmt.Sprintf("user%d", id)}
+		}
+		return NewScan("users", rows)
+	}
+	ids := func(op Operator) []int {
+		var ids []int
+		for row := range op.Execute() {
+			ids = append(ids, row["id"].(int))
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		op   Operator
+		want []int
+	}{
+		{"union all", NewUnionAll(users(1, 2, 2), users(2, 3)), []int{1, 2, 2, 2, 3}},
+		{"union", NewUnion(DistinctOptions{}, users(1, 2, 2), users(2, 3)), []int{1, 2, 3}},
+		{"intersect", NewIntersect(users(1, 2, 2, 3), users(2, 2, 4, 3)), []int{2, 3}},
+		{"intersect all", NewIntersectAll(users(2, 1, 2, 2), users(2, 2)), []int{2, 2}},
+		{"distinct", NewDistinct(users(3, 1, 3, 1), DistinctOptions{}), []int{3, 1}},
+	}
+	for _, tt := range tests {
+		if got := ids(tt.op); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// Rows equal only in some columns, or holding other value types, are
+	// not duplicates
+	mixed := NewScan("t", []Row{{"id": 1}, {"id": 1, "x": nil}, {"id": int64(1)}, {"id": 1}})
+	if got := len(slices.Collect(NewDistinct(mixed, DistinctOptions{}).Execute())); got != 3 {
+		t.Errorf("distinct mixed rows: %d, want 3", got)
+	}
+	if got := NewIntersect(users(1), users(2)).Explain(); got != "Intersect(Scan(users), Scan(users))" {
+		t.Errorf("Explain() = %q", got)
+	}
+}
+
+func TestDistinctSpill(t *testing.T) {
+	// 5000 rows with 1000 distinct ids, far more keys than fit in memory
+	rows := make([]Row, 5000)
+	for i := range rows {
+		id := (i * 7) % 1000
+		rows[i] = Row{"id": id, "name": fmt.Sprintf("user%d", id)}
+	}
+	dir := t.TempDir()
+	d := NewDistinct(NewScan("users", rows), DistinctOptions{MemoryLimit: 4096, SpillDir: dir})
+
+	for range 2 {
+		seen := make(map[int]bool)
+		for row := range d.Execute() {
+			id := row["id"].(int)
+			if seen[id] {
+				t.Fatalf("id %d produced twice", id)
+			}
+			seen[id] = true
+		}
+		if len(seen) != 1000 || d.Err() != nil {
+			t.Fatalf("%d distinct ids, err %v", len(seen), d.Err())
+		}
+	}
+	if d.Spilled() == 0 {
+		t.Error("nothing spilled")
+	}
+	if used := d.Profile().MemoryUsed; used > 4096 {
+		t.Errorf("hash set used %d bytes, over the 4096 limit", used)
+	}
+
+	// Stopping early removes the spill files as well
+	for range d.Execute() {
+		break
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d spill files left", len(entries))
+	}
+
+	// Without a spill directory the rows are deduplicated in memory
+	d = NewDistinct(NewScan("users", rows), DistinctOptions{MemoryLimit: 4096, SpillDir: dir + "/missing"})
+	if got := len(slices.Collect(d.Execute())); got != 1000 || d.Err() == nil {
+		t.Errorf("unspillable distinct: %d rows, err %v", got, d.Err())
+	}
+}
+
+func TestTracing(t *testing.T) {
+	users := make([]Row, 10)
+	for i := range users {
+		users[i] = Row{"id": i}
+	}
+	orders := []Row{{"user": 1}, {"user": 2}, {"user": 2}, {"user": 42}}
+	join := NewHashJoin(
+		NewFilter(NewScan("users", users), func(r Row) bool { return r["id"].(int) < 5 }),
+		NewProject(NewScan("orders", orders), []string{"user"}),
+		"id", "user")
+	rec := NewTraceRecorder()
+	Trace(join, rec)
+	for range join.Execute() {
+	}
+
+	// Render each tree as name[rows](children...)
+	var render func(s *RecordedSpan) string
+	render = func(s *RecordedSpan) string {
+		var b strings.Builder
+		fmt.Fprintf(&b, "%s[%v]", s.Name, s.Attributes["rows"])
+		if len(s.Children) > 0 {
+			parts := make([]string, len(s.Children))
+			for i, c := range s.Children {
+				parts[i] = render(c)
+			}
+			b.WriteString("(" + strings.Join(parts, " ") + ")")
+		}
+		return b.String()
+	}
+	spans := rec.Spans()
+	if len(spans) != 1 {
+		t.Fatalf("%d root spans, want 1", len(spans))
+	}
+	want := "HashJoin[3](HashJoin.build[5](Filter[5](Scan(users)[10])) " +
+		"HashJoin.probe[4](Project[4](Scan(orders)[4])))"
+	if got := render(spans[0]); got != want {
+		t.Errorf("trace\n got %s\nwant %s", got, want)
+	}
+	if keys := spans[0].Children[0].Attributes["keys"]; keys != 5 {
+		t.Errorf("build keys = %v, want 5", keys)
+	}
+
+	var chrome struct {
+		TraceEvents []struct {
+			Name  string  `json:"name"`
+			Phase string  `json:"ph"`
+			TS    float64 `json:"ts"`
+			Dur   float64 `json:"dur"`
+		} `json:"traceEvents"`
+	}
+	var buf bytes.Buffer
+	if err := rec.WriteChromeTrace(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &chrome); err != nil {
+		t.Fatal(err)
+	}
+	if len(chrome.TraceEvents) != 7 {
+		t.Fatalf("%d trace events, want 7", len(chrome.TraceEvents))
+	}
+	root := chrome.TraceEvents[0]
+	for _, e := range chrome.TraceEvents {
+		if e.Phase != "X" || e.TS < root.TS || e.TS+e.Dur > root.TS+root.Dur+1 {
+			t.Errorf("event %+v outside root %+v", e, root)
+		}
+	}
+
+	// Stopping early still ends every span, and the next run is a new root
+	for range join.Execute() {
+		break
+	}
+	if spans := rec.Spans(); len(spans) != 2 || spans[1].Attributes["rows"] != int64(1) {
+		t.Errorf("early termination: %d roots", len(spans))
+	}
+	if len(rec.open) != 0 {
+		t.Errorf("%d spans left open", len(rec.open))
+	}
+	buf.Reset()
+	if err := rec.WriteJSON(&buf); err != nil || !json.Valid(buf.Bytes()) {
+		t.Errorf("WriteJSON: %v", err)
+	}
+}
+
 func BenchmarkScan(b *testing.B) {
 	// TODO: Benchmark scan performance
 	b.Skip("not implemented")
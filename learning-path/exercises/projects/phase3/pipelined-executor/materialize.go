@@ -0,0 +1,284 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/gob"
+	"errors"
+	"io"
+	"iter"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrMaterializeReleased is reported by a Materialize whose last consumer
+// was closed before another consumer executed
+var ErrMaterializeReleased = errors.New("materialized result released")
+
+// MaterializeOptions configures a Materialize operator
+type MaterializeOptions struct {
+	// MemoryLimit is the estimated number of bytes of rows kept in memory;
+	// rows beyond it are spilled to a temporary file. Zero means no limit.
+	MemoryLimit int64
+	// SpillDir is the directory for spill files; empty uses os.TempDir
+	SpillDir string
+}
+
+// Materialize runs its child once and caches the output for any number of
+// consumers, so a common subplan is computed once and the inner side of a
+// join can be rewound cheaply. Rows past the memory limit are spooled to
+// disk. Each consumer is obtained from Consumer and must be closed; when the
+// last one closes, the cached rows and spill file are released.
+type Materialize struct {
+	child  Operator
+	opts   MaterializeOptions
+	stats  OperatorStats
+	tracer Tracer
+
+	fillOnce sync.Once
+	mu       sync.Mutex
+	refs     int
+	released bool
+	rows     []Row // in-memory rows preceding the spilled ones
+	tail     []Row // in-memory rows following them if spilling failed
+	spill    *os.File
+	spilled  int64
+	err      error
+}
+
+// NewMaterialize creates a materialization point over child
+func NewMaterialize(child Operator, opts MaterializeOptions) *Materialize {
+	return &Materialize{child: child, opts: opts}
+}
+
+// Consumer returns a new reader of the materialized rows and takes a
+// reference on them
+func (m *Materialize) Consumer() *MaterializeReader {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refs++
+	return &MaterializeReader{m: m}
+}
+
+// Execute reads the materialized rows without taking a reference. It lets
+// Materialize be used directly as a plan node.
+func (m *Materialize) Execute() iter.Seq[Row] {
+	return m.replay
+}
+
+func (m *Materialize) Explain() string {
+	return "Materialize(" + m.child.Explain() + ")"
+}
+
+// Profile reports the rows materialized and the memory they occupy
+func (m *Materialize) Profile() OperatorStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.stats
+}
+
+// Spilled returns the number of rows written to the spill file
+func (m *Materialize) Spilled() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.spilled
+}
+
+// Err returns the first spill or replay error. Rows are never silently
+// dropped: if spilling fails, the remaining rows are kept in memory.
+func (m *Materialize) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.err
+}
+
+// fill drains the child into memory and the spill file
+func (m *Materialize) fill() {
+	start := time.Now()
+	span := startSpan(m.tracer, "Materialize.fill")
+	defer func() {
+		span.SetAttribute("rows", m.stats.RowsProduced)
+		span.SetAttribute("spilled", m.spilled)
+		span.End()
+	}()
+	var enc *gob.Encoder
+	var w *bufio.Writer
+
+	for row := range m.child.Execute() {
+		size := rowSize(row)
+		overLimit := m.opts.MemoryLimit > 0 && m.stats.MemoryUsed+size > m.opts.MemoryLimit
+		if overLimit && enc == nil && m.err == nil {
+			f, err := os.CreateTemp(m.opts.SpillDir, "materialize-*.spill")
+			if err != nil {
+				m.err = err
+			} else {
+				m.spill = f
+				w = bufio.NewWriter(f)
+				enc = gob.NewEncoder(w)
+			}
+		}
+
+		m.stats.RowsProduced++
+		if overLimit && enc != nil {
+			err := enc.Encode(row)
+			if err == nil {
+				m.spilled++
+				continue
+			}
+			m.err = err
+			enc = nil
+		}
+		if m.spill != nil {
+			m.tail = append(m.tail, row)
+		} else {
+			m.rows = append(m.rows, row)
+		}
+		m.stats.MemoryUsed += size
+	}
+
+	if w != nil {
+		if err := w.Flush(); err != nil && m.err == nil {
+			m.err = err
+		}
+	}
+	m.stats.ExecutionTime = time.Since(start)
+}
+
+// replay yields the rows in child order: the in-memory prefix, the spilled
+// rows, then any rows kept in memory after spilling failed
+func (m *Materialize) replay(yield func(Row) bool) {
+	m.mu.Lock()
+	if m.released {
+		m.err = ErrMaterializeReleased
+		m.mu.Unlock()
+		return
+	}
+	m.fillOnce.Do(m.fill)
+	rows, tail, spill, spilled := m.rows, m.tail, m.spill, m.spilled
+	m.mu.Unlock()
+
+	for _, row := range rows {
+		if !yield(row) {
+			return
+		}
+	}
+	if spill != nil && spilled > 0 && !m.replaySpill(spill.Name(), spilled, yield) {
+		return
+	}
+	for _, row := range tail {
+		if !yield(row) {
+			return
+		}
+	}
+}
+
+// replaySpill yields n rows from the spill file. Each call opens its own
+// handle so consumers can read concurrently. It returns false if the
+// consumer stopped or the file could not be read.
+func (m *Materialize) replaySpill(path string, n int64, yield func(Row) bool) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		m.setErr(err)
+		return false
+	}
+	defer f.Close()
+
+	dec := gob.NewDecoder(bufio.NewReader(f))
+	for range n {
+		var row Row
+		if err := dec.Decode(&row); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			m.setErr(err)
+			return false
+		}
+		if !yield(row) {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *Materialize) setErr(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err == nil {
+		m.err = err
+	}
+}
+
+// release drops one reference and frees the cache when none remain
+func (m *Materialize) release() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.refs--
+	if m.refs > 0 {
+		return
+	}
+	m.released = true
+	m.rows, m.tail = nil, nil
+	if m.spill != nil {
+		m.spill.Close()
+		os.Remove(m.spill.Name())
+		m.spill = nil
+	}
+}
+
+// MaterializeReader is one consumer of a Materialize. Execute may be called
+// repeatedly to rewind.
+type MaterializeReader struct {
+	m      *Materialize
+	stats  OperatorStats
+	closed bool
+}
+
+func (r *MaterializeReader) Execute() iter.Seq[Row] {
+	return func(yield func(Row) bool) {
+		if r.closed {
+			return
+		}
+		start := time.Now()
+		defer func() { r.stats.ExecutionTime += time.Since(start) }()
+		defer traceExecute(r.m.tracer, "Materialize", &r.stats)()
+
+		for row := range r.m.Execute() {
+			r.stats.RowsProduced++
+			if !yield(row) {
+				return
+			}
+		}
+	}
+}
+
+func (r *MaterializeReader) Explain() string {
+	return r.m.Explain()
+}
+
+func (r *MaterializeReader) Profile() OperatorStats {
+	return r.stats
+}
+
+// Close releases the reader's reference. Closing twice is a no-op.
+func (r *MaterializeReader) Close() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	r.m.release()
+}
+
+// rowSize estimates the memory used by a row
+func rowSize(row Row) int64 {
+	size := int64(48) // map header
+	for k, v := range row {
+		size += int64(len(k)) + 16
+		if s, ok := v.(string); ok {
+			size += int64(len(s))
+		} else {
+			size += 16
+		}
+	}
+	return size
+}
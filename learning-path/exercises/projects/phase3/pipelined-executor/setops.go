@@ -0,0 +1,386 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"hash/maphash"
+	"io"
+	"iter"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// distinctPartitions is the number of spill files a Distinct splits
+	// its overflow into at each level
+	distinctPartitions = 16
+	// maxSpillLevel bounds the repartitioning of a partition that still
+	// exceeds the memory limit; past it the partition is kept in memory
+	maxSpillLevel = 3
+	// keyOverhead estimates the memory of a hash set entry beyond its key
+	keyOverhead = 48
+)
+
+// The set operators compare whole rows: two rows are equal when they have
+// the same columns with equal values of the same type. Inputs should be
+// projected onto the same columns first, as in SQL.
+
+// rowKey encodes a row so that equal rows, and only they, have equal keys
+func rowKey(row Row) string {
+	var b strings.Builder
+	for _, col := range slices.Sorted(maps.Keys(row)) {
+		v := row[col]
+		fmt.Fprintf(&b, "%q:%T:%#v;", col, v, v)
+	}
+	return b.String()
+}
+
+// UnionAllOperator concatenates its inputs (UNION ALL)
+type UnionAllOperator struct {
+	inputs []Operator
+	stats  OperatorStats
+	tracer Tracer
+}
+
+// NewUnionAll creates a union of inputs that keeps duplicates
+func NewUnionAll(inputs ...Operator) *UnionAllOperator {
+	return &UnionAllOperator{inputs: inputs}
+}
+
+// NewUnion creates a union of inputs without duplicates (UNION): a
+// Distinct over their UnionAll
+func NewUnion(opts DistinctOptions, inputs ...Operator) *DistinctOperator {
+	return NewDistinct(NewUnionAll(inputs...), opts)
+}
+
+func (o *UnionAllOperator) Execute() iter.Seq[Row] {
+	return func(yield func(Row) bool) {
+		start := time.Now()
+		defer func() { o.stats.ExecutionTime += time.Since(start) }()
+		defer traceExecute(o.tracer, "UnionAll", &o.stats)()
+
+		for _, input := range o.inputs {
+			for row := range input.Execute() {
+				o.stats.RowsProduced++
+				if !yield(row) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func (o *UnionAllOperator) Explain() string {
+	names := make([]string, len(o.inputs))
+	for i, input := range o.inputs {
+		names[i] = input.Explain()
+	}
+	return "UnionAll(" + strings.Join(names, ", ") + ")"
+}
+
+func (o *UnionAllOperator) Profile() OperatorStats {
+	return o.stats
+}
+
+// IntersectOperator returns the rows of its left input that also occur in
+// its right input. The right input is the build side and is held in memory.
+type IntersectOperator struct {
+	left   Operator
+	right  Operator
+	all    bool
+	stats  OperatorStats
+	tracer Tracer
+}
+
+// NewIntersect creates an intersection without duplicates (INTERSECT)
+func NewIntersect(left, right Operator) *IntersectOperator {
+	return &IntersectOperator{left: left, right: right}
+}
+
+// NewIntersectAll creates an intersection that keeps a row as many times
+// as it occurs in both inputs (INTERSECT ALL)
+func NewIntersectAll(left, right Operator) *IntersectOperator {
+	return &IntersectOperator{left: left, right: right, all: true}
+}
+
+func (o *IntersectOperator) Execute() iter.Seq[Row] {
+	return func(yield func(Row) bool) {
+		start := time.Now()
+		defer func() { o.stats.ExecutionTime += time.Since(start) }()
+		defer traceExecute(o.tracer, o.name(), &o.stats)()
+
+		// Build phase: count the occurrences of each right row
+		build := startSpan(o.tracer, o.name()+".build")
+		counts := make(map[string]int)
+		built := 0
+		for row := range o.right.Execute() {
+			key := rowKey(row)
+			counts[key]++
+			built++
+			o.stats.MemoryUsed += int64(len(key)) + keyOverhead
+		}
+		build.SetAttribute("rows", built)
+		build.SetAttribute("keys", len(counts))
+		build.End()
+
+		// Each left row uses up one right occurrence; without ALL, the
+		// first match uses them all
+		for row := range o.left.Execute() {
+			key := rowKey(row)
+			if counts[key] == 0 {
+				continue
+			}
+			if o.all {
+				counts[key]--
+			} else {
+				counts[key] = 0
+			}
+			o.stats.RowsProduced++
+			if !yield(row) {
+				return
+			}
+		}
+	}
+}
+
+func (o *IntersectOperator) name() string {
+	if o.all {
+		return "IntersectAll"
+	}
+	return "Intersect"
+}
+
+func (o *IntersectOperator) Explain() string {
+	return o.name() + "(" + o.left.Explain() + ", " + o.right.Explain() + ")"
+}
+
+func (o *IntersectOperator) Profile() OperatorStats {
+	return o.stats
+}
+
+// DistinctOptions configures a Distinct operator
+type DistinctOptions struct {
+	// MemoryLimit is the estimated number of bytes of row keys kept in the
+	// hash set; rows with new keys beyond it are spilled to temporary
+	// files. Zero means no limit.
+	MemoryLimit int64
+	// SpillDir is the directory for spill files; empty uses os.TempDir
+	SpillDir string
+}
+
+// DistinctOperator removes duplicate rows by hashing. It streams: the first
+// row of each key is produced as soon as it arrives. Once the hash set
+// reaches the memory limit, rows with keys it does not hold are spilled to
+// one of distinctPartitions files chosen by key hash, and after the input
+// ends each file is deduplicated on its own. Equal rows always land in the
+// same file, so the files need no comparing with each other. A file that
+// is still too large is partitioned again with a new hash. Rows from spill
+// files come after the streamed ones.
+type DistinctOperator struct {
+	child   Operator
+	opts    DistinctOptions
+	stats   OperatorStats
+	tracer  Tracer
+	spilled int64
+	err     error
+}
+
+// NewDistinct creates a duplicate-eliminating operator over child
+func NewDistinct(child Operator, opts DistinctOptions) *DistinctOperator {
+	return &DistinctOperator{child: child, opts: opts}
+}
+
+func (o *DistinctOperator) Execute() iter.Seq[Row] {
+	return func(yield func(Row) bool) {
+		start := time.Now()
+		defer func() { o.stats.ExecutionTime += time.Since(start) }()
+		defer traceExecute(o.tracer, "Distinct", &o.stats)()
+
+		o.err = nil
+		o.dedupe(o.child.Execute(), 0, yield)
+	}
+}
+
+func (o *DistinctOperator) Explain() string {
+	return "Distinct(" + o.child.Explain() + ")"
+}
+
+// Profile reports the distinct rows produced and the peak memory of the
+// hash set
+func (o *DistinctOperator) Profile() OperatorStats {
+	return o.stats
+}
+
+// Spilled returns the number of rows written to spill files, counting a
+// row again each time it is repartitioned
+func (o *DistinctOperator) Spilled() int64 {
+	return o.spilled
+}
+
+// Err returns the spill error of the last execution. If no spill file
+// could be created, the rows are deduplicated in memory instead and the
+// result is complete; if writing or reading one failed, the output stops
+// early.
+func (o *DistinctOperator) Err() error {
+	return o.err
+}
+
+// dedupe yields the first row of each key in rows and reports whether the
+// consumer wants more. level is the number of times the rows have been
+// partitioned.
+func (o *DistinctOperator) dedupe(rows iter.Seq[Row], level int, yield func(Row) bool) bool {
+	seen := make(map[string]struct{})
+	var used int64
+	var parts *spillPartitions
+	defer func() { parts.remove() }()
+
+	for row := range rows {
+		key := rowKey(row)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		size := int64(len(key)) + keyOverhead
+		if o.opts.MemoryLimit > 0 && used+size > o.opts.MemoryLimit && level < maxSpillLevel {
+			if parts == nil && o.err == nil {
+				var err error
+				if parts, err = newSpillPartitions(o.opts.SpillDir); err != nil {
+					o.err = err
+				}
+			}
+			if parts != nil {
+				if err := parts.add(key, row); err != nil {
+					o.err = err
+					return false
+				}
+				o.spilled++
+				continue
+			}
+		}
+
+		seen[key] = struct{}{}
+		used += size
+		o.stats.MemoryUsed = max(o.stats.MemoryUsed, used)
+		o.stats.RowsProduced++
+		if !yield(row) {
+			return false
+		}
+	}
+	if parts == nil {
+		return true
+	}
+
+	span := startSpan(o.tracer, "Distinct.spill")
+	span.SetAttribute("level", level)
+	span.SetAttribute("rows", parts.rows())
+	defer span.End()
+	if err := parts.finish(); err != nil {
+		o.err = err
+		return false
+	}
+	seen = nil
+	for i := range parts.files {
+		if parts.counts[i] == 0 {
+			continue
+		}
+		if !o.dedupe(parts.read(i, &o.err), level+1, yield) || o.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// spillPartitions are the spill files of one level of a Distinct. Rows are
+// gob-encoded, as in Materialize.
+type spillPartitions struct {
+	seed    maphash.Seed
+	files   []*os.File
+	writers []*bufio.Writer
+	encs    []*gob.Encoder
+	counts  []int64
+}
+
+func newSpillPartitions(dir string) (*spillPartitions, error) {
+	p := &spillPartitions{seed: maphash.MakeSeed(), counts: make([]int64, distinctPartitions)}
+	for range distinctPartitions {
+		f, err := os.CreateTemp(dir, "distinct-*.spill")
+		if err != nil {
+			p.remove()
+			return nil, err
+		}
+		w := bufio.NewWriter(f)
+		p.files = append(p.files, f)
+		p.writers = append(p.writers, w)
+		p.encs = append(p.encs, gob.NewEncoder(w))
+	}
+	return p, nil
+}
+
+// add writes row to the partition of its key
+func (p *spillPartitions) add(key string, row Row) error {
+	i := maphash.String(p.seed, key) % distinctPartitions
+	if err := p.encs[i].Encode(row); err != nil {
+		return err
+	}
+	p.counts[i]++
+	return nil
+}
+
+func (p *spillPartitions) rows() int64 {
+	var n int64
+	for _, c := range p.counts {
+		n += c
+	}
+	return n
+}
+
+// finish flushes the partitions for reading
+func (p *spillPartitions) finish() error {
+	for _, w := range p.writers {
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read returns the rows of partition i. A read error is stored in *errp and
+// ends the sequence.
+func (p *spillPartitions) read(i int, errp *error) iter.Seq[Row] {
+	return func(yield func(Row) bool) {
+		f := p.files[i]
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			*errp = err
+			return
+		}
+		dec := gob.NewDecoder(bufio.NewReader(f))
+		for range p.counts[i] {
+			var row Row
+			if err := dec.Decode(&row); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				*errp = err
+				return
+			}
+			if !yield(row) {
+				return
+			}
+		}
+	}
+}
+
+// remove closes and deletes the spill files; p may be nil
+func (p *spillPartitions) remove() {
+	if p == nil {
+		return
+	}
+	for _, f := range p.files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	p.files = nil
+}
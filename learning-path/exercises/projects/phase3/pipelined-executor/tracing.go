@@ -0,0 +1,224 @@
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Tracer receives spans from operators, in the manner of an OpenTelemetry
+// tracer. Operators open a span around each execution and around phases
+// of their own work, such as the build and probe of a hash join. Because
+// the pipeline pulls rows through nested iterators, a span opened while
+// another is still open belongs inside it. Durations are inclusive: while
+// a consumer handles a row, the spans of every operator below it stay
+// open.
+type Tracer interface {
+	StartSpan(name string) Span
+}
+
+// Span is one timed section of execution
+type Span interface {
+	SetAttribute(key string, value any)
+	End()
+}
+
+// Traceable is implemented by operators that report to a Tracer.
+// SetTracer also sets the tracer of the operator's inputs.
+type Traceable interface {
+	SetTracer(t Tracer)
+}
+
+// Trace attaches t to every operator of the plan rooted at op
+func Trace(op Operator, t Tracer) {
+	if op, ok := op.(Traceable); ok {
+		op.SetTracer(t)
+	}
+}
+
+func (o *ScanOperator) SetTracer(t Tracer) { o.tracer = t }
+
+func (o *FilterOperator) SetTracer(t Tracer) {
+	o.tracer = t
+	Trace(o.child, t)
+}
+
+func (o *ProjectOperator) SetTracer(t Tracer) {
+	o.tracer = t
+	Trace(o.child, t)
+}
+
+func (o *HashJoinOperator) SetTracer(t Tracer) {
+	o.tracer = t
+	Trace(o.left, t)
+	Trace(o.right, t)
+}
+
+func (m *Materialize) SetTracer(t Tracer) {
+	m.tracer = t
+	Trace(m.child, t)
+}
+
+// SetTracer sets the tracer of the shared Materialize
+func (r *MaterializeReader) SetTracer(t Tracer) { r.m.SetTracer(t) }
+
+func (o *UnionAllOperator) SetTracer(t Tracer) {
+	o.tracer = t
+	for _, input := range o.inputs {
+		Trace(input, t)
+	}
+}
+
+func (o *IntersectOperator) SetTracer(t Tracer) {
+	o.tracer = t
+	Trace(o.left, t)
+	Trace(o.right, t)
+}
+
+func (o *DistinctOperator) SetTracer(t Tracer) {
+	o.tracer = t
+	Trace(o.child, t)
+}
+
+// noopSpan is the span of an operator without a tracer
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(string, any) {}
+func (noopSpan) End()                     {}
+
+// startSpan opens a span on t, which may be nil
+func startSpan(t Tracer, name string) Span {
+	if t == nil {
+		return noopSpan{}
+	}
+	return t.StartSpan(name)
+}
+
+// traceExecute opens the span of one execution of an operator and returns
+// the function ending it, which records the rows produced meanwhile
+func traceExecute(t Tracer, name string, stats *OperatorStats) func() {
+	if t == nil {
+		return func() {}
+	}
+	span := t.StartSpan(name)
+	before := stats.RowsProduced
+	return func() {
+		span.SetAttribute("rows", stats.RowsProduced-before)
+		span.End()
+	}
+}
+
+// TraceRecorder is a Tracer that keeps its spans as trees, for export as
+// JSON or in the Chrome trace format. It nests each span under the
+// innermost span still open, so it should trace one goroutine at a time.
+type TraceRecorder struct {
+	mu    sync.Mutex
+	roots []*RecordedSpan
+	open  []*RecordedSpan
+}
+
+// RecordedSpan is a span kept by a TraceRecorder. Its fields must not be
+// read before the span has ended.
+type RecordedSpan struct {
+	Name       string          `json:"name"`
+	Start      time.Time       `json:"start"`
+	Duration   time.Duration   `json:"duration_ns"`
+	Attributes map[string]any  `json:"attributes,omitempty"`
+	Children   []*RecordedSpan `json:"children,omitempty"`
+
+	rec *TraceRecorder
+}
+
+// NewTraceRecorder creates an empty recorder
+func NewTraceRecorder() *TraceRecorder {
+	return &TraceRecorder{}
+}
+
+func (r *TraceRecorder) StartSpan(name string) Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	span := &RecordedSpan{Name: name, Start: time.Now(), rec: r}
+	if n := len(r.open); n > 0 {
+		parent := r.open[n-1]
+		parent.Children = append(parent.Children, span)
+	} else {
+		r.roots = append(r.roots, span)
+	}
+	r.open = append(r.open, span)
+	return span
+}
+
+func (s *RecordedSpan) SetAttribute(key string, value any) {
+	s.rec.mu.Lock()
+	defer s.rec.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]any)
+	}
+	s.Attributes[key] = value
+}
+
+func (s *RecordedSpan) End() {
+	r := s.rec
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.Duration = time.Since(s.Start)
+	for i := len(r.open) - 1; i >= 0; i-- {
+		if r.open[i] == s {
+			r.open = append(r.open[:i], r.open[i+1:]...)
+			break
+		}
+	}
+}
+
+// Spans returns the root spans in the order they started
+func (r *TraceRecorder) Spans() []*RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*RecordedSpan(nil), r.roots...)
+}
+
+// WriteJSON writes the span trees as a JSON array
+func (r *TraceRecorder) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.Spans())
+}
+
+// chromeEvent is a complete ("X") event of the Chrome trace format, which
+// chrome://tracing and Perfetto display as a flame chart
+type chromeEvent struct {
+	Name  string         `json:"name"`
+	Phase string         `json:"ph"`
+	TS    float64        `json:"ts"`  // microseconds since the first span
+	Dur   float64        `json:"dur"` // microseconds
+	PID   int            `json:"pid"`
+	TID   int            `json:"tid"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+// WriteChromeTrace writes the spans in the Chrome trace format
+func (r *TraceRecorder) WriteChromeTrace(w io.Writer) error {
+	roots := r.Spans()
+	events := []chromeEvent{}
+	if len(roots) > 0 {
+		epoch := roots[0].Start
+		var walk func(spans []*RecordedSpan)
+		walk = func(spans []*RecordedSpan) {
+			for _, s := range spans {
+				events = append(events, chromeEvent{
+					Name:  s.Name,
+					Phase: "X",
+					TS:    float64(s.Start.Sub(epoch).Nanoseconds()) / 1e3,
+					Dur:   float64(s.Duration.Nanoseconds()) / 1e3,
+					PID:   1,
+					TID:   1,
+					Args:  s.Attributes,
+				})
+				walk(s.Children)
+			}
+		}
+		walk(roots)
+	}
+	return json.NewEncoder(w).Encode(map[string]any{"traceEvents": events})
+}
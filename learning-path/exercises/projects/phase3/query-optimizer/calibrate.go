@@ -0,0 +1,160 @@
+package optimizer
+
+import (
+	"math/rand/v2"
+	"os"
+	"time"
+)
+
+// CalibrationOptions sizes the micro-benchmarks run by Calibrate
+type CalibrationOptions struct {
+	Rows     int    // rows per CPU benchmark (default 1M)
+	Pages    int    // pages in the I/O benchmark file (default 4096)
+	PageSize int    // bytes per page (default 4096)
+	Rounds   int    // each benchmark reports its fastest of Rounds runs (default 3)
+	Dir      string // directory for the I/O benchmark file (default os.TempDir())
+}
+
+func (o CalibrationOptions) withDefaults() CalibrationOptions {
+	if o.Rows <= 0 {
+		o.Rows = 1 << 20
+	}
+	if o.Pages <= 0 {
+		o.Pages = 4096
+	}
+	if o.PageSize <= 0 {
+		o.PageSize = 4096
+	}
+	if o.Rounds <= 0 {
+		o.Rounds = 3
+	}
+	return o
+}
+
+// sink keeps benchmark results alive so the loops are not optimized away
+var sink int64
+
+// Calibrate measures the cost constants on the current machine: sequential
+// row processing, hash table build and probe, and sequential and random
+// page reads. RowsPerPage depends on the data rather than the machine and
+// keeps its default.
+//
+// Page reads go through the OS page cache, so the I/O constants describe a
+// warm cache; cold reads from disk are slower.
+func Calibrate(opts CalibrationOptions) (CostModel, error) {
+	opts = opts.withDefaults()
+	model := DefaultCostModel()
+
+	model.SeqRowCost = measure(opts.Rounds, opts.Rows, benchSeqScan(opts.Rows))
+	model.HashBuildCost, model.HashProbeCost = measureHash(opts)
+
+	var err error
+	model.SeqPageCost, model.RandomPageCost, err = measurePages(opts)
+	if err != nil {
+		return CostModel{}, err
+	}
+	return model, nil
+}
+
+// measure returns the fastest per-operation time of fn over rounds runs of
+// n operations, in nanoseconds
+func measure(rounds, n int, fn func()) float64 {
+	best := time.Duration(1<<63 - 1)
+	for range rounds {
+		start := time.Now()
+		fn()
+		best = min(best, time.Since(start))
+	}
+	// A coarse clock can report zero; keep the constant positive
+	return max(float64(best.Nanoseconds())/float64(n), 0.001)
+}
+
+func benchSeqScan(n int) func() {
+	type row struct{ id, val int64 }
+	rows := make([]row, n)
+	for i := range rows {
+		rows[i] = row{id: int64(i), val: int64(i % 97)}
+	}
+	return func() {
+		var matched int64
+		for i := range rows {
+			if rows[i].val > 48 {
+				matched += rows[i].id
+			}
+		}
+		sink = matched
+	}
+}
+
+func measureHash(opts CalibrationOptions) (build, probe float64) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	keys := make([]int64, opts.Rows)
+	for i := range keys {
+		keys[i] = int64(i)
+	}
+	rng.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+
+	var table map[int64]int32
+	build = measure(opts.Rounds, len(keys), func() {
+		table = make(map[int64]int32)
+		for i, k := range keys {
+			table[k] = int32(i)
+		}
+	})
+	probe = measure(opts.Rounds, len(keys), func() {
+		var found int64
+		for i := len(keys) - 1; i >= 0; i-- {
+			if v, ok := table[keys[i]]; ok {
+				found += int64(v)
+			}
+		}
+		sink = found
+	})
+	return build, probe
+}
+
+// measurePages writes a scratch file and times reading it page by page in
+// order and at random offsets
+func measurePages(opts CalibrationOptions) (seq, random float64, err error) {
+	file, err := os.CreateTemp(opts.Dir, "calibrate-*.dat")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	page := make([]byte, opts.PageSize)
+	for i := range page {
+		page[i] = byte(i)
+	}
+	for range opts.Pages {
+		if _, err := file.Write(page); err != nil {
+			return 0, 0, err
+		}
+	}
+	if err := file.Sync(); err != nil {
+		return 0, 0, err
+	}
+
+	offsets := make([]int64, opts.Pages)
+	for i := range offsets {
+		offsets[i] = int64(i) * int64(opts.PageSize)
+	}
+	readAll := func() {
+		for _, off := range offsets {
+			if _, rerr := file.ReadAt(page, off); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+	}
+
+	seq = measure(opts.Rounds, opts.Pages, readAll)
+	rand.New(rand.NewPCG(3, 4)).Shuffle(len(offsets), func(i, j int) {
+		offsets[i], offsets[j] = offsets[j], offsets[i]
+	})
+	random = measure(opts.Rounds, opts.Pages, readAll)
+	if err != nil {
+		return 0, 0, err
+	}
+	return seq, random, nil
+}
@@ -0,0 +1,50 @@
+// Command calibrate measures the optimizer's cost constants on this machine
+// and writes them as JSON for LoadCostModel.
+//
+// Usage:
+//
+//	calibrate [-o costmodel.json] [-rows N] [-pages N] [-rounds N]
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	optimizer "github.com/kuzu/learning-path/exercises/projects/phase3/query-optimizer"
+)
+
+func main() {
+	out := flag.String("o", "", "write the model to this file instead of stdout")
+	rows := flag.Int("rows", 0, "rows per CPU benchmark (default 1M)")
+	pages := flag.Int("pages", 0, "pages in the I/O benchmark file (default 4096)")
+	rounds := flag.Int("rounds", 0, "runs per benchmark, fastest wins (default 3)")
+	flag.Parse()
+
+	model, err := optimizer.Calibrate(optimizer.CalibrationOptions{
+		Rows:   *rows,
+		Pages:  *pages,
+		Rounds: *rounds,
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	if *out != "" {
+		if err := model.Save(*out); err != nil {
+			fatal(err)
+		}
+		return
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(model); err != nil {
+		fatal(err)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "calibrate:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,95 @@
+package optimizer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+)
+
+// ErrInvalidCostModel is returned when a loaded cost model has a missing or
+// non-positive constant
+var ErrInvalidCostModel = errors.New("invalid cost model")
+
+// CostModel holds the machine-dependent constants used by EstimateCost.
+// Costs are in nanoseconds so calibrated and default models are comparable.
+type CostModel struct {
+	SeqRowCost     float64 `json:"seq_row_cost"`     // process one row of a sequential scan
+	SeqPageCost    float64 `json:"seq_page_cost"`    // read one page sequentially
+	RandomPageCost float64 `json:"random_page_cost"` // read one page at a random offset
+	HashBuildCost  float64 `json:"hash_build_cost"`  // insert one row into a hash table
+	HashProbeCost  float64 `json:"hash_probe_cost"`  // look up one row in a hash table
+	RowsPerPage    float64 `json:"rows_per_page"`    // rows stored per page
+}
+
+// DefaultCostModel returns constants representative of an SSD-backed
+// machine with a warm cache. Use Calibrate to measure the current machine.
+func DefaultCostModel() CostModel {
+	return CostModel{
+		SeqRowCost:     10,
+		SeqPageCost:    1000,
+		RandomPageCost: 4000,
+		HashBuildCost:  50,
+		HashProbeCost:  25,
+		RowsPerPage:    100,
+	}
+}
+
+func (m CostModel) pages(rows float64) float64 {
+	return math.Ceil(rows / m.RowsPerPage)
+}
+
+// Validate reports whether every constant is positive and finite
+func (m CostModel) Validate() error {
+	for _, f := range []struct {
+		name  string
+		value float64
+	}{
+		{"seq_row_cost", m.SeqRowCost},
+		{"seq_page_cost", m.SeqPageCost},
+		{"random_page_cost", m.RandomPageCost},
+		{"hash_build_cost", m.HashBuildCost},
+		{"hash_probe_cost", m.HashProbeCost},
+		{"rows_per_page", m.RowsPerPage},
+	} {
+		if !(f.value > 0) || math.IsInf(f.value, 1) {
+			return fmt.Errorf("%w: %s = %v", ErrInvalidCostModel, f.name, f.value)
+		}
+	}
+	return nil
+}
+
+// Save writes the model as JSON, replacing path atomically
+func (m CostModel) Save(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LoadCostModel reads a model written by Save
+func LoadCostModel(path string) (CostModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CostModel{}, err
+	}
+	var m CostModel
+	if err := json.Unmarshal(data, &m); err != nil {
+		return CostModel{}, fmt.Errorf("%w: %v", ErrInvalidCostModel, err)
+	}
+	if err := m.Validate(); err != nil {
+		return CostModel{}, err
+	}
+	return m, nil
+}
@@ -0,0 +1,211 @@
+package optimizer
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// FeedbackOptions configures a FeedbackStore
+type FeedbackOptions struct {
+	// HalfLife is the time over which an observation loses half its
+	// weight (default 1h)
+	HalfLife time.Duration
+	// MinWeight is the weight below which an entry is too stale to use:
+	// estimates fall back to the plan's Selectivity (default 0.25, two
+	// half-lives after a single observation)
+	MinWeight float64
+}
+
+func (o FeedbackOptions) withDefaults() FeedbackOptions {
+	if o.HalfLife <= 0 {
+		o.HalfLife = time.Hour
+	}
+	if o.MinWeight <= 0 {
+		o.MinWeight = 0.25
+	}
+	return o
+}
+
+// FeedbackStore learns predicate selectivities from the row counts of
+// executed plans. Each entry is a weighted average of the selectivities
+// observed for one predicate fingerprint, in which older observations
+// weigh exponentially less. It is safe for concurrent use, so executors
+// can record while the optimizer estimates.
+type FeedbackStore struct {
+	opts    FeedbackOptions
+	mu      sync.Mutex
+	entries map[string]*feedbackEntry
+	now     func() time.Time // time.Now if nil
+}
+
+// feedbackEntry is the learned selectivity of one fingerprint and the
+// weight of its observations as of updated
+type feedbackEntry struct {
+	selectivity float64
+	weight      float64
+	updated     time.Time
+}
+
+// NewFeedbackStore creates an empty feedback store
+func NewFeedbackStore(opts FeedbackOptions) *FeedbackStore {
+	return &FeedbackStore{opts: opts.withDefaults(), entries: make(map[string]*feedbackEntry)}
+}
+
+// Record adds an observation: of inputRows rows, the predicate with the
+// given fingerprint kept outputRows. Observations without input rows say
+// nothing and are ignored.
+func (f *FeedbackStore) Record(fingerprint string, inputRows, outputRows int64) {
+	if inputRows <= 0 || outputRows < 0 {
+		return
+	}
+	observed := min(float64(outputRows)/float64(inputRows), 1)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.clock()
+	e, ok := f.entries[fingerprint]
+	if !ok {
+		f.entries[fingerprint] = &feedbackEntry{selectivity: observed, weight: 1, updated: now}
+		return
+	}
+	w := f.decayed(e, now)
+	e.selectivity = (e.selectivity*w + observed) / (w + 1)
+	e.weight, e.updated = w+1, now
+}
+
+// Selectivity returns the learned selectivity of fingerprint. ok is false
+// if nothing was recorded for it or its observations have gone stale.
+func (f *FeedbackStore) Selectivity(fingerprint string) (selectivity float64, ok bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	e, found := f.entries[fingerprint]
+	if !found || f.decayed(e, f.clock()) < f.opts.MinWeight {
+		return 0, false
+	}
+	return e.selectivity, true
+}
+
+// Prune drops the stale entries and returns how many it dropped
+func (f *FeedbackStore) Prune() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.clock()
+	dropped := 0
+	for fp, e := range f.entries {
+		if f.decayed(e, now) < f.opts.MinWeight {
+			delete(f.entries, fp)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// Len returns the number of entries, stale ones included until pruned
+func (f *FeedbackStore) Len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.entries)
+}
+
+// decayed returns the weight of e's observations at now
+func (f *FeedbackStore) decayed(e *feedbackEntry, now time.Time) float64 {
+	age := now.Sub(e.updated)
+	if age <= 0 {
+		return e.weight
+	}
+	return e.weight * math.Exp2(-float64(age)/float64(f.opts.HalfLife))
+}
+
+func (f *FeedbackStore) clock() time.Time {
+	if f.now != nil {
+		return f.now()
+	}
+	return time.Now()
+}
+
+// SetFeedback has EstimateCost prefer selectivities learned by f over the
+// Selectivity of Filter and IndexScan operators with a Predicate. A nil f
+// turns feedback off.
+func (o *Optimizer) SetFeedback(f *FeedbackStore) {
+	o.feedback = f
+}
+
+// RecordFeedback feeds the actual output rows of an executed plan's
+// operators, such as the executor's RowsProduced, into the feedback store.
+// For every Filter and IndexScan with a Predicate, it records the rows
+// kept against the rows of its input: the Filter's child, or the table's
+// RowCount for an IndexScan. Operators missing from actual are skipped.
+func (o *Optimizer) RecordFeedback(plan PhysicalPlan, actual map[PhysicalPlan]int64) {
+	if o.feedback == nil {
+		return
+	}
+	visited := make(map[*Materialize]bool)
+	var walk func(PhysicalPlan)
+	walk = func(plan PhysicalPlan) {
+		if m, ok := plan.(*Materialize); ok {
+			if visited[m] {
+				return
+			}
+			visited[m] = true
+		}
+		out, ok := actual[plan]
+		switch p := plan.(type) {
+		case *Filter:
+			in, inOK := actual[p.Child]
+			if fp, fpOK := predicateFingerprint(p); ok && inOK && fpOK {
+				o.feedback.Record(fp, in, out)
+			}
+		case *IndexScan:
+			stats, statsOK := o.stats[p.Table]
+			if fp, fpOK := predicateFingerprint(p); ok && statsOK && fpOK {
+				o.feedback.Record(fp, stats.RowCount, out)
+			}
+		}
+		for _, child := range physicalChildren(plan) {
+			walk(child)
+		}
+	}
+	walk(plan)
+}
+
+// selectivity returns the learned selectivity of plan's predicate, or
+// estimate without one
+func (o *Optimizer) selectivity(plan PhysicalPlan, estimate float64) float64 {
+	if o.feedback == nil {
+		return estimate
+	}
+	fp, ok := predicateFingerprint(plan)
+	if !ok {
+		return estimate
+	}
+	if sel, ok := o.feedback.Selectivity(fp); ok {
+		return sel
+	}
+	return estimate
+}
+
+// predicateFingerprint identifies the predicate of a Filter or IndexScan
+// together with its input, since a predicate's selectivity depends on the
+// rows it sees: the same predicate over a table and over a join result are
+// learned apart. It reports false for operators without a Predicate and
+// for inputs containing operators of unknown types.
+func predicateFingerprint(plan PhysicalPlan) (string, bool) {
+	switch p := plan.(type) {
+	case *Filter:
+		if p.Predicate == "" {
+			return "", false
+		}
+		input, _, ok := planKey(p.Child)
+		if !ok {
+			return "", false
+		}
+		return "Filter[" + p.Predicate + "](" + input + ")", true
+	case *IndexScan:
+		if p.Predicate == "" {
+			return "", false
+		}
+		return "IndexScan[" + p.Predicate + "](" + p.Table + ")", true
+	}
+	return "", false
+}
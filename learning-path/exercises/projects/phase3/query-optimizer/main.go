@@ -1,5 +1,7 @@
 package optimizer
 
+import "fmt"
+
 // LogicalPlan represents a logical query plan
 type LogicalPlan interface {
 	Children() []LogicalPlan
@@ -12,22 +14,55 @@ type PhysicalPlan interface {
 	Cost() float64
 }
 
-// Cost represents plan cost
+// Cost represents plan cost in estimated nanoseconds
 type Cost struct {
 	CPUCost float64
 	IOCost  float64
 }
 
+// Total returns the combined CPU and I/O cost
+func (c Cost) Total() float64 {
+	return c.CPUCost + c.IOCost
+}
+
+func (c Cost) add(other Cost) Cost {
+	return Cost{CPUCost: c.CPUCost + other.CPUCost, IOCost: c.IOCost + other.IOCost}
+}
+
 // Optimizer optimizes query plans
 type Optimizer struct {
-	stats map[string]*TableStats
+	stats    map[string]*TableStats
+	model    CostModel
+	feedback *FeedbackStore
 }
 
-// NewOptimizer creates a new optimizer
+// NewOptimizer creates a new optimizer using DefaultCostModel
 func NewOptimizer() *Optimizer {
 	return &Optimizer{
 		stats: make(map[string]*TableStats),
+		model: DefaultCostModel(),
+	}
+}
+
+// SetStats records statistics for a table
+func (o *Optimizer) SetStats(table string, stats *TableStats) {
+	o.stats[table] = stats
+}
+
+// SetCostModel replaces the constants used by EstimateCost, typically with
+// the result of Calibrate or LoadCostModel. An invalid model is rejected and
+// the current one kept.
+func (o *Optimizer) SetCostModel(model CostModel) error {
+	if err := model.Validate(); err != nil {
+		return err
 	}
+	o.model = model
+	return nil
+}
+
+// CostModel returns the constants used by EstimateCost
+func (o *Optimizer) CostModel() CostModel {
+	return o.model
 }
 
 // Optimize transforms a logical plan to optimal physical plan
@@ -40,10 +75,100 @@ func (o *Optimizer) Optimize(plan LogicalPlan) (PhysicalPlan, error) {
 	return nil, nil
 }
 
-// EstimateCost estimates the cost of a physical plan
+// EstimateCost estimates the cost of a physical plan. Every operator of the
+// plan is annotated, so Cost on any subplan reports its share afterwards.
+// Plans of unknown types contribute their own Cost as CPU cost.
 func (o *Optimizer) EstimateCost(plan PhysicalPlan) Cost {
-	// TODO: Implement cost estimation
-	return Cost{}
+	cost, _ := o.estimate(plan, make(map[*Materialize]bool))
+	return cost
+}
+
+// estimate returns the cost and estimated output rows of plan. A
+// Materialize in computed is already paid for and only replayed.
+func (o *Optimizer) estimate(plan PhysicalPlan, computed map[*Materialize]bool) (Cost, float64) {
+	m := o.model
+	var (
+		cost Cost
+		rows float64
+	)
+
+	switch p := plan.(type) {
+	case *SeqScan:
+		rows = o.tableRows(p.Table)
+		cost = Cost{
+			CPUCost: rows * m.SeqRowCost,
+			IOCost:  m.pages(rows) * m.SeqPageCost,
+		}
+		p.est = cost
+	case *IndexScan:
+		rows = o.tableRows(p.Table) * o.selectivity(p, p.Selectivity)
+		// Assume no clustering: every matching row is a random page read
+		cost = Cost{
+			CPUCost: rows * m.SeqRowCost,
+			IOCost:  rows * m.RandomPageCost,
+		}
+		p.est = cost
+	case *Filter:
+		childCost, childRows := o.estimate(p.Child, computed)
+		rows = childRows * o.selectivity(p, p.Selectivity)
+		cost = childCost.add(Cost{CPUCost: childRows * m.SeqRowCost})
+		p.est = cost
+	case *HashJoin:
+		buildCost, buildRows := o.estimate(p.Build, computed)
+		probeCost, probeRows := o.estimate(p.Probe, computed)
+		rows = buildRows * probeRows * joinSelectivity(p.Selectivity, buildRows, probeRows)
+		cost = buildCost.add(probeCost).add(Cost{
+			CPUCost: buildRows*m.HashBuildCost + probeRows*m.HashProbeCost,
+		})
+		p.est = cost
+	case *NestedLoopJoin:
+		outerCost, outerRows := o.estimate(p.Outer, computed)
+		innerCost, innerRows := o.estimate(p.Inner, computed)
+		rows = outerRows * innerRows * joinSelectivity(p.Selectivity, outerRows, innerRows)
+		// The inner side is re-run once per outer row, or replayed if it
+		// is materialized
+		reruns := max(outerRows, 1)
+		if _, ok := p.Inner.(*Materialize); ok {
+			innerCost = innerCost.add(Cost{CPUCost: (reruns - 1) * innerRows * m.SeqRowCost})
+			reruns = 1
+		}
+		cost = outerCost.add(Cost{
+			CPUCost: reruns*innerCost.CPUCost + outerRows*innerRows*m.SeqRowCost,
+			IOCost:  reruns * innerCost.IOCost,
+		})
+		p.est = cost
+	case *Materialize:
+		if computed[p] {
+			rows = p.rows
+			cost = Cost{CPUCost: rows * m.SeqRowCost}
+			break
+		}
+		computed[p] = true
+		childCost, childRows := o.estimate(p.Child, computed)
+		rows = childRows
+		// Storing the rows, then replaying them to the first consumer
+		cost = childCost.add(Cost{CPUCost: 2 * rows * m.SeqRowCost})
+		p.est, p.rows = cost, rows
+	default:
+		cost = Cost{CPUCost: plan.Cost()}
+	}
+	return cost, rows
+}
+
+func (o *Optimizer) tableRows(table string) float64 {
+	if stats, ok := o.stats[table]; ok {
+		return float64(stats.RowCount)
+	}
+	return 0
+}
+
+// joinSelectivity defaults to a key/foreign-key join: each row of the
+// larger side matches one row of the smaller
+func joinSelectivity(sel, left, right float64) float64 {
+	if sel > 0 {
+		return sel
+	}
+	return 1 / max(left, right, 1)
 }
 
 // TableStats stores table statistics
@@ -56,3 +181,88 @@ type ResultSet interface {
 	Next() bool
 	Values() []interface{}
 }
+
+// Physical operators. They describe a plan for costing; Execute returns an
+// empty result since execution belongs to the pipelined executor.
+
+// SeqScan reads every row of a table in storage order
+type SeqScan struct {
+	Table string
+	est   Cost
+}
+
+// IndexScan reads the fraction Selectivity of a table through an index.
+// Predicate, if set, is the canonical text of the index condition, such
+// as the expression parser's Canonical; it keys the selectivity learned by
+// a FeedbackStore.
+type IndexScan struct {
+	Table       string
+	Predicate   string
+	Selectivity float64
+	est         Cost
+}
+
+// Filter keeps the fraction Selectivity of its child's rows. Predicate is
+// as for IndexScan.
+type Filter struct {
+	Child       PhysicalPlan
+	Predicate   string
+	Selectivity float64
+	est         Cost
+}
+
+// HashJoin builds a hash table on Build and probes it with Probe. A zero
+// Selectivity assumes a key/foreign-key join.
+type HashJoin struct {
+	Build, Probe PhysicalPlan
+	Selectivity  float64
+	est          Cost
+}
+
+// NestedLoopJoin re-runs Inner for every row of Outer. A zero Selectivity
+// assumes a key/foreign-key join.
+type NestedLoopJoin struct {
+	Outer, Inner PhysicalPlan
+	Selectivity  float64
+	est          Cost
+}
+
+type emptyResult struct{}
+
+func (emptyResult) Next() bool            { return false }
+func (emptyResult) Values() []interface{} { return nil }
+
+func (p *SeqScan) Execute() ResultSet        { return emptyResult{} }
+func (p *IndexScan) Execute() ResultSet      { return emptyResult{} }
+func (p *Filter) Execute() ResultSet         { return emptyResult{} }
+func (p *HashJoin) Execute() ResultSet       { return emptyResult{} }
+func (p *NestedLoopJoin) Execute() ResultSet { return emptyResult{} }
+
+// Cost returns the total from the last EstimateCost covering the operator
+func (p *SeqScan) Cost() float64        { return p.est.Total() }
+func (p *IndexScan) Cost() float64      { return p.est.Total() }
+func (p *Filter) Cost() float64         { return p.est.Total() }
+func (p *HashJoin) Cost() float64       { return p.est.Total() }
+func (p *NestedLoopJoin) Cost() float64 { return p.est.Total() }
+
+func (p *SeqScan) String() string { return fmt.Sprintf("SeqScan(%s)", p.Table) }
+func (p *IndexScan) String() string {
+	return fmt.Sprintf("IndexScan(%s, %ssel=%g)", p.Table, predicateArg(p.Predicate), p.Selectivity)
+}
+func (p *Filter) String() string {
+	return fmt.Sprintf("Filter(%v, %ssel=%g)", p.Child, predicateArg(p.Predicate), p.Selectivity)
+}
+
+// predicateArg formats a predicate as an argument of String
+func predicateArg(pred string) string {
+	if pred == "" {
+		return ""
+	}
+	return fmt.Sprintf("%q, ", pred)
+}
+func (p *HashJoin) String() string {
+	return fmt.Sprintf("HashJoin(build=%v, probe=%v)", p.Build, p.Probe)
+}
+func (p *NestedLoopJoin) String() string {
+	return fmt.Sprintf("NestedLoopJoin(outer=%v, inner=%v)", p.Outer, p.Inner)
+}
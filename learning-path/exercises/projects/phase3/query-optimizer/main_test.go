@@ -1,6 +1,13 @@
 package optimizer
 
-import "testing"
+import (
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 func TestOptimize(t *testing.T) {
 	// TODO: Test query optimization
@@ -18,6 +25,252 @@ func TestFilterPushdown(t *testing.T) {
 }
 
 func TestCostEstimation(t *testing.T) {
-	// TODO: Test cost estimation accuracy
-	t.Skip("not implemented")
+	o := NewOptimizer()
+	o.SetStats("person", &TableStats{RowCount: 10000})
+	o.SetStats("knows", &TableStats{RowCount: 100000})
+	if err := o.SetCostModel(CostModel{
+		SeqRowCost:     1,
+		SeqPageCost:    10,
+		RandomPageCost: 100,
+		HashBuildCost:  5,
+		HashProbeCost:  2,
+		RowsPerPage:    100,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	scan := &SeqScan{Table: "person"}
+	if got, want := o.EstimateCost(scan), (Cost{CPUCost: 10000, IOCost: 1000}); got != want {
+		t.Errorf("SeqScan cost = %+v, want %+v", got, want)
+	}
+
+	index := &IndexScan{Table: "person", Selectivity: 0.01}
+	if got, want := o.EstimateCost(index), (Cost{CPUCost: 100, IOCost: 10000}); got != want {
+		t.Errorf("IndexScan cost = %+v, want %+v", got, want)
+	}
+
+	filter := &Filter{Child: &SeqScan{Table: "person"}, Selectivity: 0.1}
+	join := &HashJoin{Build: filter, Probe: &SeqScan{Table: "knows"}}
+	got := o.EstimateCost(join)
+	// filter: scan(10000+1000) + 10000 CPU; knows: 100000+10000;
+	// build 1000 rows * 5, probe 100000 rows * 2
+	want := Cost{CPUCost: 20000 + 100000 + 5000 + 200000, IOCost: 1000 + 10000}
+	if got != want {
+		t.Errorf("HashJoin cost = %+v, want %+v", got, want)
+	}
+	if filter.Cost() != 21000 || join.Cost() != want.Total() {
+		t.Errorf("operators not annotated: filter %v join %v", filter.Cost(), join.Cost())
+	}
+}
+
+func TestCostModelChangesPlanChoice(t *testing.T) {
+	o := NewOptimizer()
+	o.SetStats("t", &TableStats{RowCount: 100000})
+	seq := &SeqScan{Table: "t"}
+	index := &IndexScan{Table: "t", Selectivity: 0.005}
+
+	cheapRandom := DefaultCostModel()
+	cheapRandom.RandomPageCost = cheapRandom.SeqPageCost
+	if err := o.SetCostModel(cheapRandom); err != nil {
+		t.Fatal(err)
+	}
+	if o.EstimateCost(index).Total() >= o.EstimateCost(seq).Total() {
+		t.Error("index scan should win when random reads are cheap")
+	}
+
+	slowRandom := DefaultCostModel()
+	slowRandom.RandomPageCost = 100 * slowRandom.SeqPageCost
+	if err := o.SetCostModel(slowRandom); err != nil {
+		t.Fatal(err)
+	}
+	if o.EstimateCost(index).Total() <= o.EstimateCost(seq).Total() {
+		t.Error("seq scan should win when random reads are expensive")
+	}
+}
+
+func TestShareSubplans(t *testing.T) {
+	o := NewOptimizer()
+	o.SetStats("person", &TableStats{RowCount: 10000})
+	o.SetStats("knows", &TableStats{RowCount: 100000})
+	friends := func() PhysicalPlan {
+		return &HashJoin{
+			Build: &Filter{Child: &SeqScan{Table: "person"}, Selectivity: 0.01},
+			Probe: &SeqScan{Table: "knows"},
+		}
+	}
+
+	// The largest common subplan of a batch is shared
+	batch := []PhysicalPlan{friends(), &Filter{Child: friends(), Selectivity: 0.5}}
+	before := o.EstimateBatchCost(batch)
+	out, shared := o.ShareSubplans(batch)
+	if len(shared) != 1 {
+		t.Fatalf("shared %v, want the join", shared)
+	}
+	if _, ok := shared[0].Child.(*HashJoin); !ok {
+		t.Errorf("shared %v, want the join", shared[0])
+	}
+	if out[0] != shared[0] || out[1].(*Filter).Child != shared[0] {
+		t.Errorf("rewritten batch %v", out)
+	}
+	if after := o.EstimateBatchCost(out); after.Total() >= before.Total() {
+		t.Errorf("sharing did not pay: %v >= %v", after.Total(), before.Total())
+	}
+	if _, ok := batch[0].(*HashJoin).Build.(*Filter); !ok || batch[0] == out[0] {
+		t.Error("input plans modified")
+	}
+
+	// Within one query: a self-join reads the same filtered input twice
+	self := &HashJoin{
+		Build: &Filter{Child: &SeqScan{Table: "person"}, Selectivity: 0.01},
+		Probe: &Filter{Child: &SeqScan{Table: "person"}, Selectivity: 0.01},
+	}
+	out, shared = o.ShareSubplans([]PhysicalPlan{self})
+	join := out[0].(*HashJoin)
+	if len(shared) != 1 || join.Build != shared[0] || join.Probe != shared[0] {
+		t.Errorf("self-join rewritten as %v", out[0])
+	}
+
+	// Storing and replaying every row of a scan costs more than scanning
+	// it again, so bare scans are not shared
+	scans := []PhysicalPlan{
+		&Filter{Child: &SeqScan{Table: "person"}, Selectivity: 0.5},
+		&Filter{Child: &SeqScan{Table: "person"}, Selectivity: 0.2},
+	}
+	if _, shared := o.ShareSubplans(scans); len(shared) != 0 {
+		t.Errorf("shared %v", shared)
+	}
+}
+
+func TestMaterializeCost(t *testing.T) {
+	o := NewOptimizer()
+	o.SetStats("a", &TableStats{RowCount: 100})
+	o.SetStats("b", &TableStats{RowCount: 10000})
+	inner := func() PhysicalPlan { return &Filter{Child: &SeqScan{Table: "b"}, Selectivity: 0.01} }
+
+	// A materialized inner side is computed once and replayed per outer row
+	rerun := o.EstimateCost(&NestedLoopJoin{Outer: &SeqScan{Table: "a"}, Inner: inner()})
+	m := &Materialize{Child: inner()}
+	replayed := o.EstimateCost(&NestedLoopJoin{Outer: &SeqScan{Table: "a"}, Inner: m})
+	if replayed.Total() >= rerun.Total() {
+		t.Errorf("materialized inner %v not cheaper than rerun %v", replayed.Total(), rerun.Total())
+	}
+	if want := o.EstimateCost(inner()).Total() + 2*100*DefaultCostModel().SeqRowCost; m.Cost() != want {
+		t.Errorf("Materialize cost %v, want %v", m.Cost(), want)
+	}
+
+	// A second consumer in the batch pays only for the replay
+	first := o.EstimateBatchCost([]PhysicalPlan{m})
+	both := o.EstimateBatchCost([]PhysicalPlan{m, m})
+	if got := both.Total() - first.Total(); got != 100*DefaultCostModel().SeqRowCost {
+		t.Errorf("replay cost %v", got)
+	}
+}
+
+func TestSelectivityFeedback(t *testing.T) {
+	o := NewOptimizer()
+	o.SetStats("person", &TableStats{RowCount: 10000})
+	feedback := NewFeedbackStore(FeedbackOptions{HalfLife: time.Hour})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	feedback.now = func() time.Time { return now }
+	o.SetFeedback(feedback)
+
+	scan := &SeqScan{Table: "person"}
+	filter := &Filter{Child: scan, Predicate: "30 < age", Selectivity: 0.1}
+	index := &IndexScan{Table: "person", Predicate: "id = 7", Selectivity: 0.01}
+	estimated := o.EstimateCost(&HashJoin{Build: filter, Probe: &SeqScan{Table: "person"}})
+
+	// The executor saw the filter keep half its input, and the index scan
+	// a single row
+	o.RecordFeedback(filter, map[PhysicalPlan]int64{filter: 5000, scan: 10000})
+	o.RecordFeedback(index, map[PhysicalPlan]int64{index: 1})
+	if sel, ok := feedback.Selectivity(`Filter[30 < age](SeqScan(person))`); !ok || sel != 0.5 {
+		t.Errorf("learned filter selectivity = %v, %v", sel, ok)
+	}
+
+	// An equal plan built anew picks up the learned selectivity
+	same := &Filter{Child: &SeqScan{Table: "person"}, Predicate: "30 < age", Selectivity: 0.1}
+	learned := o.EstimateCost(&HashJoin{Build: same, Probe: &SeqScan{Table: "person"}})
+	if learned.Total() <= estimated.Total() {
+		t.Errorf("learned cost %v not above estimated %v", learned.Total(), estimated.Total())
+	}
+	if got, want := o.EstimateCost(index), (Cost{CPUCost: 10, IOCost: 4000}); got != want {
+		t.Errorf("IndexScan cost with feedback = %+v, want %+v", got, want)
+	}
+	// Other inputs and predicates are learned apart
+	other := &Filter{Child: &IndexScan{Table: "person", Selectivity: 0.5}, Predicate: "30 < age", Selectivity: 0.1}
+	if _, ok := predicateFingerprint(other); !ok {
+		t.Fatal("no fingerprint")
+	}
+	if sel := o.selectivity(other, other.Selectivity); sel != 0.1 {
+		t.Errorf("selectivity over another input = %v", sel)
+	}
+
+	// A new observation is averaged in by weight; older ones decay
+	now = now.Add(time.Hour)
+	o.RecordFeedback(filter, map[PhysicalPlan]int64{filter: 2000, scan: 10000})
+	if sel, _ := feedback.Selectivity(`Filter[30 < age](SeqScan(person))`); math.Abs(sel-0.3) > 1e-9 {
+		t.Errorf("after decay selectivity = %v, want 0.3", sel)
+	}
+
+	// Stale entries fall back to the estimate and are pruned
+	now = now.Add(2 * time.Hour)
+	if sel := o.selectivity(index, index.Selectivity); sel != 0.01 {
+		t.Errorf("stale selectivity used: %v", sel)
+	}
+	if dropped := feedback.Prune(); dropped != 1 || feedback.Len() != 1 {
+		t.Errorf("Prune() = %d, %d left", dropped, feedback.Len())
+	}
+}
+
+func TestCalibrate(t *testing.T) {
+	model, err := Calibrate(CalibrationOptions{Rows: 1 << 14, Pages: 64, Rounds: 1, Dir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := model.Validate(); err != nil {
+		t.Fatalf("calibrated model invalid: %v (%+v)", err, model)
+	}
+	if model.RowsPerPage != DefaultCostModel().RowsPerPage {
+		t.Errorf("RowsPerPage = %v, want default", model.RowsPerPage)
+	}
+
+	path := filepath.Join(t.TempDir(), "model.json")
+	if err := model.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCostModel(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != model {
+		t.Errorf("round trip: got %+v, want %+v", loaded, model)
+	}
+}
+
+func TestLoadCostModelInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "model.json")
+	for _, content := range []string{"not json", `{"seq_row_cost": 1}`} {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadCostModel(path); !errors.Is(err, ErrInvalidCostModel) {
+			t.Errorf("LoadCostModel(%q) error = %v, want ErrInvalidCostModel", content, err)
+		}
+	}
+
+	m := DefaultCostModel()
+	m.HashProbeCost = math.Inf(1)
+	if err := m.Validate(); !errors.Is(err, ErrInvalidCostModel) {
+		t.Errorf("Validate accepted infinite cost: %v", err)
+	}
+
+	o := NewOptimizer()
+	m = DefaultCostModel()
+	m.RowsPerPage = 0
+	if err := o.SetCostModel(m); !errors.Is(err, ErrInvalidCostModel) {
+		t.Errorf("SetCostModel accepted RowsPerPage = 0: %v", err)
+	}
+	if o.CostModel() != DefaultCostModel() {
+		t.Error("rejected model replaced the current one")
+	}
 }
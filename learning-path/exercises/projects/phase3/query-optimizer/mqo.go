@@ -0,0 +1,234 @@
+package optimizer
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// Materialize computes Child once and caches its rows for every parent that
+// references this node, so a subplan shared by several queries of a batch,
+// or by several parts of one query, runs only once. The pipelined
+// executor's Materialize operator implements it.
+type Materialize struct {
+	Child PhysicalPlan
+	est   Cost
+	rows  float64
+}
+
+func (p *Materialize) Execute() ResultSet { return emptyResult{} }
+
+// Cost returns the cost of computing and storing the rows; each further
+// consumer only pays for a replay
+func (p *Materialize) Cost() float64 { return p.est.Total() }
+
+func (p *Materialize) String() string { return fmt.Sprintf("Materialize(%v)", p.Child) }
+
+// EstimateBatchCost estimates the cost of running plans one after the
+// other, paying for each Materialize once across the whole batch
+func (o *Optimizer) EstimateBatchCost(plans []PhysicalPlan) Cost {
+	computed := make(map[*Materialize]bool)
+	var total Cost
+	for _, plan := range plans {
+		cost, _ := o.estimate(plan, computed)
+		total = total.add(cost)
+	}
+	return total
+}
+
+// ShareSubplans finds identical subplans within and across plans and
+// rewrites their occurrences to reference one Materialize, when the cost
+// model says computing once, storing the rows and replaying them is cheaper
+// than computing every occurrence. A subplan computed k times costs k times
+// its cost; shared, it costs its cost once plus storing its rows and k
+// replays. Larger subplans are considered first, so the shared part is as
+// large as possible; the search repeats until no sharing pays off.
+//
+// The plans are not modified: the result is a rewritten copy, with the
+// Materialize nodes introduced. Subplans containing operators of unknown
+// types are never shared.
+func (o *Optimizer) ShareSubplans(plans []PhysicalPlan) ([]PhysicalPlan, []*Materialize) {
+	out := make([]PhysicalPlan, len(plans))
+	for i, plan := range plans {
+		out[i] = clonePlan(plan)
+	}
+
+	var shared []*Materialize
+	for {
+		occurrences := make(map[string][]PhysicalPlan)
+		sizes := make(map[string]int)
+		visited := make(map[*Materialize]bool)
+		for _, plan := range out {
+			collectSubplans(plan, occurrences, sizes, visited)
+		}
+
+		var candidates []string
+		for key, nodes := range occurrences {
+			if len(nodes) >= 2 {
+				candidates = append(candidates, key)
+			}
+		}
+		slices.SortFunc(candidates, func(a, b string) int {
+			return cmp.Or(cmp.Compare(sizes[b], sizes[a]), cmp.Compare(a, b))
+		})
+
+		var chosen *Materialize
+		for _, key := range candidates {
+			nodes := occurrences[key]
+			if o.sharingPays(nodes[0], len(nodes)) {
+				chosen = &Materialize{Child: nodes[0]}
+				for i, plan := range out {
+					out[i] = replaceSubplan(plan, key, chosen, make(map[*Materialize]bool))
+				}
+				break
+			}
+		}
+		if chosen == nil {
+			return out, shared
+		}
+		shared = append(shared, chosen)
+	}
+}
+
+// sharingPays reports whether materializing plan beats computing it k times
+func (o *Optimizer) sharingPays(plan PhysicalPlan, k int) bool {
+	cost, rows := o.estimate(plan, make(map[*Materialize]bool))
+	recompute := float64(k) * cost.Total()
+	share := cost.Total() + float64(1+k)*rows*o.model.SeqRowCost
+	return share < recompute
+}
+
+// collectSubplans records every shareable subplan of plan under its key,
+// along with its size in operators. A Materialize is walked once however
+// often it is referenced, since its child runs once.
+func collectSubplans(plan PhysicalPlan, occurrences map[string][]PhysicalPlan, sizes map[string]int, visited map[*Materialize]bool) {
+	if m, ok := plan.(*Materialize); ok {
+		if !visited[m] {
+			visited[m] = true
+			collectSubplans(m.Child, occurrences, sizes, visited)
+		}
+		return
+	}
+	if key, size, ok := planKey(plan); ok {
+		occurrences[key] = append(occurrences[key], plan)
+		sizes[key] = size
+	}
+	for _, child := range physicalChildren(plan) {
+		collectSubplans(child, occurrences, sizes, visited)
+	}
+}
+
+// replaceSubplan returns plan with every subplan whose key is key replaced
+// by m
+func replaceSubplan(plan PhysicalPlan, key string, m *Materialize, visited map[*Materialize]bool) PhysicalPlan {
+	if k, _, ok := planKey(plan); ok && k == key {
+		return m
+	}
+	switch p := plan.(type) {
+	case *Filter:
+		p.Child = replaceSubplan(p.Child, key, m, visited)
+	case *HashJoin:
+		p.Build = replaceSubplan(p.Build, key, m, visited)
+		p.Probe = replaceSubplan(p.Probe, key, m, visited)
+	case *NestedLoopJoin:
+		p.Outer = replaceSubplan(p.Outer, key, m, visited)
+		p.Inner = replaceSubplan(p.Inner, key, m, visited)
+	case *Materialize:
+		if !visited[p] {
+			visited[p] = true
+			p.Child = replaceSubplan(p.Child, key, m, visited)
+		}
+	}
+	return plan
+}
+
+// planKey identifies a subplan by its structure, so identical subplans
+// have equal keys. It reports false for plans containing operators of
+// unknown types.
+func planKey(plan PhysicalPlan) (key string, size int, ok bool) {
+	switch p := plan.(type) {
+	case *SeqScan:
+		return p.String(), 1, true
+	case *IndexScan:
+		return p.String(), 1, true
+	}
+
+	children := physicalChildren(plan)
+	if children == nil {
+		return "", 0, false
+	}
+	keys := make([]string, len(children))
+	size = 1
+	for i, child := range children {
+		k, n, ok := planKey(child)
+		if !ok {
+			return "", 0, false
+		}
+		keys[i], size = k, size+n
+	}
+	switch p := plan.(type) {
+	case *Filter:
+		key = fmt.Sprintf("Filter(%s, %ssel=%g)", keys[0], predicateArg(p.Predicate), p.Selectivity)
+	case *HashJoin:
+		key = fmt.Sprintf("HashJoin(build=%s, probe=%s, sel=%g)", keys[0], keys[1], p.Selectivity)
+	case *NestedLoopJoin:
+		key = fmt.Sprintf("NestedLoopJoin(outer=%s, inner=%s, sel=%g)", keys[0], keys[1], p.Selectivity)
+	case *Materialize:
+		key = "Materialize(" + keys[0] + ")"
+	}
+	return key, size, true
+}
+
+// physicalChildren returns the inputs of the known operators with inputs
+func physicalChildren(plan PhysicalPlan) []PhysicalPlan {
+	switch p := plan.(type) {
+	case *Filter:
+		return []PhysicalPlan{p.Child}
+	case *HashJoin:
+		return []PhysicalPlan{p.Build, p.Probe}
+	case *NestedLoopJoin:
+		return []PhysicalPlan{p.Outer, p.Inner}
+	case *Materialize:
+		return []PhysicalPlan{p.Child}
+	}
+	return nil
+}
+
+// clonePlan deep-copies the known operators of plan. A Materialize
+// referenced several times stays shared in the copy; operators of unknown
+// types are not copied.
+func clonePlan(plan PhysicalPlan) PhysicalPlan {
+	return cloneShared(plan, make(map[*Materialize]*Materialize))
+}
+
+func cloneShared(plan PhysicalPlan, copies map[*Materialize]*Materialize) PhysicalPlan {
+	switch p := plan.(type) {
+	case *SeqScan:
+		c := *p
+		return &c
+	case *IndexScan:
+		c := *p
+		return &c
+	case *Filter:
+		c := *p
+		c.Child = cloneShared(p.Child, copies)
+		return &c
+	case *HashJoin:
+		c := *p
+		c.Build, c.Probe = cloneShared(p.Build, copies), cloneShared(p.Probe, copies)
+		return &c
+	case *NestedLoopJoin:
+		c := *p
+		c.Outer, c.Inner = cloneShared(p.Outer, copies), cloneShared(p.Inner, copies)
+		return &c
+	case *Materialize:
+		if c, ok := copies[p]; ok {
+			return c
+		}
+		c := &Materialize{}
+		copies[p] = c
+		c.Child = cloneShared(p.Child, copies)
+		return c
+	}
+	return plan
+}
@@ -0,0 +1,102 @@
+package lockmanager
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+)
+
+// LockRequest asks for one lock of a group acquired with AcquireAll
+type LockRequest struct {
+	Resource ResourceID
+	Mode     LockMode
+}
+
+// AcquireAll acquires every lock in requests, or none of them. Requests are
+// merged per resource (S and IX combine to X, as for upgrades) and acquired
+// in resource order, so transactions that take their locks through
+// AcquireAll always lock in the same order and cannot deadlock each other.
+//
+// If a lock cannot be granted, because it would deadlock, times out after
+// LockTimeout or the transaction was aborted, the locks granted by this call
+// are released again and locks the transaction already held return to
+// their former mode. The error names the failed request and wraps the cause
+// (ErrDeadlock, ErrTimeout, ...).
+func (lm *LockManager) AcquireAll(txn TxnID, requests []LockRequest) error {
+	var before []LockRequest // the locks of this group held before, and their modes
+	var touched []ResourceID
+	for _, req := range canonicalOrder(requests) {
+		lm.mu.Lock()
+		prev, held := lm.heldModeLocked(txn, req.Resource)
+		lm.mu.Unlock()
+
+		if err := lm.AcquireLock(txn, req.Resource, req.Mode); err != nil {
+			lm.rollback(txn, touched, before)
+			return fmt.Errorf("acquire %s on %q: %w", req.Mode, req.Resource, err)
+		}
+		if held {
+			before = append(before, LockRequest{Resource: req.Resource, Mode: prev})
+		}
+		touched = append(touched, req.Resource)
+	}
+	return nil
+}
+
+// canonicalOrder returns requests sorted by resource, with the modes of a
+// resource requested more than once combined
+func canonicalOrder(requests []LockRequest) []LockRequest {
+	sorted := slices.Clone(requests)
+	slices.SortStableFunc(sorted, func(a, b LockRequest) int { return cmp.Compare(a.Resource, b.Resource) })
+	merged := sorted[:0]
+	for _, req := range sorted {
+		if n := len(merged); n > 0 && merged[n-1].Resource == req.Resource {
+			merged[n-1].Mode = combine(merged[n-1].Mode, req.Mode)
+			continue
+		}
+		merged = append(merged, req)
+	}
+	return merged
+}
+
+// heldModeLocked returns the mode of txn's lock on resource. Caller holds
+// lm.mu.
+func (lm *LockManager) heldModeLocked(txn TxnID, resource ResourceID) (LockMode, bool) {
+	table, ok := lm.locks[resource]
+	if !ok {
+		return 0, false
+	}
+	h, ok := table.holders[txn]
+	if !ok {
+		return 0, false
+	}
+	return h.mode, true
+}
+
+// rollback undoes the grants of a failed AcquireAll: locks in touched are
+// released, except those in before, which go back to their former mode.
+// Locks the transaction lost meanwhile, say to the hold limit, are left
+// alone.
+func (lm *LockManager) rollback(txn TxnID, touched []ResourceID, before []LockRequest) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	for _, resource := range touched {
+		table, ok := lm.locks[resource]
+		if !ok {
+			continue
+		}
+		h, ok := table.holders[txn]
+		if !ok {
+			continue
+		}
+		i := slices.IndexFunc(before, func(r LockRequest) bool { return r.Resource == resource })
+		if i < 0 {
+			lm.releaseLocked(txn, resource, table)
+			continue
+		}
+		if h.mode != before[i].Mode {
+			// A weaker mode may let waiters in
+			h.mode = before[i].Mode
+			lm.processQueue(table)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package lockmanager
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrHoldLimitExceeded is returned to a transaction aborted for holding a
+// lock that others waited on for too long
+var ErrHoldLimitExceeded = errors.New("lock hold limit exceeded")
+
+// HoldLimit aborts transactions that hold a lock longer than MaxHold while
+// another transaction waits for it. A zero MaxHold disables the limit.
+type HoldLimit struct {
+	MaxHold time.Duration
+	// Grace is how long a waiter must have waited before its blocker is
+	// aborted, so a long but just now contended lock gets time to finish
+	Grace time.Duration
+	// CheckInterval is how often holds are checked (default MaxHold/4)
+	CheckInterval time.Duration
+	// Exempt transactions are never aborted
+	Exempt func(txn TxnID) bool
+	// OnAbort is called, without the lock manager's mutex held, after a
+	// victim's locks are released
+	OnAbort func(v HoldViolation)
+}
+
+func (h HoldLimit) withDefaults() HoldLimit {
+	if h.CheckInterval <= 0 {
+		h.CheckInterval = max(h.MaxHold/4, time.Millisecond)
+	}
+	return h
+}
+
+// HoldViolation describes a transaction aborted by the hold limit
+type HoldViolation struct {
+	Txn      TxnID
+	Resource ResourceID    // the lock that was held too long
+	Held     time.Duration // how long it had been held
+	Waiters  []TxnID       // transactions it was blocking
+}
+
+func (lm *LockManager) watchHolds() {
+	defer close(lm.doneCh)
+	ticker := time.NewTicker(lm.opts.HoldLimit.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			lm.enforceHoldLimits(time.Now())
+		case <-lm.stopCh:
+			return
+		}
+	}
+}
+
+// enforceHoldLimits aborts every non-exempt holder that has held a lock
+// longer than MaxHold while blocking a waiter for at least Grace. Victims
+// lose all their locks and their pending request; their later requests
+// fail with ErrHoldLimitExceeded until they call ReleaseAllLocks. Victims
+// are aborted one at a time, lowest TxnID first, and the locks are checked
+// again after each abort: a holder whose only waiter was an earlier victim
+// is no longer blocking anyone and keeps its locks.
+func (lm *LockManager) enforceHoldLimits(now time.Time) {
+	limit := lm.opts.HoldLimit
+
+	lm.mu.Lock()
+	var victims []HoldViolation
+	for {
+		v, ok := lm.nextHoldVictimLocked(now)
+		if !ok {
+			break
+		}
+		lm.endLocked(v.Txn, ErrHoldLimitExceeded)
+		lm.aborted[v.Txn] = ErrHoldLimitExceeded
+		victims = append(victims, v)
+	}
+	lm.mu.Unlock()
+
+	if limit.OnAbort != nil {
+		for _, v := range victims {
+			limit.OnAbort(v)
+		}
+	}
+}
+
+// nextHoldVictimLocked returns the violation of the lowest TxnID that
+// currently exceeds the hold limit. Caller holds lm.mu.
+func (lm *LockManager) nextHoldVictimLocked(now time.Time) (HoldViolation, bool) {
+	limit := lm.opts.HoldLimit
+	var victim HoldViolation
+	found := false
+	for resource, table := range lm.locks {
+		for txn, h := range table.holders {
+			if now.Sub(h.since) <= limit.MaxHold || (found && txn >= victim.Txn) {
+				continue
+			}
+			if limit.Exempt != nil && limit.Exempt(txn) {
+				continue
+			}
+			var waiters []TxnID
+			for _, w := range table.waiters {
+				if w.txnID != txn && !isCompatible(h.mode, w.mode) && now.Sub(w.since) >= limit.Grace {
+					waiters = append(waiters, w.txnID)
+				}
+			}
+			if len(waiters) > 0 {
+				victim = HoldViolation{Txn: txn, Resource: resource, Held: now.Sub(h.since), Waiters: waiters}
+				found = true
+			}
+		}
+	}
+	return victim, found
+}
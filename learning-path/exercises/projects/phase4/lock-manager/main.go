@@ -2,6 +2,7 @@ package lockmanager
 
 import (
 	"errors"
+	"fmt"
 	"sync"
 	"time"
 )
@@ -19,30 +20,57 @@ const (
 	IntentionExclusive
 )
 
+// String returns the conventional abbreviation of the mode
+func (m LockMode) String() string {
+	switch m {
+	case SharedLock:
+		return "S"
+	case ExclusiveLock:
+		return "X"
+	case IntentionShared:
+		return "IS"
+	case IntentionExclusive:
+		return "IX"
+	default:
+		return fmt.Sprintf("LockMode(%d)", int(m))
+	}
+}
+
 // Errors
 var (
 	ErrDeadlock     = errors.New("deadlock detected")
 	ErrTimeout      = errors.New("lock timeout")
 	ErrLockConflict = errors.New("lock conflict")
+	ErrLockNotHeld  = errors.New("lock not held")
+	ErrTxnAborted   = errors.New("transaction aborted")
 )
 
-// LockRequest represents a lock request
-type LockRequest struct {
-	txnID TxnID
+// lockRequest is a request of one transaction for one lock, granted at once
+// or queued
+type lockRequest struct {
+	txnID    TxnID
+	resource ResourceID
+	mode     LockMode
+	upgrade  bool
+	since    time.Time
+	granted  chan error
+}
+
+// holder is a granted lock
+type holder struct {
 	mode  LockMode
-	granted chan bool
+	since time.Time
 }
 
 // LockTable manages locks for a resource
 type LockTable struct {
-	holders map[TxnID]LockMode
-	waiters []*LockRequest
-	mu      sync.Mutex
+	holders map[TxnID]*holder
+	waiters []*lockRequest // FIFO, upgrades first
 }
 
 // WaitForGraph tracks transaction dependencies
 type WaitForGraph struct {
-	edges map[TxnID][]TxnID  // txn -> waiting for txns
+	edges map[TxnID][]TxnID // txn -> waiting for txns
 	mu    sync.RWMutex
 }
 
@@ -55,72 +83,409 @@ func NewWaitForGraph() *WaitForGraph {
 
 // AddEdge adds a wait-for edge
 func (g *WaitForGraph) AddEdge(waiter, holder TxnID) {
-	// TODO: Implement edge addition
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, h := range g.edges[waiter] {
+		if h == holder {
+			return
+		}
+	}
+	g.edges[waiter] = append(g.edges[waiter], holder)
 }
 
 // RemoveEdge removes a wait-for edge
 func (g *WaitForGraph) RemoveEdge(waiter, holder TxnID) {
-	// TODO: Implement edge removal
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	edges := g.edges[waiter]
+	for i, h := range edges {
+		if h == holder {
+			edges = append(edges[:i], edges[i+1:]...)
+			break
+		}
+	}
+	if len(edges) == 0 {
+		delete(g.edges, waiter)
+	} else {
+		g.edges[waiter] = edges
+	}
+}
+
+// RemoveWaiter removes every edge out of waiter
+func (g *WaitForGraph) RemoveWaiter(waiter TxnID) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.edges, waiter)
 }
 
 // DetectCycle detects cycles in the wait-for graph
 func (g *WaitForGraph) DetectCycle() ([]TxnID, bool) {
-	// TODO: Implement cycle detection using DFS
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	for start := range g.edges {
+		if cycle := g.cycleThroughLocked(start); cycle != nil {
+			return cycle, true
+		}
+	}
 	return nil, false
 }
 
+// cycleThrough returns a cycle containing start, or nil
+func (g *WaitForGraph) cycleThrough(start TxnID) []TxnID {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.cycleThroughLocked(start)
+}
+
+func (g *WaitForGraph) cycleThroughLocked(start TxnID) []TxnID {
+	visited := make(map[TxnID]bool)
+	var path []TxnID
+	var dfs func(txn TxnID) bool
+	dfs = func(txn TxnID) bool {
+		path = append(path, txn)
+		for _, next := range g.edges[txn] {
+			if next == start {
+				return true
+			}
+			if !visited[next] {
+				visited[next] = true
+				if dfs(next) {
+					return true
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+	if dfs(start) {
+		return path
+	}
+	return nil
+}
+
+// Options configures a LockManager
+type Options struct {
+	// LockTimeout bounds how long AcquireLock waits; 0 waits forever
+	LockTimeout time.Duration
+	// HoldLimit aborts transactions that keep others waiting too long
+	HoldLimit HoldLimit
+}
+
 // LockManager manages locks for resources
 type LockManager struct {
 	locks        map[ResourceID]*LockTable
+	held         map[TxnID]map[ResourceID]struct{}
+	waiting      map[TxnID]*lockRequest
+	aborted      map[TxnID]error
 	waitForGraph *WaitForGraph
-	mu           sync.RWMutex
+	opts         Options
+	mu           sync.Mutex
+
+	stopCh chan struct{}
+	doneCh chan struct{}
 }
 
 // NewLockManager creates a new lock manager
 func NewLockManager() *LockManager {
-	return &LockManager{
+	return NewLockManagerWithOptions(Options{})
+}
+
+// NewLockManagerWithOptions creates a lock manager. If a hold limit is
+// configured, Close must be called to stop its watchdog.
+func NewLockManagerWithOptions(opts Options) *LockManager {
+	lm := &LockManager{
 		locks:        make(map[ResourceID]*LockTable),
+		held:         make(map[TxnID]map[ResourceID]struct{}),
+		waiting:      make(map[TxnID]*lockRequest),
+		aborted:      make(map[TxnID]error),
 		waitForGraph: NewWaitForGraph(),
+		opts:         opts,
+	}
+	if opts.HoldLimit.MaxHold > 0 {
+		lm.opts.HoldLimit = opts.HoldLimit.withDefaults()
+		lm.stopCh = make(chan struct{})
+		lm.doneCh = make(chan struct{})
+		go lm.watchHolds()
+	}
+	return lm
+}
+
+// Close stops background work. Locks stay as they are.
+func (lm *LockManager) Close() {
+	if lm.stopCh != nil {
+		close(lm.stopCh)
+		<-lm.doneCh
+		lm.stopCh = nil
 	}
 }
 
-// AcquireLock acquires a lock on a resource
+// AcquireLock acquires a lock on a resource, blocking until it is granted.
+// Requesting a stronger mode on a lock already held upgrades it. If waiting
+// would close a cycle in the wait-for graph, the request fails with
+// ErrDeadlock and the caller should abort.
 func (lm *LockManager) AcquireLock(txn TxnID, resource ResourceID, mode LockMode) error {
-	// TODO: Implement lock acquisition
-	// 1. Get or create lock table for resource
-	// 2. Check compatibility with existing locks
-	// 3. If compatible, grant immediately
-	// 4. If not, add to wait queue
-	// 5. Update wait-for graph
-	// 6. Check for deadlock
-	return nil
+	lm.mu.Lock()
+	if err := lm.aborted[txn]; err != nil {
+		lm.mu.Unlock()
+		return err
+	}
+
+	table, ok := lm.locks[resource]
+	if !ok {
+		table = &LockTable{holders: make(map[TxnID]*holder)}
+		lm.locks[resource] = table
+	}
+	req := &lockRequest{
+		txnID:    txn,
+		resource: resource,
+		mode:     mode,
+		since:    time.Now(),
+		granted:  make(chan error, 1),
+	}
+	if h, ok := table.holders[txn]; ok {
+		if covers(h.mode, mode) {
+			lm.mu.Unlock()
+			return nil
+		}
+		req.mode = combine(h.mode, mode)
+		req.upgrade = true
+	}
+
+	// New requests queue behind existing waiters so writers do not starve
+	if (req.upgrade || len(table.waiters) == 0) && lm.compatibleWithHolders(table, req) {
+		lm.grant(table, req)
+		lm.mu.Unlock()
+		return nil
+	}
+
+	lm.enqueue(table, req)
+	if cycle := lm.waitForGraph.cycleThrough(txn); cycle != nil {
+		lm.cancel(table, req)
+		lm.mu.Unlock()
+		return fmt.Errorf("%w: cycle %v", ErrDeadlock, cycle)
+	}
+	lm.mu.Unlock()
+
+	return lm.wait(table, req)
+}
+
+// wait blocks until req is granted, cancelled or times out
+func (lm *LockManager) wait(table *LockTable, req *lockRequest) error {
+	var timeout <-chan time.Time
+	if lm.opts.LockTimeout > 0 {
+		timer := time.NewTimer(lm.opts.LockTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case err := <-req.granted:
+		return err
+	case <-timeout:
+		lm.mu.Lock()
+		defer lm.mu.Unlock()
+		// The grant may have raced with the timer
+		select {
+		case err := <-req.granted:
+			return err
+		default:
+		}
+		lm.cancel(table, req)
+		return ErrTimeout
+	}
 }
 
 // ReleaseLock releases a lock on a resource
 func (lm *LockManager) ReleaseLock(txn TxnID, resource ResourceID) error {
-	// TODO: Implement lock release
-	// 1. Remove from holders
-	// 2. Update wait-for graph
-	// 3. Grant locks to waiting transactions
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	table, ok := lm.locks[resource]
+	if !ok || table.holders[txn] == nil {
+		return ErrLockNotHeld
+	}
+	lm.releaseLocked(txn, resource, table)
 	return nil
 }
 
-// ReleaseAllLocks releases all locks held by a transaction
+// ReleaseAllLocks releases all locks held by a transaction and cancels its
+// pending request, ending the transaction as far as the lock manager is
+// concerned
 func (lm *LockManager) ReleaseAllLocks(txn TxnID) error {
-	// TODO: Implement release all locks
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	lm.endLocked(txn, ErrTxnAborted)
+	delete(lm.aborted, txn)
 	return nil
 }
 
 // UpgradeLock upgrades a lock from shared to exclusive
 func (lm *LockManager) UpgradeLock(txn TxnID, resource ResourceID) error {
-	// TODO: Implement lock upgrade
-	return nil
+	lm.mu.Lock()
+	table, ok := lm.locks[resource]
+	held := ok && table.holders[txn] != nil
+	lm.mu.Unlock()
+	if !held {
+		return ErrLockNotHeld
+	}
+	return lm.AcquireLock(txn, resource, ExclusiveLock)
+}
+
+// DetectDeadlock reports a cycle in the current wait-for graph
+func (lm *LockManager) DetectDeadlock() ([]TxnID, bool) {
+	return lm.waitForGraph.DetectCycle()
+}
+
+// endLocked cancels txn's pending request with err and releases its locks.
+// Caller holds lm.mu.
+func (lm *LockManager) endLocked(txn TxnID, err error) {
+	if req, ok := lm.waiting[txn]; ok {
+		lm.cancel(lm.locks[req.resource], req)
+		req.granted <- err
+	}
+	for resource := range lm.held[txn] {
+		lm.releaseLocked(txn, resource, lm.locks[resource])
+	}
+}
+
+func (lm *LockManager) releaseLocked(txn TxnID, resource ResourceID, table *LockTable) {
+	delete(table.holders, txn)
+	delete(lm.held[txn], resource)
+	if len(lm.held[txn]) == 0 {
+		delete(lm.held, txn)
+	}
+	lm.processQueue(table)
+	if len(table.holders) == 0 && len(table.waiters) == 0 {
+		delete(lm.locks, resource)
+	}
+}
+
+// compatibleWithHolders reports whether req can be granted alongside every
+// other transaction's lock
+func (lm *LockManager) compatibleWithHolders(table *LockTable, req *lockRequest) bool {
+	for txn, h := range table.holders {
+		if txn != req.txnID && !isCompatible(h.mode, req.mode) {
+			return false
+		}
+	}
+	return true
+}
+
+func (lm *LockManager) grant(table *LockTable, req *lockRequest) {
+	if h, ok := table.holders[req.txnID]; ok {
+		h.mode = req.mode
+	} else {
+		table.holders[req.txnID] = &holder{mode: req.mode, since: time.Now()}
+	}
+	if lm.held[req.txnID] == nil {
+		lm.held[req.txnID] = make(map[ResourceID]struct{})
+	}
+	lm.held[req.txnID][req.resource] = struct{}{}
+}
+
+// enqueue adds req to the wait queue, upgrades ahead of new requests
+func (lm *LockManager) enqueue(table *LockTable, req *lockRequest) {
+	pos := len(table.waiters)
+	if req.upgrade {
+		pos = 0
+		for pos < len(table.waiters) && table.waiters[pos].upgrade {
+			pos++
+		}
+	}
+	table.waiters = append(table.waiters, nil)
+	copy(table.waiters[pos+1:], table.waiters[pos:])
+	table.waiters[pos] = req
+	lm.waiting[req.txnID] = req
+	lm.updateEdges(table)
+}
+
+// cancel removes a waiting request without answering it
+func (lm *LockManager) cancel(table *LockTable, req *lockRequest) {
+	for i, w := range table.waiters {
+		if w == req {
+			table.waiters = append(table.waiters[:i], table.waiters[i+1:]...)
+			break
+		}
+	}
+	delete(lm.waiting, req.txnID)
+	lm.waitForGraph.RemoveWaiter(req.txnID)
+	lm.processQueue(table)
+	if len(table.holders) == 0 && len(table.waiters) == 0 && lm.locks[req.resource] == table {
+		delete(lm.locks, req.resource)
+	}
+}
+
+// processQueue grants waiters in FIFO order until one is blocked
+func (lm *LockManager) processQueue(table *LockTable) {
+	for len(table.waiters) > 0 {
+		req := table.waiters[0]
+		if !lm.compatibleWithHolders(table, req) {
+			break
+		}
+		table.waiters = table.waiters[1:]
+		delete(lm.waiting, req.txnID)
+		lm.waitForGraph.RemoveWaiter(req.txnID)
+		lm.grant(table, req)
+		req.granted <- nil
+	}
+	lm.updateEdges(table)
+}
+
+// updateEdges recomputes the wait-for edges of the table's waiters: each
+// waits for incompatible holders and incompatible requests queued ahead
+func (lm *LockManager) updateEdges(table *LockTable) {
+	for i, w := range table.waiters {
+		lm.waitForGraph.RemoveWaiter(w.txnID)
+		for txn, h := range table.holders {
+			if txn != w.txnID && !isCompatible(h.mode, w.mode) {
+				lm.waitForGraph.AddEdge(w.txnID, txn)
+			}
+		}
+		for _, ahead := range table.waiters[:i] {
+			if ahead.txnID != w.txnID && !isCompatible(ahead.mode, w.mode) {
+				lm.waitForGraph.AddEdge(w.txnID, ahead.txnID)
+			}
+		}
+	}
 }
 
 // isCompatible checks if lock modes are compatible
+//
+//	     IS  IX  S   X
+//	IS   y   y   y   n
+//	IX   y   y   n   n
+//	S    y   n   y   n
+//	X    n   n   n   n
 func isCompatible(mode1, mode2 LockMode) bool {
-	// TODO: Implement compatibility matrix
-	// S is compatible with S
-	// X is not compatible with any
-	return false
+	if mode1 == ExclusiveLock || mode2 == ExclusiveLock {
+		return false
+	}
+	if mode1 == IntentionShared || mode2 == IntentionShared {
+		return true
+	}
+	return mode1 == mode2
+}
+
+// covers reports whether a held mode already grants everything wanted
+func covers(held, wanted LockMode) bool {
+	switch {
+	case held == wanted || held == ExclusiveLock:
+		return true
+	case wanted == IntentionShared:
+		return held == SharedLock || held == IntentionExclusive
+	default:
+		return false
+	}
+}
+
+// combine returns the weakest mode covering both; S and IX combine to X
+// since there is no SIX mode
+func combine(held, wanted LockMode) LockMode {
+	switch {
+	case covers(held, wanted):
+		return held
+	case covers(wanted, held):
+		return wanted
+	default:
+		return ExclusiveLock
+	}
 }
@@ -1,34 +1,423 @@
 package lockmanager
 
-import "testing"
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// acquireAsync starts AcquireLock in a goroutine and returns its result
+func acquireAsync(lm *LockManager, txn TxnID, resource ResourceID, mode LockMode) <-chan error {
+	done := make(chan error, 1)
+	go func() { done <- lm.AcquireLock(txn, resource, mode) }()
+	return done
+}
 
 func TestAcquireLock(t *testing.T) {
-	// TODO: Test basic lock acquisition
-	t.Skip("not implemented")
+	lm := NewLockManager()
+	if err := lm.AcquireLock(1, "a", ExclusiveLock); err != nil {
+		t.Fatalf("AcquireLock: %v", err)
+	}
+	// Re-acquiring a held mode is a no-op
+	if err := lm.AcquireLock(1, "a", SharedLock); err != nil {
+		t.Fatalf("re-acquire: %v", err)
+	}
+	if err := lm.ReleaseLock(1, "a"); err != nil {
+		t.Fatalf("ReleaseLock: %v", err)
+	}
+	if err := lm.ReleaseLock(1, "a"); !errors.Is(err, ErrLockNotHeld) {
+		t.Fatalf("second ReleaseLock = %v, want ErrLockNotHeld", err)
+	}
+	if len(lm.locks) != 0 {
+		t.Fatalf("%d lock tables left after release", len(lm.locks))
+	}
 }
 
 func TestLockCompatibility(t *testing.T) {
-	// TODO: Test lock compatibility matrix
-	t.Skip("not implemented")
+	modes := []LockMode{IntentionShared, IntentionExclusive, SharedLock, ExclusiveLock}
+	want := map[[2]LockMode]bool{
+		{IntentionShared, IntentionShared}:       true,
+		{IntentionShared, IntentionExclusive}:    true,
+		{IntentionShared, SharedLock}:            true,
+		{IntentionExclusive, IntentionExclusive}: true,
+		{SharedLock, SharedLock}:                 true,
+	}
+	for _, a := range modes {
+		for _, b := range modes {
+			expected := want[[2]LockMode{a, b}] || want[[2]LockMode{b, a}]
+			if got := isCompatible(a, b); got != expected {
+				t.Errorf("isCompatible(%s, %s) = %v, want %v", a, b, got, expected)
+			}
+		}
+	}
+
+	synctest.Test(t, func(t *testing.T) {
+		lm := NewLockManager()
+		if err := lm.AcquireLock(1, "r", SharedLock); err != nil {
+			t.Fatal(err)
+		}
+		if err := lm.AcquireLock(2, "r", IntentionShared); err != nil {
+			t.Fatal(err)
+		}
+		done := acquireAsync(lm, 3, "r", IntentionExclusive)
+		synctest.Wait()
+		select {
+		case err := <-done:
+			t.Fatalf("IX granted alongside S: %v", err)
+		default:
+		}
+		lm.ReleaseLock(1, "r")
+		if err := <-done; err != nil {
+			t.Fatalf("IX after S released: %v", err)
+		}
+	})
 }
 
 func TestDeadlockDetection(t *testing.T) {
-	// TODO: Test deadlock detection
-	t.Skip("not implemented")
+	synctest.Test(t, func(t *testing.T) {
+		lm := NewLockManager()
+		lm.AcquireLock(1, "a", ExclusiveLock)
+		lm.AcquireLock(2, "b", ExclusiveLock)
+
+		first := acquireAsync(lm, 1, "b", ExclusiveLock)
+		synctest.Wait()
+		if cycle, found := lm.DetectDeadlock(); found {
+			t.Fatalf("cycle %v before deadlock", cycle)
+		}
+
+		// Txn 2 closes the cycle and is chosen as victim
+		if err := lm.AcquireLock(2, "a", ExclusiveLock); !errors.Is(err, ErrDeadlock) {
+			t.Fatalf("AcquireLock = %v, want ErrDeadlock", err)
+		}
+		lm.ReleaseAllLocks(2)
+		if err := <-first; err != nil {
+			t.Fatalf("txn 1 after victim aborted: %v", err)
+		}
+	})
 }
 
 func TestLockUpgrade(t *testing.T) {
-	// TODO: Test lock upgrade
-	t.Skip("not implemented")
+	synctest.Test(t, func(t *testing.T) {
+		lm := NewLockManager()
+		lm.AcquireLock(1, "r", SharedLock)
+		lm.AcquireLock(2, "r", SharedLock)
+		if err := lm.UpgradeLock(3, "r"); !errors.Is(err, ErrLockNotHeld) {
+			t.Fatalf("UpgradeLock without lock = %v, want ErrLockNotHeld", err)
+		}
+
+		// A new request queued first must not block the upgrade
+		waiter := acquireAsync(lm, 3, "r", ExclusiveLock)
+		synctest.Wait()
+		upgraded := make(chan error, 1)
+		go func() { upgraded <- lm.UpgradeLock(1, "r") }()
+		synctest.Wait()
+
+		// Two upgrades of the same shared lock deadlock
+		if err := lm.UpgradeLock(2, "r"); !errors.Is(err, ErrDeadlock) {
+			t.Fatalf("second upgrade = %v, want ErrDeadlock", err)
+		}
+		lm.ReleaseAllLocks(2)
+		if err := <-upgraded; err != nil {
+			t.Fatalf("UpgradeLock: %v", err)
+		}
+		if mode := lm.locks["r"].holders[1].mode; mode != ExclusiveLock {
+			t.Fatalf("mode after upgrade = %s, want X", mode)
+		}
+		lm.ReleaseAllLocks(1)
+		if err := <-waiter; err != nil {
+			t.Fatalf("waiter: %v", err)
+		}
+	})
+}
+
+func TestLockTimeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		lm := NewLockManagerWithOptions(Options{LockTimeout: 50 * time.Millisecond})
+		lm.AcquireLock(1, "r", ExclusiveLock)
+		start := time.Now()
+		if err := lm.AcquireLock(2, "r", SharedLock); !errors.Is(err, ErrTimeout) {
+			t.Fatalf("AcquireLock = %v, want ErrTimeout", err)
+		}
+		if waited := time.Since(start); waited != 50*time.Millisecond {
+			t.Fatalf("waited %v, want 50ms", waited)
+		}
+		if len(lm.waiting) != 0 || len(lm.locks["r"].waiters) != 0 {
+			t.Fatal("timed out request still queued")
+		}
+	})
 }
 
 func TestConcurrentLocks(t *testing.T) {
-	// TODO: Test concurrent lock acquisition
-	// Use -race flag
-	t.Skip("not implemented")
+	lm := NewLockManager()
+	counters := make([]int, 4)
+	var wg sync.WaitGroup
+	for i := range 16 {
+		wg.Add(1)
+		go func(txn TxnID) {
+			defer wg.Done()
+			for j := range 200 {
+				resource := ResourceID(fmt.Sprint(j % len(counters)))
+				if err := lm.AcquireLock(txn, resource, ExclusiveLock); err != nil {
+					t.Error(err)
+					return
+				}
+				counters[j%len(counters)]++
+				lm.ReleaseAllLocks(txn)
+			}
+		}(TxnID(i + 1))
+	}
+	wg.Wait()
+	for i, n := range counters {
+		if n != 16*200/len(counters) {
+			t.Errorf("counter %d = %d, want %d", i, n, 16*200/len(counters))
+		}
+	}
+}
+
+func TestHoldLimitAbortsBlocker(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var violations []HoldViolation
+		var mu sync.Mutex
+		lm := NewLockManagerWithOptions(Options{HoldLimit: HoldLimit{
+			MaxHold:       100 * time.Millisecond,
+			CheckInterval: 10 * time.Millisecond,
+			OnAbort: func(v HoldViolation) {
+				mu.Lock()
+				defer mu.Unlock()
+				violations = append(violations, v)
+			},
+		}})
+		defer lm.Close()
+
+		lm.AcquireLock(1, "a", ExclusiveLock)
+		lm.AcquireLock(1, "b", SharedLock)
+		// Holding long without waiters is fine
+		time.Sleep(time.Second)
+		synctest.Wait()
+		if len(violations) != 0 {
+			t.Fatalf("aborted without waiters: %+v", violations)
+		}
+
+		waiter := acquireAsync(lm, 2, "a", SharedLock)
+		if err := <-waiter; err != nil {
+			t.Fatalf("waiter: %v", err)
+		}
+		synctest.Wait()
+
+		mu.Lock()
+		if len(violations) != 1 || violations[0].Txn != 1 || violations[0].Resource != "a" ||
+			len(violations[0].Waiters) != 1 || violations[0].Waiters[0] != 2 {
+			t.Fatalf("violations = %+v", violations)
+		}
+		mu.Unlock()
+		if _, ok := lm.held[1]; ok {
+			t.Fatal("victim kept locks")
+		}
+		if err := lm.AcquireLock(1, "c", SharedLock); !errors.Is(err, ErrHoldLimitExceeded) {
+			t.Fatalf("victim AcquireLock = %v, want ErrHoldLimitExceeded", err)
+		}
+		lm.ReleaseAllLocks(1)
+		if err := lm.AcquireLock(1, "c", SharedLock); err != nil {
+			t.Fatalf("AcquireLock after ReleaseAllLocks: %v", err)
+		}
+	})
+}
+
+func TestHoldLimitGraceAndExempt(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		lm := NewLockManagerWithOptions(Options{HoldLimit: HoldLimit{
+			MaxHold:       100 * time.Millisecond,
+			Grace:         200 * time.Millisecond,
+			CheckInterval: 10 * time.Millisecond,
+			Exempt:        func(txn TxnID) bool { return txn == 1 },
+		}})
+		defer lm.Close()
+
+		lm.AcquireLock(1, "a", ExclusiveLock)
+		lm.AcquireLock(2, "b", ExclusiveLock)
+		time.Sleep(time.Second)
+
+		exempt := acquireAsync(lm, 3, "a", SharedLock)
+		start := time.Now()
+		// Txn 2 is past MaxHold but its waiter gets Grace first
+		blocked := acquireAsync(lm, 4, "b", SharedLock)
+		if err := <-blocked; err != nil {
+			t.Fatalf("waiter: %v", err)
+		}
+		if waited := time.Since(start); waited < 200*time.Millisecond {
+			t.Fatalf("blocker aborted after %v, before grace", waited)
+		}
+
+		time.Sleep(time.Second)
+		synctest.Wait()
+		select {
+		case err := <-exempt:
+			t.Fatalf("exempt holder was aborted: %v", err)
+		default:
+		}
+		lm.ReleaseAllLocks(1)
+		if err := <-exempt; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestHoldLimitAbortsWaitingVictim(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		lm := NewLockManagerWithOptions(Options{HoldLimit: HoldLimit{MaxHold: 100 * time.Millisecond}})
+		defer lm.Close()
+
+		// Txn 1 holds "a" and is itself stuck waiting for "b"
+		lm.AcquireLock(1, "a", ExclusiveLock)
+		lm.AcquireLock(3, "b", ExclusiveLock)
+		victim := acquireAsync(lm, 1, "b", ExclusiveLock)
+		synctest.Wait()
+		waiter := acquireAsync(lm, 2, "a", ExclusiveLock)
+
+		// Txn 3 keeps only the victim waiting; both exceed MaxHold
+		if err := <-victim; !errors.Is(err, ErrHoldLimitExceeded) {
+			t.Fatalf("victim = %v, want ErrHoldLimitExceeded", err)
+		}
+		if err := <-waiter; err != nil {
+			t.Fatalf("waiter: %v", err)
+		}
+		// With the victim gone txn 3 blocks nobody and keeps "b"
+		synctest.Wait()
+		lm.mu.Lock()
+		_, kept := lm.held[3]["b"]
+		lm.mu.Unlock()
+		if !kept {
+			t.Fatal("txn 3 aborted although its only waiter was already a victim")
+		}
+		lm.ReleaseAllLocks(3)
+		lm.ReleaseAllLocks(2)
+		lm.ReleaseAllLocks(1)
+	})
+}
+
+func TestHoldLimitMutualBlockers(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		var violations []HoldViolation
+		var mu sync.Mutex
+		lm := NewLockManagerWithOptions(Options{HoldLimit: HoldLimit{
+			MaxHold:       100 * time.Millisecond,
+			CheckInterval: 10 * time.Millisecond,
+			OnAbort: func(v HoldViolation) {
+				mu.Lock()
+				defer mu.Unlock()
+				violations = append(violations, v)
+			},
+		}})
+		defer lm.Close()
+
+		lm.AcquireLock(1, "a", ExclusiveLock)
+		lm.AcquireLock(2, "b", ExclusiveLock)
+		first := acquireAsync(lm, 1, "b", ExclusiveLock)
+		synctest.Wait()
+		// AcquireLock refuses the wait that closes the cycle, so queue it
+		// directly: each holder is now the other's only waiter
+		second := &lockRequest{txnID: 2, resource: "a", mode: ExclusiveLock, since: time.Now(), granted: make(chan error, 1)}
+		lm.mu.Lock()
+		lm.enqueue(lm.locks["a"], second)
+		lm.mu.Unlock()
+
+		if err := <-first; !errors.Is(err, ErrHoldLimitExceeded) {
+			t.Fatalf("txn 1 = %v, want ErrHoldLimitExceeded", err)
+		}
+		if err := <-second.granted; err != nil {
+			t.Fatalf("txn 2 = %v, want the lock", err)
+		}
+		time.Sleep(time.Second)
+		synctest.Wait()
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(violations) != 1 || violations[0].Txn != 1 {
+			t.Fatalf("violations = %+v, want only txn 1", violations)
+		}
+		lm.ReleaseAllLocks(2)
+		lm.ReleaseAllLocks(1)
+	})
+}
+
+func TestAcquireAll(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		lm := NewLockManager()
+		// Requests for one resource are merged: S and IX make X
+		err := lm.AcquireAll(1, []LockRequest{{"b", ExclusiveLock}, {"a", SharedLock}, {"a", IntentionExclusive}})
+		if err != nil {
+			t.Fatalf("AcquireAll: %v", err)
+		}
+		for _, r := range []ResourceID{"a", "b"} {
+			if mode, _ := lm.heldModeLocked(1, r); mode != ExclusiveLock {
+				t.Errorf("mode of %s = %s, want X", r, mode)
+			}
+		}
+
+		// Listed in the opposite order, txn 2 still waits for "a" first, and
+		// holds nothing while it waits
+		done := make(chan error, 1)
+		go func() { done <- lm.AcquireAll(2, []LockRequest{{"b", SharedLock}, {"a", SharedLock}}) }()
+		synctest.Wait()
+		if len(lm.held[2]) != 0 || lm.waiting[2].resource != "a" {
+			t.Fatalf("txn 2 holds %v, waits for %+v", lm.held[2], lm.waiting[2])
+		}
+		lm.ReleaseAllLocks(1)
+		if err := <-done; err != nil {
+			t.Fatalf("second AcquireAll: %v", err)
+		}
+	})
+}
+
+func TestAcquireAllRollback(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		lm := NewLockManagerWithOptions(Options{LockTimeout: 50 * time.Millisecond})
+		lm.AcquireLock(1, "a", SharedLock)
+		lm.AcquireLock(2, "c", ExclusiveLock)
+
+		// "c" times out: "b" is released and "a" goes back to S
+		err := lm.AcquireAll(1, []LockRequest{{"a", ExclusiveLock}, {"b", ExclusiveLock}, {"c", SharedLock}})
+		if !errors.Is(err, ErrTimeout) {
+			t.Fatalf("AcquireAll = %v, want ErrTimeout", err)
+		}
+		if mode, held := lm.heldModeLocked(1, "a"); !held || mode != SharedLock {
+			t.Errorf("a after rollback: %s, held %v; want S", mode, held)
+		}
+		if _, held := lm.heldModeLocked(1, "b"); held {
+			t.Error("b still held after rollback")
+		}
+		if err := lm.AcquireLock(3, "a", SharedLock); err != nil {
+			t.Fatalf("S on a after rollback: %v", err)
+		}
+
+		// A deadlock on a later lock releases the earlier ones too: txn 2
+		// still holds "c" and waits for txn 1
+		lm.ReleaseAllLocks(1)
+		lm.ReleaseAllLocks(3)
+		lm.AcquireLock(1, "x", ExclusiveLock)
+		waiter := acquireAsync(lm, 2, "x", ExclusiveLock)
+		synctest.Wait()
+		err = lm.AcquireAll(1, []LockRequest{{"b", ExclusiveLock}, {"c", ExclusiveLock}})
+		if !errors.Is(err, ErrDeadlock) {
+			t.Fatalf("AcquireAll = %v, want ErrDeadlock", err)
+		}
+		if _, held := lm.heldModeLocked(1, "b"); held {
+			t.Error("b still held after deadlock")
+		}
+		lm.ReleaseAllLocks(1)
+		if err := <-waiter; err != nil {
+			t.Fatal(err)
+		}
+	})
 }
 
 func BenchmarkAcquireLock(b *testing.B) {
-	// TODO: Benchmark lock acquisition speed
-	b.Skip("not implemented")
+	lm := NewLockManager()
+	for i := 0; b.Loop(); i++ {
+		lm.AcquireLock(TxnID(i), "r", ExclusiveLock)
+		lm.ReleaseLock(TxnID(i), "r")
+	}
 }
@@ -0,0 +1,204 @@
+package mvcc
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ChangeListener is told about every key a commit changes, so an index or
+// cache can follow the store without polling it. oldVal is nil for a key
+// the commit created.
+//
+// Guarantees:
+//   - Changes arrive in commit order, and the keys of one commit in key
+//     order. Calls are never concurrent for one listener.
+//   - Delivery is at least once: if OnCommit returns an error, the same
+//     change is delivered again after a backoff, and later changes wait
+//     for it. A listener should therefore be idempotent, for example by
+//     ignoring a change whose ts it has already applied for the key.
+//   - OnCommit runs after the commit is visible and outside the store's
+//     locks, so it may read the store; a slow listener delays only its own
+//     deliveries.
+type ChangeListener interface {
+	OnCommit(key Key, oldVal, newVal Value, ts Timestamp) error
+}
+
+// ChangeListenerFunc adapts a function to a ChangeListener
+type ChangeListenerFunc func(key Key, oldVal, newVal Value, ts Timestamp) error
+
+func (f ChangeListenerFunc) OnCommit(key Key, oldVal, newVal Value, ts Timestamp) error {
+	return f(key, oldVal, newVal, ts)
+}
+
+// listenerRetry paces the redelivery of a change its listener rejected
+var listenerRetry = RetryOptions{BaseDelay: time.Millisecond, MaxDelay: time.Second}
+
+// change is one key changed by a commit
+type change struct {
+	key      Key
+	old, new Value
+	ts       Timestamp
+}
+
+// Subscription delivers commits to one ChangeListener from its own
+// goroutine. Commits queue up without bound while the listener is behind.
+type Subscription struct {
+	store    *MVCCStore
+	listener ChangeListener
+	start    Timestamp
+
+	mu    sync.Mutex
+	queue []change
+	// idle is closed while the queue is empty
+	idle       chan struct{}
+	idleClosed bool
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// AddChangeListener starts delivering every commit after the current one
+// to l. Start on the returned subscription is the last commit l is not
+// told about, so an index can be built by reading at Start (see ExportAt)
+// and then kept up to date by l.
+func (s *MVCCStore) AddChangeListener(l ChangeListener) *Subscription {
+	idle := make(chan struct{})
+	close(idle)
+	sub := &Subscription{
+		store:      s,
+		listener:   l,
+		idle:       idle,
+		idleClosed: true,
+		wake:       make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	sub.start = Timestamp(s.clock.Load())
+	s.listeners = append(s.listeners, sub)
+	s.mu.Unlock()
+
+	go sub.run()
+	return sub
+}
+
+// Start returns the timestamp of the last commit made before the
+// subscription; it receives every commit after it
+func (sub *Subscription) Start() Timestamp {
+	return sub.start
+}
+
+// Wait blocks until every change queued so far has been delivered, or ctx
+// is done, or the subscription is closed
+func (sub *Subscription) Wait(ctx context.Context) error {
+	sub.mu.Lock()
+	idle := sub.idle
+	sub.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-sub.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops delivery, waiting for a call in progress to return. Changes
+// not delivered yet are dropped.
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		s := sub.store
+		s.mu.Lock()
+		s.listeners = slices.DeleteFunc(s.listeners, func(l *Subscription) bool { return l == sub })
+		s.mu.Unlock()
+		close(sub.stop)
+	})
+	<-sub.done
+}
+
+// enqueue adds the changes of a commit. The store calls it with s.mu held,
+// so queues are in commit order.
+func (sub *Subscription) enqueue(changes []change) {
+	sub.mu.Lock()
+	if sub.idleClosed {
+		sub.idle = make(chan struct{})
+		sub.idleClosed = false
+	}
+	sub.queue = append(sub.queue, changes...)
+	sub.mu.Unlock()
+
+	select {
+	case sub.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (sub *Subscription) run() {
+	defer close(sub.done)
+	for {
+		sub.mu.Lock()
+		if len(sub.queue) == 0 {
+			if !sub.idleClosed {
+				close(sub.idle)
+				sub.idleClosed = true
+			}
+			sub.mu.Unlock()
+			select {
+			case <-sub.wake:
+				continue
+			case <-sub.stop:
+				return
+			}
+		}
+		c := sub.queue[0]
+		sub.mu.Unlock()
+
+		// The change stays at the head of the queue until it is accepted
+		for attempt := 1; sub.listener.OnCommit(c.key, c.old, c.new, c.ts) != nil; attempt++ {
+			if !sub.sleep(backoff(listenerRetry, attempt)) {
+				return
+			}
+		}
+		select {
+		case <-sub.stop:
+			return
+		default:
+		}
+
+		sub.mu.Lock()
+		sub.queue[0] = change{}
+		sub.queue = sub.queue[1:]
+		sub.mu.Unlock()
+	}
+}
+
+// sleep waits for d and reports false if the subscription closed first
+func (sub *Subscription) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-sub.stop:
+		return false
+	}
+}
+
+// notifyListeners queues the changes of a commit for every listener.
+// Caller holds s.mu, after installing the commit's versions.
+func (s *MVCCStore) notifyListeners(changes []change) {
+	if len(s.listeners) == 0 {
+		return
+	}
+	slices.SortFunc(changes, func(a, b change) int { return cmp.Compare(a.key, b.key) })
+	for _, sub := range s.listeners {
+		sub.enqueue(changes)
+	}
+}
@@ -2,6 +2,8 @@ package mvcc
 
 import (
 	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,15 +20,30 @@ var (
 	ErrWriteConflict = errors.New("write conflict")
 	ErrKeyNotFound   = errors.New("key not found")
 	ErrTxnAborted    = errors.New("transaction aborted")
+	ErrTxnFinished   = errors.New("transaction already committed or aborted")
 )
 
+// ConflictError reports the keys that made a commit fail. It matches
+// ErrWriteConflict with errors.Is.
+type ConflictError struct {
+	Keys []Key
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("write conflict on %d key(s): %v", len(e.Keys), e.Keys)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return ErrWriteConflict
+}
+
 // Version represents a single version of a value
 type Version struct {
 	data    Value
 	beginTS Timestamp
-	endTS   *Timestamp  // nil if latest version
+	endTS   *Timestamp // nil if latest version
 	txnID   TxnID
-	prev    *Version    // previous version (could use weak.Pointer in Go 1.24)
+	prev    *Version // previous version (could use weak.Pointer in Go 1.24)
 }
 
 // VersionChain is a linked list of versions
@@ -41,86 +58,213 @@ type Transaction struct {
 	snapshot Timestamp
 	writeSet map[Key]*Version
 	readSet  map[Key]Timestamp
+	done     bool
 	mu       sync.Mutex
 }
 
+// ID returns the transaction's identifier
+func (t *Transaction) ID() TxnID {
+	return t.id
+}
+
+// Snapshot returns the timestamp the transaction reads at
+func (t *Transaction) Snapshot() Timestamp {
+	return t.snapshot
+}
+
 // MVCCStore implements multi-version concurrency control
 type MVCCStore struct {
-	data        map[Key]*VersionChain
+	data         map[Key]*VersionChain
 	transactions map[TxnID]*Transaction
-	clock       atomic.Uint64
-	mu          sync.RWMutex
-	gc          *GarbageCollector
+	clock        atomic.Uint64
+	nextTxnID    atomic.Uint64
+	mu           sync.RWMutex
+	gc           *GarbageCollector
+	// horizon is the oldest snapshot GC has kept every version for
+	horizon atomic.Uint64
+
+	retry      RetryOptions
+	contention contentionTracker
+	listeners  []*Subscription
 }
 
 // NewMVCCStore creates a new MVCC store
 func NewMVCCStore() *MVCCStore {
 	store := &MVCCStore{
-		data:        make(map[Key]*VersionChain),
+		data:         make(map[Key]*VersionChain),
 		transactions: make(map[TxnID]*Transaction),
+		retry:        RetryOptions{}.withDefaults(),
 	}
 	store.gc = NewGarbageCollector(store)
 	return store
 }
 
-// BeginTransaction starts a new transaction
+// BeginTransaction starts a new transaction that sees every commit made
+// before it began
 func (s *MVCCStore) BeginTransaction() *Transaction {
-	// TODO: Implement transaction start
-	// Assign snapshot timestamp
-	return nil
+	txn := &Transaction{
+		id:       TxnID(s.nextTxnID.Add(1)),
+		writeSet: make(map[Key]*Version),
+		readSet:  make(map[Key]Timestamp),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	txn.snapshot = Timestamp(s.clock.Load())
+	s.transactions[txn.id] = txn
+	return txn
 }
 
-// Read reads a value at the transaction's snapshot
+// Read reads a value at the transaction's snapshot. A transaction sees its
+// own uncommitted writes.
 func (s *MVCCStore) Read(txn *Transaction, key Key) (Value, error) {
-	// TODO: Implement MVCC read
-	// 1. Get version chain
-	// 2. Find visible version based on snapshot
-	// 3. Add to read set
-	return nil, nil
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return nil, ErrTxnFinished
+	}
+	if v, ok := txn.writeSet[key]; ok {
+		return v.data, nil
+	}
+
+	// Commits install versions under the exclusive lock, so a snapshot
+	// never observes a commit half applied
+	s.mu.RLock()
+	chain, ok := s.data[key]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	chain.mu.RLock()
+	defer chain.mu.RUnlock()
+	for v := chain.latest; v != nil; v = v.prev {
+		if s.isVisible(v, txn.snapshot) {
+			txn.readSet[key] = v.beginTS
+			return v.data, nil
+		}
+	}
+	return nil, ErrKeyNotFound
 }
 
-// Write writes a value in the transaction
+// Write writes a value in the transaction. The write is buffered and
+// applied at commit.
 func (s *MVCCStore) Write(txn *Transaction, key Key, value Value) error {
-	// TODO: Implement MVCC write
-	// 1. Add to write set (don't write yet)
-	// 2. Will be applied at commit
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnFinished
+	}
+	txn.writeSet[key] = &Version{
+		data:  append(Value(nil), value...),
+		txnID: txn.id,
+	}
 	return nil
 }
 
-// Commit commits a transaction
+// Commit commits a transaction. If another transaction committed one of
+// its keys after its snapshot, the transaction is aborted and a
+// *ConflictError is returned (first committer wins).
 func (s *MVCCStore) Commit(txn *Transaction) error {
-	// TODO: Implement commit
-	// 1. Check for write conflicts
-	// 2. Apply write set
-	// 3. Create new versions
-	// 4. Update timestamps
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnFinished
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	txn.done = true
+	delete(s.transactions, txn.id)
+
+	if err := s.detectConflict(txn); err != nil {
+		return err
+	}
+	if len(txn.writeSet) == 0 {
+		return nil
+	}
+
+	commitTS := Timestamp(s.clock.Add(1))
+	var changes []change
+	if len(s.listeners) > 0 {
+		changes = make([]change, 0, len(txn.writeSet))
+	}
+	for key, v := range txn.writeSet {
+		chain, ok := s.data[key]
+		if !ok {
+			chain = &VersionChain{}
+			s.data[key] = chain
+		}
+
+		chain.mu.Lock()
+		if changes != nil {
+			c := change{key: key, new: v.data, ts: commitTS}
+			if chain.latest != nil {
+				c.old = chain.latest.data
+			}
+			changes = append(changes, c)
+		}
+		v.beginTS = commitTS
+		v.prev = chain.latest
+		if chain.latest != nil {
+			end := commitTS
+			chain.latest.endTS = &end
+		}
+		chain.latest = v
+		chain.mu.Unlock()
+	}
+	s.notifyListeners(changes)
 	return nil
 }
 
-// Abort aborts a transaction
+// Abort aborts a transaction, discarding its writes
 func (s *MVCCStore) Abort(txn *Transaction) error {
-	// TODO: Implement abort
-	// Discard write set
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+	if txn.done {
+		return ErrTxnFinished
+	}
+	txn.done = true
+	txn.writeSet = nil
+
+	s.mu.Lock()
+	delete(s.transactions, txn.id)
+	s.mu.Unlock()
 	return nil
 }
 
 // isVisible checks if a version is visible to a transaction
 func (s *MVCCStore) isVisible(version *Version, snapshot Timestamp) bool {
-	// TODO: Implement visibility check
-	// version.beginTS <= snapshot && (version.endTS == nil || version.endTS > snapshot)
-	return false
+	return version.beginTS <= snapshot && (version.endTS == nil || *version.endTS > snapshot)
 }
 
-// detectConflict checks for write-write conflicts
+// detectConflict checks for write-write conflicts: a written key whose
+// latest version committed after the snapshot. Caller holds s.mu.
 func (s *MVCCStore) detectConflict(txn *Transaction) error {
-	// TODO: Implement conflict detection
-	// Check if any written keys were modified after snapshot
-	return nil
+	var conflicts []Key
+	for key := range txn.writeSet {
+		chain, ok := s.data[key]
+		if !ok {
+			continue
+		}
+		chain.mu.RLock()
+		if chain.latest != nil && chain.latest.beginTS > txn.snapshot {
+			conflicts = append(conflicts, key)
+		}
+		chain.mu.RUnlock()
+	}
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i] < conflicts[j] })
+	s.contention.record(conflicts)
+	return &ConflictError{Keys: conflicts}
 }
 
 // GarbageCollector removes old versions
 type GarbageCollector struct {
-	store *MVCCStore
+	store  *MVCCStore
 	stopCh chan struct{}
 	doneCh chan struct{}
 }
@@ -152,9 +296,28 @@ func (gc *GarbageCollector) run() {
 }
 
 func (gc *GarbageCollector) collect() {
-	// TODO: Implement garbage collection
-	// 1. Find oldest active transaction snapshot
-	// 2. Remove versions older than oldest snapshot
+	s := gc.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// Versions that ended at or before the oldest snapshot are invisible to
+	// every active and future transaction
+	oldest := Timestamp(s.clock.Load())
+	for _, txn := range s.transactions {
+		oldest = min(oldest, txn.snapshot)
+	}
+	s.horizon.Store(uint64(max(oldest, Timestamp(s.horizon.Load()))))
+
+	for _, chain := range s.data {
+		chain.mu.Lock()
+		for v := chain.latest; v != nil; v = v.prev {
+			if s.isVisible(v, oldest) {
+				v.prev = nil
+				break
+			}
+		}
+		chain.mu.Unlock()
+	}
 }
 
 func (gc *GarbageCollector) Stop() {
@@ -1,46 +1,433 @@
 package mvcc
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+// put commits a single write
+func put(t *testing.T, s *MVCCStore, key Key, value string) {
+	t.Helper()
+	txn := s.BeginTransaction()
+	if err := s.Write(txn, key, Value(value)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func read(t *testing.T, s *MVCCStore, txn *Transaction, key Key) string {
+	t.Helper()
+	v, err := s.Read(txn, key)
+	if err != nil {
+		t.Fatalf("Read(%q): %v", key, err)
+	}
+	return string(v)
+}
 
 func TestBeginTransaction(t *testing.T) {
-	// TODO: Test transaction creation
-	t.Skip("not implemented")
+	s := NewMVCCStore()
+	a, b := s.BeginTransaction(), s.BeginTransaction()
+	if a.ID() == b.ID() {
+		t.Errorf("transactions share ID %d", a.ID())
+	}
+	put(t, s, "k", "v")
+	if c := s.BeginTransaction(); c.Snapshot() <= a.Snapshot() {
+		t.Errorf("snapshot %d not after commit (earlier snapshot %d)", c.Snapshot(), a.Snapshot())
+	}
 }
 
 func TestRead(t *testing.T) {
-	// TODO: Test MVCC read
-	t.Skip("not implemented")
+	s := NewMVCCStore()
+	txn := s.BeginTransaction()
+	if _, err := s.Read(txn, "missing"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Read(missing) error = %v, want ErrKeyNotFound", err)
+	}
+	put(t, s, "k", "v1")
+	if got := read(t, s, s.BeginTransaction(), "k"); got != "v1" {
+		t.Errorf("Read = %q, want v1", got)
+	}
 }
 
 func TestWrite(t *testing.T) {
-	// TODO: Test MVCC write
-	t.Skip("not implemented")
+	s := NewMVCCStore()
+	txn := s.BeginTransaction()
+	s.Write(txn, "k", Value("mine"))
+	if got := read(t, s, txn, "k"); got != "mine" {
+		t.Errorf("own write not visible: %q", got)
+	}
+	if _, err := s.Read(s.BeginTransaction(), "k"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("uncommitted write visible to others: %v", err)
+	}
 }
 
 func TestCommit(t *testing.T) {
-	// TODO: Test transaction commit
-	t.Skip("not implemented")
+	s := NewMVCCStore()
+	put(t, s, "k", "v1")
+	put(t, s, "k", "v2")
+	if got := read(t, s, s.BeginTransaction(), "k"); got != "v2" {
+		t.Errorf("Read = %q, want v2", got)
+	}
+
+	txn := s.BeginTransaction()
+	s.Commit(txn)
+	if err := s.Commit(txn); !errors.Is(err, ErrTxnFinished) {
+		t.Errorf("second Commit error = %v, want ErrTxnFinished", err)
+	}
+	if err := s.Write(txn, "k", nil); !errors.Is(err, ErrTxnFinished) {
+		t.Errorf("Write after commit error = %v, want ErrTxnFinished", err)
+	}
 }
 
 func TestWriteConflict(t *testing.T) {
-	// TODO: Test write conflict detection
-	t.Skip("not implemented")
+	s := NewMVCCStore()
+	a, b := s.BeginTransaction(), s.BeginTransaction()
+	s.Write(a, "x", Value("a"))
+	s.Write(b, "x", Value("b"))
+	s.Write(b, "y", Value("b"))
+	if err := s.Commit(a); err != nil {
+		t.Fatal(err)
+	}
+
+	err := s.Commit(b)
+	var conflict *ConflictError
+	if !errors.Is(err, ErrWriteConflict) || !errors.As(err, &conflict) {
+		t.Fatalf("Commit error = %v, want *ConflictError", err)
+	}
+	if len(conflict.Keys) != 1 || conflict.Keys[0] != "x" {
+		t.Errorf("conflict keys = %v, want [x]", conflict.Keys)
+	}
+	if got := read(t, s, s.BeginTransaction(), "x"); got != "a" {
+		t.Errorf("x = %q, want first committer's value", got)
+	}
+	if stats := s.Contention(0); len(stats.Keys) != 1 || stats.Keys[0] != (KeyContention{Key: "x", Conflicts: 1}) {
+		t.Errorf("contention = %+v", stats)
+	}
 }
 
 func TestSnapshotIsolation(t *testing.T) {
-	// TODO: Test snapshot isolation guarantees
-	t.Skip("not implemented")
+	s := NewMVCCStore()
+	put(t, s, "k", "old")
+	reader := s.BeginTransaction()
+	put(t, s, "k", "new")
+	put(t, s, "other", "new")
+
+	if got := read(t, s, reader, "k"); got != "old" {
+		t.Errorf("reader saw %q, want its snapshot's old", got)
+	}
+	if _, err := s.Read(reader, "other"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("reader saw key created after its snapshot: %v", err)
+	}
 }
 
 func TestGarbageCollection(t *testing.T) {
-	// TODO: Test version GC
-	t.Skip("not implemented")
+	s := NewMVCCStore()
+	defer s.gc.Stop()
+	put(t, s, "k", "v1")
+	reader := s.BeginTransaction()
+	put(t, s, "k", "v2")
+	put(t, s, "k", "v3")
+
+	chainLen := func() int {
+		n := 0
+		for v := s.data["k"].latest; v != nil; v = v.prev {
+			n++
+		}
+		return n
+	}
+
+	s.gc.collect()
+	if n := chainLen(); n != 3 {
+		t.Errorf("chain length %d while reader holds v1, want 3", n)
+	}
+	if got := read(t, s, reader, "k"); got != "v1" {
+		t.Errorf("reader saw %q after GC", got)
+	}
+
+	s.Commit(reader)
+	s.gc.collect()
+	if n := chainLen(); n != 1 {
+		t.Errorf("chain length %d after reader finished, want 1", n)
+	}
 }
 
 func TestConcurrentTransactions(t *testing.T) {
-	// TODO: Test concurrent read/write workload
-	// Use -race flag
-	t.Skip("not implemented")
+	s := NewMVCCStore()
+	s.SetRetryOptions(RetryOptions{MaxAttempts: 1000, BaseDelay: 10 * time.Microsecond, MaxDelay: time.Millisecond})
+	put(t, s, "counter", "\x00\x00\x00\x00\x00\x00\x00\x00")
+
+	const workers, increments = 8, 25
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range increments {
+				err := s.RunInTransaction(context.Background(), func(txn *Transaction) error {
+					v, err := s.Read(txn, "counter")
+					if err != nil {
+						return err
+					}
+					next := binary.LittleEndian.AppendUint64(nil, binary.LittleEndian.Uint64(v)+1)
+					return s.Write(txn, "counter", next)
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	v, _ := s.Read(s.BeginTransaction(), "counter")
+	if got := binary.LittleEndian.Uint64(v); got != workers*increments {
+		t.Errorf("counter = %d, want %d (lost updates)", got, workers*increments)
+	}
+	if stats := s.Contention(0); stats.Exhausted != 0 || (stats.Retries > 0 && stats.Keys[0].Key != "counter") {
+		t.Errorf("contention = %+v", stats)
+	}
+}
+
+func TestRunInTransaction(t *testing.T) {
+	s := NewMVCCStore()
+	s.SetRetryOptions(RetryOptions{MaxAttempts: 3, BaseDelay: time.Microsecond})
+	ctx := context.Background()
+
+	// A non-conflict error aborts without retrying
+	errBoom := errors.New("boom")
+	calls := 0
+	err := s.RunInTransaction(ctx, func(txn *Transaction) error {
+		calls++
+		s.Write(txn, "k", Value("discarded"))
+		return errBoom
+	})
+	if err != errBoom || calls != 1 {
+		t.Errorf("err = %v after %d calls, want boom after 1", err, calls)
+	}
+	check := s.BeginTransaction()
+	if _, err := s.Read(check, "k"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("failed transaction's write visible: %v", err)
+	}
+	s.Abort(check)
+
+	// A writer that always loses the race exhausts its attempts
+	calls = 0
+	err = s.RunInTransaction(ctx, func(txn *Transaction) error {
+		calls++
+		put(t, s, "hot", "rival")
+		return s.Write(txn, "hot", Value("mine"))
+	})
+	if !errors.Is(err, ErrRetriesExhausted) || !errors.Is(err, ErrWriteConflict) || calls != 3 {
+		t.Errorf("err = %v after %d calls, want exhausted conflict after 3", err, calls)
+	}
+	stats := s.Contention(0)
+	if stats.Retries != 2 || stats.Exhausted != 1 || stats.Keys[0] != (KeyContention{Key: "hot", Conflicts: 3}) {
+		t.Errorf("contention = %+v", stats)
+	}
+	if len(s.transactions) != 0 {
+		t.Errorf("%d transactions left active", len(s.transactions))
+	}
+
+	// Cancellation interrupts the backoff
+	cctx, cancel := context.WithCancel(ctx)
+	s.SetRetryOptions(RetryOptions{MaxAttempts: 5, BaseDelay: time.Hour, MaxDelay: time.Hour})
+	err = s.RunInTransaction(cctx, func(txn *Transaction) error {
+		cancel()
+		put(t, s, "hot", "rival")
+		return s.Write(txn, "hot", Value("mine"))
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	opts := RetryOptions{BaseDelay: time.Millisecond, MaxDelay: 10 * time.Millisecond}
+	for attempt, want := range map[int]time.Duration{1: time.Millisecond, 3: 4 * time.Millisecond, 5: 10 * time.Millisecond, 80: 10 * time.Millisecond} {
+		for range 20 {
+			if d := backoff(opts, attempt); d < want/2 || d > want {
+				t.Errorf("backoff(%d) = %v, want in [%v, %v]", attempt, d, want/2, want)
+			}
+		}
+	}
+
+	// BaseDelay<<shift would wrap negative here; the delay must stay capped
+	huge := RetryOptions{BaseDelay: math.MaxInt64 / 4, MaxDelay: math.MaxInt64 / 2}
+	for attempt := range 40 {
+		if d := backoff(huge, attempt+1); d < 0 || d > huge.MaxDelay {
+			t.Errorf("backoff(%d) = %v with huge BaseDelay, want in [0, %v]", attempt+1, d, huge.MaxDelay)
+		}
+	}
+}
+
+func TestSnapshotExportImport(t *testing.T) {
+	src := NewMVCCStore()
+	put(t, src, "b", "b1")
+	put(t, src, "a", "a1")
+	put(t, src, "empty", "")
+	ts := src.BeginTransaction().Snapshot()
+	put(t, src, "a", "a2")
+	put(t, src, "c", "c1")
+
+	var buf bytes.Buffer
+	if err := src.ExportAt(ts, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if len(src.transactions) != 1 {
+		t.Errorf("%d transactions active after export, want the unfinished one", len(src.transactions))
+	}
+
+	replica := NewMVCCStore()
+	got, err := replica.ImportSnapshot(bytes.NewReader(buf.Bytes()))
+	if err != nil || got != ts {
+		t.Fatalf("ImportSnapshot = %d, %v; want %d", got, err, ts)
+	}
+	txn := replica.BeginTransaction()
+	for key, want := range map[Key]string{"a": "a1", "b": "b1", "empty": ""} {
+		if v := read(t, replica, txn, key); v != want {
+			t.Errorf("%s = %q, want %q", key, v, want)
+		}
+	}
+	if _, err := replica.Read(txn, "c"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("key committed after the snapshot was exported: %v", err)
+	}
+	// Commits replayed on the replica land after the snapshot
+	put(t, replica, "a", "a2")
+	if replica.BeginTransaction().Snapshot() != ts+1 {
+		t.Errorf("replica clock did not continue from %d", ts)
+	}
+
+	if _, err := replica.ImportSnapshot(bytes.NewReader(buf.Bytes())); !errors.Is(err, ErrStoreNotEmpty) {
+		t.Errorf("import into a used store: %v", err)
+	}
+	for name, data := range map[string][]byte{
+		"truncated": buf.Bytes()[:buf.Len()-1],
+		"corrupt":   append(bytes.Clone(buf.Bytes()[:20]), append([]byte{buf.Bytes()[20] ^ 0xff}, buf.Bytes()[21:]...)...),
+		"garbage":   []byte("not a snapshot"),
+	} {
+		if _, err := NewMVCCStore().ImportSnapshot(bytes.NewReader(data)); !errors.Is(err, ErrBadSnapshot) {
+			t.Errorf("%s: err = %v, want ErrBadSnapshot", name, err)
+		}
+	}
+
+	if err := src.ExportAt(ts+10, &buf); !errors.Is(err, ErrSnapshotUnavailable) {
+		t.Errorf("future timestamp: %v", err)
+	}
+	// Once GC has dropped its versions, an old timestamp cannot be exported
+	for _, txn := range src.transactions {
+		src.Abort(txn)
+	}
+	src.gc.collect()
+	if err := src.ExportAt(ts, &buf); !errors.Is(err, ErrSnapshotUnavailable) {
+		t.Errorf("timestamp behind GC: %v", err)
+	}
+}
+
+func TestChangeListener(t *testing.T) {
+	s := NewMVCCStore()
+	put(t, s, "a", "1")
+
+	type event struct {
+		key      Key
+		old, new string
+		ts       Timestamp
+	}
+	var (
+		mu     sync.Mutex
+		events []event
+		failed bool
+	)
+	sub := s.AddChangeListener(ChangeListenerFunc(func(key Key, oldVal, newVal Value, ts Timestamp) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// Reject the first delivery of b once; it must come again
+		if key == "b" && !failed {
+			failed = true
+			return errors.New("index busy")
+		}
+		events = append(events, event{key, string(oldVal), string(newVal), ts})
+		return nil
+	}))
+	defer sub.Close()
+	if sub.Start() != 1 {
+		t.Errorf("Start() = %d, want 1", sub.Start())
+	}
+
+	txn := s.BeginTransaction()
+	s.Write(txn, "c", Value("3"))
+	s.Write(txn, "b", Value("2"))
+	s.Write(txn, "a", Value("10"))
+	if err := s.Commit(txn); err != nil {
+		t.Fatal(err)
+	}
+	put(t, s, "a", "11")
+	// An aborted transaction changes nothing
+	txn = s.BeginTransaction()
+	s.Write(txn, "a", Value("lost"))
+	s.Abort(txn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sub.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	want := []event{{"a", "1", "10", 2}, {"b", "", "2", 2}, {"c", "", "3", 2}, {"a", "10", "11", 3}}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d = %v, want %v", i, events[i], want[i])
+		}
+	}
+	mu.Unlock()
+
+	// Concurrent commits are delivered in commit order
+	var last Timestamp
+	ordered := true
+	ordering := s.AddChangeListener(ChangeListenerFunc(func(key Key, oldVal, newVal Value, ts Timestamp) error {
+		if ts <= last {
+			ordered = false
+		}
+		last = ts
+		return nil
+	}))
+	var wg sync.WaitGroup
+	for i := range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 25 {
+				put(t, s, Key(string(rune('p'+i))), string(rune('0'+j%10)))
+			}
+		}()
+	}
+	wg.Wait()
+	if err := ordering.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ordering.Close()
+	if !ordered || last != 103 {
+		t.Errorf("deliveries out of commit order, or missing: last ts %d", last)
+	}
+
+	// A closed subscription hears nothing more
+	sub.Close()
+	put(t, s, "z", "1")
+	mu.Lock()
+	defer mu.Unlock()
+	if events[len(events)-1].key == "z" {
+		t.Error("closed subscription still delivered")
+	}
 }
 
 func BenchmarkRead(b *testing.B) {
@@ -0,0 +1,174 @@
+package mvcc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrRetriesExhausted is returned by RunInTransaction when every attempt
+// failed with a write conflict. The last *ConflictError is wrapped too.
+var ErrRetriesExhausted = errors.New("transaction retries exhausted")
+
+// RetryOptions bounds the retries of RunInTransaction
+type RetryOptions struct {
+	MaxAttempts int           // attempts including the first (default 10)
+	BaseDelay   time.Duration // backoff before the first retry (default 1ms)
+	MaxDelay    time.Duration // backoff cap (default 100ms)
+}
+
+func (o RetryOptions) withDefaults() RetryOptions {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 10
+	}
+	if o.BaseDelay <= 0 {
+		o.BaseDelay = time.Millisecond
+	}
+	if o.MaxDelay <= 0 {
+		o.MaxDelay = 100 * time.Millisecond
+	}
+	return o
+}
+
+// SetRetryOptions configures RunInTransaction for this store
+func (s *MVCCStore) SetRetryOptions(opts RetryOptions) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retry = opts.withDefaults()
+}
+
+// RunInTransaction runs fn in a new transaction and commits it. If the
+// commit (or fn) fails with a write conflict, the transaction is retried
+// after an exponential backoff with jitter, up to MaxAttempts times. Any
+// other error from fn aborts the transaction and is returned as is.
+//
+// fn may run several times, so it should have no effects outside txn.
+func (s *MVCCStore) RunInTransaction(ctx context.Context, fn func(txn *Transaction) error) error {
+	s.mu.RLock()
+	opts := s.retry
+	s.mu.RUnlock()
+
+	var lastErr error
+	for attempt := range opts.MaxAttempts {
+		if attempt > 0 {
+			s.contention.retries.Add(1)
+			if err := sleepCtx(ctx, backoff(opts, attempt)); err != nil {
+				return err
+			}
+		}
+
+		err := s.runOnce(fn)
+		if err == nil || !errors.Is(err, ErrWriteConflict) {
+			return err
+		}
+		lastErr = err
+	}
+	s.contention.exhausted.Add(1)
+	return fmt.Errorf("%w after %d attempts: %w", ErrRetriesExhausted, opts.MaxAttempts, lastErr)
+}
+
+// runOnce runs one attempt, aborting the transaction unless it commits
+func (s *MVCCStore) runOnce(fn func(txn *Transaction) error) error {
+	txn := s.BeginTransaction()
+	committed := false
+	defer func() {
+		if !committed {
+			s.Abort(txn)
+		}
+	}()
+
+	if err := fn(txn); err != nil {
+		return err
+	}
+	// Commit finishes the transaction even when it reports a conflict
+	committed = true
+	return s.Commit(txn)
+}
+
+// backoff returns the delay before retry number attempt (1-based): the
+// capped exponential delay, half of it fixed and half random so that
+// colliding writers spread out
+func backoff(opts RetryOptions, attempt int) time.Duration {
+	d := opts.MaxDelay
+	// Compare against MaxDelay shifted down so the check itself can't overflow
+	if shift := attempt - 1; shift < 32 && opts.BaseDelay <= opts.MaxDelay>>shift {
+		d = opts.BaseDelay << shift
+	}
+	half := d / 2
+	return half + rand.N(d-half+1)
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// KeyContention counts the commits that failed on a key
+type KeyContention struct {
+	Key       Key
+	Conflicts uint64
+}
+
+// ContentionStats summarizes write conflicts seen by the store
+type ContentionStats struct {
+	Retries   uint64          // RunInTransaction attempts retried after a conflict
+	Exhausted uint64          // RunInTransaction calls that ran out of attempts
+	Keys      []KeyContention // most contended first
+}
+
+// contentionTracker records conflicts per key
+type contentionTracker struct {
+	mu        sync.Mutex
+	conflicts map[Key]uint64
+	retries   atomic.Uint64
+	exhausted atomic.Uint64
+}
+
+func (c *contentionTracker) record(keys []Key) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conflicts == nil {
+		c.conflicts = make(map[Key]uint64)
+	}
+	for _, key := range keys {
+		c.conflicts[key]++
+	}
+}
+
+// Contention returns conflict counts for the topN most contended keys
+// (all keys if topN <= 0) plus retry totals
+func (s *MVCCStore) Contention(topN int) ContentionStats {
+	c := &s.contention
+	c.mu.Lock()
+	keys := make([]KeyContention, 0, len(c.conflicts))
+	for key, n := range c.conflicts {
+		keys = append(keys, KeyContention{Key: key, Conflicts: n})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Conflicts != keys[j].Conflicts {
+			return keys[i].Conflicts > keys[j].Conflicts
+		}
+		return keys[i].Key < keys[j].Key
+	})
+	if topN > 0 && len(keys) > topN {
+		keys = keys[:topN]
+	}
+	return ContentionStats{
+		Retries:   c.retries.Load(),
+		Exhausted: c.exhausted.Load(),
+		Keys:      keys,
+	}
+}
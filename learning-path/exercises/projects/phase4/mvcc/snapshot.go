@@ -0,0 +1,246 @@
+package mvcc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"sort"
+)
+
+// Errors
+var (
+	// ErrSnapshotUnavailable is returned by ExportAt for a timestamp that
+	// has not been reached yet, or whose versions GC may have removed
+	ErrSnapshotUnavailable = errors.New("snapshot not available")
+	ErrBadSnapshot         = errors.New("bad snapshot")
+	ErrStoreNotEmpty       = errors.New("store is not empty")
+)
+
+const (
+	snapshotMagic   = "MVSN"
+	snapshotVersion = 1
+
+	// Each entry starts with entryMore; entryEnd precedes the trailer
+	entryMore = 1
+	entryEnd  = 0
+
+	// maxSnapshotField guards against allocating for a corrupted length
+	maxSnapshotField = 64 << 20
+)
+
+var snapshotTable = crc32.MakeTable(crc32.Castagnoli)
+
+// ExportAt writes every key with the value visible at ts to w, in key
+// order, so that ImportSnapshot can seed a replica that then catches up
+// with the commits after ts. The export reads as a transaction would, so
+// concurrent commits are not blocked and GC keeps the versions it needs.
+//
+// Format: Magic "MVSN"(4) + Version(1) + Timestamp(8), then for each key
+// 0x01 + KeyLen(uvarint) + Key + ValueLen(uvarint) + Value, then 0x00 +
+// Count(uvarint) + CRC32C(4) of everything before it.
+func (s *MVCCStore) ExportAt(ts Timestamp, w io.Writer) error {
+	txn, err := s.pinSnapshot(ts)
+	if err != nil {
+		return err
+	}
+	defer s.Abort(txn)
+
+	s.mu.RLock()
+	keys := make([]Key, 0, len(s.data))
+	for key := range s.data {
+		keys = append(keys, key)
+	}
+	s.mu.RUnlock()
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	bw := bufio.NewWriter(w)
+	sw := &snapshotWriter{w: bw, crc: crc32.New(snapshotTable)}
+	sw.write([]byte(snapshotMagic))
+	sw.write([]byte{snapshotVersion})
+	sw.write(binary.LittleEndian.AppendUint64(nil, uint64(ts)))
+
+	var count uint64
+	for _, key := range keys {
+		value, err := s.Read(txn, key)
+		if errors.Is(err, ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sw.write([]byte{entryMore})
+		sw.writeBytes([]byte(key))
+		sw.writeBytes(value)
+		count++
+	}
+	sw.write([]byte{entryEnd})
+	sw.write(binary.AppendUvarint(nil, count))
+	sw.write(binary.LittleEndian.AppendUint32(nil, sw.crc.Sum32()))
+	if sw.err != nil {
+		return sw.err
+	}
+	return bw.Flush()
+}
+
+// pinSnapshot registers a read-only transaction at ts, so GC keeps the
+// versions visible at ts until it finishes
+func (s *MVCCStore) pinSnapshot(ts Timestamp) (*Transaction, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := Timestamp(s.clock.Load()); ts > now {
+		return nil, fmt.Errorf("%w: timestamp %d is after the latest commit %d", ErrSnapshotUnavailable, ts, now)
+	}
+	if horizon := Timestamp(s.horizon.Load()); ts < horizon {
+		return nil, fmt.Errorf("%w: timestamp %d is before the GC horizon %d", ErrSnapshotUnavailable, ts, horizon)
+	}
+	txn := &Transaction{
+		id:       TxnID(s.nextTxnID.Add(1)),
+		snapshot: ts,
+		writeSet: make(map[Key]*Version),
+		readSet:  make(map[Key]Timestamp),
+	}
+	s.transactions[txn.id] = txn
+	return txn, nil
+}
+
+// ImportSnapshot loads a snapshot written by ExportAt into an empty store
+// and returns its timestamp. The store's clock is set to that timestamp,
+// so commits replayed from the source's log after it keep their order. A
+// snapshot that fails its checksum is rejected with ErrBadSnapshot before
+// anything is loaded.
+func (s *MVCCStore) ImportSnapshot(r io.Reader) (Timestamp, error) {
+	sr := &snapshotReader{r: bufio.NewReader(r), crc: crc32.New(snapshotTable)}
+	header := sr.read(len(snapshotMagic) + 1 + 8)
+	if sr.err != nil {
+		return 0, sr.err
+	}
+	if string(header[:4]) != snapshotMagic {
+		return 0, fmt.Errorf("%w: bad magic", ErrBadSnapshot)
+	}
+	if header[4] != snapshotVersion {
+		return 0, fmt.Errorf("%w: unsupported version %d", ErrBadSnapshot, header[4])
+	}
+	ts := Timestamp(binary.LittleEndian.Uint64(header[5:]))
+
+	data := make(map[Key]*VersionChain)
+	var last Key
+	for {
+		marker := sr.read(1)
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if marker[0] == entryEnd {
+			break
+		}
+		if marker[0] != entryMore {
+			return 0, fmt.Errorf("%w: bad entry marker %#x", ErrBadSnapshot, marker[0])
+		}
+		key, value := Key(sr.readBytes()), Value(sr.readBytes())
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if len(data) > 0 && key <= last {
+			return 0, fmt.Errorf("%w: keys out of order", ErrBadSnapshot)
+		}
+		last = key
+		data[key] = &VersionChain{latest: &Version{data: value, beginTS: ts}}
+	}
+	count := sr.readUvarint()
+	sum := sr.crc.Sum32()
+	trailer := sr.read(4)
+	if sr.err != nil {
+		return 0, sr.err
+	}
+	if binary.LittleEndian.Uint32(trailer) != sum {
+		return 0, fmt.Errorf("%w: checksum mismatch", ErrBadSnapshot)
+	}
+	if count != uint64(len(data)) {
+		return 0, fmt.Errorf("%w: %d entries, trailer says %d", ErrBadSnapshot, len(data), count)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.data) > 0 || s.clock.Load() != 0 {
+		return 0, ErrStoreNotEmpty
+	}
+	s.data = data
+	s.clock.Store(uint64(ts))
+	s.horizon.Store(uint64(ts))
+	return ts, nil
+}
+
+// snapshotWriter writes to w and the checksum, keeping the first error
+type snapshotWriter struct {
+	w   io.Writer
+	crc hash.Hash32
+	err error
+}
+
+func (sw *snapshotWriter) write(p []byte) {
+	if sw.err != nil {
+		return
+	}
+	sw.crc.Write(p)
+	_, sw.err = sw.w.Write(p)
+}
+
+func (sw *snapshotWriter) writeBytes(p []byte) {
+	sw.write(binary.AppendUvarint(nil, uint64(len(p))))
+	sw.write(p)
+}
+
+// snapshotReader reads from r into the checksum, keeping the first error.
+// A snapshot that ends early is reported as ErrBadSnapshot.
+type snapshotReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	err error
+}
+
+func (sr *snapshotReader) read(n int) []byte {
+	if sr.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(sr.r, p); err != nil {
+		sr.fail(err)
+		return nil
+	}
+	sr.crc.Write(p)
+	return p
+}
+
+func (sr *snapshotReader) readUvarint() uint64 {
+	if sr.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(sr.r)
+	if err != nil {
+		sr.fail(err)
+		return 0
+	}
+	sr.crc.Write(binary.AppendUvarint(nil, n))
+	return n
+}
+
+func (sr *snapshotReader) readBytes() []byte {
+	n := sr.readUvarint()
+	if n > maxSnapshotField {
+		if sr.err == nil {
+			sr.err = fmt.Errorf("%w: field of %d bytes", ErrBadSnapshot, n)
+		}
+		return nil
+	}
+	return sr.read(int(n))
+}
+
+func (sr *snapshotReader) fail(err error) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = fmt.Errorf("%w: truncated", ErrBadSnapshot)
+	}
+	sr.err = err
+}
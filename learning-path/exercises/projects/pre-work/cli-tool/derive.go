@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	exprparser "github.com/kuzu/learning-path/exercises/projects/phase3/expression-parser"
+)
+
+// DeriveStage appends Column, computed per record by evaluating Expr with
+// the record's values bound to their column names. Earlier derived columns
+// can be referenced by later ones.
+type DeriveStage struct {
+	Column string
+	Expr   exprparser.Expr
+}
+
+func (s DeriveStage) Apply(records []Record, headers []string) ([]Record, []string, error) {
+	if slices.Contains(headers, s.Column) {
+		return nil, nil, fmt.Errorf("derive: column %q already exists", s.Column)
+	}
+	if err := checkColumns(referencedColumns(s.Expr), headers); err != nil {
+		return nil, nil, fmt.Errorf("derive %s: %w", s.Column, err)
+	}
+
+	ctx := make(exprparser.Context, len(headers))
+	for i, record := range records {
+		for _, col := range headers {
+			ctx[col] = cellValue(record[col])
+		}
+		v, err := s.Expr.Eval(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("derive %s: row %d: %w", s.Column, i+1, err)
+		}
+		if v.IsNull() {
+			record[s.Column] = ""
+		} else {
+			record[s.Column] = v.String()
+		}
+	}
+	return records, append(slices.Clone(headers), s.Column), nil
+}
+
+// referencedColumns returns the names expr reads, in order of first use
+func referencedColumns(expr exprparser.Expr) []string {
+	var names []string
+	var walk func(exprparser.Expr)
+	walk = func(e exprparser.Expr) {
+		switch e := e.(type) {
+		case *exprparser.Ident:
+			if !slices.Contains(names, e.Name) {
+				names = append(names, e.Name)
+			}
+		case *exprparser.UnaryExpr:
+			walk(e.Operand)
+		case *exprparser.BinaryExpr:
+			walk(e.Left)
+			walk(e.Right)
+		case *exprparser.CallExpr:
+			for _, arg := range e.Args {
+				walk(arg)
+			}
+		}
+	}
+	walk(expr)
+	return names
+}
+
+// cellValue types a CSV value for evaluation: nulls are NULL, numbers are
+// INT or FLOAT, and anything else is a STRING
+func cellValue(value string) exprparser.Value {
+	if isNull(value) {
+		return exprparser.Null()
+	}
+	trimmed := strings.TrimSpace(value)
+	if i, err := strconv.ParseInt(trimmed, 10, 64); err == nil {
+		return exprparser.IntValue(i)
+	}
+	if f, err := strconv.ParseFloat(trimmed, 64); err == nil {
+		return exprparser.FloatValue(f)
+	}
+	return exprparser.StringValue(value)
+}
+
+// parseDerive accepts NAME=EXPR. The expression is parsed here, so syntax
+// errors are reported before any input is read.
+func parseDerive(value string) (Stage, error) {
+	name, text, ok := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.TrimSpace(text) == "" {
+		return nil, fmt.Errorf("derive: expected name=expression, got %q", value)
+	}
+	expr, err := exprparser.Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("derive %s: %w", name, err)
+	}
+	return DeriveStage{Column: name, Expr: expr}, nil
+}
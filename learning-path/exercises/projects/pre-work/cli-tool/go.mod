@@ -1,3 +1,7 @@
 module csvtool
 
 go 1.24.7
+
+require github.com/kuzu/learning-path/exercises/projects/phase3/expression-parser v0.0.0
+
+replace github.com/kuzu/learning-path/exercises/projects/phase3/expression-parser => ../../phase3/expression-parser
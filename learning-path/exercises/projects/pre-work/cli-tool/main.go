@@ -18,6 +18,7 @@ type Config struct {
 	Value      string
 	Aggregate  string
 	Operation  string
+	Stages     []Stage
 }
 
 // Record represents a CSV row
@@ -43,6 +44,16 @@ func parseFlags() *Config {
 	flag.StringVar(&config.Value, "value", "", "Value to filter for")
 	flag.StringVar(&config.Aggregate, "aggregate", "", "Column to aggregate")
 	flag.StringVar(&config.Operation, "operation", "count", "Aggregation operation (sum, avg, count, min, max)")
+	flag.Var(&stageFlag{stages: &config.Stages, parse: parseDistinct, bool: true}, "distinct",
+		"Drop duplicate rows, optionally keyed by comma-separated columns (--distinct=a,b)")
+	flag.Var(&stageFlag{stages: &config.Stages, parse: parseDropNA, bool: true}, "dropna",
+		"Drop rows with empty/NULL/NA values, optionally only in listed columns (--dropna=a,b)")
+	flag.Var(&stageFlag{stages: &config.Stages, parse: parseFillNA}, "fillna",
+		"Replace empty/NULL/NA values with VALUE, or only in one column with COLUMN=VALUE (repeatable)")
+	flag.Var(&stageFlag{stages: &config.Stages, parse: parseRename}, "rename",
+		"Rename a column with OLD=NEW (repeatable)")
+	flag.Var(&stageFlag{stages: &config.Stages, parse: parseDerive}, "derive",
+		"Append a column computed per row with NAME=EXPR, e.g. total=price*qty (repeatable)")
 
 	flag.Parse()
 
@@ -74,6 +85,12 @@ func run(config *Config) error {
 		return fmt.Errorf("reading CSV: %w", err)
 	}
 
+	// Apply cleanup stages in command-line order
+	records, headers, err = applyStages(config.Stages, records, headers)
+	if err != nil {
+		return fmt.Errorf("transforming records: %w", err)
+	}
+
 	// Filter records if filter is specified
 	if config.Filter != "" && config.Value != "" {
 		records, err = filterRecords(records, config.Filter, config.Value)
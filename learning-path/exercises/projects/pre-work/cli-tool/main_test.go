@@ -1,8 +1,10 @@
 package main
 
 import (
+	"flag"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -174,6 +176,208 @@ func TestWriteCSV(t *testing.T) {
 	// TODO: Read back and verify content
 }
 
+func TestStages(t *testing.T) {
+	headers := []string{"name", "city", "age"}
+	newRecords := func() []Record {
+		return []Record{
+			{"name": "Alice", "city": "NYC", "age": "30"},
+			{"name": "Alice", "city": "NYC", "age": "30"},
+			{"name": "Bob", "city": "", "age": "25"},
+			{"name": "Alice", "city": "LA", "age": "NULL"},
+		}
+	}
+
+	tests := []struct {
+		name        string
+		stage       Stage
+		wantCount   int
+		wantHeaders []string
+		check       func(t *testing.T, records []Record)
+		wantErr     bool
+	}{
+		{name: "distinct all columns", stage: DistinctStage{}, wantCount: 3},
+		{name: "distinct by name", stage: DistinctStage{Columns: []string{"name"}}, wantCount: 2},
+		{name: "distinct unknown column", stage: DistinctStage{Columns: []string{"zip"}}, wantErr: true},
+		{name: "dropna all columns", stage: DropNAStage{}, wantCount: 2},
+		{name: "dropna by city", stage: DropNAStage{Columns: []string{"city"}}, wantCount: 3},
+		{
+			name: "fillna all columns", stage: FillNAStage{Value: "?"}, wantCount: 4,
+			check: func(t *testing.T, records []Record) {
+				if records[2]["city"] != "?" || records[3]["age"] != "?" {
+					t.Errorf("nulls not filled: %v", records)
+				}
+			},
+		},
+		{
+			name: "fillna one column", stage: FillNAStage{Column: "age", Value: "0"}, wantCount: 4,
+			check: func(t *testing.T, records []Record) {
+				if records[2]["city"] != "" || records[3]["age"] != "0" {
+					t.Errorf("unexpected fill: %v", records)
+				}
+			},
+		},
+		{
+			name: "rename", stage: RenameStage{Old: "city", New: "town"}, wantCount: 4,
+			wantHeaders: []string{"name", "town", "age"},
+			check: func(t *testing.T, records []Record) {
+				if records[0]["town"] != "NYC" {
+					t.Errorf("value not moved to renamed column: %v", records[0])
+				}
+			},
+		},
+		{name: "rename unknown column", stage: RenameStage{Old: "zip", New: "postcode"}, wantErr: true},
+		{name: "rename onto existing column", stage: RenameStage{Old: "city", New: "name"}, wantErr: true},
+		{
+			name: "derive", stage: mustDerive(t, "next=age+1"), wantCount: 4,
+			wantHeaders: []string{"name", "city", "age", "next"},
+			check: func(t *testing.T, records []Record) {
+				if records[0]["next"] != "31" || records[3]["next"] != "" {
+					t.Errorf("unexpected derived values: %v", records)
+				}
+			},
+		},
+		{
+			name: "derive with function", stage: mustDerive(t, "label=concat(upper(name), '-', city)"), wantCount: 4,
+			check: func(t *testing.T, records []Record) {
+				if records[0]["label"] != "ALICE-NYC" {
+					t.Errorf("label = %q", records[0]["label"])
+				}
+			},
+		},
+		{name: "derive unknown column", stage: mustDerive(t, "total=price*qty"), wantErr: true},
+		{name: "derive onto existing column", stage: mustDerive(t, "age=age*2"), wantErr: true},
+		{name: "derive type mismatch", stage: mustDerive(t, "x=name*2"), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, gotHeaders, err := tt.stage.Apply(newRecords(), headers)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Apply() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(records) != tt.wantCount {
+				t.Errorf("Apply() got %d records, want %d", len(records), tt.wantCount)
+			}
+			if tt.wantHeaders != nil && !slices.Equal(gotHeaders, tt.wantHeaders) {
+				t.Errorf("Apply() headers = %v, want %v", gotHeaders, tt.wantHeaders)
+			}
+			if tt.check != nil {
+				tt.check(t, records)
+			}
+		})
+	}
+}
+
+func TestStageFlagsKeepOrder(t *testing.T) {
+	var stages []Stage
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&stageFlag{stages: &stages, parse: parseDistinct, bool: true}, "distinct", "")
+	fs.Var(&stageFlag{stages: &stages, parse: parseFillNA}, "fillna", "")
+	fs.Var(&stageFlag{stages: &stages, parse: parseRename}, "rename", "")
+
+	args := []string{"--fillna", "city=unknown", "--distinct", "--rename", "city=town", "--distinct=name,town"}
+	if err := fs.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Stage{
+		FillNAStage{Column: "city", Value: "unknown"},
+		DistinctStage{},
+		RenameStage{Old: "city", New: "town"},
+		DistinctStage{Columns: []string{"name", "town"}},
+	}
+	if len(stages) != len(want) {
+		t.Fatalf("got %d stages, want %d", len(stages), len(want))
+	}
+	for i := range want {
+		if !equalStage(stages[i], want[i]) {
+			t.Errorf("stage %d = %#v, want %#v", i, stages[i], want[i])
+		}
+	}
+
+	if err := fs.Parse([]string{"--rename", "missing-equals"}); err == nil {
+		t.Error("expected error for malformed --rename")
+	}
+}
+
+func TestParseDerive(t *testing.T) {
+	for _, value := range []string{"total", "=a+b", "total=", "total=price*", "total=(price"} {
+		if _, err := parseDerive(value); err == nil {
+			t.Errorf("parseDerive(%q) succeeded", value)
+		}
+	}
+
+	// Derived columns feed later stages, filtering and aggregation
+	input := createTempCSV(t, "product,price,qty\nwidget,2.5,4\ngadget,3,2\ntool,,1\n")
+	output := filepath.Join(t.TempDir(), "out.csv")
+	config := &Config{
+		InputFile:  input,
+		OutputFile: output,
+		Stages:     []Stage{mustDerive(t, "total=price*qty"), mustDerive(t, "big=total > 6")},
+		Filter:     "big",
+		Value:      "TRUE",
+		Aggregate:  "total",
+		Operation:  "sum",
+	}
+	if err := run(config); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "product,price,qty,total,big\nwidget,2.5,4,10,TRUE\n\"Summary: 1 rows, sum total: 10.00\"\n"; string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func mustDerive(t *testing.T, value string) Stage {
+	t.Helper()
+	stage, err := parseDerive(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stage
+}
+
+// equalStage compares stages field by field
+func equalStage(a, b Stage) bool {
+	if da, ok := a.(DistinctStage); ok {
+		db, ok := b.(DistinctStage)
+		return ok && slices.Equal(da.Columns, db.Columns)
+	}
+	return a == b
+}
+
+func TestRunWithStages(t *testing.T) {
+	input := createTempCSV(t, "name,city\nAlice,NYC\nAlice,NYC\nBob,\n")
+	output := filepath.Join(t.TempDir(), "out.csv")
+
+	config := &Config{
+		InputFile:  input,
+		OutputFile: output,
+		Stages: []Stage{
+			DistinctStage{},
+			FillNAStage{Column: "city", Value: "unknown"},
+			RenameStage{Old: "city", New: "town"},
+		},
+	}
+	if err := run(config); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "name,town\nAlice,NYC\nBob,unknown\n"; string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
 // Helper function to create temporary CSV file
 func createTempCSV(t *testing.T, content string) string {
 	t.Helper()
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+// Stage is one step of the record pipeline. Stages run in the order their
+// flags appear on the command line, before filtering and aggregation.
+type Stage interface {
+	Apply(records []Record, headers []string) ([]Record, []string, error)
+}
+
+// isNull reports whether a value counts as missing
+func isNull(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "null", "na", "n/a", "nan":
+		return true
+	}
+	return false
+}
+
+// checkColumns returns an error naming the first column not in headers
+func checkColumns(columns, headers []string) error {
+	for _, col := range columns {
+		if !slices.Contains(headers, col) {
+			return fmt.Errorf("unknown column %q", col)
+		}
+	}
+	return nil
+}
+
+// DistinctStage keeps the first record for each distinct key, where the key
+// is the values of Columns (all columns when empty)
+type DistinctStage struct {
+	Columns []string
+}
+
+func (s DistinctStage) Apply(records []Record, headers []string) ([]Record, []string, error) {
+	columns := s.Columns
+	if len(columns) == 0 {
+		columns = headers
+	}
+	if err := checkColumns(columns, headers); err != nil {
+		return nil, nil, fmt.Errorf("distinct: %w", err)
+	}
+
+	// Bucket by hash; compare full keys only within a bucket to rule out
+	// collisions
+	seen := make(map[uint64][][]string)
+	var out []Record
+	for _, record := range records {
+		key := make([]string, len(columns))
+		h := fnv.New64a()
+		for i, col := range columns {
+			key[i] = record[col]
+			h.Write([]byte(key[i]))
+			h.Write([]byte{0})
+		}
+		sum := h.Sum64()
+
+		if slices.ContainsFunc(seen[sum], func(other []string) bool { return slices.Equal(key, other) }) {
+			continue
+		}
+		seen[sum] = append(seen[sum], key)
+		out = append(out, record)
+	}
+	return out, headers, nil
+}
+
+// DropNAStage removes records with a null value in any of Columns (all
+// columns when empty)
+type DropNAStage struct {
+	Columns []string
+}
+
+func (s DropNAStage) Apply(records []Record, headers []string) ([]Record, []string, error) {
+	columns := s.Columns
+	if len(columns) == 0 {
+		columns = headers
+	}
+	if err := checkColumns(columns, headers); err != nil {
+		return nil, nil, fmt.Errorf("dropna: %w", err)
+	}
+
+	var out []Record
+	for _, record := range records {
+		if !slices.ContainsFunc(columns, func(col string) bool { return isNull(record[col]) }) {
+			out = append(out, record)
+		}
+	}
+	return out, headers, nil
+}
+
+// FillNAStage replaces null values in Column (all columns when empty) with
+// Value
+type FillNAStage struct {
+	Column string
+	Value  string
+}
+
+func (s FillNAStage) Apply(records []Record, headers []string) ([]Record, []string, error) {
+	columns := headers
+	if s.Column != "" {
+		if err := checkColumns([]string{s.Column}, headers); err != nil {
+			return nil, nil, fmt.Errorf("fillna: %w", err)
+		}
+		columns = []string{s.Column}
+	}
+
+	for _, record := range records {
+		for _, col := range columns {
+			if isNull(record[col]) {
+				record[col] = s.Value
+			}
+		}
+	}
+	return records, headers, nil
+}
+
+// RenameStage renames column Old to New
+type RenameStage struct {
+	Old string
+	New string
+}
+
+func (s RenameStage) Apply(records []Record, headers []string) ([]Record, []string, error) {
+	idx := slices.Index(headers, s.Old)
+	if idx < 0 {
+		return nil, nil, fmt.Errorf("rename: unknown column %q", s.Old)
+	}
+	if s.New != s.Old && slices.Contains(headers, s.New) {
+		return nil, nil, fmt.Errorf("rename: column %q already exists", s.New)
+	}
+
+	renamed := slices.Clone(headers)
+	renamed[idx] = s.New
+	for _, record := range records {
+		if val, ok := record[s.Old]; ok {
+			delete(record, s.Old)
+			record[s.New] = val
+		}
+	}
+	return records, renamed, nil
+}
+
+// applyStages runs each stage in order
+func applyStages(stages []Stage, records []Record, headers []string) ([]Record, []string, error) {
+	var err error
+	for _, stage := range stages {
+		records, headers, err = stage.Apply(records, headers)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return records, headers, nil
+}
+
+// stageFlag is a flag.Value that appends a Stage to a shared pipeline each
+// time the flag appears, which keeps stages in command-line order
+type stageFlag struct {
+	stages *[]Stage
+	parse  func(value string) (Stage, error)
+	bool   bool
+}
+
+func (f *stageFlag) String() string { return "" }
+
+func (f *stageFlag) Set(value string) error {
+	stage, err := f.parse(value)
+	if err != nil {
+		return err
+	}
+	if stage != nil {
+		*f.stages = append(*f.stages, stage)
+	}
+	return nil
+}
+
+// IsBoolFlag lets column-list flags be given without a value
+func (f *stageFlag) IsBoolFlag() bool { return f.bool }
+
+// parseColumnList parses "true" (bare flag) as all columns, "false" as a
+// disabled stage, and anything else as a comma-separated column list
+func parseColumnList(value string) (columns []string, enabled bool) {
+	switch value {
+	case "true":
+		return nil, true
+	case "false":
+		return nil, false
+	}
+	for _, col := range strings.Split(value, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			columns = append(columns, col)
+		}
+	}
+	return columns, true
+}
+
+func parseDistinct(value string) (Stage, error) {
+	columns, enabled := parseColumnList(value)
+	if !enabled {
+		return nil, nil
+	}
+	return DistinctStage{Columns: columns}, nil
+}
+
+func parseDropNA(value string) (Stage, error) {
+	columns, enabled := parseColumnList(value)
+	if !enabled {
+		return nil, nil
+	}
+	return DropNAStage{Columns: columns}, nil
+}
+
+// parseFillNA accepts VALUE (all columns) or COLUMN=VALUE
+func parseFillNA(value string) (Stage, error) {
+	if col, fill, ok := strings.Cut(value, "="); ok {
+		if col == "" {
+			return nil, fmt.Errorf("fillna: empty column in %q", value)
+		}
+		return FillNAStage{Column: col, Value: fill}, nil
+	}
+	return FillNAStage{Value: value}, nil
+}
+
+// parseRename accepts OLD=NEW
+func parseRename(value string) (Stage, error) {
+	old, name, ok := strings.Cut(value, "=")
+	if !ok || old == "" || name == "" {
+		return nil, fmt.Errorf("rename: expected old=new, got %q", value)
+	}
+	return RenameStage{Old: old, New: name}, nil
+}
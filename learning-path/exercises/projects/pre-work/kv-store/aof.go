@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AOF errors
+var (
+	// ErrCorruptAOF is returned when a record before the end of the log
+	// fails its checksum. A damaged last record is a write cut short by a
+	// crash; it is dropped instead.
+	ErrCorruptAOF = errors.New("corrupt append-only file")
+	// ErrNoAOF is returned by RewriteAOF for a store without a log
+	ErrNoAOF = errors.New("append-only file is off")
+	// ErrRewriteInProgress is returned when a rewrite is already running
+	ErrRewriteInProgress = errors.New("append-only file rewrite already in progress")
+)
+
+// SyncPolicy says how often the append-only file is synced to disk. Each
+// write reaches the operating system at once, so only a machine crash can
+// lose the writes since the last sync.
+type SyncPolicy int
+
+const (
+	// SyncEverySecond syncs once a second when there were writes
+	SyncEverySecond SyncPolicy = iota
+	// SyncAlways syncs after every write
+	SyncAlways
+	// SyncNever leaves syncing to the operating system
+	SyncNever
+)
+
+// ParseSyncPolicy parses a policy name: always, everysec or no
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch name {
+	case "always":
+		return SyncAlways, nil
+	case "everysec":
+		return SyncEverySecond, nil
+	case "no":
+		return SyncNever, nil
+	}
+	return 0, fmt.Errorf("unknown sync policy %q: want always, everysec or no", name)
+}
+
+// aofRecord is one logged mutation
+type aofRecord struct {
+	Op        string    `json:"op"`
+	Key       string    `json:"key,omitempty"`
+	Value     string    `json:"value,omitempty"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	Field     string    `json:"field,omitempty"`
+	Values    []string  `json:"values,omitempty"`
+}
+
+// AOF is an append-only log of the store's mutations. Each record is a
+// line holding the CRC-32 of its JSON payload in hex, a space and the
+// payload:
+//
+//	1c291ca3 {"op":"set","key":"name","value":"Alice"}
+//
+// On startup the log is replayed over the snapshot. It is rewritten by
+// RewriteAOF, which replaces it with the shortest log of the current data.
+type AOF struct {
+	path   string
+	policy SyncPolicy
+	file   *os.File
+	w      *bufio.Writer
+	dirty  bool // written since the last sync
+	err    error
+
+	// rewriting is set while RewriteAOF runs; the records appended
+	// meanwhile are kept in pending for the new file
+	rewriting bool
+	pending   []aofRecord
+
+	replayed int
+	mu       sync.Mutex
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// OpenAOF replays the log at path into store, creating it if needed, and
+// then logs every mutation of store to it. Load the snapshot first: the
+// log holds the writes made after it.
+func OpenAOF(store *Store, path string, policy SyncPolicy) (*AOF, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a := &AOF{path: path, policy: policy, file: file, done: make(chan struct{})}
+
+	store.lockAll()
+	defer store.unlockAll()
+	end, err := a.replay(store)
+	if err == nil {
+		// Drop a torn last record so new records follow whole ones
+		if err = file.Truncate(end); err == nil {
+			_, err = file.Seek(end, io.SeekStart)
+		}
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("replaying %s: %w", path, err)
+	}
+	a.w = bufio.NewWriter(file)
+	store.aof = a
+
+	if policy == SyncEverySecond {
+		a.wg.Add(1)
+		go a.syncLoop()
+	}
+	return a, nil
+}
+
+// replay applies the log's records to store and returns the offset where
+// its whole records end. Caller holds every shard.
+func (a *AOF) replay(store *Store) (int64, error) {
+	r := bufio.NewReader(a.file)
+	var end int64
+	for line := 1; ; line++ {
+		text, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// A record without its newline was cut short
+			return end, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		rec, ok := parseRecord(text)
+		if !ok {
+			if _, err := r.Peek(1); err == io.EOF {
+				return end, nil
+			}
+			return 0, fmt.Errorf("%w: line %d", ErrCorruptAOF, line)
+		}
+		switch rec.Op {
+		case opSet, opDel, opClear, opLPush, opHSet:
+		default:
+			return 0, fmt.Errorf("%w: line %d: unknown op %q", ErrCorruptAOF, line, rec.Op)
+		}
+		cmd := rec.command()
+		var sh *shard
+		if cmd.Op != opClear {
+			sh = store.shardFor(cmd.Key)
+		}
+		store.applyLocked(sh, cmd)
+		end += int64(len(text))
+		a.replayed++
+	}
+}
+
+// aofRecordOf returns the record of cmd
+func aofRecordOf(cmd Command) aofRecord {
+	return aofRecord{Op: cmd.Op, Key: cmd.Key, Value: cmd.Value, ExpiresAt: cmd.ExpiresAt, Field: cmd.Field, Values: cmd.Values}
+}
+
+// command returns the command rec logged
+func (rec aofRecord) command() Command {
+	return Command{Op: rec.Op, Key: rec.Key, Value: rec.Value, ExpiresAt: rec.ExpiresAt, Field: rec.Field, Values: rec.Values}
+}
+
+// parseRecord decodes a record line and checks its checksum
+func parseRecord(line []byte) (aofRecord, bool) {
+	var rec aofRecord
+	sum, payload, ok := bytes.Cut(bytes.TrimSuffix(line, []byte("\n")), []byte(" "))
+	if !ok {
+		return rec, false
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil || crc32.ChecksumIEEE(payload) != uint32(want) {
+		return rec, false
+	}
+	return rec, json.Unmarshal(payload, &rec) == nil
+}
+
+// writeRecord writes rec as a record line
+func writeRecord(w *bufio.Writer, rec aofRecord) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+	return err
+}
+
+// Replayed returns the number of records replayed when the log was opened
+func (a *AOF) Replayed() int {
+	return a.replayed
+}
+
+// Err returns the first error writing the log. The store keeps working in
+// memory after it, but its writes are no longer logged until a rewrite
+// succeeds.
+func (a *AOF) Err() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.err
+}
+
+// append logs cmd. The store calls it with s.mu held, so records are in the
+// order the mutations were applied.
+func (a *AOF) append(cmd Command) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	rec := aofRecordOf(cmd)
+	if a.rewriting {
+		a.pending = append(a.pending, rec)
+	}
+	if a.err != nil {
+		return
+	}
+	if err := writeRecord(a.w, rec); err != nil {
+		a.err = err
+		return
+	}
+	if err := a.w.Flush(); err != nil {
+		a.err = err
+		return
+	}
+	if a.policy == SyncAlways {
+		a.err = a.file.Sync()
+	} else {
+		a.dirty = true
+	}
+}
+
+func (a *AOF) syncLoop() {
+	defer a.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.dirty && a.err == nil {
+				a.err = a.file.Sync()
+				a.dirty = false
+			}
+			a.mu.Unlock()
+		case <-a.done:
+			return
+		}
+	}
+}
+
+// Close syncs and closes the log. The store must not be written after.
+func (a *AOF) Close() error {
+	close(a.done)
+	a.wg.Wait()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := a.file.Sync()
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// RewriteAOF replaces the append-only log with the shortest one that
+// rebuilds the current data: a clear followed by a set per string, a push
+// per list and a set per hash field. The data
+// is copied with every shard locked, but the new log is written without it;
+// writes made meanwhile go to the old log and are added to the new one
+// before it takes the old one's place.
+func (s *Store) RewriteAOF() error {
+	s.rlockAll()
+	a := s.aof
+	if a == nil {
+		s.runlockAll()
+		return ErrNoAOF
+	}
+	// Keys are sorted across shards, so the log does not depend on how
+	// many shards there are
+	var stringKeys, listKeys, hashKeys []string
+	for _, sh := range s.shards {
+		for key := range sh.data {
+			if !s.expiredLocked(sh, key) {
+				stringKeys = append(stringKeys, key)
+			}
+		}
+		for key := range sh.lists {
+			if !s.expiredLocked(sh, key) {
+				listKeys = append(listKeys, key)
+			}
+		}
+		for key := range sh.hashes {
+			if !s.expiredLocked(sh, key) {
+				hashKeys = append(hashKeys, key)
+			}
+		}
+	}
+	slices.Sort(stringKeys)
+	slices.Sort(listKeys)
+	slices.Sort(hashKeys)
+
+	records := []aofRecord{{Op: opClear}}
+	for _, key := range stringKeys {
+		sh := s.shardFor(key)
+		records = append(records, aofRecord{Op: opSet, Key: key, Value: sh.data[key], ExpiresAt: sh.expires[key]})
+	}
+	for _, key := range listKeys {
+		// Pushed in reverse, the values come out in list order
+		values := slices.Clone(s.shardFor(key).lists[key])
+		slices.Reverse(values)
+		records = append(records, aofRecord{Op: opLPush, Key: key, Values: values})
+	}
+	for _, key := range hashKeys {
+		h := s.shardFor(key).hashes[key]
+		for _, field := range slices.Sorted(maps.Keys(h)) {
+			records = append(records, aofRecord{Op: opHSet, Key: key, Field: field, Value: h[field]})
+		}
+	}
+	err := a.beginRewrite()
+	s.runlockAll()
+	if err != nil {
+		return err
+	}
+	return a.finishRewrite(records)
+}
+
+func (a *AOF) beginRewrite() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewriting {
+		return ErrRewriteInProgress
+	}
+	a.rewriting = true
+	a.pending = nil
+	return nil
+}
+
+// finishRewrite writes records and the pending ones to a new log and puts
+// it in place of the old one
+func (a *AOF) finishRewrite(records []aofRecord) (err error) {
+	tmpPath := a.path + ".rewrite"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		a.endRewrite()
+		return err
+	}
+	defer func() {
+		if err != nil {
+			file.Close()
+			os.Remove(tmpPath)
+			a.endRewrite()
+		}
+	}()
+
+	w := bufio.NewWriter(file)
+	for _, rec := range records {
+		if err := writeRecord(w, rec); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, rec := range a.pending {
+		if err := writeRecord(w, rec); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, a.path); err != nil {
+		return err
+	}
+	a.file.Close()
+	a.file, a.w = file, w
+	a.rewriting, a.pending = false, nil
+	a.dirty, a.err = false, nil
+	return nil
+}
+
+func (a *AOF) endRewrite() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.rewriting, a.pending = false, nil
+}
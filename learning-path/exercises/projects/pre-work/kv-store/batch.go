@@ -0,0 +1,267 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Batch errors
+var (
+	// ErrBatchAborted is returned when an EXPECT or ABSENT check fails;
+	// none of the batch's writes are applied
+	ErrBatchAborted = errors.New("batch aborted")
+	ErrInvalidBatch = errors.New("invalid batch")
+)
+
+// Batch operations
+const (
+	batchGet    = "GET"
+	batchSet    = "SET"
+	batchDel    = "DEL"
+	batchExists = "EXISTS"
+	batchTTL    = "TTL"
+	batchExpect = "EXPECT" // abort unless Key holds Value
+	batchAbsent = "ABSENT" // abort unless Key does not exist
+)
+
+// BatchOp is one operation of a batch. A SET with a positive TTL stores a
+// key that expires TTL from now, as SetWithTTL; without one, SET clears the
+// key's TTL.
+type BatchOp struct {
+	Op    string        `json:"op"`
+	Key   string        `json:"key"`
+	Value string        `json:"value,omitempty"`
+	TTL   time.Duration `json:"ttl,omitempty"`
+}
+
+// BatchResult is the outcome of one BatchOp. Found is the key's presence
+// for GET, EXISTS and TTL, and whether DEL removed a key. TTL is what a TTL
+// operation returns, as Store.TTL.
+type BatchResult struct {
+	Value string        `json:"value,omitempty"`
+	Found bool          `json:"found"`
+	TTL   time.Duration `json:"ttl,omitempty"`
+}
+
+// Batch executes ops atomically: no other reader or writer observes the
+// store between them. Reads see the batch's earlier writes. If an EXPECT or
+// ABSENT check fails, Batch returns ErrBatchAborted and applies nothing.
+// EXISTS, TTL, ABSENT, DEL and SET apply to keys of any type; GET and
+// EXPECT on a list or hash fail with ErrWrongType.
+//
+// The writes are replicated as individual commands, so a follower may
+// briefly expose part of a batch.
+func (s *Store) Batch(ops []BatchOp) ([]BatchResult, error) {
+	writes, err := checkBatch(ops)
+	if err != nil {
+		return nil, err
+	}
+	if writes && s.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if writes {
+		if err := s.makeRoom(); err != nil {
+			return nil, err
+		}
+	}
+
+	unlock := s.lockKeys(batchKeys(ops), writes)
+	defer unlock()
+	return s.batchLocked(ops)
+}
+
+// batchKeys returns the keys ops touch, whose shards a batch locks
+func batchKeys(ops []BatchOp) []string {
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+	return keys
+}
+
+// checkBatch validates ops and reports whether any of them writes
+func checkBatch(ops []BatchOp) (writes bool, err error) {
+	for i, op := range ops {
+		switch op.Op {
+		case batchSet, batchDel:
+			writes = true
+		case batchGet, batchExists, batchTTL, batchExpect, batchAbsent:
+		default:
+			return false, fmt.Errorf("%w: op %d: unknown operation %q", ErrInvalidBatch, i, op.Op)
+		}
+	}
+	return writes, nil
+}
+
+// batchLocked executes checked ops. Caller holds the shards of their keys,
+// for writing if any op writes.
+func (s *Store) batchLocked(ops []BatchOp) ([]BatchResult, error) {
+	// Writes are staged and applied only once every check has passed; a
+	// nil value is a pending delete. stagedExpiry holds the expiry of the
+	// staged writes that have a TTL.
+	staged := make(map[string]*string)
+	stagedExpiry := make(map[string]time.Time)
+	lookup := func(key string) (value, typ string) {
+		if v, ok := staged[key]; ok {
+			if v == nil {
+				return "", TypeNone
+			}
+			return *v, TypeString
+		}
+		sh := s.shardFor(key)
+		if s.expiredLocked(sh, key) {
+			return "", TypeNone
+		}
+		return sh.data[key], sh.typeLocked(key)
+	}
+	expiry := func(key string) (time.Time, bool) {
+		if _, ok := staged[key]; ok {
+			at, ok := stagedExpiry[key]
+			return at, ok
+		}
+		at, ok := s.shardFor(key).expires[key]
+		return at, ok
+	}
+
+	results := make([]BatchResult, len(ops))
+	var cmds []Command
+	for i, op := range ops {
+		value, typ := lookup(op.Key)
+		found := typ != TypeNone
+		switch op.Op {
+		case batchGet, batchExpect:
+			if found && typ != TypeString {
+				return nil, fmt.Errorf("op %d: %w", i, ErrWrongType)
+			}
+		}
+		switch op.Op {
+		case batchGet:
+			results[i] = BatchResult{Value: value, Found: found}
+		case batchExists:
+			results[i] = BatchResult{Found: found}
+		case batchTTL:
+			results[i] = BatchResult{Found: found}
+			if found {
+				results[i].TTL = NoExpiry
+				if at, ok := expiry(op.Key); ok {
+					// Expiring since the lookup counts as gone, as in TTL
+					if ttl := at.Sub(s.now()); ttl > 0 {
+						results[i].TTL = ttl
+					} else {
+						results[i] = BatchResult{}
+					}
+				}
+			}
+		case batchExpect:
+			if !found || value != op.Value {
+				return nil, fmt.Errorf("%w: op %d: EXPECT %s", ErrBatchAborted, i, op.Key)
+			}
+		case batchAbsent:
+			if found {
+				return nil, fmt.Errorf("%w: op %d: ABSENT %s", ErrBatchAborted, i, op.Key)
+			}
+		case batchSet:
+			v := op.Value
+			staged[op.Key] = &v
+			cmd := Command{Op: opSet, Key: op.Key, Value: v}
+			delete(stagedExpiry, op.Key)
+			if op.TTL > 0 {
+				cmd.ExpiresAt = s.now().Add(op.TTL)
+				stagedExpiry[op.Key] = cmd.ExpiresAt
+			}
+			cmds = append(cmds, cmd)
+		case batchDel:
+			results[i] = BatchResult{Found: found}
+			if found {
+				staged[op.Key] = nil
+				delete(stagedExpiry, op.Key)
+				cmds = append(cmds, Command{Op: opDel, Key: op.Key})
+			}
+		}
+	}
+
+	for key, v := range staged {
+		sh := s.shardFor(key)
+		sh.deleteLocked(key)
+		if v != nil {
+			sh.data[key] = *v
+			sh.indexLocked(key)
+			s.growLocked(sh, key, stringSize(key, *v))
+			if at, ok := stagedExpiry[key]; ok {
+				sh.expires[key] = at
+			}
+		}
+	}
+	for _, cmd := range cmds {
+		s.record(s.shardFor(cmd.Key), cmd)
+	}
+	return results, nil
+}
+
+// ParseBatch parses the REPL form of a batch: operations separated by
+// semicolons, e.g. "EXPECT stock 1; SET stock 0; SET owner bob". As with
+// SET, a value is the rest of its operation, spaces included.
+func ParseBatch(text string) ([]BatchOp, error) {
+	var ops []BatchOp
+	for i, part := range strings.Split(text, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		op := BatchOp{Op: strings.ToUpper(fields[0])}
+		if op.Op == "DELETE" {
+			op.Op = batchDel
+		}
+		switch op.Op {
+		case batchSet, batchExpect:
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("%w: op %d: usage: %s <key> <value>", ErrInvalidBatch, i, op.Op)
+			}
+			op.Value = strings.Join(fields[2:], " ")
+		case batchGet, batchDel, batchExists, batchTTL, batchAbsent:
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("%w: op %d: usage: %s <key>", ErrInvalidBatch, i, op.Op)
+			}
+		default:
+			return nil, fmt.Errorf("%w: op %d: unknown operation %q", ErrInvalidBatch, i, fields[0])
+		}
+		op.Key = fields[1]
+		ops = append(ops, op)
+	}
+	if len(ops) == 0 {
+		return nil, fmt.Errorf("%w: no operations", ErrInvalidBatch)
+	}
+	return ops, nil
+}
+
+// formatBatchResults renders results one line per operation, as the REPL
+// prints the single-key commands
+func formatBatchResults(ops []BatchOp, results []BatchResult) string {
+	var b strings.Builder
+	for i, op := range ops {
+		r := results[i]
+		fmt.Fprintf(&b, "%d) ", i+1)
+		switch op.Op {
+		case batchGet:
+			if r.Found {
+				b.WriteString(r.Value)
+			} else {
+				b.WriteString("(nil)")
+			}
+		case batchExists, batchDel:
+			if r.Found {
+				b.WriteString("1")
+			} else {
+				b.WriteString("0")
+			}
+		case batchTTL:
+			b.WriteString(formatTTL(r.TTL, r.Found))
+		default:
+			b.WriteString("OK")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
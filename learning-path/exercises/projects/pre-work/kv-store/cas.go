@@ -0,0 +1,57 @@
+package main
+
+import "errors"
+
+// ErrCASMismatch is returned by CAS when the key does not hold the
+// expected value, or does not exist
+var ErrCASMismatch = errors.New("CAS failed: value does not match")
+
+// CAS sets key to value if it holds expected, checking and writing under
+// the key's lock, so no other write lands in between. It fails with
+// ErrCASMismatch if the key holds something else or is missing, and with
+// ErrWrongType for a list or hash. Like INCR, it keeps the key's TTL.
+func (s *Store) CAS(key, expected, value string) error {
+	if err := s.makeRoom(); err != nil {
+		return err
+	}
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	switch s.liveTypeLocked(sh, key) {
+	case TypeNone:
+		return ErrCASMismatch
+	case TypeString:
+	default:
+		return ErrWrongType
+	}
+	if sh.data[key] != expected {
+		return ErrCASMismatch
+	}
+	cmd := Command{Op: opSet, Key: key, Value: value, ExpiresAt: sh.expires[key]}
+	s.applyLocked(sh, cmd)
+	s.record(sh, cmd)
+	return nil
+}
+
+// GetSet sets key to value and returns the value it replaced, with found
+// false if the key was missing. It fails with ErrWrongType for a list or
+// hash, which it leaves alone. Like SET, it clears the key's TTL.
+func (s *Store) GetSet(key, value string) (old string, found bool, err error) {
+	if err := s.makeRoom(); err != nil {
+		return "", false, err
+	}
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	switch s.liveTypeLocked(sh, key) {
+	case TypeNone:
+	case TypeString:
+		old, found = sh.data[key], true
+	default:
+		return "", false, ErrWrongType
+	}
+	cmd := Command{Op: opSet, Key: key, Value: value}
+	s.applyLocked(sh, cmd)
+	s.record(sh, cmd)
+	return old, found, nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrNoVersion is returned when a key has no version that matches a
+// GETVER or GETAT query
+var ErrNoVersion = errors.New("no such version")
+
+// HistoryPolicy controls how many past versions of each key are kept. The
+// zero policy keeps none.
+type HistoryPolicy struct {
+	// Versions is how many versions of each key to keep, the current one
+	// included. A delete counts as a version.
+	Versions int
+	// MaxAge, if set, also drops versions replaced more than MaxAge ago.
+	// The version current at the cutoff is kept, so reads as of any time
+	// within MaxAge still answer correctly.
+	MaxAge time.Duration
+}
+
+// Version is one value a key held, from Timestamp until the next version
+type Version struct {
+	Value     string    `json:"value,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// keyHistory is the kept versions of one key, oldest first
+type keyHistory struct {
+	versions []Version
+	// complete is set while versions go back to the key's creation, so
+	// the key did not exist before the first of them
+	complete bool
+}
+
+// SetHistoryPolicy starts keeping the version history of every key, or
+// stops and drops it with the zero policy. Keys that exist when history is
+// turned on get their current value as their first version.
+func (s *Store) SetHistoryPolicy(policy HistoryPolicy) {
+	s.lockAll()
+	defer s.unlockAll()
+
+	wasOn := s.historyPolicy.Versions > 0
+	s.historyPolicy = policy
+	if policy.Versions <= 0 {
+		for _, sh := range s.shards {
+			sh.history = nil
+		}
+		return
+	}
+	now := s.now()
+	if !wasOn {
+		s.historySince = now
+	}
+	for _, sh := range s.shards {
+		if sh.history == nil {
+			sh.history = make(map[string]*keyHistory, len(sh.data))
+			for k, v := range sh.data {
+				if s.expiredLocked(sh, k) {
+					continue
+				}
+				sh.history[k] = &keyHistory{versions: []Version{{Value: v, Timestamp: now}}}
+			}
+		}
+		for key := range sh.history {
+			s.trimLocked(sh, key, now)
+		}
+	}
+}
+
+// remember adds the versions a mutation creates. Caller holds sh.mu, or
+// every shard for a clear.
+func (s *Store) remember(sh *shard, cmd Command) {
+	if s.historyPolicy.Versions <= 0 {
+		return
+	}
+	now := s.now()
+	switch cmd.Op {
+	case opSet:
+		s.addVersionLocked(sh, cmd.Key, Version{Value: cmd.Value, Timestamp: now})
+	case opDel:
+		s.addVersionLocked(sh, cmd.Key, Version{Deleted: true, Timestamp: now})
+	case opClear:
+		for _, sh := range s.shards {
+			for key, h := range sh.history {
+				if !h.versions[len(h.versions)-1].Deleted {
+					s.addVersionLocked(sh, key, Version{Deleted: true, Timestamp: now})
+				}
+			}
+		}
+	}
+}
+
+func (s *Store) addVersionLocked(sh *shard, key string, v Version) {
+	h, ok := sh.history[key]
+	if !ok {
+		// A key without history was created now, or deleted and dropped
+		// from the history; either way it did not exist before
+		h = &keyHistory{complete: true}
+		sh.history[key] = h
+	}
+	h.versions = append(h.versions, v)
+	s.trimLocked(sh, key, v.Timestamp)
+}
+
+// trimLocked applies the history policy to key's versions as of now
+func (s *Store) trimLocked(sh *shard, key string, now time.Time) {
+	h := sh.history[key]
+	versions := h.versions
+	drop := max(len(versions)-s.historyPolicy.Versions, 0)
+	if s.historyPolicy.MaxAge > 0 {
+		cutoff := now.Add(-s.historyPolicy.MaxAge)
+		for drop < len(versions)-1 && !versions[drop+1].Timestamp.After(cutoff) {
+			drop++
+		}
+		// A delete from before the cutoff is all that is left
+		if drop == len(versions)-1 && versions[drop].Deleted && !versions[drop].Timestamp.After(cutoff) {
+			drop++
+		}
+	}
+	switch {
+	case drop == len(versions):
+		delete(sh.history, key)
+	case drop > 0:
+		h.versions = append([]Version(nil), versions[drop:]...)
+		h.complete = false
+	}
+}
+
+// CompactHistory applies the history policy to every key now, dropping
+// versions that have aged past MaxAge since they were written. Writes
+// compact the history of the key they change; CompactHistory catches the
+// keys that are no longer written. It returns the number of versions
+// dropped. Shards are compacted one at a time.
+func (s *Store) CompactHistory() int {
+	dropped := 0
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		now := s.now()
+		for key, h := range sh.history {
+			before := len(h.versions)
+			s.trimLocked(sh, key, now)
+			if h, ok := sh.history[key]; ok {
+				dropped += before - len(h.versions)
+			} else {
+				dropped += before
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return dropped
+}
+
+// History returns the kept versions of key, oldest first
+func (s *Store) History(key string) []Version {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if h, ok := sh.history[key]; ok {
+		return append([]Version(nil), h.versions...)
+	}
+	return nil
+}
+
+// GetVersion returns the version of key n writes before the current one;
+// n = 0 is the current version. It returns ErrNoVersion if that version
+// was not kept.
+func (s *Store) GetVersion(key string, n int) (Version, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	var versions []Version
+	if h, ok := sh.history[key]; ok {
+		versions = h.versions
+	}
+	if n < 0 || n >= len(versions) {
+		return Version{}, ErrNoVersion
+	}
+	return versions[len(versions)-1-n], nil
+}
+
+// GetAt returns the value key held at time t. found is false if the key
+// did not exist then. It returns ErrNoVersion if the versions of the key
+// current at t were not kept, so the answer is unknown.
+func (s *Store) GetAt(key string, t time.Time) (value string, found bool, err error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+
+	if sh.history == nil || t.Before(s.historySince) {
+		return "", false, ErrNoVersion
+	}
+	h, ok := sh.history[key]
+	if !ok {
+		// Not written since history was turned on, or deleted so long ago
+		// that the delete was dropped
+		return "", false, nil
+	}
+	// The first version written after t; the one before it was current
+	i := sort.Search(len(h.versions), func(i int) bool {
+		return h.versions[i].Timestamp.After(t)
+	})
+	if i == 0 {
+		if h.complete {
+			return "", false, nil
+		}
+		return "", false, ErrNoVersion
+	}
+	v := h.versions[i-1]
+	return v.Value, !v.Deleted, nil
+}
+
+// now returns the time versions are stamped with
+func (s *Store) now() time.Time {
+	if s.clock != nil {
+		return s.clock()
+	}
+	return time.Now()
+}
+
+// compactHistory runs CompactHistory every interval
+func compactHistory(store *Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		store.CompactHistory()
+	}
+}
+
+// parseTimestamp parses a GETAT time: RFC 3339, or seconds since the Unix
+// epoch, possibly fractional
+func parseTimestamp(text string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return t, nil
+	}
+	secs, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q: want RFC 3339 or Unix seconds", text)
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))), nil
+}
+
+// formatVersion renders a version as the REPL prints it: its timestamp,
+// then its value or (nil) for a delete
+func formatVersion(v Version) string {
+	value := v.Value
+	if v.Deleted {
+		value = "(nil)"
+	}
+	return v.Timestamp.Format(time.RFC3339Nano) + " " + value
+}
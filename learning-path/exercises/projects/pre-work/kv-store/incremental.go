@@ -0,0 +1,256 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// IncrementalSave is what SnapshotIncremental wrote
+type IncrementalSave struct {
+	Full      bool // a full snapshot, rather than a delta
+	Delta     int  // the number of the delta written, 0 for none
+	Compacted bool // the deltas were then merged into the snapshot
+}
+
+// SnapshotIncremental saves the keys written since the last save to a
+// delta file next to the snapshot, FILE.delta.N, which Load applies on top
+// of it. It saves nothing if no key changed. It writes a full snapshot
+// instead when there is none to build on yet, or after a Clear or a full
+// sync. Once there are MaxDeltas deltas they are merged into the snapshot,
+// as CompactSnapshots does.
+func (s *Store) SnapshotIncremental() (IncrementalSave, error) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.rlockAll()
+	if s.snapshotID == "" || s.needFull.Load() {
+		s.runlockAll()
+		return IncrementalSave{Full: true}, s.saveFull()
+	}
+	delta := Snapshot{
+		Version:   snapshotVersion,
+		Timestamp: time.Now().Format(time.RFC3339),
+		Base:      s.snapshotID,
+		Seq:       s.deltaSeq + 1,
+		Data:      make(map[string]string),
+		Lists:     make(map[string][]string),
+		Hashes:    make(map[string]map[string]string),
+		Expires:   make(map[string]time.Time),
+	}
+	changed := 0
+	for _, sh := range s.shards {
+		for key := range sh.dirty {
+			changed++
+			if s.expiredLocked(sh, key) {
+				delta.Deleted = append(delta.Deleted, key)
+				continue
+			}
+			switch sh.typeLocked(key) {
+			case TypeString:
+				delta.Data[key] = sh.data[key]
+			case TypeList:
+				delta.Lists[key] = slices.Clone(sh.lists[key])
+			case TypeHash:
+				delta.Hashes[key] = maps.Clone(sh.hashes[key])
+			default:
+				delta.Deleted = append(delta.Deleted, key)
+				continue
+			}
+			if at, ok := sh.expires[key]; ok {
+				delta.Expires[key] = at
+			}
+		}
+		// Writers are held off by the read lock, and other saves by
+		// saveMu, so the set can be swapped under the read lock
+		sh.dirty = make(map[string]struct{})
+	}
+	s.runlockAll()
+	if changed == 0 {
+		return IncrementalSave{}, nil
+	}
+
+	if err := s.replaceFile(deltaPath(s.filename, delta.Seq), &delta); err != nil {
+		s.needFull.Store(true)
+		return IncrementalSave{}, err
+	}
+	s.deltaSeq = delta.Seq
+	saved := IncrementalSave{Delta: delta.Seq}
+	if s.deltaSeq >= s.maxDeltas {
+		saved.Compacted = true
+		return saved, s.compactSnapshots()
+	}
+	return saved, nil
+}
+
+// CompactSnapshots merges the deltas into the snapshot, reading the files
+// rather than the store, so writes go on meanwhile. The merged snapshot
+// gets a new ID, which keeps deltas left over by a crash from applying to
+// it.
+func (s *Store) CompactSnapshots() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return s.compactSnapshots()
+}
+
+// compactSnapshots implements CompactSnapshots. Caller holds s.saveMu.
+func (s *Store) compactSnapshots() error {
+	if s.deltaSeq == 0 {
+		return nil
+	}
+	merged, seq, err := readSnapshotChain(s.filename)
+	if err != nil {
+		return err
+	}
+	if merged.ID != s.snapshotID || seq != s.deltaSeq {
+		return fmt.Errorf("snapshot files changed on disk: want snapshot %s with %d deltas", s.snapshotID, s.deltaSeq)
+	}
+	merged.ID = newSnapshotID()
+	if err := s.replaceFile(s.filename, merged); err != nil {
+		return err
+	}
+	s.snapshotID, s.deltaSeq = merged.ID, 0
+	return removeDeltas(s.filename)
+}
+
+// markDirtyLocked records that key changed since the last save. Caller
+// holds sh.mu for writing.
+func (sh *shard) markDirtyLocked(key string) {
+	if sh.dirty != nil {
+		sh.dirty[key] = struct{}{}
+	}
+}
+
+// startDeltasLocked starts tracking changes for deltas on the snapshot
+// being saved or loaded. Caller holds s.saveMu and every shard, for
+// reading at least.
+func (s *Store) startDeltasLocked() {
+	for _, sh := range s.shards {
+		sh.dirty = make(map[string]struct{})
+	}
+	s.needFull.Store(false)
+}
+
+// newSnapshotID returns a random ID for a full snapshot
+func newSnapshotID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// deltaPath returns the file of the seq-th delta on the snapshot in
+// filename
+func deltaPath(filename string, seq int) string {
+	return filename + ".delta." + strconv.Itoa(seq)
+}
+
+// removeDeltas deletes every delta file of the snapshot in filename
+func removeDeltas(filename string) error {
+	paths, err := filepath.Glob(filename + ".delta.*")
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, path := range paths {
+		if _, err := strconv.Atoi(strings.TrimPrefix(path, filename+".delta.")); err != nil {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// readSnapshotChain reads the snapshot in filename and applies its deltas
+// in order, returning the result and the number of deltas applied. The
+// chain ends at the first missing delta, or one saved on another snapshot.
+func readSnapshotChain(filename string) (*Snapshot, int, error) {
+	snap, err := readSnapshotFile(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	if snap.ID == "" {
+		return snap, 0, nil
+	}
+	seq := 0
+	for ; ; seq++ {
+		delta, err := readSnapshotFile(deltaPath(filename, seq+1))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		if delta.Base != snap.ID || delta.Seq != seq+1 {
+			break
+		}
+		snap.apply(delta)
+	}
+	return snap, seq, nil
+}
+
+// readSnapshotFile reads the snapshot or delta in path
+func readSnapshotFile(path string) (*Snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	defer f.Close()
+	snap, err := readSnapshot(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	if snap.Version > snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+	return snap, nil
+}
+
+// apply overwrites the keys of snap that delta changed or deleted
+func (snap *Snapshot) apply(delta *Snapshot) {
+	if snap.Data == nil {
+		snap.Data = make(map[string]string)
+	}
+	if snap.Lists == nil {
+		snap.Lists = make(map[string][]string)
+	}
+	if snap.Hashes == nil {
+		snap.Hashes = make(map[string]map[string]string)
+	}
+	if snap.Expires == nil {
+		snap.Expires = make(map[string]time.Time)
+	}
+	remove := func(key string) {
+		delete(snap.Data, key)
+		delete(snap.Lists, key)
+		delete(snap.Hashes, key)
+		delete(snap.Expires, key)
+	}
+	for _, key := range delta.Deleted {
+		remove(key)
+	}
+	for key, v := range delta.Data {
+		remove(key)
+		snap.Data[key] = v
+	}
+	for key, l := range delta.Lists {
+		remove(key)
+		snap.Lists[key] = l
+	}
+	for key, h := range delta.Hashes {
+		remove(key)
+		snap.Hashes[key] = h
+	}
+	maps.Copy(snap.Expires, delta.Expires)
+	snap.Timestamp = delta.Timestamp
+}
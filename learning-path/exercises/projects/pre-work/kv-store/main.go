@@ -2,36 +2,130 @@ package main
 
 import (
 	"bufio"
-	"encoding/json"
 	"flag"
 	"fmt"
+	"hash/maphash"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// Store represents an in-memory key-value store
+// Store represents an in-memory key-value store. Its keys are spread over
+// shards by hash. The other fields are only set with every shard locked,
+// so any shard lock is enough to read them.
 type Store struct {
-	data     map[string]string
-	mu       sync.RWMutex
+	shards   []*shard
+	seed     maphash.Seed
 	filename string
+	backlog  *Backlog
+	aof      *AOF
+	readOnly atomic.Bool
+	pubsub   pubsub
+
+	// saveMu serializes saving and loading snapshots, and guards
+	// snapshotID and deltaSeq: the snapshot on disk and the number of
+	// deltas saved on it
+	saveMu     sync.Mutex
+	snapshotID string
+	deltaSeq   int
+	maxDeltas  int
+	// needFull is set when the changes since the last save are not all
+	// in the shards' dirty sets, so the next save must be a full one
+	needFull atomic.Bool
+
+	snapshotFormat SnapshotFormat
+	snapshotGzip   bool
+
+	maxMemory      int64
+	evictionPolicy EvictionPolicy
+	// evictMu makes writers over the limit evict one at a time
+	evictMu sync.Mutex
+	// accessClock orders the uses of keys for LRU eviction
+	accessClock   atomic.Uint64
+	expiredKeys   atomic.Uint64
+	evictedKeys   atomic.Uint64
+	oomRejections atomic.Uint64
+
+	// historyPolicy says which versions each shard's history keeps, since
+	// historySince
+	historyPolicy HistoryPolicy
+	historySince  time.Time
+	clock         func() time.Time // time.Now if nil
 }
 
-// Snapshot represents a point-in-time snapshot of the store
+// snapshotVersion is the version of the snapshot format Snapshot writes.
+// Version 2 added lists and hashes; Load reads both versions.
+const snapshotVersion = 2
+
+// Snapshot represents a point-in-time snapshot of the store, or a delta
+// holding only the keys changed since the previous save
 type Snapshot struct {
-	Version   int               `json:"version"`
-	Timestamp string            `json:"timestamp"`
-	Data      map[string]string `json:"data"`
+	Version   int    `json:"version"`
+	Timestamp string `json:"timestamp"`
+	// ID identifies a full snapshot. A delta names the snapshot it applies
+	// to in Base, and its place in the chain of deltas in Seq.
+	ID   string            `json:"id,omitempty"`
+	Base string            `json:"base,omitempty"`
+	Seq  int               `json:"seq,omitempty"`
+	Data map[string]string `json:"data"`
+	// Lists and Hashes hold the typed values (version 2)
+	Lists  map[string][]string          `json:"lists,omitempty"`
+	Hashes map[string]map[string]string `json:"hashes,omitempty"`
+	// Expires holds the expiry of each key with a TTL
+	Expires map[string]time.Time `json:"expires,omitempty"`
+	// Deleted holds the keys a delta removes
+	Deleted []string `json:"deleted,omitempty"`
 }
 
 func main() {
 	filename := flag.String("file", "data.json", "Persistence file path")
 	autosave := flag.Duration("autosave", 0, "Auto-save interval (e.g., 30s, 1m)")
+	replListen := flag.String("replicate", "", "Serve followers on this address (e.g., :7000)")
+	replicaOf := flag.String("replicaof", "", "Run as a read-only follower of this primary")
+	listen := flag.String("listen", "", "Serve clients on this address (e.g., :6380)")
+	historyVersions := flag.Int("history", 0, "Versions to keep per key for GETVER/GETAT (0 = off)")
+	historyAge := flag.Duration("history-age", 0, "Also drop versions replaced longer ago than this")
+	aofPath := flag.String("aof", "", "Log every write to this append-only file, replayed after the snapshot")
+	aofSync := flag.String("aof-sync", "everysec", "Sync the append-only file: always, everysec or no")
+	reapInterval := flag.Duration("reap-interval", time.Second, "Remove expired keys this often (0 = only on read)")
+	shards := flag.Int("shards", 0, "Independently locked shards to spread keys over (0 = GOMAXPROCS*4)")
+	orderedIndex := flag.Bool("ordered-index", false, "Keep keys sorted for SCAN and RANGE")
+	snapshotFormat := flag.String("snapshot-format", "json", "Write snapshots as json or binary (either is read)")
+	snapshotGzip := flag.Bool("snapshot-gzip", false, "Compress snapshots with gzip")
+	incremental := flag.Bool("incremental", false, "Auto-save only the keys changed since the last save, as deltas")
+	maxDeltas := flag.Int("max-deltas", 0, "Merge deltas into the snapshot once there are this many (0 = 16)")
+	maxMemory := flag.String("maxmemory", "0", "Bound the keys and values to this size, e.g. 100mb (0 = no limit)")
+	maxMemoryPolicy := flag.String("maxmemory-policy", "noeviction", "Beyond -maxmemory: noeviction, allkeys-lru or volatile-ttl")
 	flag.Parse()
 
-	store := NewStore(*filename)
+	format, err := ParseSnapshotFormat(*snapshotFormat)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	memoryLimit, err := parseMemorySize(*maxMemory)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	eviction, err := ParseEvictionPolicy(*maxMemoryPolicy)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	store := NewStoreWithOptions(*filename, StoreOptions{
+		Shards:         *shards,
+		OrderedIndex:   *orderedIndex,
+		SnapshotFormat: format,
+		SnapshotGzip:   *snapshotGzip,
+		MaxDeltas:      *maxDeltas,
+		MaxMemory:      memoryLimit,
+		EvictionPolicy: eviction,
+	})
 
 	// Load existing data if file exists
 	if _, err := os.Stat(*filename); err == nil {
@@ -42,9 +136,60 @@ func main() {
 		}
 	}
 
+	if *aofPath != "" {
+		policy, err := ParseSyncPolicy(*aofSync)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		aof, err := OpenAOF(store, *aofPath, policy)
+		if err != nil {
+			fmt.Printf("Error: could not open append-only file: %v\n", err)
+			os.Exit(1)
+		}
+		defer aof.Close()
+		fmt.Printf("Replayed %d writes from %s\n", aof.Replayed(), *aofPath)
+	}
+
+	if *replListen != "" {
+		primary, err := StartPrimary(store, *replListen)
+		if err != nil {
+			fmt.Printf("Error: could not start replication: %v\n", err)
+			os.Exit(1)
+		}
+		defer primary.Close()
+		fmt.Printf("Accepting followers on %s\n", primary.Addr())
+	}
+	if *replicaOf != "" {
+		follower := StartFollower(store, *replicaOf)
+		defer follower.Stop()
+		fmt.Printf("Replicating from %s\n", *replicaOf)
+	}
+
+	if *listen != "" {
+		server, err := StartServer(store, *listen)
+		if err != nil {
+			fmt.Printf("Error: could not start server: %v\n", err)
+			os.Exit(1)
+		}
+		defer server.Close()
+		fmt.Printf("Accepting clients on %s\n", server.Addr())
+	}
+
+	if *historyVersions > 0 {
+		store.SetHistoryPolicy(HistoryPolicy{Versions: *historyVersions, MaxAge: *historyAge})
+		if *historyAge > 0 {
+			go compactHistory(store, *historyAge)
+		}
+	}
+
+	if *reapInterval > 0 {
+		go reapExpired(store, *reapInterval)
+	}
+
 	// Start auto-save if enabled
 	if *autosave > 0 {
-		go autoSave(store, *autosave)
+		go autoSave(store, *autosave, *incremental)
 	}
 
 	// Start REPL
@@ -53,122 +198,251 @@ func main() {
 
 // NewStore creates a new key-value store
 func NewStore(filename string) *Store {
-	return &Store{
-		data:     make(map[string]string),
-		filename: filename,
+	return NewStoreWithOptions(filename, StoreOptions{})
+}
+
+// NewStoreWithOptions creates a key-value store with custom options
+func NewStoreWithOptions(filename string, opts StoreOptions) *Store {
+	opts = opts.withDefaults()
+	s := &Store{
+		filename:       filename,
+		seed:           maphash.MakeSeed(),
+		shards:         make([]*shard, opts.Shards),
+		snapshotFormat: opts.SnapshotFormat,
+		snapshotGzip:   opts.SnapshotGzip,
+		maxDeltas:      opts.MaxDeltas,
+		maxMemory:      opts.MaxMemory,
+		evictionPolicy: opts.EvictionPolicy,
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{}
+		if opts.OrderedIndex {
+			s.shards[i].index = newSkipList()
+		}
+		if opts.EvictionPolicy == AllKeysLRU {
+			s.shards[i].access = make(map[string]*atomic.Uint64)
+		}
+	}
+	s.resetLocked()
+	return s
+}
+
+// resetLocked empties the store without recording it. Caller holds every
+// shard.
+func (s *Store) resetLocked() {
+	for _, sh := range s.shards {
+		sh.resetLocked()
 	}
 }
 
-// Get retrieves a value by key
+// Get retrieves a string value by key. An expired key is not found, and is
+// removed. Keys holding other types are not found either; see Type.
 func (s *Store) Get(key string) (string, bool) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	val, ok := s.data[key]
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	val, ok := sh.data[key]
+	expired := ok && s.expiredLocked(sh, key)
+	s.accessedLocked(sh, key)
+	sh.mu.RUnlock()
+	if expired {
+		s.expire(key)
+		return "", false
+	}
 	return val, ok
 }
 
-// Set stores a key-value pair
-func (s *Store) Set(key, value string) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data[key] = value
+// Set stores a key-value pair, replacing a value of any type and clearing
+// any TTL the key had. It fails with ErrOutOfMemory if the store is full.
+func (s *Store) Set(key, value string) error {
+	if err := s.makeRoom(); err != nil {
+		return err
+	}
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	cmd := Command{Op: opSet, Key: key, Value: value}
+	s.applyLocked(sh, cmd)
+	s.record(sh, cmd)
+	return nil
 }
 
-// Delete removes a key
+// Delete removes a key. It reports false for a key that had expired,
+// though it removes that too.
 func (s *Store) Delete(key string) bool {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	_, existed := s.data[key]
-	delete(s.data, key)
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	present := sh.typeLocked(key) != TypeNone
+	existed := present && !s.expiredLocked(sh, key)
+	sh.deleteLocked(key)
+	if present {
+		s.record(sh, Command{Op: opDel, Key: key})
+	}
 	return existed
 }
 
-// Exists checks if a key exists
+// Exists checks if a key of any type exists
 func (s *Store) Exists(key string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	_, ok := s.data[key]
-	return ok
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	present := sh.typeLocked(key) != TypeNone
+	expired := present && s.expiredLocked(sh, key)
+	sh.mu.RUnlock()
+	if expired {
+		s.expire(key)
+		return false
+	}
+	return present
 }
 
-// Keys returns all keys matching the pattern
+// Keys returns all keys matching the pattern. Shards are read one after
+// the other, so keys written meanwhile may or may not be listed.
 func (s *Store) Keys(pattern string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
 	var keys []string
-	for k := range s.data {
-		matched, err := filepath.Match(pattern, k)
-		if err == nil && matched {
-			keys = append(keys, k)
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		match := func(k string) {
+			matched, err := filepath.Match(pattern, k)
+			if err == nil && matched && !s.expiredLocked(sh, k) {
+				keys = append(keys, k)
+			}
+		}
+		for k := range sh.data {
+			match(k)
 		}
+		for k := range sh.lists {
+			match(k)
+		}
+		for k := range sh.hashes {
+			match(k)
+		}
+		sh.mu.RUnlock()
 	}
 	return keys
 }
 
-// Size returns the number of keys in the store
+// Size returns the number of keys in the store. Like Keys, it reads the
+// shards one after the other.
 func (s *Store) Size() int {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	return len(s.data)
+	n := 0
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		n += len(sh.data) + len(sh.lists) + len(sh.hashes)
+		for key := range sh.expires {
+			if s.expiredLocked(sh, key) {
+				n--
+			}
+		}
+		sh.mu.RUnlock()
+	}
+	return n
 }
 
 // Clear removes all keys
 func (s *Store) Clear() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data = make(map[string]string)
+	s.lockAll()
+	defer s.unlockAll()
+	s.resetLocked()
+	s.record(nil, Command{Op: opClear})
 }
 
-// Snapshot saves the store to disk
+// Snapshot saves the store to disk, in the format set by
+// StoreOptions.SnapshotFormat. The data is copied under the locks and then
+// encoded straight to the file. Deltas of earlier incremental saves are
+// removed, as the snapshot replaces them.
 func (s *Store) Snapshot() error {
-	s.mu.RLock()
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return s.saveFull()
+}
+
+// saveFull writes a full snapshot and starts a new chain of deltas on it.
+// Caller holds s.saveMu.
+func (s *Store) saveFull() error {
+	s.rlockAll()
 	// Create a copy to avoid holding lock during I/O
-	dataCopy := make(map[string]string, len(s.data))
-	for k, v := range s.data {
-		dataCopy[k] = v
+	dataCopy := make(map[string]string)
+	expiresCopy := make(map[string]time.Time)
+	for _, sh := range s.shards {
+		for k, v := range sh.data {
+			if s.expiredLocked(sh, k) {
+				continue
+			}
+			dataCopy[k] = v
+			if at, ok := sh.expires[k]; ok {
+				expiresCopy[k] = at
+			}
+		}
 	}
-	s.mu.RUnlock()
+	lists, hashes := s.copyTyped(expiresCopy)
+	s.startDeltasLocked()
+	s.runlockAll()
 
 	snapshot := Snapshot{
-		Version:   1,
+		Version:   snapshotVersion,
 		Timestamp: time.Now().Format(time.RFC3339),
+		ID:        newSnapshotID(),
 		Data:      dataCopy,
+		Lists:     lists,
+		Hashes:    hashes,
+		Expires:   expiresCopy,
 	}
 
-	data, err := json.MarshalIndent(snapshot, "", "  ")
-	if err != nil {
-		return fmt.Errorf("marshaling snapshot: %w", err)
+	if err := s.replaceFile(s.filename, &snapshot); err != nil {
+		// The changes since the last save are forgotten, so the next
+		// incremental save must be a full one
+		s.needFull.Store(true)
+		return err
 	}
+	s.snapshotID, s.deltaSeq = snapshot.ID, 0
+	return removeDeltas(s.filename)
+}
 
-	// Write to temporary file first, then rename (atomic)
-	tmpFile := s.filename + ".tmp"
-	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+// replaceFile writes snap to path through a temporary file, so path holds
+// the old or the new snapshot whatever happens
+func (s *Store) replaceFile(path string, snap *Snapshot) error {
+	tmpFile := path + ".tmp"
+	if err := s.writeSnapshotFile(tmpFile, snap); err != nil {
 		return fmt.Errorf("writing temp file: %w", err)
 	}
-
-	if err := os.Rename(tmpFile, s.filename); err != nil {
+	if err := os.Rename(tmpFile, path); err != nil {
 		return fmt.Errorf("renaming temp file: %w", err)
 	}
-
 	return nil
 }
 
-// Load restores the store from disk
+// Load restores the store from disk: the snapshot, then the deltas saved
+// on it in order. It reads a snapshot in any format, gzipped or not, so a
+// store can switch formats between runs.
 func (s *Store) Load() error {
-	data, err := os.ReadFile(s.filename)
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	snapshot, seq, err := readSnapshotChain(s.filename)
 	if err != nil {
-		return fmt.Errorf("reading file: %w", err)
+		return err
 	}
 
-	var snapshot Snapshot
-	if err := json.Unmarshal(data, &snapshot); err != nil {
-		return fmt.Errorf("unmarshaling snapshot: %w", err)
+	s.lockAll()
+	defer s.unlockAll()
+	s.fillLocked(snapshot.Data, snapshot.Lists, snapshot.Hashes, nil)
+	// What is loaded is what is on disk, so deltas can follow
+	if snapshot.ID != "" {
+		s.startDeltasLocked()
+		s.snapshotID, s.deltaSeq = snapshot.ID, seq
+	}
+	// Keys that expired while the store was down are dropped now
+	now := s.now()
+	for k, at := range snapshot.Expires {
+		sh := s.shardFor(k)
+		if sh.typeLocked(k) == TypeNone {
+			continue
+		}
+		if now.Before(at) {
+			sh.expires[k] = at
+		} else {
+			sh.deleteLocked(k)
+		}
 	}
-
-	s.mu.Lock()
-	defer s.mu.Unlock()
-	s.data = snapshot.Data
 
 	return nil
 }
@@ -177,6 +451,9 @@ func (s *Store) Load() error {
 func runREPL(store *Store) {
 	scanner := bufio.NewScanner(os.Stdin)
 	fmt.Println("KV Store - Type 'HELP' for commands")
+	var txn replTxn
+	subs := make(replPubSub)
+	defer subs.unsubscribe("")
 
 	for {
 		fmt.Print("> ")
@@ -195,6 +472,17 @@ func runREPL(store *Store) {
 		}
 
 		command := strings.ToUpper(parts[0])
+		if txn.handle(store, command, line, parts) {
+			continue
+		}
+
+		switch command {
+		case "SET", "DELETE", "DEL", "CLEAR", "LPUSH", "HSET", "INCR", "DECR", "CAS", "GETSET":
+			if store.ReadOnly() {
+				fmt.Println(ErrReadOnly)
+				continue
+			}
+		}
 
 		switch command {
 		case "GET":
@@ -204,19 +492,185 @@ func runREPL(store *Store) {
 			}
 			if val, ok := store.Get(parts[1]); ok {
 				fmt.Println(val)
+			} else if t := store.Type(parts[1]); t != TypeNone {
+				fmt.Printf("Error: %v\n", ErrWrongType)
 			} else {
 				fmt.Println("(nil)")
 			}
 
+		case "TYPE":
+			if len(parts) != 2 {
+				fmt.Println("Usage: TYPE <key>")
+				continue
+			}
+			fmt.Println(store.Type(parts[1]))
+
+		case "LPUSH":
+			if len(parts) < 3 {
+				fmt.Println("Usage: LPUSH <key> <value> [value ...]")
+				continue
+			}
+			if n, err := store.LPush(parts[1], parts[2:]...); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			} else {
+				fmt.Println(n)
+			}
+
+		case "LRANGE":
+			if len(parts) != 4 {
+				fmt.Println("Usage: LRANGE <key> <start> <stop>")
+				continue
+			}
+			start, stop, err := parseRange(parts[2], parts[3])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			if values, err := store.LRange(parts[1], start, stop); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			} else {
+				fmt.Print(formatList(values))
+			}
+
+		case "HSET":
+			if len(parts) < 4 {
+				fmt.Println("Usage: HSET <key> <field> <value>")
+				continue
+			}
+			if created, err := store.HSet(parts[1], parts[2], strings.Join(parts[3:], " ")); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			} else if created {
+				fmt.Println("1")
+			} else {
+				fmt.Println("0")
+			}
+
+		case "HGET":
+			if len(parts) != 3 {
+				fmt.Println("Usage: HGET <key> <field>")
+				continue
+			}
+			switch val, ok, err := store.HGet(parts[1], parts[2]); {
+			case err != nil:
+				fmt.Printf("Error: %v\n", err)
+			case ok:
+				fmt.Println(val)
+			default:
+				fmt.Println("(nil)")
+			}
+
+		case "INCR", "DECR":
+			if len(parts) != 2 {
+				fmt.Printf("Usage: %s <key>\n", command)
+				continue
+			}
+			delta := int64(1)
+			if command == "DECR" {
+				delta = -1
+			}
+			if n, err := store.IncrBy(parts[1], delta); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			} else {
+				fmt.Println(n)
+			}
+
+		case "GETVER":
+			if len(parts) != 3 {
+				fmt.Println("Usage: GETVER <key> <n>")
+				continue
+			}
+			n, err := strconv.Atoi(parts[2])
+			if err != nil {
+				fmt.Printf("Error: invalid version %q\n", parts[2])
+				continue
+			}
+			if v, err := store.GetVersion(parts[1], n); err != nil {
+				fmt.Printf("Error: %v\n", err)
+			} else {
+				fmt.Println(formatVersion(v))
+			}
+
+		case "GETAT":
+			if len(parts) != 3 {
+				fmt.Println("Usage: GETAT <key> <timestamp>")
+				continue
+			}
+			t, err := parseTimestamp(parts[2])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			switch val, ok, err := store.GetAt(parts[1], t); {
+			case err != nil:
+				fmt.Printf("Error: %v\n", err)
+			case ok:
+				fmt.Println(val)
+			default:
+				fmt.Println("(nil)")
+			}
+
+		case "HISTORY":
+			if len(parts) != 2 {
+				fmt.Println("Usage: HISTORY <key>")
+				continue
+			}
+			for _, v := range store.History(parts[1]) {
+				fmt.Println(formatVersion(v))
+			}
+
 		case "SET":
 			if len(parts) < 3 {
-				fmt.Println("Usage: SET <key> <value>")
+				fmt.Println("Usage: SET <key> <value> [EX <seconds>]")
+				continue
+			}
+			value, ttl, err := parseSetArgs(parts[2:])
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			if ttl > 0 {
+				err = store.SetWithTTL(parts[1], value, ttl)
+			} else {
+				err = store.Set(parts[1], value)
+			}
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
 				continue
 			}
-			value := strings.Join(parts[2:], " ")
-			store.Set(parts[1], value)
 			fmt.Println("OK")
 
+		case "CAS":
+			if len(parts) < 4 {
+				fmt.Println("Usage: CAS <key> <expected> <value>")
+				continue
+			}
+			if err := store.CAS(parts[1], parts[2], strings.Join(parts[3:], " ")); err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			fmt.Println("OK")
+
+		case "GETSET":
+			if len(parts) < 3 {
+				fmt.Println("Usage: GETSET <key> <value>")
+				continue
+			}
+			switch old, ok, err := store.GetSet(parts[1], strings.Join(parts[2:], " ")); {
+			case err != nil:
+				fmt.Printf("Error: %v\n", err)
+			case ok:
+				fmt.Println(old)
+			default:
+				fmt.Println("(nil)")
+			}
+
+		case "TTL":
+			if len(parts) != 2 {
+				fmt.Println("Usage: TTL <key>")
+				continue
+			}
+			fmt.Println(formatTTL(store.TTL(parts[1])))
+
 		case "DELETE", "DEL":
 			if len(parts) < 2 {
 				fmt.Println("Usage: DELETE <key>")
@@ -249,20 +703,118 @@ func runREPL(store *Store) {
 				fmt.Println(key)
 			}
 
+		case "SCAN", "RANGE":
+			var keys []string
+			var next string
+			var err error
+			if command == "SCAN" {
+				prefix, args := "", parts[1:]
+				// An odd number of arguments starts with the prefix
+				if len(args)%2 == 1 {
+					prefix, args = args[0], args[1:]
+				}
+				var cursor string
+				var count int
+				if cursor, count, err = parseScanArgs(args); err != nil {
+					fmt.Printf("Error: %v\nUsage: SCAN [prefix] [AFTER <key>] [COUNT <n>]\n", err)
+					continue
+				}
+				keys, next, err = store.Scan(prefix, cursor, count)
+			} else {
+				if len(parts) < 3 {
+					fmt.Println("Usage: RANGE <start|-> <end|+> [AFTER <key>] [COUNT <n>]")
+					continue
+				}
+				start, end := parts[1], parts[2]
+				if start == "-" {
+					start = ""
+				}
+				if end == "+" {
+					end = ""
+				}
+				var cursor string
+				var count int
+				if cursor, count, err = parseScanArgs(parts[3:]); err != nil {
+					fmt.Printf("Error: %v\nUsage: RANGE <start|-> <end|+> [AFTER <key>] [COUNT <n>]\n", err)
+					continue
+				}
+				keys, next, err = store.Range(start, end, cursor, count)
+			}
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			fmt.Print(formatScan(keys, next))
+
+		case "SUBSCRIBE":
+			if len(parts) != 2 {
+				fmt.Println("Usage: SUBSCRIBE <pattern>")
+				continue
+			}
+			subs.subscribe(store, parts[1])
+
+		case "UNSUBSCRIBE":
+			pattern := ""
+			if len(parts) >= 2 {
+				pattern = parts[1]
+			}
+			subs.unsubscribe(pattern)
+
 		case "SIZE":
 			fmt.Println(store.Size())
 
+		case "BATCH":
+			ops, err := ParseBatch(strings.TrimSpace(line[len(parts[0]):]))
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			results, err := store.Batch(ops)
+			if err != nil {
+				fmt.Printf("Error: %v\n", err)
+				continue
+			}
+			fmt.Print(formatBatchResults(ops, results))
+
 		case "CLEAR":
 			store.Clear()
 			fmt.Println("OK")
 
+		case "INFO":
+			fmt.Print(formatInfo(store.Info()))
+
 		case "SNAPSHOT", "SAVE":
-			if err := store.Snapshot(); err != nil {
+			if len(parts) > 2 || len(parts) == 2 && !strings.EqualFold(parts[1], "--incremental") {
+				fmt.Println("Usage: SAVE [--incremental]")
+				continue
+			}
+			if len(parts) == 1 {
+				if err := store.Snapshot(); err != nil {
+					fmt.Printf("Error: %v\n", err)
+				} else {
+					fmt.Printf("Saved snapshot to %s\n", store.filename)
+				}
+				continue
+			}
+			fmt.Println(saveIncremental(store))
+
+		case "COMPACTSNAPSHOTS":
+			if err := store.CompactSnapshots(); err != nil {
 				fmt.Printf("Error: %v\n", err)
 			} else {
-				fmt.Printf("Saved snapshot to %s\n", store.filename)
+				fmt.Printf("Merged deltas into %s\n", store.filename)
 			}
 
+		case "BGREWRITEAOF":
+			go func() {
+				if err := store.RewriteAOF(); err != nil {
+					fmt.Printf("\nAOF rewrite error: %v\n> ", err)
+				} else {
+					fmt.Print("\n[Rewrote the append-only file]\n> ")
+				}
+			}()
+			fmt.Println("Background append-only file rewrite started")
+
 		case "HELP":
 			printHelp()
 
@@ -282,23 +834,56 @@ func printHelp() {
 Available Commands:
   GET <key>           Get value for key
   SET <key> <value>   Set key to value
+      [EX <seconds>]  ... expiring after the given number of seconds
+  CAS <key> <old> <v> Set key to v only if it holds old
+  GETSET <key> <v>    Set key to v and get the value it replaced
+  TTL <key>           Get seconds left to live (-1 = no TTL, -2 = missing)
+  TYPE <key>          Get the type of key: string, list, hash or none
+  LPUSH <key> <v>...  Push values onto the head of a list
+  LRANGE <key> <a> <b> Get list elements a to b (negative counts from the end)
+  HSET <key> <f> <v>  Set field f of a hash
+  HGET <key> <f>      Get field f of a hash
+  INCR/DECR <key>     Add or subtract one from an integer
   DELETE <key>        Delete key
   EXISTS <key>        Check if key exists (returns 1 or 0)
   KEYS [pattern]      List keys matching pattern (default: *)
+  SCAN [prefix]       List keys with prefix in order, 10 at a time (COUNT n);
+                      AFTER <key> continues; needs -ordered-index
+  RANGE <a> <b>       List keys from a up to b in order (- and + for no
+                      bound); takes AFTER and COUNT like SCAN
+  SUBSCRIBE <pattern> Print changes to keys matching pattern as they happen
+  UNSUBSCRIBE [pat]   Stop printing changes (default: every pattern)
   SIZE                Get number of keys
+  INFO                Show memory use, the eviction policy and counters
   CLEAR               Remove all keys
+  BATCH <op>; <op>... Run GET/SET/DEL/EXISTS atomically; EXPECT <key> <value>
+                      and ABSENT <key> abort the batch if they do not hold
+  WATCH <key>...      Abort the next EXEC if key is written meanwhile
+  MULTI               Queue GET/SET/DEL/EXISTS/EXPECT/ABSENT until EXEC
+  EXEC                Run the queued commands atomically ((nil) if aborted)
+  DISCARD, UNWATCH    Drop the queued commands / stop watching
+  GETVER <key> <n>    Get the version n writes back (0 = current); needs -history
+  GETAT <key> <time>  Get the value at a time (RFC 3339 or Unix seconds)
+  HISTORY <key>       List the kept versions of key, oldest first
   SNAPSHOT            Save to disk
+      [--incremental] ... only the keys changed since the last save, as a delta
+  COMPACTSNAPSHOTS    Merge the saved deltas into the snapshot
+  BGREWRITEAOF        Compact the append-only file in the background
   HELP                Show this help
   EXIT                Exit the program
 `
 	fmt.Println(help)
 }
 
-func autoSave(store *Store, interval time.Duration) {
+func autoSave(store *Store, interval time.Duration, incremental bool) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for range ticker.C {
+		if incremental {
+			fmt.Printf("\n[Auto-save: %s]\n> ", saveIncremental(store))
+			continue
+		}
 		if err := store.Snapshot(); err != nil {
 			fmt.Printf("\nAuto-save error: %v\n> ", err)
 		} else {
@@ -306,3 +891,19 @@ func autoSave(store *Store, interval time.Duration) {
 		}
 	}
 }
+
+// saveIncremental runs SnapshotIncremental and describes what it saved
+func saveIncremental(store *Store) string {
+	saved, err := store.SnapshotIncremental()
+	switch {
+	case err != nil:
+		return fmt.Sprintf("Error: %v", err)
+	case saved.Full:
+		return fmt.Sprintf("Saved full snapshot to %s", store.filename)
+	case saved.Compacted:
+		return fmt.Sprintf("Saved delta %d and merged the deltas into %s", saved.Delta, store.filename)
+	case saved.Delta > 0:
+		return fmt.Sprintf("Saved delta %d to %s", saved.Delta, deltaPath(store.filename, saved.Delta))
+	}
+	return "No changes since the last save"
+}
@@ -1,10 +1,18 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestStoreBasicOperations(t *testing.T) {
@@ -222,8 +230,1777 @@ func TestStoreConcurrentMixed(t *testing.T) {
 	wg.Wait()
 }
 
+// waitFor polls cond until it holds or the deadline passes
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplicationFullSyncAndStream(t *testing.T) {
+	primaryStore := NewStore("")
+	primaryStore.Set("existing", "before-follower")
+
+	primary, err := StartPrimary(primaryStore, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	replica := NewStore("")
+	follower := StartFollower(replica, primary.Addr())
+	defer follower.Stop()
+
+	select {
+	case <-follower.Synced():
+	case <-time.After(5 * time.Second):
+		t.Fatal("follower never synced")
+	}
+	if v, ok := replica.Get("existing"); !ok || v != "before-follower" {
+		t.Errorf("full sync: Get(existing) = %q, %v", v, ok)
+	}
+
+	primaryStore.Set("k1", "v1")
+	primaryStore.Set("k2", "v 2 with spaces")
+	primaryStore.Delete("existing")
+	waitFor(t, "streamed commands", func() bool {
+		return follower.Offset() == primaryStore.backlog.Offset()
+	})
+
+	if v, _ := replica.Get("k2"); v != "v 2 with spaces" {
+		t.Errorf("Get(k2) = %q", v)
+	}
+	if replica.Exists("existing") {
+		t.Error("delete was not replicated")
+	}
+	if !replica.ReadOnly() {
+		t.Error("follower store should be read-only")
+	}
+}
+
+func TestReplicationReconnectCatchUp(t *testing.T) {
+	primaryStore := NewStore("")
+	primary, err := StartPrimary(primaryStore, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := primary.Addr()
+
+	replica := NewStore("")
+	follower := StartFollower(replica, addr)
+	defer follower.Stop()
+
+	primaryStore.Set("a", "1")
+	waitFor(t, "first command", func() bool { return follower.Offset() == 1 })
+
+	// Drop every follower connection; writes continue on the primary
+	primary.Close()
+	primaryStore.Set("b", "2")
+	primaryStore.Set("c", "3")
+
+	primary, err = StartPrimary(primaryStore, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	waitFor(t, "catch-up", func() bool { return follower.Offset() == 3 })
+	if v, _ := replica.Get("c"); v != "3" {
+		t.Errorf("Get(c) = %q after catch-up", v)
+	}
+}
+
+func TestBacklogWindow(t *testing.T) {
+	b := NewBacklog(2)
+	for i := 0; i < 3; i++ {
+		b.Append(Command{Op: opSet, Key: "k"})
+	}
+
+	if _, _, ok := b.Since(0); ok {
+		t.Error("offset 0 should be outside a 2-command window after 3 appends")
+	}
+	cmds, _, ok := b.Since(1)
+	if !ok || len(cmds) != 2 || cmds[0].Offset != 2 {
+		t.Errorf("Since(1) = %+v, %v", cmds, ok)
+	}
+	if _, _, ok := b.Since(4); ok {
+		t.Error("offset ahead of the backlog should require a full sync")
+	}
+}
+
+func TestStoreBatch(t *testing.T) {
+	store := NewStore("")
+	store.backlog = NewBacklog(defaultBacklogSize)
+	store.Set("stock", "1")
+
+	results, err := store.Batch([]BatchOp{
+		{Op: "EXPECT", Key: "stock", Value: "1"},
+		{Op: "SET", Key: "stock", Value: "0"},
+		{Op: "GET", Key: "stock"},
+		{Op: "DEL", Key: "missing"},
+		{Op: "ABSENT", Key: "owner"},
+		{Op: "SET", Key: "owner", Value: "bob"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[2]; !r.Found || r.Value != "0" {
+		t.Errorf("GET after SET in batch = %+v, want 0", r)
+	}
+	if results[3].Found {
+		t.Error("DEL of a missing key reported found")
+	}
+	if v, _ := store.Get("owner"); v != "bob" {
+		t.Errorf("Get(owner) = %q after batch", v)
+	}
+	if got := store.backlog.Offset(); got != 3 {
+		t.Errorf("backlog offset = %d, want 3 (one SET before, two in batch)", got)
+	}
+
+	// A failed check applies none of the batch, including earlier writes
+	_, err = store.Batch([]BatchOp{
+		{Op: "SET", Key: "stock", Value: "-1"},
+		{Op: "EXPECT", Key: "stock", Value: "1"},
+	})
+	if !errors.Is(err, ErrBatchAborted) {
+		t.Fatalf("Batch with failing EXPECT: err = %v, want ErrBatchAborted", err)
+	}
+	if v, _ := store.Get("stock"); v != "0" {
+		t.Errorf("aborted batch changed stock to %q", v)
+	}
+	if got := store.backlog.Offset(); got != 3 {
+		t.Errorf("aborted batch was replicated: offset %d", got)
+	}
+
+	// SET with a TTL expires the key; TTL reads it, staged writes included
+	results, err = store.Batch([]BatchOp{
+		{Op: "TTL", Key: "stock"},
+		{Op: "SET", Key: "session", Value: "abc", TTL: time.Minute},
+		{Op: "TTL", Key: "session"},
+		{Op: "TTL", Key: "missing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; !r.Found || r.TTL != NoExpiry {
+		t.Errorf("TTL of a key without one = %+v, want NoExpiry", r)
+	}
+	if r := results[2]; !r.Found || r.TTL <= 0 || r.TTL > time.Minute {
+		t.Errorf("TTL after SET with TTL in batch = %+v", r)
+	}
+	if results[3].Found {
+		t.Error("TTL of a missing key reported found")
+	}
+	if ttl, found := store.TTL("session"); !found || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("TTL(session) after batch = %v, %v", ttl, found)
+	}
+
+	if _, err := store.Batch([]BatchOp{{Op: "INCR", Key: "stock"}}); !errors.Is(err, ErrInvalidBatch) {
+		t.Errorf("unknown op: err = %v, want ErrInvalidBatch", err)
+	}
+	store.readOnly.Store(true)
+	if _, err := store.Batch([]BatchOp{{Op: "SET", Key: "k", Value: "v"}}); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("write batch on follower: err = %v, want ErrReadOnly", err)
+	}
+	if _, err := store.Batch([]BatchOp{{Op: "GET", Key: "stock"}}); err != nil {
+		t.Errorf("read batch on follower: %v", err)
+	}
+}
+
+func TestParseBatch(t *testing.T) {
+	ops, err := ParseBatch("expect stock 1; SET greeting hello world ;del stock;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []BatchOp{
+		{Op: "EXPECT", Key: "stock", Value: "1"},
+		{Op: "SET", Key: "greeting", Value: "hello world"},
+		{Op: "DEL", Key: "stock"},
+	}
+	if len(ops) != len(want) {
+		t.Fatalf("ParseBatch = %+v", ops)
+	}
+	for i := range want {
+		if ops[i] != want[i] {
+			t.Errorf("op %d = %+v, want %+v", i, ops[i], want[i])
+		}
+	}
+
+	for _, text := range []string{"", "SET k", "GET a b", "INCR k"} {
+		if _, err := ParseBatch(text); !errors.Is(err, ErrInvalidBatch) {
+			t.Errorf("ParseBatch(%q): err = %v, want ErrInvalidBatch", text, err)
+		}
+	}
+}
+
+func TestServerBatch(t *testing.T) {
+	store := NewStore("")
+	server, err := StartServer(store, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Set("stock", "1"); err != nil {
+		t.Fatal(err)
+	}
+	results, err := client.Batch([]BatchOp{
+		{Op: "EXPECT", Key: "stock", Value: "1"},
+		{Op: "SET", Key: "stock", Value: "0"},
+		{Op: "GET", Key: "stock"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 || results[2].Value != "0" {
+		t.Errorf("Batch results = %+v", results)
+	}
+
+	_, err = client.Batch([]BatchOp{{Op: "EXPECT", Key: "stock", Value: "1"}})
+	if !errors.Is(err, ErrBatchAborted) {
+		t.Errorf("remote aborted batch: err = %v, want ErrBatchAborted", err)
+	}
+
+	// The connection stays usable after an error
+	if v, ok, err := client.Get("stock"); err != nil || !ok || v != "0" {
+		t.Errorf("Get(stock) = %q, %v, %v", v, ok, err)
+	}
+	if found, err := client.Delete("stock"); err != nil || !found {
+		t.Errorf("Delete(stock) = %v, %v", found, err)
+	}
+
+	store.readOnly.Store(true)
+	if err := client.Set("k", "v"); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("remote write to follower: err = %v, want ErrReadOnly", err)
+	}
+}
+
+func TestShardedClient(t *testing.T) {
+	stores := make(map[string]*Store)
+	var addrs []string
+	for range 3 {
+		store := NewStore("")
+		server, err := StartServer(store, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		stores[server.Addr()] = store
+		addrs = append(addrs, server.Addr())
+	}
+
+	client, err := DialSharded(addrs[:2], ShardedOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// checkPlacement verifies every key is on the shard that owns it, and
+	// nowhere else
+	const n = 300
+	checkPlacement := func(when string) {
+		t.Helper()
+		for i := range n {
+			key := fmt.Sprintf("key:%d", i)
+			owner := client.ShardFor(key)
+			for addr, store := range stores {
+				if v, ok := store.Get(key); ok != (addr == owner) || (ok && v != strconv.Itoa(i)) {
+					t.Fatalf("%s: %s on %s = %q, %v (owner %s)", when, key, addr, v, ok, owner)
+				}
+			}
+			if v, ok, err := client.Get(key); err != nil || !ok || v != strconv.Itoa(i) {
+				t.Fatalf("%s: Get(%s) = %q, %v, %v", when, key, v, ok, err)
+			}
+		}
+	}
+
+	for i := range n {
+		if err := client.Set(fmt.Sprintf("key:%d", i), strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkPlacement("after Set")
+	for _, addr := range addrs[:2] {
+		if size := stores[addr].Size(); size < n/4 {
+			t.Errorf("shard %s holds %d of %d keys", addr, size, n)
+		}
+	}
+	stores[addrs[0]].LPush("queue", "a")
+
+	// A new shard takes over only the keys it now owns
+	moved, err := client.AddShard(addrs[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := stores[addrs[2]].Size(); moved != size || moved < n/6 || moved > n/2 {
+		t.Errorf("AddShard moved %d keys, new shard holds %d", moved, size)
+	}
+	checkPlacement("after AddShard")
+	if !slices.Equal(client.Shards(), slices.Sorted(slices.Values(addrs))) {
+		t.Errorf("Shards() = %v", client.Shards())
+	}
+
+	// Another client with the same shards places keys alike
+	other, err := DialSharded([]string{addrs[2], addrs[0], addrs[1]}, ShardedOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range n {
+		key := fmt.Sprintf("key:%d", i)
+		if a, b := client.ShardFor(key), other.ShardFor(key); a != b {
+			t.Fatalf("ShardFor(%s) = %s and %s", key, a, b)
+		}
+	}
+	other.Close()
+
+	// A shard holding a list cannot be removed, as the list would be lost
+	before := stores[addrs[0]].Size() - 1
+	if moved, err := client.RemoveShard(addrs[0]); !errors.Is(err, ErrUnmovableKeys) || moved != 0 {
+		t.Fatalf("RemoveShard with a list = %d, %v; want ErrUnmovableKeys", moved, err)
+	}
+	if stores[addrs[0]].Size() != before+1 {
+		t.Fatalf("refused RemoveShard moved keys: %d left of %d", stores[addrs[0]].Size(), before+1)
+	}
+
+	// Without it, the removed shard's keys move to the others
+	stores[addrs[0]].Delete("queue")
+	moved, err = client.RemoveShard(addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != before || stores[addrs[0]].Size() != 0 {
+		t.Errorf("RemoveShard moved %d of %d keys, %d left", moved, before, stores[addrs[0]].Size())
+	}
+	removed := stores[addrs[0]]
+	delete(stores, addrs[0])
+	checkPlacement("after RemoveShard")
+	if moved, err := client.Rebalance(); err != nil || moved != 0 {
+		t.Errorf("Rebalance() of a balanced ring = %d, %v", moved, err)
+	}
+
+	if found, err := client.Delete("key:1"); err != nil || !found {
+		t.Errorf("Delete(key:1) = %v, %v", found, err)
+	}
+	if _, ok, _ := client.Get("key:1"); ok {
+		t.Error("key:1 still found after Delete")
+	}
+
+	if _, err := client.AddShard(addrs[1]); !errors.Is(err, ErrShardExists) {
+		t.Errorf("AddShard of a shard in the ring: err = %v", err)
+	}
+	if _, err := client.RemoveShard(addrs[0]); !errors.Is(err, ErrUnknownShard) {
+		t.Errorf("RemoveShard of a removed shard: err = %v", err)
+	}
+	if _, err := client.RemoveShard(addrs[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.RemoveShard(addrs[2]); !errors.Is(err, ErrNoShards) {
+		t.Errorf("RemoveShard of the last shard: err = %v", err)
+	}
+
+	// The server lists keys, and reports wrong types, to any client
+	removed.LPush("queue", "a")
+	direct, err := Dial(addrs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer direct.Close()
+	if keys, err := direct.Keys("*"); err != nil || !slices.Equal(keys, []string{"queue"}) {
+		t.Errorf("Keys(*) = %v, %v", keys, err)
+	}
+	if _, _, err := direct.Get("queue"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("remote GET of a list: err = %v, want ErrWrongType", err)
+	}
+}
+
 // Benchmarks
 
+func TestStoreHistory(t *testing.T) {
+	store := NewStore("")
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := base
+	store.clock = func() time.Time { return now }
+	at := func(sec int) time.Time { return base.Add(time.Duration(sec) * time.Second) }
+	tick := func() { now = now.Add(time.Second) }
+
+	store.Set("old", "seeded")
+	store.SetHistoryPolicy(HistoryPolicy{Versions: 3})
+	for _, v := range []string{"a", "b", "c", "d"} {
+		tick()
+		store.Set("k", v)
+	}
+	tick()
+	store.Delete("k")
+
+	// Only the last three versions are kept, the delete included
+	if got := store.History("k"); len(got) != 3 || got[0].Value != "c" || !got[2].Deleted {
+		t.Fatalf("History(k) = %+v", got)
+	}
+	if v, err := store.GetVersion("k", 1); err != nil || v.Value != "d" || !v.Timestamp.Equal(at(4)) {
+		t.Errorf("GetVersion(k, 1) = %+v, %v", v, err)
+	}
+	if _, err := store.GetVersion("k", 3); !errors.Is(err, ErrNoVersion) {
+		t.Errorf("GetVersion past the kept versions = %v", err)
+	}
+
+	for _, tc := range []struct {
+		key   string
+		t     time.Time
+		value string
+		found bool
+		err   error
+	}{
+		{"k", at(3), "c", true, nil},
+		{"k", at(4).Add(time.Millisecond), "d", true, nil},
+		{"k", at(5), "", false, nil},
+		{"k", at(2), "", false, ErrNoVersion}, // "b" was dropped
+		{"old", at(9), "seeded", true, nil},
+		{"old", base.Add(-time.Second), "", false, ErrNoVersion}, // before history
+		{"new", at(9), "", false, nil},
+	} {
+		value, found, err := store.GetAt(tc.key, tc.t)
+		if value != tc.value || found != tc.found || !errors.Is(err, tc.err) {
+			t.Errorf("GetAt(%s, %v) = %q, %v, %v", tc.key, tc.t, value, found, err)
+		}
+	}
+
+	// A key created under history did not exist before its first version
+	tick()
+	store.Set("fresh", "1")
+	if _, found, err := store.GetAt("fresh", at(1)); found || err != nil {
+		t.Errorf("GetAt before creation = %v, %v", found, err)
+	}
+
+	// MaxAge drops versions replaced before the cutoff, keeping the one
+	// current at it, and deletes that aged out entirely
+	store.SetHistoryPolicy(HistoryPolicy{Versions: 10, MaxAge: 2 * time.Second})
+	now = at(6)
+	store.Set("k", "e")
+	now = at(20)
+	store.Set("k2", "x")
+	if dropped := store.CompactHistory(); dropped == 0 {
+		t.Error("CompactHistory dropped nothing")
+	}
+	if got := store.History("k"); len(got) != 1 || got[0].Value != "e" {
+		t.Errorf("History(k) after compaction = %+v", got)
+	}
+	store.Delete("k2")
+	now = at(30)
+	store.CompactHistory()
+	if got := store.History("k2"); got != nil {
+		t.Errorf("aged-out delete kept: %+v", got)
+	}
+	if _, found, err := store.GetAt("k2", at(29)); found || err != nil {
+		t.Errorf("GetAt of an aged-out key = %v, %v", found, err)
+	}
+
+	store.Clear()
+	if v, _ := store.GetVersion("old", 0); !v.Deleted {
+		t.Errorf("CLEAR did not record a delete: %+v", v)
+	}
+	store.SetHistoryPolicy(HistoryPolicy{})
+	if _, _, err := store.GetAt("old", at(1)); !errors.Is(err, ErrNoVersion) {
+		t.Errorf("GetAt with history off = %v", err)
+	}
+}
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2026, 1, 1, 0, 0, 1, 500_000_000, time.UTC)
+	for _, text := range []string{"2026-01-01T00:00:01.5Z", "1767225601.5"} {
+		if got, err := parseTimestamp(text); err != nil || !got.Equal(want) {
+			t.Errorf("parseTimestamp(%q) = %v, %v", text, got, err)
+		}
+	}
+	if _, err := parseTimestamp("yesterday"); err == nil {
+		t.Error("parseTimestamp accepted an invalid time")
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "ttl.json"))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.clock = func() time.Time { return now }
+
+	store.SetWithTTL("session", "abc", 10*time.Second)
+	store.SetWithTTL("token", "xyz", 30*time.Second)
+	store.Set("name", "Alice")
+	if ttl, ok := store.TTL("session"); !ok || ttl != 10*time.Second {
+		t.Errorf("TTL(session) = %v, %v", ttl, ok)
+	}
+	if ttl, ok := store.TTL("name"); !ok || ttl != NoExpiry {
+		t.Errorf("TTL(name) = %v, %v", ttl, ok)
+	}
+	if _, ok := store.TTL("missing"); ok {
+		t.Error("TTL of a missing key found it")
+	}
+
+	// A plain SET clears the TTL
+	store.SetWithTTL("name", "Bob", time.Second)
+	store.Set("name", "Carol")
+	if ttl, _ := store.TTL("name"); ttl != NoExpiry {
+		t.Errorf("TTL after SET = %v", ttl)
+	}
+
+	if err := store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	now = now.Add(10 * time.Second)
+	if store.Exists("session") || store.Size() != 2 || len(store.Keys("*")) != 2 {
+		t.Errorf("expired key visible: size %d, keys %v", store.Size(), store.Keys("*"))
+	}
+	// The read removed it
+	if _, ok := store.shardFor("session").data["session"]; ok {
+		t.Error("expired key not removed on read")
+	}
+	now = now.Add(20 * time.Second)
+	if n := store.ExpireKeys(); n != 1 || store.Size() != 1 {
+		t.Errorf("ExpireKeys() = %d, size %d", n, store.Size())
+	}
+
+	// The snapshot kept the expiry times; session expired while down
+	loaded := NewStore(store.filename)
+	loaded.clock = func() time.Time { return now.Add(-5 * time.Second) }
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, ok := loaded.TTL("token"); !ok || ttl != 5*time.Second {
+		t.Errorf("loaded TTL(token) = %v, %v", ttl, ok)
+	}
+	if loaded.Exists("session") || loaded.Size() != 2 {
+		t.Errorf("loaded size %d", loaded.Size())
+	}
+
+	// A batch does not see expired keys and SET in it clears a TTL
+	loaded.SetWithTTL("gone", "1", 0)
+	results, err := loaded.Batch([]BatchOp{{Op: batchGet, Key: "gone"}, {Op: batchSet, Key: "token", Value: "new"}})
+	if err != nil || results[0].Found {
+		t.Errorf("Batch GET of an expired key = %+v, %v", results, err)
+	}
+	if ttl, _ := loaded.TTL("token"); ttl != NoExpiry {
+		t.Errorf("TTL after batch SET = %v", ttl)
+	}
+}
+
+func TestReplicationTTL(t *testing.T) {
+	primaryStore := NewStore("")
+	primaryStore.SetWithTTL("before", "1", time.Hour)
+	primary, err := StartPrimary(primaryStore, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+
+	replica := NewStore("")
+	follower := StartFollower(replica, primary.Addr())
+	defer follower.Stop()
+
+	primaryStore.SetWithTTL("after", "2", time.Hour)
+	primaryStore.SetWithTTL("short", "3", time.Millisecond)
+	waitFor(t, "streamed commands", func() bool {
+		return follower.Offset() == primaryStore.backlog.Offset()
+	})
+	for _, key := range []string{"before", "after"} {
+		if ttl, ok := replica.TTL(key); !ok || ttl <= 0 || ttl > time.Hour {
+			t.Errorf("replica TTL(%s) = %v, %v", key, ttl, ok)
+		}
+	}
+
+	// The follower hides an expired key but leaves removing it to the
+	// primary
+	waitFor(t, "expiry", func() bool { return !replica.Exists("short") })
+	if replica.ExpireKeys() != 0 {
+		t.Error("follower removed keys itself")
+	}
+	primaryStore.ExpireKeys()
+	waitFor(t, "replicated expiry", func() bool {
+		sh := replica.shardFor("short")
+		sh.mu.RLock()
+		defer sh.mu.RUnlock()
+		_, ok := sh.data["short"]
+		return !ok
+	})
+}
+
+func TestParseSetArgs(t *testing.T) {
+	for _, tc := range []struct {
+		args  []string
+		value string
+		ttl   time.Duration
+		ok    bool
+	}{
+		{[]string{"v"}, "v", 0, true},
+		{[]string{"a", "b", "EX", "10"}, "a b", 10 * time.Second, true},
+		{[]string{"v", "ex", "5"}, "v", 5 * time.Second, true},
+		{[]string{"EX", "10"}, "EX 10", 0, true}, // the value itself
+		{[]string{"v", "EX", "0"}, "", 0, false},
+		{[]string{"v", "EX", "soon"}, "", 0, false},
+	} {
+		value, ttl, err := parseSetArgs(tc.args)
+		if value != tc.value || ttl != tc.ttl || (err == nil) != tc.ok {
+			t.Errorf("parseSetArgs(%q) = %q, %v, %v", tc.args, value, ttl, err)
+		}
+	}
+	if got := formatTTL(1500*time.Millisecond, true); got != "2" {
+		t.Errorf("formatTTL(1.5s) = %s", got)
+	}
+}
+
+func TestAOF(t *testing.T) {
+	dir := t.TempDir()
+	snapPath, aofPath := filepath.Join(dir, "data.json"), filepath.Join(dir, "data.aof")
+	open := func() (*Store, *AOF) {
+		t.Helper()
+		store := NewStore(snapPath)
+		if _, err := os.Stat(snapPath); err == nil {
+			if err := store.Load(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		aof, err := OpenAOF(store, aofPath, SyncAlways)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store, aof
+	}
+
+	store, aof := open()
+	store.Set("a", "1")
+	store.Set("b", "two words")
+	if err := store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	// Writes after the snapshot are replayed over it
+	store.Delete("a")
+	store.SetWithTTL("session", "abc", time.Hour)
+	if _, err := store.Batch([]BatchOp{{Op: batchSet, Key: "c", Value: "3"}}); err != nil {
+		t.Fatal(err)
+	}
+	aof.Close()
+
+	store, aof = open()
+	if store.Exists("a") || store.Size() != 3 || aof.Replayed() != 5 {
+		t.Errorf("after replay: keys %v, %d replayed", store.Keys("*"), aof.Replayed())
+	}
+	if v, _ := store.Get("b"); v != "two words" {
+		t.Errorf("Get(b) = %q", v)
+	}
+	if ttl, ok := store.TTL("session"); !ok || ttl == NoExpiry {
+		t.Errorf("replayed TTL(session) = %v, %v", ttl, ok)
+	}
+
+	// Rewriting leaves a clear and one set per key, and writes after it
+	// are appended to the new log
+	for i := range 50 {
+		store.Set("counter", strconv.Itoa(i))
+	}
+	before, _ := os.Stat(aofPath)
+	if err := store.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(aofPath)
+	if after.Size() >= before.Size() {
+		t.Errorf("rewrite grew the log from %d to %d bytes", before.Size(), after.Size())
+	}
+	store.Set("d", "4")
+	aof.Close()
+
+	store, aof = open()
+	if aof.Replayed() != 6 || store.Size() != 5 {
+		t.Errorf("after rewrite: %d replayed, keys %v", aof.Replayed(), store.Keys("*"))
+	}
+	if v, _ := store.Get("counter"); v != "49" {
+		t.Errorf("Get(counter) = %q", v)
+	}
+	aof.Close()
+
+	// A torn last record is dropped and the next write follows the last
+	// whole one
+	f, err := os.OpenFile(aofPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`0badc0de {"op":"set","ke`)
+	f.Close()
+	store, aof = open()
+	store.Set("e", "5")
+	aof.Close()
+	store, aof = open()
+	if v, _ := store.Get("e"); v != "5" || aof.Replayed() != 7 {
+		t.Errorf("after a torn record: Get(e) = %q, %d replayed", v, aof.Replayed())
+	}
+	aof.Close()
+
+	// A damaged record in the middle is an error
+	data, _ := os.ReadFile(aofPath)
+	data[len(data)/2] ^= 0xff
+	os.WriteFile(aofPath, data, 0644)
+	if _, err := OpenAOF(NewStore(""), aofPath, SyncNever); !errors.Is(err, ErrCorruptAOF) {
+		t.Errorf("corrupt log: err = %v", err)
+	}
+}
+
+func TestTypedValues(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "typed.json"))
+
+	if n, err := store.LPush("queue", "a", "b"); err != nil || n != 2 {
+		t.Fatalf("LPush = %d, %v", n, err)
+	}
+	store.LPush("queue", "c")
+	for _, tc := range []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, -1, []string{"c", "b", "a"}},
+		{1, 1, []string{"b"}},
+		{-2, 10, []string{"b", "a"}},
+		{2, 1, nil},
+	} {
+		if got, err := store.LRange("queue", tc.start, tc.stop); err != nil || !slices.Equal(got, tc.want) {
+			t.Errorf("LRange(%d, %d) = %v, %v, want %v", tc.start, tc.stop, got, err, tc.want)
+		}
+	}
+
+	if created, err := store.HSet("user:1", "name", "Alice"); !created || err != nil {
+		t.Errorf("HSet new field = %v, %v", created, err)
+	}
+	if created, _ := store.HSet("user:1", "name", "Alicia"); created {
+		t.Error("HSet of an existing field reported it new")
+	}
+	if v, ok, err := store.HGet("user:1", "name"); v != "Alicia" || !ok || err != nil {
+		t.Errorf("HGet = %q, %v, %v", v, ok, err)
+	}
+	if _, ok, _ := store.HGet("user:1", "age"); ok {
+		t.Error("HGet found a missing field")
+	}
+
+	for _, want := range []int64{1, 2} {
+		if n, err := store.Incr("hits"); n != want || err != nil {
+			t.Errorf("Incr = %d, %v, want %d", n, err, want)
+		}
+	}
+	if n, _ := store.Decr("hits"); n != 1 {
+		t.Errorf("Decr = %d", n)
+	}
+	store.Set("name", "Bob")
+	if _, err := store.Incr("name"); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("Incr of a non-integer = %v", err)
+	}
+	store.Set("big", "9223372036854775807")
+	if _, err := store.Incr("big"); !errors.Is(err, ErrNotInteger) {
+		t.Errorf("Incr past MaxInt64 = %v", err)
+	}
+
+	// A key holds one type at a time
+	if _, err := store.LPush("user:1", "x"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("LPush on a hash = %v", err)
+	}
+	if _, _, err := store.HGet("queue", "f"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("HGet on a list = %v", err)
+	}
+	if _, err := store.Batch([]BatchOp{{Op: batchGet, Key: "queue"}}); !errors.Is(err, ErrWrongType) {
+		t.Errorf("batch GET of a list = %v", err)
+	}
+	if store.Type("queue") != TypeList || store.Type("hits") != TypeString || store.Type("nope") != TypeNone {
+		t.Errorf("types: %s %s %s", store.Type("queue"), store.Type("hits"), store.Type("nope"))
+	}
+	if store.Size() != 5 || len(store.Keys("*")) != 5 || !store.Exists("user:1") {
+		t.Errorf("size %d, keys %v", store.Size(), store.Keys("*"))
+	}
+
+	// The snapshot is version 2 and holds the typed values
+	if err := store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := NewStore(store.filename)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := loaded.LRange("queue", 0, -1); !slices.Equal(got, []string{"c", "b", "a"}) {
+		t.Errorf("loaded list = %v", got)
+	}
+	if h, _ := loaded.HGetAll("user:1"); h["name"] != "Alicia" {
+		t.Errorf("loaded hash = %v", h)
+	}
+
+	// SET replaces a value of any type, and DEL removes any type
+	store.Set("queue", "flat")
+	if v, _ := store.Get("queue"); v != "flat" || store.Type("queue") != TypeString {
+		t.Errorf("SET over a list: %q, %s", v, store.Type("queue"))
+	}
+	if !store.Delete("user:1") || store.Exists("user:1") {
+		t.Error("Delete of a hash")
+	}
+
+	// Version 1 snapshots still load
+	v1 := filepath.Join(t.TempDir(), "v1.json")
+	os.WriteFile(v1, []byte(`{"version": 1, "timestamp": "2024-01-15T10:30:00Z", "data": {"k": "v"}}`), 0644)
+	old := NewStore(v1)
+	if err := old.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := old.Get("k"); v != "v" {
+		t.Errorf("v1 snapshot Get(k) = %q", v)
+	}
+	os.WriteFile(v1, []byte(`{"version": 3, "data": {}}`), 0644)
+	if err := old.Load(); err == nil {
+		t.Error("loaded a snapshot from a newer version")
+	}
+}
+
+func TestSnapshotFormats(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	fill := func(store *Store) {
+		store.clock = func() time.Time { return now }
+		for i := range 500 {
+			store.Set(fmt.Sprintf("visits:%d", i), strconv.Itoa(i))
+		}
+		store.Set("empty", "")
+		store.LPush("queue", "a", "b", "c")
+		store.HSet("user:1", "name", "Alice")
+		store.HSet("user:1", "city", "Paris")
+		store.SetWithTTL("session", "abc", time.Minute)
+	}
+
+	sizes := make(map[string]int64)
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		for _, gz := range []bool{false, true} {
+			name := fmt.Sprintf("%s-gzip=%v", format, gz)
+			t.Run(name, func(t *testing.T) {
+				path := filepath.Join(dir, name)
+				store := NewStoreWithOptions(path, StoreOptions{SnapshotFormat: format, SnapshotGzip: gz})
+				fill(store)
+				if err := store.Snapshot(); err != nil {
+					t.Fatal(err)
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sizes[name] = info.Size()
+
+				// The format is detected, whatever the loading store writes
+				loaded := NewStore(path)
+				loaded.clock = func() time.Time { return now }
+				if err := loaded.Load(); err != nil {
+					t.Fatal(err)
+				}
+				if loaded.Size() != store.Size() {
+					t.Errorf("loaded %d keys, want %d", loaded.Size(), store.Size())
+				}
+				for _, k := range []string{"visits:7", "empty"} {
+					want, _ := store.Get(k)
+					if got, ok := loaded.Get(k); !ok || got != want {
+						t.Errorf("Get(%s) = %.20q, %v", k, got, ok)
+					}
+				}
+				if l, _ := loaded.LRange("queue", 0, -1); !slices.Equal(l, []string{"c", "b", "a"}) {
+					t.Errorf("loaded list = %v", l)
+				}
+				if h, _ := loaded.HGetAll("user:1"); !maps.Equal(h, map[string]string{"name": "Alice", "city": "Paris"}) {
+					t.Errorf("loaded hash = %v", h)
+				}
+				if ttl, ok := loaded.TTL("session"); !ok || ttl != time.Minute {
+					t.Errorf("loaded TTL = %v, %v", ttl, ok)
+				}
+			})
+		}
+	}
+	// Small values are where the JSON overhead shows
+	if sizes["binary-gzip=false"]*3/2 > sizes["json-gzip=false"] || sizes["binary-gzip=true"] >= sizes["binary-gzip=false"] {
+		t.Errorf("snapshot sizes: %v", sizes)
+	}
+
+	// Strings longer than a read chunk round-trip
+	path := filepath.Join(dir, "binary-gzip=false")
+	long := NewStoreWithOptions(path, StoreOptions{SnapshotFormat: SnapshotBinary})
+	big := strings.Repeat("0123456789", stringChunk/3)
+	long.Set("big", big)
+	if err := long.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if err := long.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := long.Get("big"); v != big {
+		t.Errorf("long string: got %d bytes, want %d", len(v), len(big))
+	}
+
+	// A damaged or truncated binary snapshot is rejected
+	good, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	damaged := slices.Clone(good)
+	damaged[len(damaged)/2] ^= 0xff
+	for name, data := range map[string][]byte{"damaged": damaged, "truncated": good[:len(good)-3], "header": good[:6]} {
+		os.WriteFile(path, data, 0644)
+		if err := NewStore(path).Load(); !errors.Is(err, ErrCorruptSnapshot) {
+			t.Errorf("%s snapshot: err = %v, want ErrCorruptSnapshot", name, err)
+		}
+	}
+
+	if _, err := ParseSnapshotFormat("xml"); err == nil {
+		t.Error("ParseSnapshotFormat accepted xml")
+	}
+}
+
+func TestIncrementalSnapshots(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	// dump renders every key of store, to compare stores whole
+	dump := func(store *Store) string {
+		var b strings.Builder
+		keys := store.Keys("*")
+		slices.Sort(keys)
+		for _, k := range keys {
+			ttl, _ := store.TTL(k)
+			fmt.Fprintf(&b, "%s %s ttl=%v:", k, store.Type(k), ttl)
+			switch store.Type(k) {
+			case TypeString:
+				v, _ := store.Get(k)
+				b.WriteString(v)
+			case TypeList:
+				l, _ := store.LRange(k, 0, -1)
+				fmt.Fprint(&b, l)
+			case TypeHash:
+				h, _ := store.HGetAll(k)
+				fmt.Fprint(&b, h)
+			}
+			b.WriteString("\n")
+		}
+		return b.String()
+	}
+	reload := func(t *testing.T, path string) *Store {
+		t.Helper()
+		loaded := NewStore(path)
+		loaded.clock = clock
+		if err := loaded.Load(); err != nil {
+			t.Fatal(err)
+		}
+		return loaded
+	}
+	save := func(t *testing.T, store *Store, want IncrementalSave) {
+		t.Helper()
+		saved, err := store.SnapshotIncremental()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved != want {
+			t.Fatalf("SnapshotIncremental() = %+v, want %+v", saved, want)
+		}
+	}
+
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		t.Run(string(format), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "data")
+			store := NewStoreWithOptions(path, StoreOptions{SnapshotFormat: format, MaxDeltas: 3})
+			store.clock = clock
+			for i := range 100 {
+				store.Set(fmt.Sprintf("key:%d", i), strconv.Itoa(i))
+			}
+			store.LPush("queue", "a")
+			store.HSet("user:1", "name", "Alice")
+
+			// With nothing to build on, the first save is full
+			save(t, store, IncrementalSave{Full: true})
+			store.Set("key:1", "one")
+			store.Delete("key:2")
+			store.LPush("queue", "b")
+			store.HSet("user:1", "city", "Paris")
+			store.SetWithTTL("session", "abc", time.Minute)
+			store.Delete("key:3")
+			store.Set("key:3", "three") // deleted, then written again
+			save(t, store, IncrementalSave{Delta: 1})
+			if info, _ := os.Stat(deltaPath(path, 1)); info == nil {
+				t.Fatal("no delta file")
+			}
+			save(t, store, IncrementalSave{})
+			if _, err := os.Stat(deltaPath(path, 2)); err == nil {
+				t.Error("a save with no changes wrote a delta")
+			}
+			store.Delete("queue")
+			store.Set("user:1", "replaced")
+			save(t, store, IncrementalSave{Delta: 2})
+			if got, want := dump(reload(t, path)), dump(store); got != want {
+				t.Errorf("loaded deltas:\n%s\nwant:\n%s", got, want)
+			}
+
+			// A loaded store goes on saving deltas on the same snapshot
+			loaded := reload(t, path)
+			loaded.snapshotFormat, loaded.maxDeltas = format, 3
+			loaded.Set("key:4", "four")
+			save(t, loaded, IncrementalSave{Delta: 3, Compacted: true})
+			for seq := 1; seq <= 3; seq++ {
+				if _, err := os.Stat(deltaPath(path, seq)); err == nil {
+					t.Errorf("delta %d left after compaction", seq)
+				}
+			}
+			if got, want := dump(reload(t, path)), dump(loaded); got != want {
+				t.Errorf("loaded after compaction:\n%s\nwant:\n%s", got, want)
+			}
+
+			// A delta saved on an older snapshot is ignored
+			loaded.Set("key:5", "five")
+			save(t, loaded, IncrementalSave{Delta: 1})
+			stale, err := os.ReadFile(deltaPath(path, 1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := loaded.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+			loaded.Set("key:5", "newer")
+			os.WriteFile(deltaPath(path, 1), stale, 0644)
+			if v, _ := reload(t, path).Get("key:5"); v != "five" {
+				t.Errorf("key:5 = %q from the full snapshot, want five", v)
+			}
+			// and overwritten by the next delta
+			save(t, loaded, IncrementalSave{Delta: 1})
+			if v, _ := reload(t, path).Get("key:5"); v != "newer" {
+				t.Errorf("key:5 = %q, want newer", v)
+			}
+
+			// Compaction merges the deltas on disk
+			loaded.Delete("key:6")
+			save(t, loaded, IncrementalSave{Delta: 2})
+			if err := loaded.CompactSnapshots(); err != nil {
+				t.Fatal(err)
+			}
+			if matches, _ := filepath.Glob(path + ".delta.*"); len(matches) > 0 {
+				t.Errorf("deltas left after CompactSnapshots: %v", matches)
+			}
+			if got, want := dump(reload(t, path)), dump(loaded); got != want {
+				t.Errorf("loaded after CompactSnapshots:\n%s\nwant:\n%s", got, want)
+			}
+
+			// A Clear cannot be a delta
+			loaded.Clear()
+			loaded.Set("fresh", "1")
+			save(t, loaded, IncrementalSave{Full: true})
+			if got := reload(t, path).Size(); got != 1 {
+				t.Errorf("loaded %d keys after Clear, want 1", got)
+			}
+		})
+	}
+}
+
+func BenchmarkSnapshotFormats(b *testing.B) {
+	for _, format := range []SnapshotFormat{SnapshotJSON, SnapshotBinary} {
+		b.Run(string(format), func(b *testing.B) {
+			store := NewStoreWithOptions(filepath.Join(b.TempDir(), "snap"), StoreOptions{SnapshotFormat: format})
+			for i := range 10000 {
+				store.Set(fmt.Sprintf("key:%d", i), fmt.Sprintf("value:%d", i))
+			}
+			b.ResetTimer()
+			for range b.N {
+				if err := store.Snapshot(); err != nil {
+					b.Fatal(err)
+				}
+				if err := store.Load(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestTypedValuesReplay(t *testing.T) {
+	aofPath := filepath.Join(t.TempDir(), "typed.aof")
+	store := NewStore("")
+	aof, err := OpenAOF(store, aofPath, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary, err := StartPrimary(store, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer primary.Close()
+	replica := NewStore("")
+	follower := StartFollower(replica, primary.Addr())
+	defer follower.Stop()
+
+	store.LPush("list", "a", "b")
+	store.HSet("hash", "f", "v")
+	store.Incr("n")
+	store.LPush("list", "c")
+	check := func(name string, s *Store) {
+		t.Helper()
+		if got, _ := s.LRange("list", 0, -1); !slices.Equal(got, []string{"c", "b", "a"}) {
+			t.Errorf("%s: list = %v", name, got)
+		}
+		if v, _, _ := s.HGet("hash", "f"); v != "v" {
+			t.Errorf("%s: HGet = %q", name, v)
+		}
+		if v, _ := s.Get("n"); v != "1" {
+			t.Errorf("%s: counter = %q", name, v)
+		}
+	}
+
+	waitFor(t, "streamed commands", func() bool {
+		return follower.Offset() == store.backlog.Offset()
+	})
+	check("replica", replica)
+
+	aof.Close()
+	replayed := NewStore("")
+	aof, err = OpenAOF(replayed, aofPath, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("replayed", replayed)
+	if err := replayed.RewriteAOF(); err != nil {
+		t.Fatal(err)
+	}
+	aof.Close()
+	rewritten := NewStore("")
+	aof, err = OpenAOF(rewritten, aofPath, SyncNever)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aof.Close()
+	check("rewritten", rewritten)
+}
+
+func TestWatchExec(t *testing.T) {
+	store := NewStore("")
+	now := time.Now()
+	store.clock = func() time.Time { return now }
+	store.Set("balance", "100")
+	set := []BatchOp{{Op: batchSet, Key: "balance", Value: "90"}}
+
+	// Nothing written in between: the transaction applies
+	txn := store.Watch("balance")
+	store.Set("other", "x")
+	results, err := txn.Exec(append([]BatchOp{{Op: batchGet, Key: "balance"}}, set...))
+	if err != nil || results[0].Value != "100" {
+		t.Fatalf("Exec = %+v, %v", results, err)
+	}
+	if v, _ := store.Get("balance"); v != "90" {
+		t.Errorf("balance = %q, want 90", v)
+	}
+	if _, err := txn.Exec(set); !errors.Is(err, ErrTxnDone) {
+		t.Errorf("second Exec = %v, want ErrTxnDone", err)
+	}
+
+	// Any write to a watched key aborts: a set, a batch, a clear, even of
+	// the same value
+	for name, write := range map[string]func(){
+		"set":     func() { store.Set("balance", "90") },
+		"batch":   func() { store.Batch([]BatchOp{{Op: batchDel, Key: "balance"}}) },
+		"clear":   func() { store.Clear() },
+		"counter": func() { store.Incr("balance") },
+	} {
+		store.Set("balance", "90")
+		txn := store.Watch("balance", "other")
+		write()
+		if _, err := txn.Exec([]BatchOp{{Op: batchSet, Key: "other", Value: "y"}}); !errors.Is(err, ErrTxnAborted) {
+			t.Errorf("%s: Exec = %v, want ErrTxnAborted", name, err)
+		}
+		if v, _ := store.Get("other"); v == "y" {
+			t.Errorf("%s: aborted transaction applied", name)
+		}
+	}
+
+	// A watched key that expires counts as written
+	store.SetWithTTL("session", "abc", time.Second)
+	txn = store.Watch("session")
+	now = now.Add(2 * time.Second)
+	if _, err := txn.Exec(nil); !errors.Is(err, ErrTxnAborted) {
+		t.Errorf("Exec after expiry = %v, want ErrTxnAborted", err)
+	}
+
+	// Discard stops watching
+	txn = store.Watch("balance")
+	txn.Watch("session")
+	txn.Discard()
+	for i, sh := range store.shards {
+		if len(sh.watched) != 0 {
+			t.Errorf("keys still watched in shard %d: %v", i, sh.watched)
+		}
+	}
+}
+
+func TestScanRange(t *testing.T) {
+	if _, _, err := NewStore("").Scan("", "", 0); !errors.Is(err, ErrNoIndex) {
+		t.Fatalf("Scan without index: %v", err)
+	}
+
+	store := NewStoreWithOptions("", StoreOptions{Shards: 4, OrderedIndex: true})
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.clock = func() time.Time { return now }
+	for i := range 25 {
+		store.Set(fmt.Sprintf("user:%02d", i), "x")
+	}
+	store.LPush("user:list", "a")
+	store.HSet("user:hash", "f", "v")
+	store.Set("account", "x")
+	store.Set("zebra", "x")
+	store.SetWithTTL("user:gone", "x", time.Second)
+	store.Delete("user:13")
+	now = now.Add(2 * time.Second)
+
+	// Paging through the prefix returns every live key once, in order
+	var all []string
+	cursor, pages := "", 0
+	for {
+		keys, next, err := store.Scan("user:", cursor, 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		all = append(all, keys...)
+		pages++
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if len(all) != 26 || pages != 4 || !slices.IsSorted(all) || all[0] != "user:00" || all[25] != "user:list" || slices.Contains(all, "user:13") {
+		t.Errorf("scan in %d pages = %v", pages, all)
+	}
+
+	keys, next, _ := store.Range("user:05", "user:08", "", 0)
+	if !slices.Equal(keys, []string{"user:05", "user:06", "user:07"}) || next != "" {
+		t.Errorf("range = %v, next %q", keys, next)
+	}
+	keys, _, _ = store.Range("user:hash", "", "user:list", 0)
+	if !slices.Equal(keys, []string{"zebra"}) {
+		t.Errorf("range after cursor = %v", keys)
+	}
+
+	// The index follows writes that replace the whole store
+	store.filename = filepath.Join(t.TempDir(), "scan.json")
+	if err := store.Snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	store.Clear()
+	if keys, _, _ := store.Scan("", "", 0); len(keys) != 0 {
+		t.Errorf("scan after Clear = %v", keys)
+	}
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, _, _ := store.Scan("a", "", 0); !slices.Equal(keys, []string{"account"}) {
+		t.Errorf("scan after Load = %v", keys)
+	}
+}
+
+func TestSkipList(t *testing.T) {
+	l := newSkipList()
+	want := make(map[string]bool)
+	for i := range 2000 {
+		key := strconv.Itoa(i * 7919 % 1000)
+		if i%3 == 2 {
+			if l.remove(key) != want[key] {
+				t.Fatalf("remove(%q) disagrees", key)
+			}
+			delete(want, key)
+		} else {
+			if l.insert(key) == want[key] {
+				t.Fatalf("insert(%q) disagrees", key)
+			}
+			want[key] = true
+		}
+	}
+	var got []string
+	for n := l.seek(""); n != nil; n = n.next[0] {
+		got = append(got, n.key)
+	}
+	sorted := slices.Sorted(maps.Keys(want))
+	if !slices.Equal(got, sorted) || l.len != len(want) {
+		t.Errorf("skip list holds %d keys, want %d", len(got), len(want))
+	}
+	i, _ := slices.BinarySearch(sorted, "5")
+	if n := l.seek("5"); n == nil || n.key != sorted[i] {
+		t.Errorf("seek(5) = %v, want %q", n, sorted[i])
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	store := NewStore("")
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.clock = func() time.Time { return now }
+
+	if _, err := store.Subscribe("[bad"); err == nil {
+		t.Error("Subscribe accepted an invalid pattern")
+	}
+	sub, err := store.Subscribe("user:*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	store.Set("other", "x")
+	store.Set("user:1", "a")
+	store.Incr("user:n")
+	store.LPush("user:list", "x", "y")
+	store.HSet("user:h", "f", "v")
+	store.Delete("user:1")
+	store.SetWithTTL("user:s", "t", time.Second)
+	now = now.Add(time.Second)
+	store.ExpireKeys()
+	store.Clear()
+
+	want := []Event{
+		{Kind: EventSet, Key: "user:1", Value: "a"},
+		{Kind: EventSet, Key: "user:n", Value: "1"},
+		{Kind: EventLPush, Key: "user:list"},
+		{Kind: EventHSet, Key: "user:h", Field: "f", Value: "v"},
+		{Kind: EventDelete, Key: "user:1"},
+		{Kind: EventSet, Key: "user:s", Value: "t"},
+		{Kind: EventExpire, Key: "user:s"},
+		{Kind: EventClear},
+	}
+	for _, w := range want {
+		select {
+		case e := <-sub.C:
+			if e != w {
+				t.Errorf("event = %+v, want %+v", e, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event, want %+v", w)
+		}
+	}
+	select {
+	case e := <-sub.C:
+		t.Errorf("unexpected event %+v", e)
+	default:
+	}
+
+	// A subscriber that falls behind is dropped rather than slowing writes
+	slow, err := store.Subscribe("*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i <= subscriptionBuffer; i++ {
+		store.Set("k", strconv.Itoa(i))
+	}
+	n := 0
+	for range slow.C {
+		n++
+	}
+	if n != subscriptionBuffer || !errors.Is(slow.Err(), ErrSlowSubscriber) {
+		t.Errorf("slow subscriber got %d events, err %v", n, slow.Err())
+	}
+	slow.Close()
+
+	sub.Close()
+	store.Set("user:2", "b")
+	if _, ok := <-sub.C; ok || sub.Err() != nil {
+		t.Errorf("event after Close, err %v", sub.Err())
+	}
+	if store.pubsub.n.Load() != 0 {
+		t.Errorf("%d subscriptions left", store.pubsub.n.Load())
+	}
+}
+
+func TestServerSubscribe(t *testing.T) {
+	store := NewStore("")
+	server, err := StartServer(store, "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	client, err := Dial(server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if _, err := client.Subscribe("[bad"); err == nil {
+		t.Error("remote Subscribe accepted an invalid pattern")
+	}
+	if err := client.Set("k0", "0"); err != nil {
+		t.Errorf("Set after a rejected subscription: %v", err)
+	}
+	sub, err := client.Subscribe("k*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Set("a", "1")
+	store.Set("k1", "1")
+	store.Delete("k1")
+	for _, w := range []Event{{Kind: EventSet, Key: "k1", Value: "1"}, {Kind: EventDelete, Key: "k1"}} {
+		select {
+		case e := <-sub.C:
+			if e != w {
+				t.Errorf("remote event = %+v, want %+v", e, w)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no remote event, want %+v", w)
+		}
+	}
+
+	// Closing the subscription disconnects, which unsubscribes on the server
+	sub.Close()
+	deadline := time.Now().Add(time.Second)
+	for store.pubsub.n.Load() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("server kept the subscription of a closed client")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMoveKey(t *testing.T) {
+	src, dst := NewStore(""), NewStore("")
+	var clients []*Client
+	for _, store := range []*Store{src, dst} {
+		server, err := StartServer(store, "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer server.Close()
+		client, err := Dial(server.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		clients = append(clients, client)
+	}
+	// duringCopy runs once, while dst stores the copy of a key with a TTL
+	var duringCopy atomic.Pointer[func()]
+	dst.clock = func() time.Time {
+		if fn := duringCopy.Swap(nil); fn != nil {
+			(*fn)()
+		}
+		return time.Now()
+	}
+	move := func(key string, during func()) (bool, error) {
+		duringCopy.Store(&during)
+		return moveKey(clients[0], clients[1], key)
+	}
+
+	// The key keeps its TTL
+	src.SetWithTTL("session", "abc", time.Hour)
+	if ok, err := move("session", func() {}); err != nil || !ok {
+		t.Fatalf("moveKey(session) = %v, %v", ok, err)
+	}
+	if ttl, found := dst.TTL("session"); !found || ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Errorf("moved TTL = %v, %v; want about an hour", ttl, found)
+	}
+	if src.Exists("session") {
+		t.Error("moved key left on the source")
+	}
+
+	// A write landing between the copy and the delete is not lost
+	src.SetWithTTL("counter", "1", time.Hour)
+	if ok, err := move("counter", func() { src.Set("counter", "2") }); err != nil || !ok {
+		t.Fatalf("moveKey(counter) = %v, %v", ok, err)
+	}
+	if v, _ := dst.Get("counter"); v != "2" || src.Exists("counter") {
+		t.Errorf("after a concurrent write: dst = %q, on source %v; want 2, false", v, src.Exists("counter"))
+	}
+	if ttl, _ := dst.TTL("counter"); ttl != NoExpiry {
+		t.Errorf("TTL after the write cleared it = %v, want NoExpiry", ttl)
+	}
+
+	// Nor is a delete: the copy is taken back
+	src.SetWithTTL("token", "x", time.Hour)
+	if ok, err := move("token", func() { src.Delete("token") }); err != nil || ok {
+		t.Fatalf("moveKey(token) = %v, %v; want false", ok, err)
+	}
+	if dst.Exists("token") {
+		t.Error("deleted key resurrected on the destination")
+	}
+
+	// Lists and hashes are not moved
+	src.LPush("queue", "a")
+	if _, err := moveKey(clients[0], clients[1], "queue"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("moveKey(queue) err = %v, want ErrWrongType", err)
+	}
+}
+
+func TestShards(t *testing.T) {
+	for _, shards := range []int{1, 16} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			store := NewStoreWithOptions("", StoreOptions{Shards: shards})
+			if len(store.shards) != shards {
+				t.Fatalf("got %d shards, want %d", len(store.shards), shards)
+			}
+
+			var wg sync.WaitGroup
+			for w := 0; w < 8; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := 0; i < 100; i++ {
+						key := fmt.Sprintf("w%d:%d", w, i)
+						store.Set(key, key)
+						store.Get(key)
+						if _, err := store.Batch([]BatchOp{
+							{Op: batchExpect, Key: key, Value: key},
+							{Op: batchSet, Key: key + ":copy", Value: key},
+						}); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+
+			if got := store.Size(); got != 1600 {
+				t.Fatalf("Size = %d, want 1600", got)
+			}
+			if got := len(store.Keys("w3:*:copy")); got != 100 {
+				t.Errorf("Keys matched %d, want 100", got)
+			}
+			store.filename = filepath.Join(t.TempDir(), "shards.json")
+			if err := store.Snapshot(); err != nil {
+				t.Fatal(err)
+			}
+			loaded := NewStoreWithOptions(store.filename, StoreOptions{Shards: 3})
+			if err := loaded.Load(); err != nil {
+				t.Fatal(err)
+			}
+			if v, ok := loaded.Get("w7:42:copy"); !ok || v != "w7:42" {
+				t.Errorf("after Load: Get = %q, %v", v, ok)
+			}
+			store.Clear()
+			if store.Size() != 0 {
+				t.Errorf("Size after Clear = %d", store.Size())
+			}
+		})
+	}
+}
+
+func TestMaxMemory(t *testing.T) {
+	// measured adds up what every key measures, for what MemoryUsed keeps
+	// up to date write by write
+	measured := func(store *Store) int64 {
+		var n int64
+		for _, sh := range store.shards {
+			sh.mu.RLock()
+			for k := range sh.data {
+				n += sh.sizeLocked(k)
+			}
+			for k := range sh.lists {
+				n += sh.sizeLocked(k)
+			}
+			for k := range sh.hashes {
+				n += sh.sizeLocked(k)
+			}
+			sh.mu.RUnlock()
+		}
+		return n
+	}
+
+	store := NewStore("")
+	store.Set("a", "1")
+	store.Set("a", "longer")
+	store.LPush("list", "x", "yy")
+	store.LPush("list", "zzz")
+	store.HSet("hash", "f", "v")
+	store.HSet("hash", "f", "replaced")
+	store.HSet("hash", "g", "")
+	store.Incr("n")
+	store.Batch([]BatchOp{{Op: batchSet, Key: "b", Value: "2"}, {Op: batchDel, Key: "a"}})
+	store.Set("list", "now a string")
+	if got, want := store.MemoryUsed(), measured(store); got != want || got == 0 {
+		t.Errorf("MemoryUsed() = %d, keys measure %d", got, want)
+	}
+	store.Delete("hash")
+	store.Delete("n")
+	store.Delete("b")
+	store.Delete("list")
+	if got := store.MemoryUsed(); got != 0 {
+		t.Errorf("MemoryUsed() = %d with no keys", got)
+	}
+
+	t.Run("noeviction", func(t *testing.T) {
+		store := NewStoreWithOptions("", StoreOptions{MaxMemory: 10 * stringSize("key:0", "value")})
+		var err error
+		n := 0
+		for ; n < 100 && err == nil; n++ {
+			err = store.Set(fmt.Sprintf("key:%d", n), "value")
+		}
+		if !errors.Is(err, ErrOutOfMemory) {
+			t.Fatalf("Set = %v after %d keys, want ErrOutOfMemory", err, n)
+		}
+		if _, err := store.LPush("list", "x"); !errors.Is(err, ErrOutOfMemory) {
+			t.Errorf("LPush = %v, want ErrOutOfMemory", err)
+		}
+		// Deletes free memory for writes again
+		store.Delete("key:0")
+		store.Delete("key:1")
+		if err := store.Set("key:0", "value"); err != nil {
+			t.Errorf("Set after deletes: %v", err)
+		}
+		if info := store.Info(); info.EvictedKeys != 0 || info.OOMRejections != 2 || info.EvictionPolicy != NoEviction {
+			t.Errorf("Info() = %+v", info)
+		}
+	})
+
+	t.Run("allkeys-lru", func(t *testing.T) {
+		limit := 20 * stringSize("key:00", "value")
+		store := NewStoreWithOptions("", StoreOptions{Shards: 2, MaxMemory: limit, EvictionPolicy: AllKeysLRU})
+		sub, err := store.Subscribe("*")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+		store.Set("hot", "value")
+		for i := range 100 {
+			if err := store.Set(fmt.Sprintf("key:%02d", i), "value"); err != nil {
+				t.Fatal(err)
+			}
+			store.Get("hot")
+		}
+		if _, ok := store.Get("hot"); !ok {
+			t.Error("the most recently used key was evicted")
+		}
+		if used := store.MemoryUsed(); used > limit+stringSize("key:00", "value") {
+			t.Errorf("MemoryUsed() = %d over the limit of %d", used, limit)
+		}
+		info := store.Info()
+		if info.EvictedKeys == 0 || int(info.EvictedKeys)+info.Keys != 101 {
+			t.Errorf("Info() = %+v", info)
+		}
+		evicted := 0
+		for len(sub.C) > 0 {
+			if e := <-sub.C; e.Kind == EventEvict {
+				evicted++
+			}
+		}
+		if evicted != int(info.EvictedKeys) {
+			t.Errorf("%d evicted events for %d evictions", evicted, info.EvictedKeys)
+		}
+	})
+
+	t.Run("volatile-ttl", func(t *testing.T) {
+		limit := 10 * stringSize("key:00", "value")
+		store := NewStoreWithOptions("", StoreOptions{MaxMemory: limit, EvictionPolicy: VolatileTTL})
+		for i := range 5 {
+			store.Set(fmt.Sprintf("key:%02d", i), "value")
+		}
+		for i := 5; i < 20; i++ {
+			if err := store.SetWithTTL(fmt.Sprintf("key:%02d", i), "value", time.Duration(i)*time.Minute); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := range 5 {
+			if !store.Exists(fmt.Sprintf("key:%02d", i)) {
+				t.Errorf("key:%02d without a TTL was evicted", i)
+			}
+		}
+		// Once only keys without a TTL are left, writes fail
+		var err error
+		for i := 20; i < 40 && err == nil; i++ {
+			err = store.Set(fmt.Sprintf("key:%02d", i), "value")
+		}
+		if !errors.Is(err, ErrOutOfMemory) {
+			t.Errorf("Set = %v with no key to evict, want ErrOutOfMemory", err)
+		}
+	})
+
+	if n, err := parseMemorySize("100MB"); err != nil || n != 100<<20 {
+		t.Errorf("parseMemorySize(100MB) = %d, %v", n, err)
+	}
+	for _, bad := range []string{"", "mb", "-1kb", "10tb"} {
+		if _, err := parseMemorySize(bad); err == nil {
+			t.Errorf("parseMemorySize(%q) succeeded", bad)
+		}
+	}
+	if _, err := ParseEvictionPolicy("allkeys-random"); err == nil {
+		t.Error("ParseEvictionPolicy accepted allkeys-random")
+	}
+}
+
+func TestCASGetSet(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "cas.json"))
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	store.clock = func() time.Time { return now }
+
+	if err := store.CAS("missing", "", "x"); !errors.Is(err, ErrCASMismatch) {
+		t.Errorf("CAS of a missing key = %v, want ErrCASMismatch", err)
+	}
+	store.SetWithTTL("version", "1", time.Minute)
+	if err := store.CAS("version", "2", "3"); !errors.Is(err, ErrCASMismatch) {
+		t.Errorf("CAS with the wrong value = %v, want ErrCASMismatch", err)
+	}
+	if err := store.CAS("version", "1", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := store.Get("version"); v != "2" {
+		t.Errorf("after CAS, version = %q", v)
+	}
+	if ttl, _ := store.TTL("version"); ttl != time.Minute {
+		t.Errorf("TTL after CAS = %v, want it kept", ttl)
+	}
+
+	if old, found, err := store.GetSet("version", "3"); err != nil || !found || old != "2" {
+		t.Errorf("GetSet = %q, %v, %v", old, found, err)
+	}
+	if ttl, _ := store.TTL("version"); ttl != NoExpiry {
+		t.Errorf("TTL after GetSet = %v, want it cleared", ttl)
+	}
+	if old, found, err := store.GetSet("fresh", "a"); err != nil || found || old != "" {
+		t.Errorf("GetSet of a missing key = %q, %v, %v", old, found, err)
+	}
+	store.SetWithTTL("gone", "old", time.Second)
+	now = now.Add(time.Second)
+	if _, found, _ := store.GetSet("gone", "new"); found {
+		t.Error("GetSet found an expired key")
+	}
+	if err := store.CAS("gone", "old", "x"); !errors.Is(err, ErrCASMismatch) {
+		t.Errorf("CAS on the value an expired key had = %v", err)
+	}
+
+	store.LPush("list", "a")
+	if err := store.CAS("list", "a", "b"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("CAS on a list = %v, want ErrWrongType", err)
+	}
+	if _, _, err := store.GetSet("list", "b"); !errors.Is(err, ErrWrongType) {
+		t.Errorf("GetSet on a list = %v, want ErrWrongType", err)
+	}
+	if store.Type("list") != TypeList {
+		t.Error("GetSet replaced a list")
+	}
+
+	// Increments built on CAS lose no update, however they interleave
+	store.Set("counter", "0")
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				for {
+					v, _ := store.Get("counter")
+					n, _ := strconv.Atoi(v)
+					err := store.CAS("counter", v, strconv.Itoa(n+1))
+					if err == nil {
+						break
+					}
+					if !errors.Is(err, ErrCASMismatch) {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := store.Get("counter"); v != "800" {
+		t.Errorf("counter = %s, want 800", v)
+	}
+}
+
 func BenchmarkStoreGet(b *testing.B) {
 	store := NewStore("")
 	store.Set("key", "value")
@@ -274,6 +2051,60 @@ func BenchmarkStoreConcurrentWrites(b *testing.B) {
 	})
 }
 
+// benchmarkShards runs op in parallel over many keys, with every key behind
+// one lock and with the default shards, to show what sharding buys
+func benchmarkShards(b *testing.B, op func(store *Store, key string, n int)) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key:%d", i)
+	}
+	for _, shards := range []int{1, 0} {
+		name := fmt.Sprintf("shards=%d", shards)
+		if shards == 0 {
+			name = fmt.Sprintf("shards=%d", StoreOptions{}.withDefaults().Shards)
+		}
+		b.Run(name, func(b *testing.B) {
+			store := NewStoreWithOptions("", StoreOptions{Shards: shards})
+			for _, key := range keys {
+				store.Set(key, "value")
+			}
+			var worker atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				// Each goroutine starts at a different key
+				i := int(worker.Add(1)) * 7919
+				for pb.Next() {
+					op(store, keys[i%len(keys)], i)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkStoreParallelWrites(b *testing.B) {
+	benchmarkShards(b, func(store *Store, key string, n int) {
+		store.Set(key, "value")
+	})
+}
+
+func BenchmarkStoreParallelReads(b *testing.B) {
+	benchmarkShards(b, func(store *Store, key string, n int) {
+		store.Get(key)
+	})
+}
+
+func BenchmarkStoreParallelMixed(b *testing.B) {
+	benchmarkShards(b, func(store *Store, key string, n int) {
+		// One write for every three reads
+		if n%4 == 0 {
+			store.Set(key, "value")
+		} else {
+			store.Get(key)
+		}
+	})
+}
+
 func BenchmarkStoreKeys(b *testing.B) {
 	store := NewStore("")
 	for i := 0; i < 1000; i++ {
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrOutOfMemory is returned for a write while the store is over
+// MaxMemory and no key may be evicted
+var ErrOutOfMemory = errors.New("OOM command not allowed when used memory > 'maxmemory'")
+
+// EvictionPolicy chooses the keys evicted to bring a store with a
+// MaxMemory back under it
+type EvictionPolicy string
+
+const (
+	// NoEviction evicts nothing: writes fail with ErrOutOfMemory
+	// instead, while deletes still go through
+	NoEviction EvictionPolicy = "noeviction"
+	// AllKeysLRU evicts the least recently used keys
+	AllKeysLRU EvictionPolicy = "allkeys-lru"
+	// VolatileTTL evicts the keys with a TTL that expire soonest; keys
+	// without one are kept, and writes fail once none is left
+	VolatileTTL EvictionPolicy = "volatile-ttl"
+)
+
+// ParseEvictionPolicy parses a policy name: noeviction, allkeys-lru or
+// volatile-ttl
+func ParseEvictionPolicy(name string) (EvictionPolicy, error) {
+	switch p := EvictionPolicy(strings.ToLower(name)); p {
+	case NoEviction, AllKeysLRU, VolatileTTL:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown eviction policy %q: want noeviction, allkeys-lru or volatile-ttl", name)
+}
+
+// Estimated bytes of bookkeeping on top of the bytes of keys and values.
+// The estimate is what MaxMemory bounds; it is not the Go heap, which also
+// holds the version history, the backlog and garbage not yet collected.
+const (
+	keyOverhead   = 64 // per key: its entries in the shard's maps
+	itemOverhead  = 16 // per list item: its string header
+	fieldOverhead = 48 // per hash field: a map entry and two strings
+)
+
+// evictionSamples is how many keys of a shard are compared to pick one to
+// evict. Like Redis, the store approximates LRU and TTL order by sampling
+// instead of keeping every key sorted.
+const evictionSamples = 5
+
+// sizeLocked returns the estimated size of key, 0 if it is missing.
+// Caller holds sh.mu.
+func (sh *shard) sizeLocked(key string) int64 {
+	if v, ok := sh.data[key]; ok {
+		return stringSize(key, v)
+	}
+	if l, ok := sh.lists[key]; ok {
+		n := int64(keyOverhead + len(key))
+		for _, item := range l {
+			n += itemOverhead + int64(len(item))
+		}
+		return n
+	}
+	if h, ok := sh.hashes[key]; ok {
+		n := int64(keyOverhead + len(key))
+		for field, v := range h {
+			n += fieldSize(field, v)
+		}
+		return n
+	}
+	return 0
+}
+
+func stringSize(key, value string) int64 {
+	return int64(keyOverhead + len(key) + len(value))
+}
+
+func fieldSize(field, value string) int64 {
+	return int64(fieldOverhead + len(field) + len(value))
+}
+
+// growLocked adds delta bytes to the size of the shard for a write to key,
+// and marks key used now for LRU eviction. Writes keep the sizes up to
+// date this way, by what they add, rather than measuring whole lists and
+// hashes; deleteLocked subtracts what a key measures. Caller holds sh.mu
+// for writing.
+func (s *Store) growLocked(sh *shard, key string, delta int64) {
+	sh.used.Add(delta)
+	if sh.access == nil {
+		return
+	}
+	a := sh.access[key]
+	if a == nil {
+		a = new(atomic.Uint64)
+		sh.access[key] = a
+	}
+	a.Store(s.accessClock.Add(1))
+}
+
+// accessedLocked marks key used now, for a read. The stamp is atomic, so
+// readers holding sh.mu only for reading update it. Caller holds sh.mu.
+func (s *Store) accessedLocked(sh *shard, key string) {
+	if a := sh.access[key]; a != nil {
+		a.Store(s.accessClock.Add(1))
+	}
+}
+
+// MemoryUsed returns the estimated size of the keys and values in bytes.
+// Like Size, it reads the shards one after the other.
+func (s *Store) MemoryUsed() int64 {
+	var n int64
+	for _, sh := range s.shards {
+		n += sh.used.Load()
+	}
+	return n
+}
+
+// makeRoom evicts keys until the store is back within MaxMemory, before a
+// write. Deletes do not call it, as they only free memory. It fails with
+// ErrOutOfMemory if the policy evicts nothing, or runs out of keys it may
+// evict. The write may take the store over the limit again; the next one
+// evicts for it.
+//
+// A follower never evicts: like expired keys, the primary's evictions
+// reach it as deletes.
+func (s *Store) makeRoom() error {
+	if s.maxMemory <= 0 || s.ReadOnly() || s.MemoryUsed() <= s.maxMemory {
+		return nil
+	}
+	if s.evictionPolicy == NoEviction {
+		s.oomRejections.Add(1)
+		return ErrOutOfMemory
+	}
+	s.evictMu.Lock()
+	defer s.evictMu.Unlock()
+	for s.MemoryUsed() > s.maxMemory {
+		if !s.evictOne() {
+			s.oomRejections.Add(1)
+			return ErrOutOfMemory
+		}
+	}
+	return nil
+}
+
+// evictOne evicts the best candidate of the shards' samples, and reports
+// false if no shard has a key to evict. Caller holds s.evictMu.
+func (s *Store) evictOne() bool {
+	var best evictionCandidate
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		c, ok := s.evictionCandidateLocked(sh)
+		sh.mu.RUnlock()
+		if ok && (best.sh == nil || c.rank < best.rank) {
+			best = c
+		}
+	}
+	if best.sh == nil {
+		return false
+	}
+
+	// The key may have changed since it was sampled; if it is gone, the
+	// caller measures the store again all the same
+	sh, key := best.sh, best.key
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	switch {
+	case sh.typeLocked(key) == TypeNone:
+	case s.expiredLocked(sh, key):
+		s.removeExpiredLocked(sh, key)
+	default:
+		sh.deleteLocked(key)
+		s.record(sh, Command{Op: opDel, Key: key, Evicted: true})
+		s.evictedKeys.Add(1)
+	}
+	return true
+}
+
+// evictionCandidate is the key of a shard to evict first; the candidate
+// of lowest rank across the shards is evicted
+type evictionCandidate struct {
+	sh   *shard
+	key  string
+	rank int64
+}
+
+// evictionCandidateLocked picks the key of sh to evict under the store's
+// policy: the least recently used of a sample, ranked by when it was used,
+// or the one expiring soonest, ranked by its expiry. Caller holds sh.mu.
+func (s *Store) evictionCandidateLocked(sh *shard) (c evictionCandidate, ok bool) {
+	sampled := 0
+	consider := func(key string, rank int64) bool {
+		if !ok || rank < c.rank {
+			c, ok = evictionCandidate{sh: sh, key: key, rank: rank}, true
+		}
+		sampled++
+		return sampled < evictionSamples
+	}
+	switch s.evictionPolicy {
+	case AllKeysLRU:
+		for k, a := range sh.access {
+			if !consider(k, int64(a.Load())) {
+				break
+			}
+		}
+	case VolatileTTL:
+		for k, at := range sh.expires {
+			if !consider(k, at.UnixNano()) {
+				break
+			}
+		}
+	}
+	return c, ok
+}
+
+// Info is a summary of the store and its counters, as INFO prints it
+type Info struct {
+	Keys           int
+	MemoryUsed     int64
+	MaxMemory      int64 // 0 for no limit
+	EvictionPolicy EvictionPolicy
+	// ExpiredKeys counts the keys removed as their TTL ran out,
+	// EvictedKeys the keys evicted to stay within MaxMemory, and
+	// OOMRejections the writes refused because nothing could be
+	ExpiredKeys   uint64
+	EvictedKeys   uint64
+	OOMRejections uint64
+}
+
+// Info returns the store's size, memory use and counters
+func (s *Store) Info() Info {
+	return Info{
+		Keys:           s.Size(),
+		MemoryUsed:     s.MemoryUsed(),
+		MaxMemory:      s.maxMemory,
+		EvictionPolicy: s.evictionPolicy,
+		ExpiredKeys:    s.expiredKeys.Load(),
+		EvictedKeys:    s.evictedKeys.Load(),
+		OOMRejections:  s.oomRejections.Load(),
+	}
+}
+
+// formatInfo renders info as INFO prints it, one name:value line each
+func formatInfo(info Info) string {
+	var b strings.Builder
+	b.WriteString("# Memory\n")
+	fmt.Fprintf(&b, "used_memory:%d\n", info.MemoryUsed)
+	fmt.Fprintf(&b, "used_memory_human:%s\n", formatMemorySize(info.MemoryUsed))
+	fmt.Fprintf(&b, "maxmemory:%d\n", info.MaxMemory)
+	fmt.Fprintf(&b, "maxmemory_policy:%s\n", info.EvictionPolicy)
+	b.WriteString("\n# Stats\n")
+	fmt.Fprintf(&b, "expired_keys:%d\n", info.ExpiredKeys)
+	fmt.Fprintf(&b, "evicted_keys:%d\n", info.EvictedKeys)
+	fmt.Fprintf(&b, "oom_rejections:%d\n", info.OOMRejections)
+	b.WriteString("\n# Keyspace\n")
+	fmt.Fprintf(&b, "keys:%d\n", info.Keys)
+	return b.String()
+}
+
+// Memory size units of parseMemorySize and formatMemorySize
+var memoryUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"gb", 1 << 30},
+	{"mb", 1 << 20},
+	{"kb", 1 << 10},
+	{"b", 1},
+}
+
+// parseMemorySize parses a size in bytes, such as 100mb, 512kb or 1gb. A
+// number without a unit is bytes.
+func parseMemorySize(text string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(text))
+	unit := int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower, unit = strings.TrimSuffix(lower, u.suffix), u.bytes
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 || n > (1<<63-1)/unit {
+		return 0, fmt.Errorf("invalid memory size %q", text)
+	}
+	return n * unit, nil
+}
+
+// formatMemorySize renders n bytes in the largest unit it reaches
+func formatMemorySize(n int64) string {
+	for _, u := range memoryUnits[:len(memoryUnits)-1] {
+		if n >= u.bytes {
+			return strconv.FormatFloat(float64(n)/float64(u.bytes), 'f', 2, 64) + strings.ToUpper(u.suffix[:1])
+		}
+	}
+	return strconv.FormatInt(n, 10) + "B"
+}
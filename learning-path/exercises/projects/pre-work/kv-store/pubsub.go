@@ -0,0 +1,235 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrSlowSubscriber is the error of a subscription closed because its
+// buffer filled up: events were dropped, so the subscriber must assume
+// any key may have changed
+var ErrSlowSubscriber = errors.New("subscriber fell behind and missed events")
+
+// subscriptionBuffer is how many events a subscription holds for a
+// subscriber that has not received them yet
+const subscriptionBuffer = 1024
+
+// Kinds of Event
+const (
+	EventSet    = "set"     // SET, INCR and DECR; Value is the new value
+	EventLPush  = "lpush"   // Key is a list
+	EventHSet   = "hset"    // Field and Value are the field set
+	EventDelete = "del"     // DEL, or SET EX with an expiry in the past
+	EventExpire = "expired" // the key's TTL ran out and it was removed
+	EventEvict  = "evicted" // the key was evicted to stay within MaxMemory
+	// EventClear has no key and goes to every subscription: the whole
+	// store was cleared or replaced, by CLEAR, a load or a full sync
+	EventClear = "clear"
+)
+
+// Event is a change to a key
+type Event struct {
+	Kind  string `json:"kind"`
+	Key   string `json:"key,omitempty"`
+	Field string `json:"field,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// Subscription receives the events of the keys matching a pattern on C.
+// Events of one key arrive in the order the key changed. C is closed by
+// Close, or when the subscriber falls more than subscriptionBuffer events
+// behind; Err then returns ErrSlowSubscriber.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	pattern string
+	cancel  func()     // stops the events at their source
+	mu      sync.Mutex // guards closed, err and sends on c
+	closed  bool
+	err     error
+}
+
+// pubsub is the set of subscriptions of a store. It has its own lock, as
+// events are published under the shard lock of the key that changed.
+type pubsub struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+	// n counts subscriptions, so writes skip the lock while there are none
+	n atomic.Int32
+}
+
+// Subscribe returns a subscription to the changes of keys matching pattern,
+// a glob as for KEYS. Every write is delivered: sets, deletes, expiries and
+// the typed writes, on a follower the replicated ones too. Call Close to
+// stop, even after C was closed.
+func (s *Store) Subscribe(pattern string) (*Subscription, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("subscribe %q: %w", pattern, err)
+	}
+	ps := &s.pubsub
+	sub := newSubscription(pattern)
+	sub.cancel = func() {
+		ps.mu.Lock()
+		defer ps.mu.Unlock()
+		if _, ok := ps.subs[sub]; ok {
+			delete(ps.subs, sub)
+			ps.n.Add(-1)
+		}
+	}
+	ps.mu.Lock()
+	if ps.subs == nil {
+		ps.subs = make(map[*Subscription]struct{})
+	}
+	ps.subs[sub] = struct{}{}
+	ps.n.Add(1)
+	ps.mu.Unlock()
+	return sub, nil
+}
+
+func newSubscription(pattern string) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	return &Subscription{C: c, c: c, pattern: pattern}
+}
+
+// Pattern returns the pattern the subscription matches keys with
+func (sub *Subscription) Pattern() string {
+	return sub.pattern
+}
+
+// Err returns ErrSlowSubscriber if the subscription was closed because
+// it fell behind, and nil otherwise
+func (sub *Subscription) Err() error {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	return sub.err
+}
+
+// Close ends the subscription and closes C
+func (sub *Subscription) Close() {
+	sub.cancel()
+	sub.close(nil)
+}
+
+func (sub *Subscription) close(err error) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if !sub.closed {
+		sub.closed = true
+		sub.err = err
+		close(sub.c)
+	}
+}
+
+// send queues e without blocking the writer; a subscription with a full
+// buffer is closed instead. It reports whether the subscription is open.
+func (sub *Subscription) send(e Event) bool {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed {
+		return false
+	}
+	select {
+	case sub.c <- e:
+		return true
+	default:
+		sub.closed = true
+		sub.err = ErrSlowSubscriber
+		close(sub.c)
+		return false
+	}
+}
+
+// publish delivers e to the subscriptions it matches
+func (ps *pubsub) publish(e Event) {
+	if ps.n.Load() == 0 {
+		return
+	}
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	for sub := range ps.subs {
+		if e.Kind == EventClear {
+			sub.send(e)
+		} else if matched, _ := filepath.Match(sub.pattern, e.Key); matched {
+			sub.send(e)
+		}
+	}
+}
+
+// eventOf returns the event of a recorded mutation
+func eventOf(cmd Command) Event {
+	switch cmd.Op {
+	case opSet:
+		return Event{Kind: EventSet, Key: cmd.Key, Value: cmd.Value}
+	case opLPush:
+		return Event{Kind: EventLPush, Key: cmd.Key}
+	case opHSet:
+		return Event{Kind: EventHSet, Key: cmd.Key, Field: cmd.Field, Value: cmd.Value}
+	case opDel:
+		switch {
+		case cmd.Expired:
+			return Event{Kind: EventExpire, Key: cmd.Key}
+		case cmd.Evicted:
+			return Event{Kind: EventEvict, Key: cmd.Key}
+		}
+		return Event{Kind: EventDelete, Key: cmd.Key}
+	default:
+		return Event{Kind: EventClear}
+	}
+}
+
+// replPubSub is the subscriptions of a REPL session, whose events are
+// printed as they arrive
+type replPubSub map[string]*Subscription
+
+// subscribe starts printing the events of keys matching pattern
+func (r replPubSub) subscribe(store *Store, pattern string) {
+	if _, ok := r[pattern]; ok {
+		fmt.Printf("Already subscribed to %s\n", pattern)
+		return
+	}
+	sub, err := store.Subscribe(pattern)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	r[pattern] = sub
+	fmt.Printf("Subscribed to %s\n", pattern)
+	go func() {
+		for e := range sub.C {
+			fmt.Printf("\n[%s] %s\n> ", pattern, formatEvent(e))
+		}
+		if err := sub.Err(); err != nil {
+			fmt.Printf("\n[%s] %v; unsubscribed\n> ", pattern, err)
+		}
+	}()
+}
+
+// unsubscribe stops the subscription to pattern, or every subscription if
+// pattern is empty
+func (r replPubSub) unsubscribe(pattern string) {
+	for p, sub := range r {
+		if pattern == "" || p == pattern {
+			sub.Close()
+			delete(r, p)
+		}
+	}
+	fmt.Println("OK")
+}
+
+// formatEvent renders an event as the REPL prints it
+func formatEvent(e Event) string {
+	switch e.Kind {
+	case EventSet:
+		return fmt.Sprintf("%s %s %s", e.Kind, e.Key, e.Value)
+	case EventHSet:
+		return fmt.Sprintf("%s %s %s %s", e.Kind, e.Key, e.Field, e.Value)
+	case EventClear:
+		return e.Kind
+	default:
+		return fmt.Sprintf("%s %s", e.Kind, e.Key)
+	}
+}
@@ -0,0 +1,513 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrReadOnly is returned for writes against a follower
+var ErrReadOnly = errors.New("READONLY: cannot write to a replica")
+
+// defaultBacklogSize is how many commands a primary keeps for partial resync
+const defaultBacklogSize = 10000
+
+// Command is one replicated store mutation
+type Command struct {
+	Op     string `json:"op"`
+	Key    string `json:"key,omitempty"`
+	Value  string `json:"value,omitempty"`
+	Offset uint64 `json:"offset"`
+	// ExpiresAt is the expiry a set gives its key; zero for none
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	// Field is the field of an hset; Values are the values of an lpush
+	Field  string   `json:"field,omitempty"`
+	Values []string `json:"values,omitempty"`
+	// Expired marks the delete of a key whose TTL ran out, and Evicted
+	// one evicted to stay within MaxMemory
+	Expired bool `json:"expired,omitempty"`
+	Evicted bool `json:"evicted,omitempty"`
+}
+
+// Replication protocol message types
+const (
+	opSet      = "set"
+	opDel      = "del"
+	opClear    = "clear"
+	opLPush    = "lpush"
+	opHSet     = "hset"
+	opSync     = "sync"
+	opFullSync = "fullsync"
+	opContinue = "continue"
+)
+
+// syncMessage carries the full data set for an initial sync
+type syncMessage struct {
+	Op     string                       `json:"op"`
+	ID     string                       `json:"id,omitempty"`
+	Offset uint64                       `json:"offset"`
+	Data   map[string]string            `json:"data,omitempty"`
+	Lists  map[string][]string          `json:"lists,omitempty"`
+	Hashes map[string]map[string]string `json:"hashes,omitempty"`
+	// Expires holds the expiry of each key with a TTL
+	Expires map[string]time.Time `json:"expires,omitempty"`
+}
+
+// Backlog is a bounded, ordered log of recent commands. Offsets start at 1
+// and increase by one per command. The ID identifies this offset sequence,
+// so a follower of a restarted primary knows its offset is meaningless.
+type Backlog struct {
+	id       string
+	commands []Command
+	capacity int
+	offset   uint64
+	notify   chan struct{}
+	mu       sync.Mutex
+}
+
+// NewBacklog creates a backlog retaining the last capacity commands
+func NewBacklog(capacity int) *Backlog {
+	return &Backlog{
+		id:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		capacity: capacity,
+		notify:   make(chan struct{}),
+	}
+}
+
+// Append assigns the next offset to cmd and wakes waiting readers
+func (b *Backlog) Append(cmd Command) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.offset++
+	cmd.Offset = b.offset
+	b.commands = append(b.commands, cmd)
+	if len(b.commands) > b.capacity {
+		b.commands = b.commands[len(b.commands)-b.capacity:]
+	}
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// Offset returns the offset of the newest command
+func (b *Backlog) Offset() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.offset
+}
+
+// Since returns the commands after offset and a channel closed on the next
+// append. ok is false if commands after offset were already discarded.
+func (b *Backlog) Since(offset uint64) (cmds []Command, wait <-chan struct{}, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if offset > b.offset {
+		return nil, b.notify, false
+	}
+	oldest := b.offset - uint64(len(b.commands))
+	if offset < oldest {
+		return nil, b.notify, false
+	}
+	start := int(offset - oldest)
+	return append([]Command(nil), b.commands[start:]...), b.notify, true
+}
+
+// record appends a mutation to the version history, the append-only file
+// and the replication backlog, marks its key for the next incremental
+// save, aborts transactions watching the key and notifies subscribers.
+// Caller holds sh.mu, the key's shard, or every shard for a clear, so the
+// log, backlog and event order of each key's mutations is the order they
+// were applied in. Mutations of keys in different shards commute, so their
+// order does not matter.
+func (s *Store) record(sh *shard, cmd Command) {
+	if cmd.Op == opClear {
+		s.needFull.Store(true)
+	} else {
+		sh.touchLocked(cmd.Key)
+		sh.markDirtyLocked(cmd.Key)
+	}
+	s.remember(sh, cmd)
+	if s.aof != nil {
+		s.aof.append(cmd)
+	}
+	if s.backlog != nil {
+		s.backlog.Append(cmd)
+	}
+	s.pubsub.publish(eventOf(cmd))
+}
+
+// apply executes a replicated command against the store
+func (s *Store) apply(cmd Command) {
+	if cmd.Op == opClear {
+		s.lockAll()
+		defer s.unlockAll()
+		s.applyLocked(nil, cmd)
+		s.record(nil, cmd)
+		return
+	}
+	sh := s.shardFor(cmd.Key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	s.applyLocked(sh, cmd)
+	s.record(sh, cmd)
+}
+
+// applyLocked executes cmd against the data without recording it. Caller
+// holds sh.mu, the key's shard; a clear ignores sh and needs every shard
+// held.
+func (s *Store) applyLocked(sh *shard, cmd Command) {
+	switch cmd.Op {
+	case opSet:
+		sh.deleteLocked(cmd.Key)
+		sh.data[cmd.Key] = cmd.Value
+		sh.indexLocked(cmd.Key)
+		s.growLocked(sh, cmd.Key, stringSize(cmd.Key, cmd.Value))
+		if !cmd.ExpiresAt.IsZero() {
+			sh.expires[cmd.Key] = cmd.ExpiresAt
+		}
+	case opLPush:
+		// Each value goes to the head in turn, so they end up reversed
+		old, existed := sh.lists[cmd.Key]
+		list := make([]string, 0, len(cmd.Values)+len(old))
+		var grown int64
+		if !existed {
+			grown = keyOverhead + int64(len(cmd.Key))
+		}
+		for i := len(cmd.Values) - 1; i >= 0; i-- {
+			list = append(list, cmd.Values[i])
+			grown += itemOverhead + int64(len(cmd.Values[i]))
+		}
+		sh.lists[cmd.Key] = append(list, old...)
+		sh.indexLocked(cmd.Key)
+		s.growLocked(sh, cmd.Key, grown)
+	case opHSet:
+		h, ok := sh.hashes[cmd.Key]
+		var grown int64
+		if !ok {
+			h = make(map[string]string)
+			sh.hashes[cmd.Key] = h
+			sh.indexLocked(cmd.Key)
+			grown = keyOverhead + int64(len(cmd.Key))
+		}
+		if old, ok := h[cmd.Field]; ok {
+			grown -= fieldSize(cmd.Field, old)
+		}
+		h[cmd.Field] = cmd.Value
+		s.growLocked(sh, cmd.Key, grown+fieldSize(cmd.Field, cmd.Value))
+	case opDel:
+		sh.deleteLocked(cmd.Key)
+	case opClear:
+		s.resetLocked()
+	}
+}
+
+// ReadOnly reports whether the store is a follower
+func (s *Store) ReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// Primary accepts follower connections and streams store mutations to them
+type Primary struct {
+	store    *Store
+	listener net.Listener
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+// StartPrimary enables replication on store and listens for followers on addr
+func StartPrimary(store *Store, addr string) (*Primary, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	store.lockAll()
+	if store.backlog == nil {
+		store.backlog = NewBacklog(defaultBacklogSize)
+	}
+	store.unlockAll()
+
+	p := &Primary{
+		store:    store,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	p.wg.Add(1)
+	go p.acceptLoop()
+	return p, nil
+}
+
+// Addr returns the address followers should connect to
+func (p *Primary) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Close stops accepting followers and disconnects existing ones
+func (p *Primary) Close() error {
+	close(p.done)
+	err := p.listener.Close()
+	p.wg.Wait()
+	return err
+}
+
+func (p *Primary) acceptLoop() {
+	defer p.wg.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			defer conn.Close()
+			p.serveFollower(conn)
+		}()
+	}
+}
+
+// serveFollower performs the sync handshake and then streams commands until
+// the connection fails or the primary is closed
+func (p *Primary) serveFollower(conn net.Conn) {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-p.done:
+		case <-finished:
+		}
+		conn.Close()
+	}()
+
+	var req syncMessage
+	if err := json.NewDecoder(conn).Decode(&req); err != nil || req.Op != opSync {
+		return
+	}
+
+	w := bufio.NewWriter(conn)
+	enc := json.NewEncoder(w)
+	backlog := p.store.backlog
+
+	// Partial resync is only possible when the follower already holds a
+	// prefix of this backlog's history
+	offset := req.Offset
+	if _, _, ok := backlog.Since(offset); ok && req.ID == backlog.id && offset > 0 {
+		if err := enc.Encode(syncMessage{Op: opContinue, ID: backlog.id, Offset: offset}); err != nil {
+			return
+		}
+	} else {
+		full, err := p.fullSync(enc)
+		if err != nil {
+			return
+		}
+		offset = full
+	}
+
+	for {
+		cmds, wait, ok := backlog.Since(offset)
+		if !ok {
+			// Follower fell out of the backlog window; it will reconnect
+			// and receive a full sync
+			return
+		}
+		for _, cmd := range cmds {
+			if err := enc.Encode(cmd); err != nil {
+				return
+			}
+			offset = cmd.Offset
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-wait:
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// fullSync sends a consistent copy of the data set and returns its offset
+func (p *Primary) fullSync(enc *json.Encoder) (uint64, error) {
+	p.store.rlockAll()
+	data := make(map[string]string)
+	expires := make(map[string]time.Time)
+	for _, sh := range p.store.shards {
+		maps.Copy(data, sh.data)
+		maps.Copy(expires, sh.expires)
+	}
+	lists, hashes := p.store.copyTyped(make(map[string]time.Time))
+	offset := p.store.backlog.Offset()
+	p.store.runlockAll()
+
+	return offset, enc.Encode(syncMessage{
+		Op:      opFullSync,
+		ID:      p.store.backlog.id,
+		Offset:  offset,
+		Data:    data,
+		Lists:   lists,
+		Hashes:  hashes,
+		Expires: expires,
+	})
+}
+
+// Follower replicates a primary into a local read-only store
+type Follower struct {
+	store   *Store
+	addr    string
+	id      string
+	offset  uint64
+	synced  chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+	conn    net.Conn
+	backoff time.Duration
+	mu      sync.Mutex
+}
+
+// StartFollower makes store a read-only replica of the primary at addr.
+// Lost connections are re-established and resume from the last applied
+// offset, falling back to a full sync when the primary's backlog no longer
+// covers it.
+func StartFollower(store *Store, addr string) *Follower {
+	store.readOnly.Store(true)
+	f := &Follower{
+		store:   store,
+		addr:    addr,
+		synced:  make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		backoff: 100 * time.Millisecond,
+	}
+	go f.run()
+	return f
+}
+
+// Offset returns the offset of the last applied command
+func (f *Follower) Offset() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.offset
+}
+
+// Synced is closed once the first sync with the primary has completed
+func (f *Follower) Synced() <-chan struct{} {
+	return f.synced
+}
+
+// Stop disconnects from the primary. The store stays read-only.
+func (f *Follower) Stop() {
+	close(f.stop)
+	f.mu.Lock()
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.mu.Unlock()
+	<-f.done
+}
+
+func (f *Follower) run() {
+	defer close(f.done)
+	var once sync.Once
+	for {
+		err := f.session(func() { once.Do(func() { close(f.synced) }) })
+		select {
+		case <-f.stop:
+			return
+		default:
+		}
+		if err != nil {
+			select {
+			case <-time.After(f.backoff):
+			case <-f.stop:
+				return
+			}
+		}
+	}
+}
+
+// session runs one connection to the primary until it fails
+func (f *Follower) session(onSynced func()) error {
+	conn, err := net.DialTimeout("tcp", f.addr, time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	select {
+	case <-f.stop:
+		f.mu.Unlock()
+		return nil
+	default:
+	}
+	f.conn = conn
+	id, offset := f.id, f.offset
+	f.mu.Unlock()
+
+	if err := json.NewEncoder(conn).Encode(syncMessage{Op: opSync, ID: id, Offset: offset}); err != nil {
+		return err
+	}
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	first := true
+	for {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := f.handle(raw); err != nil {
+			return err
+		}
+		if first {
+			first = false
+			onSynced()
+		}
+	}
+}
+
+// handle applies one message from the primary
+func (f *Follower) handle(raw json.RawMessage) error {
+	var cmd Command
+	if err := json.Unmarshal(raw, &cmd); err != nil {
+		return err
+	}
+
+	switch cmd.Op {
+	case opContinue:
+		return nil
+	case opFullSync:
+		var msg syncMessage
+		if err := json.Unmarshal(raw, &msg); err != nil {
+			return err
+		}
+		f.store.lockAll()
+		f.store.fillLocked(msg.Data, msg.Lists, msg.Hashes, msg.Expires)
+		f.store.unlockAll()
+		// The replaced data set was not logged as writes
+		if err := f.store.RewriteAOF(); err != nil && !errors.Is(err, ErrNoAOF) {
+			return err
+		}
+		f.mu.Lock()
+		f.id = msg.ID
+		f.mu.Unlock()
+		cmd.Offset = msg.Offset
+	case opSet, opDel, opClear, opLPush, opHSet:
+		f.store.apply(cmd)
+	default:
+		return fmt.Errorf("replication: unknown op %q", cmd.Op)
+	}
+
+	f.mu.Lock()
+	f.offset = cmd.Offset
+	f.mu.Unlock()
+	return nil
+}
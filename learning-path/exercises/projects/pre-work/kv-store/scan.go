@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrNoIndex is returned by Scan and Range on a store created without
+// StoreOptions.OrderedIndex
+var ErrNoIndex = errors.New("ordered index not enabled (start with -ordered-index)")
+
+// defaultScanCount is the page size of a scan that does not give one
+const defaultScanCount = 10
+
+// Scan returns the keys starting with prefix in lexical order, a page of
+// at most count of them (default 10) after cursor. An empty cursor starts
+// at the first key. next is the cursor of the following page, or empty
+// after the last one.
+//
+// Pages read the shards one after the other, like Keys. A key that exists
+// for the whole scan is returned exactly once; a key written or deleted
+// during the scan may or may not be.
+func (s *Store) Scan(prefix, cursor string, count int) (keys []string, next string, err error) {
+	return s.Range(prefix, prefixEnd(prefix), cursor, count)
+}
+
+// Range returns the keys from start up to but excluding end in lexical
+// order, a page at a time like Scan. An empty end means no upper bound.
+func (s *Store) Range(start, end, cursor string, count int) (keys []string, next string, err error) {
+	if s.shards[0].index == nil {
+		return nil, "", ErrNoIndex
+	}
+	if count <= 0 {
+		count = defaultScanCount
+	}
+	from := max(start, cursor)
+
+	// Each shard gives up to count+1 keys, so there is more to come if
+	// more than count are found in all
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+		found := 0
+		for n := sh.index.seek(from); n != nil && found <= count; n = n.next[0] {
+			if end != "" && n.key >= end {
+				break
+			}
+			if (cursor != "" && n.key == cursor) || s.expiredLocked(sh, n.key) {
+				continue
+			}
+			keys = append(keys, n.key)
+			found++
+		}
+		sh.mu.RUnlock()
+	}
+	slices.Sort(keys)
+	if len(keys) > count {
+		keys = keys[:count]
+		next = keys[count-1]
+	}
+	return keys, next, nil
+}
+
+// prefixEnd returns the first key after every key starting with prefix,
+// or "" if there is none
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
+
+// indexLocked adds key to the shard's ordered index, if it has one.
+// Caller holds sh.mu for writing.
+func (sh *shard) indexLocked(key string) {
+	if sh.index != nil {
+		sh.index.insert(key)
+	}
+}
+
+// parseScanArgs parses the options after the range of SCAN and RANGE:
+// AFTER <cursor> and COUNT <n>, in any order
+func parseScanArgs(args []string) (cursor string, count int, err error) {
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return "", 0, fmt.Errorf("%s needs a value", args[0])
+		}
+		switch strings.ToUpper(args[0]) {
+		case "AFTER":
+			cursor = args[1]
+		case "COUNT":
+			count, err = strconv.Atoi(args[1])
+			if err != nil || count <= 0 {
+				return "", 0, fmt.Errorf("invalid count %q", args[1])
+			}
+		default:
+			return "", 0, fmt.Errorf("unknown option %q", args[0])
+		}
+		args = args[2:]
+	}
+	return cursor, count, nil
+}
+
+// formatScan renders a page of keys as the REPL prints it, with the
+// option that fetches the next page
+func formatScan(keys []string, next string) string {
+	out := formatList(keys)
+	if next != "" {
+		out += fmt.Sprintf("(more: AFTER %s)\n", next)
+	}
+	return out
+}
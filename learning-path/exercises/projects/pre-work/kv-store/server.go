@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+)
+
+// batchRequest is one client request: a batch executed atomically, a
+// listing of the keys matching a pattern, or a subscription, after which
+// the connection only carries events
+type batchRequest struct {
+	Ops       []BatchOp `json:"ops"`
+	Keys      string    `json:"keys,omitempty"`
+	Subscribe string    `json:"subscribe,omitempty"`
+}
+
+// batchResponse answers a batchRequest. Code names the sentinel error so
+// the client can return it again.
+type batchResponse struct {
+	Results []BatchResult `json:"results,omitempty"`
+	Keys    []string      `json:"keys,omitempty"`
+	Error   string        `json:"error,omitempty"`
+	Code    string        `json:"code,omitempty"`
+}
+
+// eventMessage is a line of a subscribed connection: an empty one to
+// acknowledge the subscription, then an event each, and an error last if
+// the server ends the subscription
+type eventMessage struct {
+	Event *Event `json:"event,omitempty"`
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+// Error codes of batchResponse and eventMessage
+const (
+	codeAborted  = "aborted"
+	codeReadOnly = "readonly"
+	codeInvalid  = "invalid"
+	codeSlow     = "slow"
+	codeOOM      = "oom"
+	codeType     = "wrongtype"
+)
+
+// Server serves the store to clients over TCP. The protocol is JSON lines:
+// each request is a batch, executed atomically, and answered in order, so a
+// client performs any number of operations in one round trip.
+type Server struct {
+	store    *Store
+	listener net.Listener
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+// StartServer serves store to clients on addr
+func StartServer(store *Store, addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		store:    store,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// Addr returns the address clients should connect to
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops accepting clients and disconnects existing ones
+func (s *Server) Close() error {
+	close(s.done)
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.serveClient(conn)
+		}()
+	}
+}
+
+// serveClient answers requests until the client disconnects or the server
+// is closed
+func (s *Server) serveClient(conn net.Conn) {
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-s.done:
+		case <-finished:
+		}
+		conn.Close()
+	}()
+
+	dec := json.NewDecoder(bufio.NewReader(conn))
+	w := bufio.NewWriter(conn)
+	enc := json.NewEncoder(w)
+	for {
+		var req batchRequest
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		if req.Subscribe != "" {
+			if s.streamEvents(dec, enc, w, req.Subscribe) {
+				return
+			}
+			continue
+		}
+		var resp batchResponse
+		if req.Keys != "" {
+			resp.Keys = s.store.Keys(req.Keys)
+			if err := enc.Encode(resp); err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+			continue
+		}
+		results, err := s.store.Batch(req.Ops)
+		if err != nil {
+			resp.Error = err.Error()
+			switch {
+			case errors.Is(err, ErrBatchAborted):
+				resp.Code = codeAborted
+			case errors.Is(err, ErrReadOnly):
+				resp.Code = codeReadOnly
+			case errors.Is(err, ErrInvalidBatch):
+				resp.Code = codeInvalid
+			case errors.Is(err, ErrOutOfMemory):
+				resp.Code = codeOOM
+			case errors.Is(err, ErrWrongType):
+				resp.Code = codeType
+			}
+		} else {
+			resp.Results = results
+		}
+		if err := enc.Encode(resp); err != nil {
+			return
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// streamEvents sends the events of keys matching pattern until the client
+// disconnects, the server is closed or the client falls behind. It reports
+// whether the connection is done; after a rejected pattern it serves
+// requests on.
+func (s *Server) streamEvents(dec *json.Decoder, enc *json.Encoder, w *bufio.Writer, pattern string) bool {
+	reply := func(msg eventMessage) error {
+		if err := enc.Encode(msg); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+	sub, err := s.store.Subscribe(pattern)
+	if err != nil {
+		return reply(eventMessage{Error: err.Error(), Code: codeInvalid}) != nil
+	}
+	defer sub.Close()
+	if reply(eventMessage{}) != nil {
+		return true
+	}
+	// The client sends nothing more, so reading only ends when it
+	// disconnects or serveClient closes the connection
+	go func() {
+		var discard json.RawMessage
+		for dec.Decode(&discard) == nil {
+		}
+		sub.Close()
+	}()
+
+	for e := range sub.C {
+		if err := enc.Encode(eventMessage{Event: &e}); err != nil {
+			return true
+		}
+		// Flush once the queued events are written, so bursts share writes
+		if len(sub.C) == 0 && w.Flush() != nil {
+			return true
+		}
+	}
+	if err := sub.Err(); err != nil {
+		reply(eventMessage{Error: err.Error(), Code: codeSlow})
+	}
+	return true
+}
+
+// Client is a connection to a Server. It is not safe for concurrent use.
+type Client struct {
+	conn net.Conn
+	enc  *json.Encoder
+	dec  *json.Decoder
+}
+
+// Dial connects to the server at addr
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(bufio.NewReader(conn)),
+	}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Batch executes ops atomically on the server in one round trip. Errors
+// the server reports as ErrBatchAborted, ErrReadOnly or ErrInvalidBatch
+// match them with errors.Is.
+func (c *Client) Batch(ops []BatchOp) ([]BatchResult, error) {
+	if err := c.enc.Encode(batchRequest{Ops: ops}); err != nil {
+		return nil, err
+	}
+	var resp batchResponse
+	if err := c.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, &remoteError{msg: resp.Error, code: resp.Code}
+	}
+	return resp.Results, nil
+}
+
+// Get retrieves a value by key
+func (c *Client) Get(key string) (string, bool, error) {
+	results, err := c.Batch([]BatchOp{{Op: batchGet, Key: key}})
+	if err != nil {
+		return "", false, err
+	}
+	return results[0].Value, results[0].Found, nil
+}
+
+// Set stores a key-value pair
+func (c *Client) Set(key, value string) error {
+	_, err := c.Batch([]BatchOp{{Op: batchSet, Key: key, Value: value}})
+	return err
+}
+
+// Delete removes a key and reports whether it existed
+func (c *Client) Delete(key string) (bool, error) {
+	results, err := c.Batch([]BatchOp{{Op: batchDel, Key: key}})
+	if err != nil {
+		return false, err
+	}
+	return results[0].Found, nil
+}
+
+// Keys returns the keys on the server matching pattern, as Store.Keys
+func (c *Client) Keys(pattern string) ([]string, error) {
+	if err := c.enc.Encode(batchRequest{Keys: pattern}); err != nil {
+		return nil, err
+	}
+	var resp batchResponse
+	if err := c.dec.Decode(&resp); err != nil {
+		return nil, err
+	}
+	if resp.Error != "" {
+		return nil, &remoteError{msg: resp.Error, code: resp.Code}
+	}
+	return resp.Keys, nil
+}
+
+// Subscribe turns the connection into a subscription to the changes of
+// keys matching pattern, as Store.Subscribe. The client can send nothing
+// else afterwards; closing the subscription closes the connection. If the
+// server ends the subscription because the client fell behind, Err returns
+// ErrSlowSubscriber.
+func (c *Client) Subscribe(pattern string) (*Subscription, error) {
+	if err := c.enc.Encode(batchRequest{Subscribe: pattern}); err != nil {
+		return nil, err
+	}
+	var ack eventMessage
+	if err := c.dec.Decode(&ack); err != nil {
+		return nil, err
+	}
+	if ack.Error != "" {
+		return nil, &remoteError{msg: ack.Error, code: ack.Code}
+	}
+
+	sub := newSubscription(pattern)
+	sub.cancel = func() { c.conn.Close() }
+	go func() {
+		defer c.conn.Close()
+		for {
+			var msg eventMessage
+			if err := c.dec.Decode(&msg); err != nil {
+				sub.close(nil)
+				return
+			}
+			switch {
+			case msg.Code == codeSlow:
+				sub.close(ErrSlowSubscriber)
+				return
+			case msg.Event != nil:
+				if !sub.send(*msg.Event) {
+					return
+				}
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// remoteError is an error reported by the server
+type remoteError struct {
+	msg  string
+	code string
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+// Is matches the sentinel error the server reported
+func (e *remoteError) Is(target error) bool {
+	switch e.code {
+	case codeAborted:
+		return target == ErrBatchAborted
+	case codeReadOnly:
+		return target == ErrReadOnly
+	case codeInvalid:
+		return target == ErrInvalidBatch
+	case codeOOM:
+		return target == ErrOutOfMemory
+	case codeType:
+		return target == ErrWrongType
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"hash/maphash"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// StoreOptions configures a Store
+type StoreOptions struct {
+	// Shards is how many independently locked parts the keys are spread
+	// over (default GOMAXPROCS*4). 1 puts every key behind one lock.
+	Shards int
+	// OrderedIndex keeps the keys of each shard sorted, for Scan and
+	// Range. It makes every write that adds or removes a key O(log n).
+	OrderedIndex bool
+	// SnapshotFormat is the encoding Snapshot writes (default JSON), and
+	// SnapshotGzip compresses it. Load detects both.
+	SnapshotFormat SnapshotFormat
+	SnapshotGzip   bool
+	// MaxDeltas is how many incremental saves SnapshotIncremental makes
+	// before it merges them into the snapshot (default 16)
+	MaxDeltas int
+	// MaxMemory bounds the estimated size of the keys and values in bytes
+	// (0 = no limit). Writes beyond it evict keys under EvictionPolicy
+	// (default NoEviction).
+	MaxMemory      int64
+	EvictionPolicy EvictionPolicy
+}
+
+func (o StoreOptions) withDefaults() StoreOptions {
+	if o.Shards <= 0 {
+		o.Shards = runtime.GOMAXPROCS(0) * 4
+	}
+	if o.SnapshotFormat == "" {
+		o.SnapshotFormat = SnapshotJSON
+	}
+	if o.MaxDeltas <= 0 {
+		o.MaxDeltas = 16
+	}
+	if o.EvictionPolicy == "" {
+		o.EvictionPolicy = NoEviction
+	}
+	return o
+}
+
+// shard holds the keys that hash to it, and everything kept per key, under
+// its own lock. Commands on one key lock only its shard, so commands on
+// keys in different shards run in parallel.
+type shard struct {
+	mu      sync.RWMutex
+	data    map[string]string            // string values
+	lists   map[string][]string          // list values
+	hashes  map[string]map[string]string // hash values
+	expires map[string]time.Time         // expiry of each key with a TTL
+
+	// history holds past versions of each key; nil while history is off
+	history map[string]*keyHistory
+	// watched counts the writes to each key a transaction watches
+	watched map[string]*watchedKey
+	// index holds the shard's keys in order; nil without an ordered index
+	index *skipList
+	// dirty holds the keys written since the last save; nil until a
+	// snapshot is saved or loaded, as deltas need one to apply to
+	dirty map[string]struct{}
+
+	// used is the estimated size of the shard's keys and values, written
+	// under mu and read without it for the store's total
+	used atomic.Int64
+	// access holds when each key was last used, for LRU eviction; nil
+	// under other policies
+	access map[string]*atomic.Uint64
+}
+
+// resetLocked empties the shard and bumps the version of its watched keys.
+// Caller holds sh.mu.
+func (sh *shard) resetLocked() {
+	sh.data = make(map[string]string)
+	sh.lists = make(map[string][]string)
+	sh.hashes = make(map[string]map[string]string)
+	sh.expires = make(map[string]time.Time)
+	if sh.index != nil {
+		sh.index = newSkipList()
+	}
+	if sh.access != nil {
+		sh.access = make(map[string]*atomic.Uint64)
+	}
+	sh.used.Store(0)
+	sh.dirty = nil
+	for _, w := range sh.watched {
+		w.version++
+	}
+}
+
+// fillLocked empties the store and spreads the keys of a copy of the data
+// over the shards, for Load and a full sync, and tells subscribers the
+// store was replaced. The next save is a full one. Caller holds every shard.
+func (s *Store) fillLocked(data map[string]string, lists map[string][]string, hashes map[string]map[string]string, expires map[string]time.Time) {
+	s.resetLocked()
+	for k, v := range data {
+		sh := s.shardFor(k)
+		sh.data[k] = v
+		sh.indexLocked(k)
+		s.growLocked(sh, k, sh.sizeLocked(k))
+	}
+	for k, l := range lists {
+		sh := s.shardFor(k)
+		sh.lists[k] = l
+		sh.indexLocked(k)
+		s.growLocked(sh, k, sh.sizeLocked(k))
+	}
+	for k, h := range hashes {
+		sh := s.shardFor(k)
+		sh.hashes[k] = h
+		sh.indexLocked(k)
+		s.growLocked(sh, k, sh.sizeLocked(k))
+	}
+	for k, at := range expires {
+		s.shardFor(k).expires[k] = at
+	}
+	s.needFull.Store(true)
+	s.pubsub.publish(Event{Kind: EventClear})
+}
+
+// shardFor returns the shard holding key
+func (s *Store) shardFor(key string) *shard {
+	return s.shards[s.shardIndex(key)]
+}
+
+func (s *Store) shardIndex(key string) int {
+	return int(maphash.String(s.seed, key) % uint64(len(s.shards)))
+}
+
+// lockAll locks every shard for writing, for commands on the whole store.
+// Shards are always locked in index order, so commands locking several of
+// them cannot deadlock.
+func (s *Store) lockAll() {
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+	}
+}
+
+func (s *Store) unlockAll() {
+	for _, sh := range s.shards {
+		sh.mu.Unlock()
+	}
+}
+
+// rlockAll locks every shard for reading, for a consistent copy of the
+// whole store
+func (s *Store) rlockAll() {
+	for _, sh := range s.shards {
+		sh.mu.RLock()
+	}
+}
+
+func (s *Store) runlockAll() {
+	for _, sh := range s.shards {
+		sh.mu.RUnlock()
+	}
+}
+
+// lockKeys locks the shards of keys in index order, for writing if write
+// is set, and returns the function that unlocks them
+func (s *Store) lockKeys(keys []string, write bool) (unlock func()) {
+	indexes := make([]int, 0, len(keys))
+	for _, key := range keys {
+		indexes = append(indexes, s.shardIndex(key))
+	}
+	slices.Sort(indexes)
+	indexes = slices.Compact(indexes)
+	for _, i := range indexes {
+		if write {
+			s.shards[i].mu.Lock()
+		} else {
+			s.shards[i].mu.RLock()
+		}
+	}
+	return func() {
+		for _, i := range indexes {
+			if write {
+				s.shards[i].mu.Unlock()
+			} else {
+				s.shards[i].mu.RUnlock()
+			}
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Errors of ShardedClient
+var (
+	ErrNoShards     = errors.New("no shards")
+	ErrShardExists  = errors.New("shard already in the ring")
+	ErrUnknownShard = errors.New("shard not in the ring")
+	// ErrUnmovableKeys is returned by RemoveShard for a shard holding
+	// lists or hashes, which migration does not move
+	ErrUnmovableKeys = errors.New("shard holds lists or hashes")
+	// ErrMigrationConflict is returned when a key kept changing while
+	// migration tried to move it
+	ErrMigrationConflict = errors.New("key changed during migration")
+)
+
+// moveAttempts is how many times migration copies a key that other
+// clients keep writing before it gives up
+const moveAttempts = 3
+
+// defaultVirtualNodes is the default of ShardedOptions.VirtualNodes
+const defaultVirtualNodes = 128
+
+// ShardedOptions configures a ShardedClient
+type ShardedOptions struct {
+	// VirtualNodes is how many points each shard takes on the hash ring
+	// (default 128). More points spread keys more evenly.
+	VirtualNodes int
+}
+
+func (o ShardedOptions) withDefaults() ShardedOptions {
+	if o.VirtualNodes <= 0 {
+		o.VirtualNodes = defaultVirtualNodes
+	}
+	return o
+}
+
+// ringPoint is a virtual node: the point hash on the ring belongs to addr
+type ringPoint struct {
+	hash uint64
+	addr string
+}
+
+// hashRing maps keys to shards by consistent hashing. A key belongs to the
+// first point at or after its hash, wrapping around, so adding or removing
+// a shard only moves the keys of that shard's points.
+type hashRing struct {
+	vnodes int
+	points []ringPoint // sorted by hash
+}
+
+// ringHash hashes a key or virtual node. It must not depend on the
+// process, as every client has to place keys alike.
+func ringHash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	// FNV leaves similar strings close together; mix the bits to spread
+	// "addr#0", "addr#1"... over the ring
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// with returns a copy of the ring with addr's points added
+func (r hashRing) with(addr string) hashRing {
+	points := slices.Clone(r.points)
+	for i := range r.vnodes {
+		points = append(points, ringPoint{hash: ringHash(addr + "#" + strconv.Itoa(i)), addr: addr})
+	}
+	slices.SortFunc(points, func(a, b ringPoint) int {
+		// Ties are broken the same way on every client
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.addr, b.addr))
+	})
+	return hashRing{vnodes: r.vnodes, points: points}
+}
+
+// without returns a copy of the ring with addr's points removed
+func (r hashRing) without(addr string) hashRing {
+	points := slices.DeleteFunc(slices.Clone(r.points), func(p ringPoint) bool {
+		return p.addr == addr
+	})
+	return hashRing{vnodes: r.vnodes, points: points}
+}
+
+// owner returns the shard key belongs to, or "" on an empty ring
+func (r hashRing) owner(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	h := ringHash(key)
+	i, _ := slices.BinarySearchFunc(r.points, h, func(p ringPoint, h uint64) int {
+		return cmp.Compare(p.hash, h)
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.points[i].addr
+}
+
+// shardConn is the connection to one shard. A Client is not safe for
+// concurrent use, so calls on it take mu.
+type shardConn struct {
+	mu     sync.Mutex
+	client *Client
+}
+
+// ShardedClient spreads keys over several servers by consistent hashing,
+// with the Get, Set and Delete of Client. Every client given the same
+// shards and VirtualNodes places keys alike. Unlike Client, it is safe for
+// concurrent use.
+//
+// Only string keys are sharded; lists and hashes stay wherever they were
+// written, and migration leaves them alone. RemoveShard refuses a shard
+// that holds any, as they would be lost with it.
+type ShardedClient struct {
+	mu    sync.RWMutex // guards ring and conns; held for writing to migrate
+	ring  hashRing
+	conns map[string]*shardConn
+}
+
+// DialSharded connects to the servers at addrs
+func DialSharded(addrs []string, opts ShardedOptions) (*ShardedClient, error) {
+	opts = opts.withDefaults()
+	c := &ShardedClient{
+		ring:  hashRing{vnodes: opts.VirtualNodes},
+		conns: make(map[string]*shardConn),
+	}
+	for _, addr := range addrs {
+		if _, ok := c.conns[addr]; ok {
+			c.Close()
+			return nil, fmt.Errorf("%w: %s", ErrShardExists, addr)
+		}
+		client, err := Dial(addr)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.conns[addr] = &shardConn{client: client}
+		c.ring = c.ring.with(addr)
+	}
+	return c, nil
+}
+
+// Close closes the connection to every shard
+func (c *ShardedClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for addr, conn := range c.conns {
+		errs = append(errs, conn.client.Close())
+		delete(c.conns, addr)
+	}
+	c.ring = hashRing{vnodes: c.ring.vnodes}
+	return errors.Join(errs...)
+}
+
+// Shards returns the addresses of the shards, sorted
+func (c *ShardedClient) Shards() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Sorted(maps.Keys(c.conns))
+}
+
+// ShardFor returns the address of the shard key belongs to, or "" if there
+// are no shards
+func (c *ShardedClient) ShardFor(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ring.owner(key)
+}
+
+// Get retrieves a value by key from its shard
+func (c *ShardedClient) Get(key string) (value string, found bool, err error) {
+	err = c.onShard(key, func(client *Client) error {
+		value, found, err = client.Get(key)
+		return err
+	})
+	return value, found, err
+}
+
+// Set stores a key-value pair on its shard
+func (c *ShardedClient) Set(key, value string) error {
+	return c.onShard(key, func(client *Client) error {
+		return client.Set(key, value)
+	})
+}
+
+// Delete removes a key from its shard and reports whether it existed
+func (c *ShardedClient) Delete(key string) (found bool, err error) {
+	err = c.onShard(key, func(client *Client) error {
+		found, err = client.Delete(key)
+		return err
+	})
+	return found, err
+}
+
+// onShard runs fn on the connection of key's shard
+func (c *ShardedClient) onShard(key string, fn func(*Client) error) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	addr := c.ring.owner(key)
+	if addr == "" {
+		return ErrNoShards
+	}
+	conn := c.conns[addr]
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	return fn(conn.client)
+}
+
+// AddShard connects to the server at addr, adds it to the ring and moves
+// to it the keys it now owns from the other shards. It returns how many
+// keys were moved. If a move fails, the shard stays in the ring and
+// Rebalance finishes the job.
+func (c *ShardedClient) AddShard(addr string) (moved int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.conns[addr]; ok {
+		return 0, fmt.Errorf("%w: %s", ErrShardExists, addr)
+	}
+	client, err := Dial(addr)
+	if err != nil {
+		return 0, err
+	}
+	c.conns[addr] = &shardConn{client: client}
+	c.ring = c.ring.with(addr)
+	return c.rebalanceLocked()
+}
+
+// RemoveShard moves the keys of the shard at addr to the shards that own
+// them without it, then drops it from the ring and closes its connection.
+// It returns how many keys were moved. If a move fails, the shard is kept
+// and the error returned; calling RemoveShard again resumes. The keys of
+// the last shard have nowhere to go, so it cannot be removed, and neither
+// can a shard holding lists or hashes: it fails with ErrUnmovableKeys
+// before moving anything.
+func (c *ShardedClient) RemoveShard(addr string) (moved int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn, ok := c.conns[addr]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrUnknownShard, addr)
+	}
+	if len(c.conns) == 1 {
+		return 0, fmt.Errorf("%w: cannot remove the last shard", ErrNoShards)
+	}
+	if err := checkMovable(conn.client); err != nil {
+		return 0, fmt.Errorf("shard %s: %w", addr, err)
+	}
+	ring := c.ring.without(addr)
+	moved, err = c.migrateLocked(addr, ring)
+	if err != nil {
+		return moved, err
+	}
+	c.ring = ring
+	delete(c.conns, addr)
+	return moved, conn.client.Close()
+}
+
+// Rebalance moves every key found on a shard that does not own it to the
+// one that does, and returns how many it moved. AddShard calls it; call it
+// again after a migration failed, or once servers were written to by
+// clients with other shards.
+func (c *ShardedClient) Rebalance() (moved int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rebalanceLocked()
+}
+
+func (c *ShardedClient) rebalanceLocked() (int, error) {
+	moved := 0
+	var errs []error
+	for _, addr := range slices.Sorted(maps.Keys(c.conns)) {
+		n, err := c.migrateLocked(addr, c.ring)
+		moved += n
+		errs = append(errs, err)
+	}
+	return moved, errors.Join(errs...)
+}
+
+// checkMovable fails with ErrUnmovableKeys if the shard of client holds a
+// list or hash. It reads every key in one batch, which fails with
+// ErrWrongType on the first that is not a string.
+func checkMovable(client *Client) error {
+	keys, err := client.Keys("*")
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+	ops := make([]BatchOp, len(keys))
+	for i, key := range keys {
+		ops[i] = BatchOp{Op: batchGet, Key: key}
+	}
+	if _, err := client.Batch(ops); errors.Is(err, ErrWrongType) {
+		return ErrUnmovableKeys
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// migrateLocked moves the string keys on the shard at from that ring
+// places elsewhere, with moveKey. The client's own calls wait meanwhile;
+// other clients writing the keys being moved do not lose their writes,
+// but a write that lands on the new owner before the copy is overwritten.
+// Caller holds c.mu for writing.
+func (c *ShardedClient) migrateLocked(from string, ring hashRing) (moved int, err error) {
+	src := c.conns[from].client
+	keys, err := src.Keys("*")
+	if err != nil {
+		return 0, fmt.Errorf("shard %s: %w", from, err)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		to := ring.owner(key)
+		if to == from {
+			continue
+		}
+		ok, err := moveKey(src, c.conns[to].client, key)
+		if errors.Is(err, ErrWrongType) {
+			// A list or hash
+			continue
+		}
+		if err != nil {
+			return moved, fmt.Errorf("move %s from %s to %s: %w", key, from, to, err)
+		}
+		if ok {
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// moveKey copies the string key and its remaining TTL from src to dst,
+// then deletes it from src only if it still holds the copied value. If
+// another client changed it in between, the copy is redone, up to
+// moveAttempts times before failing with ErrMigrationConflict; if it was
+// deleted, the copy is taken back. It reports false if the key was deleted
+// or expired, and fails with ErrWrongType for a list or hash.
+func moveKey(src, dst *Client, key string) (bool, error) {
+	var copied *string
+	for range moveAttempts {
+		read, err := src.Batch([]BatchOp{{Op: batchGet, Key: key}, {Op: batchTTL, Key: key}})
+		if err != nil {
+			return false, err
+		}
+		if !read[0].Found || !read[1].Found {
+			if copied != nil {
+				_, err := dst.Batch([]BatchOp{{Op: batchExpect, Key: key, Value: *copied}, {Op: batchDel, Key: key}})
+				if err != nil && !errors.Is(err, ErrBatchAborted) {
+					return false, err
+				}
+			}
+			return false, nil
+		}
+		value := read[0].Value
+		set := BatchOp{Op: batchSet, Key: key, Value: value}
+		if read[1].TTL != NoExpiry {
+			set.TTL = read[1].TTL
+		}
+		if _, err := dst.Batch([]BatchOp{set}); err != nil {
+			return false, err
+		}
+		copied = &value
+		_, err = src.Batch([]BatchOp{{Op: batchExpect, Key: key, Value: value}, {Op: batchDel, Key: key}})
+		if !errors.Is(err, ErrBatchAborted) {
+			return err == nil, err
+		}
+	}
+	return false, ErrMigrationConflict
+}
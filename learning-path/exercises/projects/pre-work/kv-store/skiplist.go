@@ -0,0 +1,92 @@
+package main
+
+import "math/rand/v2"
+
+// maxLevel bounds the height of a skip list node; with p = 1/4 it covers
+// far more keys than fit in memory
+const maxLevel = 16
+
+// skipList is a sorted set of keys. Searches, inserts and removes take
+// O(log n) expected time, and the keys from any point on are read in
+// order by following the bottom level. It is not safe for concurrent
+// use; a shard guards its own.
+type skipList struct {
+	head  skipNode
+	level int // levels in use, at least 1
+	len   int
+}
+
+type skipNode struct {
+	key  string
+	next []*skipNode // next[i] is the following node on level i
+}
+
+func newSkipList() *skipList {
+	return &skipList{head: skipNode{next: make([]*skipNode, maxLevel)}, level: 1}
+}
+
+// findPath fills path with the last node before key on each level and
+// returns the first node at or after key, or nil
+func (l *skipList) findPath(key string, path *[maxLevel]*skipNode) *skipNode {
+	n := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		if path != nil {
+			path[i] = n
+		}
+	}
+	return n.next[0]
+}
+
+// insert adds key and reports whether it was not there already
+func (l *skipList) insert(key string) bool {
+	var path [maxLevel]*skipNode
+	if n := l.findPath(key, &path); n != nil && n.key == key {
+		return false
+	}
+	level := randomLevel()
+	for ; l.level < level; l.level++ {
+		path[l.level] = &l.head
+	}
+	n := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := range level {
+		n.next[i] = path[i].next[i]
+		path[i].next[i] = n
+	}
+	l.len++
+	return true
+}
+
+// remove deletes key and reports whether it was there
+func (l *skipList) remove(key string) bool {
+	var path [maxLevel]*skipNode
+	n := l.findPath(key, &path)
+	if n == nil || n.key != key {
+		return false
+	}
+	for i := range len(n.next) {
+		path[i].next[i] = n.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+	l.len--
+	return true
+}
+
+// seek returns the node of the first key at or after key, or nil
+func (l *skipList) seek(key string) *skipNode {
+	return l.findPath(key, nil)
+}
+
+// randomLevel returns a node height: each level above the first with
+// probability 1/4
+func randomLevel() int {
+	level := 1
+	for level < maxLevel && rand.IntN(4) == 0 {
+		level++
+	}
+	return level
+}
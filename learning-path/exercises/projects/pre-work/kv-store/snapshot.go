@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// ErrCorruptSnapshot is returned by Load for a binary snapshot that is
+// truncated or fails its checksum
+var ErrCorruptSnapshot = errors.New("corrupt snapshot")
+
+// SnapshotFormat is how Snapshot encodes the store. Load reads every
+// format, compressed or not, whatever the store is set to write.
+type SnapshotFormat string
+
+const (
+	// SnapshotJSON is the indented JSON document of Snapshot
+	SnapshotJSON SnapshotFormat = "json"
+	// SnapshotBinary is a stream of length-prefixed records, written and
+	// read without holding the encoded file in memory
+	SnapshotBinary SnapshotFormat = "binary"
+)
+
+// ParseSnapshotFormat parses a format name: json or binary
+func ParseSnapshotFormat(name string) (SnapshotFormat, error) {
+	switch f := SnapshotFormat(name); f {
+	case SnapshotJSON, SnapshotBinary:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown snapshot format %q: want json or binary", name)
+}
+
+// The binary format is a header, a record per key and a trailer:
+//
+//	header:  "KVDB" version:u8 timestamp:i64 (Unix seconds, big-endian)
+//	ids:     'm' id base seq:uvarint (version 2; base and seq for a delta)
+//	string:  's' key value
+//	list:    'l' key count:uvarint item...
+//	hash:    'h' key count:uvarint (field value)...
+//	expiry:  'x' key at:varint (Unix nanoseconds)
+//	deleted: 'd' key (a delta's removed key)
+//	trailer: 'e' records:uvarint crc:u32 (big-endian)
+//
+// Strings are a uvarint length and the bytes. The CRC-32 covers everything
+// before it, so a truncated or damaged file is detected at the end.
+const (
+	binarySnapshotMagic   = "KVDB"
+	binarySnapshotVersion = 2
+
+	recIDs    = 'm'
+	recString = 's'
+	recList   = 'l'
+	recHash   = 'h'
+	recExpiry = 'x'
+	recDelete = 'd'
+	recEnd    = 'e'
+)
+
+// gzipMagic starts every gzip stream
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// writeSnapshotFile writes snap to path in the store's format
+func (s *Store) writeSnapshotFile(path string, snap *Snapshot) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	buf := bufio.NewWriter(f)
+	var w io.Writer = buf
+	var zw *gzip.Writer
+	if s.snapshotGzip {
+		zw = gzip.NewWriter(buf)
+		w = zw
+	}
+	if s.snapshotFormat == SnapshotBinary {
+		err = encodeBinarySnapshot(w, snap)
+	} else {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(snap)
+	}
+	if err != nil {
+		return err
+	}
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	return buf.Flush()
+}
+
+// readSnapshot decodes a snapshot in any format, telling them apart by
+// their first bytes
+func readSnapshot(r io.Reader) (*Snapshot, error) {
+	br := bufio.NewReader(r)
+	if head, _ := br.Peek(len(gzipMagic)); bytes.Equal(head, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+	if head, _ := br.Peek(len(binarySnapshotMagic)); string(head) == binarySnapshotMagic {
+		return decodeBinarySnapshot(br)
+	}
+	var snap Snapshot
+	if err := json.NewDecoder(br).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("unmarshaling snapshot: %w", err)
+	}
+	return &snap, nil
+}
+
+// snapshotEncoder writes the records of a binary snapshot, keeping the
+// first error so callers check it once at the end
+type snapshotEncoder struct {
+	w       *bufio.Writer
+	crc     hash.Hash32
+	records uint64
+	scratch [binary.MaxVarintLen64]byte
+	err     error
+}
+
+func encodeBinarySnapshot(w io.Writer, snap *Snapshot) error {
+	e := &snapshotEncoder{crc: crc32.NewIEEE()}
+	e.w = bufio.NewWriter(io.MultiWriter(w, e.crc))
+
+	timestamp, _ := time.Parse(time.RFC3339, snap.Timestamp)
+	e.write([]byte(binarySnapshotMagic))
+	e.write([]byte{binarySnapshotVersion})
+	e.write(binary.BigEndian.AppendUint64(nil, uint64(timestamp.Unix())))
+	if snap.ID != "" || snap.Base != "" {
+		e.record(recIDs, snap.ID)
+		e.string(snap.Base)
+		e.uvarint(uint64(snap.Seq))
+	}
+
+	for k, v := range snap.Data {
+		e.record(recString, k)
+		e.string(v)
+	}
+	for k, l := range snap.Lists {
+		e.record(recList, k)
+		e.uvarint(uint64(len(l)))
+		for _, item := range l {
+			e.string(item)
+		}
+	}
+	for k, h := range snap.Hashes {
+		e.record(recHash, k)
+		e.uvarint(uint64(len(h)))
+		for field, v := range h {
+			e.string(field)
+			e.string(v)
+		}
+	}
+	for k, at := range snap.Expires {
+		e.record(recExpiry, k)
+		e.write(binary.AppendVarint(e.scratch[:0], at.UnixNano()))
+	}
+	for _, k := range snap.Deleted {
+		e.record(recDelete, k)
+	}
+
+	e.write([]byte{recEnd})
+	e.uvarint(e.records)
+	if e.err == nil {
+		e.err = e.w.Flush()
+	}
+	if e.err != nil {
+		return e.err
+	}
+	// The checksum itself goes straight to w, past the hash
+	_, err := w.Write(binary.BigEndian.AppendUint32(nil, e.crc.Sum32()))
+	return err
+}
+
+func (e *snapshotEncoder) record(kind byte, key string) {
+	e.records++
+	if e.err == nil {
+		e.err = e.w.WriteByte(kind)
+	}
+	e.string(key)
+}
+
+func (e *snapshotEncoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+func (e *snapshotEncoder) uvarint(n uint64) {
+	e.write(binary.AppendUvarint(e.scratch[:0], n))
+}
+
+func (e *snapshotEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+// snapshotDecoder reads the records of a binary snapshot, hashing what it
+// reads to check the trailer
+type snapshotDecoder struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+	buf []byte // reused to read strings into
+	err error
+}
+
+// maxSnapshotCount bounds a decoded list or hash length before anything is
+// allocated for it, so a damaged length fails instead of exhausting memory
+const maxSnapshotCount = 1 << 30
+
+func decodeBinarySnapshot(r *bufio.Reader) (*Snapshot, error) {
+	d := &snapshotDecoder{r: r, crc: crc32.NewIEEE()}
+	var header [len(binarySnapshotMagic) + 1 + 8]byte
+	d.read(header[:])
+	if d.err != nil {
+		return nil, d.corrupt(d.err)
+	}
+	if v := header[len(binarySnapshotMagic)]; v > binarySnapshotVersion {
+		return nil, fmt.Errorf("unsupported binary snapshot version %d", v)
+	}
+	unix := int64(binary.BigEndian.Uint64(header[len(binarySnapshotMagic)+1:]))
+	snap := &Snapshot{
+		Version:   snapshotVersion,
+		Timestamp: time.Unix(unix, 0).UTC().Format(time.RFC3339),
+		Data:      make(map[string]string),
+		Lists:     make(map[string][]string),
+		Hashes:    make(map[string]map[string]string),
+		Expires:   make(map[string]time.Time),
+	}
+
+	var records uint64
+	for d.err == nil {
+		kind := d.byte()
+		if kind == recEnd {
+			break
+		}
+		records++
+		key := d.string()
+		switch kind {
+		case recIDs:
+			snap.ID = key
+			snap.Base = d.string()
+			snap.Seq = d.count()
+		case recDelete:
+			snap.Deleted = append(snap.Deleted, key)
+		case recString:
+			snap.Data[key] = d.string()
+		case recList:
+			n := d.count()
+			l := make([]string, 0, min(n, 1024))
+			for i := 0; i < n && d.err == nil; i++ {
+				l = append(l, d.string())
+			}
+			snap.Lists[key] = l
+		case recHash:
+			n := d.count()
+			h := make(map[string]string, min(n, 1024))
+			for i := 0; i < n && d.err == nil; i++ {
+				field := d.string()
+				h[field] = d.string()
+			}
+			snap.Hashes[key] = h
+		case recExpiry:
+			at, err := binary.ReadVarint(d)
+			d.fail(err)
+			snap.Expires[key] = time.Unix(0, at)
+		default:
+			d.fail(fmt.Errorf("unknown record type %q", kind))
+		}
+	}
+	if n := d.uvarint(); d.err == nil && n != records {
+		d.fail(fmt.Errorf("%d records, trailer says %d", records, n))
+	}
+	want := d.crc.Sum32()
+	var trailer [4]byte
+	if d.err == nil {
+		_, d.err = io.ReadFull(d.r, trailer[:])
+	}
+	if d.err == nil && binary.BigEndian.Uint32(trailer[:]) != want {
+		d.fail(errors.New("checksum mismatch"))
+	}
+	if d.err != nil {
+		return nil, d.corrupt(d.err)
+	}
+	return snap, nil
+}
+
+func (d *snapshotDecoder) corrupt(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("%w: %w", ErrCorruptSnapshot, err)
+}
+
+func (d *snapshotDecoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+}
+
+func (d *snapshotDecoder) read(p []byte) {
+	if d.err == nil {
+		_, d.err = io.ReadFull(d.r, p)
+		d.crc.Write(p)
+	}
+}
+
+// ReadByte makes the decoder an io.ByteReader for the varint readers
+func (d *snapshotDecoder) ReadByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err == nil {
+		d.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+func (d *snapshotDecoder) byte() byte {
+	var b [1]byte
+	d.read(b[:])
+	return b[0]
+}
+
+func (d *snapshotDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, err := binary.ReadUvarint(d)
+	d.fail(err)
+	return n
+}
+
+func (d *snapshotDecoder) count() int {
+	n := d.uvarint()
+	if n > maxSnapshotCount {
+		d.fail(fmt.Errorf("count %d too large", n))
+		return 0
+	}
+	return int(n)
+}
+
+// stringChunk is the most a string of a snapshot is read at once
+const stringChunk = 64 << 10
+
+func (d *snapshotDecoder) string() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	if cap(d.buf) < min(n, stringChunk) {
+		d.buf = make([]byte, min(max(n, 2*cap(d.buf)), stringChunk))
+	}
+	if n <= stringChunk {
+		d.read(d.buf[:n])
+		return string(d.buf[:n])
+	}
+	// A long string grows as it is read, so a damaged length runs into the
+	// end of the file instead of allocating it up front
+	var sb strings.Builder
+	for n > 0 && d.err == nil {
+		chunk := d.buf[:min(n, stringChunk)]
+		d.read(chunk)
+		sb.Write(chunk)
+		n -= len(chunk)
+	}
+	return sb.String()
+}
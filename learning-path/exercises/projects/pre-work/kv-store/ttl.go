@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NoExpiry is the TTL of a key that does not expire
+const NoExpiry time.Duration = -1
+
+// SetWithTTL stores a key-value pair that expires ttl from now. A ttl of
+// zero or less stores a key that has already expired. It fails with
+// ErrOutOfMemory if the store is full.
+func (s *Store) SetWithTTL(key, value string, ttl time.Duration) error {
+	if err := s.makeRoom(); err != nil {
+		return err
+	}
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	cmd := Command{Op: opSet, Key: key, Value: value, ExpiresAt: s.now().Add(ttl)}
+	s.applyLocked(sh, cmd)
+	s.record(sh, cmd)
+	return nil
+}
+
+// TTL returns how long key has left to live, or NoExpiry if it does not
+// expire. found is false if the key does not exist.
+func (s *Store) TTL(key string) (ttl time.Duration, found bool) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if sh.typeLocked(key) == TypeNone {
+		return 0, false
+	}
+	at, ok := sh.expires[key]
+	if !ok {
+		return NoExpiry, true
+	}
+	ttl = at.Sub(s.now())
+	if ttl <= 0 {
+		return 0, false
+	}
+	return ttl, true
+}
+
+// ExpireKeys removes every key whose TTL has passed and returns how many it
+// removed. Reads already hide expired keys and remove the ones they come
+// across; ExpireKeys frees the keys nobody reads. Only keys with a TTL are
+// scanned, one shard at a time.
+//
+// A follower never removes keys itself: it hides them until the primary's
+// delete arrives, so its data set stays a copy of the primary's.
+func (s *Store) ExpireKeys() int {
+	if s.ReadOnly() {
+		return 0
+	}
+	expired := 0
+	for _, sh := range s.shards {
+		sh.mu.Lock()
+		now := s.now()
+		for key, at := range sh.expires {
+			if !now.Before(at) {
+				s.removeExpiredLocked(sh, key)
+				expired++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return expired
+}
+
+// expiredLocked reports whether key has a TTL that has passed. Caller
+// holds sh.mu, the key's shard.
+func (s *Store) expiredLocked(sh *shard, key string) bool {
+	at, ok := sh.expires[key]
+	return ok && !s.now().Before(at)
+}
+
+// expire removes key if it has expired, for a read that found it so. The
+// read lock is released by then, so the key is checked again.
+func (s *Store) expire(key string) {
+	if s.ReadOnly() {
+		return
+	}
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if s.expiredLocked(sh, key) {
+		s.removeExpiredLocked(sh, key)
+	}
+}
+
+// removeExpiredLocked deletes an expired key and records the delete, so
+// followers and the version history see it go
+func (s *Store) removeExpiredLocked(sh *shard, key string) {
+	sh.deleteLocked(key)
+	s.record(sh, Command{Op: opDel, Key: key, Expired: true})
+	s.expiredKeys.Add(1)
+}
+
+// reapExpired runs ExpireKeys every interval
+func reapExpired(store *Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		store.ExpireKeys()
+	}
+}
+
+// parseSetArgs splits the arguments of SET into the value and its TTL. A
+// value ending in "EX <seconds>" sets a TTL; ttl is 0 without one.
+func parseSetArgs(args []string) (value string, ttl time.Duration, err error) {
+	if n := len(args); n >= 3 && strings.EqualFold(args[n-2], "EX") {
+		secs, err := strconv.Atoi(args[n-1])
+		if err != nil || secs <= 0 {
+			return "", 0, fmt.Errorf("invalid expire time %q", args[n-1])
+		}
+		return strings.Join(args[:n-2], " "), time.Duration(secs) * time.Second, nil
+	}
+	return strings.Join(args, " "), 0, nil
+}
+
+// formatTTL renders TTL's result as the REPL prints it: whole seconds left,
+// rounded up, -1 for a key without a TTL and -2 for a missing key
+func formatTTL(ttl time.Duration, found bool) string {
+	switch {
+	case !found:
+		return "-2"
+	case ttl == NoExpiry:
+		return "-1"
+	}
+	return strconv.FormatInt(int64((ttl+time.Second-1)/time.Second), 10)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Transaction errors
+var (
+	// ErrTxnAborted is returned by Exec when a watched key was written
+	// after it was watched; none of the transaction's commands are applied
+	ErrTxnAborted = errors.New("transaction aborted: a watched key changed")
+	ErrTxnDone    = errors.New("transaction already executed or discarded")
+)
+
+// watchedKey is the version of a key watched by at least one transaction.
+// Every write to the key bumps the version.
+type watchedKey struct {
+	version  uint64
+	watchers int
+}
+
+// watch is a key as a transaction saw it when it started watching it
+type watch struct {
+	version uint64
+	live    bool // the key had not expired
+}
+
+// Txn is an optimistic transaction: the keys it watches are checked when
+// it executes, and it aborts if any of them was written in between. This
+// is the WATCH/MULTI/EXEC of Redis. A Txn is not safe for concurrent use.
+type Txn struct {
+	store   *Store
+	watched map[string]watch
+	done    bool
+}
+
+// Watch starts a transaction that watches keys. Read them, then Exec the
+// writes that depend on what was read; Exec fails with ErrTxnAborted if
+// another client wrote one of the keys meanwhile, and the caller retries.
+// A transaction that is not executed must be discarded to stop watching.
+func (s *Store) Watch(keys ...string) *Txn {
+	t := &Txn{store: s, watched: make(map[string]watch)}
+	t.Watch(keys...)
+	return t
+}
+
+// Watch adds keys to the transaction. A key already watched keeps the
+// version it was first watched at.
+func (t *Txn) Watch(keys ...string) {
+	if t.done {
+		return
+	}
+	s := t.store
+	unlock := s.lockKeys(keys, true)
+	defer unlock()
+	for _, key := range keys {
+		if _, ok := t.watched[key]; ok {
+			continue
+		}
+		sh := s.shardFor(key)
+		if sh.watched == nil {
+			sh.watched = make(map[string]*watchedKey)
+		}
+		w, ok := sh.watched[key]
+		if !ok {
+			w = &watchedKey{}
+			sh.watched[key] = w
+		}
+		w.watchers++
+		t.watched[key] = watch{version: w.version, live: !s.expiredLocked(sh, key)}
+	}
+}
+
+// Exec executes ops atomically, like Batch, unless a watched key was
+// written since it was watched, in which case it applies nothing and
+// returns ErrTxnAborted. A watched key that expired meanwhile counts as
+// written. Exec ends the transaction either way.
+func (t *Txn) Exec(ops []BatchOp) ([]BatchResult, error) {
+	if t.done {
+		return nil, ErrTxnDone
+	}
+	// Deferred first, so it runs once the shards are released
+	defer t.Discard()
+
+	writes, err := checkBatch(ops)
+	if err != nil {
+		return nil, err
+	}
+	s := t.store
+	if writes && s.ReadOnly() {
+		return nil, ErrReadOnly
+	}
+	if writes {
+		if err := s.makeRoom(); err != nil {
+			return nil, err
+		}
+	}
+	keys := batchKeys(ops)
+	for key := range t.watched {
+		keys = append(keys, key)
+	}
+	unlock := s.lockKeys(keys, writes)
+	defer unlock()
+
+	for key, w := range t.watched {
+		sh := s.shardFor(key)
+		if sh.watched[key].version != w.version || (w.live && s.expiredLocked(sh, key)) {
+			return nil, fmt.Errorf("%w: %s", ErrTxnAborted, key)
+		}
+	}
+	return s.batchLocked(ops)
+}
+
+// Discard ends the transaction without executing it and stops watching
+// its keys. It does nothing on a transaction already ended.
+func (t *Txn) Discard() {
+	if t.done {
+		return
+	}
+	t.done = true
+	if len(t.watched) == 0 {
+		return
+	}
+	s := t.store
+	unlock := s.lockKeys(slices.Collect(maps.Keys(t.watched)), true)
+	defer unlock()
+	for key := range t.watched {
+		sh := s.shardFor(key)
+		w := sh.watched[key]
+		if w.watchers--; w.watchers == 0 {
+			delete(sh.watched, key)
+		}
+	}
+	t.watched = nil
+}
+
+// touchLocked bumps the version of key if a transaction watches it. Caller
+// holds sh.mu for writing.
+func (sh *shard) touchLocked(key string) {
+	if w := sh.watched[key]; w != nil {
+		w.version++
+	}
+}
+
+// replTxn is the transaction state of a REPL session: the transaction
+// watching keys, and the commands queued since MULTI
+type replTxn struct {
+	txn    *Txn
+	multi  bool
+	queued []BatchOp
+}
+
+// handle runs the transaction commands, and queues commands between MULTI
+// and EXEC. It reports false for a command it leaves to the REPL.
+func (r *replTxn) handle(store *Store, command, line string, parts []string) bool {
+	switch command {
+	case "WATCH":
+		switch {
+		case r.multi:
+			fmt.Println("Error: WATCH inside MULTI is not allowed")
+		case len(parts) < 2:
+			fmt.Println("Usage: WATCH <key> [key ...]")
+		case r.txn == nil:
+			r.txn = store.Watch(parts[1:]...)
+			fmt.Println("OK")
+		default:
+			r.txn.Watch(parts[1:]...)
+			fmt.Println("OK")
+		}
+
+	case "UNWATCH":
+		if r.multi {
+			fmt.Println("Error: UNWATCH inside MULTI is not allowed")
+			return true
+		}
+		if r.txn != nil {
+			r.txn.Discard()
+			r.txn = nil
+		}
+		fmt.Println("OK")
+
+	case "MULTI":
+		if r.multi {
+			fmt.Println("Error: MULTI calls can not be nested")
+			return true
+		}
+		r.multi = true
+		fmt.Println("OK")
+
+	case "EXEC":
+		if !r.multi {
+			fmt.Println("Error: EXEC without MULTI")
+			return true
+		}
+		ops := r.queued
+		txn := r.txn
+		if txn == nil {
+			txn = store.Watch()
+		}
+		*r = replTxn{}
+		results, err := txn.Exec(ops)
+		switch {
+		case errors.Is(err, ErrTxnAborted):
+			fmt.Println("(nil)")
+		case err != nil:
+			fmt.Printf("Error: %v\n", err)
+		case len(ops) == 0:
+			fmt.Println("(empty list)")
+		default:
+			fmt.Print(formatBatchResults(ops, results))
+		}
+
+	case "DISCARD":
+		if !r.multi {
+			fmt.Println("Error: DISCARD without MULTI")
+			return true
+		}
+		if r.txn != nil {
+			r.txn.Discard()
+		}
+		*r = replTxn{}
+		fmt.Println("OK")
+
+	case "HELP", "EXIT", "QUIT":
+		return false
+
+	default:
+		if !r.multi {
+			return false
+		}
+		// Between MULTI and EXEC, the batch operations are queued
+		ops, err := ParseBatch(strings.TrimSpace(line))
+		if err != nil {
+			fmt.Printf("Error: %v (not queued; only GET, SET, DEL, EXISTS, TTL, EXPECT and ABSENT can be)\n", err)
+			return true
+		}
+		r.queued = append(r.queued, ops...)
+		fmt.Println("QUEUED")
+	}
+	return true
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Typed value errors
+var (
+	// ErrWrongType is returned for a command on a key holding another
+	// type of value, such as LPUSH on a string
+	ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	// ErrNotInteger is returned by INCR and DECR for a value that is not
+	// a 64-bit integer, or when the result would overflow
+	ErrNotInteger = errors.New("value is not an integer or out of range")
+)
+
+// Types of value, as TYPE reports them. Counters are strings holding an
+// integer.
+const (
+	TypeNone   = "none"
+	TypeString = "string"
+	TypeList   = "list"
+	TypeHash   = "hash"
+)
+
+// Type returns the type of the value at key, or TypeNone if it does not
+// exist
+func (s *Store) Type(key string) string {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if s.expiredLocked(sh, key) {
+		return TypeNone
+	}
+	return sh.typeLocked(key)
+}
+
+// typeLocked returns the type of key, expired or not. Caller holds sh.mu.
+func (sh *shard) typeLocked(key string) string {
+	if _, ok := sh.data[key]; ok {
+		return TypeString
+	}
+	if _, ok := sh.lists[key]; ok {
+		return TypeList
+	}
+	if _, ok := sh.hashes[key]; ok {
+		return TypeHash
+	}
+	return TypeNone
+}
+
+// liveTypeLocked returns the type of key for a write, first removing the
+// key if it has expired. Caller holds sh.mu, the key's shard, for writing.
+func (s *Store) liveTypeLocked(sh *shard, key string) string {
+	if s.expiredLocked(sh, key) {
+		s.removeExpiredLocked(sh, key)
+		return TypeNone
+	}
+	return sh.typeLocked(key)
+}
+
+// deleteLocked removes key, whatever its type, and its TTL. Caller holds
+// sh.mu.
+func (sh *shard) deleteLocked(key string) {
+	if sh.index != nil {
+		sh.index.remove(key)
+	}
+	sh.used.Add(-sh.sizeLocked(key))
+	delete(sh.access, key)
+	delete(sh.data, key)
+	delete(sh.lists, key)
+	delete(sh.hashes, key)
+	delete(sh.expires, key)
+}
+
+// LPush inserts values at the head of the list at key, one after the
+// other, so the last value ends up first. A missing key is created as an
+// empty list first. It returns the length of the list.
+func (s *Store) LPush(key string, values ...string) (int, error) {
+	if err := s.makeRoom(); err != nil {
+		return 0, err
+	}
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	switch s.liveTypeLocked(sh, key) {
+	case TypeNone, TypeList:
+	default:
+		return 0, ErrWrongType
+	}
+	cmd := Command{Op: opLPush, Key: key, Values: values}
+	s.applyLocked(sh, cmd)
+	s.record(sh, cmd)
+	return len(sh.lists[key]), nil
+}
+
+// LRange returns the elements of the list at key from start to stop,
+// inclusive. Negative indexes count from the end, so -1 is the last
+// element; indexes out of range are clamped. A missing key is an empty
+// list.
+func (s *Store) LRange(key string, start, stop int) ([]string, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	expired := s.expiredLocked(sh, key)
+	var result []string
+	var err error
+	if !expired {
+		result, err = sh.lrangeLocked(key, start, stop)
+		s.accessedLocked(sh, key)
+	}
+	sh.mu.RUnlock()
+	if expired {
+		s.expire(key)
+	}
+	return result, err
+}
+
+func (sh *shard) lrangeLocked(key string, start, stop int) ([]string, error) {
+	switch sh.typeLocked(key) {
+	case TypeNone:
+		return nil, nil
+	case TypeList:
+	default:
+		return nil, ErrWrongType
+	}
+	list := sh.lists[key]
+	n := len(list)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if stop < 0 {
+		stop = n + stop
+	}
+	stop = min(stop, n-1)
+	if start > stop {
+		return nil, nil
+	}
+	return append([]string(nil), list[start:stop+1]...), nil
+}
+
+// HSet sets field of the hash at key to value, creating the hash if it is
+// missing, and reports whether the field is new
+func (s *Store) HSet(key, field, value string) (bool, error) {
+	if err := s.makeRoom(); err != nil {
+		return false, err
+	}
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	switch s.liveTypeLocked(sh, key) {
+	case TypeNone, TypeHash:
+	default:
+		return false, ErrWrongType
+	}
+	_, existed := sh.hashes[key][field]
+	cmd := Command{Op: opHSet, Key: key, Field: field, Value: value}
+	s.applyLocked(sh, cmd)
+	s.record(sh, cmd)
+	return !existed, nil
+}
+
+// HGet returns field of the hash at key
+func (s *Store) HGet(key, field string) (string, bool, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	expired := s.expiredLocked(sh, key)
+	var (
+		value string
+		found bool
+		err   error
+	)
+	if !expired {
+		switch sh.typeLocked(key) {
+		case TypeNone:
+		case TypeHash:
+			value, found = sh.hashes[key][field]
+			s.accessedLocked(sh, key)
+		default:
+			err = ErrWrongType
+		}
+	}
+	sh.mu.RUnlock()
+	if expired {
+		s.expire(key)
+	}
+	return value, found, err
+}
+
+// HGetAll returns a copy of the hash at key, or nil if it is missing
+func (s *Store) HGetAll(key string) (map[string]string, error) {
+	sh := s.shardFor(key)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	if s.expiredLocked(sh, key) {
+		return nil, nil
+	}
+	switch sh.typeLocked(key) {
+	case TypeNone:
+		return nil, nil
+	case TypeHash:
+		s.accessedLocked(sh, key)
+		return maps.Clone(sh.hashes[key]), nil
+	}
+	return nil, ErrWrongType
+}
+
+// Incr adds one to the integer at key and returns the result
+func (s *Store) Incr(key string) (int64, error) {
+	return s.IncrBy(key, 1)
+}
+
+// Decr subtracts one from the integer at key and returns the result
+func (s *Store) Decr(key string) (int64, error) {
+	return s.IncrBy(key, -1)
+}
+
+// IncrBy adds delta to the integer at key atomically and returns the
+// result. A missing key counts as 0. The key keeps its TTL. The result is
+// replicated and logged as a SET of the new value, so replaying it twice
+// does not count twice.
+func (s *Store) IncrBy(key string, delta int64) (int64, error) {
+	if err := s.makeRoom(); err != nil {
+		return 0, err
+	}
+	sh := s.shardFor(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	var n int64
+	switch s.liveTypeLocked(sh, key) {
+	case TypeNone:
+	case TypeString:
+		var err error
+		if n, err = strconv.ParseInt(sh.data[key], 10, 64); err != nil {
+			return 0, ErrNotInteger
+		}
+	default:
+		return 0, ErrWrongType
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, ErrNotInteger
+	}
+	n += delta
+
+	cmd := Command{Op: opSet, Key: key, Value: strconv.FormatInt(n, 10), ExpiresAt: sh.expires[key]}
+	s.applyLocked(sh, cmd)
+	s.record(sh, cmd)
+	return n, nil
+}
+
+// parseRange parses the start and stop of LRANGE
+func parseRange(start, stop string) (int, int, error) {
+	a, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid index %q", start)
+	}
+	b, err := strconv.Atoi(stop)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid index %q", stop)
+	}
+	return a, b, nil
+}
+
+// formatList renders a list as the REPL prints it, one numbered element per
+// line, or (empty list)
+func formatList(values []string) string {
+	if len(values) == 0 {
+		return "(empty list)\n"
+	}
+	var b strings.Builder
+	for i, v := range values {
+		fmt.Fprintf(&b, "%d) %s\n", i+1, v)
+	}
+	return b.String()
+}
+
+// copyTyped returns deep copies of the lists and hashes that have not
+// expired, with their expiry times added to expires, for a snapshot or a
+// full sync. Caller holds every shard.
+func (s *Store) copyTyped(expires map[string]time.Time) (map[string][]string, map[string]map[string]string) {
+	var lists map[string][]string
+	var hashes map[string]map[string]string
+	for _, sh := range s.shards {
+		for k, l := range sh.lists {
+			if s.expiredLocked(sh, k) {
+				continue
+			}
+			if lists == nil {
+				lists = make(map[string][]string)
+			}
+			lists[k] = append([]string(nil), l...)
+			if at, ok := sh.expires[k]; ok {
+				expires[k] = at
+			}
+		}
+		for k, h := range sh.hashes {
+			if s.expiredLocked(sh, k) {
+				continue
+			}
+			if hashes == nil {
+				hashes = make(map[string]map[string]string)
+			}
+			hashes[k] = maps.Clone(h)
+			if at, ok := sh.expires[k]; ok {
+				expires[k] = at
+			}
+		}
+	}
+	return lists, hashes
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const (
+	// defaultMaxBodyBytes caps how much of a response body is read
+	defaultMaxBodyBytes = 10 << 20
+
+	// titleScanBytes is how much of the body is kept for title extraction;
+	// the rest is counted and discarded
+	titleScanBytes = 64 << 10
+)
+
+// defaultSkipTypes are media type prefixes that are never downloaded
+var defaultSkipTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/",
+	"application/octet-stream",
+	"application/pdf",
+	"application/zip",
+	"application/gzip",
+}
+
+// FetchRules decide which responses are worth downloading
+type FetchRules struct {
+	// MaxBodyBytes skips responses whose Content-Length exceeds it and
+	// stops reading bodies of unknown length after it. Zero means no limit.
+	MaxBodyBytes int64
+	// SkipTypes are Content-Type prefixes whose bodies are not read
+	SkipTypes []string
+	// HeadFirst sends a HEAD request before GET so skipped resources are
+	// never requested in full
+	HeadFirst bool
+}
+
+// DefaultFetchRules returns rules that skip common binary types and cap
+// bodies at 10MB
+func DefaultFetchRules() FetchRules {
+	return FetchRules{
+		MaxBodyBytes: defaultMaxBodyBytes,
+		SkipTypes:    defaultSkipTypes,
+	}
+}
+
+// skipReason returns why a response with these headers should not be read,
+// or "" if it should
+func (r FetchRules) skipReason(header http.Header, contentLength int64) string {
+	if contentType := header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			mediaType = strings.ToLower(strings.TrimSpace(contentType))
+		}
+		for _, prefix := range r.SkipTypes {
+			if strings.HasPrefix(mediaType, prefix) {
+				return fmt.Sprintf("content type %s", mediaType)
+			}
+		}
+	}
+	if r.MaxBodyBytes > 0 && contentLength > r.MaxBodyBytes {
+		return fmt.Sprintf("content length %d exceeds %d bytes", contentLength, r.MaxBodyBytes)
+	}
+	return ""
+}
+
+// prefixWriter keeps the first limit bytes written to it and counts the rest
+type prefixWriter struct {
+	buf   []byte
+	limit int
+	n     int64
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	if room := w.limit - len(w.buf); room > 0 {
+		w.buf = append(w.buf, p[:min(room, len(p))]...)
+	}
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// readBody streams body without buffering it whole. It returns the leading
+// bytes for title extraction, the number of bytes read, and whether the
+// body was cut off at maxBytes.
+func readBody(body io.Reader, maxBytes int64) (prefix []byte, n int64, truncated bool, err error) {
+	w := &prefixWriter{limit: titleScanBytes}
+	if maxBytes > 0 {
+		body = io.LimitReader(body, maxBytes+1)
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		return w.buf, w.n, false, err
+	}
+	if maxBytes > 0 && w.n > maxBytes {
+		return w.buf, maxBytes, true, nil
+	}
+	return w.buf, w.n, false, nil
+}
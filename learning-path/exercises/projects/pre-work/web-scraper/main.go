@@ -5,7 +5,6 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
@@ -21,63 +20,91 @@ type Config struct {
 	Delay      time.Duration
 	Timeout    time.Duration
 	OutputFile string
+	Rules      FetchRules
+	// Sink, if set, receives each result as it is collected
+	Sink Sink
 }
 
 // Result represents a scraping result
 type Result struct {
-	URL        string  `json:"url"`
-	StatusCode int     `json:"status_code,omitempty"`
-	Title      string  `json:"title,omitempty"`
-	SizeBytes  int     `json:"size_bytes,omitempty"`
-	DurationMS int64   `json:"duration_ms,omitempty"`
-	Error      string  `json:"error,omitempty"`
+	URL         string `json:"url"`
+	StatusCode  int    `json:"status_code,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Title       string `json:"title,omitempty"`
+	SizeBytes   int    `json:"size_bytes,omitempty"`
+	Truncated   bool   `json:"truncated,omitempty"`
+	Skipped     string `json:"skipped,omitempty"`
+	DurationMS  int64  `json:"duration_ms,omitempty"`
+	Error       string `json:"error,omitempty"`
 }
 
 // Summary represents the overall scraping summary
 type Summary struct {
-	TotalURLs       int       `json:"total_urls"`
-	Successful      int       `json:"successful"`
-	Failed          int       `json:"failed"`
-	DurationSeconds float64   `json:"duration_seconds"`
-	Results         []Result  `json:"results"`
+	TotalURLs       int      `json:"total_urls"`
+	Successful      int      `json:"successful"`
+	Failed          int      `json:"failed"`
+	Skipped         int      `json:"skipped"`
+	DurationSeconds float64  `json:"duration_seconds"`
+	Results         []Result `json:"results"`
 }
 
 func main() {
-	config := parseFlags()
+	config, sinkSpec, sinkOpts := parseFlags()
+
+	sink, err := OpenSink(sinkSpec, config.OutputFile, sinkOpts)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	config.Sink = sink
 
 	startTime := time.Now()
 	results, err := scrape(config)
 	if err != nil {
+		sink.Close(nil)
 		log.Fatalf("Error: %v", err)
 	}
 
 	summary := createSummary(results, time.Since(startTime))
-	if err := writeSummary(config.OutputFile, summary); err != nil {
-		log.Fatalf("Error writing summary: %v", err)
+	if err := sink.Close(summary); err != nil {
+		log.Fatalf("Error writing results: %v", err)
 	}
 
 	fmt.Printf("Scraped %d URLs in %.2f seconds\n", summary.TotalURLs, summary.DurationSeconds)
-	fmt.Printf("Successful: %d, Failed: %d\n", summary.Successful, summary.Failed)
+	fmt.Printf("Successful: %d, Failed: %d, Skipped: %d\n", summary.Successful, summary.Failed, summary.Skipped)
 }
 
-func parseFlags() *Config {
+func parseFlags() (*Config, string, SinkOptions) {
 	config := &Config{}
+	var skipTypes, sinkSpec string
+	var sinkOpts SinkOptions
 
 	flag.StringVar(&config.URLsFile, "urls", "", "File containing URLs to scrape (required)")
 	flag.IntVar(&config.Workers, "workers", 5, "Number of worker goroutines")
 	flag.DurationVar(&config.Delay, "delay", 0, "Delay between requests per worker")
 	flag.DurationVar(&config.Timeout, "timeout", 30*time.Second, "HTTP request timeout")
-	flag.StringVar(&config.OutputFile, "output", "results.json", "Output JSON file")
+	flag.StringVar(&config.OutputFile, "output", "results.json", "Output file of the json and jsonl sinks (- for stdout)")
+	flag.StringVar(&sinkSpec, "sink", "json", "Where results go: json, jsonl, kv:ADDR (kv-store -listen address) or webhook:URL")
+	flag.IntVar(&sinkOpts.BatchSize, "sink-batch", 50, "Results per webhook request or kv-store round trip")
+	flag.IntVar(&sinkOpts.Retries, "sink-retries", 3, "Retries of a failed webhook request (-1 for none)")
+	flag.Int64Var(&config.Rules.MaxBodyBytes, "max-bytes", defaultMaxBodyBytes, "Maximum response body bytes to read (0 for no limit)")
+	flag.StringVar(&skipTypes, "skip-types", strings.Join(defaultSkipTypes, ","), "Comma-separated Content-Type prefixes to skip")
+	flag.BoolVar(&config.Rules.HeadFirst, "head", false, "Send HEAD before GET to avoid downloading skipped resources")
 
 	flag.Parse()
 
+	for _, t := range strings.Split(skipTypes, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			config.Rules.SkipTypes = append(config.Rules.SkipTypes, t)
+		}
+	}
+
 	if config.URLsFile == "" {
 		fmt.Fprintln(os.Stderr, "Error: -urls flag is required")
 		flag.Usage()
 		os.Exit(1)
 	}
 
-	return config
+	return config, sinkSpec, sinkOpts
 }
 
 // scrape orchestrates the concurrent scraping process
@@ -100,7 +127,7 @@ func scrape(config *Config) ([]Result, error) {
 	var wg sync.WaitGroup
 	for i := 0; i < config.Workers; i++ {
 		wg.Add(1)
-		go worker(ctx, i, jobs, results, config.Delay, config.Timeout, &wg)
+		go worker(ctx, i, jobs, results, config, &wg)
 	}
 
 	// Send jobs
@@ -117,38 +144,60 @@ func scrape(config *Config) ([]Result, error) {
 		close(results)
 	}()
 
-	// Collect results
+	// Collect results, passing them on to the sink as they arrive. A sink
+	// failure does not stop the scrape; it is reported at the end.
 	var allResults []Result
+	var sinkErr error
 	for result := range results {
 		allResults = append(allResults, result)
+		if config.Sink != nil && sinkErr == nil {
+			sinkErr = config.Sink.Write(result)
+		}
+	}
+	if sinkErr != nil {
+		return allResults, fmt.Errorf("writing result: %w", sinkErr)
 	}
 
 	return allResults, nil
 }
 
 // worker processes URLs from the jobs channel
-func worker(ctx context.Context, id int, jobs <-chan string, results chan<- Result, delay time.Duration, timeout time.Duration, wg *sync.WaitGroup) {
+func worker(ctx context.Context, id int, jobs <-chan string, results chan<- Result, config *Config, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	client := &http.Client{
-		Timeout: timeout,
+		Timeout: config.Timeout,
 	}
 
 	for url := range jobs {
 		// Apply delay for rate limiting
-		if delay > 0 {
-			time.Sleep(delay)
+		if config.Delay > 0 {
+			time.Sleep(config.Delay)
 		}
 
-		result := fetchURL(ctx, client, url)
+		result := fetchURLWithRules(ctx, client, url, config.Rules)
 		results <- result
 	}
 }
 
-// fetchURL fetches a single URL and extracts information
+// fetchURL fetches a single URL with the default fetch rules
 func fetchURL(ctx context.Context, client *http.Client, url string) Result {
+	return fetchURLWithRules(ctx, client, url, DefaultFetchRules())
+}
+
+// fetchURLWithRules fetches a single URL and extracts information. Headers
+// are checked against rules before the body is read, and bodies are
+// streamed rather than buffered whole.
+func fetchURLWithRules(ctx context.Context, client *http.Client, url string, rules FetchRules) Result {
 	startTime := time.Now()
 
+	if rules.HeadFirst {
+		if result, skipped := probeURL(ctx, client, url, rules); skipped {
+			result.DurationMS = time.Since(startTime).Milliseconds()
+			return result
+		}
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return Result{URL: url, Error: err.Error()}
@@ -160,26 +209,59 @@ func fetchURL(ctx context.Context, client *http.Client, url string) Result {
 	}
 	defer resp.Body.Close()
 
-	// Read body
-	body, err := io.ReadAll(resp.Body)
+	result := Result{
+		URL:         url,
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+
+	// Closing the body without reading it drops the connection instead of
+	// downloading the rest
+	if reason := rules.skipReason(resp.Header, resp.ContentLength); reason != "" {
+		result.Skipped = reason
+		result.DurationMS = time.Since(startTime).Milliseconds()
+		return result
+	}
+
+	prefix, n, truncated, err := readBody(resp.Body, rules.MaxBodyBytes)
 	if err != nil {
-		return Result{
-			URL:        url,
-			StatusCode: resp.StatusCode,
-			Error:      fmt.Sprintf("reading body: %v", err),
-		}
+		result.Error = fmt.Sprintf("reading body: %v", err)
+		return result
 	}
 
-	// Extract title
-	title := extractTitle(string(body))
+	result.Title = extractTitle(string(prefix))
+	result.SizeBytes = int(n)
+	result.Truncated = truncated
+	result.DurationMS = time.Since(startTime).Milliseconds()
+	return result
+}
 
-	return Result{
-		URL:        url,
-		StatusCode: resp.StatusCode,
-		Title:      title,
-		SizeBytes:  len(body),
-		DurationMS: time.Since(startTime).Milliseconds(),
+// probeURL sends a HEAD request and reports whether the resource should be
+// skipped. Servers that reject HEAD are not skipped; the GET decides.
+func probeURL(ctx context.Context, client *http.Client, url string, rules FetchRules) (Result, bool) {
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return Result{}, false
 	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, false
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return Result{}, false
+	}
+	reason := rules.skipReason(resp.Header, resp.ContentLength)
+	if reason == "" {
+		return Result{}, false
+	}
+	return Result{
+		URL:         url,
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Skipped:     reason,
+	}, true
 }
 
 // extractTitle extracts the page title from HTML
@@ -228,10 +310,13 @@ func createSummary(results []Result, duration time.Duration) *Summary {
 	}
 
 	for _, r := range results {
-		if r.Error == "" {
-			summary.Successful++
-		} else {
+		switch {
+		case r.Error != "":
 			summary.Failed++
+		case r.Skipped != "":
+			summary.Skipped++
+		default:
+			summary.Successful++
 		}
 	}
 
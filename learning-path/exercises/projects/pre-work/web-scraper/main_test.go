@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -70,6 +75,84 @@ func TestFetchURL(t *testing.T) {
 	}
 }
 
+func TestFetchURLRules(t *testing.T) {
+	var gets, heads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+		} else {
+			gets++
+		}
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, 1024))
+		case "/large":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Length", "4096")
+			w.Write(make([]byte, 4096))
+		case "/stream":
+			// No Content-Length: the body is chunked
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<title>Streamed</title>")
+			w.(http.Flusher).Flush()
+			w.Write(make([]byte, 4096))
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprint(w, "<title>Page</title>")
+		}
+	}))
+	defer server.Close()
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	rules := FetchRules{MaxBodyBytes: 1024, SkipTypes: []string{"image/"}}
+
+	tests := []struct {
+		path          string
+		wantSkipped   bool
+		wantTruncated bool
+		wantTitle     string
+		wantSize      int
+	}{
+		{path: "/image", wantSkipped: true},
+		{path: "/large", wantSkipped: true},
+		{path: "/stream", wantTruncated: true, wantTitle: "Streamed", wantSize: 1024},
+		{path: "/page", wantTitle: "Page", wantSize: len("<title>Page</title>")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			result := fetchURLWithRules(context.Background(), client, server.URL+tt.path, rules)
+			if result.Error != "" {
+				t.Fatalf("unexpected error: %s", result.Error)
+			}
+			if (result.Skipped != "") != tt.wantSkipped {
+				t.Errorf("Skipped = %q, want skipped %v", result.Skipped, tt.wantSkipped)
+			}
+			if result.Truncated != tt.wantTruncated {
+				t.Errorf("Truncated = %v, want %v", result.Truncated, tt.wantTruncated)
+			}
+			if result.Title != tt.wantTitle {
+				t.Errorf("Title = %q, want %q", result.Title, tt.wantTitle)
+			}
+			if result.SizeBytes != tt.wantSize {
+				t.Errorf("SizeBytes = %d, want %d", result.SizeBytes, tt.wantSize)
+			}
+		})
+	}
+
+	// With HeadFirst a skipped resource is never fetched with GET
+	gets, heads = 0, 0
+	rules.HeadFirst = true
+	result := fetchURLWithRules(context.Background(), client, server.URL+"/image", rules)
+	if result.Skipped == "" || gets != 0 || heads != 1 {
+		t.Errorf("HEAD-first skip: skipped=%q gets=%d heads=%d", result.Skipped, gets, heads)
+	}
+	result = fetchURLWithRules(context.Background(), client, server.URL+"/page", rules)
+	if result.Title != "Page" || gets != 1 || heads != 2 {
+		t.Errorf("HEAD-first fetch: title=%q gets=%d heads=%d", result.Title, gets, heads)
+	}
+}
+
 func TestExtractTitle(t *testing.T) {
 	tests := []struct {
 		name string
@@ -228,6 +311,143 @@ func TestContextCancellation(t *testing.T) {
 	}
 }
 
+func TestJSONLSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	sink, err := OpenSink("jsonl", path, SinkOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range []Result{{URL: "http://a", StatusCode: 200}, {URL: "http://b", Error: "boom"}} {
+		if err := sink.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each result is on disk as soon as it is written
+	if data, _ := os.ReadFile(path); strings.Count(string(data), "\n") != 2 {
+		t.Errorf("file before Close = %q", data)
+	}
+	if err := sink.Close(&Summary{}); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if want := `{"url":"http://a","status_code":200}` + "\n" + `{"url":"http://b","error":"boom"}` + "\n"; string(data) != want {
+		t.Errorf("file = %q, want %q", data, want)
+	}
+}
+
+func TestKVSink(t *testing.T) {
+	// A stand-in for kvstore -listen that records the batches it gets
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	batches := make(chan []kvOp, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		dec, enc := json.NewDecoder(conn), json.NewEncoder(conn)
+		for {
+			var req kvRequest
+			if dec.Decode(&req) != nil {
+				close(batches)
+				return
+			}
+			batches <- req.Ops
+			enc.Encode(map[string]any{"results": make([]map[string]any, len(req.Ops))})
+		}
+	}()
+
+	sink, err := OpenSink("kv:"+listener.Addr().String(), "", SinkOptions{BatchSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{"http://a", "http://b", "http://c"} {
+		if err := sink.Write(Result{URL: url, StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(&Summary{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+	for ops := range batches {
+		sizes = append(sizes, len(ops))
+		for _, op := range ops {
+			var r Result
+			if op.Op != "SET" || json.Unmarshal([]byte(op.Value), &r) != nil || r.URL != op.Key {
+				t.Errorf("op = %+v", op)
+			}
+		}
+	}
+	if fmt.Sprint(sizes) != "[2 1]" {
+		t.Errorf("batch sizes = %v, want [2 1]", sizes)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	var mu sync.Mutex
+	var requests, delivered int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var batch []Result
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delivered += len(batch)
+	}))
+	defer server.Close()
+
+	sink, err := OpenSink("webhook:"+server.URL, "", SinkOptions{BatchSize: 3, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 7 {
+		if err := sink.Write(Result{URL: fmt.Sprintf("http://%d", i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(&Summary{}); err != nil {
+		t.Fatal(err)
+	}
+	// Three batches, the first retried once
+	if requests != 4 || delivered != 7 {
+		t.Errorf("%d requests delivered %d results, want 4 and 7", requests, delivered)
+	}
+
+	// Client errors are not retried
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	requests = 0
+	sink, _ = OpenSink("webhook:"+rejecting.URL, "", SinkOptions{Backoff: time.Millisecond})
+	sink.Write(Result{URL: "http://a"})
+	if err := sink.Close(&Summary{}); err == nil || requests != 1 {
+		t.Errorf("Close() = %v after %d requests, want an error after 1", err, requests)
+	}
+
+	for _, spec := range []string{"xml", "kv:", "webhook:ftp://x"} {
+		if _, err := OpenSink(spec, "out", SinkOptions{}); err == nil {
+			t.Errorf("OpenSink(%q) succeeded", spec)
+		}
+	}
+}
+
 // Benchmarks
 func BenchmarkFetchURL(b *testing.B) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Sink receives results as workers produce them, then the summary once the
+// scrape is done
+type Sink interface {
+	// Write delivers one result
+	Write(result Result) error
+	// Close delivers the summary and releases the sink. Sinks that batch
+	// write what they still hold first.
+	Close(summary *Summary) error
+}
+
+// SinkOptions configures the sinks that batch results
+type SinkOptions struct {
+	// BatchSize is how many results go in one webhook request or KV store
+	// round trip (default 50)
+	BatchSize int
+	// Retries is how many times a failed webhook request is retried
+	// (default 3, negative for none)
+	Retries int
+	// Backoff is the delay before the first retry, doubled for each one
+	// after it (default 500ms)
+	Backoff time.Duration
+	// Timeout bounds each webhook request and KV store round trip
+	// (default 10s)
+	Timeout time.Duration
+}
+
+// withDefaults fills in zero-valued options
+func (o SinkOptions) withDefaults() SinkOptions {
+	if o.BatchSize <= 0 {
+		o.BatchSize = 50
+	}
+	switch {
+	case o.Retries < 0:
+		o.Retries = 0
+	case o.Retries == 0:
+		o.Retries = 3
+	}
+	if o.Backoff <= 0 {
+		o.Backoff = 500 * time.Millisecond
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second
+	}
+	return o
+}
+
+// OpenSink opens the sink named by spec:
+//
+//	json            the summary, results included, as one JSON document at output
+//	jsonl           one JSON result per line at output, written as they arrive
+//	kv:ADDR         each result as JSON under its URL in the kv-store at ADDR
+//	webhook:URL     results POSTed to URL as JSON arrays in batches
+//
+// An output of "-" is standard output.
+func OpenSink(spec, output string, opts SinkOptions) (Sink, error) {
+	opts = opts.withDefaults()
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "json":
+		return &jsonSink{filename: output}, nil
+	case "jsonl":
+		return newJSONLSink(output)
+	case "kv":
+		if target == "" {
+			return nil, errors.New("sink kv: missing address, want kv:HOST:PORT")
+		}
+		return dialKVSink(target, opts)
+	case "webhook":
+		if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+			return nil, fmt.Errorf("sink webhook: want an http or https URL, got %q", target)
+		}
+		return newWebhookSink(target, opts), nil
+	}
+	return nil, fmt.Errorf("unknown sink %q: want json, jsonl, kv:ADDR or webhook:URL", spec)
+}
+
+// jsonSink writes the summary as one JSON document when closed, like the
+// scraper always has
+type jsonSink struct {
+	filename string
+}
+
+func (s *jsonSink) Write(Result) error { return nil }
+
+func (s *jsonSink) Close(summary *Summary) error {
+	if summary == nil {
+		return nil
+	}
+	if s.filename == "-" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(summary)
+	}
+	return writeSummary(s.filename, summary)
+}
+
+// jsonlSink streams results as JSON lines, so a long scrape can be
+// followed, or consumed, while it runs
+type jsonlSink struct {
+	file *os.File // nil for standard output
+	w    *bufio.Writer
+	enc  *json.Encoder
+}
+
+func newJSONLSink(filename string) (*jsonlSink, error) {
+	s := &jsonlSink{}
+	out := io.Writer(os.Stdout)
+	if filename != "-" {
+		f, err := os.Create(filename)
+		if err != nil {
+			return nil, fmt.Errorf("sink jsonl: %w", err)
+		}
+		s.file, out = f, f
+	}
+	s.w = bufio.NewWriter(out)
+	s.enc = json.NewEncoder(s.w)
+	return s, nil
+}
+
+// Write encodes the result and flushes it, so each line is complete as
+// soon as its result is
+func (s *jsonlSink) Write(result Result) error {
+	if err := s.enc.Encode(result); err != nil {
+		return err
+	}
+	return s.w.Flush()
+}
+
+func (s *jsonlSink) Close(*Summary) error {
+	err := s.w.Flush()
+	if s.file != nil {
+		if closeErr := s.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// kvSink stores each result under its URL in the pre-work kv-store, through
+// its JSON-lines protocol (kvstore -listen): every request is a batch of
+// operations, executed atomically and answered in order
+type kvSink struct {
+	conn    net.Conn
+	enc     *json.Encoder
+	dec     *json.Decoder
+	opts    SinkOptions
+	pending []kvOp
+}
+
+type kvOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Value string `json:"value,omitempty"`
+}
+
+type kvRequest struct {
+	Ops []kvOp `json:"ops"`
+}
+
+type kvResponse struct {
+	Error string `json:"error,omitempty"`
+}
+
+func dialKVSink(addr string, opts SinkOptions) (*kvSink, error) {
+	conn, err := net.DialTimeout("tcp", addr, opts.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("sink kv: %w", err)
+	}
+	return &kvSink{
+		conn: conn,
+		enc:  json.NewEncoder(conn),
+		dec:  json.NewDecoder(bufio.NewReader(conn)),
+		opts: opts,
+	}, nil
+}
+
+func (s *kvSink) Write(result Result) error {
+	value, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	s.pending = append(s.pending, kvOp{Op: "SET", Key: result.URL, Value: string(value)})
+	if len(s.pending) >= s.opts.BatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// flush sends the pending SETs as one batch
+func (s *kvSink) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	s.conn.SetDeadline(time.Now().Add(s.opts.Timeout))
+	if err := s.enc.Encode(kvRequest{Ops: s.pending}); err != nil {
+		return fmt.Errorf("sink kv: %w", err)
+	}
+	var resp kvResponse
+	if err := s.dec.Decode(&resp); err != nil {
+		return fmt.Errorf("sink kv: %w", err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("sink kv: %s", resp.Error)
+	}
+	s.pending = s.pending[:0]
+	return nil
+}
+
+func (s *kvSink) Close(*Summary) error {
+	err := s.flush()
+	if closeErr := s.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// webhookSink POSTs results to a URL as JSON arrays of up to BatchSize.
+// Requests that fail with a network error, 429 or a 5xx status are retried
+// with exponential backoff; other statuses fail at once.
+type webhookSink struct {
+	url     string
+	client  *http.Client
+	opts    SinkOptions
+	pending []Result
+}
+
+func newWebhookSink(url string, opts SinkOptions) *webhookSink {
+	return &webhookSink{url: url, client: &http.Client{Timeout: opts.Timeout}, opts: opts}
+}
+
+func (s *webhookSink) Write(result Result) error {
+	s.pending = append(s.pending, result)
+	if len(s.pending) >= s.opts.BatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+func (s *webhookSink) flush() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(s.pending)
+	if err != nil {
+		return err
+	}
+	backoff := s.opts.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(body)
+		if err == nil {
+			s.pending = s.pending[:0]
+			return nil
+		}
+		if !retry || attempt == s.opts.Retries {
+			return fmt.Errorf("sink webhook: %w", err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends one batch and reports whether a failure is worth retrying
+func (s *webhookSink) post(body []byte) (retry bool, err error) {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s: %s", s.url, resp.Status)
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+func (s *webhookSink) Close(*Summary) error {
+	return s.flush()
+}